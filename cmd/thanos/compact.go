@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"os"
 	"path"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -26,7 +25,6 @@ import (
 	"github.com/prometheus/common/route"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb"
-	"github.com/thanos-io/objstore/client"
 
 	blocksAPI "github.com/thanos-io/thanos/pkg/api/blocks"
 	"github.com/thanos-io/thanos/pkg/block"
@@ -35,11 +33,15 @@ import (
 	"github.com/thanos-io/thanos/pkg/compact/downsample"
 	"github.com/thanos-io/thanos/pkg/component"
 	"github.com/thanos-io/thanos/pkg/dedup"
+	"github.com/thanos-io/thanos/pkg/diagnostics"
 	"github.com/thanos-io/thanos/pkg/extkingpin"
+	"github.com/thanos-io/thanos/pkg/extobjstore"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
 	"github.com/thanos-io/thanos/pkg/logging"
+	"github.com/thanos-io/thanos/pkg/memlimit"
 	"github.com/thanos-io/thanos/pkg/prober"
+	"github.com/thanos-io/thanos/pkg/runtimeconfig"
 	"github.com/thanos-io/thanos/pkg/runutil"
 	httpserver "github.com/thanos-io/thanos/pkg/server/http"
 	"github.com/thanos-io/thanos/pkg/store"
@@ -48,13 +50,7 @@ import (
 )
 
 var (
-	compactions = compactionSet{
-		1 * time.Hour,
-		2 * time.Hour,
-		8 * time.Hour,
-		2 * 24 * time.Hour,
-		14 * 24 * time.Hour,
-	}
+	compactions = compactionSet(compact.DefaultRanges)
 )
 
 type compactionSet []time.Duration
@@ -85,13 +81,34 @@ func (cs compactionSet) maxLevel() int {
 	return len(cs) - 1
 }
 
-func registerCompact(app *extkingpin.App) {
+// parseCompactionSet parses compaction ranges given as durations.
+func parseCompactionSet(ranges []string) (compactionSet, error) {
+	cs := make(compactionSet, 0, len(ranges))
+	levels := make([]int64, 0, len(ranges))
+	for _, r := range ranges {
+		d, err := model.ParseDuration(r)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse compaction range %q", r)
+		}
+		cs = append(cs, time.Duration(d))
+		levels = append(levels, time.Duration(d).Milliseconds())
+	}
+	if err := compact.ValidateRanges(levels); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+func registerCompact(app *extkingpin.App, runtimeConfig *runtimeconfig.Manager, memLimit *memlimit.Flags, diagnosticsConfig *diagnostics.Flags) {
 	cmd := app.Command(component.Compact.String(), "Continuously compacts blocks in an object store bucket.")
+	runtimeConfig.RegisterFlags(cmd)
+	memLimit.RegisterFlags(cmd)
+	diagnosticsConfig.RegisterFlags(cmd)
 	conf := &compactConfig{}
 	conf.registerFlag(cmd)
 
 	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, _ <-chan struct{}, _ bool) error {
-		return runCompact(g, logger, tracer, reg, component.Compact, *conf, getFlagsMap(cmd.Flags()))
+		return runCompact(g, logger, tracer, reg, component.Compact, *conf, getFlagsMap(cmd.Flags()), runtimeConfig)
 	})
 }
 
@@ -103,6 +120,7 @@ type compactMetrics struct {
 	partialUploadDeleteAttempts prometheus.Counter
 	blocksCleaned               prometheus.Counter
 	blockCleanupFailures        prometheus.Counter
+	orphanedDataReclaimedBytes  prometheus.Counter
 	blocksMarked                *prometheus.CounterVec
 	garbageCollectedBlocks      prometheus.Counter
 }
@@ -146,6 +164,10 @@ func newCompactMetrics(reg *prometheus.Registry, deleteDelay time.Duration) *com
 		Name: "thanos_compact_block_cleanup_failures_total",
 		Help: "Failures encountered while deleting blocks in compactor.",
 	})
+	m.orphanedDataReclaimedBytes = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_compact_orphaned_block_data_reclaimed_bytes_total",
+		Help: "Total number of bytes reclaimed by deleting data of blocks without meta.json left over by aborted uploads or interrupted deletions.",
+	})
 	m.blocksMarked = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
 		Name: "thanos_compact_blocks_marked_total",
 		Help: "Total number of blocks marked in compactor.",
@@ -169,6 +191,7 @@ func runCompact(
 	component component.Component,
 	conf compactConfig,
 	flagsMap map[string]string,
+	runtimeConfig *runtimeconfig.Manager,
 ) (rerr error) {
 	deleteDelay := time.Duration(conf.deleteDelay)
 	compactMetrics := newCompactMetrics(reg, deleteDelay)
@@ -180,11 +203,21 @@ func runCompact(
 		prober.NewInstrumentation(component, logger, extprom.WrapRegistererWithPrefix("thanos_", reg)),
 	)
 
+	httpAuth, err := newHTTPAuthenticator(logger, conf.http.authConfig)
+	if err != nil {
+		return err
+	}
 	srv := httpserver.New(logger, reg, component, httpProbe,
 		httpserver.WithListen(conf.http.bindAddress),
 		httpserver.WithGracePeriod(time.Duration(conf.http.gracePeriod)),
 		httpserver.WithTLSConfig(conf.http.tlsConfig),
+		httpserver.WithAuthenticator(httpAuth),
 	)
+	srv.Handle(runtimeconfig.APIPath, runtimeConfig)
+	deps, err := setupDependencies(g, logger, reg, &conf.health, httpProbe)
+	if err != nil {
+		return err
+	}
 
 	g.Add(func() error {
 		statusProber.Healthy()
@@ -202,10 +235,11 @@ func runCompact(
 		return err
 	}
 
-	bkt, err := client.NewBucket(logger, confContentYaml, reg, component.String())
+	bkt, err := extobjstore.NewBucket(logger, confContentYaml, reg, component.String())
 	if err != nil {
 		return err
 	}
+	deps.Register("objstore", objStoreCheck(bkt))
 
 	relabelContentYaml, err := conf.selectorRelabelConf.Content()
 	if err != nil {
@@ -217,6 +251,26 @@ func runCompact(
 		return err
 	}
 
+	deleteDelayContentYaml, err := conf.deleteDelayConf.Content()
+	if err != nil {
+		return errors.Wrap(err, "get content of delete delay configuration")
+	}
+
+	deleteDelayPolicy, err := compact.ParseDeleteDelayPolicy(deleteDelayContentYaml, deleteDelay)
+	if err != nil {
+		return err
+	}
+
+	storageClassContentYaml, err := conf.storageClassConf.Content()
+	if err != nil {
+		return errors.Wrap(err, "get content of storage class configuration")
+	}
+
+	storageClassPolicy, err := compact.ParseStorageClassPolicy(storageClassContentYaml)
+	if err != nil {
+		return err
+	}
+
 	// Ensure we close up everything properly.
 	defer func() {
 		if err != nil {
@@ -227,11 +281,13 @@ func runCompact(
 	// While fetching blocks, we filter out blocks that were marked for deletion by using IgnoreDeletionMarkFilter.
 	// The delay of deleteDelay/2 is added to ensure we fetch blocks that are meant to be deleted but do not have a replacement yet.
 	// This is to make sure compactor will not accidentally perform compactions with gap instead.
-	ignoreDeletionMarkFilter := block.NewIgnoreDeletionMarkFilter(logger, bkt, deleteDelay/2, conf.blockMetaFetchConcurrency)
+	// With delete delay overrides, the shortest delay is used so that no block is deleted while still being considered for compaction.
+	ignoreDeletionMarkFilter := block.NewIgnoreDeletionMarkFilter(logger, bkt, deleteDelayPolicy.MinDeleteDelay()/2, conf.blockMetaFetchConcurrency)
 	duplicateBlocksFilter := block.NewDeduplicateFilter(conf.blockMetaFetchConcurrency)
 	noCompactMarkerFilter := compact.NewGatherNoCompactionMarkFilter(logger, bkt, conf.blockMetaFetchConcurrency)
 	labelShardedMetaFilter := block.NewLabelShardedMetaFilter(relabelConfig)
 	consistencyDelayMetaFilter := block.NewConsistencyDelayMetaFilter(logger, conf.consistencyDelay, extprom.WrapRegistererWithPrefix("thanos_", reg))
+	uploadGracePeriodMetaFilter := block.NewUploadGracePeriodMetaFilter(logger, bkt, conf.uploadGracePeriod, conf.blockMetaFetchConcurrency, extprom.WrapRegistererWithPrefix("thanos_", reg))
 	timePartitionMetaFilter := block.NewTimePartitionMetaFilter(conf.filterConf.MinTime, conf.filterConf.MaxTime)
 
 	baseMetaFetcher, err := block.NewBaseFetcher(logger, conf.blockMetaFetchConcurrency, bkt, conf.dataDir, extprom.WrapRegistererWithPrefix("thanos_", reg))
@@ -262,6 +318,7 @@ func runCompact(
 				timePartitionMetaFilter,
 				labelShardedMetaFilter,
 				consistencyDelayMetaFilter,
+				uploadGracePeriodMetaFilter,
 				ignoreDeletionMarkFilter,
 				block.NewReplicaLabelRemover(logger, conf.dedupReplicaLabels),
 				duplicateBlocksFilter,
@@ -286,13 +343,29 @@ func runCompact(
 		}
 	}
 
-	levels, err := compactions.levels(conf.maxCompactionLevel)
+	compactionRanges := compactions
+	if len(conf.compactionRanges) > 0 {
+		compactionRanges, err = parseCompactionSet(conf.compactionRanges)
+		if err != nil {
+			return errors.Wrap(err, "parse compaction ranges")
+		}
+		level.Info(logger).Log("msg", "using custom compaction ranges", "ranges", compactionRanges.String())
+		if !conf.disableDownsampling && compactionRanges[compactionRanges.maxLevel()].Milliseconds() < downsample.ResLevel1DownsampleRange {
+			level.Warn(logger).Log("msg", "the biggest compaction range is shorter than the block size after which downsampling happens, blocks will not be downsampled", "downsample_range", time.Duration(downsample.ResLevel1DownsampleRange)*time.Millisecond)
+		}
+	}
+	maxCompactionLevel := conf.maxCompactionLevel
+	if maxCompactionLevel < 0 {
+		maxCompactionLevel = compactionRanges.maxLevel()
+	}
+
+	levels, err := compactionRanges.levels(maxCompactionLevel)
 	if err != nil {
 		return errors.Wrap(err, "get compaction levels")
 	}
 
-	if conf.maxCompactionLevel < compactions.maxLevel() {
-		level.Warn(logger).Log("msg", "Max compaction level is lower than should be", "current", conf.maxCompactionLevel, "default", compactions.maxLevel())
+	if maxCompactionLevel < compactionRanges.maxLevel() {
+		level.Warn(logger).Log("msg", "Max compaction level is lower than should be", "current", maxCompactionLevel, "default", compactionRanges.maxLevel())
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -321,11 +394,25 @@ func runCompact(
 
 	// Instantiate the compactor with different time slices. Timestamps in TSDB
 	// are in milliseconds.
-	comp, err := tsdb.NewLeveledCompactor(ctx, reg, logger, levels, downsample.NewPool(), mergeFunc)
+	var comp compact.Compactor
+	comp, err = tsdb.NewLeveledCompactor(ctx, reg, logger, levels, downsample.NewPool(), mergeFunc)
 	if err != nil {
 		return errors.Wrap(err, "create compactor")
 	}
 
+	labelRewritesContentYaml, err := conf.labelRewritesConf.Content()
+	if err != nil {
+		return errors.Wrap(err, "get content of label rewrites configuration")
+	}
+	if len(labelRewritesContentYaml) > 0 {
+		labelRewrites, err := compact.ParseLabelRewrites(labelRewritesContentYaml)
+		if err != nil {
+			return err
+		}
+		level.Info(logger).Log("msg", "label rewrites are enabled; blocks produced by compaction will be rewritten", "rewrites", len(labelRewrites))
+		comp = compact.NewRewritingCompactor(logger, reg, comp, labelRewrites)
+	}
+
 	var (
 		compactDir      = path.Join(conf.dataDir, "compact")
 		downsamplingDir = path.Join(conf.dataDir, "downsample")
@@ -342,15 +429,21 @@ func runCompact(
 	grouper := compact.NewDefaultGrouper(
 		logger,
 		bkt,
-		conf.acceptMalformedIndex,
-		enableVerticalCompaction,
 		reg,
 		compactMetrics.blocksMarked.WithLabelValues(metadata.DeletionMarkFilename, ""),
 		compactMetrics.garbageCollectedBlocks,
 		compactMetrics.blocksMarked.WithLabelValues(metadata.NoCompactMarkFilename, metadata.OutOfOrderChunksNoCompactReason),
-		metadata.HashFunc(conf.hashFunc),
-		conf.blockFilesConcurrency,
-		conf.compactBlocksFetchConcurrency,
+		compact.GroupOptions{
+			AcceptMalformedIndex:          conf.acceptMalformedIndex,
+			EnableVerticalCompaction:      enableVerticalCompaction,
+			HashFunc:                      metadata.HashFunc(conf.hashFunc),
+			BlockFilesConcurrency:         conf.blockFilesConcurrency,
+			CompactBlocksFetchConcurrency: conf.compactBlocksFetchConcurrency,
+			ResumeCompactions:             conf.resumeCompactions,
+			RepairIndexIssues:             conf.repairIndexIssues,
+			VerifyChunks:                  conf.verifyChunks,
+			StorageClasses:                storageClassPolicy,
+		},
 	)
 	tsdbPlanner := compact.NewPlanner(logger, levels, noCompactMarkerFilter)
 	planner := compact.WithLargeTotalIndexSizeFilter(
@@ -359,8 +452,16 @@ func runCompact(
 		int64(conf.maxBlockIndexSize),
 		compactMetrics.blocksMarked.WithLabelValues(metadata.NoCompactMarkFilename, metadata.IndexSizeExceedingNoCompactReason),
 	)
-	blocksCleaner := compact.NewBlocksCleaner(logger, bkt, ignoreDeletionMarkFilter, deleteDelay, compactMetrics.blocksCleaned, compactMetrics.blockCleanupFailures)
-	compactor, err := compact.NewBucketCompactor(
+	blocksCleaner := compact.NewBlocksCleaner(logger, bkt, ignoreDeletionMarkFilter, deleteDelayPolicy, compactMetrics.blocksCleaned, compactMetrics.blockCleanupFailures)
+	scheduler, err := compact.NewGroupScheduler(reg, conf.schedulingStrategy, conf.fairSchedulingTenantLabel)
+	if err != nil {
+		return errors.Wrap(err, "create compaction group scheduler")
+	}
+	var quarantine *compact.GroupQuarantine
+	if conf.groupQuarantine {
+		quarantine = compact.NewGroupQuarantine(logger, reg)
+	}
+	compactor, err := compact.NewBucketCompactorWithScheduler(
 		logger,
 		sy,
 		grouper,
@@ -370,6 +471,8 @@ func runCompact(
 		bkt,
 		conf.compactionConcurrency,
 		conf.skipBlockWithOutOfOrderChunks,
+		scheduler,
+		quarantine,
 	)
 	if err != nil {
 		return errors.Wrap(err, "create bucket compactor")
@@ -409,7 +512,11 @@ func runCompact(
 			return errors.Wrap(err, "syncing metas")
 		}
 
-		compact.BestEffortCleanAbortedPartialUploads(ctx, logger, sy.Partial(), bkt, compactMetrics.partialUploadDeleteAttempts, compactMetrics.blocksCleaned, compactMetrics.blockCleanupFailures)
+		if conf.orphanedDataCleanupDelay > 0 {
+			compact.BestEffortCleanOrphanedBlockData(ctx, logger, sy.Partial(), bkt, conf.orphanedDataCleanupDelay, compactMetrics.partialUploadDeleteAttempts, compactMetrics.blocksCleaned, compactMetrics.blockCleanupFailures, compactMetrics.orphanedDataReclaimedBytes)
+		} else {
+			compact.BestEffortCleanAbortedPartialUploads(ctx, logger, sy.Partial(), bkt, compactMetrics.partialUploadDeleteAttempts, compactMetrics.blocksCleaned, compactMetrics.blockCleanupFailures)
+		}
 		if err := blocksCleaner.DeleteMarkedBlocks(ctx); err != nil {
 			return errors.Wrap(err, "cleaning marked blocks")
 		}
@@ -427,6 +534,14 @@ func runCompact(
 			// After all compactions are done, work down the downsampling backlog.
 			// We run two passes of this to ensure that the 1h downsampling is generated
 			// for 5m downsamplings created in the first run.
+			downsampleCtx := extobjstore.WithStorageClass(ctx, conf.downsampleStorageClass)
+			downsampleOpts := downsampleOptions{
+				concurrency:          conf.downsampleConcurrency,
+				hashFunc:             metadata.HashFunc(conf.hashFunc),
+				acceptMalformedIndex: conf.acceptMalformedIndex,
+				seriesMemoryBudget:   int64(conf.downsampleSeriesMemoryBudget),
+				storageClasses:       storageClassPolicy,
+			}
 			level.Info(logger).Log("msg", "start first pass of downsampling")
 			if err := sy.SyncMetas(ctx); err != nil {
 				return errors.Wrap(err, "sync before first pass of downsampling")
@@ -437,7 +552,7 @@ func runCompact(
 				downsampleMetrics.downsamples.WithLabelValues(groupKey)
 				downsampleMetrics.downsampleFailures.WithLabelValues(groupKey)
 			}
-			if err := downsampleBucket(ctx, logger, downsampleMetrics, bkt, sy.Metas(), downsamplingDir, conf.downsampleConcurrency, metadata.HashFunc(conf.hashFunc), conf.acceptMalformedIndex); err != nil {
+			if err := downsampleBucket(downsampleCtx, logger, downsampleMetrics, bkt, sy.Metas(), downsamplingDir, downsampleOpts); err != nil {
 				return errors.Wrap(err, "first pass of downsampling failed")
 			}
 
@@ -445,7 +560,7 @@ func runCompact(
 			if err := sy.SyncMetas(ctx); err != nil {
 				return errors.Wrap(err, "sync before second pass of downsampling")
 			}
-			if err := downsampleBucket(ctx, logger, downsampleMetrics, bkt, sy.Metas(), downsamplingDir, conf.downsampleConcurrency, metadata.HashFunc(conf.hashFunc), conf.acceptMalformedIndex); err != nil {
+			if err := downsampleBucket(downsampleCtx, logger, downsampleMetrics, bkt, sy.Metas(), downsamplingDir, downsampleOpts); err != nil {
 				return errors.Wrap(err, "second pass of downsampling failed")
 			}
 			level.Info(logger).Log("msg", "downsampling iterations done")
@@ -468,6 +583,15 @@ func runCompact(
 	g.Add(func() error {
 		defer runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
 
+		if len(conf.compactionRanges) > 0 {
+			if err := sy.SyncMetas(ctx); err != nil {
+				return errors.Wrap(err, "sync before validating compaction ranges")
+			}
+			if err := compact.ValidateBlocksAgainstRanges(sy.Metas(), levels); err != nil {
+				return errors.Wrap(err, "validate compaction ranges against blocks in the bucket")
+			}
+		}
+
 		if !conf.wait {
 			return compactMainFn()
 		}
@@ -643,12 +767,17 @@ func runCompact(
 
 type compactConfig struct {
 	haltOnError                                    bool
+	groupQuarantine                                bool
 	acceptMalformedIndex                           bool
 	maxCompactionLevel                             int
+	compactionRanges                               []string
 	http                                           httpConfig
+	health                                         healthConfig
 	dataDir                                        string
 	objStore                                       extflag.PathOrContent
 	consistencyDelay                               time.Duration
+	uploadGracePeriod                              time.Duration
+	orphanedDataCleanupDelay                       time.Duration
 	retentionRaw, retentionFiveMin, retentionOneHr model.Duration
 	wait                                           bool
 	waitInterval                                   time.Duration
@@ -660,8 +789,14 @@ type compactConfig struct {
 	cleanupBlocksInterval                          time.Duration
 	compactionConcurrency                          int
 	downsampleConcurrency                          int
+	downsampleSeriesMemoryBudget                   units.Base2Bytes
+	downsampleStorageClass                         string
 	compactBlocksFetchConcurrency                  int
+	resumeCompactions                              bool
+	repairIndexIssues                              bool
+	verifyChunks                                   bool
 	deleteDelay                                    model.Duration
+	deleteDelayConf                                extflag.PathOrContent
 	dedupReplicaLabels                             []string
 	selectorRelabelConf                            extflag.PathOrContent
 	disableWeb                                     bool
@@ -674,6 +809,10 @@ type compactConfig struct {
 	skipBlockWithOutOfOrderChunks                  bool
 	progressCalculateInterval                      time.Duration
 	filterConf                                     *store.FilterConfig
+	labelRewritesConf                              extflag.PathOrContent
+	storageClassConf                               extflag.PathOrContent
+	schedulingStrategy                             string
+	fairSchedulingTenantLabel                      string
 }
 
 func (cc *compactConfig) registerFlag(cmd extkingpin.FlagClause) {
@@ -682,10 +821,11 @@ func (cc *compactConfig) registerFlag(cmd extkingpin.FlagClause) {
 	cmd.Flag("debug.accept-malformed-index",
 		"Compaction and downsampling index verification will ignore out of order label names.").
 		Hidden().Default("false").BoolVar(&cc.acceptMalformedIndex)
-	cmd.Flag("debug.max-compaction-level", fmt.Sprintf("Maximum compaction level, default is %d: %s", compactions.maxLevel(), compactions.String())).
-		Hidden().Default(strconv.Itoa(compactions.maxLevel())).IntVar(&cc.maxCompactionLevel)
+	cmd.Flag("debug.max-compaction-level", fmt.Sprintf("Maximum compaction level, default is the highest configured level, %d for the default ranges: %s", compactions.maxLevel(), compactions.String())).
+		Hidden().Default("-1").IntVar(&cc.maxCompactionLevel)
 
 	cc.http.registerFlag(cmd)
+	cc.health.registerFlag(cmd)
 
 	cmd.Flag("data-dir", "Data directory in which to cache blocks and process compactions.").
 		Default("./data").StringVar(&cc.dataDir)
@@ -694,6 +834,10 @@ func (cc *compactConfig) registerFlag(cmd extkingpin.FlagClause) {
 
 	cmd.Flag("consistency-delay", fmt.Sprintf("Minimum age of fresh (non-compacted) blocks before they are being processed. Malformed blocks older than the maximum of consistency-delay and %v will be removed.", compact.PartialUploadThresholdAge)).
 		Default("30m").DurationVar(&cc.consistencyDelay)
+	cmd.Flag("compact.upload-grace-period", "Minimum time since the meta.json file of a fresh (non-compacted) block was uploaded before the block is being processed. "+
+		"Contrary to consistency-delay, which is based on the block creation time, it protects from races with sidecars and receivers still uploading "+
+		"blocks of the same time range, e.g. when uploading old blocks. 0s disables it.").
+		Default("0s").DurationVar(&cc.uploadGracePeriod)
 
 	cmd.Flag("retention.resolution-raw",
 		"How long to retain raw samples in bucket. Setting this to 0d will retain samples of this resolution forever").
@@ -728,10 +872,46 @@ func (cc *compactConfig) registerFlag(cmd extkingpin.FlagClause) {
 
 	cmd.Flag("compact.concurrency", "Number of goroutines to use when compacting groups.").
 		Default("1").IntVar(&cc.compactionConcurrency)
+	cmd.Flag("compact.level-range", "Experimental. Time range of blocks produced by each compaction level, starting from level 0 (repeated). "+
+		"Ranges have to be increasing and each range has to be a multiple of the previous one. If not set, the default ranges "+compactions.String()+" are used. "+
+		"Blocks already in the bucket are validated against the configured ranges on startup.").
+		PlaceHolder("<duration>").StringsVar(&cc.compactionRanges)
+	cmd.Flag("compact.scheduling-strategy", "Order in which compaction groups are compacted. Possible values are: \"fixed\", \"fair\". "+
+		"With \"fixed\", groups are compacted in the order of their keys. With \"fair\", groups of different tenants are interleaved, "+
+		"preferring tenants which got the least compactions so far, so one tenant's backlog cannot starve the others.").
+		Default(compact.SchedulingStrategyFixed).EnumVar(&cc.schedulingStrategy, compact.SchedulingStrategyFixed, compact.SchedulingStrategyFair)
+	cmd.Flag("compact.fair-scheduling.tenant-label", "External label identifying the tenant of a compaction group for fair scheduling. If empty, each external label set is treated as a separate tenant.").
+		Default("").StringVar(&cc.fairSchedulingTenantLabel)
 	cmd.Flag("compact.blocks-fetch-concurrency", "Number of goroutines to use when download block during compaction.").
 		Default("1").IntVar(&cc.compactBlocksFetchConcurrency)
+	cmd.Flag("compact.resume-interrupted", "Experimental. When set to true, compactor records checksums of downloaded and compacted blocks in the data directory, "+
+		"so after a restart it reuses them instead of downloading source blocks again, or uploads the already compacted block instead of compacting again. "+
+		"Requires a persistent data directory.").
+		Default("false").BoolVar(&cc.resumeCompactions)
+	cmd.Flag("compact.repair-index-issues", "Experimental. When set to true, downloaded blocks with out of order labels or duplicated out of order chunks "+
+		"are repaired locally before compaction, the same way as 'thanos tools bucket verify --repair' does, instead of halting or skipping them. "+
+		"The repaired data ends up in the compacted block, and the original block is deleted as usual after compaction.").
+		Default("false").BoolVar(&cc.repairIndexIssues)
+	cmd.Flag("compact.verify-chunks", "When set to true, every chunk referenced by the index of a downloaded block is checked to exist in the chunk segment files, "+
+		"to be complete and to match its CRC32 before compaction. Blocks with dangling, truncated or corrupted chunks are treated as a critical error. "+
+		"This reads all chunks of the downloaded blocks once more.").
+		Default("false").BoolVar(&cc.verifyChunks)
+	cmd.Flag("compact.group-quarantine", "When set to true, a compaction group failing with a critical error is quarantined instead of halting the whole compactor. "+
+		"Quarantined groups are skipped until the compactor is restarted, while groups of other streams and tenants are still compacted. "+
+		"Quarantined groups are exported with the thanos_compact_group_quarantined metric.").
+		Default("false").BoolVar(&cc.groupQuarantine)
+	cmd.Flag("compact.orphaned-data-cleanup-delay", fmt.Sprintf("Experimental. Time since the last modification of any object of a block without meta.json, "+
+		"left over by an aborted upload, before the block data is deleted. Data of deletions interrupted after removing meta.json is deleted right away. "+
+		"If 0s, blocks without meta.json are deleted %v after their creation time instead.", compact.PartialUploadThresholdAge)).
+		Default("0s").DurationVar(&cc.orphanedDataCleanupDelay)
 	cmd.Flag("downsample.concurrency", "Number of goroutines to use when downsampling blocks.").
 		Default("1").IntVar(&cc.downsampleConcurrency)
+	cmd.Flag("downsample.series-memory-budget", "Maximum memory used to buffer raw samples of a single series while downsampling. Raw chunks are processed one at a time, and once the budget is exceeded, "+
+		"complete aggregation windows are aggregated and released, so huge blocks can be downsampled with bounded memory. 0 means all samples of a series are buffered.").
+		Default("0").BytesVar(&cc.downsampleSeriesMemoryBudget)
+	cmd.Flag("downsample.storage-class", "Storage class of the objects of downsampled blocks, e.g. NEARLINE, to store them with a cheaper storage class than raw blocks. "+
+		"Only supported by GCS and S3 buckets, and Azure containers accessed with workload identity. If empty, the storage class of the bucket configuration is used.").
+		Default("").StringVar(&cc.downsampleStorageClass)
 
 	cmd.Flag("delete-delay", "Time before a block marked for deletion is deleted from bucket. "+
 		"If delete-delay is non zero, blocks will be marked for deletion and compactor component will delete blocks marked for deletion from the bucket. "+
@@ -739,6 +919,11 @@ func (cc *compactConfig) registerFlag(cmd extkingpin.FlagClause) {
 		"Note that deleting blocks immediately can cause query failures, if store gateway still has the block loaded, "+
 		"or compactor is ignoring the deletion because it's compacting the block at the same time.").
 		Default("48h").SetValue(&cc.deleteDelay)
+	cc.deleteDelayConf = *extflag.RegisterPathOrContent(cmd, "delete-delay.config",
+		"YAML file with delete delay overrides per resolution and external labels selector. Overrides are evaluated in order and the first match wins; blocks not matching any override use --delete-delay. "+
+			"See format details: https://thanos.io/tip/components/compact.md/#delete-delay-overrides",
+		extflag.WithEnvSubstitution(),
+	)
 
 	cmd.Flag("compact.enable-vertical-compaction", "Experimental. When set to true, compactor will allow overlaps and perform **irreversible** vertical compaction. See https://thanos.io/tip/components/compact.md/#vertical-compactions to read more. "+
 		"Please note that by default this uses a NAIVE algorithm for merging. If you need a different deduplication algorithm (e.g one that works well with Prometheus replicas), please set it via --deduplication.func."+
@@ -766,6 +951,18 @@ func (cc *compactConfig) registerFlag(cmd extkingpin.FlagClause) {
 	cmd.Flag("compact.skip-block-with-out-of-order-chunks", "When set to true, mark blocks containing index with out-of-order chunks for no compact instead of halting the compaction").
 		Hidden().Default("false").BoolVar(&cc.skipBlockWithOutOfOrderChunks)
 
+	cc.labelRewritesConf = *extflag.RegisterPathOrContent(cmd, "compact.label-rewrite-config",
+		"Experimental. YAML file with a list of label rewrites (matchers plus rename_metric and/or drop_labels) applied to every block produced by compaction. "+
+			"This allows to progressively apply schema changes to historical data. See format details: https://thanos.io/tip/components/compact.md/#label-rewrites",
+		extflag.WithEnvSubstitution(),
+	)
+
+	cc.storageClassConf = *extflag.RegisterPathOrContent(cmd, "compact.storage-class-config",
+		"YAML file with rules setting the storage class of blocks uploaded by compaction and downsampling by their resolution and age, e.g. to store old downsampled blocks in a cold tier. "+
+			"Rules are evaluated in order and the first match wins; blocks not matching any rule use the storage class of the bucket. See format details: https://thanos.io/tip/components/compact.md/#storage-classes",
+		extflag.WithEnvSubstitution(),
+	)
+
 	cmd.Flag("hash-func", "Specify which hash function to use when calculating the hashes of produced files. If no function has been specified, it does not happen. This permits avoiding downloading some files twice albeit at some performance cost. Possible values are: \"\", \"SHA256\".").
 		Default("").EnumVar(&cc.hashFunc, "SHA256", "")
 
@@ -7,14 +7,26 @@
 package main
 
 import (
+	"context"
 	"net/url"
 	"time"
 
+	"github.com/alecthomas/units"
 	extflag "github.com/efficientgo/tools/extkingpin"
+	"github.com/go-kit/log"
+	"github.com/oklog/run"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/prometheus/common/model"
+	"github.com/thanos-io/objstore"
 
+	"github.com/thanos-io/thanos/pkg/component"
 	"github.com/thanos-io/thanos/pkg/extkingpin"
+	"github.com/thanos-io/thanos/pkg/overrides"
+	"github.com/thanos-io/thanos/pkg/prober"
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
+	"github.com/thanos-io/thanos/pkg/store"
 )
 
 type grpcConfig struct {
@@ -51,6 +63,7 @@ func (gc *grpcConfig) registerFlag(cmd extkingpin.FlagClause) *grpcConfig {
 type httpConfig struct {
 	bindAddress string
 	tlsConfig   string
+	authConfig  *extflag.PathOrContent
 	gracePeriod model.Duration
 }
 
@@ -65,15 +78,122 @@ func (hc *httpConfig) registerFlag(cmd extkingpin.FlagClause) *httpConfig {
 		"http.config",
 		"[EXPERIMENTAL] Path to the configuration file that can enable TLS or authentication for all HTTP endpoints.",
 	).Default("").StringVar(&hc.tlsConfig)
+	hc.authConfig = extkingpin.RegisterHTTPAuthFlag(cmd)
 	return hc
 }
 
+// newHTTPAuthenticator returns the authenticator of the HTTP endpoints configured by the given HTTP auth configuration,
+// or nil if no authentication is configured.
+func newHTTPAuthenticator(logger log.Logger, authConfig *extflag.PathOrContent) (*middleware.Authenticator, error) {
+	content, err := authConfig.Content()
+	if err != nil {
+		return nil, errors.Wrap(err, "get content of HTTP auth configuration")
+	}
+	if len(content) == 0 {
+		return nil, nil
+	}
+	a, err := middleware.NewAuthenticator(log.With(logger, "component", "http-auth"), content)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse HTTP auth configuration")
+	}
+	return a, nil
+}
+
+// tenantSeriesSelectLimits returns the series select limits of tenants given by flags, overridden by the current
+// per-tenant overrides, if any.
+func tenantSeriesSelectLimits(o *overrides.Manager, limits store.SeriesSelectLimits) func(tenant string) store.SeriesSelectLimits {
+	return func(tenant string) store.SeriesSelectLimits {
+		return overrideSeriesSelectLimits(o, tenant, limits)
+	}
+}
+
+// setupOverrides loads the per-tenant overrides of the given component and reloads them in the group. It returns nil if
+// no overrides are configured.
+func setupOverrides(g *run.Group, logger log.Logger, reg prometheus.Registerer, o *overrides.Manager, comp component.Component) (*overrides.Manager, error) {
+	if err := o.Init(logger, reg, comp.String()); err != nil {
+		return nil, errors.Wrap(err, "loading overrides")
+	}
+	if !o.Enabled() {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g.Add(func() error {
+		return o.Run(ctx)
+	}, func(error) {
+		cancel()
+	})
+	return o, nil
+}
+
+// overrideSeriesSelectLimits returns the given series select limits overridden by the limits of the tenant.
+func overrideSeriesSelectLimits(o *overrides.Manager, tenant string, l store.SeriesSelectLimits) store.SeriesSelectLimits {
+	tl := o.Limits(tenant)
+	if tl.MaxSeriesPerRequest != nil {
+		l.SeriesPerRequest = *tl.MaxSeriesPerRequest
+	}
+	if tl.MaxSamplesPerRequest != nil {
+		l.SamplesPerRequest = *tl.MaxSamplesPerRequest
+	}
+	return l
+}
+
+type healthConfig struct {
+	checkInterval        model.Duration
+	checkTimeout         model.Duration
+	criticalDependencies []string
+}
+
+func (hc *healthConfig) registerFlag(cmd extkingpin.FlagClause) *healthConfig {
+	cmd.Flag("health.check-interval",
+		"Interval between checks of the dependencies of the component, such as object storage or caches. Their status is served by /api/v1/status/health.").
+		Default("15s").SetValue(&hc.checkInterval)
+	cmd.Flag("health.check-timeout",
+		"Timeout of a check of a dependency of the component.").
+		Default("5s").SetValue(&hc.checkTimeout)
+	cmd.Flag("health.critical-dependency",
+		"Name of a dependency whose failing checks make the component not ready (repeated). See /api/v1/status/health for the dependencies of the component.").
+		PlaceHolder("<name>").StringsVar(&hc.criticalDependencies)
+	return hc
+}
+
+// setupDependencies returns the dependencies of the component, checked in the group once registered. Their critical
+// ones are required for the given probe to be ready.
+func setupDependencies(g *run.Group, logger log.Logger, reg prometheus.Registerer, hc *healthConfig, probe *prober.HTTPProbe) (*prober.Dependencies, error) {
+	deps, err := prober.NewDependencies(log.With(logger, "component", "dependencies"), reg, time.Duration(hc.checkInterval), time.Duration(hc.checkTimeout), hc.criticalDependencies)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid --health.check-interval or --health.check-timeout")
+	}
+	probe.SetDependencies(deps)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g.Add(func() error {
+		return deps.Run(ctx)
+	}, func(error) {
+		cancel()
+	})
+	return deps, nil
+}
+
+// objStoreCheck returns a check of the reachability of the given bucket.
+func objStoreCheck(bkt objstore.Bucket) prober.DependencyCheck {
+	return func(ctx context.Context) error {
+		// Looking up an object which most likely does not exist is cheap, yet requires the bucket to be reachable
+		// with valid credentials.
+		_, err := bkt.Exists(ctx, "thanos-dependency-check")
+		return err
+	}
+}
+
 type prometheusConfig struct {
-	url               *url.URL
-	readyTimeout      time.Duration
-	getConfigInterval time.Duration
-	getConfigTimeout  time.Duration
-	httpClient        *extflag.PathOrContent
+	url                *url.URL
+	readyTimeout       time.Duration
+	getConfigInterval  time.Duration
+	getConfigTimeout   time.Duration
+	httpClient         *extflag.PathOrContent
+	apiCacheTTL        time.Duration
+	apiCacheStaleTTL   time.Duration
+	apiCacheMaxEntries int
 }
 
 func (pc *prometheusConfig) registerFlag(cmd extkingpin.FlagClause) *prometheusConfig {
@@ -94,6 +214,15 @@ func (pc *prometheusConfig) registerFlag(cmd extkingpin.FlagClause) *prometheusC
 		"prometheus.http-client",
 		"YAML file or string with http client configs. See Format details: https://thanos.io/tip/components/sidecar.md/#configuration.",
 	)
+	cmd.Flag("prometheus.api-cache-ttl",
+		"How long the metric metadata and exemplars responses of Prometheus are cached and served from the cache. 0 disables the cache.").
+		Default("0s").DurationVar(&pc.apiCacheTTL)
+	cmd.Flag("prometheus.api-cache-stale-ttl",
+		"How long cached metric metadata and exemplars responses of Prometheus are served after --prometheus.api-cache-ttl if Prometheus is unavailable, e.g. while it restarts.").
+		Default("1m").DurationVar(&pc.apiCacheStaleTTL)
+	cmd.Flag("prometheus.api-cache-max-entries",
+		"Maximum number of metric metadata and exemplars responses of Prometheus cached, per API. The least recently used responses are evicted beyond it.").
+		Default("1000").IntVar(&pc.apiCacheMaxEntries)
 
 	return pc
 }
@@ -110,8 +239,11 @@ func (tc *tsdbConfig) registerFlag(cmd extkingpin.FlagClause) *tsdbConfig {
 type reloaderConfig struct {
 	confFile        string
 	envVarConfFile  string
+	configTemplate  bool
 	ruleDirectories []string
+	watchedFiles    []string
 	watchInterval   time.Duration
+	delayInterval   time.Duration
 	retryInterval   time.Duration
 }
 
@@ -122,12 +254,21 @@ func (rc *reloaderConfig) registerFlag(cmd extkingpin.FlagClause) *reloaderConfi
 	cmd.Flag("reloader.config-envsubst-file",
 		"Output file for environment variable substituted config file.").
 		Default("").StringVar(&rc.envVarConfFile)
+	cmd.Flag("reloader.config-template",
+		"If true, the config file is rendered as a Go template into the output file before substituting environment variables. The template can use the env, include and indent functions, see https://thanos.io/tip/components/sidecar.md/#config-templating.").
+		Default("false").BoolVar(&rc.configTemplate)
 	cmd.Flag("reloader.rule-dir",
 		"Rule directories for the reloader to refresh (repeated field).").
 		StringsVar(&rc.ruleDirectories)
+	cmd.Flag("reloader.watched-file",
+		"Additional files for the reloader to refresh, e.g. files included by the config template (repeated field).").
+		StringsVar(&rc.watchedFiles)
 	cmd.Flag("reloader.watch-interval",
 		"Controls how often reloader re-reads config and rules.").
 		Default("3m").DurationVar(&rc.watchInterval)
+	cmd.Flag("reloader.delay-interval",
+		"Controls how long the reloader waits without new changes of the watched files before reloading, to apply rapid changes at once.").
+		Default("0s").DurationVar(&rc.delayInterval)
 	cmd.Flag("reloader.retry-interval",
 		"Controls how often reloader retries config reload in case of error.").
 		Default("5s").DurationVar(&rc.retryInterval)
@@ -136,16 +277,22 @@ func (rc *reloaderConfig) registerFlag(cmd extkingpin.FlagClause) *reloaderConfi
 }
 
 type shipperConfig struct {
-	uploadCompacted       bool
-	ignoreBlockSize       bool
-	allowOutOfOrderUpload bool
-	hashFunc              string
+	uploadCompacted          bool
+	skipOverlappingCompacted bool
+	ignoreBlockSize          bool
+	allowOutOfOrderUpload    bool
+	hashFunc                 string
+	uploadConcurrency        int
+	uploadBandwidthLimit     units.Base2Bytes
 }
 
 func (sc *shipperConfig) registerFlag(cmd extkingpin.FlagClause) *shipperConfig {
 	cmd.Flag("shipper.upload-compacted",
 		"If true shipper will try to upload compacted blocks as well. Useful for migration purposes. Works only if compaction is disabled on Prometheus. Do it once and then disable the flag when done.").
 		Default("false").BoolVar(&sc.uploadCompacted)
+	cmd.Flag("shipper.skip-overlapping-compacted",
+		"If true, with --shipper.upload-compacted, compacted blocks overlapping blocks in the bucket with the same external labels are skipped and kept locally, instead of stopping the upload of all blocks. Skipped blocks are checked again on every sync.").
+		Default("false").BoolVar(&sc.skipOverlappingCompacted)
 	cmd.Flag("shipper.ignore-unequal-block-size",
 		"If true shipper will not require prometheus min and max block size flags to be set to the same value. Only use this if you want to keep long retention and compaction enabled on your Prometheus instance, as in the worst case it can result in ~2h data loss for your Thanos bucket storage.").
 		Default("false").Hidden().BoolVar(&sc.ignoreBlockSize)
@@ -156,6 +303,10 @@ func (sc *shipperConfig) registerFlag(cmd extkingpin.FlagClause) *shipperConfig
 		Default("false").Hidden().BoolVar(&sc.allowOutOfOrderUpload)
 	cmd.Flag("hash-func", "Specify which hash function to use when calculating the hashes of produced files. If no function has been specified, it does not happen. This permits avoiding downloading some files twice albeit at some performance cost. Possible values are: \"\", \"SHA256\".").
 		Default("").EnumVar(&sc.hashFunc, "SHA256", "")
+	cmd.Flag("shipper.upload-concurrency", "Number of goroutines to use when uploading the files of a block to object storage.").
+		Default("1").IntVar(&sc.uploadConcurrency)
+	cmd.Flag("shipper.upload-bandwidth-limit", "Maximum number of bytes per second uploaded to object storage by the shipper, across all concurrent uploads, e.g. 10MB. 0 means no limit.").
+		Default("0").BytesVar(&sc.uploadBandwidthLimit)
 	return sc
 }
 
@@ -179,6 +330,12 @@ func (wc *webConfig) registerFlag(cmd extkingpin.FlagClause) *webConfig {
 	return wc
 }
 
+// Orders in which the ruler tries query API servers.
+const (
+	queryEndpointOrderRandom  = "random"
+	queryEndpointOrderOrdered = "ordered"
+)
+
 type queryConfig struct {
 	addrs         []string
 	sdFiles       []string
@@ -188,6 +345,17 @@ type queryConfig struct {
 	httpMethod    string
 	dnsSDResolver string
 	step          time.Duration
+	timeout       time.Duration
+	maxRetries    int
+	order         string
+
+	grpcAddrs         []string
+	grpcTLSSecure     bool
+	grpcTLSSkipVerify bool
+	grpcTLSCert       string
+	grpcTLSKey        string
+	grpcTLSCA         string
+	grpcTLSServerName string
 }
 
 func (qc *queryConfig) registerFlag(cmd extkingpin.FlagClause) *queryConfig {
@@ -206,6 +374,20 @@ func (qc *queryConfig) registerFlag(cmd extkingpin.FlagClause) *queryConfig {
 		Default("miekgdns").Hidden().StringVar(&qc.dnsSDResolver)
 	cmd.Flag("query.default-step", "Default range query step to use. This is only used in stateless Ruler and alert state restoration.").
 		Default("1s").DurationVar(&qc.step)
+	cmd.Flag("query.timeout", "Timeout of a single evaluation query against a query API server. Once it is reached, the query is sent to the next query API server. 0s means no timeout.").
+		Default("0s").DurationVar(&qc.timeout)
+	cmd.Flag("query.max-retries", "Number of times all query API servers are tried again, with exponential backoff, before an evaluation query fails.").
+		Default("0").IntVar(&qc.maxRetries)
+	cmd.Flag("query.endpoint-order", "Order in which query API servers are tried. With random, the queries are spread over all query API servers. With ordered, query API servers are tried in the order they are configured in, e.g. to send queries to a query-frontend and only fail over to queriers when it is unavailable. Servers discovered from the same address or file are always tried in random order.").
+		Default(queryEndpointOrderRandom).EnumVar(&qc.order, queryEndpointOrderRandom, queryEndpointOrderOrdered)
+	cmd.Flag("query.grpc-address", "Addresses of statically configured query API servers reached through the gRPC QueryAPI (repeatable). They are tried after the query API servers reached through HTTP if the order is ordered.").
+		PlaceHolder("<host:port>").StringsVar(&qc.grpcAddrs)
+	cmd.Flag("query.grpc-client-tls-secure", "Use TLS when talking to the gRPC query API servers.").Default("false").BoolVar(&qc.grpcTLSSecure)
+	cmd.Flag("query.grpc-client-tls-skip-verify", "Disable TLS certificate verification of the gRPC query API servers i.e self signed, signed by fake CA.").Default("false").BoolVar(&qc.grpcTLSSkipVerify)
+	cmd.Flag("query.grpc-client-tls-cert", "TLS Certificates to use to identify this client to the gRPC query API servers.").Default("").StringVar(&qc.grpcTLSCert)
+	cmd.Flag("query.grpc-client-tls-key", "TLS Key for the client's certificate.").Default("").StringVar(&qc.grpcTLSKey)
+	cmd.Flag("query.grpc-client-tls-ca", "TLS CA Certificates to use to verify the gRPC query API servers.").Default("").StringVar(&qc.grpcTLSCA)
+	cmd.Flag("query.grpc-client-server-name", "Server name to verify the hostname on the returned gRPC certificates. See https://tools.ietf.org/html/rfc4366#section-3.1").Default("").StringVar(&qc.grpcTLSServerName)
 	return qc
 }
 
@@ -22,17 +22,19 @@ import (
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
 	"github.com/thanos-io/objstore"
-	"github.com/thanos-io/objstore/client"
 
 	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact"
 	"github.com/thanos-io/thanos/pkg/compact/downsample"
 	"github.com/thanos-io/thanos/pkg/component"
 	"github.com/thanos-io/thanos/pkg/errutil"
+	"github.com/thanos-io/thanos/pkg/extobjstore"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	"github.com/thanos-io/thanos/pkg/prober"
 	"github.com/thanos-io/thanos/pkg/runutil"
 	httpserver "github.com/thanos-io/thanos/pkg/server/http"
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 )
 
 type DownsampleMetrics struct {
@@ -67,6 +69,7 @@ func RunDownsample(
 	reg *prometheus.Registry,
 	httpBindAddr string,
 	httpTLSConfig string,
+	httpAuth *middleware.Authenticator,
 	httpGracePeriod time.Duration,
 	dataDir string,
 	waitInterval time.Duration,
@@ -74,13 +77,14 @@ func RunDownsample(
 	objStoreConfig *extflag.PathOrContent,
 	comp component.Component,
 	hashFunc metadata.HashFunc,
+	seriesMemoryBudget int64,
 ) error {
 	confContentYaml, err := objStoreConfig.Content()
 	if err != nil {
 		return err
 	}
 
-	bkt, err := client.NewBucket(logger, confContentYaml, reg, component.Downsample.String())
+	bkt, err := extobjstore.NewBucket(logger, confContentYaml, reg, component.Downsample.String())
 	if err != nil {
 		return err
 	}
@@ -108,6 +112,11 @@ func RunDownsample(
 	)
 
 	metrics := newDownsampleMetrics(reg)
+	opts := downsampleOptions{
+		concurrency:        downsampleConcurrency,
+		hashFunc:           hashFunc,
+		seriesMemoryBudget: seriesMemoryBudget,
+	}
 	// Start cycle of syncing blocks from the bucket and garbage collecting the bucket.
 	{
 		ctx, cancel := context.WithCancel(context.Background())
@@ -128,7 +137,7 @@ func RunDownsample(
 					metrics.downsamples.WithLabelValues(groupKey)
 					metrics.downsampleFailures.WithLabelValues(groupKey)
 				}
-				if err := downsampleBucket(ctx, logger, metrics, bkt, metas, dataDir, downsampleConcurrency, hashFunc, false); err != nil {
+				if err := downsampleBucket(ctx, logger, metrics, bkt, metas, dataDir, opts); err != nil {
 					return errors.Wrap(err, "downsampling failed")
 				}
 
@@ -137,7 +146,7 @@ func RunDownsample(
 				if err != nil {
 					return errors.Wrap(err, "sync before second pass of downsampling")
 				}
-				if err := downsampleBucket(ctx, logger, metrics, bkt, metas, dataDir, downsampleConcurrency, hashFunc, false); err != nil {
+				if err := downsampleBucket(ctx, logger, metrics, bkt, metas, dataDir, opts); err != nil {
 					return errors.Wrap(err, "downsampling failed")
 				}
 				return nil
@@ -151,6 +160,7 @@ func RunDownsample(
 		httpserver.WithListen(httpBindAddr),
 		httpserver.WithGracePeriod(httpGracePeriod),
 		httpserver.WithTLSConfig(httpTLSConfig),
+		httpserver.WithAuthenticator(httpAuth),
 	)
 
 	g.Add(func() error {
@@ -168,6 +178,18 @@ func RunDownsample(
 	return nil
 }
 
+// downsampleOptions configures how blocks are downsampled and uploaded.
+type downsampleOptions struct {
+	// concurrency is the number of blocks downsampled concurrently by downsampleBucket.
+	concurrency          int
+	hashFunc             metadata.HashFunc
+	acceptMalformedIndex bool
+	// seriesMemoryBudget bounds the memory used for raw samples of a block. Zero means unbounded.
+	seriesMemoryBudget int64
+	// storageClasses selects the storage class of the uploaded blocks. Nil uses the bucket default.
+	storageClasses *compact.StorageClassPolicy
+}
+
 func downsampleBucket(
 	ctx context.Context,
 	logger log.Logger,
@@ -175,9 +197,7 @@ func downsampleBucket(
 	bkt objstore.Bucket,
 	metas map[ulid.ULID]*metadata.Meta,
 	dir string,
-	downsampleConcurrency int,
-	hashFunc metadata.HashFunc,
-	acceptMalformedIndex bool,
+	opts downsampleOptions,
 ) (rerr error) {
 	if err := os.MkdirAll(dir, 0750); err != nil {
 		return errors.Wrap(err, "create dir")
@@ -237,14 +257,14 @@ func downsampleBucket(
 		wg                      sync.WaitGroup
 		metaCh                  = make(chan *metadata.Meta)
 		downsampleErrs          errutil.MultiError
-		errCh                   = make(chan error, downsampleConcurrency)
+		errCh                   = make(chan error, opts.concurrency)
 		workerCtx, workerCancel = context.WithCancel(ctx)
 	)
 
 	defer workerCancel()
 
-	level.Debug(logger).Log("msg", "downsampling bucket", "concurrency", downsampleConcurrency)
-	for i := 0; i < downsampleConcurrency; i++ {
+	level.Debug(logger).Log("msg", "downsampling bucket", "concurrency", opts.concurrency)
+	for i := 0; i < opts.concurrency; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -255,7 +275,7 @@ func downsampleBucket(
 					resolution = downsample.ResLevel2
 					errMsg = "downsampling to 60 min"
 				}
-				if err := processDownsampling(workerCtx, logger, bkt, m, dir, resolution, hashFunc, metrics, acceptMalformedIndex); err != nil {
+				if err := processDownsampling(workerCtx, logger, bkt, m, dir, resolution, metrics, opts); err != nil {
 					metrics.downsampleFailures.WithLabelValues(m.Thanos.GroupKey()).Inc()
 					errCh <- errors.Wrap(err, errMsg)
 
@@ -342,9 +362,8 @@ func processDownsampling(
 	m *metadata.Meta,
 	dir string,
 	resolution int64,
-	hashFunc metadata.HashFunc,
 	metrics *DownsampleMetrics,
-	acceptMalformedIndex bool,
+	opts downsampleOptions,
 ) error {
 	begin := time.Now()
 	bdir := filepath.Join(dir, m.ULID.String())
@@ -355,7 +374,7 @@ func processDownsampling(
 	}
 	level.Info(logger).Log("msg", "downloaded block", "id", m.ULID, "duration", time.Since(begin), "duration_ms", time.Since(begin).Milliseconds())
 
-	if err := block.VerifyIndex(logger, filepath.Join(bdir, block.IndexFilename), m.MinTime, m.MaxTime); err != nil && !acceptMalformedIndex {
+	if err := block.VerifyIndex(logger, filepath.Join(bdir, block.IndexFilename), m.MinTime, m.MaxTime); err != nil && !opts.acceptMalformedIndex {
 		return errors.Wrap(err, "input block index not valid")
 	}
 
@@ -374,7 +393,7 @@ func processDownsampling(
 	}
 	defer runutil.CloseWithLogOnErr(log.With(logger, "outcome", "potential left mmap file handlers left"), b, "tsdb reader")
 
-	id, err := downsample.Downsample(logger, m, b, dir, resolution)
+	id, err := downsample.DownsampleWithMemoryBudget(logger, m, b, dir, resolution, opts.seriesMemoryBudget)
 	if err != nil {
 		return errors.Wrapf(err, "downsample block %s to window %d", m.ULID, resolution)
 	}
@@ -385,13 +404,13 @@ func processDownsampling(
 		"from", m.ULID, "to", id, "duration", downsampleDuration, "duration_ms", downsampleDuration.Milliseconds())
 	metrics.downsampleDuration.WithLabelValues(m.Thanos.GroupKey()).Observe(downsampleDuration.Seconds())
 
-	if err := block.VerifyIndex(logger, filepath.Join(resdir, block.IndexFilename), m.MinTime, m.MaxTime); err != nil && !acceptMalformedIndex {
+	if err := block.VerifyIndex(logger, filepath.Join(resdir, block.IndexFilename), m.MinTime, m.MaxTime); err != nil && !opts.acceptMalformedIndex {
 		return errors.Wrap(err, "output block index not valid")
 	}
 
 	begin = time.Now()
 
-	err = block.Upload(ctx, logger, bkt, resdir, hashFunc)
+	err = compact.UploadBlock(ctx, logger, bkt, resdir, opts.hashFunc, opts.storageClasses)
 	if err != nil {
 		return errors.Wrapf(err, "upload downsampled block %s", id)
 	}
@@ -408,3 +427,95 @@ func processDownsampling(
 
 	return nil
 }
+
+// downsampleBlocks downsamples the blocks with the given IDs once to the given resolution, or to the next resolution if
+// it is zero, and uploads the downsampled blocks. Blocks whose sources are already all downsampled to the resolution are
+// skipped, so deleted downsampled blocks can be recreated.
+func downsampleBlocks(
+	ctx context.Context,
+	logger log.Logger,
+	metrics *DownsampleMetrics,
+	bkt objstore.Bucket,
+	metas map[ulid.ULID]*metadata.Meta,
+	ids []ulid.ULID,
+	resolution int64,
+	dir string,
+	hashFunc metadata.HashFunc,
+	seriesMemoryBudget int64,
+) (rerr error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return errors.Wrap(err, "create dir")
+	}
+	defer func() {
+		// Leave the downsample directory for inspection if it is an error.
+		if rerr != nil {
+			return
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			level.Error(logger).Log("msg", "failed to remove downsample cache directory", "path", dir, "err", err)
+		}
+	}()
+
+	for _, id := range ids {
+		m, ok := metas[id]
+		if !ok {
+			return errors.Errorf("block %s not found", id)
+		}
+
+		res := resolution
+		if res == 0 {
+			switch m.Thanos.Downsample.Resolution {
+			case downsample.ResLevel0:
+				res = downsample.ResLevel1
+			case downsample.ResLevel1:
+				res = downsample.ResLevel2
+			default:
+				return errors.Errorf("block %s of resolution %v cannot be downsampled further", id, time.Duration(m.Thanos.Downsample.Resolution)*time.Millisecond)
+			}
+		}
+		if res <= m.Thanos.Downsample.Resolution {
+			return errors.Errorf("block %s of resolution %v cannot be downsampled to resolution %v", id,
+				time.Duration(m.Thanos.Downsample.Resolution)*time.Millisecond, time.Duration(res)*time.Millisecond)
+		}
+		// The same minimum ranges as the compactor are required, as it would downsample the longer blocks compacted from
+		// shorter ones again, creating overlapping downsampled blocks.
+		minRange := int64(downsample.ResLevel1DownsampleRange)
+		if res == downsample.ResLevel2 {
+			minRange = downsample.ResLevel2DownsampleRange
+		}
+		if m.MaxTime-m.MinTime < minRange {
+			return errors.Errorf("block %s of range %v is shorter than %v, the minimum range of blocks downsampled to resolution %v", id,
+				time.Duration(m.MaxTime-m.MinTime)*time.Millisecond, time.Duration(minRange)*time.Millisecond, time.Duration(res)*time.Millisecond)
+		}
+
+		if downsampledSources(metas, res, m.Compaction.Sources) {
+			level.Info(logger).Log("msg", "block is already downsampled; skipping", "id", id, "resolution", time.Duration(res)*time.Millisecond)
+			continue
+		}
+		if err := processDownsampling(ctx, logger, bkt, m, dir, res, metrics, downsampleOptions{hashFunc: hashFunc, seriesMemoryBudget: seriesMemoryBudget}); err != nil {
+			metrics.downsampleFailures.WithLabelValues(m.Thanos.GroupKey()).Inc()
+			return errors.Wrapf(err, "downsample block %s", id)
+		}
+		metrics.downsamples.WithLabelValues(m.Thanos.GroupKey()).Inc()
+	}
+	return nil
+}
+
+// downsampledSources returns true if all sources are sources of blocks of the given resolution.
+func downsampledSources(metas map[ulid.ULID]*metadata.Meta, resolution int64, sources []ulid.ULID) bool {
+	downsampled := map[ulid.ULID]struct{}{}
+	for _, m := range metas {
+		if m.Thanos.Downsample.Resolution != resolution {
+			continue
+		}
+		for _, id := range m.Compaction.Sources {
+			downsampled[id] = struct{}{}
+		}
+	}
+	for _, id := range sources {
+		if _, ok := downsampled[id]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -13,6 +13,7 @@ import (
 	"regexp"
 	"runtime"
 	"runtime/debug"
+	"strings"
 	"syscall"
 
 	"github.com/go-kit/log"
@@ -26,8 +27,12 @@ import (
 	"go.uber.org/automaxprocs/maxprocs"
 	"gopkg.in/alecthomas/kingpin.v2"
 
+	"github.com/thanos-io/thanos/pkg/diagnostics"
 	"github.com/thanos-io/thanos/pkg/extkingpin"
+	"github.com/thanos-io/thanos/pkg/featuregate"
 	"github.com/thanos-io/thanos/pkg/logging"
+	"github.com/thanos-io/thanos/pkg/memlimit"
+	"github.com/thanos-io/thanos/pkg/runtimeconfig"
 	"github.com/thanos-io/thanos/pkg/tracing/client"
 )
 
@@ -48,18 +53,26 @@ func main() {
 	logFormat := app.Flag("log.format", "Log format to use. Possible options: logfmt or json.").
 		Default(logging.LogFormatLogfmt).Enum(logging.LogFormatLogfmt, logging.LogFormatJSON)
 	tracingConfig := extkingpin.RegisterCommonTracingFlags(app)
+	// The memory limit flags are registered by the long-running components.
+	memLimit := &memlimit.Flags{}
+	// The diagnostics flags are registered by the long-running components.
+	diagnosticsConfig := &diagnostics.Flags{}
+	// The runtime configuration flags are registered by the components supporting it.
+	runtimeConfig := runtimeconfig.NewManager()
+	// The feature gate flags are registered by the components implementing feature gates.
+	featureGates := featuregate.New()
 
-	registerSidecar(app)
-	registerStore(app)
-	registerQuery(app)
-	registerRule(app)
-	registerCompact(app)
+	registerSidecar(app, runtimeConfig, memLimit, diagnosticsConfig)
+	registerStore(app, runtimeConfig, featureGates, memLimit, diagnosticsConfig)
+	registerQuery(app, runtimeConfig, featureGates, memLimit, diagnosticsConfig)
+	registerRule(app, runtimeConfig, memLimit, diagnosticsConfig)
+	registerCompact(app, runtimeConfig, memLimit, diagnosticsConfig)
 	registerTools(app)
-	registerReceive(app)
-	registerQueryFrontend(app)
+	registerReceive(app, runtimeConfig, featureGates, memLimit, diagnosticsConfig)
+	registerQueryFrontend(app, runtimeConfig, memLimit, diagnosticsConfig)
 
 	cmd, setup := app.Parse()
-	logger := logging.NewLogger(*logLevel, *logFormat, *debugName)
+	logger, logLevelSwitch := logging.NewLoggerWithLevelSwitch(*logLevel, *logFormat, *debugName)
 
 	// Running in container with limits but with empty/wrong value of GOMAXPROCS env var could lead to throttling by cpu
 	// maxprocs will automate adjustment by using cgroups info about cpu limit if it set as value for runtime.GOMAXPROCS.
@@ -71,6 +84,18 @@ func main() {
 		level.Warn(logger).Log("warn", errors.Wrapf(err, "failed to set GOMAXPROCS: %v", err))
 	}
 
+	if err := memLimit.Options.Validate(); err != nil {
+		level.Error(logger).Log("msg", "invalid --auto-gomemlimit.headroom-percent", "err", err)
+		os.Exit(1)
+	}
+	// Running in container with a memory limit but without GOMEMLIMIT lets the Go runtime grow the heap until the
+	// container is OOM killed, instead of collecting garbage more often when it gets close to the limit.
+	if memLimit.Enabled {
+		if err := memlimit.Set(logger, memLimit.Options); err != nil {
+			level.Warn(logger).Log("msg", "failed to set GOMEMLIMIT", "err", err)
+		}
+	}
+
 	metrics := prometheus.NewRegistry()
 	metrics.MustRegister(
 		version.NewCollector("thanos"),
@@ -79,6 +104,7 @@ func main() {
 		),
 		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 	)
+	memlimit.RegisterMetrics(metrics)
 
 	// Some packages still use default Register. Replace to have those metrics.
 	prometheus.DefaultRegisterer = metrics
@@ -127,6 +153,53 @@ func main() {
 			cancel()
 		})
 	}
+	// Setup the optional runtime configuration.
+	{
+		if err := runtimeConfig.Init(logger, metrics, logLevelSwitch); err != nil {
+			level.Error(logger).Log("msg", "loading runtime config failed", "err", err)
+			os.Exit(1)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return runtimeConfig.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+	// Setup the feature gates, which can be changed by the runtime configuration.
+	{
+		if err := featureGates.Init(logger, metrics, strings.Fields(cmd)[0], func() featuregate.Settings {
+			return runtimeConfig.Config().FeatureGates
+		}); err != nil {
+			level.Error(logger).Log("msg", "setting up feature gates failed", "err", err)
+			os.Exit(1)
+		}
+		runtimeConfig.OnReload(featureGates.Update)
+	}
+	// Setup the optional capture of profiles on resource pressure.
+	{
+		confContentYaml, err := diagnosticsConfig.Content()
+		if err != nil {
+			level.Error(logger).Log("msg", "getting diagnostics config failed", "err", err)
+			os.Exit(1)
+		}
+
+		if len(confContentYaml) > 0 {
+			d, err := diagnostics.New(logger, metrics, metrics, confContentYaml, cmd)
+			if err != nil {
+				level.Error(logger).Log("msg", "setting up diagnostics failed", "err", err)
+				os.Exit(1)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			g.Add(func() error {
+				return d.Run(ctx)
+			}, func(error) {
+				cancel()
+			})
+		}
+	}
 	// Create a signal channel to dispatch reload events to sub-commands.
 	reloadCh := make(chan struct{}, 1)
 
@@ -157,7 +157,7 @@ func TestRegression4960_Deadlock(t *testing.T) {
 
 	metas, _, err := metaFetcher.Fetch(ctx)
 	testutil.Ok(t, err)
-	err = downsampleBucket(ctx, logger, metrics, bkt, metas, dir, 1, metadata.NoneFunc, false)
+	err = downsampleBucket(ctx, logger, metrics, bkt, metas, dir, downsampleOptions{concurrency: 1, hashFunc: metadata.NoneFunc})
 	testutil.NotOk(t, err)
 
 	testutil.Assert(t, strings.Contains(err.Error(), "some random error has occurred"))
@@ -196,9 +196,61 @@ func TestCleanupDownsampleCacheFolder(t *testing.T) {
 
 	metas, _, err := metaFetcher.Fetch(ctx)
 	testutil.Ok(t, err)
-	testutil.Ok(t, downsampleBucket(ctx, logger, metrics, bkt, metas, dir, 1, metadata.NoneFunc, false))
+	testutil.Ok(t, downsampleBucket(ctx, logger, metrics, bkt, metas, dir, downsampleOptions{concurrency: 1, hashFunc: metadata.NoneFunc}))
 	testutil.Equals(t, 1.0, promtest.ToFloat64(metrics.downsamples.WithLabelValues(meta.Thanos.GroupKey())))
 
 	_, err = os.Stat(dir)
 	testutil.Assert(t, os.IsNotExist(err), "index cache dir should not exist at the end of execution")
 }
+
+func TestDownsampleBlocks(t *testing.T) {
+	logger := log.NewNopLogger()
+	dir := t.TempDir()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	bkt := objstore.WithNoopInstr(objstore.NewInMemBucket())
+	createBlock := func(maxt int64) ulid.ULID {
+		id, err := e2eutil.CreateBlock(ctx, dir, []labels.Labels{{{Name: "a", Value: "1"}}}, 100, 0, maxt,
+			labels.Labels{{Name: "e1", Value: "1"}}, downsample.ResLevel0, metadata.NoneFunc)
+		testutil.Ok(t, err)
+		testutil.Ok(t, block.Upload(ctx, logger, bkt, path.Join(dir, id.String()), metadata.NoneFunc))
+		return id
+	}
+	id := createBlock(downsample.ResLevel1DownsampleRange + 1)
+	shortID := createBlock(downsample.ResLevel1DownsampleRange - 1)
+
+	metaFetcher, err := block.NewMetaFetcher(nil, block.FetcherConcurrency, bkt, "", nil, nil)
+	testutil.Ok(t, err)
+	fetch := func() map[ulid.ULID]*metadata.Meta {
+		metas, _, err := metaFetcher.Fetch(ctx)
+		testutil.Ok(t, err)
+		return metas
+	}
+	metrics := newDownsampleMetrics(prometheus.NewRegistry())
+
+	// Blocks shorter than the minimum range of the resolution are not downsampled.
+	testutil.NotOk(t, downsampleBlocks(ctx, logger, metrics, bkt, fetch(), []ulid.ULID{shortID}, 0, dir, metadata.NoneFunc, 0))
+	// Raw blocks cannot be downsampled to 1h without the minimum range of 1h blocks either.
+	testutil.NotOk(t, downsampleBlocks(ctx, logger, metrics, bkt, fetch(), []ulid.ULID{id}, downsample.ResLevel2, dir, metadata.NoneFunc, 0))
+
+	testutil.Ok(t, downsampleBlocks(ctx, logger, metrics, bkt, fetch(), []ulid.ULID{id}, 0, dir, metadata.NoneFunc, 0))
+	metas := fetch()
+	testutil.Equals(t, 3, len(metas))
+	var downsampled *metadata.Meta
+	for _, m := range metas {
+		if m.Thanos.Downsample.Resolution == downsample.ResLevel1 {
+			downsampled = m
+		}
+	}
+	testutil.Assert(t, downsampled != nil, "expected downsampled block")
+	testutil.Equals(t, []ulid.ULID{id}, downsampled.Compaction.Sources)
+
+	// Already downsampled blocks are skipped.
+	testutil.Ok(t, downsampleBlocks(ctx, logger, metrics, bkt, metas, []ulid.ULID{id}, downsample.ResLevel1, dir, metadata.NoneFunc, 0))
+	testutil.Equals(t, 3, len(fetch()))
+
+	// 5m blocks cannot be downsampled to 5m again.
+	testutil.NotOk(t, downsampleBlocks(ctx, logger, metrics, bkt, metas, []ulid.ULID{downsampled.ULID}, downsample.ResLevel1, dir, metadata.NoneFunc, 0))
+}
@@ -13,6 +13,7 @@ import (
 
 	"google.golang.org/grpc"
 
+	extflag "github.com/efficientgo/tools/extkingpin"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	grpc_logging "github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
@@ -31,40 +32,43 @@ import (
 
 	apiv1 "github.com/thanos-io/thanos/pkg/api/query"
 	"github.com/thanos-io/thanos/pkg/api/query/querypb"
+	"github.com/thanos-io/thanos/pkg/audit"
 	"github.com/thanos-io/thanos/pkg/compact/downsample"
 	"github.com/thanos-io/thanos/pkg/component"
+	"github.com/thanos-io/thanos/pkg/diagnostics"
 	"github.com/thanos-io/thanos/pkg/discovery/cache"
 	"github.com/thanos-io/thanos/pkg/discovery/dns"
 	"github.com/thanos-io/thanos/pkg/exemplars"
 	"github.com/thanos-io/thanos/pkg/extgrpc"
 	"github.com/thanos-io/thanos/pkg/extgrpc/snappy"
+	"github.com/thanos-io/thanos/pkg/extgrpc/zstd"
 	"github.com/thanos-io/thanos/pkg/extkingpin"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
+	"github.com/thanos-io/thanos/pkg/featuregate"
 	"github.com/thanos-io/thanos/pkg/gate"
 	"github.com/thanos-io/thanos/pkg/info"
 	"github.com/thanos-io/thanos/pkg/info/infopb"
 	"github.com/thanos-io/thanos/pkg/logging"
+	"github.com/thanos-io/thanos/pkg/memlimit"
 	"github.com/thanos-io/thanos/pkg/metadata"
+	"github.com/thanos-io/thanos/pkg/overrides"
 	"github.com/thanos-io/thanos/pkg/prober"
 	"github.com/thanos-io/thanos/pkg/query"
 	"github.com/thanos-io/thanos/pkg/rules"
+	"github.com/thanos-io/thanos/pkg/runtimeconfig"
 	"github.com/thanos-io/thanos/pkg/runutil"
 	grpcserver "github.com/thanos-io/thanos/pkg/server/grpc"
 	httpserver "github.com/thanos-io/thanos/pkg/server/http"
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 	"github.com/thanos-io/thanos/pkg/store"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
 	"github.com/thanos-io/thanos/pkg/targets"
 	"github.com/thanos-io/thanos/pkg/tls"
 	"github.com/thanos-io/thanos/pkg/ui"
 )
 
-const (
-	promqlNegativeOffset = "promql-negative-offset"
-	promqlAtModifier     = "promql-at-modifier"
-	queryPushdown        = "query-pushdown"
-)
-
 type queryMode string
 
 const (
@@ -73,23 +77,31 @@ const (
 )
 
 // registerQuery registers a query command.
-func registerQuery(app *extkingpin.App) {
+func registerQuery(app *extkingpin.App, runtimeConfig *runtimeconfig.Manager, featureGates *featuregate.Gates, memLimit *memlimit.Flags, diagnosticsConfig *diagnostics.Flags) {
 	comp := component.Query
 	cmd := app.Command(comp.String(), "Query node exposing PromQL enabled Query API with data retrieved from multiple store nodes.")
+	runtimeConfig.RegisterFlags(cmd)
+	memLimit.RegisterFlags(cmd)
+	diagnosticsConfig.RegisterFlags(cmd)
+	featureGates.RegisterFlags(cmd)
 
 	httpBindAddr, httpGracePeriod, httpTLSConfig := extkingpin.RegisterHTTPFlags(cmd)
+	httpAuthConfig := extkingpin.RegisterHTTPAuthFlag(cmd)
 
 	var grpcServerConfig grpcConfig
 	grpcServerConfig.registerFlag(cmd)
 
+	var healthCfg healthConfig
+	healthCfg.registerFlag(cmd)
+
 	secure := cmd.Flag("grpc-client-tls-secure", "Use TLS when talking to the gRPC server").Default("false").Bool()
 	skipVerify := cmd.Flag("grpc-client-tls-skip-verify", "Disable TLS certificate verification i.e self signed, signed by fake CA").Default("false").Bool()
 	cert := cmd.Flag("grpc-client-tls-cert", "TLS Certificates to use to identify this client to the server").Default("").String()
 	key := cmd.Flag("grpc-client-tls-key", "TLS Key for the client's certificate").Default("").String()
 	caCert := cmd.Flag("grpc-client-tls-ca", "TLS CA Certificates to use to verify gRPC servers").Default("").String()
 	serverName := cmd.Flag("grpc-client-server-name", "Server name to verify the hostname on the returned gRPC certificates. See https://tools.ietf.org/html/rfc4366#section-3.1").Default("").String()
-	compressionOptions := strings.Join([]string{snappy.Name, compressionNone}, ", ")
-	grpcCompression := cmd.Flag("grpc-compression", "Compression algorithm to use for gRPC requests to other clients. Must be one of: "+compressionOptions).Default(compressionNone).Enum(snappy.Name, compressionNone)
+	compressionOptions := strings.Join([]string{snappy.Name, zstd.Name, compressionNone}, ", ")
+	grpcCompression := cmd.Flag("grpc-compression", "Compression algorithm to use for gRPC requests to other clients. Must be one of: "+compressionOptions+". It can be overridden per endpoint with --endpoint.config.").Default(compressionNone).Enum(snappy.Name, zstd.Name, compressionNone)
 
 	webRoutePrefix := cmd.Flag("web.route-prefix", "Prefix for API and UI endpoints. This allows thanos UI to be served on a sub-path. Defaults to the value of --web.external-prefix. This option is analogous to --web.route-prefix of Prometheus.").Default("").String()
 	webExternalPrefix := cmd.Flag("web.external-prefix", "Static prefix for all HTML links and redirect URLs in the UI query web interface. Actual endpoints are still served on / or the web.route-prefix. This allows thanos UI to be served behind a reverse proxy that strips a URL sub-path.").Default("").String()
@@ -164,6 +176,8 @@ func registerQuery(app *extkingpin.App) {
 	strictEndpointGroups := extkingpin.Addrs(cmd.Flag("endpoint-group-strict", "Experimental: DNS name of statically configured Thanos API server groups (repeatable) that are always used, even if the health check fails.").
 		PlaceHolder("<endpoint-group-strict>"))
 
+	endpointConfig := extflag.RegisterPathOrContent(cmd, "endpoint.config", "YAML file with the configuration of Thanos API servers, in addition to the ones given by the endpoint flags, allowing to override the gRPC compression per endpoint. See format details: https://thanos.io/tip/components/query.md/#endpoint-configuration", extflag.WithEnvSubstitution())
+
 	fileSDFiles := cmd.Flag("store.sd-files", "Path to files that contain addresses of store API servers. The path can be a glob pattern (repeatable).").
 		PlaceHolder("<path>").Strings()
 
@@ -198,7 +212,11 @@ func registerQuery(app *extkingpin.App) {
 
 	activeQueryDir := cmd.Flag("query.active-query-path", "Directory to log currently active queries in the queries.active file.").Default("").String()
 
-	featureList := cmd.Flag("enable-feature", "Comma separated experimental feature names to enable.The current list of features is "+queryPushdown+".").Default("").Strings()
+	tenantOverrides := overrides.NewManager()
+	tenantOverrides.RegisterFlags(cmd)
+	tenantOverrides.RegisterTenantFlags(cmd)
+
+	auditLogConfig := extflag.RegisterPathOrContent(cmd, "query.audit-log.config", "YAML file with the configuration of the audit log of queries, recording the tenant, user, expression, time range, status and fetched data of every query to a file or an HTTP endpoint. See format details: https://thanos.io/tip/components/query.md/#audit-log", extflag.WithEnvSubstitution())
 
 	enableExemplarPartialResponse := cmd.Flag("exemplar.partial-response", "Enable partial response for exemplar endpoint. --no-exemplar.partial-response for disabling.").
 		Hidden().Default("true").Bool()
@@ -227,19 +245,6 @@ func registerQuery(app *extkingpin.App) {
 			return errors.Wrap(err, "parse federation labels")
 		}
 
-		var enableQueryPushdown bool
-		for _, feature := range *featureList {
-			if feature == queryPushdown {
-				enableQueryPushdown = true
-			}
-			if feature == promqlAtModifier {
-				level.Warn(logger).Log("msg", "This option for --enable-feature is now permanently enabled and therefore a no-op.", "option", promqlAtModifier)
-			}
-			if feature == promqlNegativeOffset {
-				level.Warn(logger).Log("msg", "This option for --enable-feature is now permanently enabled and therefore a no-op.", "option", promqlNegativeOffset)
-			}
-		}
-
 		httpLogOpts, err := logging.ParseHTTPOptions(*reqLogDecision, reqLogConfig)
 		if err != nil {
 			return errors.Wrap(err, "error while parsing config for request logging")
@@ -250,6 +255,25 @@ func registerQuery(app *extkingpin.App) {
 			return errors.Wrap(err, "error while parsing config for request logging")
 		}
 
+		httpAuth, err := newHTTPAuthenticator(logger, httpAuthConfig)
+		if err != nil {
+			return err
+		}
+
+		endpointConfigYAML, err := endpointConfig.Content()
+		if err != nil {
+			return err
+		}
+		endpointConfigs, err := parseEndpointsConfig(endpointConfigYAML)
+		if err != nil {
+			return err
+		}
+
+		auditLogConfigYAML, err := auditLogConfig.Content()
+		if err != nil {
+			return err
+		}
+
 		var fileSD *file.Discovery
 		if len(*fileSDFiles) > 0 {
 			conf := &file.SDConfig{
@@ -277,6 +301,7 @@ func registerQuery(app *extkingpin.App) {
 			grpcLogOpts,
 			tagOpts,
 			grpcServerConfig,
+			&healthCfg,
 			*grpcCompression,
 			*secure,
 			*skipVerify,
@@ -286,6 +311,7 @@ func registerQuery(app *extkingpin.App) {
 			*serverName,
 			*httpBindAddr,
 			*httpTLSConfig,
+			httpAuth,
 			time.Duration(*httpGracePeriod),
 			*webRoutePrefix,
 			*webExternalPrefix,
@@ -316,6 +342,7 @@ func registerQuery(app *extkingpin.App) {
 			*enableMetricMetadataPartialResponse,
 			*enableExemplarPartialResponse,
 			*activeQueryDir,
+			auditLogConfigYAML,
 			fileSD,
 			time.Duration(*dnsSDInterval),
 			*dnsSDResolver,
@@ -326,8 +353,8 @@ func registerQuery(app *extkingpin.App) {
 			*strictStores,
 			*strictEndpoints,
 			*strictEndpointGroups,
+			endpointConfigs,
 			*webDisableCORS,
-			enableQueryPushdown,
 			*alertQueryURL,
 			*grpcProxyStrategy,
 			component.Query,
@@ -337,6 +364,9 @@ func registerQuery(app *extkingpin.App) {
 			*defaultEngine,
 			storeRateLimits,
 			queryMode(*promqlQueryMode),
+			runtimeConfig,
+			featureGates,
+			tenantOverrides,
 		)
 	})
 }
@@ -353,6 +383,7 @@ func runQuery(
 	grpcLogOpts []grpc_logging.Option,
 	tagOpts []tags.Option,
 	grpcServerConfig grpcConfig,
+	healthCfg *healthConfig,
 	grpcCompression string,
 	secure bool,
 	skipVerify bool,
@@ -362,6 +393,7 @@ func runQuery(
 	serverName string,
 	httpBindAddr string,
 	httpTLSConfig string,
+	httpAuth *middleware.Authenticator,
 	httpGracePeriod time.Duration,
 	webRoutePrefix string,
 	webExternalPrefix string,
@@ -392,6 +424,7 @@ func runQuery(
 	enableMetricMetadataPartialResponse bool,
 	enableExemplarPartialResponse bool,
 	activeQueryDir string,
+	auditLogConfigYAML []byte,
 	fileSD *file.Discovery,
 	dnsSDInterval time.Duration,
 	dnsSDResolver string,
@@ -402,8 +435,8 @@ func runQuery(
 	strictStores []string,
 	strictEndpoints []string,
 	strictEndpointGroups []string,
+	endpointConfigs []endpointConfig,
 	disableCORS bool,
-	enableQueryPushdown bool,
 	alertQueryURL string,
 	grpcProxyStrategy string,
 	comp component.Component,
@@ -413,6 +446,9 @@ func runQuery(
 	defaultEngine string,
 	storeRateLimits store.SeriesSelectLimits,
 	queryMode queryMode,
+	runtimeConfig *runtimeconfig.Manager,
+	featureGates *featuregate.Gates,
+	tenantOverrides *overrides.Manager,
 ) error {
 	if alertQueryURL == "" {
 		lastColon := strings.LastIndex(httpBindAddr, ":")
@@ -484,6 +520,34 @@ func runQuery(
 		dns.ResolverType(dnsSDResolver),
 	)
 
+	configuredEndpoints := newConfiguredEndpoints(logger, reg, dns.ResolverType(dnsSDResolver), endpointConfigs)
+
+	// The tenant header of requests is set to their tenant by the resolver, even if it is not taken from the header.
+	tenantResolver, err := tenantOverrides.TenantResolver(httpAuth != nil)
+	if err != nil {
+		return err
+	}
+
+	var auditLog *audit.Logger
+	if len(auditLogConfigYAML) > 0 {
+		auditLog, err = audit.NewLogger(logger, reg, auditLogConfigYAML, tenantResolver.Header)
+		if err != nil {
+			return errors.Wrap(err, "creating query audit log")
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			auditLog.Run(ctx)
+			return nil
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	tenantOverrides, err = setupOverrides(g, logger, reg, tenantOverrides, comp)
+	if err != nil {
+		return err
+	}
+
 	options := []store.ProxyStoreOption{}
 	if debugLogging {
 		options = append(options, store.WithProxyStoreDebugLogging())
@@ -533,6 +597,8 @@ func runQuery(
 					specs = append(specs, spec)
 				}
 
+				specs = append(specs, configuredEndpoints.Specs()...)
+
 				return specs
 			},
 			dialOpts,
@@ -548,7 +614,7 @@ func runQuery(
 		queryableCreator = query.NewQueryableCreator(
 			logger,
 			extprom.WrapRegistererWithPrefix("thanos_query_", reg),
-			proxy,
+			tenantLimitedStore(reg, proxy, tenantOverrides),
 			maxConcurrentSelects,
 			queryTimeout,
 		)
@@ -633,6 +699,9 @@ func runQuery(
 					level.Error(logger).Log("msg", "failed to resolve addresses passed using endpoint flag", "err", err)
 
 				}
+				if err := configuredEndpoints.Resolve(resolveCtx); err != nil {
+					level.Error(logger).Log("msg", "failed to resolve addresses of the endpoint config", "err", err)
+				}
 				return nil
 			})
 		}, func(error) {
@@ -647,6 +716,11 @@ func runQuery(
 		grpcProbe,
 		prober.NewInstrumentation(comp, logger, extprom.WrapRegistererWithPrefix("thanos_", reg)),
 	)
+	deps, err := setupDependencies(g, logger, reg, healthCfg, httpProbe)
+	if err != nil {
+		return err
+	}
+	deps.Register("endpoints", endpoints.Check)
 
 	engineOpts := promql.EngineOpts{
 		Logger: logger,
@@ -704,7 +778,9 @@ func runQuery(
 		}
 
 		// Configure Request Logging for HTTP calls.
-		logMiddleware := logging.NewHTTPServerMiddleware(logger, httpLogOpts...)
+		logMiddleware := logging.NewHTTPServerMiddleware(logger, append(httpLogOpts, logging.WithTenantFunc(func(r *http.Request) string {
+			return r.Header.Get(tenantResolver.Header)
+		}))...)
 
 		ins := extpromhttp.NewInstrumentationMiddleware(reg, nil)
 		// TODO(bplotka in PR #513 review): pass all flags, not only the flags needed by prefix rewriting.
@@ -728,7 +804,9 @@ func runQuery(
 			enableTargetPartialResponse,
 			enableMetricMetadataPartialResponse,
 			enableExemplarPartialResponse,
-			enableQueryPushdown,
+			func() bool {
+				return featureGates.Enabled(featuregate.QueryPushdown)
+			},
 			queryReplicaLabels,
 			flagsMap,
 			defaultRangeQueryStep,
@@ -746,6 +824,7 @@ func runQuery(
 				queryTelemetrySamplesQuantiles,
 				queryTelemetrySeriesQuantiles,
 			),
+			auditLog,
 			reg,
 		)
 
@@ -755,8 +834,13 @@ func runQuery(
 			httpserver.WithListen(httpBindAddr),
 			httpserver.WithGracePeriod(httpGracePeriod),
 			httpserver.WithTLSConfig(httpTLSConfig),
+			httpserver.WithAuthenticator(httpAuth),
+			httpserver.WithTenant(tenantResolver),
 		)
 		srv.Handle("/", router)
+		srv.Handle(runtimeconfig.APIPath, runtimeConfig)
+		srv.Handle(featuregate.APIPath, featureGates)
+		srv.Handle(overrides.APIPath, tenantOverrides)
 
 		g.Add(func() error {
 			statusProber.Healthy()
@@ -801,7 +885,7 @@ func runQuery(
 
 		defaultEngineType := querypb.EngineType(querypb.EngineType_value[defaultEngine])
 		grpcAPI := apiv1.NewGRPCAPI(time.Now, queryReplicaLabels, queryableCreator, *engineFactory, defaultEngineType, lookbackDeltaCreator, instantDefaultMaxSourceResolution)
-		storeServer := store.NewLimitedStoreServer(store.NewInstrumentedStoreServer(reg, proxy), reg, storeRateLimits)
+		storeServer := store.NewDynamicLimitedStoreServer(store.NewInstrumentedStoreServer(reg, proxy), reg, tenantSeriesSelectLimits(tenantOverrides, storeRateLimits))
 		s := grpcserver.New(logger, reg, tracer, grpcLogOpts, tagOpts, comp, grpcProbe,
 			grpcserver.WithServer(apiv1.RegisterQueryServer(grpcAPI)),
 			grpcserver.WithServer(store.RegisterStoreServer(storeServer, logger)),
@@ -829,6 +913,20 @@ func runQuery(
 	return nil
 }
 
+// tenantLimitedStore returns the store of the queries of the querier, limiting their Series requests by the limits of
+// the per-tenant overrides, if any.
+func tenantLimitedStore(reg prometheus.Registerer, proxy *store.ProxyStore, tenantOverrides *overrides.Manager) storepb.StoreServer {
+	if tenantOverrides == nil {
+		return proxy
+	}
+	return store.NewTenantLimitedStoreServer(proxy, promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_query_selects_dropped_total",
+		Help: "Number of selects of queries that were dropped due to the per-tenant limits of the overrides.",
+	}, []string{"reason"}), func(tenant string) store.SeriesSelectLimits {
+		return overrideSeriesSelectLimits(tenantOverrides, tenant, store.SeriesSelectLimits{})
+	})
+}
+
 func removeDuplicateEndpointSpecs(logger log.Logger, duplicatedStores prometheus.Counter, specs []*query.GRPCEndpointSpec) []*query.GRPCEndpointSpec {
 	set := make(map[string]*query.GRPCEndpointSpec)
 	for _, spec := range specs {
@@ -0,0 +1,141 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/discovery/dns"
+	"github.com/thanos-io/thanos/pkg/extgrpc"
+	"github.com/thanos-io/thanos/pkg/extgrpc/snappy"
+	"github.com/thanos-io/thanos/pkg/extgrpc/zstd"
+	"github.com/thanos-io/thanos/pkg/extprom"
+	"github.com/thanos-io/thanos/pkg/query"
+)
+
+// endpointConfig is an endpoint of the querier given by the endpoint.config flag.
+type endpointConfig struct {
+	// Address is the address of the endpoint, which may be prefixed with 'dns+' or 'dnssrv+' like in the endpoint flag.
+	Address string `yaml:"address"`
+	// Strict endpoints are always used, even if their health check fails, like the ones of the endpoint-strict flag.
+	Strict bool `yaml:"strict"`
+	// Group endpoints are DNS names of endpoint groups, like the ones of the endpoint-group flag.
+	Group bool `yaml:"group"`
+	// Compression overrides the grpc-compression flag for the endpoint.
+	Compression string `yaml:"compression"`
+}
+
+type endpointsConfig struct {
+	Endpoints []endpointConfig `yaml:"endpoints"`
+}
+
+// parseEndpointsConfig parses and validates the content of the endpoint.config flag.
+func parseEndpointsConfig(content []byte) ([]endpointConfig, error) {
+	var cfg endpointsConfig
+	if err := yaml.UnmarshalStrict(content, &cfg); err != nil {
+		return nil, errors.Wrap(err, "parsing endpoint config YAML")
+	}
+
+	for _, ec := range cfg.Endpoints {
+		if ec.Address == "" {
+			return nil, errors.New("endpoint config: address must not be empty")
+		}
+		if ec.Strict && !ec.Group && dns.IsDynamicNode(ec.Address) {
+			return nil, errors.Errorf("endpoint config: %s is a dynamically specified endpoint i.e. it uses SD and that is not permitted for strict endpoints", ec.Address)
+		}
+		switch ec.Compression {
+		case "", compressionNone, snappy.Name, zstd.Name:
+		default:
+			return nil, errors.Errorf("endpoint config: unknown compression %q of endpoint %s, expected one of %s, %s or %s", ec.Compression, ec.Address, snappy.Name, zstd.Name, compressionNone)
+		}
+	}
+	return cfg.Endpoints, nil
+}
+
+// compressionDialOpts returns the dial options using the given gRPC compression, or none if it is empty.
+func compressionDialOpts(compression string) []grpc.DialOption {
+	switch compression {
+	case "":
+		return nil
+	case compressionNone:
+		// The identity compressor overrides the compressor of the default dial options.
+		return []grpc.DialOption{grpc.WithDefaultCallOptions(grpc.UseCompressor(encoding.Identity))}
+	default:
+		return []grpc.DialOption{grpc.WithDefaultCallOptions(grpc.UseCompressor(compression))}
+	}
+}
+
+// configuredEndpoints provides the endpoint specs of the endpoint config. Addresses of non-strict endpoints are
+// resolved by a DNS provider per compression, so that their specs keep the compression of their endpoint.
+type configuredEndpoints struct {
+	staticSpecs []*query.GRPCEndpointSpec
+
+	compressions []string
+	addrs        map[string][]string
+	providers    map[string]*dns.Provider
+}
+
+func newConfiguredEndpoints(logger log.Logger, reg prometheus.Registerer, resolverType dns.ResolverType, endpoints []endpointConfig) *configuredEndpoints {
+	ce := &configuredEndpoints{
+		addrs:     map[string][]string{},
+		providers: map[string]*dns.Provider{},
+	}
+	for _, ec := range endpoints {
+		dialOpts := compressionDialOpts(ec.Compression)
+		switch {
+		case ec.Group:
+			dialOpts = append(extgrpc.EndpointGroupGRPCOpts(), dialOpts...)
+			ce.staticSpecs = append(ce.staticSpecs, query.NewGRPCEndpointSpec(fmt.Sprintf("dns:///%s", ec.Address), ec.Strict, dialOpts...))
+		case ec.Strict:
+			ce.staticSpecs = append(ce.staticSpecs, query.NewGRPCEndpointSpec(ec.Address, true, dialOpts...))
+		default:
+			if _, ok := ce.providers[ec.Compression]; !ok {
+				compression := ec.Compression
+				if compression == "" {
+					compression = "default"
+				}
+				ce.compressions = append(ce.compressions, ec.Compression)
+				ce.providers[ec.Compression] = dns.NewProvider(
+					logger,
+					extprom.WrapRegistererWith(prometheus.Labels{"compression": compression}, extprom.WrapRegistererWithPrefix("thanos_query_endpoint_config_", reg)),
+					resolverType,
+				)
+			}
+			ce.addrs[ec.Compression] = append(ce.addrs[ec.Compression], ec.Address)
+		}
+	}
+	sort.Strings(ce.compressions)
+	return ce
+}
+
+// Resolve resolves the addresses of the non-strict endpoints.
+func (ce *configuredEndpoints) Resolve(ctx context.Context) error {
+	for _, compression := range ce.compressions {
+		if err := ce.providers[compression].Resolve(ctx, ce.addrs[compression]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Specs returns the specs of the strict and group endpoints, and of the resolved addresses of the other ones.
+func (ce *configuredEndpoints) Specs() []*query.GRPCEndpointSpec {
+	specs := append([]*query.GRPCEndpointSpec{}, ce.staticSpecs...)
+	for _, compression := range ce.compressions {
+		dialOpts := compressionDialOpts(compression)
+		for _, addr := range ce.providers[compression].Addresses() {
+			specs = append(specs, query.NewGRPCEndpointSpec(addr, false, dialOpts...))
+		}
+	}
+	return specs
+}
@@ -4,8 +4,10 @@
 package main
 
 import (
+	"context"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/NYTimes/gziphandler"
@@ -25,12 +27,17 @@ import (
 	cortexvalidation "github.com/thanos-io/thanos/internal/cortex/util/validation"
 	"github.com/thanos-io/thanos/pkg/api"
 	"github.com/thanos-io/thanos/pkg/component"
+	"github.com/thanos-io/thanos/pkg/diagnostics"
 	"github.com/thanos-io/thanos/pkg/extkingpin"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
 	"github.com/thanos-io/thanos/pkg/logging"
+	"github.com/thanos-io/thanos/pkg/memlimit"
+	"github.com/thanos-io/thanos/pkg/overrides"
 	"github.com/thanos-io/thanos/pkg/prober"
 	"github.com/thanos-io/thanos/pkg/queryfrontend"
+	"github.com/thanos-io/thanos/pkg/runtimeconfig"
+	"github.com/thanos-io/thanos/pkg/runutil"
 	httpserver "github.com/thanos-io/thanos/pkg/server/http"
 	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 	"github.com/thanos-io/thanos/pkg/tracing"
@@ -38,14 +45,18 @@ import (
 
 type queryFrontendConfig struct {
 	http           httpConfig
+	health         healthConfig
 	webDisableCORS bool
 	queryfrontend.Config
 	orgIdHeaders []string
 }
 
-func registerQueryFrontend(app *extkingpin.App) {
+func registerQueryFrontend(app *extkingpin.App, runtimeConfig *runtimeconfig.Manager, memLimit *memlimit.Flags, diagnosticsConfig *diagnostics.Flags) {
 	comp := component.QueryFrontend
 	cmd := app.Command(comp.String(), "Query frontend command implements a service deployed in front of queriers to improve query parallelization and caching.")
+	runtimeConfig.RegisterFlags(cmd)
+	memLimit.RegisterFlags(cmd)
+	diagnosticsConfig.RegisterFlags(cmd)
 	cfg := &queryFrontendConfig{
 		Config: queryfrontend.Config{
 			// Max body size is 10 MiB.
@@ -62,6 +73,7 @@ func registerQueryFrontend(app *extkingpin.App) {
 	}
 
 	cfg.http.registerFlag(cmd)
+	cfg.health.registerFlag(cmd)
 
 	cmd.Flag("web.disable-cors", "Whether to disable CORS headers to be set by Thanos. By default Thanos sets CORS headers to be allowed by all.").
 		Default("false").BoolVar(&cfg.webDisableCORS)
@@ -146,6 +158,10 @@ func registerQueryFrontend(app *extkingpin.App) {
 
 	cmd.Flag("query-frontend.forward-header", "List of headers forwarded by the query-frontend to downstream queriers, default is empty").PlaceHolder("<http-header-name>").StringsVar(&cfg.ForwardHeaders)
 
+	cfg.Overrides = overrides.NewManager()
+	cfg.Overrides.RegisterFlags(cmd)
+	cfg.Overrides.RegisterTenantFlags(cmd)
+
 	cmd.Flag("query-frontend.vertical-shards", "Number of shards to use when distributing shardable PromQL queries. For more details, you can refer to the Vertical query sharding proposal: https://thanos.io/tip/proposals-accepted/202205-vertical-query-sharding.md").IntVar(&cfg.NumShards)
 
 	cmd.Flag("log.request.decision", "Deprecation Warning - This flag would be soon deprecated, and replaced with `request.logging-config`. Request Logging for logging the start and end of requests. By default this flag is disabled. LogFinishCall : Logs the finish call of the requests. LogStartAndFinishCall : Logs the start and finish call of the requests. NoLogCall : Disable request logging.").Default("").EnumVar(&cfg.RequestLoggingDecision, "NoLogCall", "LogFinishCall", "LogStartAndFinishCall", "")
@@ -157,10 +173,32 @@ func registerQueryFrontend(app *extkingpin.App) {
 			return errors.Wrap(err, "error while parsing config for request logging")
 		}
 
-		return runQueryFrontend(g, logger, reg, tracer, httpLogOpts, cfg, comp)
+		return runQueryFrontend(g, logger, reg, tracer, httpLogOpts, cfg, comp, runtimeConfig)
 	})
 }
 
+// downstreamCheck returns a check of the readiness of the downstream queriers.
+func downstreamCheck(downstreamURL string, rt http.RoundTripper) prober.DependencyCheck {
+	client := &http.Client{Transport: rt}
+	readyURL := strings.TrimSuffix(downstreamURL, "/") + "/-/ready"
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, readyURL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer runutil.ExhaustCloseWithLogOnErr(log.NewNopLogger(), resp.Body, "downstream readiness response")
+
+		if resp.StatusCode/100 != 2 {
+			return errors.Errorf("downstream is not ready, %s returned %s", readyURL, resp.Status)
+		}
+		return nil
+	}
+}
+
 func parseTransportConfiguration(downstreamTripperConfContentYaml []byte) (*http.Transport, error) {
 	downstreamTripper := &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
@@ -216,6 +254,7 @@ func runQueryFrontend(
 	httpLogOpts []logging.Option,
 	cfg *queryFrontendConfig,
 	comp component.Component,
+	runtimeConfig *runtimeconfig.Manager,
 ) error {
 	queryRangeCacheConfContentYaml, err := cfg.QueryRangeConfig.CachePathOrContent.Content()
 	if err != nil {
@@ -251,6 +290,19 @@ func runQueryFrontend(
 		return errors.Wrap(err, "error validating the config")
 	}
 
+	httpAuth, err := newHTTPAuthenticator(logger, cfg.http.authConfig)
+	if err != nil {
+		return err
+	}
+	tenantResolver, err := cfg.Overrides.TenantResolver(httpAuth != nil)
+	if err != nil {
+		return err
+	}
+	cfg.Overrides, err = setupOverrides(g, logger, reg, cfg.Overrides, comp)
+	if err != nil {
+		return err
+	}
+
 	tripperWare, err := queryfrontend.NewTripperware(cfg.Config, reg, logger)
 	if err != nil {
 		return errors.Wrap(err, "setup tripperwares")
@@ -285,9 +337,16 @@ func runQueryFrontend(
 		httpProbe,
 		prober.NewInstrumentation(comp, logger, extprom.WrapRegistererWithPrefix("thanos_", reg)),
 	)
+	deps, err := setupDependencies(g, logger, reg, &cfg.health, httpProbe)
+	if err != nil {
+		return err
+	}
+	deps.Register("downstream", downstreamCheck(cfg.DownstreamURL, downstreamTripper))
 
 	// Configure Request Logging for HTTP calls.
-	logMiddleware := logging.NewHTTPServerMiddleware(logger, httpLogOpts...)
+	logMiddleware := logging.NewHTTPServerMiddleware(logger, append(httpLogOpts, logging.WithTenantFunc(func(r *http.Request) string {
+		return extractOrgId(cfg, r)
+	}))...)
 	ins := extpromhttp.NewInstrumentationMiddleware(reg, nil)
 
 	// Start metrics HTTP server.
@@ -296,11 +355,18 @@ func runQueryFrontend(
 			httpserver.WithListen(cfg.http.bindAddress),
 			httpserver.WithGracePeriod(time.Duration(cfg.http.gracePeriod)),
 			httpserver.WithTLSConfig(cfg.http.tlsConfig),
+			httpserver.WithAuthenticator(httpAuth),
+			httpserver.WithTenant(tenantResolver),
 		)
 
 		instr := func(f http.HandlerFunc) http.HandlerFunc {
 			hf := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				orgId := extractOrgId(cfg, r)
+				if tenant, ok := middleware.TenantFromContext(r.Context()); ok && cfg.Overrides != nil {
+					// The limits of the overrides are looked up by org ID, which must be the tenant of the
+					// request for queries to get the limits of the tenant identified by queriers.
+					orgId = tenant
+				}
 				name := "query-frontend"
 				if !cfg.webDisableCORS {
 					api.SetCORS(w)
@@ -323,6 +389,8 @@ func runQueryFrontend(
 			return hf
 		}
 		srv.Handle("/", instr(handler.ServeHTTP))
+		srv.Handle(runtimeconfig.APIPath, runtimeConfig)
+		srv.Handle(overrides.APIPath, cfg.Overrides)
 
 		g.Add(func() error {
 			statusProber.Healthy()
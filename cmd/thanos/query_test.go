@@ -4,9 +4,13 @@
 package main
 
 import (
+	"context"
 	"testing"
 	"time"
 
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
 	"github.com/prometheus/prometheus/promql"
 
 	"github.com/efficientgo/core/testutil"
@@ -94,3 +98,56 @@ func TestLookbackDeltaFactory(t *testing.T) {
 		}
 	}
 }
+
+func TestParseEndpointsConfig(t *testing.T) {
+	endpoints, err := parseEndpointsConfig([]byte(`
+endpoints:
+  - address: store-1:10901
+    compression: zstd
+  - address: dns+store-2:10901
+  - address: store-3:10901
+    strict: true
+    compression: none
+  - address: receive:10901
+    group: true
+    strict: true
+`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, []endpointConfig{
+		{Address: "store-1:10901", Compression: "zstd"},
+		{Address: "dns+store-2:10901"},
+		{Address: "store-3:10901", Strict: true, Compression: "none"},
+		{Address: "receive:10901", Group: true, Strict: true},
+	}, endpoints)
+
+	endpoints, err = parseEndpointsConfig(nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(endpoints))
+
+	for _, content := range []string{
+		`endpoints: [{compression: zstd}]`,
+		`endpoints: [{address: "store:10901", compression: gzip}]`,
+		`endpoints: [{address: "dns+store:10901", strict: true}]`,
+		`endpoints: [{address: "store:10901", unknown: true}]`,
+	} {
+		_, err := parseEndpointsConfig([]byte(content))
+		testutil.NotOk(t, err, content)
+	}
+}
+
+func TestConfiguredEndpoints(t *testing.T) {
+	ce := newConfiguredEndpoints(log.NewNopLogger(), prometheus.NewRegistry(), "", []endpointConfig{
+		{Address: "store-1:10901", Compression: "zstd"},
+		{Address: "store-2:10901"},
+		{Address: "store-3:10901", Compression: "zstd"},
+		{Address: "store-4:10901", Strict: true, Compression: "none"},
+		{Address: "receive:10901", Group: true},
+	})
+	testutil.Ok(t, ce.Resolve(context.Background()))
+
+	var addrs []string
+	for _, spec := range ce.Specs() {
+		addrs = append(addrs, spec.Addr())
+	}
+	testutil.Equals(t, []string{"store-4:10901", "dns:///receive:10901", "store-2:10901", "store-1:10901", "store-3:10901"}, addrs)
+}
@@ -12,6 +12,7 @@ import (
 
 	"google.golang.org/grpc"
 
+	"github.com/alecthomas/units"
 	extflag "github.com/efficientgo/tools/extkingpin"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
@@ -27,21 +28,28 @@ import (
 	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/thanos-io/objstore"
-	"github.com/thanos-io/objstore/client"
+	"go.uber.org/atomic"
 	"gopkg.in/yaml.v2"
 
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 	"github.com/thanos-io/thanos/pkg/component"
+	"github.com/thanos-io/thanos/pkg/diagnostics"
 	"github.com/thanos-io/thanos/pkg/exemplars"
 	"github.com/thanos-io/thanos/pkg/extgrpc"
 	"github.com/thanos-io/thanos/pkg/extgrpc/snappy"
+	"github.com/thanos-io/thanos/pkg/extgrpc/zstd"
 	"github.com/thanos-io/thanos/pkg/extkingpin"
+	"github.com/thanos-io/thanos/pkg/extobjstore"
 	"github.com/thanos-io/thanos/pkg/extprom"
+	"github.com/thanos-io/thanos/pkg/featuregate"
 	"github.com/thanos-io/thanos/pkg/info"
 	"github.com/thanos-io/thanos/pkg/info/infopb"
 	"github.com/thanos-io/thanos/pkg/logging"
+	"github.com/thanos-io/thanos/pkg/memlimit"
+	"github.com/thanos-io/thanos/pkg/overrides"
 	"github.com/thanos-io/thanos/pkg/prober"
 	"github.com/thanos-io/thanos/pkg/receive"
+	"github.com/thanos-io/thanos/pkg/runtimeconfig"
 	"github.com/thanos-io/thanos/pkg/runutil"
 	grpcserver "github.com/thanos-io/thanos/pkg/server/grpc"
 	httpserver "github.com/thanos-io/thanos/pkg/server/http"
@@ -52,8 +60,12 @@ import (
 
 const compressionNone = "none"
 
-func registerReceive(app *extkingpin.App) {
+func registerReceive(app *extkingpin.App, runtimeConfig *runtimeconfig.Manager, featureGates *featuregate.Gates, memLimit *memlimit.Flags, diagnosticsConfig *diagnostics.Flags) {
 	cmd := app.Command(component.Receive.String(), "Accept Prometheus remote write API requests and write to local tsdb.")
+	runtimeConfig.RegisterFlags(cmd)
+	memLimit.RegisterFlags(cmd)
+	diagnosticsConfig.RegisterFlags(cmd)
+	featureGates.RegisterFlags(cmd)
 
 	conf := &receiveConfig{}
 	conf.registerFlag(cmd)
@@ -70,11 +82,21 @@ func registerReceive(app *extkingpin.App) {
 		if len(lset) == 0 {
 			return errors.New("no external labels configured for receive, uniquely identifying external labels must be configured (ideally with `receive_` prefix); see https://thanos.io/tip/thanos/storage.md#external-labels for details.")
 		}
+		if conf.replicationStreamQueueSize <= 0 {
+			return errors.Errorf("replication stream queue size must be positive, got %d", conf.replicationStreamQueueSize)
+		}
+		if conf.replicationStreamBatchSize <= 0 {
+			return errors.Errorf("replication stream batch size must be positive, got %d", conf.replicationStreamBatchSize)
+		}
 
 		tagOpts, grpcLogOpts, err := logging.ParsegRPCOptions("", conf.reqLogConfig)
 		if err != nil {
 			return errors.Wrap(err, "error while parsing config for request logging")
 		}
+		httpLogOpts, err := logging.ParseHTTPOptions("", conf.reqLogConfig)
+		if err != nil {
+			return errors.Wrap(err, "error while parsing config for request logging")
+		}
 
 		tsdbOpts := &tsdb.Options{
 			MinBlockDuration:               int64(time.Duration(*conf.tsdbMinBlockDuration) / time.Millisecond),
@@ -88,7 +110,7 @@ func registerReceive(app *extkingpin.App) {
 			EnableExemplarStorage:          conf.tsdbMaxExemplars > 0,
 			HeadChunksWriteQueueSize:       int(conf.tsdbWriteQueueSize),
 			EnableMemorySnapshotOnShutdown: conf.tsdbMemorySnapshotOnShutdown,
-			EnableNativeHistograms:         conf.tsdbEnableNativeHistograms,
+			EnableNativeHistograms:         conf.tsdbEnableNativeHistograms || featureGates.Enabled(featuregate.NativeHistograms),
 		}
 
 		// Are we running in IngestorOnly, RouterOnly or RouterIngestor mode?
@@ -101,12 +123,15 @@ func registerReceive(app *extkingpin.App) {
 			reg,
 			tracer,
 			grpcLogOpts, tagOpts,
+			httpLogOpts,
 			tsdbOpts,
 			lset,
 			component.Receive,
 			metadata.HashFunc(conf.hashFunc),
 			receiveMode,
 			conf,
+			runtimeConfig,
+			featureGates,
 		)
 	})
 }
@@ -119,12 +144,15 @@ func runReceive(
 	tracer opentracing.Tracer,
 	grpcLogOpts []grpc_logging.Option,
 	tagOpts []tags.Option,
+	httpLogOpts []logging.Option,
 	tsdbOpts *tsdb.Options,
 	lset labels.Labels,
 	comp component.SourceStoreAPI,
 	hashFunc metadata.HashFunc,
 	receiveMode receive.ReceiverMode,
 	conf *receiveConfig,
+	runtimeConfig *runtimeconfig.Manager,
+	featureGates *featuregate.Gates,
 ) error {
 	logger = log.With(logger, "component", "receive")
 
@@ -174,7 +202,7 @@ func runReceive(
 			}
 			// The background shipper continuously scans the data directory and uploads
 			// new blocks to object storage service.
-			bkt, err = client.NewBucket(logger, confContentYaml, reg, comp.String())
+			bkt, err = extobjstore.NewBucket(logger, confContentYaml, reg, comp.String())
 			if err != nil {
 				return err
 			}
@@ -185,8 +213,11 @@ func runReceive(
 
 	// TODO(brancz): remove after a couple of versions
 	// Migrate non-multi-tsdb capable storage to multi-tsdb disk layout.
-	if err := migrateLegacyStorage(logger, conf.dataDir, conf.defaultTenantID); err != nil {
-		return errors.Wrapf(err, "migrate legacy storage in %v to default tenant %v", conf.dataDir, conf.defaultTenantID)
+	// Routing-only receivers never open a TSDB, so they leave the data directory untouched.
+	if enableIngestion {
+		if err := migrateLegacyStorage(logger, conf.dataDir, conf.defaultTenantID); err != nil {
+			return errors.Wrapf(err, "migrate legacy storage in %v to default tenant %v", conf.dataDir, conf.defaultTenantID)
+		}
 	}
 
 	relabelContentYaml, err := conf.relabelConfigPath.Content()
@@ -198,6 +229,121 @@ func runReceive(
 		return errors.Wrap(err, "parse relabel configuration")
 	}
 
+	tenantJWTContentYaml, err := conf.tenantJWTConfig.Content()
+	if err != nil {
+		return errors.Wrap(err, "get content of tenant JWT configuration")
+	}
+	var tenantJWT *receive.TenantJWTValidator
+	if len(tenantJWTContentYaml) > 0 {
+		if conf.tenantField != "" {
+			return errors.New("tenant can not be determined from both the client certificate and the JWT bearer token")
+		}
+		tenantJWT, err = receive.NewTenantJWTValidator(tenantJWTContentYaml)
+		if err != nil {
+			return errors.Wrap(err, "parse tenant JWT configuration")
+		}
+	}
+
+	// The HTTP authentication applies to the remote write endpoint as well.
+	httpAuth, err := newHTTPAuthenticator(logger, conf.httpAuthConfig)
+	if err != nil {
+		return err
+	}
+
+	tenantMappingContentYaml, err := conf.tenantMappingConfig.Content()
+	if err != nil {
+		return errors.Wrap(err, "get content of tenant mapping configuration")
+	}
+	var tenantMapping map[string]string
+	if len(tenantMappingContentYaml) > 0 {
+		if conf.tenantField == "" && tenantJWT == nil {
+			return errors.New("tenant mapping requires the tenant to be determined from the client certificate or the JWT bearer token")
+		}
+		if err := yaml.UnmarshalStrict(tenantMappingContentYaml, &tenantMapping); err != nil {
+			return errors.Wrap(err, "parse tenant mapping configuration")
+		}
+	}
+
+	mirrorContentYaml, err := conf.mirrorConfig.Content()
+	if err != nil {
+		return errors.Wrap(err, "get content of mirror configuration")
+	}
+	var mirror *receive.Mirror
+	if len(mirrorContentYaml) > 0 {
+		mirrorConfigs, err := receive.ParseMirrorConfigs(mirrorContentYaml)
+		if err != nil {
+			return errors.Wrap(err, "parse mirror configuration")
+		}
+		mirror, err = receive.NewMirror(log.With(logger, "component", "receive-mirror"), reg, mirrorConfigs)
+		if err != nil {
+			return errors.Wrap(err, "create mirror")
+		}
+	}
+
+	var usageBkt objstore.Bucket
+	if *conf.usageReportInterval > 0 {
+		switch {
+		case bkt != nil:
+			usageBkt = bkt
+		case len(confContentYaml) > 0:
+			// Routing receivers do not upload blocks, so they have no bucket yet.
+			usageBkt, err = extobjstore.NewBucket(logger, confContentYaml, reg, comp.String())
+			if err != nil {
+				return err
+			}
+		default:
+			return errors.New("tenant usage reports require object storage to be configured")
+		}
+	}
+	tenantUsage := receive.NewTenantUsage(log.With(logger, "component", "receive-usage"), reg, usageBkt, lset)
+	if usageBkt != nil {
+		if err := tenantUsage.Restore(context.Background()); err != nil {
+			return errors.Wrap(err, "restore tenant usage")
+		}
+	}
+
+	tenantWriteContentYaml, err := conf.tenantWriteConfigPath.Content()
+	if err != nil {
+		return errors.Wrap(err, "get content of tenant write configuration")
+	}
+	var tenantWriteConfig *receive.TenantWriteConfig
+	if len(tenantWriteContentYaml) > 0 {
+		tenantWriteConfig, err = receive.ParseTenantWriteConfig(tenantWriteContentYaml)
+		if err != nil {
+			return errors.Wrap(err, "parse tenant write configuration")
+		}
+	}
+
+	tenantOutOfOrderWindows, err := parseFlagTenantDurations(conf.tsdbTenantOutOfOrderWindows)
+	if err != nil {
+		return errors.Wrap(err, "parse tenant out-of-order time windows")
+	}
+	tenantRetentions, err := parseFlagTenantDurations(conf.tsdbTenantRetentions)
+	if err != nil {
+		return errors.Wrap(err, "parse tenant retentions")
+	}
+	tenantBlockDurations, err := parseFlagTenantDurations(conf.tsdbTenantBlockDurations)
+	if err != nil {
+		return errors.Wrap(err, "parse tenant block durations")
+	}
+	for tenant, d := range tenantBlockDurations {
+		if d <= 0 {
+			return errors.Errorf("block duration of tenant %s must be positive", tenant)
+		}
+	}
+	tenantUploadIntervals, err := parseFlagTenantDurations(conf.shipperTenantUploadIntervals)
+	if err != nil {
+		return errors.Wrap(err, "parse tenant upload intervals")
+	}
+	tenantOverrides, err := setupOverrides(g, logger, reg, conf.overrides, comp)
+	if err != nil {
+		return err
+	}
+	uploadIntervals := make(map[string]time.Duration, len(tenantUploadIntervals))
+	for tenant, d := range tenantUploadIntervals {
+		uploadIntervals[tenant] = time.Duration(d) * time.Millisecond
+	}
+
 	dbs := receive.NewMultiTSDB(
 		conf.dataDir,
 		logger,
@@ -208,46 +354,108 @@ func runReceive(
 		bkt,
 		conf.allowOutOfOrderUpload,
 		hashFunc,
+		receive.WithTenantOutOfOrderTimeWindows(tenantOutOfOrderWindows),
+		receive.WithTenantNativeHistograms(conf.tsdbNativeHistogramsTenants...),
+		receive.WithTenantRetentions(tenantRetentions),
+		receive.WithTenantBlockDurations(tenantBlockDurations),
+		receive.WithTenantUploadIntervals(uploadIntervals),
+		receive.WithTenantHeadSeriesLimit(conf.tsdbMaxTenantHeadSeries),
+		receive.WithTenantWALSizeLimit(int64(conf.tsdbMaxTenantWALSize)),
+		receive.WithOverrides(tenantOverrides),
 	)
+	// The --tsdb.enable-native-histograms flag keeps native histograms enabled regardless of the feature gate.
+	featureGates.OnChange(featuregate.NativeHistograms, func(enabled bool) {
+		dbs.SetNativeHistograms(enabled || conf.tsdbEnableNativeHistograms)
+	})
+	var snapshotter receive.TSDBSnapshotter
+	if conf.enableAdminAPI || conf.restoreSnapshot != "" {
+		if !enableIngestion || bkt == nil {
+			return errors.New("TSDB snapshots require ingestion and object storage to be configured")
+		}
+		if conf.enableAdminAPI {
+			snapshotter = dbs
+		}
+	}
+	if conf.restoreSnapshot != "" {
+		level.Info(logger).Log("msg", "restoring TSDB snapshot", "snapshot", conf.restoreSnapshot)
+		restored, err := dbs.RestoreSnapshot(context.Background(), conf.restoreSnapshot)
+		if err != nil {
+			return errors.Wrap(err, "restore TSDB snapshot")
+		}
+		level.Info(logger).Log("msg", "restored TSDB snapshot", "snapshot", conf.restoreSnapshot, "tenants", strings.Join(restored, ","))
+	}
+
 	writer := receive.NewWriter(log.With(logger, "component", "receive-writer"), dbs, &receive.WriterOptions{
 		Intern:                   conf.writerInterning,
 		TooFarInFutureTimeWindow: int64(time.Duration(*conf.tsdbTooFarInFutureTimeWindow)),
 	})
 
-	var limitsConfig *receive.RootLimitsConfig
-	if conf.writeLimitsConfig != nil {
+	var limitsContent interface {
+		Content() ([]byte, error)
+		Path() string
+	} = conf.writeLimitsConfig
+	var limitsBkt objstore.Bucket
+	limitsBucketConfYAML, err := conf.writeLimitsBucketConfig.Content()
+	if err != nil {
+		return errors.Wrap(err, "get content of limit configuration bucket")
+	}
+	if len(limitsBucketConfYAML) > 0 {
 		limitsContentYaml, err := conf.writeLimitsConfig.Content()
 		if err != nil {
 			return errors.Wrap(err, "get content of limit configuration")
 		}
-		limitsConfig, err = receive.ParseRootLimitConfig(limitsContentYaml)
+		if len(limitsContentYaml) > 0 {
+			return errors.New("the limit configuration can be loaded either from --receive.limits-config or from --receive.limits-config-bucket, not both")
+		}
+		limitsBkt, err = extobjstore.NewBucket(logger, limitsBucketConfYAML, nil, comp.String())
 		if err != nil {
-			return errors.Wrap(err, "parse limit configuration")
+			return errors.Wrap(err, "create limit configuration bucket")
 		}
+		limitsContent = receive.NewBucketConfigContent(limitsBkt, conf.writeLimitsObject, conf.writeLimitsReloadInterval)
+	}
+
+	limitsConfig, err := receive.ParseLimitConfigContent(limitsContent)
+	if err != nil {
+		return err
 	}
-	limiter, err := receive.NewLimiter(conf.writeLimitsConfig, reg, receiveMode, log.With(logger, "component", "receive-limiter"))
+	limiter, err := receive.NewLimiter(limitsContent, reg, receiveMode, log.With(logger, "component", "receive-limiter"))
 	if err != nil {
 		return errors.Wrap(err, "creating limiter")
 	}
 
 	webHandler := receive.NewHandler(log.With(logger, "component", "receive-handler"), &receive.Options{
-		Writer:            writer,
-		ListenAddress:     conf.rwAddress,
-		Registry:          reg,
-		Endpoint:          conf.endpoint,
-		TenantHeader:      conf.tenantHeader,
-		TenantField:       conf.tenantField,
-		DefaultTenantID:   conf.defaultTenantID,
-		ReplicaHeader:     conf.replicaHeader,
-		ReplicationFactor: conf.replicationFactor,
-		RelabelConfigs:    relabelConfig,
-		ReceiverMode:      receiveMode,
-		Tracer:            tracer,
-		TLSConfig:         rwTLSConfig,
-		DialOpts:          dialOpts,
-		ForwardTimeout:    time.Duration(*conf.forwardTimeout),
-		TSDBStats:         dbs,
-		Limiter:           limiter,
+		Writer:                        writer,
+		ListenAddress:                 conf.rwAddress,
+		Registry:                      reg,
+		Endpoint:                      conf.endpoint,
+		TenantHeader:                  conf.tenantHeader,
+		TenantField:                   conf.tenantField,
+		TenantJWT:                     tenantJWT,
+		TenantMapping:                 tenantMapping,
+		DefaultTenantID:               conf.defaultTenantID,
+		ReplicaHeader:                 conf.replicaHeader,
+		ReplicationFactor:             conf.replicationFactor,
+		WriteConsistency:              receive.WriteConsistency(conf.writeConsistency),
+		RelabelConfigs:                relabelConfig,
+		TenantWriteConfig:             tenantWriteConfig,
+		Mirror:                        mirror,
+		TenantUsage:                   tenantUsage,
+		ReceiverMode:                  receiveMode,
+		Tracer:                        tracer,
+		TLSConfig:                     rwTLSConfig,
+		DialOpts:                      dialOpts,
+		ForwardTimeout:                time.Duration(*conf.forwardTimeout),
+		TSDBStats:                     dbs,
+		Limiter:                       limiter,
+		OTLPTenantAttribute:           conf.otlpTenantAttribute,
+		ReplicationProtocol:           receive.ReplicationProtocol(conf.replicationProtocol),
+		ReplicationStreamQueueSize:    conf.replicationStreamQueueSize,
+		ReplicationStreamBatchSize:    conf.replicationStreamBatchSize,
+		HashringTransitionWindow:      time.Duration(*conf.hashringTransitionWindow),
+		HashringTransitionMaxBuffered: conf.hashringTransitionMaxBuffered,
+		ReplicationAbortOnQuorum:      conf.replicationAbortOnQuorum,
+		Authenticator:                 httpAuth,
+		HTTPLoggingOptions:            httpLogOpts,
 	})
 
 	grpcProbe := prober.NewGRPC()
@@ -257,6 +465,13 @@ func runReceive(
 		grpcProbe,
 		prober.NewInstrumentation(comp, logger, extprom.WrapRegistererWithPrefix("thanos_", reg)),
 	)
+	deps, err := setupDependencies(g, logger, reg, &conf.healthConfig, httpProbe)
+	if err != nil {
+		return err
+	}
+	if bkt != nil {
+		deps.Register("objstore", objStoreCheck(bkt))
+	}
 
 	// Start all components while we wait for TSDB to open but only load
 	// initial config and mark ourselves as ready after it completes.
@@ -272,7 +487,7 @@ func runReceive(
 
 		level.Debug(logger).Log("msg", "setting up TSDB")
 		{
-			if err := startTSDBAndUpload(g, logger, reg, dbs, uploadC, hashringChangedChan, upload, uploadDone, statusProber, bkt, receive.HashringAlgorithm(conf.hashringsAlgorithm)); err != nil {
+			if err := startTSDBAndUpload(g, logger, reg, dbs, uploadC, hashringChangedChan, upload, uploadDone, statusProber, bkt, receive.HashringAlgorithm(conf.hashringsAlgorithm), time.Duration(*conf.shutdownFlushTimeout)); err != nil {
 				return err
 			}
 		}
@@ -280,7 +495,7 @@ func runReceive(
 
 	level.Debug(logger).Log("msg", "setting up hashring")
 	{
-		if err := setupHashring(g, logger, reg, conf, hashringChangedChan, webHandler, statusProber, enableIngestion); err != nil {
+		if err := setupHashring(g, logger, reg, conf, hashringChangedChan, webHandler, statusProber, deps, enableIngestion); err != nil {
 			return err
 		}
 	}
@@ -291,7 +506,15 @@ func runReceive(
 			httpserver.WithListen(*conf.httpBindAddr),
 			httpserver.WithGracePeriod(time.Duration(*conf.httpGracePeriod)),
 			httpserver.WithTLSConfig(*conf.httpTLSConfig),
+			httpserver.WithAuthenticator(httpAuth),
 		)
+		srv.Handle(runtimeconfig.APIPath, runtimeConfig)
+		srv.Handle(featuregate.APIPath, featureGates)
+		srv.Handle(overrides.APIPath, tenantOverrides)
+		// The admin API is served on the HTTP server, behind its authentication, rather than on the remote write endpoint.
+		if snapshotter != nil {
+			srv.Handle(receive.SnapshotAPIPath, receive.NewSnapshotAPI(log.With(logger, "component", "snapshot-api"), snapshotter))
+		}
 		g.Add(func() error {
 			statusProber.Healthy()
 			return srv.ListenAndServe()
@@ -325,7 +548,7 @@ func runReceive(
 			store.LazyRetrieval,
 			options...,
 		)
-		mts := store.NewLimitedStoreServer(store.NewInstrumentedStoreServer(reg, proxy), reg, conf.storeRateLimits)
+		mts := store.NewDynamicLimitedStoreServer(store.NewInstrumentedStoreServer(reg, proxy), reg, tenantSeriesSelectLimits(tenantOverrides, conf.storeRateLimits))
 		rw := store.ReadWriteTSDBStore{
 			StoreServer:          mts,
 			WriteableStoreServer: webHandler,
@@ -388,11 +611,11 @@ func runReceive(
 	}
 
 	if limitsConfig.AreHeadSeriesLimitsConfigured() {
-		level.Info(logger).Log("msg", "setting up periodic (every 15s) meta-monitoring query for limiting cache")
+		level.Info(logger).Log("msg", "setting up periodic meta-monitoring query for limiting cache", "interval", receive.MetaMonitoringQueryInterval)
 		{
 			ctx, cancel := context.WithCancel(context.Background())
 			g.Add(func() error {
-				return runutil.Repeat(15*time.Second, ctx.Done(), func() error {
+				return runutil.Repeat(receive.MetaMonitoringQueryInterval, ctx.Done(), func() error {
 					if err := limiter.HeadSeriesLimiter.QueryMetaMonitoring(ctx); err != nil {
 						level.Error(logger).Log("msg", "failed to query meta-monitoring", "err", err.Error())
 					}
@@ -404,8 +627,29 @@ func runReceive(
 		}
 	}
 
-	level.Debug(logger).Log("msg", "setting up periodic tenant pruning")
-	{
+	if mirror != nil {
+		level.Debug(logger).Log("msg", "setting up mirroring to remote write endpoints")
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			mirror.Run(ctx)
+			return nil
+		}, func(err error) {
+			cancel()
+		})
+	}
+
+	if usageBkt != nil {
+		level.Debug(logger).Log("msg", "setting up periodic tenant usage reports")
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return tenantUsage.Run(ctx, time.Duration(*conf.usageReportInterval))
+		}, func(err error) {
+			cancel()
+		})
+	}
+
+	if enableIngestion {
+		level.Debug(logger).Log("msg", "setting up periodic tenant pruning")
 		ctx, cancel := context.WithCancel(context.Background())
 		g.Add(func() error {
 			return runutil.Repeat(2*time.Hour, ctx.Done(), func() error {
@@ -424,6 +668,9 @@ func runReceive(
 			ctx, cancel := context.WithCancel(context.Background())
 			g.Add(func() error {
 				level.Debug(logger).Log("msg", "limits config initialized with file watcher.")
+				if limitsBkt != nil {
+					defer runutil.CloseWithLogOnErr(logger, limitsBkt, "limit configuration bucket")
+				}
 				if err := limiter.StartConfigReloader(ctx); err != nil {
 					return err
 				}
@@ -448,6 +695,7 @@ func setupHashring(g *run.Group,
 	hashringChangedChan chan struct{},
 	webHandler *receive.Handler,
 	statusProber prober.Probe,
+	deps *prober.Dependencies,
 	enableIngestion bool,
 ) error {
 	// Note: the hashring configuration watcher
@@ -470,6 +718,7 @@ func setupHashring(g *run.Group,
 			close(updates)
 			return errors.Wrap(err, "failed to validate hashring configuration file")
 		}
+		deps.Register("hashring", cw.Check)
 
 		ctx, cancel := context.WithCancel(context.Background())
 		g.Add(func() error {
@@ -552,6 +801,7 @@ func startTSDBAndUpload(g *run.Group,
 	statusProber prober.Probe,
 	bkt objstore.Bucket,
 	hashringAlgorithm receive.HashringAlgorithm,
+	shutdownFlushTimeout time.Duration,
 ) error {
 
 	log.With(logger, "component", "storage")
@@ -569,6 +819,10 @@ func startTSDBAndUpload(g *run.Group,
 		return errors.Wrap(err, "remove storage lock files")
 	}
 
+	// shutdownDeadline bounds the final upload of the blocks flushed on shutdown, if set, in Unix nanoseconds.
+	// It is written by the storage routine on shutdown and read by the uploader before the final upload.
+	var shutdownDeadline atomic.Int64
+
 	// TSDBs reload logic, listening on hashring changes.
 	cancel := make(chan struct{})
 	g.Add(func() error {
@@ -577,6 +831,9 @@ func startTSDBAndUpload(g *run.Group,
 		// Before quitting, ensure the WAL is flushed and the DBs are closed.
 		defer func() {
 			level.Info(logger).Log("msg", "shutting down storage")
+			if shutdownFlushTimeout > 0 {
+				shutdownDeadline.Store(time.Now().Add(shutdownFlushTimeout).UnixNano())
+			}
 			if err := dbs.Flush(); err != nil {
 				level.Error(logger).Log("err", err, "msg", "failed to flush storage")
 			} else {
@@ -670,6 +927,9 @@ func startTSDBAndUpload(g *run.Group,
 					<-uploadC // Closed by storage routine when it's done.
 					level.Info(logger).Log("msg", "uploading the final cut block before exiting")
 					ctx, cancel := context.WithCancel(context.Background())
+					if deadline := shutdownDeadline.Load(); deadline != 0 {
+						ctx, cancel = context.WithDeadline(context.Background(), time.Unix(0, deadline))
+					}
 					uploaded, err := dbs.Sync(ctx)
 					if err != nil {
 						cancel()
@@ -750,8 +1010,10 @@ type receiveConfig struct {
 	httpBindAddr    *string
 	httpGracePeriod *model.Duration
 	httpTLSConfig   *string
+	httpAuthConfig  *extflag.PathOrContent
 
-	grpcConfig grpcConfig
+	grpcConfig   grpcConfig
+	healthConfig healthConfig
 
 	rwAddress          string
 	rwServerCert       string
@@ -772,27 +1034,48 @@ type receiveConfig struct {
 	hashringsFileContent string
 	hashringsAlgorithm   string
 
-	refreshInterval   *model.Duration
-	endpoint          string
-	tenantHeader      string
-	tenantField       string
-	tenantLabelName   string
-	defaultTenantID   string
-	replicaHeader     string
-	replicationFactor uint64
-	forwardTimeout    *model.Duration
-	compression       string
+	refreshInterval            *model.Duration
+	endpoint                   string
+	tenantHeader               string
+	tenantField                string
+	tenantJWTConfig            *extflag.PathOrContent
+	tenantMappingConfig        *extflag.PathOrContent
+	tenantLabelName            string
+	otlpTenantAttribute        string
+	defaultTenantID            string
+	replicaHeader              string
+	replicationFactor          uint64
+	writeConsistency           string
+	replicationProtocol        string
+	replicationStreamQueueSize int
+	replicationStreamBatchSize int
+	replicationAbortOnQuorum   bool
+	forwardTimeout             *model.Duration
+	shutdownFlushTimeout       *model.Duration
+	enableAdminAPI             bool
+	restoreSnapshot            string
+	usageReportInterval        *model.Duration
+
+	hashringTransitionWindow      *model.Duration
+	hashringTransitionMaxBuffered int
+	compression                   string
 
 	tsdbMinBlockDuration         *model.Duration
 	tsdbMaxBlockDuration         *model.Duration
 	tsdbTooFarInFutureTimeWindow *model.Duration
 	tsdbOutOfOrderTimeWindow     *model.Duration
 	tsdbOutOfOrderCapMax         int64
+	tsdbTenantOutOfOrderWindows  []string
 	tsdbAllowOverlappingBlocks   bool
 	tsdbMaxExemplars             int64
 	tsdbWriteQueueSize           int64
 	tsdbMemorySnapshotOnShutdown bool
 	tsdbEnableNativeHistograms   bool
+	tsdbNativeHistogramsTenants  []string
+	tsdbTenantRetentions         []string
+	tsdbTenantBlockDurations     []string
+	tsdbMaxTenantHeadSeries      uint64
+	tsdbMaxTenantWALSize         units.Base2Bytes
 
 	walCompression  bool
 	noLockFile      bool
@@ -800,20 +1083,33 @@ type receiveConfig struct {
 
 	hashFunc string
 
-	ignoreBlockSize       bool
-	allowOutOfOrderUpload bool
+	ignoreBlockSize              bool
+	allowOutOfOrderUpload        bool
+	shipperTenantUploadIntervals []string
 
 	reqLogConfig      *extflag.PathOrContent
 	relabelConfigPath *extflag.PathOrContent
 
+	tenantWriteConfigPath *extflag.PathOrContent
+	mirrorConfig          *extflag.PathOrContent
+
 	writeLimitsConfig *extflag.PathOrContent
-	storeRateLimits   store.SeriesSelectLimits
+
+	writeLimitsBucketConfig   *extflag.PathOrContent
+	writeLimitsObject         string
+	writeLimitsReloadInterval time.Duration
+	storeRateLimits           store.SeriesSelectLimits
+	overrides                 *overrides.Manager
 }
 
 func (rc *receiveConfig) registerFlag(cmd extkingpin.FlagClause) {
 	rc.httpBindAddr, rc.httpGracePeriod, rc.httpTLSConfig = extkingpin.RegisterHTTPFlags(cmd)
+	rc.httpAuthConfig = extkingpin.RegisterHTTPAuthFlag(cmd)
 	rc.grpcConfig.registerFlag(cmd)
+	rc.healthConfig.registerFlag(cmd)
 	rc.storeRateLimits.RegisterFlags(cmd)
+	rc.overrides = overrides.NewManager()
+	rc.overrides.RegisterFlags(cmd)
 
 	cmd.Flag("remote-write.address", "Address to listen on for remote write requests.").
 		Default("0.0.0.0:19291").StringVar(&rc.rwAddress)
@@ -857,36 +1153,96 @@ func (rc *receiveConfig) registerFlag(cmd extkingpin.FlagClause) {
 
 	cmd.Flag("receive.tenant-header", "HTTP header to determine tenant for write requests.").Default(receive.DefaultTenantHeader).StringVar(&rc.tenantHeader)
 
-	cmd.Flag("receive.tenant-certificate-field", "Use TLS client's certificate field to determine tenant for write requests. Must be one of "+receive.CertificateFieldOrganization+", "+receive.CertificateFieldOrganizationalUnit+" or "+receive.CertificateFieldCommonName+". This setting will cause the receive.tenant-header flag value to be ignored.").Default("").EnumVar(&rc.tenantField, "", receive.CertificateFieldOrganization, receive.CertificateFieldOrganizationalUnit, receive.CertificateFieldCommonName)
+	cmd.Flag("receive.tenant-certificate-field", "Use TLS client's certificate field to determine tenant for write requests. Must be one of "+receive.CertificateFieldOrganization+", "+receive.CertificateFieldOrganizationalUnit+", "+receive.CertificateFieldCommonName+", "+receive.CertificateFieldDNSName+" or "+receive.CertificateFieldURI+". The last two use the first subject alternative name of that type. This setting will cause the receive.tenant-header flag value to be ignored.").Default("").EnumVar(&rc.tenantField, "", receive.CertificateFieldOrganization, receive.CertificateFieldOrganizationalUnit, receive.CertificateFieldCommonName, receive.CertificateFieldDNSName, receive.CertificateFieldURI)
+
+	rc.tenantJWTConfig = extflag.RegisterPathOrContent(cmd, "receive.tenant-jwt-config", "YAML file that contains the configuration to determine tenant for write requests from a claim of their validated JWT bearer token. This setting will cause the receive.tenant-header flag value to be ignored. Can not be used together with receive.tenant-certificate-field.", extflag.WithEnvSubstitution())
+
+	rc.tenantMappingConfig = extflag.RegisterPathOrContent(cmd, "receive.tenant-mapping-config", "YAML file that maps the identities read from client certificates or JWT bearer tokens to tenants. Write requests with identities without mapping are rejected. Requires --receive.tenant-certificate-field or --receive.tenant-jwt-config.", extflag.WithEnvSubstitution())
 
 	cmd.Flag("receive.default-tenant-id", "Default tenant ID to use when none is provided via a header.").Default(receive.DefaultTenant).StringVar(&rc.defaultTenantID)
 
 	cmd.Flag("receive.tenant-label-name", "Label name through which the tenant will be announced.").Default(receive.DefaultTenantLabel).StringVar(&rc.tenantLabelName)
 
+	cmd.Flag("receive.otlp-tenant-attribute", "[EXPERIMENTAL] OTLP resource attribute holding the tenant of the metrics sent to the OTLP endpoint. If empty or if the attribute is missing, the tenant is determined as for remote write requests.").Default("").StringVar(&rc.otlpTenantAttribute)
+
 	cmd.Flag("receive.replica-header", "HTTP header specifying the replica number of a write request.").Default(receive.DefaultReplicaHeader).StringVar(&rc.replicaHeader)
 
-	compressionOptions := strings.Join([]string{snappy.Name, compressionNone}, ", ")
-	cmd.Flag("receive.grpc-compression", "Compression algorithm to use for gRPC requests to other receivers. Must be one of: "+compressionOptions).Default(snappy.Name).EnumVar(&rc.compression, snappy.Name, compressionNone)
+	compressionOptions := strings.Join([]string{snappy.Name, zstd.Name, compressionNone}, ", ")
+	cmd.Flag("receive.grpc-compression", "Compression algorithm to use for gRPC requests to other receivers. Must be one of: "+compressionOptions).Default(snappy.Name).EnumVar(&rc.compression, snappy.Name, zstd.Name, compressionNone)
 
 	cmd.Flag("receive.replication-factor", "How many times to replicate incoming write requests.").Default("1").Uint64Var(&rc.replicationFactor)
 
+	cmd.Flag("receive.write-consistency", "How many replicas have to acknowledge a write request before it succeeds. Must be one of: "+string(receive.WriteConsistencyOne)+", "+string(receive.WriteConsistencyQuorum)+", "+string(receive.WriteConsistencyAll)+".").
+		Default(string(receive.WriteConsistencyQuorum)).EnumVar(&rc.writeConsistency, string(receive.WriteConsistencyOne), string(receive.WriteConsistencyQuorum), string(receive.WriteConsistencyAll))
+
+	cmd.Flag("receive.replication-protocol", "[EXPERIMENTAL] The protocol used to forward and replicate write requests to other receivers. With "+string(receive.ReplicationProtocolUnary)+", every request is a separate gRPC call. With "+string(receive.ReplicationProtocolStream)+", requests are pipelined over one long-lived gRPC stream per receiver, which requires all receivers in the hashring to support it.").
+		Default(string(receive.ReplicationProtocolUnary)).EnumVar(&rc.replicationProtocol, string(receive.ReplicationProtocolUnary), string(receive.ReplicationProtocolStream))
+
+	cmd.Flag("receive.replication-stream.queue-size", "[EXPERIMENTAL] Maximum number of write requests queued or waiting for acknowledgement on the replication stream to a single receiver. Forwarding to the receiver blocks once the queue is full. Also the maximum number of write requests of a single incoming replication stream handled concurrently.").
+		Default("1000").IntVar(&rc.replicationStreamQueueSize)
+
+	cmd.Flag("receive.replication-stream.batch-size", "[EXPERIMENTAL] Maximum number of queued write requests merged into a single request on the replication stream to a single receiver. Only used with the stream replication protocol.").
+		Default("100").IntVar(&rc.replicationStreamBatchSize)
+
+	cmd.Flag("receive.replication-abort-on-quorum", "[EXPERIMENTAL] Abort the forward requests to replicas still outstanding once the write quorum is reached, instead of letting them run until the forward timeout. This frees the resources of slow receivers faster, but series are then only guaranteed to be written to a quorum of replicas.").
+		Default("false").BoolVar(&rc.replicationAbortOnQuorum)
+
 	rc.forwardTimeout = extkingpin.ModelDuration(cmd.Flag("receive-forward-timeout", "Timeout for each forward request.").Default("5s").Hidden())
 
+	rc.hashringTransitionWindow = extkingpin.ModelDuration(cmd.Flag("receive.hashring-transition.window", "[EXPERIMENTAL] Time after a hashring change during which write requests failing because receivers are not ready, e.g. while they flush their storage, are buffered and retried instead of failing. 0s disables buffering.").Default("0s"))
+
+	cmd.Flag("receive.hashring-transition.max-buffered-requests", "[EXPERIMENTAL] Maximum number of write requests buffered at once during a hashring transition. Write requests failing while the buffer is full are answered right away.").
+		Default("1000").IntVar(&rc.hashringTransitionMaxBuffered)
+
+	rc.shutdownFlushTimeout = extkingpin.ModelDuration(cmd.Flag("receive.shutdown-flush-timeout", "Deadline of the upload of the blocks compacted from the TSDB heads on graceful shutdown. It only bounds the upload: head compaction is never interrupted, but the time it takes counts against the deadline, which starts with the shutdown. Blocks not uploaded by then stay on disk and are uploaded on the next start. 0s disables the deadline.").Default("0s"))
+
+	cmd.Flag("receive.enable-admin-api", "[EXPERIMENTAL] Enable the admin API of the HTTP server, which snapshots the TSDBs of tenants, including their heads, to object storage.").
+		Default("false").BoolVar(&rc.enableAdminAPI)
+
+	cmd.Flag("receive.restore-snapshot", "[EXPERIMENTAL] Name of a TSDB snapshot created with the admin API to restore into the data directory on start, e.g. to replace a receiver or to migrate it to another region. Tenants that already have a local TSDB are not restored.").
+		Default("").StringVar(&rc.restoreSnapshot)
+
+	rc.usageReportInterval = extkingpin.ModelDuration(cmd.Flag("receive.tenant-usage-report-interval", "[EXPERIMENTAL] Interval at which the samples and bytes accepted per tenant are written to object storage, for chargeback. The counts are restored from object storage on start, so that they survive restarts. 0s disables the reports.").Default("0s"))
+
 	rc.relabelConfigPath = extflag.RegisterPathOrContent(cmd, "receive.relabel-config", "YAML file that contains relabeling configuration.", extflag.WithEnvSubstitution())
 
+	rc.mirrorConfig = extflag.RegisterPathOrContent(cmd, "receive.mirror-config", "[EXPERIMENTAL] YAML file that contains the remote write endpoints to mirror the accepted series to, e.g. to migrate to or from another system.", extflag.WithEnvSubstitution())
+
+	rc.tenantWriteConfigPath = extflag.RegisterPathOrContent(cmd, "receive.tenant-write-config", "YAML file that contains relabeling configuration and validation rules per tenant. Series breaking the validation rules are rejected.", extflag.WithEnvSubstitution())
+
 	rc.tsdbMinBlockDuration = extkingpin.ModelDuration(cmd.Flag("tsdb.min-block-duration", "Min duration for local TSDB blocks").Default("2h").Hidden())
 
 	rc.tsdbMaxBlockDuration = extkingpin.ModelDuration(cmd.Flag("tsdb.max-block-duration", "Max duration for local TSDB blocks").Default("2h").Hidden())
 
+	cmd.Flag("tsdb.tenant-retention",
+		"Overrides --tsdb.retention for a specific tenant, e.g. <tenant>=30d. 0d disables the retention policy for the tenant. Repeated field.",
+	).PlaceHolder("<tenant>=<duration>").StringsVar(&rc.tsdbTenantRetentions)
+
+	cmd.Flag("tsdb.tenant-block-duration",
+		"[EXPERIMENTAL] Overrides the min and max duration of local TSDB blocks for a specific tenant, e.g. <tenant>=30m. Shorter blocks are cut, uploaded and pruned sooner. Repeated field.",
+	).PlaceHolder("<tenant>=<duration>").StringsVar(&rc.tsdbTenantBlockDurations)
+
+	cmd.Flag("tsdb.max-tenant-head-series",
+		"[EXPERIMENTAL] Maximum number of series in the head of each tenant's TSDB. Samples of new series are rejected once it is reached. 0 disables the limit.",
+	).Default("0").Uint64Var(&rc.tsdbMaxTenantHeadSeries)
+
+	cmd.Flag("tsdb.max-tenant-wal-size",
+		"[EXPERIMENTAL] Maximum size of the WAL of each tenant's TSDB. Samples of new series are rejected while it is exceeded, until the WAL is truncated by the next head compaction. 0 disables the limit.",
+	).Default("0").BytesVar(&rc.tsdbMaxTenantWALSize)
+
 	rc.tsdbTooFarInFutureTimeWindow = extkingpin.ModelDuration(cmd.Flag("tsdb.too-far-in-future.time-window",
 		"[EXPERIMENTAL] Configures the allowed time window for ingesting samples too far in the future. Disabled (0s) by default"+
 			"Please note enable this flag will reject samples in the future of receive local NTP time + configured duration due to clock skew in remote write clients.",
 	).Default("0s"))
 
 	rc.tsdbOutOfOrderTimeWindow = extkingpin.ModelDuration(cmd.Flag("tsdb.out-of-order.time-window",
-		"[EXPERIMENTAL] Configures the allowed time window for ingestion of out-of-order samples. Disabled (0s) by default"+
+		"[EXPERIMENTAL] Configures the allowed time window for ingestion of out-of-order samples. Disabled (0s) by default. "+
 			"Please note if you enable this option and you use compactor, make sure you have the --enable-vertical-compaction flag enabled, otherwise you might risk compactor halt.",
-	).Default("0s").Hidden())
+	).Default("0s"))
+
+	cmd.Flag("tsdb.out-of-order.tenant-time-window",
+		"[EXPERIMENTAL] Overrides the allowed time window for ingestion of out-of-order samples for a specific tenant, e.g. <tenant>=1h. Repeated field.",
+	).PlaceHolder("<tenant>=<duration>").StringsVar(&rc.tsdbTenantOutOfOrderWindows)
 
 	cmd.Flag("tsdb.out-of-order.cap-max",
 		"[EXPERIMENTAL] Configures the maximum capacity for out-of-order chunks (in samples). If set to <=0, default value 32 is assumed.",
@@ -915,7 +1271,11 @@ func (rc *receiveConfig) registerFlag(cmd extkingpin.FlagClause) {
 
 	cmd.Flag("tsdb.enable-native-histograms",
 		"[EXPERIMENTAL] Enables the ingestion of native histograms.").
-		Default("false").Hidden().BoolVar(&rc.tsdbEnableNativeHistograms)
+		Default("false").BoolVar(&rc.tsdbEnableNativeHistograms)
+
+	cmd.Flag("tsdb.native-histograms-tenant",
+		"[EXPERIMENTAL] Enables the ingestion of native histograms only for the given tenant. Repeated field. Not needed if --tsdb.enable-native-histograms is set.").
+		PlaceHolder("<tenant>").StringsVar(&rc.tsdbNativeHistogramsTenants)
 
 	cmd.Flag("writer.intern",
 		"[EXPERIMENTAL] Enables string interning in receive writer, for more optimized memory usage.").
@@ -932,9 +1292,35 @@ func (rc *receiveConfig) registerFlag(cmd extkingpin.FlagClause) {
 			"about order.").
 		Default("false").Hidden().BoolVar(&rc.allowOutOfOrderUpload)
 
+	cmd.Flag("shipper.tenant-upload-interval",
+		"Minimum interval between two uploads of blocks of a specific tenant, e.g. <tenant>=1h. Blocks of other tenants are uploaded as soon as they are cut. Blocks cut by a flush are always uploaded right away. Repeated field.",
+	).PlaceHolder("<tenant>=<duration>").StringsVar(&rc.shipperTenantUploadIntervals)
+
 	rc.reqLogConfig = extkingpin.RegisterRequestLoggingFlags(cmd)
 
 	rc.writeLimitsConfig = extflag.RegisterPathOrContent(cmd, "receive.limits-config", "YAML file that contains limit configuration.", extflag.WithEnvSubstitution(), extflag.WithHidden())
+	rc.writeLimitsBucketConfig = extflag.RegisterPathOrContent(cmd, "receive.limits-config-bucket", "YAML file that contains the object store configuration of the bucket the limit configuration is loaded from, instead of --receive.limits-config. See format details: https://thanos.io/tip/thanos/storage.md/#configuration", extflag.WithEnvSubstitution(), extflag.WithHidden())
+	cmd.Flag("receive.limits-config-object", "Name of the object with the limit configuration in the --receive.limits-config-bucket bucket.").
+		Default("limits.yaml").Hidden().StringVar(&rc.writeLimitsObject)
+	cmd.Flag("receive.limits-config-reload-interval", "How often the limit configuration object of the --receive.limits-config-bucket bucket is checked for changes.").
+		Default("10s").Hidden().DurationVar(&rc.writeLimitsReloadInterval)
+}
+
+// parseFlagTenantDurations parses repeated <tenant>=<duration> flag values into durations in milliseconds per tenant.
+func parseFlagTenantDurations(s []string) (map[string]int64, error) {
+	res := make(map[string]int64, len(s))
+	for _, v := range s {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("unrecognized tenant duration %q, expected <tenant>=<duration>", v)
+		}
+		d, err := model.ParseDuration(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse duration of tenant %s", parts[0])
+		}
+		res[parts[0]] = int64(time.Duration(d) / time.Millisecond)
+	}
+	return res, nil
 }
 
 // determineMode returns the ReceiverMode that this receiver is configured to run in.
@@ -5,7 +5,6 @@ package main
 
 import (
 	"context"
-	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
@@ -31,32 +30,38 @@ import (
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/prometheus/prometheus/notifier"
-	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/rules"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/storage/remote"
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/prometheus/prometheus/tsdb/agent"
 	"github.com/prometheus/prometheus/util/strutil"
-	"github.com/thanos-io/objstore/client"
+	"google.golang.org/grpc"
 	"gopkg.in/yaml.v2"
 
 	"github.com/thanos-io/thanos/pkg/alert"
+	"github.com/thanos-io/thanos/pkg/api/query/querypb"
 	v1 "github.com/thanos-io/thanos/pkg/api/rule"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 	"github.com/thanos-io/thanos/pkg/component"
+	"github.com/thanos-io/thanos/pkg/diagnostics"
 	"github.com/thanos-io/thanos/pkg/discovery/dns"
 	"github.com/thanos-io/thanos/pkg/errutil"
+	"github.com/thanos-io/thanos/pkg/extgrpc"
 	"github.com/thanos-io/thanos/pkg/extkingpin"
+	"github.com/thanos-io/thanos/pkg/extobjstore"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
 	"github.com/thanos-io/thanos/pkg/httpconfig"
 	"github.com/thanos-io/thanos/pkg/info"
 	"github.com/thanos-io/thanos/pkg/info/infopb"
 	"github.com/thanos-io/thanos/pkg/logging"
+	"github.com/thanos-io/thanos/pkg/memlimit"
 	"github.com/thanos-io/thanos/pkg/prober"
 	"github.com/thanos-io/thanos/pkg/promclient"
+	"github.com/thanos-io/thanos/pkg/receive"
 	thanosrules "github.com/thanos-io/thanos/pkg/rules"
+	"github.com/thanos-io/thanos/pkg/runtimeconfig"
 	"github.com/thanos-io/thanos/pkg/runutil"
 	grpcserver "github.com/thanos-io/thanos/pkg/server/grpc"
 	httpserver "github.com/thanos-io/thanos/pkg/server/http"
@@ -71,6 +76,7 @@ import (
 
 type ruleConfig struct {
 	http    httpConfig
+	health  healthConfig
 	grpc    grpcConfig
 	web     webConfig
 	shipper shipperConfig
@@ -87,30 +93,54 @@ type ruleConfig struct {
 
 	resendDelay       time.Duration
 	evalInterval      time.Duration
+	queryOffset       time.Duration
+	evalConcurrency   int
 	outageTolerance   time.Duration
 	forGracePeriod    time.Duration
 	ruleFiles         []string
+	ruleBucket        ruleBucketConfig
 	objStoreConfig    *extflag.PathOrContent
 	dataDir           string
 	lset              labels.Labels
 	ignoredLabelNames []string
+	tenantHeader      string
 	storeRateLimits   store.SeriesSelectLimits
 }
 
+// ruleBucketConfig configures the bucket rule files are loaded from.
+type ruleBucketConfig struct {
+	config       *extflag.PathOrContent
+	prefix       string
+	syncInterval time.Duration
+}
+
+func (rc *ruleBucketConfig) registerFlag(cmd extkingpin.FlagClause) {
+	rc.config = extkingpin.RegisterCommonObjStoreFlags(cmd, "-rules", false, "Rule files are loaded from it next to the --rule-file ones, with the rule files of a tenant in the <prefix>/<tenant>/ directory. The rule groups of these rule files have the tenant of their directory.")
+	cmd.Flag("objstore-rules.prefix", "Prefix of the tenant directories of the rule files in the rule files bucket.").
+		Default("").StringVar(&rc.prefix)
+	cmd.Flag("objstore-rules.sync-interval", "Interval between syncs of the rule files of the rule files bucket. Rules are reloaded when the rule files changed.").
+		Default("1m").DurationVar(&rc.syncInterval)
+}
+
 func (rc *ruleConfig) registerFlag(cmd extkingpin.FlagClause) {
 	rc.http.registerFlag(cmd)
+	rc.health.registerFlag(cmd)
 	rc.grpc.registerFlag(cmd)
 	rc.web.registerFlag(cmd)
 	rc.shipper.registerFlag(cmd)
 	rc.query.registerFlag(cmd)
 	rc.alertmgr.registerFlag(cmd)
+	rc.ruleBucket.registerFlag(cmd)
 	rc.storeRateLimits.RegisterFlags(cmd)
 }
 
 // registerRule registers a rule command.
-func registerRule(app *extkingpin.App) {
+func registerRule(app *extkingpin.App, runtimeConfig *runtimeconfig.Manager, memLimit *memlimit.Flags, diagnosticsConfig *diagnostics.Flags) {
 	comp := component.Rule
 	cmd := app.Command(comp.String(), "Ruler evaluating Prometheus rules against given Query nodes, exposing Store API and storing old blocks in bucket.")
+	runtimeConfig.RegisterFlags(cmd)
+	memLimit.RegisterFlags(cmd)
+	diagnosticsConfig.RegisterFlags(cmd)
 
 	conf := &ruleConfig{}
 	conf.registerFlag(cmd)
@@ -123,6 +153,7 @@ func registerRule(app *extkingpin.App) {
 		Default("48h"))
 	noLockFile := cmd.Flag("tsdb.no-lockfile", "Do not create lockfile in TSDB data directory. In any case, the lockfiles will be deleted on next startup.").Default("false").Bool()
 	walCompression := cmd.Flag("tsdb.wal-compression", "Compress the tsdb WAL.").Default("true").Bool()
+	enableNativeHistograms := cmd.Flag("tsdb.enable-native-histograms", "[EXPERIMENTAL] Enables the storage of the native histogram results of recording rules. Without it, they fail to be appended, also in the stateless mode.").Default("false").Bool()
 
 	cmd.Flag("data-dir", "data directory").Default("data/").StringVar(&conf.dataDir)
 	cmd.Flag("rule-file", "Rule files that should be used by rule manager. Can be in glob format (repeated). Note that rules are not automatically detected, use SIGHUP or do HTTP POST /-/reload to re-read them.").
@@ -131,15 +162,24 @@ func registerRule(app *extkingpin.App) {
 		Default("1m").DurationVar(&conf.resendDelay)
 	cmd.Flag("eval-interval", "The default evaluation interval to use.").
 		Default("1m").DurationVar(&conf.evalInterval)
+	cmd.Flag("eval-query-offset", "The default offset of the evaluation queries of rules, to allow for the delay until the queried data is available, e.g. through remote write. Rule groups can override it with the query_offset field.").
+		Default("0s").DurationVar(&conf.queryOffset)
+	cmd.Flag("eval-concurrency", "The maximum number of rules of a rule group evaluated concurrently. Only rules that do not select series written by other rules of the group are evaluated concurrently. Rule groups can override it with the concurrency field.").
+		Default("1").IntVar(&conf.evalConcurrency)
 	cmd.Flag("for-outage-tolerance", "Max time to tolerate prometheus outage for restoring \"for\" state of alert.").
 		Default("1h").DurationVar(&conf.outageTolerance)
 	cmd.Flag("for-grace-period", "Minimum duration between alert and restored \"for\" state. This is maintained only for alerts with configured \"for\" time greater than grace period.").
 		Default("10m").DurationVar(&conf.forGracePeriod)
-	cmd.Flag("restore-ignored-label", "Label names to be ignored when restoring alerts from the remote storage. This is only used in stateless mode.").
+	cmd.Flag("restore-ignored-label", "Label names to be ignored when restoring alerts from the query API servers, next to the labels of the ruler set with --label.").
 		StringsVar(&conf.ignoredLabelNames)
+	cmd.Flag("tenant-header", "HTTP header (gRPC metadata for --query.grpc-address) the tenant of rule groups with a tenant is sent in, on their evaluation queries and, in stateless mode, on the remote write requests of their results.").
+		Default(receive.DefaultTenantHeader).StringVar(&conf.tenantHeader)
 
 	conf.rwConfig = extflag.RegisterPathOrContent(cmd, "remote-write.config", "YAML config for the remote-write configurations, that specify servers where samples should be sent to (see https://prometheus.io/docs/prometheus/latest/configuration/configuration/#remote_write). This automatically enables stateless mode for ruler and no series will be stored in the ruler's TSDB. If an empty config (or file) is provided, the flag is ignored and ruler is run with its own TSDB.", extflag.WithEnvSubstitution())
 
+	walRetention := extkingpin.ModelDuration(cmd.Flag("remote-write.wal-retention", "Maximum time samples are kept in the WAL in stateless mode while they cannot be sent, e.g. because the remote write endpoint is unavailable. Older samples are dropped.").
+		Default("4h"))
+
 	reqLogDecision := cmd.Flag("log.request.decision", "Deprecation Warning - This flag would be soon deprecated, and replaced with `request.logging-config`. Request Logging for logging the start and end of requests. By default this flag is disabled. LogFinishCall: Logs the finish call of the requests. LogStartAndFinishCall: Logs the start and finish call of the requests. NoLogCall: Disable request logging.").Default("").Enum("NoLogCall", "LogFinishCall", "LogStartAndFinishCall", "")
 
 	conf.objStoreConfig = extkingpin.RegisterCommonObjStoreFlags(cmd, "", false)
@@ -164,11 +204,14 @@ func registerRule(app *extkingpin.App) {
 			RetentionDuration: int64(time.Duration(*tsdbRetention) / time.Millisecond),
 			NoLockfile:        *noLockFile,
 			WALCompression:    *walCompression,
+			// Recording rules over native histograms produce native histograms.
+			EnableNativeHistograms: *enableNativeHistograms,
 		}
 
 		agentOpts := &agent.Options{
 			WALCompression: *walCompression,
 			NoLockfile:     *noLockFile,
+			MaxWALTime:     int64(time.Duration(*walRetention) / time.Millisecond),
 		}
 
 		// Parse and check query configuration.
@@ -185,7 +228,7 @@ func registerRule(app *extkingpin.App) {
 		if err != nil {
 			return err
 		}
-		if len(conf.query.sdFiles) == 0 && len(conf.query.addrs) == 0 && len(conf.queryConfigYAML) == 0 {
+		if len(conf.query.sdFiles) == 0 && len(conf.query.addrs) == 0 && len(conf.queryConfigYAML) == 0 && len(conf.query.grpcAddrs) == 0 {
 			return errors.New("no --query parameter was given")
 		}
 		if (len(conf.query.sdFiles) != 0 || len(conf.query.addrs) != 0) && len(conf.queryConfigYAML) != 0 {
@@ -229,6 +272,7 @@ func registerRule(app *extkingpin.App) {
 			tagOpts,
 			tsdbOpts,
 			agentOpts,
+			runtimeConfig,
 		)
 	})
 }
@@ -293,6 +337,7 @@ func runRule(
 	tagOpts []tags.Option,
 	tsdbOpts *tsdb.Options,
 	agentOpts *agent.Options,
+	runtimeConfig *runtimeconfig.Manager,
 ) error {
 	metrics := newRuleMetrics(reg)
 
@@ -343,7 +388,7 @@ func runRule(
 		if err != nil {
 			return err
 		}
-		c.Transport = tracing.HTTPTripperware(logger, c.Transport)
+		c.Transport = thanosrules.TenantTripperware(conf.tenantHeader, tracing.HTTPTripperware(logger, c.Transport))
 		queryClient, err := httpconfig.NewClient(logger, cfg.EndpointsConfig, c, queryProvider.Clone())
 		if err != nil {
 			return err
@@ -353,13 +398,50 @@ func runRule(
 		// Discover and resolve query addresses.
 		addDiscoveryGroups(g, queryClient, conf.query.dnsSDInterval)
 	}
+	var (
+		grpcQueryEndpoints []ruleQueryEndpoint
+		grpcQueryConns     []*grpc.ClientConn
+	)
+	if len(conf.query.grpcAddrs) > 0 {
+		dialOpts, err := extgrpc.StoreClientGRPCOpts(logger, reg, tracer,
+			conf.query.grpcTLSSecure,
+			conf.query.grpcTLSSkipVerify,
+			conf.query.grpcTLSCert,
+			conf.query.grpcTLSKey,
+			conf.query.grpcTLSCA,
+			conf.query.grpcTLSServerName,
+		)
+		if err != nil {
+			return errors.Wrap(err, "gRPC query client options")
+		}
+		for _, addr := range conf.query.grpcAddrs {
+			conn, err := grpc.Dial(addr, dialOpts...)
+			if err != nil {
+				return errors.Wrapf(err, "dial gRPC query API server %s", addr)
+			}
+			grpcQueryConns = append(grpcQueryConns, conn)
+			grpcQueryEndpoints = append(grpcQueryEndpoints, grpcQueryEndpoint(addr, querypb.NewQueryClient(conn), conf.tenantHeader))
+		}
+	}
 	var (
 		appendable storage.Appendable
 		queryable  storage.Queryable
 		tsdbDB     *tsdb.DB
 		agentDB    *agent.DB
+		// applyTenants is called with the tenants of the rule groups whenever the rules are reloaded.
+		applyTenants func(tenants []string) error
+		// ruleMgrStopped is closed once the rule manager stopped, so that the storage is only closed after the
+		// last evaluations appended to it.
+		ruleMgrStopped = make(chan struct{})
 	)
 
+	// The ALERTS_FOR_STATE series the ruler writes have its labels when queried through the query API servers.
+	restoreIgnoredLabels := append([]string{}, conf.ignoredLabelNames...)
+	for _, l := range conf.lset {
+		restoreIgnoredLabels = append(restoreIgnoredLabels, l.Name)
+	}
+	forStateQueryable := thanosrules.NewPromClientsQueryable(logger, queryClients, promClients, conf.query.httpMethod, conf.query.step, restoreIgnoredLabels)
+
 	rwCfgYAML, err := conf.rwConfig.Content()
 	if err != nil {
 		return err
@@ -372,17 +454,26 @@ func runRule(
 		if err := yaml.Unmarshal(rwCfgYAML, &rwCfg); err != nil {
 			return errors.Wrapf(err, "failed to parse remote write config %v", string(rwCfgYAML))
 		}
+		for _, rw := range rwCfg.RemoteWriteConfigs {
+			if tsdbOpts.EnableNativeHistograms && !rw.SendNativeHistograms {
+				level.Warn(logger).Log("msg", "remote write config does not send native histograms, native histogram results of recording rules will be dropped; set send_native_histograms to send them", "url", rw.URL)
+			}
+		}
 
-		// flushDeadline is set to 1m, but it is for metadata watcher only so not used here.
+		// flushDeadline bounds the time spent sending the queued samples when the ruler stops.
 		remoteStore := remote.NewStorage(logger, reg, func() (int64, error) {
 			return 0, nil
 		}, conf.dataDir, 1*time.Minute, nil)
-		if err := remoteStore.ApplyConfig(&config.Config{
-			GlobalConfig: config.GlobalConfig{
-				ExternalLabels: labelsTSDBToProm(conf.lset),
-			},
-			RemoteWriteConfigs: rwCfg.RemoteWriteConfigs,
-		}); err != nil {
+		// The results of rule groups with a tenant are sent with the tenant header, by remote write queues of their own.
+		applyTenants = func(tenants []string) error {
+			return remoteStore.ApplyConfig(&config.Config{
+				GlobalConfig: config.GlobalConfig{
+					ExternalLabels: labelsTSDBToProm(conf.lset),
+				},
+				RemoteWriteConfigs: thanosrules.TenantRemoteWriteConfigs(rwCfg.RemoteWriteConfigs, conf.tenantHeader, tenants),
+			})
+		}
+		if err := applyTenants(nil); err != nil {
 			return errors.Wrap(err, "applying config to remote storage")
 		}
 
@@ -390,12 +481,30 @@ func runRule(
 		if err != nil {
 			return errors.Wrap(err, "start remote write agent db")
 		}
+		{
+			done := make(chan struct{})
+			g.Add(func() error {
+				<-done
+				<-ruleMgrStopped
+				// Send the queued samples before closing the WAL they are read from.
+				var errs errutil.MultiError
+				errs.Add(remoteStore.Close())
+				errs.Add(agentDB.Close())
+				return errs.Err()
+			}, func(error) {
+				close(done)
+			})
+		}
 		fanoutStore := storage.NewFanout(logger, agentDB, remoteStore)
-		appendable = fanoutStore
+		appendable = thanosrules.NewRemoteWriteLagAppendable(reg, fanoutStore, remoteStore.LowestSentTimestamp)
+		if !tsdbOpts.EnableNativeHistograms {
+			appendable = thanosrules.NewNoNativeHistogramsAppendable(appendable)
+		}
+		appendable = thanosrules.NewTenantLabelAppendable(appendable)
 		// Use a separate queryable to restore the ALERTS firing states.
 		// We cannot use remoteStore directly because it uses remote read for
 		// query. However, remote read is not implemented in Thanos Receiver.
-		queryable = thanosrules.NewPromClientsQueryable(logger, queryClients, promClients, conf.query.httpMethod, conf.query.step, conf.ignoredLabelNames)
+		queryable = forStateQueryable
 	} else {
 		tsdbDB, err = tsdb.Open(conf.dataDir, log.With(logger, "component", "tsdb"), reg, tsdbOpts, nil)
 		if err != nil {
@@ -411,13 +520,27 @@ func runRule(
 			done := make(chan struct{})
 			g.Add(func() error {
 				<-done
+				<-ruleMgrStopped
 				return tsdbDB.Close()
 			}, func(error) {
 				close(done)
 			})
 		}
 		appendable = tsdbDB
-		queryable = tsdbDB
+		// Restore the ALERTS firing states from the query API servers too, so that they are kept across restarts
+		// of rulers without persistent storage. The local TSDB has the most recent state of the rulers with one.
+		queryable = storage.QueryableFunc(func(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+			local, err := tsdbDB.Querier(ctx, mint, maxt)
+			if err != nil {
+				return nil, err
+			}
+			remote, err := forStateQueryable.Querier(ctx, mint, maxt)
+			if err != nil {
+				runutil.CloseWithLogOnErr(logger, local, "close local querier")
+				return nil, err
+			}
+			return storage.NewMergeQuerier([]storage.Querier{local}, []storage.Querier{remote}, storage.ChainedSeriesMerge), nil
+		})
 	}
 
 	// Build the Alertmanager clients.
@@ -438,10 +561,6 @@ func runRule(
 		}
 	}
 
-	if len(alertingCfg.Alertmanagers) == 0 {
-		level.Warn(logger).Log("msg", "no alertmanager configured")
-	}
-
 	var alertRelabelConfigs []*relabel.Config
 	if len(conf.alertRelabelConfigYAML) > 0 {
 		alertRelabelConfigs, err = alert.LoadRelabelConfigs(conf.alertRelabelConfigYAML)
@@ -455,26 +574,25 @@ func runRule(
 		extprom.WrapRegistererWithPrefix("thanos_rule_alertmanagers_", reg),
 		dns.ResolverType(conf.query.dnsSDResolver),
 	)
-	var alertmgrs []*alert.Alertmanager
-	amClientMetrics := extpromhttp.NewClientMetrics(
-		extprom.WrapRegistererWith(prometheus.Labels{"client": "alertmanager"}, reg),
-	)
-	for _, cfg := range alertingCfg.Alertmanagers {
-		cfg.HTTPClientConfig.ClientMetrics = amClientMetrics
-		c, err := httpconfig.NewHTTPClient(cfg.HTTPClientConfig, "alertmanager")
-		if err != nil {
-			return err
-		}
-		c.Transport = tracing.HTTPTripperware(logger, c.Transport)
-		// Each Alertmanager client has a different list of targets thus each needs its own DNS provider.
-		amClient, err := httpconfig.NewClient(logger, cfg.EndpointsConfig, c, amProvider.Clone())
-		if err != nil {
-			return err
+	sdr := alert.NewSender(logger, reg, nil)
+	alertmgrs := &ruleAlertmanagers{
+		logger:   logger,
+		sender:   sdr,
+		provider: amProvider,
+		clientMetrics: extpromhttp.NewClientMetrics(
+			extprom.WrapRegistererWith(prometheus.Labels{"client": "alertmanager"}, reg),
+		),
+		sdInterval: conf.alertmgr.alertmgrsDNSSDInterval,
+	}
+	if err := alertmgrs.apply(conf.alertmgrsConfigYAML, alertingCfg); err != nil {
+		return err
+	}
+	// The alerting configuration is reloaded with the rules, if it is given by --alertmanagers.config*.
+	reloadAlerting := func() error {
+		if len(conf.alertmgr.alertmgrURLs) != 0 {
+			return nil
 		}
-		// Discover and resolve Alertmanager addresses.
-		addDiscoveryGroups(g, amClient, conf.alertmgr.alertmgrsDNSSDInterval)
-
-		alertmgrs = append(alertmgrs, alert.NewAlertmanager(logger, amClient, time.Duration(cfg.Timeout), cfg.APIVersion))
+		return alertmgrs.reload(conf.alertmgr.configPath.Content)
 	}
 
 	var (
@@ -522,12 +640,19 @@ func runRule(
 				OutageTolerance: conf.outageTolerance,
 				ForGracePeriod:  conf.forGracePeriod,
 			},
-			queryFuncCreator(logger, queryClients, promClients, metrics.duplicatedQuery, metrics.ruleEvalWarnings, conf.query.httpMethod),
+			queryFuncCreator(logger, queryClients, promClients, grpcQueryEndpoints, metrics.duplicatedQuery, metrics.ruleEvalWarnings, ruleQueryOptions{
+				httpMethod: conf.query.httpMethod,
+				timeout:    conf.query.timeout,
+				maxRetries: conf.query.maxRetries,
+				ordered:    conf.query.order == queryEndpointOrderOrdered,
+			}),
 			conf.lset,
 			// In our case the querying URL is the external URL because in Prometheus
 			// --web.external-url points to it i.e. it points at something where the user
 			// could execute the alert or recording rule's expression and get results.
 			conf.alertQueryURL.String(),
+			conf.queryOffset,
+			conf.evalConcurrency,
 		)
 
 		// Schedule rule manager that evaluates rules.
@@ -539,11 +664,27 @@ func runRule(
 		}, func(err error) {
 			cancel()
 			ruleMgr.Stop()
+			close(ruleMgrStopped)
 		})
+
+		if len(grpcQueryConns) > 0 {
+			done := make(chan struct{})
+			g.Add(func() error {
+				<-done
+				// Close the connections to the gRPC query API servers once the last evaluations are done.
+				<-ruleMgrStopped
+				var errs errutil.MultiError
+				for _, conn := range grpcQueryConns {
+					errs.Add(conn.Close())
+				}
+				return errs.Err()
+			}, func(error) {
+				close(done)
+			})
+		}
 	}
 	// Run the alert sender.
 	{
-		sdr := alert.NewSender(logger, reg, alertmgrs)
 		ctx, cancel := context.WithCancel(context.Background())
 		ctx = tracing.ContextWithTracer(ctx, tracer)
 
@@ -559,6 +700,49 @@ func runRule(
 				default:
 				}
 			}
+		}, func(error) {
+			cancel()
+			alertmgrs.stop()
+		})
+	}
+
+	// Sync the rule files of the rule files bucket.
+	ruleFiles := conf.ruleFiles
+	bucketRulesChanged := make(chan struct{}, 1)
+	ruleBucketConfYAML, err := conf.ruleBucket.config.Content()
+	if err != nil {
+		return err
+	}
+	if len(ruleBucketConfYAML) > 0 {
+		// The metrics of the bucket client would clash with the ones of the bucket blocks are uploaded to.
+		bkt, err := extobjstore.NewBucket(logger, ruleBucketConfYAML, nil, component.Rule.String())
+		if err != nil {
+			return err
+		}
+		bucketRuleFiles := thanosrules.NewBucketRuleFiles(logger, reg, bkt, conf.ruleBucket.prefix, filepath.Join(conf.dataDir, "bucket-rules"))
+		ruleFiles = append(append([]string{}, conf.ruleFiles...), bucketRuleFiles.Pattern())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		if _, err := bucketRuleFiles.Sync(ctx); err != nil {
+			level.Error(logger).Log("msg", "initial sync of the rule files of the bucket failed", "err", err)
+		}
+		g.Add(func() error {
+			defer runutil.CloseWithLogOnErr(logger, bkt, "rule files bucket client")
+
+			return runutil.Repeat(conf.ruleBucket.syncInterval, ctx.Done(), func() error {
+				changed, err := bucketRuleFiles.Sync(ctx)
+				if err != nil {
+					level.Error(logger).Log("msg", "sync of the rule files of the bucket failed", "err", err)
+					return nil
+				}
+				if changed {
+					select {
+					case bucketRulesChanged <- struct{}{}:
+					default:
+					}
+				}
+				return nil
+			})
 		}, func(error) {
 			cancel()
 		})
@@ -570,22 +754,34 @@ func runRule(
 		ctx, cancel := context.WithCancel(context.Background())
 		g.Add(func() error {
 			// Initialize rules.
-			if err := reloadRules(logger, conf.ruleFiles, ruleMgr, conf.evalInterval, metrics); err != nil {
+			if err := reloadRules(logger, ruleFiles, ruleMgr, conf.evalInterval, metrics, applyTenants); err != nil {
 				level.Error(logger).Log("msg", "initialize rules failed", "err", err)
 				return err
 			}
 			for {
 				select {
 				case <-reloadSignal:
-					if err := reloadRules(logger, conf.ruleFiles, ruleMgr, conf.evalInterval, metrics); err != nil {
+					if err := reloadRules(logger, ruleFiles, ruleMgr, conf.evalInterval, metrics, applyTenants); err != nil {
 						level.Error(logger).Log("msg", "reload rules by sighup failed", "err", err)
 					}
+					if err := reloadAlerting(); err != nil {
+						level.Error(logger).Log("msg", "reload alerting configuration by sighup failed", "err", err)
+					}
+				case <-bucketRulesChanged:
+					if err := reloadRules(logger, ruleFiles, ruleMgr, conf.evalInterval, metrics, applyTenants); err != nil {
+						level.Error(logger).Log("msg", "reload rules after sync of the rule files of the bucket failed", "err", err)
+					}
 				case reloadMsg := <-reloadWebhandler:
-					err := reloadRules(logger, conf.ruleFiles, ruleMgr, conf.evalInterval, metrics)
-					if err != nil {
+					var errs errutil.MultiError
+					if err := reloadRules(logger, ruleFiles, ruleMgr, conf.evalInterval, metrics, applyTenants); err != nil {
 						level.Error(logger).Log("msg", "reload rules by webhandler failed", "err", err)
+						errs.Add(err)
+					}
+					if err := reloadAlerting(); err != nil {
+						level.Error(logger).Log("msg", "reload alerting configuration by webhandler failed", "err", err)
+						errs.Add(err)
 					}
-					reloadMsg <- err
+					reloadMsg <- errs.Err()
 				case <-ctx.Done():
 					return ctx.Err()
 				}
@@ -602,6 +798,10 @@ func runRule(
 		grpcProbe,
 		prober.NewInstrumentation(comp, logger, extprom.WrapRegistererWithPrefix("thanos_", reg)),
 	)
+	deps, err := setupDependencies(g, logger, reg, &conf.health, httpProbe)
+	if err != nil {
+		return err
+	}
 
 	// Start gRPC server.
 	tlsCfg, err := tls.NewServerConfig(log.With(logger, "protocol", "gRPC"), conf.grpc.tlsSrvCert, conf.grpc.tlsSrvKey, conf.grpc.tlsSrvClientCA)
@@ -688,12 +888,18 @@ func runRule(
 		api := v1.NewRuleAPI(logger, reg, thanosrules.NewGRPCClient(ruleMgr), ruleMgr, conf.web.disableCORS, flagsMap)
 		api.Register(router.WithPrefix("/api/v1"), tracer, logger, ins, logMiddleware)
 
+		httpAuth, err := newHTTPAuthenticator(logger, conf.http.authConfig)
+		if err != nil {
+			return err
+		}
 		srv := httpserver.New(logger, reg, comp, httpProbe,
 			httpserver.WithListen(conf.http.bindAddress),
 			httpserver.WithGracePeriod(time.Duration(conf.http.gracePeriod)),
 			httpserver.WithTLSConfig(conf.http.tlsConfig),
+			httpserver.WithAuthenticator(httpAuth),
 		)
 		srv.Handle("/", router)
+		srv.Handle(runtimeconfig.APIPath, runtimeConfig)
 
 		g.Add(func() error {
 			statusProber.Healthy()
@@ -712,13 +918,16 @@ func runRule(
 		return err
 	}
 
-	if len(confContentYaml) > 0 {
+	if len(confContentYaml) > 0 && agentDB != nil {
+		level.Warn(logger).Log("msg", "ruler runs in stateless mode and has no blocks to upload, ignoring the object storage configuration")
+	} else if len(confContentYaml) > 0 {
 		// The background shipper continuously scans the data directory and uploads
 		// new blocks to Google Cloud Storage or an S3-compatible storage service.
-		bkt, err := client.NewBucket(logger, confContentYaml, reg, component.Rule.String())
+		bkt, err := extobjstore.NewBucket(logger, confContentYaml, reg, component.Rule.String())
 		if err != nil {
 			return err
 		}
+		deps.Register("objstore", objStoreCheck(bkt))
 
 		// Ensure we close up everything properly.
 		defer func() {
@@ -727,7 +936,11 @@ func runRule(
 			}
 		}()
 
-		s := shipper.New(logger, reg, conf.dataDir, bkt, func() labels.Labels { return conf.lset }, metadata.RulerSource, false, conf.shipper.allowOutOfOrderUpload, metadata.HashFunc(conf.shipper.hashFunc))
+		s := shipper.New(logger, reg, conf.dataDir, bkt, func() labels.Labels { return conf.lset }, metadata.RulerSource, metadata.HashFunc(conf.shipper.hashFunc),
+			shipper.WithAllowOutOfOrderUploads(conf.shipper.allowOutOfOrderUpload),
+			shipper.WithUploadConcurrency(conf.shipper.uploadConcurrency),
+			shipper.WithUploadBandwidthLimit(int64(conf.shipper.uploadBandwidthLimit)),
+		)
 
 		ctx, cancel := context.WithCancel(context.Background())
 
@@ -796,60 +1009,6 @@ func labelsTSDBToProm(lset labels.Labels) (res labels.Labels) {
 	return res
 }
 
-func queryFuncCreator(
-	logger log.Logger,
-	queriers []*httpconfig.Client,
-	promClients []*promclient.Client,
-	duplicatedQuery prometheus.Counter,
-	ruleEvalWarnings *prometheus.CounterVec,
-	httpMethod string,
-) func(partialResponseStrategy storepb.PartialResponseStrategy) rules.QueryFunc {
-
-	// queryFunc returns query function that hits the HTTP query API of query peers in randomized order until we get a result
-	// back or the context get canceled.
-	return func(partialResponseStrategy storepb.PartialResponseStrategy) rules.QueryFunc {
-		var spanID string
-
-		switch partialResponseStrategy {
-		case storepb.PartialResponseStrategy_WARN:
-			spanID = "/rule_instant_query HTTP[client]"
-		case storepb.PartialResponseStrategy_ABORT:
-			spanID = "/rule_instant_query_part_resp_abort HTTP[client]"
-		default:
-			// Programming error will be caught by tests.
-			panic(errors.Errorf("unknown partial response strategy %v", partialResponseStrategy).Error())
-		}
-
-		return func(ctx context.Context, q string, t time.Time) (promql.Vector, error) {
-			for _, i := range rand.Perm(len(queriers)) {
-				promClient := promClients[i]
-				endpoints := thanosrules.RemoveDuplicateQueryEndpoints(logger, duplicatedQuery, queriers[i].Endpoints())
-				for _, i := range rand.Perm(len(endpoints)) {
-					span, ctx := tracing.StartSpan(ctx, spanID)
-					v, warns, err := promClient.PromqlQueryInstant(ctx, endpoints[i], q, t, promclient.QueryOptions{
-						Deduplicate:             true,
-						PartialResponseStrategy: partialResponseStrategy,
-						Method:                  httpMethod,
-					})
-					span.Finish()
-
-					if err != nil {
-						level.Error(logger).Log("err", err, "query", q)
-						continue
-					}
-					if len(warns) > 0 {
-						ruleEvalWarnings.WithLabelValues(strings.ToLower(partialResponseStrategy.String())).Inc()
-						// TODO(bwplotka): Propagate those to UI, probably requires changing rule manager code ):
-						level.Warn(logger).Log("warnings", strings.Join(warns, ", "), "query", q)
-					}
-					return v, nil
-				}
-			}
-			return nil, errors.Errorf("no query API server reachable")
-		}
-	}
-}
-
 func addDiscoveryGroups(g *run.Group, c *httpconfig.Client, interval time.Duration) {
 	ctx, cancel := context.WithCancel(context.Background())
 	g.Add(func() error {
@@ -872,7 +1031,8 @@ func reloadRules(logger log.Logger,
 	ruleFiles []string,
 	ruleMgr *thanosrules.Manager,
 	evalInterval time.Duration,
-	metrics *RuleMetrics) error {
+	metrics *RuleMetrics,
+	applyTenants func(tenants []string) error) error {
 	level.Debug(logger).Log("msg", "configured rule files", "files", strings.Join(ruleFiles, ","))
 	var (
 		errs      errutil.MultiError
@@ -904,12 +1064,21 @@ func reloadRules(logger log.Logger,
 		return errs.Err()
 	}
 
+	if applyTenants != nil {
+		if err := applyTenants(ruleMgr.Tenants()); err != nil {
+			metrics.configSuccess.Set(0)
+			errs.Add(errors.Wrap(err, "applying config to remote storage for rule group tenants"))
+			return errs.Err()
+		}
+	}
+
 	metrics.configSuccess.Set(1)
 	metrics.configSuccessTime.Set(float64(time.Now().UnixNano()) / 1e9)
 
 	metrics.rulesLoaded.Reset()
 	for _, group := range ruleMgr.RuleGroups() {
-		metrics.rulesLoaded.WithLabelValues(group.PartialResponseStrategy.String(), group.OriginalFile, group.Name()).Set(float64(len(group.Rules())))
+		// Rule groups evaluated concurrently consist of several groups.
+		metrics.rulesLoaded.WithLabelValues(group.PartialResponseStrategy.String(), group.OriginalFile, group.Name()).Add(float64(len(group.Rules())))
 	}
 	return errs.Err()
 }
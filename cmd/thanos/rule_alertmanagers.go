@@ -0,0 +1,129 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/thanos-io/thanos/pkg/alert"
+	"github.com/thanos-io/thanos/pkg/discovery/dns"
+	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
+	"github.com/thanos-io/thanos/pkg/httpconfig"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/tracing"
+)
+
+// ruleAlertmanagers builds the Alertmanager clients of the alerting configuration for the sender,
+// and replaces them when the alerting configuration changes.
+type ruleAlertmanagers struct {
+	logger        log.Logger
+	sender        *alert.Sender
+	provider      *dns.Provider
+	clientMetrics *extpromhttp.ClientMetrics
+	sdInterval    time.Duration
+
+	mtx       sync.Mutex
+	configRaw []byte
+	// cancel stops the discovery of the current Alertmanager clients, wg waits for it to stop.
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// apply replaces the Alertmanager clients with the ones of the given alerting configuration, unless the
+// configuration did not change. Alerts are sent to the previous clients until the new ones resolved their addresses.
+func (r *ruleAlertmanagers) apply(configRaw []byte, cfg alert.AlertingConfig) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.cancel != nil && bytes.Equal(r.configRaw, configRaw) {
+		return nil
+	}
+	if len(cfg.Alertmanagers) == 0 {
+		level.Warn(r.logger).Log("msg", "no alertmanager configured")
+	}
+
+	var (
+		clients   = make([]*httpconfig.Client, 0, len(cfg.Alertmanagers))
+		alertmgrs = make([]*alert.Alertmanager, 0, len(cfg.Alertmanagers))
+	)
+	for _, amCfg := range cfg.Alertmanagers {
+		amCfg.HTTPClientConfig.ClientMetrics = r.clientMetrics
+		c, err := httpconfig.NewHTTPClient(amCfg.HTTPClientConfig, "alertmanager")
+		if err != nil {
+			return err
+		}
+		c.Transport = tracing.HTTPTripperware(r.logger, c.Transport)
+		// Each Alertmanager client has a different list of targets thus each needs its own DNS provider.
+		amClient, err := httpconfig.NewClient(r.logger, amCfg.EndpointsConfig, c, r.provider.Clone())
+		if err != nil {
+			return err
+		}
+		clients = append(clients, amClient)
+		alertmgrs = append(alertmgrs, alert.NewAlertmanager(r.logger, amClient, time.Duration(amCfg.Timeout), amCfg.APIVersion))
+	}
+
+	// The DNS providers share their metrics, so the previous clients stop resolving before the new ones start.
+	if r.cancel != nil {
+		r.cancel()
+		r.wg.Wait()
+		level.Info(r.logger).Log("msg", "applying new alerting configuration", "alertmanagers", len(alertmgrs))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	for _, amClient := range clients {
+		amClient := amClient
+		if err := amClient.Resolve(ctx); err != nil {
+			level.Error(r.logger).Log("msg", "resolving Alertmanager addresses failed", "err", err)
+		}
+		// Discover and resolve Alertmanager addresses until the client is replaced.
+		r.wg.Add(2)
+		go func() {
+			defer r.wg.Done()
+			amClient.Discover(ctx)
+		}()
+		go func() {
+			defer r.wg.Done()
+			_ = runutil.Repeat(r.sdInterval, ctx.Done(), func() error {
+				if err := amClient.Resolve(ctx); err != nil && ctx.Err() == nil {
+					level.Error(r.logger).Log("msg", "resolving Alertmanager addresses failed", "err", err)
+				}
+				return nil
+			})
+		}()
+	}
+	r.sender.SetAlertmanagers(alertmgrs)
+	r.configRaw = configRaw
+	r.cancel = cancel
+	return nil
+}
+
+// reload applies the alerting configuration read again from the given content.
+func (r *ruleAlertmanagers) reload(content func() ([]byte, error)) error {
+	configRaw, err := content()
+	if err != nil {
+		return errors.Wrap(err, "read alerting configuration")
+	}
+	cfg, err := alert.LoadAlertingConfig(configRaw)
+	if err != nil {
+		return errors.Wrap(err, "parse alerting configuration")
+	}
+	return r.apply(configRaw, cfg)
+}
+
+// stop stops the discovery of the Alertmanager clients.
+func (r *ruleAlertmanagers) stop() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.cancel != nil {
+		r.cancel()
+		r.wg.Wait()
+	}
+}
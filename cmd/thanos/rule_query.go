@@ -0,0 +1,218 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package main
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/jpillora/backoff"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/rules"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/thanos-io/thanos/pkg/api/query/querypb"
+	"github.com/thanos-io/thanos/pkg/httpconfig"
+	"github.com/thanos-io/thanos/pkg/promclient"
+	thanosrules "github.com/thanos-io/thanos/pkg/rules"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+	"github.com/thanos-io/thanos/pkg/tracing"
+)
+
+// ruleQueryOptions configures how the ruler sends its evaluation queries to query API servers.
+type ruleQueryOptions struct {
+	httpMethod string
+	// timeout is the timeout of a single query against a query API server. 0 means no timeout.
+	timeout time.Duration
+	// maxRetries is the number of times all query API servers are tried again before a query fails.
+	maxRetries int
+	// ordered tries the query API servers in the order they are configured in, instead of in random order.
+	ordered bool
+}
+
+// ruleQueryEndpoint is a query API server evaluation queries are sent to.
+type ruleQueryEndpoint struct {
+	name  string
+	query func(ctx context.Context, q string, t time.Time, partialResponseStrategy storepb.PartialResponseStrategy) (promql.Vector, []string, error)
+}
+
+// grpcQueryEndpoint returns the query API server reached through the gRPC QueryAPI at the given address.
+// The tenant of the rule group is sent in the tenantHeader metadata.
+func grpcQueryEndpoint(addr string, client querypb.QueryClient, tenantHeader string) ruleQueryEndpoint {
+	return ruleQueryEndpoint{
+		name: addr,
+		query: func(ctx context.Context, q string, t time.Time, partialResponseStrategy storepb.PartialResponseStrategy) (promql.Vector, []string, error) {
+			if tenant, ok := thanosrules.TenantFromContext(ctx); ok {
+				ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(tenantHeader), tenant)
+			}
+			req := &querypb.QueryRequest{
+				Query:                 q,
+				TimeSeconds:           t.Unix(),
+				EnableDedup:           true,
+				EnablePartialResponse: partialResponseStrategy == storepb.PartialResponseStrategy_WARN,
+			}
+			stream, err := client.Query(ctx, req)
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "query")
+			}
+
+			var (
+				vec   promql.Vector
+				warns []string
+			)
+			for {
+				resp, err := stream.Recv()
+				if err == io.EOF {
+					return vec, warns, nil
+				}
+				if err != nil {
+					return nil, nil, errors.Wrap(err, "receive query response")
+				}
+				if w := resp.GetWarnings(); w != "" {
+					warns = append(warns, w)
+					continue
+				}
+				series := resp.GetTimeseries()
+				if series == nil {
+					continue
+				}
+				// The QueryAPI evaluates queries at second precision, the samples are returned at the evaluation time.
+				sample := promql.Sample{Metric: labelpb.ZLabelsToPromLabels(series.Labels), T: timestamp.FromTime(t)}
+				switch {
+				case len(series.Samples) > 0:
+					sample.F = series.Samples[0].Value
+				case len(series.Histograms) > 0:
+					sample.H = prompb.HistogramProtoToFloatHistogram(series.Histograms[0])
+				default:
+					continue
+				}
+				vec = append(vec, sample)
+			}
+		},
+	}
+}
+
+func queryFuncCreator(
+	logger log.Logger,
+	queriers []*httpconfig.Client,
+	promClients []*promclient.Client,
+	grpcEndpoints []ruleQueryEndpoint,
+	duplicatedQuery prometheus.Counter,
+	ruleEvalWarnings *prometheus.CounterVec,
+	opts ruleQueryOptions,
+) func(partialResponseStrategy storepb.PartialResponseStrategy) rules.QueryFunc {
+
+	// endpoints returns the query API servers to try, grouped by configuration.
+	endpoints := func() [][]ruleQueryEndpoint {
+		groups := make([][]ruleQueryEndpoint, 0, len(queriers)+1)
+		for i := range queriers {
+			promClient := promClients[i]
+			urls := thanosrules.RemoveDuplicateQueryEndpoints(logger, duplicatedQuery, queriers[i].Endpoints())
+			group := make([]ruleQueryEndpoint, 0, len(urls))
+			for _, u := range urls {
+				u := u
+				group = append(group, ruleQueryEndpoint{
+					name: u.String(),
+					query: func(ctx context.Context, q string, t time.Time, partialResponseStrategy storepb.PartialResponseStrategy) (promql.Vector, []string, error) {
+						return promClient.PromqlQueryInstant(ctx, u, q, t, promclient.QueryOptions{
+							Deduplicate:             true,
+							PartialResponseStrategy: partialResponseStrategy,
+							Method:                  opts.httpMethod,
+						})
+					},
+				})
+			}
+			groups = append(groups, group)
+		}
+		if len(grpcEndpoints) > 0 {
+			groups = append(groups, grpcEndpoints)
+		}
+		if opts.ordered {
+			return groups
+		}
+		shuffled := make([][]ruleQueryEndpoint, 0, len(groups))
+		for _, i := range rand.Perm(len(groups)) {
+			group := make([]ruleQueryEndpoint, 0, len(groups[i]))
+			for _, j := range rand.Perm(len(groups[i])) {
+				group = append(group, groups[i][j])
+			}
+			shuffled = append(shuffled, group)
+		}
+		return shuffled
+	}
+
+	// queryFunc returns query function that hits the query API of query peers in randomized or configured order until we get
+	// a result back or the context get canceled. All query peers are tried again with backoff up to the configured number of retries.
+	return func(partialResponseStrategy storepb.PartialResponseStrategy) rules.QueryFunc {
+		var spanID string
+
+		switch partialResponseStrategy {
+		case storepb.PartialResponseStrategy_WARN:
+			spanID = "/rule_instant_query HTTP[client]"
+		case storepb.PartialResponseStrategy_ABORT:
+			spanID = "/rule_instant_query_part_resp_abort HTTP[client]"
+		default:
+			// Programming error will be caught by tests.
+			panic(errors.Errorf("unknown partial response strategy %v", partialResponseStrategy).Error())
+		}
+
+		query := func(ctx context.Context, e ruleQueryEndpoint, q string, t time.Time) (promql.Vector, []string, error) {
+			if opts.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+				defer cancel()
+			}
+			span, ctx := tracing.StartSpan(ctx, spanID)
+			defer span.Finish()
+			return e.query(ctx, q, t, partialResponseStrategy)
+		}
+
+		return func(ctx context.Context, q string, t time.Time) (promql.Vector, error) {
+			b := backoff.Backoff{
+				Factor: 2,
+				Min:    100 * time.Millisecond,
+				Max:    5 * time.Second,
+				Jitter: true,
+			}
+			for attempt := 0; ; attempt++ {
+				for _, group := range endpoints() {
+					for _, e := range group {
+						v, warns, err := query(ctx, e, q, t)
+						if err != nil {
+							level.Error(logger).Log("err", err, "query", q, "endpoint", e.name)
+							if ctx.Err() != nil {
+								return nil, errors.Wrap(ctx.Err(), "query")
+							}
+							continue
+						}
+						if len(warns) > 0 {
+							ruleEvalWarnings.WithLabelValues(strings.ToLower(partialResponseStrategy.String())).Inc()
+							// TODO(bwplotka): Propagate those to UI, probably requires changing rule manager code ):
+							level.Warn(logger).Log("warnings", strings.Join(warns, ", "), "query", q)
+						}
+						return v, nil
+					}
+				}
+				if attempt >= opts.maxRetries {
+					return nil, errors.Errorf("no query API server reachable")
+				}
+				select {
+				case <-ctx.Done():
+					return nil, errors.Wrap(ctx.Err(), "query")
+				case <-time.After(b.Duration()):
+				}
+			}
+		}
+	}
+}
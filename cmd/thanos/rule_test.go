@@ -4,9 +4,22 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/thanos-io/thanos/pkg/alert"
+	"github.com/thanos-io/thanos/pkg/discovery/dns"
+	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
 )
 
 func Test_parseFlagLabels(t *testing.T) {
@@ -48,3 +61,100 @@ func Test_parseFlagLabels(t *testing.T) {
 		testutil.Equals(t, err != nil, td.expectErr)
 	}
 }
+
+func TestQueryFuncCreator(t *testing.T) {
+	result := promql.Vector{{Metric: labels.FromStrings("__name__", "up"), T: 1000, F: 1}}
+	failing := func(calls *int) ruleQueryEndpoint {
+		return ruleQueryEndpoint{name: "failing", query: func(context.Context, string, time.Time, storepb.PartialResponseStrategy) (promql.Vector, []string, error) {
+			*calls++
+			return nil, nil, errors.New("unavailable")
+		}}
+	}
+	hanging := ruleQueryEndpoint{name: "hanging", query: func(ctx context.Context, _ string, _ time.Time, _ storepb.PartialResponseStrategy) (promql.Vector, []string, error) {
+		<-ctx.Done()
+		return nil, nil, ctx.Err()
+	}}
+	// flaky fails the given number of times before it succeeds.
+	flaky := func(failures int) ruleQueryEndpoint {
+		return ruleQueryEndpoint{name: "flaky", query: func(context.Context, string, time.Time, storepb.PartialResponseStrategy) (promql.Vector, []string, error) {
+			if failures > 0 {
+				failures--
+				return nil, nil, errors.New("unavailable")
+			}
+			return result, nil, nil
+		}}
+	}
+
+	newQueryFunc := func(opts ruleQueryOptions, endpoints ...ruleQueryEndpoint) func(ctx context.Context, q string, t time.Time) (promql.Vector, error) {
+		ruleEvalWarnings := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "warnings"}, []string{"strategy"})
+		return queryFuncCreator(log.NewNopLogger(), nil, nil, endpoints, prometheus.NewCounter(prometheus.CounterOpts{}), ruleEvalWarnings, opts)(storepb.PartialResponseStrategy_ABORT)
+	}
+
+	t.Run("fails over in order", func(t *testing.T) {
+		var calls int
+		v, err := newQueryFunc(ruleQueryOptions{ordered: true}, failing(&calls), flaky(0))(context.Background(), "up", time.Unix(1, 0))
+		testutil.Ok(t, err)
+		testutil.Equals(t, result, v)
+		testutil.Equals(t, 1, calls)
+	})
+	t.Run("retries", func(t *testing.T) {
+		_, err := newQueryFunc(ruleQueryOptions{maxRetries: 1}, flaky(2))(context.Background(), "up", time.Unix(1, 0))
+		testutil.NotOk(t, err)
+
+		v, err := newQueryFunc(ruleQueryOptions{maxRetries: 2}, flaky(2))(context.Background(), "up", time.Unix(1, 0))
+		testutil.Ok(t, err)
+		testutil.Equals(t, result, v)
+	})
+	t.Run("times out single queries", func(t *testing.T) {
+		v, err := newQueryFunc(ruleQueryOptions{ordered: true, timeout: 100 * time.Millisecond}, hanging, flaky(0))(context.Background(), "up", time.Unix(1, 0))
+		testutil.Ok(t, err)
+		testutil.Equals(t, result, v)
+	})
+	t.Run("stops once the evaluation is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		var calls int
+		_, err := newQueryFunc(ruleQueryOptions{ordered: true}, hanging, failing(&calls))(ctx, "up", time.Unix(1, 0))
+		testutil.NotOk(t, err)
+		testutil.Equals(t, 0, calls)
+	})
+}
+
+func TestRuleAlertmanagersReload(t *testing.T) {
+	logger := log.NewNopLogger()
+	am := &ruleAlertmanagers{
+		logger:        logger,
+		sender:        alert.NewSender(logger, nil, nil),
+		provider:      dns.NewProvider(logger, nil, dns.GolangResolverType),
+		clientMetrics: extpromhttp.NewClientMetrics(nil),
+		sdInterval:    time.Minute,
+	}
+	defer am.stop()
+
+	content := []byte(`alertmanagers:
+- static_configs: ["am1:9093"]
+`)
+	read := func() ([]byte, error) { return content, nil }
+
+	testutil.Ok(t, am.reload(read))
+	first := am.cancel
+	testutil.Ok(t, am.reload(read))
+	testutil.Assert(t, fmt.Sprintf("%p", first) == fmt.Sprintf("%p", am.cancel), "unchanged configuration must not be applied again")
+
+	content = []byte(`alertmanagers:
+- static_configs: ["am1:9093", "am2:9093"]
+  api_version: v1
+`)
+	testutil.Ok(t, am.reload(read))
+	testutil.Equals(t, content, am.configRaw)
+
+	// Invalid configurations are not applied.
+	content = []byte(`alertmanagers:
+- api_version: v3
+`)
+	testutil.NotOk(t, am.reload(read))
+	testutil.Equals(t, []byte(`alertmanagers:
+- static_configs: ["am1:9093", "am2:9093"]
+  api_version: v1
+`), am.configRaw)
+}
@@ -5,8 +5,10 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"math"
 	"net/url"
+	"sort"
 	"sync"
 	"time"
 
@@ -22,23 +24,26 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/model/labels"
-	"github.com/thanos-io/objstore/client"
 
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 	"github.com/thanos-io/thanos/pkg/component"
+	"github.com/thanos-io/thanos/pkg/diagnostics"
 	"github.com/thanos-io/thanos/pkg/exemplars"
 	"github.com/thanos-io/thanos/pkg/extkingpin"
+	"github.com/thanos-io/thanos/pkg/extobjstore"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	"github.com/thanos-io/thanos/pkg/httpconfig"
 	"github.com/thanos-io/thanos/pkg/info"
 	"github.com/thanos-io/thanos/pkg/info/infopb"
 	"github.com/thanos-io/thanos/pkg/logging"
+	"github.com/thanos-io/thanos/pkg/memlimit"
 	meta "github.com/thanos-io/thanos/pkg/metadata"
 	thanosmodel "github.com/thanos-io/thanos/pkg/model"
 	"github.com/thanos-io/thanos/pkg/prober"
 	"github.com/thanos-io/thanos/pkg/promclient"
 	"github.com/thanos-io/thanos/pkg/reloader"
 	"github.com/thanos-io/thanos/pkg/rules"
+	"github.com/thanos-io/thanos/pkg/runtimeconfig"
 	"github.com/thanos-io/thanos/pkg/runutil"
 	grpcserver "github.com/thanos-io/thanos/pkg/server/grpc"
 	httpserver "github.com/thanos-io/thanos/pkg/server/http"
@@ -49,8 +54,11 @@ import (
 	"github.com/thanos-io/thanos/pkg/tls"
 )
 
-func registerSidecar(app *extkingpin.App) {
+func registerSidecar(app *extkingpin.App, runtimeConfig *runtimeconfig.Manager, memLimit *memlimit.Flags, diagnosticsConfig *diagnostics.Flags) {
 	cmd := app.Command(component.Sidecar.String(), "Sidecar for Prometheus server.")
+	runtimeConfig.RegisterFlags(cmd)
+	memLimit.RegisterFlags(cmd)
+	diagnosticsConfig.RegisterFlags(cmd)
 	conf := &sidecarConfig{}
 	conf.registerFlag(cmd)
 	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, _ <-chan struct{}, _ bool) error {
@@ -65,12 +73,15 @@ func registerSidecar(app *extkingpin.App) {
 				ReloadURL:     reloader.ReloadURLFromBase(conf.prometheus.url),
 				CfgFile:       conf.reloader.confFile,
 				CfgOutputFile: conf.reloader.envVarConfFile,
+				CfgTemplate:   conf.reloader.configTemplate,
 				WatchedDirs:   conf.reloader.ruleDirectories,
+				WatchedFiles:  conf.reloader.watchedFiles,
 				WatchInterval: conf.reloader.watchInterval,
+				DelayInterval: conf.reloader.delayInterval,
 				RetryInterval: conf.reloader.retryInterval,
 			})
 
-		return runSidecar(g, logger, reg, tracer, rl, component.Sidecar, *conf, grpcLogOpts, tagOpts)
+		return runSidecar(g, logger, reg, tracer, rl, component.Sidecar, *conf, grpcLogOpts, tagOpts, runtimeConfig)
 	})
 }
 
@@ -84,6 +95,7 @@ func runSidecar(
 	conf sidecarConfig,
 	grpcLogOpts []grpc_logging.Option,
 	tagOpts []tags.Option,
+	runtimeConfig *runtimeconfig.Manager,
 ) error {
 	httpConfContentYaml, err := conf.prometheus.httpClient.Content()
 	if err != nil {
@@ -132,11 +144,22 @@ func runSidecar(
 		prober.NewInstrumentation(comp, logger, extprom.WrapRegistererWithPrefix("thanos_", reg)),
 	)
 
+	httpAuth, err := newHTTPAuthenticator(logger, conf.http.authConfig)
+	if err != nil {
+		return err
+	}
 	srv := httpserver.New(logger, reg, comp, httpProbe,
 		httpserver.WithListen(conf.http.bindAddress),
 		httpserver.WithGracePeriod(time.Duration(conf.http.gracePeriod)),
 		httpserver.WithTLSConfig(conf.http.tlsConfig),
+		httpserver.WithAuthenticator(httpAuth),
 	)
+	srv.Handle(runtimeconfig.APIPath, runtimeConfig)
+	deps, err := setupDependencies(g, logger, reg, &conf.health, httpProbe)
+	if err != nil {
+		return err
+	}
+	deps.Register("prometheus", m.WALReplayed)
 
 	g.Add(func() error {
 		statusProber.Healthy()
@@ -188,6 +211,15 @@ func runSidecar(
 			// Blocking query of external labels before joining as a Source Peer into gossip.
 			// We retry infinitely until we reach and fetch labels from our Prometheus.
 			err = runutil.Retry(2*time.Second, ctx.Done(), func() error {
+				// Prometheus cannot answer queries before it replayed its WAL.
+				if err := m.WALReplayed(ctx); err != nil {
+					level.Warn(logger).Log(
+						"msg", "Prometheus is not ready yet. Retrying",
+						"err", err,
+					)
+					statusProber.NotReady(err)
+					return err
+				}
 				if err := m.UpdateLabels(ctx); err != nil {
 					level.Warn(logger).Log(
 						"msg", "failed to fetch initial external labels. Is Prometheus running? Retrying",
@@ -224,6 +256,10 @@ func runSidecar(
 					level.Warn(logger).Log("msg", "heartbeat failed", "err", err)
 					promUp.Set(0)
 					statusProber.NotReady(err)
+				} else if err := m.WALReplayed(iterCtx); err != nil {
+					level.Warn(logger).Log("msg", "Prometheus is not ready", "err", err)
+					promUp.Set(1)
+					statusProber.NotReady(err)
 				} else {
 					promUp.Set(1)
 					statusProber.Ready()
@@ -257,7 +293,15 @@ func runSidecar(
 			return errors.Wrap(err, "setup gRPC server")
 		}
 
-		exemplarSrv := exemplars.NewPrometheus(conf.prometheus.url, c, m.Labels)
+		metadataCache, err := promclient.NewResponseCache(extprom.WrapRegistererWith(prometheus.Labels{"api": "metadata"}, reg), conf.prometheus.apiCacheTTL, conf.prometheus.apiCacheStaleTTL, conf.prometheus.apiCacheMaxEntries)
+		if err != nil {
+			return errors.Wrap(err, "create metadata response cache")
+		}
+		exemplarsCache, err := promclient.NewResponseCache(extprom.WrapRegistererWith(prometheus.Labels{"api": "exemplars"}, reg), conf.prometheus.apiCacheTTL, conf.prometheus.apiCacheStaleTTL, conf.prometheus.apiCacheMaxEntries)
+		if err != nil {
+			return errors.Wrap(err, "create exemplars response cache")
+		}
+		exemplarSrv := exemplars.NewPrometheus(conf.prometheus.url, c, exemplarsCache, m.Labels)
 
 		infoSrv := info.NewInfoServer(
 			component.Sidecar.String(),
@@ -287,7 +331,7 @@ func runSidecar(
 			grpcserver.WithServer(store.RegisterStoreServer(storeServer, logger)),
 			grpcserver.WithServer(rules.RegisterRulesServer(rules.NewPrometheus(conf.prometheus.url, c, m.Labels))),
 			grpcserver.WithServer(targets.RegisterTargetsServer(targets.NewPrometheus(conf.prometheus.url, c, m.Labels))),
-			grpcserver.WithServer(meta.RegisterMetadataServer(meta.NewPrometheus(conf.prometheus.url, c))),
+			grpcserver.WithServer(meta.RegisterMetadataServer(meta.NewPrometheus(conf.prometheus.url, c, metadataCache))),
 			grpcserver.WithServer(exemplars.RegisterExemplarsServer(exemplarSrv)),
 			grpcserver.WithServer(info.RegisterInfoServer(infoSrv)),
 			grpcserver.WithListen(conf.grpc.bindAddress),
@@ -307,10 +351,11 @@ func runSidecar(
 	if uploads {
 		// The background shipper continuously scans the data directory and uploads
 		// new blocks to Google Cloud Storage or an S3-compatible storage service.
-		bkt, err := client.NewBucket(logger, confContentYaml, reg, component.Sidecar.String())
+		bkt, err := extobjstore.NewBucket(logger, confContentYaml, reg, component.Sidecar.String())
 		if err != nil {
 			return err
 		}
+		deps.Register("objstore", objStoreCheck(bkt))
 
 		// Ensure we close up everything properly.
 		defer func() {
@@ -323,6 +368,11 @@ func runSidecar(
 			level.Error(logger).Log("err", err)
 		}
 
+		labelCollisionBlocks := promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "thanos_sidecar_label_collision_blocks",
+			Help: "Number of blocks in the bucket uploaded by another uploader with the same external labels, overlapping the blocks of Prometheus, as of the last check.",
+		})
+
 		ctx, cancel := context.WithCancel(context.Background())
 		g.Add(func() error {
 			defer runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
@@ -340,11 +390,35 @@ func runSidecar(
 				return errors.Wrapf(err, "aborting as no external labels found after waiting %s", promReadyTimeout)
 			}
 
-			s := shipper.New(logger, reg, conf.tsdb.path, bkt, m.Labels, metadata.SidecarSource,
-				conf.shipper.uploadCompacted, conf.shipper.allowOutOfOrderUpload, metadata.HashFunc(conf.shipper.hashFunc))
-
+			s := shipper.New(logger, reg, conf.tsdb.path, bkt, m.Labels, metadata.SidecarSource, metadata.HashFunc(conf.shipper.hashFunc),
+				shipper.WithUploadCompacted(conf.shipper.uploadCompacted, conf.shipper.skipOverlappingCompacted),
+				shipper.WithAllowOutOfOrderUploads(conf.shipper.allowOutOfOrderUpload),
+				shipper.WithUploadConcurrency(conf.shipper.uploadConcurrency),
+				shipper.WithUploadBandwidthLimit(int64(conf.shipper.uploadBandwidthLimit)),
+			)
+
+			var (
+				collision      bool
+				collisionCheck time.Time
+				uploads        = &uploadedTimes{}
+			)
 			return runutil.Repeat(30*time.Second, ctx.Done(), func() error {
-				if uploaded, err := s.Sync(ctx); err != nil {
+				if conf.labelCollisionCheckInterval > 0 && time.Since(collisionCheck) >= conf.labelCollisionCheckInterval {
+					if ids, err := s.LabelCollisions(ctx); err != nil {
+						level.Warn(logger).Log("msg", "checking the bucket for external label collisions failed", "err", err)
+					} else {
+						collisionCheck = time.Now()
+						collision = len(ids) > 0
+						labelCollisionBlocks.Set(float64(len(ids)))
+						if collision {
+							level.Error(logger).Log("msg", "found blocks uploaded by another uploader with the same external labels, overlapping the blocks of Prometheus; the external labels of every uploader must be unique", "labels", m.Labels().String(), "blocks", fmt.Sprint(ids))
+						}
+					}
+				}
+
+				if collision && conf.refuseOnLabelCollision {
+					level.Warn(logger).Log("msg", "not uploading blocks due to external label collision")
+				} else if uploaded, err := s.Sync(ctx); err != nil {
 					level.Warn(logger).Log("err", err, "uploaded", uploaded)
 				}
 
@@ -353,6 +427,18 @@ func runSidecar(
 					level.Warn(logger).Log("msg", "reading timestamps failed", "err", err)
 					return nil
 				}
+				if conf.stopServingUploadedAfter > 0 {
+					uploadedUntil, err := s.UploadedUntil()
+					if err != nil {
+						level.Warn(logger).Log("msg", "reading uploaded time failed", "err", err)
+						return nil
+					}
+					now := time.Now()
+					uploads.observe(now, uploadedUntil)
+					if until := uploads.uploadedUntilAsOf(now.Add(-conf.stopServingUploadedAfter)); until > minTime {
+						minTime = until
+					}
+				}
 				m.UpdateTimestamps(minTime, math.MaxInt64)
 				return nil
 			})
@@ -402,6 +488,37 @@ func validatePrometheus(ctx context.Context, client *promclient.Client, logger l
 	return nil
 }
 
+// uploadedTimes tracks when the time until which all local blocks were uploaded by the shipper increased, to know
+// which data has been in the bucket for a given duration. It is kept in memory only, so after a restart all local data
+// is served again until the duration passed.
+type uploadedTimes struct {
+	observations []uploadedTime
+}
+
+type uploadedTime struct {
+	at    time.Time
+	until int64
+}
+
+// observe records the time until which all local blocks were uploaded, as of the given time.
+func (u *uploadedTimes) observe(at time.Time, until int64) {
+	if n := len(u.observations); n > 0 && u.observations[n-1].until >= until {
+		return
+	}
+	u.observations = append(u.observations, uploadedTime{at: at, until: until})
+}
+
+// uploadedUntilAsOf returns the time until which all local blocks were uploaded as of the given time, or math.MinInt64
+// if none. Observations older than the returned one are dropped, as the given time is expected to only increase.
+func (u *uploadedTimes) uploadedUntilAsOf(t time.Time) int64 {
+	i := sort.Search(len(u.observations), func(i int) bool { return u.observations[i].at.After(t) })
+	if i == 0 {
+		return math.MinInt64
+	}
+	u.observations = u.observations[i-1:]
+	return u.observations[0].until
+}
+
 type promMetadata struct {
 	promURL *url.URL
 
@@ -429,6 +546,18 @@ func (s *promMetadata) UpdateLabels(ctx context.Context) error {
 	return nil
 }
 
+// WALReplayed returns an error if Prometheus did not replay its WAL yet, e.g. after a restart.
+func (s *promMetadata) WALReplayed(ctx context.Context) error {
+	status, err := s.client.WALReplayStatus(ctx, s.promURL)
+	if err != nil {
+		return errors.Wrap(err, "get WAL replay status")
+	}
+	if status != nil && !status.Done() {
+		return errors.Errorf("Prometheus is replaying its WAL, segment %d of %d", status.Current, status.Max)
+	}
+	return nil
+}
+
 func (s *promMetadata) UpdateTimestamps(mint, maxt int64) {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
@@ -477,6 +606,7 @@ func (s *promMetadata) Version() string {
 
 type sidecarConfig struct {
 	http            httpConfig
+	health          healthConfig
 	grpc            grpcConfig
 	prometheus      prometheusConfig
 	tsdb            tsdbConfig
@@ -486,10 +616,15 @@ type sidecarConfig struct {
 	shipper         shipperConfig
 	limitMinTime    thanosmodel.TimeOrDurationValue
 	storeRateLimits store.SeriesSelectLimits
+
+	labelCollisionCheckInterval time.Duration
+	refuseOnLabelCollision      bool
+	stopServingUploadedAfter    time.Duration
 }
 
 func (sc *sidecarConfig) registerFlag(cmd extkingpin.FlagClause) {
 	sc.http.registerFlag(cmd)
+	sc.health.registerFlag(cmd)
 	sc.grpc.registerFlag(cmd)
 	sc.prometheus.registerFlag(cmd)
 	sc.tsdb.registerFlag(cmd)
@@ -497,7 +632,13 @@ func (sc *sidecarConfig) registerFlag(cmd extkingpin.FlagClause) {
 	sc.reqLogConfig = extkingpin.RegisterRequestLoggingFlags(cmd)
 	sc.objStore = *extkingpin.RegisterCommonObjStoreFlags(cmd, "", false)
 	sc.shipper.registerFlag(cmd)
+	cmd.Flag("shipper.label-collision-check-interval", "How often to check the bucket for blocks uploaded by another uploader with the same external labels, which overlap the blocks of Prometheus. The check also runs on startup. 0 disables the check.").
+		Default("1h").DurationVar(&sc.labelCollisionCheckInterval)
+	cmd.Flag("shipper.refuse-on-label-collision", "If true, blocks are not uploaded while blocks uploaded by another uploader with the same external labels are found in the bucket. Otherwise, the collision is only logged and reported by the thanos_sidecar_label_collision_blocks metric.").
+		Default("false").BoolVar(&sc.refuseOnLabelCollision)
 	sc.storeRateLimits.RegisterFlags(cmd)
 	cmd.Flag("min-time", "Start of time range limit to serve. Thanos sidecar will serve only metrics, which happened later than this value. Option can be a constant time in RFC3339 format or time duration relative to current time, such as -1d or 2h45m. Valid duration units are ms, s, m, h, d, w, y.").
 		Default("0000-01-01T00:00:00Z").SetValue(&sc.limitMinTime)
+	cmd.Flag("min-time.uploaded-after", "If set, Thanos sidecar stops serving metrics once the blocks containing them were uploaded to the bucket for longer than this duration, as they are served by the Store Gateway from the bucket. It should be longer than the time the Store Gateway takes to load new blocks, including the consistency delay. Unlike a static --min-time, no gap is created when uploads lag. Takes effect only if uploads are enabled. 0 disables it.").
+		Default("0s").DurationVar(&sc.stopServingUploadedAfter)
 }
@@ -0,0 +1,32 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+)
+
+func TestUploadedTimes(t *testing.T) {
+	var (
+		u     = &uploadedTimes{}
+		start = time.Unix(0, 0)
+	)
+	testutil.Equals(t, int64(math.MinInt64), u.uploadedUntilAsOf(start))
+
+	u.observe(start, math.MinInt64)
+	u.observe(start.Add(time.Minute), 100)
+	u.observe(start.Add(2*time.Minute), 100)
+	u.observe(start.Add(3*time.Minute), 200)
+
+	testutil.Equals(t, int64(math.MinInt64), u.uploadedUntilAsOf(start.Add(30*time.Second)))
+	testutil.Equals(t, int64(100), u.uploadedUntilAsOf(start.Add(time.Minute)))
+	// The time observed later again does not delay it.
+	testutil.Equals(t, int64(100), u.uploadedUntilAsOf(start.Add(150*time.Second)))
+	testutil.Equals(t, int64(200), u.uploadedUntilAsOf(start.Add(time.Hour)))
+	testutil.Equals(t, 1, len(u.observations))
+}
@@ -19,7 +19,6 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/route"
-	"github.com/thanos-io/objstore/client"
 
 	commonmodel "github.com/prometheus/common/model"
 
@@ -28,17 +27,24 @@ import (
 	blocksAPI "github.com/thanos-io/thanos/pkg/api/blocks"
 	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/cacheutil"
 	"github.com/thanos-io/thanos/pkg/component"
+	"github.com/thanos-io/thanos/pkg/diagnostics"
 	hidden "github.com/thanos-io/thanos/pkg/extflag"
 	"github.com/thanos-io/thanos/pkg/extkingpin"
+	"github.com/thanos-io/thanos/pkg/extobjstore"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
+	"github.com/thanos-io/thanos/pkg/featuregate"
 	"github.com/thanos-io/thanos/pkg/gate"
 	"github.com/thanos-io/thanos/pkg/info"
 	"github.com/thanos-io/thanos/pkg/info/infopb"
 	"github.com/thanos-io/thanos/pkg/logging"
+	"github.com/thanos-io/thanos/pkg/memlimit"
 	"github.com/thanos-io/thanos/pkg/model"
+	"github.com/thanos-io/thanos/pkg/overrides"
 	"github.com/thanos-io/thanos/pkg/prober"
+	"github.com/thanos-io/thanos/pkg/runtimeconfig"
 	"github.com/thanos-io/thanos/pkg/runutil"
 	grpcserver "github.com/thanos-io/thanos/pkg/server/grpc"
 	httpserver "github.com/thanos-io/thanos/pkg/server/http"
@@ -61,10 +67,12 @@ type storeConfig struct {
 	cacheIndexHeader            bool
 	grpcConfig                  grpcConfig
 	httpConfig                  httpConfig
+	healthConfig                healthConfig
 	indexCacheSizeBytes         units.Base2Bytes
 	chunkPoolSize               units.Base2Bytes
 	seriesBatchSize             int
 	storeRateLimits             store.SeriesSelectLimits
+	overrides                   *overrides.Manager
 	maxDownloadedBytes          units.Base2Bytes
 	maxConcurrency              int
 	component                   component.StoreAPI
@@ -85,12 +93,16 @@ type storeConfig struct {
 	reqLogConfig                *extflag.PathOrContent
 	lazyIndexReaderEnabled      bool
 	lazyIndexReaderIdleTimeout  time.Duration
+	getRangeTimeout             commonmodel.Duration
 }
 
 func (sc *storeConfig) registerFlag(cmd extkingpin.FlagClause) {
 	sc.httpConfig = *sc.httpConfig.registerFlag(cmd)
 	sc.grpcConfig = *sc.grpcConfig.registerFlag(cmd)
+	sc.healthConfig = *sc.healthConfig.registerFlag(cmd)
 	sc.storeRateLimits.RegisterFlags(cmd)
+	sc.overrides = overrides.NewManager()
+	sc.overrides.RegisterFlags(cmd)
 
 	cmd.Flag("data-dir", "Local data directory used for caching purposes (index-header, in-mem cache items and meta.jsons). If removed, no data will be lost, just store will have to rebuild the cache. NOTE: Putting raw blocks here will not cause the store to read them. For such use cases use Prometheus + sidecar. Ignored if --no-cache-index-header option is specified.").
 		Default("./data").StringVar(&sc.dataDir)
@@ -173,6 +185,9 @@ func (sc *storeConfig) registerFlag(cmd extkingpin.FlagClause) {
 	cmd.Flag("store.index-header-lazy-reader-idle-timeout", "If index-header lazy reader is enabled and this idle timeout setting is > 0, memory map-ed index-headers will be automatically released after 'idle timeout' inactivity.").
 		Hidden().Default("5m").DurationVar(&sc.lazyIndexReaderIdleTimeout)
 
+	cmd.Flag("store.objstore.get-range-timeout", "Timeout of GetRange operations against the object storage whose caller has no deadline, e.g. lazy loading of index-headers, so that a hung connection does not block the Store Gateway indefinitely. Deadlines of queries apply otherwise. 0 disables the timeout. The deadlines section of the bucket configuration applies to all operations too.").
+		Default("0s").SetValue(&sc.getRangeTimeout)
+
 	cmd.Flag("web.disable", "Disable Block Viewer UI.").Default("false").BoolVar(&sc.disableWeb)
 
 	cmd.Flag("web.external-prefix", "Static prefix for all HTML links and redirect URLs in the bucket web UI interface. Actual endpoints are still served on / or the web.route-prefix. This allows thanos bucket web UI to be served behind a reverse proxy that strips a URL sub-path.").
@@ -190,8 +205,12 @@ func (sc *storeConfig) registerFlag(cmd extkingpin.FlagClause) {
 }
 
 // registerStore registers a store command.
-func registerStore(app *extkingpin.App) {
+func registerStore(app *extkingpin.App, runtimeConfig *runtimeconfig.Manager, featureGates *featuregate.Gates, memLimit *memlimit.Flags, diagnosticsConfig *diagnostics.Flags) {
 	cmd := app.Command(component.Store.String(), "Store node giving access to blocks in a bucket provider. Now supported GCS, S3, Azure, Swift, Tencent COS and Aliyun OSS.")
+	runtimeConfig.RegisterFlags(cmd)
+	memLimit.RegisterFlags(cmd)
+	diagnosticsConfig.RegisterFlags(cmd)
+	featureGates.RegisterFlags(cmd)
 
 	conf := &storeConfig{}
 	conf.registerFlag(cmd)
@@ -223,6 +242,8 @@ func registerStore(app *extkingpin.App) {
 			tagOpts,
 			*conf,
 			getFlagsMap(cmd.Flags()),
+			runtimeConfig,
+			featureGates,
 		)
 	})
 }
@@ -238,6 +259,8 @@ func runStore(
 	tagOpts []tags.Option,
 	conf storeConfig,
 	flagsMap map[string]string,
+	runtimeConfig *runtimeconfig.Manager,
+	featureGates *featuregate.Gates,
 ) error {
 	dataDir := conf.dataDir
 	if !conf.cacheIndexHeader {
@@ -252,12 +275,29 @@ func runStore(
 		prober.NewInstrumentation(conf.component, logger, extprom.WrapRegistererWithPrefix("thanos_", reg)),
 	)
 
+	httpAuth, err := newHTTPAuthenticator(logger, conf.httpConfig.authConfig)
+	if err != nil {
+		return err
+	}
 	srv := httpserver.New(logger, reg, conf.component, httpProbe,
 		httpserver.WithListen(conf.httpConfig.bindAddress),
 		httpserver.WithGracePeriod(time.Duration(conf.httpConfig.gracePeriod)),
 		httpserver.WithTLSConfig(conf.httpConfig.tlsConfig),
+		httpserver.WithAuthenticator(httpAuth),
 		httpserver.WithEnableH2C(true), // For groupcache.
 	)
+	srv.Handle(runtimeconfig.APIPath, runtimeConfig)
+	srv.Handle(featuregate.APIPath, featureGates)
+	deps, err := setupDependencies(g, logger, reg, &conf.healthConfig, httpProbe)
+	if err != nil {
+		return err
+	}
+
+	tenantOverrides, err := setupOverrides(g, logger, reg, conf.overrides, conf.component)
+	if err != nil {
+		return err
+	}
+	srv.Handle(overrides.APIPath, tenantOverrides)
 
 	g.Add(func() error {
 		statusProber.Healthy()
@@ -275,10 +315,14 @@ func runStore(
 		return err
 	}
 
-	bkt, err := client.NewBucket(logger, confContentYaml, reg, conf.component.String())
+	bkt, err := extobjstore.NewBucket(logger, confContentYaml, reg, conf.component.String())
 	if err != nil {
 		return errors.Wrap(err, "create bucket client")
 	}
+	deps.Register("objstore", objStoreCheck(bkt))
+	if conf.getRangeTimeout > 0 {
+		bkt = extobjstore.WrapWithDeadlines(bkt, extobjstore.DeadlinesConfig{GetRangeTimeout: conf.getRangeTimeout})
+	}
 
 	cachingBucketConfigYaml, err := conf.cachingBucketConfig.Content()
 	if err != nil {
@@ -323,6 +367,9 @@ func runStore(
 	if err != nil {
 		return errors.Wrap(err, "create index cache")
 	}
+	if p, ok := indexCache.(cacheutil.Pinger); ok {
+		deps.Register("index-cache", p.Ping)
+	}
 
 	ignoreDeletionMarkFilter := block.NewIgnoreDeletionMarkFilter(logger, bkt, time.Duration(conf.ignoreDeletionMarksDelay), conf.blockMetaFetchConcurrency)
 	metaFetcher, err := block.NewMetaFetcher(logger, conf.blockMetaFetchConcurrency, bkt, dataDir, extprom.WrapRegistererWithPrefix("thanos_", reg),
@@ -364,6 +411,12 @@ func runStore(
 		options = append(options, store.WithDebugLogging())
 	}
 
+	// The limits of the runtime configuration and the per-tenant overrides are applied to new requests, instead of the
+	// limits of the chunks and series limiter factories.
+	options = append(options, store.WithTenantSeriesSelectLimits(tenantSeriesSelectLimits(tenantOverrides, conf.storeRateLimits)))
+	options = append(options, store.WithPostingsS2Encoding(func() bool {
+		return featureGates.Enabled(featuregate.PostingsS2Encoding)
+	}))
 	bs, err := store.NewBucketStore(
 		bkt,
 		metaFetcher,
@@ -28,6 +28,10 @@ func registerTools(app *extkingpin.App) {
 
 	registerBucket(cmd)
 	registerCheckRules(cmd)
+	registerRulesBackfill(cmd)
+	registerImport(cmd)
+	registerQueryTools(cmd)
+	registerBlockTools(cmd)
 }
 
 func (tc *checkRulesConfig) registerFlag(cmd extkingpin.FlagClause) *checkRulesConfig {
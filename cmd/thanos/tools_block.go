@@ -0,0 +1,265 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alecthomas/units"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/run"
+	"github.com/oklog/ulid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/extkingpin"
+	"github.com/thanos-io/thanos/pkg/extobjstore"
+	"github.com/thanos-io/thanos/pkg/model"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+type blockDumpConfig struct {
+	blockDir string
+	blockID  string
+	match    string
+	minTime  model.TimeOrDurationValue
+	maxTime  model.TimeOrDurationValue
+	format   string
+	tmpDir   string
+}
+
+func (tbc *blockDumpConfig) registerFlag(cmd extkingpin.FlagClause) *blockDumpConfig {
+	cmd.Flag("block.dir", "Directory of the block to dump. Either this or --id has to be given.").StringVar(&tbc.blockDir)
+	cmd.Flag("id", "ID (ULID) of the block in the bucket to dump. The block is downloaded to --tmp.dir first.").StringVar(&tbc.blockID)
+	cmd.Flag("match", "Series selector of the dumped series, e.g. 'up{job=\"node\"}'. All series are dumped if not set.").StringVar(&tbc.match)
+	cmd.Flag("min-time", "Start of the time range of the dumped samples. Option can be a constant time in RFC3339 format or time duration relative to current time, such as -1d or 2h45m. Valid duration units are ms, s, m, h, d, w, y.").
+		Default("0000-01-01T00:00:00Z").SetValue(&tbc.minTime)
+	cmd.Flag("max-time", "End of the time range of the dumped samples. Option can be a constant time in RFC3339 format or time duration relative to current time, such as -1d or 2h45m. Valid duration units are ms, s, m, h, d, w, y.").
+		Default("9999-12-31T23:59:59Z").SetValue(&tbc.maxTime)
+	cmd.Flag("format", "Format of the dump: \"text\" prints each sample with its series labels and timestamp in milliseconds, \"openmetrics\" writes the float samples in the OpenMetrics text format, "+
+		"\"chunks\" prints the reference, time range, encoding and number of samples of each chunk instead of the samples.").
+		Default(string(block.DumpText)).EnumVar(&tbc.format, string(block.DumpText), string(block.DumpOpenMetrics), string(block.DumpChunks))
+	cmd.Flag("tmp.dir", "Directory the block given with --id is downloaded to.").
+		Default(filepath.Join(os.TempDir(), "thanos-block-dump")).StringVar(&tbc.tmpDir)
+	return tbc
+}
+
+type blockSplitConfig struct {
+	blockID      string
+	duration     time.Duration
+	maxIndexSize units.Base2Bytes
+	tmpDir       string
+	hashFunc     string
+	dryRun       bool
+	deleteSource bool
+}
+
+func (tbc *blockSplitConfig) registerFlag(cmd extkingpin.FlagClause) *blockSplitConfig {
+	cmd.Flag("id", "ID (ULID) of the block in the bucket to split.").Required().StringVar(&tbc.blockID)
+	cmd.Flag("split.duration", "Duration of the blocks the block is split into. The blocks are aligned to it, so use one of the compaction ranges, e.g. 2h or 2d, for them to be compacted as usual.").
+		DurationVar(&tbc.duration)
+	cmd.Flag("split.max-index-size", "Maximum index size of the blocks the block is split into, as an alternative to --split.duration. "+
+		"The duration of the blocks is estimated from the index size of the block, assuming it is proportional to its time range.").
+		BytesVar(&tbc.maxIndexSize)
+	cmd.Flag("tmp.dir", "Working directory for temporary files").Default(filepath.Join(os.TempDir(), "thanos-block-split")).StringVar(&tbc.tmpDir)
+	cmd.Flag("hash-func", "Specify which hash function to use when calculating the hashes of produced files. If no function has been specified, it does not happen. This permits avoiding downloading some files twice albeit at some performance cost. Possible values are: \"\", \"SHA256\".").
+		Default("").EnumVar(&tbc.hashFunc, "SHA256", "")
+	cmd.Flag("dry-run", "Splits the block locally and prints the resulting blocks without uploading them. Defaults to true, for user to double check. Pass --no-dry-run to upload them.").
+		Default("true").BoolVar(&tbc.dryRun)
+	cmd.Flag("delete-source", "Whether to mark the split block for deletion after all resulting blocks were uploaded, so it does not overlap with them. Available in non dry-run mode only.").
+		Default("true").BoolVar(&tbc.deleteSource)
+	return tbc
+}
+
+func registerBlockTools(app extkingpin.AppClause) {
+	cmd := app.Command("block", "Block utility commands")
+
+	registerBlockDump(cmd)
+	registerBlockSplit(cmd)
+}
+
+func registerBlockDump(app extkingpin.AppClause) {
+	cmd := app.Command("dump", "Dump the series and samples or chunks of a block to stdout, e.g. to inspect corrupted or suspicious blocks without loading them into a Prometheus. "+
+		"Series which cannot be read are logged and skipped.")
+	tbc := &blockDumpConfig{}
+	tbc.registerFlag(cmd)
+	objStoreConfig := extkingpin.RegisterCommonObjStoreFlags(cmd, "", false)
+
+	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		if (tbc.blockDir == "") == (tbc.blockID == "") {
+			return errors.New("either --block.dir or --id has to be given")
+		}
+
+		var matchers []*labels.Matcher
+		if tbc.match != "" {
+			var err error
+			if matchers, err = parser.ParseMetricSelector(tbc.match); err != nil {
+				return errors.Wrap(err, "parse series selector")
+			}
+		}
+
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		dir := tbc.blockDir
+		if tbc.blockID != "" {
+			id, err := ulid.Parse(tbc.blockID)
+			if err != nil {
+				return errors.Wrapf(err, "parse block ID %q", tbc.blockID)
+			}
+
+			confContentYaml, err := objStoreConfig.Content()
+			if err != nil {
+				return err
+			}
+			bkt, err := extobjstore.NewBucket(logger, confContentYaml, reg, "block-dump")
+			if err != nil {
+				return err
+			}
+			defer runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+
+			dir = filepath.Join(tbc.tmpDir, id.String())
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+			if err := block.Download(context.Background(), logger, bkt, id, dir); err != nil {
+				return errors.Wrapf(err, "download block %s", id)
+			}
+			defer func() {
+				if err := os.RemoveAll(dir); err != nil {
+					level.Warn(logger).Log("msg", "failed to delete dir", "dir", dir, "err", err)
+				}
+			}()
+		}
+
+		stats, err := block.Dump(logger, dir, os.Stdout, block.DumpOptions{
+			MinTime:  tbc.minTime.PrometheusTimestamp(),
+			MaxTime:  tbc.maxTime.PrometheusTimestamp(),
+			Matchers: matchers,
+			Format:   block.DumpFormat(tbc.format),
+		})
+		if err != nil {
+			return errors.Wrap(err, "dump block")
+		}
+		level.Info(logger).Log("msg", "dump done", "series", stats.Series, "samples", stats.Samples, "chunks", stats.Chunks, "skipped", stats.Skipped, "errors", stats.Errors)
+		if stats.Errors > 0 {
+			return errors.Errorf("%d series could not be read", stats.Errors)
+		}
+		return nil
+	})
+}
+
+func registerBlockSplit(app extkingpin.AppClause) {
+	cmd := app.Command("split", "Split a block in the bucket into multiple blocks of a given duration or maximum index size, "+
+		"e.g. a too large block produced by a misconfigured backfill. The resulting blocks keep the external labels of the block and record it as rewritten block. "+
+		"NOTE: It's recommended to turn off compactor while doing this operation.")
+	tbc := &blockSplitConfig{}
+	tbc.registerFlag(cmd)
+	objStoreConfig := extkingpin.RegisterCommonObjStoreFlags(cmd, "", true)
+
+	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		id, err := ulid.Parse(tbc.blockID)
+		if err != nil {
+			return errors.Wrapf(err, "parse block ID %q", tbc.blockID)
+		}
+		if (tbc.duration > 0) == (tbc.maxIndexSize > 0) {
+			return errors.New("either --split.duration or --split.max-index-size has to be given")
+		}
+
+		confContentYaml, err := objStoreConfig.Content()
+		if err != nil {
+			return err
+		}
+		bkt, err := extobjstore.NewBucket(logger, confContentYaml, reg, "block-split")
+		if err != nil {
+			return err
+		}
+
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		defer runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+
+		if err := os.RemoveAll(tbc.tmpDir); err != nil {
+			return err
+		}
+		defer func() {
+			if err := os.RemoveAll(tbc.tmpDir); err != nil {
+				level.Warn(logger).Log("msg", "failed to delete dir", "dir", tbc.tmpDir, "err", err)
+			}
+		}()
+
+		ctx := context.Background()
+		level.Info(logger).Log("msg", "downloading block", "block", id)
+		dir := filepath.Join(tbc.tmpDir, id.String())
+		if err := block.Download(ctx, logger, bkt, id, dir); err != nil {
+			return errors.Wrapf(err, "download block %s", id)
+		}
+
+		splitDir := filepath.Join(tbc.tmpDir, "split")
+		metas, err := block.Split(ctx, logger, dir, splitDir, block.SplitOptions{Duration: tbc.duration, MaxIndexSize: int64(tbc.maxIndexSize)})
+		if err != nil {
+			return errors.Wrapf(err, "split block %s", id)
+		}
+		if err := printSplitBlocks(os.Stdout, metas); err != nil {
+			return err
+		}
+		if tbc.dryRun {
+			level.Info(logger).Log("msg", "dry run finished, pass --no-dry-run to upload the blocks", "block", id, "blocks", len(metas))
+			return nil
+		}
+
+		stubCounter := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
+		for i, m := range metas {
+			if err := block.Upload(ctx, logger, bkt, filepath.Join(splitDir, m.ULID.String()), metadata.HashFunc(tbc.hashFunc)); err != nil {
+				// Remove the already uploaded blocks, which overlap with the source block.
+				for _, uploaded := range metas[:i] {
+					if err := block.MarkForDeletion(ctx, logger, bkt, uploaded.ULID, "block split failed", stubCounter); err != nil {
+						level.Error(logger).Log("msg", "failed to mark block for deletion", "id", uploaded.ULID, "err", err)
+					}
+				}
+				return errors.Wrapf(err, "upload block %s", m.ULID)
+			}
+			level.Info(logger).Log("msg", "uploaded block", "source", id, "new", m.ULID)
+		}
+
+		if tbc.deleteSource {
+			if err := block.MarkForDeletion(ctx, logger, bkt, id, "block split", stubCounter); err != nil {
+				return errors.Wrapf(err, "mark block %s for deletion", id)
+			}
+		}
+		level.Info(logger).Log("msg", "split done", "block", id, "blocks", len(metas))
+		return nil
+	})
+}
+
+func printSplitBlocks(w io.Writer, metas []*metadata.Meta) error {
+	p := message.NewPrinter(language.English)
+	t := Table{Header: []string{"ULID", "FROM", "UNTIL", "RANGE", "#SERIES", "#SAMPLES", "#CHUNKS"}}
+	for _, m := range metas {
+		t.Lines = append(t.Lines, []string{
+			m.ULID.String(),
+			time.Unix(m.MinTime/1000, 0).Format(time.RFC3339),
+			time.Unix(m.MaxTime/1000, 0).Format(time.RFC3339),
+			(time.Duration(m.MaxTime-m.MinTime) * time.Millisecond).String(),
+			p.Sprintf("%d", m.Stats.NumSeries),
+			p.Sprintf("%d", m.Stats.NumSamples),
+			p.Sprintf("%d", m.Stats.NumChunks),
+		})
+	}
+	return printTable(w, t)
+}
@@ -10,8 +10,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -19,6 +21,7 @@ import (
 	"text/template"
 	"time"
 
+	"github.com/alecthomas/units"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/oklog/run"
@@ -30,12 +33,15 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	prommodel "github.com/prometheus/common/model"
 	"github.com/prometheus/common/route"
+	"github.com/prometheus/common/version"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/promql/parser"
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/tombstones"
 	"github.com/thanos-io/objstore"
-	"github.com/thanos-io/objstore/client"
 
 	extflag "github.com/efficientgo/tools/extkingpin"
 	"golang.org/x/text/language"
@@ -50,6 +56,7 @@ import (
 	"github.com/thanos-io/thanos/pkg/compactv2"
 	"github.com/thanos-io/thanos/pkg/component"
 	"github.com/thanos-io/thanos/pkg/extkingpin"
+	"github.com/thanos-io/thanos/pkg/extobjstore"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
 	"github.com/thanos-io/thanos/pkg/logging"
@@ -67,14 +74,15 @@ const extpromPrefix = "thanos_bucket_"
 
 var (
 	issuesVerifiersRegistry = verifier.Registry{
-		Verifiers: []verifier.Verifier{verifier.OverlappedBlocksIssue{}},
+		Verifiers: []verifier.Verifier{verifier.OverlappedBlocksIssue{}, verifier.IndexChunksMismatchIssue{}},
 		VerifierRepairers: []verifier.VerifierRepairer{
 			verifier.IndexKnownIssues{},
 			verifier.DuplicatedCompactionBlocks{},
+			verifier.DuplicatedBlocks{},
 		},
 	}
-	inspectColumns = []string{"ULID", "FROM", "UNTIL", "RANGE", "UNTIL-DOWN", "#SERIES", "#SAMPLES", "#CHUNKS", "COMP-LEVEL", "COMP-FAILED", "LABELS", "RESOLUTION", "SOURCE"}
-	outputTypes    = []string{"table", "tsv", "csv"}
+	inspectColumns = []string{"ULID", "FROM", "UNTIL", "RANGE", "UNTIL-DOWN", "#SERIES", "#SAMPLES", "#CHUNKS", "COMP-LEVEL", "COMP-FAILED", "LABELS", "RESOLUTION", "SOURCE", "SIZE"}
+	outputTypes    = []string{"table", "tsv", "csv", "json"}
 )
 
 type outputType string
@@ -83,6 +91,7 @@ const (
 	TABLE outputType = "table"
 	CSV   outputType = "csv"
 	TSV   outputType = "tsv"
+	JSON  outputType = "json"
 )
 
 type bucketRewriteConfig struct {
@@ -94,9 +103,12 @@ type bucketRewriteConfig struct {
 }
 
 type bucketInspectConfig struct {
-	selector []string
-	sortBy   []string
-	timeout  time.Duration
+	selector    []string
+	matcherStrs string
+	resolutions []time.Duration
+	filterConf  store.FilterConfig
+	sortBy      []string
+	timeout     time.Duration
 }
 
 type bucketVerifyConfig struct {
@@ -108,6 +120,9 @@ type bucketVerifyConfig struct {
 type bucketLsConfig struct {
 	output        string
 	excludeDelete bool
+	matcherStrs   string
+	resolutions   []time.Duration
+	aggregate     bool
 }
 
 type bucketWebConfig struct {
@@ -121,23 +136,31 @@ type bucketWebConfig struct {
 }
 
 type bucketReplicateConfig struct {
-	resolutions []time.Duration
-	compactions []int
-	matcherStrs string
-	singleRun   bool
+	resolutions     []time.Duration
+	compactions     []int
+	matcherStrs     string
+	singleRun       bool
+	concurrency     int
+	progressFile    string
+	verifyChecksums bool
+	bandwidthLimit  units.Base2Bytes
 }
 
 type bucketDownsampleConfig struct {
 	waitInterval          time.Duration
 	downsampleConcurrency int
+	seriesMemoryBudget    units.Base2Bytes
 	dataDir               string
 	hashFunc              string
+	ids                   []string
+	resolution            time.Duration
 }
 
 type bucketCleanupConfig struct {
 	consistencyDelay     time.Duration
 	blockSyncConcurrency int
 	deleteDelay          time.Duration
+	deleteDelayConf      extflag.PathOrContent
 }
 
 type bucketRetentionConfig struct {
@@ -146,11 +169,43 @@ type bucketRetentionConfig struct {
 	deleteDelay          time.Duration
 }
 
+type bucketReconcileConfig struct {
+	dir         string
+	concurrency int
+	output      string
+}
+
+type bucketAnalyzeConfig struct {
+	ids      []string
+	selector []string
+	limit    int
+	tmpDir   string
+	output   string
+	timeout  time.Duration
+}
+
+type bucketCleanupPartialConfig struct {
+	olderThan time.Duration
+	dryRun    bool
+	timeout   time.Duration
+}
+
+type bucketCleanupDebugMetasConfig struct {
+	olderThan time.Duration
+	dryRun    bool
+	timeout   time.Duration
+}
+
 type bucketMarkBlockConfig struct {
 	details      string
 	marker       string
 	blockIDs     []string
 	removeMarker bool
+	minTime      *model.TimeOrDurationValue
+	maxTime      *model.TimeOrDurationValue
+	matcherStrs  string
+	resolutions  []time.Duration
+	dryRun       bool
 }
 
 func (tbc *bucketVerifyConfig) registerBucketVerifyFlag(cmd extkingpin.FlagClause) *bucketVerifyConfig {
@@ -172,12 +227,22 @@ func (tbc *bucketLsConfig) registerBucketLsFlag(cmd extkingpin.FlagClause) *buck
 		Short('o').Default("").StringVar(&tbc.output)
 	cmd.Flag("exclude-delete", "Exclude blocks marked for deletion.").
 		Default("false").BoolVar(&tbc.excludeDelete)
+	cmd.Flag("matcher", "Only blocks whose external labels match this matcher are listed. All Prometheus matchers are supported, including =, !=, =~ and !~.").StringVar(&tbc.matcherStrs)
+	cmd.Flag("resolution", "Only blocks with these resolutions are listed. Repeated flag. All resolutions if not set.").HintAction(listResLevel).DurationListVar(&tbc.resolutions)
+	cmd.Flag("aggregate", "Instead of the blocks, print the number of blocks, series, samples and bytes per external label set. Only the 'json' output format is supported with it, otherwise a table is printed.").
+		Default("false").BoolVar(&tbc.aggregate)
 	return tbc
 }
 
 func (tbc *bucketInspectConfig) registerBucketInspectFlag(cmd extkingpin.FlagClause) *bucketInspectConfig {
 	cmd.Flag("selector", "Selects blocks based on label, e.g. '-l key1=\\\"value1\\\" -l key2=\\\"value2\\\"'. All key value pairs must match.").Short('l').
 		PlaceHolder("<name>=\\\"<value>\\\"").StringsVar(&tbc.selector)
+	cmd.Flag("matcher", "Only blocks whose external labels match this matcher are inspected. All Prometheus matchers are supported, including =, !=, =~ and !~.").StringVar(&tbc.matcherStrs)
+	cmd.Flag("resolution", "Only blocks with these resolutions are inspected. Repeated flag. All resolutions if not set.").HintAction(listResLevel).DurationListVar(&tbc.resolutions)
+	cmd.Flag("min-time", "Start of time range limit to inspect. Only blocks with data later than this value are inspected. Option can be a constant time in RFC3339 format or time duration relative to current time, such as -1d or 2h45m. Valid duration units are ms, s, m, h, d, w, y.").
+		Default("0000-01-01T00:00:00Z").SetValue(&tbc.filterConf.MinTime)
+	cmd.Flag("max-time", "End of time range limit to inspect. Only blocks with data earlier than this value are inspected. Option can be a constant time in RFC3339 format or time duration relative to current time, such as -1d or 2h45m. Valid duration units are ms, s, m, h, d, w, y.").
+		Default("9999-12-31T23:59:59Z").SetValue(&tbc.filterConf.MaxTime)
 	cmd.Flag("sort-by", "Sort by columns. It's also possible to sort by multiple columns, e.g. '--sort-by FROM --sort-by UNTIL'. I.e., if the 'FROM' value is equal the rows are then further sorted by the 'UNTIL' value.").
 		Default("FROM", "UNTIL").EnumsVar(&tbc.sortBy, inspectColumns...)
 	cmd.Flag("timeout", "Timeout to download metadata from remote storage").Default("5m").DurationVar(&tbc.timeout)
@@ -211,6 +276,14 @@ func (tbc *bucketReplicateConfig) registerBucketReplicateFlag(cmd extkingpin.Fla
 
 	cmd.Flag("single-run", "Run replication only one time, then exit.").Default("false").BoolVar(&tbc.singleRun)
 
+	cmd.Flag("concurrency", "Number of blocks replicated concurrently. Blocks are started in order of their minimum time, so with a concurrency above 1 newer blocks can be replicated before older ones complete.").Default("1").IntVar(&tbc.concurrency)
+
+	cmd.Flag("progress-file", "Local file the progress of the replication is persisted to after each replicated object, to resume an interrupted replication without checking the replicated objects again. Delete it to replicate blocks deleted from the target bucket again. Empty means the progress is not persisted.").Default("").StringVar(&tbc.progressFile)
+
+	cmd.Flag("verify-checksums", "Read back each object replicated to the target bucket, and compare its SHA256 hash with the hash of the origin object and with the hash in the meta.json of the block if any. Objects with mismatching hashes are deleted from the target bucket, and fail the replication.").Default("false").BoolVar(&tbc.verifyChecksums)
+
+	cmd.Flag("bandwidth-limit", "Maximum number of bytes per second copied to the target bucket, shared by all concurrently replicated blocks. 0 means no limit.").Default("0").BytesVar(&tbc.bandwidthLimit)
+
 	return tbc
 }
 
@@ -229,24 +302,42 @@ func (tbc *bucketDownsampleConfig) registerBucketDownsampleFlag(cmd extkingpin.F
 		Default("5m").DurationVar(&tbc.waitInterval)
 	cmd.Flag("downsample.concurrency", "Number of goroutines to use when downsampling blocks.").
 		Default("1").IntVar(&tbc.downsampleConcurrency)
+	cmd.Flag("downsample.series-memory-budget", "Maximum memory used to buffer raw samples of a single series while downsampling. Raw chunks are processed one at a time, and once the budget is exceeded, "+
+		"complete aggregation windows are aggregated and released, so huge blocks can be downsampled with bounded memory. 0 means all samples of a series are buffered.").
+		Default("0").BytesVar(&tbc.seriesMemoryBudget)
 	cmd.Flag("data-dir", "Data directory in which to cache blocks and process downsamplings.").
 		Default("./data").StringVar(&tbc.dataDir)
 	cmd.Flag("hash-func", "Specify which hash function to use when calculating the hashes of produced files. If no function has been specified, it does not happen. This permits avoiding downloading some files twice albeit at some performance cost. Possible values are: \"\", \"SHA256\".").
 		Default("").EnumVar(&tbc.hashFunc, "SHA256", "")
+	cmd.Flag("id", "ID (ULID) of the blocks to downsample once, instead of continuously downsampling all blocks (repeated flag). "+
+		"The downsampled blocks are uploaded and the command exits. Blocks marked for no downsampling are downsampled too.").StringsVar(&tbc.ids)
+	cmd.Flag("resolution", "Resolution the blocks given with --id are downsampled to, 5m or 1h. 0s downsamples them to the next resolution: 5m for raw blocks and 1h for 5m blocks.").
+		Default("0s").HintOptions("0s", "5m", "1h").DurationVar(&tbc.resolution)
 
 	return tbc
 }
 
 func (tbc *bucketMarkBlockConfig) registerBucketMarkBlockFlag(cmd extkingpin.FlagClause) *bucketMarkBlockConfig {
-	cmd.Flag("id", "ID (ULID) of the blocks to be marked for deletion (repeated flag)").Required().StringsVar(&tbc.blockIDs)
+	cmd.Flag("id", "ID (ULID) of the blocks to be marked for deletion (repeated flag). Either IDs or --min-time, --max-time, --matcher and --resolution have to be given.").StringsVar(&tbc.blockIDs)
 	cmd.Flag("marker", "Marker to be put.").Required().EnumVar(&tbc.marker, metadata.DeletionMarkFilename, metadata.NoCompactMarkFilename, metadata.NoDownsampleMarkFilename)
 	cmd.Flag("details", "Human readable details to be put into marker.").StringVar(&tbc.details)
 	cmd.Flag("remove", "Remove the marker.").Default("false").BoolVar(&tbc.removeMarker)
+	tbc.minTime = model.TimeOrDuration(cmd.Flag("min-time", "Select the blocks with all their data later than this value instead of giving their IDs. Option can be a constant time in RFC3339 format or time duration relative to current time, such as -1d or 2h45m. Valid duration units are ms, s, m, h, d, w, y."))
+	tbc.maxTime = model.TimeOrDuration(cmd.Flag("max-time", "Select the blocks with all their data earlier than this value instead of giving their IDs. Option can be a constant time in RFC3339 format or time duration relative to current time, such as -1d or 2h45m. Valid duration units are ms, s, m, h, d, w, y."))
+	cmd.Flag("matcher", "Select the blocks whose external labels match this matcher instead of giving their IDs. All Prometheus matchers are supported, including =, !=, =~ and !~.").StringVar(&tbc.matcherStrs)
+	cmd.Flag("resolution", "Select the blocks with these resolutions instead of giving their IDs. Repeated flag.").HintAction(listResLevel).DurationListVar(&tbc.resolutions)
+	cmd.Flag("dry-run", "Only list the blocks selected by --min-time, --max-time, --matcher and --resolution without marking them. Use --no-dry-run to mark them after checking the list.").
+		Default("true").BoolVar(&tbc.dryRun)
 	return tbc
 }
 
 func (tbc *bucketCleanupConfig) registerBucketCleanupFlag(cmd extkingpin.FlagClause) *bucketCleanupConfig {
 	cmd.Flag("delete-delay", "Time before a block marked for deletion is deleted from bucket.").Default("48h").DurationVar(&tbc.deleteDelay)
+	tbc.deleteDelayConf = *extflag.RegisterPathOrContent(cmd, "delete-delay.config",
+		"YAML file with delete delay overrides per resolution and external labels selector. Blocks not matching any override use --delete-delay. "+
+			"See format details: https://thanos.io/tip/components/compact.md/#delete-delay-overrides",
+		extflag.WithEnvSubstitution(),
+	)
 	cmd.Flag("consistency-delay", fmt.Sprintf("Minimum age of fresh (non-compacted) blocks before they are being processed. Malformed blocks older than the maximum of consistency-delay and %v will be removed.", compact.PartialUploadThresholdAge)).
 		Default("30m").DurationVar(&tbc.consistencyDelay)
 	cmd.Flag("block-sync-concurrency", "Number of goroutines to use when syncing block metadata from object storage.").
@@ -264,6 +355,40 @@ func (tbc *bucketRetentionConfig) registerBucketRetentionFlag(cmd extkingpin.Fla
 	return tbc
 }
 
+func (tbc *bucketAnalyzeConfig) registerBucketAnalyzeFlag(cmd extkingpin.FlagClause) *bucketAnalyzeConfig {
+	cmd.Flag("id", "ID (ULID) of the blocks to analyze (repeated flag). All blocks matching --selector are analyzed by default.").StringsVar(&tbc.ids)
+	cmd.Flag("selector", "Selects blocks based on label, e.g. '-l key1=\\\"value1\\\" -l key2=\\\"value2\\\"'. All key value pairs must match.").Short('l').
+		PlaceHolder("<name>=\\\"<value>\\\"").StringsVar(&tbc.selector)
+	cmd.Flag("limit", "Number of label names and label name and value pairs with the most series reported for each block.").Default("20").IntVar(&tbc.limit)
+	cmd.Flag("tmp.dir", "Working directory the indexes of the blocks are downloaded to.").Default(filepath.Join(os.TempDir(), "thanos-analyze")).StringVar(&tbc.tmpDir)
+	cmd.Flag("output", "Output format of the report. Options are 'table' or 'json'.").Short('o').Default("table").EnumVar(&tbc.output, "table", "json")
+	cmd.Flag("timeout", "Timeout to download metadata and indexes from remote storage").Default("1h").DurationVar(&tbc.timeout)
+	return tbc
+}
+
+func (tbc *bucketCleanupPartialConfig) registerBucketCleanupPartialFlag(cmd extkingpin.FlagClause) *bucketCleanupPartialConfig {
+	cmd.Flag("older-than", "Time since the last modification of any object of a block without meta.json, after which its upload is assumed aborted and its data is deleted.").
+		Default(compact.PartialUploadThresholdAge.String()).DurationVar(&tbc.olderThan)
+	cmd.Flag("dry-run", "Only report the data of aborted uploads without deleting it.").Default("false").BoolVar(&tbc.dryRun)
+	cmd.Flag("timeout", "Timeout to list and delete the objects of partial blocks in remote storage").Default("1h").DurationVar(&tbc.timeout)
+	return tbc
+}
+
+func (tbc *bucketCleanupDebugMetasConfig) registerBucketCleanupDebugMetasFlag(cmd extkingpin.FlagClause) *bucketCleanupDebugMetasConfig {
+	cmd.Flag("older-than", "Time since the last modification of a debug meta file, after which it is deleted if its block does not exist.").
+		Default(compact.PartialUploadThresholdAge.String()).DurationVar(&tbc.olderThan)
+	cmd.Flag("dry-run", "Only report the debug meta files without deleting them.").Default("false").BoolVar(&tbc.dryRun)
+	cmd.Flag("timeout", "Timeout to list and delete the debug meta files in remote storage").Default("1h").DurationVar(&tbc.timeout)
+	return tbc
+}
+
+func (tbc *bucketReconcileConfig) registerBucketReconcileFlag(cmd extkingpin.FlagClause) *bucketReconcileConfig {
+	cmd.Flag("dir", "Directory of the objects to compare, recursively. The whole bucket is compared by default.").Default("").StringVar(&tbc.dir)
+	cmd.Flag("concurrency", "Number of goroutines to use when comparing the sizes of objects.").Default("20").IntVar(&tbc.concurrency)
+	cmd.Flag("output", "Optional format in which to print the report. Options are 'json' or the plain list of objects by default.").Short('o').Default("").StringVar(&tbc.output)
+	return tbc
+}
+
 func registerBucket(app extkingpin.AppClause) {
 	cmd := app.Command("bucket", "Bucket utility commands")
 
@@ -275,9 +400,13 @@ func registerBucket(app extkingpin.AppClause) {
 	registerBucketReplicate(cmd, objStoreConfig)
 	registerBucketDownsample(cmd, objStoreConfig)
 	registerBucketCleanup(cmd, objStoreConfig)
+	registerBucketCleanupPartial(cmd, objStoreConfig)
+	registerBucketCleanupDebugMetas(cmd, objStoreConfig)
 	registerBucketMarkBlock(cmd, objStoreConfig)
 	registerBucketRewrite(cmd, objStoreConfig)
 	registerBucketRetention(cmd, objStoreConfig)
+	registerBucketReconcile(cmd, objStoreConfig)
+	registerBucketAnalyze(cmd, objStoreConfig)
 }
 
 func registerBucketVerify(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
@@ -299,7 +428,7 @@ func registerBucketVerify(app extkingpin.AppClause, objStoreConfig *extflag.Path
 			return err
 		}
 
-		bkt, err := client.NewBucket(logger, confContentYaml, reg, component.Bucket.String())
+		bkt, err := extobjstore.NewBucket(logger, confContentYaml, reg, component.Bucket.String())
 		if err != nil {
 			return err
 		}
@@ -317,7 +446,7 @@ func registerBucketVerify(app extkingpin.AppClause, objStoreConfig *extflag.Path
 			}
 		} else {
 			// nil Prometheus registerer: don't create conflicting metrics.
-			backupBkt, err = client.NewBucket(logger, backupconfContentYaml, nil, component.Bucket.String())
+			backupBkt, err = extobjstore.NewBucket(logger, backupconfContentYaml, nil, component.Bucket.String())
 			if err != nil {
 				return err
 			}
@@ -375,12 +504,20 @@ func registerBucketLs(app extkingpin.AppClause, objStoreConfig *extflag.PathOrCo
 	tbc.registerBucketLsFlag(cmd)
 
 	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		matchers, err := replicate.ParseFlagMatchers(tbc.matcherStrs)
+		if err != nil {
+			return errors.Wrap(err, "parse block label matchers")
+		}
+		if tbc.aggregate && tbc.output != "" && tbc.output != "json" {
+			return errors.Errorf("output format %q is not supported with --aggregate", tbc.output)
+		}
+
 		confContentYaml, err := objStoreConfig.Content()
 		if err != nil {
 			return err
 		}
 
-		bkt, err := client.NewBucket(logger, confContentYaml, reg, component.Bucket.String())
+		bkt, err := extobjstore.NewBucket(logger, confContentYaml, reg, component.Bucket.String())
 		if err != nil {
 			return err
 		}
@@ -454,7 +591,29 @@ func registerBucketLs(app extkingpin.AppClause, objStoreConfig *extflag.PathOrCo
 			return err
 		}
 
+		if tbc.aggregate {
+			blockMetas := make([]*metadata.Meta, 0, len(metas))
+			for _, meta := range metas {
+				if matchesInspectFilters(meta, matchers, tbc.resolutions) {
+					blockMetas = append(blockMetas, meta)
+				}
+			}
+			aggs, err := aggregateBlocks(ctx, bkt, blockMetas)
+			if err != nil {
+				return err
+			}
+			if format == "json" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "\t")
+				return enc.Encode(aggs)
+			}
+			return printLsAggregates(os.Stdout, aggs)
+		}
+
 		for _, meta := range metas {
+			if !matchesInspectFilters(meta, matchers, tbc.resolutions) {
+				continue
+			}
 			objects++
 			if err := printBlock(meta); err != nil {
 				return errors.Wrap(err, "iter")
@@ -471,7 +630,7 @@ func registerBucketInspect(app extkingpin.AppClause, objStoreConfig *extflag.Pat
 	tbc := &bucketInspectConfig{}
 	tbc.registerBucketInspectFlag(cmd)
 
-	output := cmd.Flag("output", "Output format for result. Currently supports table, cvs, tsv.").Default("table").Enum(outputTypes...)
+	output := cmd.Flag("output", "Output format for result. Currently supports table, csv, tsv, json.").Default("table").Enum(outputTypes...)
 
 	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
 
@@ -481,17 +640,24 @@ func registerBucketInspect(app extkingpin.AppClause, objStoreConfig *extflag.Pat
 			return errors.Wrap(err, "error parsing selector flag")
 		}
 
+		matchers, err := replicate.ParseFlagMatchers(tbc.matcherStrs)
+		if err != nil {
+			return errors.Wrap(err, "parse block label matchers")
+		}
+
 		confContentYaml, err := objStoreConfig.Content()
 		if err != nil {
 			return err
 		}
 
-		bkt, err := client.NewBucket(logger, confContentYaml, reg, component.Bucket.String())
+		bkt, err := extobjstore.NewBucket(logger, confContentYaml, reg, component.Bucket.String())
 		if err != nil {
 			return err
 		}
 
-		fetcher, err := block.NewMetaFetcher(logger, block.FetcherConcurrency, bkt, "", extprom.WrapRegistererWithPrefix(extpromPrefix, reg), nil)
+		fetcher, err := block.NewMetaFetcher(logger, block.FetcherConcurrency, bkt, "", extprom.WrapRegistererWithPrefix(extpromPrefix, reg), []block.MetadataFilter{
+			block.NewTimePartitionMetaFilter(tbc.filterConf.MinTime, tbc.filterConf.MaxTime),
+		})
 		if err != nil {
 			return err
 		}
@@ -512,9 +678,19 @@ func registerBucketInspect(app extkingpin.AppClause, objStoreConfig *extflag.Pat
 
 		blockMetas := make([]*metadata.Meta, 0, len(metas))
 		for _, meta := range metas {
+			if !matchesSelector(meta, selectorLabels) || !matchesInspectFilters(meta, matchers, tbc.resolutions) {
+				continue
+			}
 			blockMetas = append(blockMetas, meta)
 		}
 
+		sizes := make(map[ulid.ULID]int64, len(blockMetas))
+		for _, meta := range blockMetas {
+			if sizes[meta.ULID], err = blockSize(ctx, bkt, meta); err != nil {
+				return errors.Wrapf(err, "get size of block %s", meta.ULID)
+			}
+		}
+
 		var opPrinter tablePrinter
 		op := outputType(*output)
 		switch op {
@@ -524,8 +700,10 @@ func registerBucketInspect(app extkingpin.AppClause, objStoreConfig *extflag.Pat
 			opPrinter = printTSV
 		case CSV:
 			opPrinter = printCSV
+		case JSON:
+			opPrinter = func(w io.Writer, t Table) error { return printInspectJSON(w, t, metas, sizes) }
 		}
-		return printBlockData(blockMetas, selectorLabels, tbc.sortBy, opPrinter)
+		return printBlockData(blockMetas, sizes, tbc.sortBy, opPrinter)
 	})
 }
 
@@ -533,6 +711,7 @@ func registerBucketInspect(app extkingpin.AppClause, objStoreConfig *extflag.Pat
 func registerBucketWeb(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
 	cmd := app.Command("web", "Web interface for remote storage bucket.")
 	httpBindAddr, httpGracePeriod, httpTLSConfig := extkingpin.RegisterHTTPFlags(cmd)
+	httpAuthConfig := extkingpin.RegisterHTTPAuthFlag(cmd)
 
 	tbc := &bucketWebConfig{}
 	tbc.registerBucketWebFlag(cmd)
@@ -552,10 +731,15 @@ func registerBucketWeb(app extkingpin.AppClause, objStoreConfig *extflag.PathOrC
 			prober.NewInstrumentation(comp, logger, extprom.WrapRegistererWithPrefix("thanos_", reg)),
 		)
 
+		httpAuth, err := newHTTPAuthenticator(logger, httpAuthConfig)
+		if err != nil {
+			return err
+		}
 		srv := httpserver.New(logger, reg, comp, httpProbe,
 			httpserver.WithListen(*httpBindAddr),
 			httpserver.WithGracePeriod(time.Duration(*httpGracePeriod)),
 			httpserver.WithTLSConfig(*httpTLSConfig),
+			httpserver.WithAuthenticator(httpAuth),
 		)
 
 		if tbc.webRoutePrefix == "" {
@@ -594,7 +778,7 @@ func registerBucketWeb(app extkingpin.AppClause, objStoreConfig *extflag.PathOrC
 			return err
 		}
 
-		bkt, err := client.NewBucket(logger, confContentYaml, reg, component.Bucket.String())
+		bkt, err := extobjstore.NewBucket(logger, confContentYaml, reg, component.Bucket.String())
 		if err != nil {
 			return errors.Wrap(err, "bucket client")
 		}
@@ -689,6 +873,7 @@ func listResLevel() []string {
 func registerBucketReplicate(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
 	cmd := app.Command("replicate", fmt.Sprintf("Replicate data from one object storage to another. NOTE: Currently it works only with Thanos blocks (%v has to have Thanos metadata).", block.MetaFilename))
 	httpBindAddr, httpGracePeriod, httpTLSConfig := extkingpin.RegisterHTTPFlags(cmd)
+	httpAuthConfig := extkingpin.RegisterHTTPAuthFlag(cmd)
 	toObjStoreConfig := extkingpin.RegisterCommonObjStoreFlags(cmd, "-to", false, "The object storage which replicate data to.")
 
 	tbc := &bucketReplicateConfig{}
@@ -721,6 +906,11 @@ func registerBucketReplicate(app extkingpin.AppClause, objStoreConfig *extflag.P
 			blockIDs = append(blockIDs, bid)
 		}
 
+		httpAuth, err := newHTTPAuthenticator(logger, httpAuthConfig)
+		if err != nil {
+			return err
+		}
+
 		return replicate.RunReplicate(
 			g,
 			logger,
@@ -728,6 +918,7 @@ func registerBucketReplicate(app extkingpin.AppClause, objStoreConfig *extflag.P
 			tracer,
 			*httpBindAddr,
 			*httpTLSConfig,
+			httpAuth,
 			time.Duration(*httpGracePeriod),
 			matchers,
 			resolutionLevels,
@@ -739,23 +930,80 @@ func registerBucketReplicate(app extkingpin.AppClause, objStoreConfig *extflag.P
 			maxTime,
 			blockIDs,
 			*ignoreMarkedForDeletion,
+			replicate.TransferOptions{
+				Concurrency:     tbc.concurrency,
+				ProgressFile:    tbc.progressFile,
+				VerifyChecksums: tbc.verifyChecksums,
+				BandwidthLimit:  int64(tbc.bandwidthLimit),
+			},
 		)
 	})
 }
 
 func registerBucketDownsample(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
-	cmd := app.Command(component.Downsample.String(), "Continuously downsamples blocks in an object store bucket.")
+	cmd := app.Command(component.Downsample.String(), "Continuously downsamples blocks in an object store bucket, or downsamples the blocks given with --id once.")
 	httpAddr, httpGracePeriod, httpTLSConfig := extkingpin.RegisterHTTPFlags(cmd)
+	httpAuthConfig := extkingpin.RegisterHTTPAuthFlag(cmd)
 
 	tbc := &bucketDownsampleConfig{}
 	tbc.registerBucketDownsampleFlag(cmd)
 
 	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, _ <-chan struct{}, _ bool) error {
-		return RunDownsample(g, logger, reg, *httpAddr, *httpTLSConfig, time.Duration(*httpGracePeriod), tbc.dataDir,
-			tbc.waitInterval, tbc.downsampleConcurrency, objStoreConfig, component.Downsample, metadata.HashFunc(tbc.hashFunc))
+		if len(tbc.ids) > 0 {
+			return runDownsampleBlocks(g, logger, reg, objStoreConfig, tbc)
+		}
+		httpAuth, err := newHTTPAuthenticator(logger, httpAuthConfig)
+		if err != nil {
+			return err
+		}
+		return RunDownsample(g, logger, reg, *httpAddr, *httpTLSConfig, httpAuth, time.Duration(*httpGracePeriod), tbc.dataDir,
+			tbc.waitInterval, tbc.downsampleConcurrency, objStoreConfig, component.Downsample, metadata.HashFunc(tbc.hashFunc), int64(tbc.seriesMemoryBudget))
 	})
 }
 
+// runDownsampleBlocks downsamples the blocks given with --id once.
+func runDownsampleBlocks(g *run.Group, logger log.Logger, reg *prometheus.Registry, objStoreConfig *extflag.PathOrContent, tbc *bucketDownsampleConfig) error {
+	switch tbc.resolution.Milliseconds() {
+	case downsample.ResLevel0, downsample.ResLevel1, downsample.ResLevel2:
+	default:
+		return errors.Errorf("unsupported resolution %v, only 5m and 1h are supported", tbc.resolution)
+	}
+	ids := make([]ulid.ULID, 0, len(tbc.ids))
+	for _, id := range tbc.ids {
+		u, err := ulid.Parse(id)
+		if err != nil {
+			return errors.Wrapf(err, "block id %s is invalid", id)
+		}
+		ids = append(ids, u)
+	}
+
+	confContentYaml, err := objStoreConfig.Content()
+	if err != nil {
+		return err
+	}
+	bkt, err := extobjstore.NewBucket(logger, confContentYaml, reg, component.Downsample.String())
+	if err != nil {
+		return err
+	}
+	defer runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+
+	fetcher, err := block.NewMetaFetcher(logger, block.FetcherConcurrency, bkt, "", extprom.WrapRegistererWithPrefix(extpromPrefix, reg), nil)
+	if err != nil {
+		return err
+	}
+
+	// Dummy actor to immediately kill the group after the run function returns.
+	g.Add(func() error { return nil }, func(error) {})
+
+	ctx := context.Background()
+	metas, _, err := fetcher.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+	return downsampleBlocks(ctx, logger, newDownsampleMetrics(reg), bkt, metas, ids, tbc.resolution.Milliseconds(), tbc.dataDir,
+		metadata.HashFunc(tbc.hashFunc), int64(tbc.seriesMemoryBudget))
+}
+
 func registerBucketCleanup(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
 	cmd := app.Command(component.Cleanup.String(), "Cleans up all blocks marked for deletion.")
 
@@ -779,7 +1027,17 @@ func registerBucketCleanup(app extkingpin.AppClause, objStoreConfig *extflag.Pat
 			return err
 		}
 
-		bkt, err := client.NewBucket(logger, confContentYaml, reg, component.Cleanup.String())
+		deleteDelayContentYaml, err := tbc.deleteDelayConf.Content()
+		if err != nil {
+			return errors.Wrap(err, "get content of delete delay configuration")
+		}
+
+		deleteDelayPolicy, err := compact.ParseDeleteDelayPolicy(deleteDelayContentYaml, tbc.deleteDelay)
+		if err != nil {
+			return err
+		}
+
+		bkt, err := extobjstore.NewBucket(logger, confContentYaml, reg, component.Cleanup.String())
 		if err != nil {
 			return err
 		}
@@ -792,9 +1050,9 @@ func registerBucketCleanup(app extkingpin.AppClause, objStoreConfig *extflag.Pat
 		// While fetching blocks, we filter out blocks that were marked for deletion by using IgnoreDeletionMarkFilter.
 		// The delay of deleteDelay/2 is added to ensure we fetch blocks that are meant to be deleted but do not have a replacement yet.
 		// This is to make sure compactor will not accidentally perform compactions with gap instead.
-		ignoreDeletionMarkFilter := block.NewIgnoreDeletionMarkFilter(logger, bkt, tbc.deleteDelay/2, tbc.blockSyncConcurrency)
+		ignoreDeletionMarkFilter := block.NewIgnoreDeletionMarkFilter(logger, bkt, deleteDelayPolicy.MinDeleteDelay()/2, tbc.blockSyncConcurrency)
 		duplicateBlocksFilter := block.NewDeduplicateFilter(tbc.blockSyncConcurrency)
-		blocksCleaner := compact.NewBlocksCleaner(logger, bkt, ignoreDeletionMarkFilter, tbc.deleteDelay, stubCounter, stubCounter)
+		blocksCleaner := compact.NewBlocksCleaner(logger, bkt, ignoreDeletionMarkFilter, deleteDelayPolicy, stubCounter, stubCounter)
 
 		ctx := context.Background()
 
@@ -844,6 +1102,150 @@ func registerBucketCleanup(app extkingpin.AppClause, objStoreConfig *extflag.Pat
 	})
 }
 
+func registerBucketCleanupPartial(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
+	cmd := app.Command("cleanup-partial", "Deletes the data of aborted partial uploads: blocks with objects but without meta.json "+
+		"which were not modified for longer than --older-than, as well as blocks whose deletion was interrupted after their meta.json was removed. It reports the reclaimed bytes.")
+
+	tbc := &bucketCleanupPartialConfig{}
+	tbc.registerBucketCleanupPartialFlag(cmd)
+
+	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		confContentYaml, err := objStoreConfig.Content()
+		if err != nil {
+			return err
+		}
+
+		bkt, err := extobjstore.NewBucket(logger, confContentYaml, reg, component.Cleanup.String())
+		if err != nil {
+			return err
+		}
+
+		fetcher, err := block.NewMetaFetcher(logger, block.FetcherConcurrency, bkt, "", extprom.WrapRegistererWithPrefix(extpromPrefix, reg), nil)
+		if err != nil {
+			return err
+		}
+
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		defer runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+
+		ctx, cancel := context.WithTimeout(context.Background(), tbc.timeout)
+		defer cancel()
+
+		_, partial, err := fetcher.Fetch(ctx)
+		if err != nil {
+			return err
+		}
+
+		orphaned := compact.FindOrphanedBlockData(ctx, logger, partial, bkt, tbc.olderThan)
+		if !tbc.dryRun {
+			for _, b := range orphaned {
+				level.Info(logger).Log("msg", "deleting data of aborted partial upload", "block", b.ID,
+					"last_modified", b.LastModified, "objects", b.Objects, "bytes", b.Bytes)
+				if err := block.Delete(ctx, logger, bkt, b.ID); err != nil {
+					return errors.Wrapf(err, "delete block %s", b.ID)
+				}
+			}
+		}
+		return printOrphanedBlocks(os.Stdout, orphaned, tbc.dryRun)
+	})
+}
+
+func registerBucketCleanupDebugMetas(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
+	cmd := app.Command("cleanup-debug-metas", "Deletes the debug meta files uploaded to debug/metas by older Thanos versions, whose block does not exist anymore "+
+		"and which were not modified for longer than --older-than. It reports the reclaimed bytes.")
+
+	tbc := &bucketCleanupDebugMetasConfig{}
+	tbc.registerBucketCleanupDebugMetasFlag(cmd)
+
+	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		confContentYaml, err := objStoreConfig.Content()
+		if err != nil {
+			return err
+		}
+
+		bkt, err := extobjstore.NewBucket(logger, confContentYaml, reg, component.Cleanup.String())
+		if err != nil {
+			return err
+		}
+
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		defer runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+
+		ctx, cancel := context.WithTimeout(context.Background(), tbc.timeout)
+		defer cancel()
+
+		metas, err := compact.FindDebugMetasWithoutBlock(ctx, bkt, tbc.olderThan)
+		if err != nil {
+			return errors.Wrap(err, "find debug metas")
+		}
+		if !tbc.dryRun {
+			for _, m := range metas {
+				level.Info(logger).Log("msg", "deleting debug meta", "block", m.ID, "last_modified", m.LastModified, "bytes", m.Bytes)
+				if err := compact.DeleteDebugMeta(ctx, bkt, m.ID); err != nil {
+					return errors.Wrapf(err, "delete debug meta of block %s", m.ID)
+				}
+			}
+		}
+		return printDebugMetas(os.Stdout, metas, tbc.dryRun)
+	})
+}
+
+func printOrphanedBlocks(w io.Writer, orphaned []compact.OrphanedBlock, dryRun bool) error {
+	p := message.NewPrinter(language.English)
+
+	t := Table{Header: []string{"ULID", "OBJECTS", "BYTES", "LAST-MODIFIED", "INTERRUPTED-DELETION"}}
+	var reclaimed int64
+	for _, b := range orphaned {
+		t.Lines = append(t.Lines, []string{
+			b.ID.String(),
+			p.Sprintf("%d", b.Objects),
+			p.Sprintf("%d", b.Bytes),
+			b.LastModified.UTC().Format(time.RFC3339),
+			p.Sprintf("%t", b.DeletionMarked),
+		})
+		reclaimed += b.Bytes
+	}
+	if err := printTable(w, t); err != nil {
+		return err
+	}
+
+	verb := "Reclaimed"
+	if dryRun {
+		verb = "Would reclaim"
+	}
+	_, err := p.Fprintf(w, "%s %d bytes from %d blocks\n", verb, reclaimed, len(orphaned))
+	return err
+}
+
+func printDebugMetas(w io.Writer, metas []compact.DebugMeta, dryRun bool) error {
+	p := message.NewPrinter(language.English)
+
+	t := Table{Header: []string{"ULID", "BYTES", "LAST-MODIFIED"}}
+	var reclaimed int64
+	for _, m := range metas {
+		t.Lines = append(t.Lines, []string{
+			m.ID.String(),
+			p.Sprintf("%d", m.Bytes),
+			m.LastModified.UTC().Format(time.RFC3339),
+		})
+		reclaimed += m.Bytes
+	}
+	if err := printTable(w, t); err != nil {
+		return err
+	}
+
+	verb := "Reclaimed"
+	if dryRun {
+		verb = "Would reclaim"
+	}
+	_, err := p.Fprintf(w, "%s %d bytes from %d debug meta files\n", verb, reclaimed, len(metas))
+	return err
+}
+
 type tablePrinter func(w io.Writer, t Table) error
 
 func printTable(w io.Writer, t Table) error {
@@ -893,17 +1295,13 @@ func printTSV(w io.Writer, t Table) error {
 	return nil
 }
 
-func printBlockData(blockMetas []*metadata.Meta, selectorLabels labels.Labels, sortBy []string, printer tablePrinter) error {
+func printBlockData(blockMetas []*metadata.Meta, sizes map[ulid.ULID]int64, sortBy []string, printer tablePrinter) error {
 	header := inspectColumns
 
 	var lines [][]string
 	p := message.NewPrinter(language.English)
 
 	for _, blockMeta := range blockMetas {
-		if !matchesSelector(blockMeta, selectorLabels) {
-			continue
-		}
-
 		timeRange := time.Duration((blockMeta.MaxTime - blockMeta.MinTime) * int64(time.Millisecond))
 
 		untilDown := "-"
@@ -929,7 +1327,8 @@ func printBlockData(blockMetas []*metadata.Meta, selectorLabels labels.Labels, s
 			p.Sprintf("%t", blockMeta.Compaction.Failed),
 			strings.Join(labels, ","),
 			time.Duration(blockMeta.Thanos.Downsample.Resolution*int64(time.Millisecond)).String(),
-			string(blockMeta.Thanos.Source))
+			string(blockMeta.Thanos.Source),
+			p.Sprintf("%d", sizes[blockMeta.ULID]))
 
 		lines = append(lines, line)
 	}
@@ -972,6 +1371,157 @@ func matchesSelector(blockMeta *metadata.Meta, selectorLabels labels.Labels) boo
 	return true
 }
 
+// matchesInspectFilters checks if the external labels of blockMeta match all matchers, and if its resolution is one of
+// resolutions. Any resolution matches if resolutions is empty.
+func matchesInspectFilters(blockMeta *metadata.Meta, matchers []*labels.Matcher, resolutions []time.Duration) bool {
+	for _, m := range matchers {
+		if !m.Matches(blockMeta.Thanos.Labels[m.Name]) {
+			return false
+		}
+	}
+	if len(resolutions) == 0 {
+		return true
+	}
+	for _, r := range resolutions {
+		if r.Milliseconds() == blockMeta.Thanos.Downsample.Resolution {
+			return true
+		}
+	}
+	return false
+}
+
+// blockSize returns the size in bytes of the objects of the block. The sizes recorded in its meta.json are used if
+// there are any, otherwise the objects of the block are listed.
+func blockSize(ctx context.Context, bkt objstore.BucketReader, meta *metadata.Meta) (int64, error) {
+	if len(meta.Thanos.Files) > 0 {
+		var size int64
+		for _, f := range meta.Thanos.Files {
+			size += f.SizeBytes
+		}
+		return size, nil
+	}
+
+	var size int64
+	err := bkt.Iter(ctx, meta.ULID.String(), func(name string) error {
+		attrs, err := bkt.Attributes(ctx, name)
+		if err != nil {
+			return errors.Wrapf(err, "get attributes of %s", name)
+		}
+		size += attrs.Size
+		return nil
+	}, objstore.WithRecursiveIter)
+	return size, err
+}
+
+// lsAggregate is the usage of the blocks with the same external labels printed by ls with --aggregate.
+type lsAggregate struct {
+	Labels    map[string]string `json:"labels"`
+	Blocks    int               `json:"blocks"`
+	Series    uint64            `json:"series"`
+	Samples   uint64            `json:"samples"`
+	SizeBytes int64             `json:"size_bytes"`
+}
+
+// aggregateBlocks sums up the number of blocks, series, samples and bytes of the blocks per external label set, sorted
+// by labels.
+func aggregateBlocks(ctx context.Context, bkt objstore.BucketReader, metas []*metadata.Meta) ([]*lsAggregate, error) {
+	byLabels := map[string]*lsAggregate{}
+	for _, meta := range metas {
+		key := labels.FromMap(meta.Thanos.Labels).String()
+		agg, ok := byLabels[key]
+		if !ok {
+			agg = &lsAggregate{Labels: meta.Thanos.Labels}
+			byLabels[key] = agg
+		}
+
+		size, err := blockSize(ctx, bkt, meta)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get size of block %s", meta.ULID)
+		}
+		agg.Blocks++
+		agg.Series += meta.Stats.NumSeries
+		agg.Samples += meta.Stats.NumSamples
+		agg.SizeBytes += size
+	}
+
+	keys := make([]string, 0, len(byLabels))
+	for k := range byLabels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	aggs := make([]*lsAggregate, 0, len(keys))
+	for _, k := range keys {
+		aggs = append(aggs, byLabels[k])
+	}
+	return aggs, nil
+}
+
+func printLsAggregates(w io.Writer, aggs []*lsAggregate) error {
+	p := message.NewPrinter(language.English)
+	t := Table{Header: []string{"LABELS", "#BLOCKS", "#SERIES", "#SAMPLES", "SIZE"}}
+	for _, agg := range aggs {
+		var lbls []string
+		for _, key := range getKeysAlphabetically(agg.Labels) {
+			lbls = append(lbls, fmt.Sprintf("%s=%s", key, agg.Labels[key]))
+		}
+		t.Lines = append(t.Lines, []string{
+			strings.Join(lbls, ","),
+			p.Sprintf("%d", agg.Blocks),
+			p.Sprintf("%d", agg.Series),
+			p.Sprintf("%d", agg.Samples),
+			p.Sprintf("%d", agg.SizeBytes),
+		})
+	}
+	return printTable(w, t)
+}
+
+// inspectBlock is the information about a block printed by inspect in JSON.
+type inspectBlock struct {
+	ULID             ulid.ULID           `json:"ulid"`
+	MinTime          int64               `json:"min_time"`
+	MaxTime          int64               `json:"max_time"`
+	Series           uint64              `json:"series"`
+	Samples          uint64              `json:"samples"`
+	Chunks           uint64              `json:"chunks"`
+	CompactionLevel  int                 `json:"compaction_level"`
+	CompactionFailed bool                `json:"compaction_failed"`
+	Labels           map[string]string   `json:"labels"`
+	Resolution       int64               `json:"resolution"`
+	Source           metadata.SourceType `json:"source"`
+	SizeBytes        int64               `json:"size_bytes"`
+}
+
+// printInspectJSON prints the blocks of the sorted table t as a JSON array, with the raw values of their metas instead
+// of the formatted ones of the table.
+func printInspectJSON(w io.Writer, t Table, metas map[ulid.ULID]*metadata.Meta, sizes map[ulid.ULID]int64) error {
+	blocks := make([]inspectBlock, 0, len(t.Lines))
+	for _, line := range t.Lines {
+		id, err := ulid.Parse(line[0])
+		if err != nil {
+			return err
+		}
+		m := metas[id]
+		blocks = append(blocks, inspectBlock{
+			ULID:             m.ULID,
+			MinTime:          m.MinTime,
+			MaxTime:          m.MaxTime,
+			Series:           m.Stats.NumSeries,
+			Samples:          m.Stats.NumSamples,
+			Chunks:           m.Stats.NumChunks,
+			CompactionLevel:  m.Compaction.Level,
+			CompactionFailed: m.Compaction.Failed,
+			Labels:           m.Thanos.Labels,
+			Resolution:       m.Thanos.Downsample.Resolution,
+			Source:           m.Thanos.Source,
+			SizeBytes:        sizes[id],
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(blocks)
+}
+
 // getIndex calculates the index of s in strs.
 func getIndex(strs []string, s string) int {
 	for i, col := range strs {
@@ -1034,11 +1584,21 @@ func registerBucketMarkBlock(app extkingpin.AppClause, objStoreConfig *extflag.P
 			return err
 		}
 
-		bkt, err := client.NewBucket(logger, confContentYaml, reg, component.Mark.String())
+		bkt, err := extobjstore.NewBucket(logger, confContentYaml, reg, component.Mark.String())
 		if err != nil {
 			return err
 		}
 
+		matchers, err := replicate.ParseFlagMatchers(tbc.matcherStrs)
+		if err != nil {
+			return errors.Wrap(err, "parse block label matchers")
+		}
+		bulk := tbc.minTime.Time != nil || tbc.minTime.Dur != nil || tbc.maxTime.Time != nil || tbc.maxTime.Dur != nil ||
+			len(matchers) > 0 || len(tbc.resolutions) > 0
+		if bulk == (len(tbc.blockIDs) > 0) {
+			return errors.New("either --id or at least one of --min-time, --max-time, --matcher and --resolution has to be given")
+		}
+
 		var ids []ulid.ULID
 		for _, id := range tbc.blockIDs {
 			u, err := ulid.Parse(id)
@@ -1054,32 +1614,42 @@ func registerBucketMarkBlock(app extkingpin.AppClause, objStoreConfig *extflag.P
 
 		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
 		g.Add(func() error {
-			for _, id := range ids {
-				if tbc.removeMarker {
-					err := block.RemoveMark(ctx, logger, bkt, id, promauto.With(nil).NewCounter(prometheus.CounterOpts{}), tbc.marker)
-					if err != nil {
-						return errors.Wrapf(err, "remove mark %v for %v", id, tbc.marker)
-					}
-					continue
+			if bulk {
+				fetcher, err := block.NewMetaFetcher(logger, block.FetcherConcurrency, bkt, "", extprom.WrapRegistererWithPrefix(extpromPrefix, reg), nil)
+				if err != nil {
+					return err
 				}
-				switch tbc.marker {
-				case metadata.DeletionMarkFilename:
-					if err := block.MarkForDeletion(ctx, logger, bkt, id, tbc.details, promauto.With(nil).NewCounter(prometheus.CounterOpts{})); err != nil {
-						return errors.Wrapf(err, "mark %v for %v", id, tbc.marker)
-					}
-				case metadata.NoCompactMarkFilename:
-					if err := block.MarkForNoCompact(ctx, logger, bkt, id, metadata.ManualNoCompactReason, tbc.details, promauto.With(nil).NewCounter(prometheus.CounterOpts{})); err != nil {
-						return errors.Wrapf(err, "mark %v for %v", id, tbc.marker)
-					}
-				case metadata.NoDownsampleMarkFilename:
-					if err := block.MarkForNoDownsample(ctx, logger, bkt, id, metadata.ManualNoDownsampleReason, tbc.details, promauto.With(nil).NewCounter(prometheus.CounterOpts{})); err != nil {
-						return errors.Wrapf(err, "mark %v for %v", id, tbc.marker)
-					}
-				default:
-					return errors.Errorf("not supported marker %v", tbc.marker)
+				metas, _, err := fetcher.Fetch(ctx)
+				if err != nil {
+					return err
+				}
+
+				minTime, maxTime := int64(math.MinInt64), int64(math.MaxInt64)
+				if tbc.minTime.Time != nil || tbc.minTime.Dur != nil {
+					minTime = tbc.minTime.PrometheusTimestamp()
+				}
+				if tbc.maxTime.Time != nil || tbc.maxTime.Dur != nil {
+					maxTime = tbc.maxTime.PrometheusTimestamp()
+				}
+				selected := selectBlocksToMark(metas, minTime, maxTime, matchers, tbc.resolutions)
+				if err := printBlocksToMark(os.Stdout, selected); err != nil {
+					return err
+				}
+				if tbc.dryRun {
+					level.Info(logger).Log("msg", "dry run, no blocks were marked; use --no-dry-run to mark the listed blocks", "marker", tbc.marker, "blocks", len(selected))
+					return nil
+				}
+				for _, m := range selected {
+					ids = append(ids, m.ULID)
 				}
 			}
-			level.Info(logger).Log("msg", "marking done", "marker", tbc.marker, "IDs", strings.Join(tbc.blockIDs, ","))
+
+			for _, id := range ids {
+				if err := markBlock(ctx, logger, bkt, id, tbc); err != nil {
+					return err
+				}
+			}
+			level.Info(logger).Log("msg", "marking done", "marker", tbc.marker, "blocks", len(ids))
 			return nil
 		}, func(err error) {
 			cancel()
@@ -1088,6 +1658,66 @@ func registerBucketMarkBlock(app extkingpin.AppClause, objStoreConfig *extflag.P
 	})
 }
 
+// markBlock puts the marker of the config on the block, or removes it.
+func markBlock(ctx context.Context, logger log.Logger, bkt objstore.Bucket, id ulid.ULID, tbc *bucketMarkBlockConfig) error {
+	if tbc.removeMarker {
+		if err := block.RemoveMark(ctx, logger, bkt, id, promauto.With(nil).NewCounter(prometheus.CounterOpts{}), tbc.marker); err != nil {
+			return errors.Wrapf(err, "remove mark %v for %v", id, tbc.marker)
+		}
+		return nil
+	}
+	switch tbc.marker {
+	case metadata.DeletionMarkFilename:
+		if err := block.MarkForDeletion(ctx, logger, bkt, id, tbc.details, promauto.With(nil).NewCounter(prometheus.CounterOpts{})); err != nil {
+			return errors.Wrapf(err, "mark %v for %v", id, tbc.marker)
+		}
+	case metadata.NoCompactMarkFilename:
+		if err := block.MarkForNoCompact(ctx, logger, bkt, id, metadata.ManualNoCompactReason, tbc.details, promauto.With(nil).NewCounter(prometheus.CounterOpts{})); err != nil {
+			return errors.Wrapf(err, "mark %v for %v", id, tbc.marker)
+		}
+	case metadata.NoDownsampleMarkFilename:
+		if err := block.MarkForNoDownsample(ctx, logger, bkt, id, metadata.ManualNoDownsampleReason, tbc.details, promauto.With(nil).NewCounter(prometheus.CounterOpts{})); err != nil {
+			return errors.Wrapf(err, "mark %v for %v", id, tbc.marker)
+		}
+	default:
+		return errors.Errorf("not supported marker %v", tbc.marker)
+	}
+	return nil
+}
+
+// selectBlocksToMark returns the blocks with all their data within [minTime, maxTime] whose external labels match the
+// matchers and whose resolution is one of resolutions, sorted by their min time.
+func selectBlocksToMark(metas map[ulid.ULID]*metadata.Meta, minTime, maxTime int64, matchers []*labels.Matcher, resolutions []time.Duration) []*metadata.Meta {
+	var selected []*metadata.Meta
+	for _, m := range metas {
+		if m.MinTime < minTime || m.MaxTime > maxTime || !matchesInspectFilters(m, matchers, resolutions) {
+			continue
+		}
+		selected = append(selected, m)
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		if selected[i].MinTime != selected[j].MinTime {
+			return selected[i].MinTime < selected[j].MinTime
+		}
+		return selected[i].ULID.Compare(selected[j].ULID) < 0
+	})
+	return selected
+}
+
+func printBlocksToMark(w io.Writer, metas []*metadata.Meta) error {
+	t := Table{Header: []string{"ULID", "FROM", "UNTIL", "RESOLUTION", "LABELS"}}
+	for _, m := range metas {
+		t.Lines = append(t.Lines, []string{
+			m.ULID.String(),
+			time.Unix(m.MinTime/1000, 0).UTC().Format(time.RFC3339),
+			time.Unix(m.MaxTime/1000, 0).UTC().Format(time.RFC3339),
+			time.Duration(m.Thanos.Downsample.Resolution * int64(time.Millisecond)).String(),
+			labels.FromMap(m.Thanos.Labels).String(),
+		})
+	}
+	return printTable(w, t)
+}
+
 func registerBucketRewrite(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
 	cmd := app.Command(component.Rewrite.String(), "Rewrite chosen blocks in the bucket, while deleting or modifying series "+
 		"Resulted block has modified stats in meta.json. Additionally compaction.sources are altered to not confuse readers of meta.json. "+
@@ -1107,13 +1737,20 @@ func registerBucketRewrite(app extkingpin.AppClause, objStoreConfig *extflag.Pat
 	toDelete := extflag.RegisterPathOrContent(cmd, "rewrite.to-delete-config", "YAML file that contains []metadata.DeletionRequest that will be applied to blocks", extflag.WithEnvSubstitution())
 	toRelabel := extflag.RegisterPathOrContent(cmd, "rewrite.to-relabel-config", "YAML file that contains relabel configs that will be applied to blocks", extflag.WithEnvSubstitution())
 	provideChangeLog := cmd.Flag("rewrite.add-change-log", "If specified, all modifications are written to new block directory. Disable if latency is to high.").Default("true").Bool()
+	deleteMatchers := cmd.Flag("rewrite.delete-matchers", "Series selector of the series to delete, e.g. '{__name__=\"up\", job=\"api\"}' (repeated flag). "+
+		"Applied in addition to the deletion requests of --rewrite.to-delete-config.").PlaceHolder("<series-selector>").Strings()
+	deleteMinTime := model.TimeOrDuration(cmd.Flag("rewrite.delete-min-time", "Start of the time range of the samples deleted from the series matching --rewrite.delete-matchers. "+
+		"Option can be a constant time in RFC3339 format or time duration relative to current time, such as -1d or 2h45m. Valid duration units are ms, s, m, h, d, w, y. "+
+		"If neither this flag nor --rewrite.delete-max-time is set, the matching series are deleted entirely."))
+	deleteMaxTime := model.TimeOrDuration(cmd.Flag("rewrite.delete-max-time", "End of the time range of the samples deleted from the series matching --rewrite.delete-matchers. "+
+		"Option can be a constant time in RFC3339 format or time duration relative to current time, such as -1d or 2h45m. Valid duration units are ms, s, m, h, d, w, y."))
 	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
 		confContentYaml, err := objStoreConfig.Content()
 		if err != nil {
 			return err
 		}
 
-		bkt, err := client.NewBucket(logger, confContentYaml, reg, component.Rewrite.String())
+		bkt, err := extobjstore.NewBucket(logger, confContentYaml, reg, component.Rewrite.String())
 		if err != nil {
 			return err
 		}
@@ -1142,6 +1779,13 @@ func registerBucketRewrite(app extkingpin.AppClause, objStoreConfig *extflag.Pat
 			if err := yaml.Unmarshal(deletionsYaml, &deletions); err != nil {
 				return err
 			}
+		}
+		flagDeletions, err := parseDeletionFlags(*deleteMatchers, deleteMinTime, deleteMaxTime)
+		if err != nil {
+			return err
+		}
+		deletions = append(deletions, flagDeletions...)
+		if len(deletions) > 0 {
 			modifiers = append(modifiers, compactv2.WithDeletionModifier(deletions...))
 		}
 
@@ -1170,6 +1814,7 @@ func registerBucketRewrite(app extkingpin.AppClause, objStoreConfig *extflag.Pat
 			chunkPool := chunkenc.NewPool()
 			changeLog := compactv2.NewChangeLog(io.Discard)
 			stubCounter := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
+			var reports []rewriteReport
 			for _, id := range ids {
 				// Delete series from block & modify.
 				level.Info(logger).Log("msg", "downloading block", "source", id)
@@ -1188,12 +1833,17 @@ func registerBucketRewrite(app extkingpin.AppClause, objStoreConfig *extflag.Pat
 
 				p := compactv2.NewProgressLogger(logger, int(b.Meta().Stats.NumSeries))
 				newID := ulid.MustNew(ulid.Now(), rand.Reader)
-				meta.ULID = newID
-				meta.Thanos.Rewrites = append(meta.Thanos.Rewrites, metadata.Rewrite{
+				oldID := meta.ULID
+				rewrite := metadata.Rewrite{
 					Sources:          meta.Compaction.Sources,
+					Block:            &oldID,
+					Time:             timestamp.FromTime(time.Now()),
+					Version:          version.Version,
 					DeletionsApplied: deletions,
 					RelabelsApplied:  relabels,
-				})
+				}
+				stats := meta.Stats
+				meta.ULID = newID
 				meta.Compaction.Sources = []ulid.ULID{newID}
 				meta.Thanos.Source = metadata.BucketRewriteSource
 
@@ -1230,7 +1880,10 @@ func registerBucketRewrite(app extkingpin.AppClause, objStoreConfig *extflag.Pat
 				}
 
 				if tbc.dryRun {
-					level.Info(logger).Log("msg", "dry run finished. Changes should be printed to stderr", "Block ID", id)
+					dropped := droppedStats(stats, comp.DryRunStats())
+					reports = append(reports, rewriteReport{id: id, stats: stats, dropped: dropped})
+					level.Info(logger).Log("msg", "dry run finished. Changes should be printed to stderr", "Block ID", id,
+						"series_to_drop", dropped.NumSeries, "samples_to_drop", dropped.NumSamples)
 					continue
 				}
 
@@ -1239,6 +1892,10 @@ func registerBucketRewrite(app extkingpin.AppClause, objStoreConfig *extflag.Pat
 				if err != nil {
 					return errors.Wrap(err, "flush")
 				}
+				dropped := droppedStats(stats, meta.Stats)
+				reports = append(reports, rewriteReport{id: id, newID: &newID, stats: stats, dropped: dropped})
+				rewrite.Dropped = &dropped
+				meta.Thanos.Rewrites = append(meta.Thanos.Rewrites, rewrite)
 				if err := meta.WriteToDir(logger, filepath.Join(tbc.tmpDir, newID.String())); err != nil {
 					return err
 				}
@@ -1262,7 +1919,7 @@ func registerBucketRewrite(app extkingpin.AppClause, objStoreConfig *extflag.Pat
 				}
 			}
 			level.Info(logger).Log("msg", "rewrite done", "IDs", strings.Join(tbc.blockIDs, ","))
-			return nil
+			return printRewriteReports(os.Stdout, reports)
 		}, func(err error) {
 			cancel()
 		})
@@ -1270,6 +1927,90 @@ func registerBucketRewrite(app extkingpin.AppClause, objStoreConfig *extflag.Pat
 	})
 }
 
+// parseDeletionFlags returns a deletion request for each series selector in matchers, deleting the samples between
+// minTime and maxTime if any of them is set, or the whole series otherwise.
+func parseDeletionFlags(matchers []string, minTime, maxTime *model.TimeOrDurationValue) ([]metadata.DeletionRequest, error) {
+	var (
+		minSet = minTime.Time != nil || minTime.Dur != nil
+		maxSet = maxTime.Time != nil || maxTime.Dur != nil
+	)
+	if len(matchers) == 0 {
+		if minSet || maxSet {
+			return nil, errors.New("--rewrite.delete-min-time and --rewrite.delete-max-time require --rewrite.delete-matchers")
+		}
+		return nil, nil
+	}
+
+	var intervals tombstones.Intervals
+	if minSet || maxSet {
+		in := tombstones.Interval{Mint: math.MinInt64, Maxt: math.MaxInt64}
+		if minSet {
+			in.Mint = minTime.PrometheusTimestamp()
+		}
+		if maxSet {
+			in.Maxt = maxTime.PrometheusTimestamp()
+		}
+		if in.Mint > in.Maxt {
+			return nil, errors.New("--rewrite.delete-min-time must not be after --rewrite.delete-max-time")
+		}
+		intervals = tombstones.Intervals{in}
+	}
+
+	deletions := make([]metadata.DeletionRequest, 0, len(matchers))
+	for _, m := range matchers {
+		ms, err := parser.ParseMetricSelector(m)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse deletion matchers %s", m)
+		}
+		deletions = append(deletions, metadata.DeletionRequest{Matchers: ms, Intervals: intervals})
+	}
+	return deletions, nil
+}
+
+// rewriteReport is what a rewrite dropped from a block.
+type rewriteReport struct {
+	id      ulid.ULID
+	newID   *ulid.ULID
+	stats   tsdb.BlockStats
+	dropped tsdb.BlockStats
+}
+
+// droppedStats returns the series, chunks and samples of the block with stats old which are not in the block with
+// stats new.
+func droppedStats(old, new tsdb.BlockStats) tsdb.BlockStats {
+	sub := func(a, b uint64) uint64 {
+		if b > a {
+			return 0
+		}
+		return a - b
+	}
+	return tsdb.BlockStats{
+		NumSeries:  sub(old.NumSeries, new.NumSeries),
+		NumChunks:  sub(old.NumChunks, new.NumChunks),
+		NumSamples: sub(old.NumSamples, new.NumSamples),
+	}
+}
+
+func printRewriteReports(w io.Writer, reports []rewriteReport) error {
+	p := message.NewPrinter(language.English)
+	t := Table{Header: []string{"BLOCK", "NEW BLOCK", "SERIES", "DROPPED SERIES", "SAMPLES", "DROPPED SAMPLES"}}
+	for _, r := range reports {
+		newID := "-"
+		if r.newID != nil {
+			newID = r.newID.String()
+		}
+		t.Lines = append(t.Lines, []string{
+			r.id.String(),
+			newID,
+			p.Sprintf("%d", r.stats.NumSeries),
+			p.Sprintf("%d", r.dropped.NumSeries),
+			p.Sprintf("%d", r.stats.NumSamples),
+			p.Sprintf("%d", r.dropped.NumSamples),
+		})
+	}
+	return printTable(w, t)
+}
+
 func registerBucketRetention(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
 	var (
 		retentionRaw, retentionFiveMin, retentionOneHr prommodel.Duration
@@ -1320,7 +2061,7 @@ func registerBucketRetention(app extkingpin.AppClause, objStoreConfig *extflag.P
 			return err
 		}
 
-		bkt, err := client.NewBucket(logger, confContentYaml, reg, component.Retention.String())
+		bkt, err := extobjstore.NewBucket(logger, confContentYaml, reg, component.Retention.String())
 		if err != nil {
 			return err
 		}
@@ -1382,3 +2123,228 @@ func registerBucketRetention(app extkingpin.AppClause, objStoreConfig *extflag.P
 		return nil
 	})
 }
+
+func registerBucketReconcile(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
+	cmd := app.Command("reconcile", "Compare the objects of the bucket and its secondary bucket, configured in the secondary section of the bucket configuration, e.g. to verify a migration between buckets before switching to the secondary bucket.")
+
+	tbc := &bucketReconcileConfig{}
+	tbc.registerBucketReconcileFlag(cmd)
+
+	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		if tbc.concurrency <= 0 {
+			return errors.New("--concurrency must be positive")
+		}
+
+		confContentYaml, err := objStoreConfig.Content()
+		if err != nil {
+			return err
+		}
+
+		primary, secondary, err := extobjstore.NewPrimaryAndSecondary(logger, confContentYaml, reg, component.Bucket.String())
+		if err != nil {
+			return err
+		}
+
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		defer runutil.CloseWithLogOnErr(logger, primary, "primary bucket client")
+		defer runutil.CloseWithLogOnErr(logger, secondary, "secondary bucket client")
+
+		report, err := extobjstore.Reconcile(context.Background(), primary, secondary, tbc.dir, tbc.concurrency)
+		if err != nil {
+			return errors.Wrap(err, "reconcile")
+		}
+
+		switch tbc.output {
+		case "":
+			for _, o := range []struct {
+				desc  string
+				names []string
+			}{
+				{desc: "missing in secondary", names: report.MissingInSecondary},
+				{desc: "missing in primary", names: report.MissingInPrimary},
+				{desc: "size mismatch", names: report.SizeMismatches},
+			} {
+				for _, name := range o.names {
+					fmt.Fprintf(os.Stdout, "%s: %s\n", o.desc, name)
+				}
+			}
+		case "json":
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "\t")
+			if err := enc.Encode(report); err != nil {
+				return err
+			}
+		default:
+			return errors.Errorf("unsupported output %s", tbc.output)
+		}
+
+		level.Info(logger).Log("msg", "reconcile done", "objects", report.Objects, "missing_in_secondary", len(report.MissingInSecondary),
+			"missing_in_primary", len(report.MissingInPrimary), "size_mismatches", len(report.SizeMismatches))
+		return nil
+	})
+}
+
+func registerBucketAnalyze(app extkingpin.AppClause, objStoreConfig *extflag.PathOrContent) {
+	cmd := app.Command("analyze", "Analyze the cardinality and the size of the indexes of blocks: the label names and values with the most series, the sizes of the sections of the index, the distribution of the sizes of postings, and the churn of series between consecutive blocks. "+
+		"It helps deciding on relabeling and retention. NOTE: The index of each analyzed block is downloaded.")
+
+	tbc := &bucketAnalyzeConfig{}
+	tbc.registerBucketAnalyzeFlag(cmd)
+
+	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		selectorLabels, err := parseFlagLabels(tbc.selector)
+		if err != nil {
+			return errors.Wrap(err, "error parsing selector flag")
+		}
+		ids := map[ulid.ULID]struct{}{}
+		for _, id := range tbc.ids {
+			u, err := ulid.Parse(id)
+			if err != nil {
+				return errors.Wrapf(err, "block id %s is invalid", id)
+			}
+			ids[u] = struct{}{}
+		}
+
+		confContentYaml, err := objStoreConfig.Content()
+		if err != nil {
+			return err
+		}
+
+		bkt, err := extobjstore.NewBucket(logger, confContentYaml, reg, component.Bucket.String())
+		if err != nil {
+			return err
+		}
+
+		fetcher, err := block.NewMetaFetcher(logger, block.FetcherConcurrency, bkt, "", extprom.WrapRegistererWithPrefix(extpromPrefix, reg), nil)
+		if err != nil {
+			return err
+		}
+
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		defer runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+
+		ctx, cancel := context.WithTimeout(context.Background(), tbc.timeout)
+		defer cancel()
+
+		metas, _, err := fetcher.Fetch(ctx)
+		if err != nil {
+			return err
+		}
+
+		var selected []*metadata.Meta
+		for id, m := range metas {
+			if _, ok := ids[id]; len(ids) > 0 && !ok {
+				continue
+			}
+			if !matchesSelector(m, selectorLabels) {
+				continue
+			}
+			selected = append(selected, m)
+		}
+		// The churn is computed between consecutive blocks of the same group.
+		sort.Slice(selected, func(i, j int) bool {
+			if gi, gj := selected[i].Thanos.GroupKey(), selected[j].Thanos.GroupKey(); gi != gj {
+				return gi < gj
+			}
+			return selected[i].MinTime < selected[j].MinTime
+		})
+
+		if err := os.MkdirAll(tbc.tmpDir, os.ModePerm); err != nil {
+			return errors.Wrap(err, "create tmp dir")
+		}
+
+		var (
+			report struct {
+				Blocks []*block.IndexAnalysis `json:"blocks"`
+				Churn  []block.SeriesChurn    `json:"churn"`
+			}
+			prev *metadata.Meta
+		)
+		for _, m := range selected {
+			a, err := analyzeBlockIndex(ctx, logger, bkt, m.ULID, tbc.tmpDir, tbc.limit)
+			if err != nil {
+				return errors.Wrapf(err, "analyze block %s", m.ULID)
+			}
+			if n := len(report.Blocks); n > 0 {
+				last := report.Blocks[n-1]
+				if prev.Thanos.GroupKey() == m.Thanos.GroupKey() {
+					report.Churn = append(report.Churn, a.Churn(last))
+				}
+				last.ReleaseSeries()
+			}
+			report.Blocks = append(report.Blocks, a)
+			prev = m
+		}
+
+		if tbc.output == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "\t")
+			return enc.Encode(report)
+		}
+		return printIndexAnalysis(os.Stdout, report.Blocks, report.Churn)
+	})
+}
+
+// analyzeBlockIndex downloads the index of the block to dir, and returns its analysis.
+func analyzeBlockIndex(ctx context.Context, logger log.Logger, bkt objstore.Bucket, id ulid.ULID, dir string, limit int) (*block.IndexAnalysis, error) {
+	fn := filepath.Join(dir, id.String()+"-"+block.IndexFilename)
+	defer func() {
+		if err := os.RemoveAll(fn); err != nil {
+			level.Warn(logger).Log("msg", "failed to delete index", "file", fn, "err", err)
+		}
+	}()
+
+	level.Info(logger).Log("msg", "downloading index of block to analyze it", "id", id)
+	if err := objstore.DownloadFile(ctx, logger, bkt, path.Join(id.String(), block.IndexFilename), fn); err != nil {
+		return nil, errors.Wrap(err, "download index")
+	}
+	return block.AnalyzeIndex(fn, id, limit)
+}
+
+func printIndexAnalysis(w io.Writer, analyses []*block.IndexAnalysis, churn []block.SeriesChurn) error {
+	p := message.NewPrinter(language.English)
+	for _, a := range analyses {
+		fmt.Fprintf(w, "Block %s: %s series, %s bytes of index\n\n", a.ULID, p.Sprintf("%d", a.Series), p.Sprintf("%d", a.IndexBytes))
+
+		sections := Table{Header: []string{"SECTION", "BYTES"}}
+		for _, s := range a.Sections {
+			sections.Lines = append(sections.Lines, []string{s.Name, p.Sprintf("%d", s.Bytes)})
+		}
+		names := Table{Header: []string{"LABEL NAME", "SERIES", "VALUES"}}
+		for _, l := range a.LabelNames {
+			names.Lines = append(names.Lines, []string{l.Name, p.Sprintf("%d", l.Series), p.Sprintf("%d", l.Values)})
+		}
+		values := Table{Header: []string{"LABEL NAME", "LABEL VALUE", "SERIES"}}
+		for _, l := range a.LabelValues {
+			values.Lines = append(values.Lines, []string{l.Name, l.Value, p.Sprintf("%d", l.Series)})
+		}
+		postings := Table{Header: []string{"POSTINGS SERIES", "POSTINGS", "BYTES"}}
+		for _, b := range a.PostingsSizes {
+			le := "+Inf"
+			if b.MaxSeries > 0 {
+				le = p.Sprintf("<= %d", b.MaxSeries)
+			}
+			postings.Lines = append(postings.Lines, []string{le, p.Sprintf("%d", b.Postings), p.Sprintf("%d", b.Bytes)})
+		}
+
+		for _, t := range []Table{sections, names, values, postings} {
+			if err := printTable(w, t); err != nil {
+				return err
+			}
+			fmt.Fprintln(w)
+		}
+	}
+
+	if len(churn) == 0 {
+		return nil
+	}
+	t := Table{Header: []string{"FROM", "TO", "ADDED SERIES", "REMOVED SERIES"}}
+	for _, c := range churn {
+		t.Lines = append(t.Lines, []string{c.From.String(), c.To.String(), p.Sprintf("%d", c.Added), p.Sprintf("%d", c.Removed)})
+	}
+	return printTable(w, t)
+}
@@ -0,0 +1,131 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/run"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	prommodel "github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/extkingpin"
+	"github.com/thanos-io/thanos/pkg/extobjstore"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+type importPrometheusSnapshotConfig struct {
+	snapshotDir   string
+	labelStrs     []string
+	blockDuration *prommodel.Duration
+	includeHead   bool
+	tmpDir        string
+}
+
+func (tbc *importPrometheusSnapshotConfig) registerFlag(cmd extkingpin.FlagClause) *importPrometheusSnapshotConfig {
+	cmd.Flag("snapshot.dir", "Prometheus data directory or snapshot directory to import. It is not modified. Prometheus has to be stopped to import its data directory with --include-head.").
+		Required().StringVar(&tbc.snapshotDir)
+	cmd.Flag("label", "External labels to be applied to all imported blocks (repeated). Use labels distinct from the ones of any other Prometheus or sidecar, unless the imported data is not uploaded by them.").
+		PlaceHolder("<name>=\"<value>\"").StringsVar(&tbc.labelStrs)
+	tbc.blockDuration = extkingpin.ModelDuration(cmd.Flag("block-duration", "Duration of the imported blocks, which are aligned to it, e.g. 2h for blocks compacted by the compactor like the blocks uploaded by sidecars. "+
+		"The data of the Prometheus blocks is split and merged into these ranges. 0s keeps the ranges of the Prometheus blocks.").Default("0s"))
+	cmd.Flag("include-head", "Also import the samples of the head, replayed from the WAL of the data directory. Snapshots include the head as a block unless they are taken with skip_head.").
+		Default("false").BoolVar(&tbc.includeHead)
+	cmd.Flag("tmp.dir", "Working directory the imported blocks are written to before they are uploaded.").
+		Default(filepath.Join(os.TempDir(), "thanos-import")).StringVar(&tbc.tmpDir)
+	return tbc
+}
+
+func registerImport(app extkingpin.AppClause) {
+	cmd := app.Command("import", "Import utility commands")
+
+	registerImportPrometheusSnapshot(cmd)
+}
+
+func registerImportPrometheusSnapshot(app extkingpin.AppClause) {
+	cmd := app.Command("prometheus-snapshot", "Import the blocks of a Prometheus data directory or snapshot to the bucket with the given external labels, "+
+		"e.g. to migrate away from a standalone Prometheus. The blocks are rewritten, so samples deleted with the TSDB admin API are dropped. "+
+		"NOTE: Importing the same data twice uploads duplicated blocks, which are only merged by a compactor with vertical compaction enabled.")
+	tbc := &importPrometheusSnapshotConfig{}
+	tbc.registerFlag(cmd)
+	objStoreConfig := extkingpin.RegisterCommonObjStoreFlags(cmd, "", false)
+
+	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		lset, err := parseFlagLabels(tbc.labelStrs)
+		if err != nil {
+			return errors.Wrap(err, "parse labels")
+		}
+		if len(lset) == 0 {
+			return errors.New("at least one label has to be set, as blocks without external labels are not allowed")
+		}
+
+		confContentYaml, err := objStoreConfig.Content()
+		if err != nil {
+			return err
+		}
+		bkt, err := extobjstore.NewBucket(logger, confContentYaml, reg, "import")
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			defer runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+			return importPrometheusSnapshot(ctx, logger, bkt, tbc, lset)
+		}, func(error) {
+			cancel()
+		})
+		return nil
+	})
+}
+
+// importPrometheusSnapshot writes the blocks of the Prometheus data directory or snapshot to the working directory, and
+// uploads them with the given labels to the bucket.
+func importPrometheusSnapshot(ctx context.Context, logger log.Logger, bkt objstore.Bucket, tbc *importPrometheusSnapshotConfig, lset labels.Labels) error {
+	if err := os.RemoveAll(tbc.tmpDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tbc.tmpDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	ids, err := block.ImportPrometheusBlocks(ctx, logger, tbc.snapshotDir, tbc.tmpDir, block.ImportOptions{
+		BlockDuration: time.Duration(*tbc.blockDuration),
+		IncludeHead:   tbc.includeHead,
+	})
+	if err != nil {
+		return errors.Wrap(err, "import blocks")
+	}
+
+	for _, id := range ids {
+		bdir := filepath.Join(tbc.tmpDir, id.String())
+		if _, err := metadata.InjectThanos(logger, bdir, metadata.Thanos{
+			Labels:     lset.Map(),
+			Downsample: metadata.ThanosDownsample{Resolution: 0},
+			Source:     metadata.ImportSource,
+		}, nil); err != nil {
+			return errors.Wrapf(err, "finalize block %s", id)
+		}
+		if err := block.Upload(ctx, logger, bkt, bdir, metadata.NoneFunc); err != nil {
+			return errors.Wrapf(err, "upload block %s", id)
+		}
+		if err := os.RemoveAll(bdir); err != nil {
+			return err
+		}
+		level.Info(logger).Log("msg", "uploaded block", "id", id)
+	}
+	level.Info(logger).Log("msg", "import done", "blocks", len(ids))
+	return nil
+}
@@ -0,0 +1,422 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/run"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+
+	"github.com/thanos-io/thanos/pkg/extkingpin"
+	"github.com/thanos-io/thanos/pkg/httpconfig"
+	"github.com/thanos-io/thanos/pkg/queryfrontend"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+type queryCaptureConfig struct {
+	logFiles    []string
+	queriesFile string
+	pathRegex   string
+	minDuration time.Duration
+}
+
+func (tqc *queryCaptureConfig) registerFlag(cmd extkingpin.FlagClause) *queryCaptureConfig {
+	cmd.Flag("frontend-log.file", "Log file of the query frontend to read the logged queries from (repeated). The query frontend logs the queries slower than --query-frontend.log-queries-longer-than, "+
+		"all of them if it is negative. Both the logfmt and the JSON log formats are supported.").
+		Required().PlaceHolder("<path>").StringsVar(&tqc.logFiles)
+	cmd.Flag("queries.file", "File the captured queries are written to, one JSON object per line.").
+		Required().StringVar(&tqc.queriesFile)
+	cmd.Flag("path", "Regular expression the API path of the captured queries has to match.").
+		Default("/api/v1/query(_range)?").StringVar(&tqc.pathRegex)
+	cmd.Flag("min-duration", "Only capture queries which took at least this long when they were logged.").
+		Default("0s").DurationVar(&tqc.minDuration)
+	return tqc
+}
+
+type queryReplayConfig struct {
+	queriesFile string
+	queryURL    *url.URL
+	concurrency int
+	speed       float64
+	timeout     time.Duration
+	output      string
+}
+
+func (tqc *queryReplayConfig) registerFlag(cmd extkingpin.FlagClause) *queryReplayConfig {
+	cmd.Flag("queries.file", "File of the queries to replay, as written by tools query capture.").
+		Required().StringVar(&tqc.queriesFile)
+	cmd.Flag("query", "Address of the query API server to replay the queries against, e.g. http://localhost:10902.").
+		Required().URLVar(&tqc.queryURL)
+	cmd.Flag("concurrency", "Maximum number of queries in flight.").
+		Default("10").IntVar(&tqc.concurrency)
+	cmd.Flag("speed", "Speed of the replay relative to the times the queries were logged at, e.g. 2 replays them twice as fast. "+
+		"0 replays them as fast as --concurrency allows.").
+		Default("1").Float64Var(&tqc.speed)
+	cmd.Flag("timeout", "Timeout of each replayed query.").
+		Default("2m").DurationVar(&tqc.timeout)
+	cmd.Flag("output", "Output format of the latency report. Currently supports table, json.").
+		Default("table").EnumVar(&tqc.output, string(TABLE), string(JSON))
+	return tqc
+}
+
+func registerQueryTools(app extkingpin.AppClause) {
+	cmd := app.Command("query", "Query utility commands")
+
+	registerQueryCapture(cmd)
+	registerQueryReplay(cmd)
+}
+
+func registerQueryCapture(app extkingpin.AppClause) {
+	cmd := app.Command("capture", "Capture the queries logged by the query frontend into a file to be replayed by tools query replay.")
+	tqc := &queryCaptureConfig{}
+	tqc.registerFlag(cmd)
+
+	cmd.Setup(func(g *run.Group, logger log.Logger, _ *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		pathRegex, err := regexp.Compile("^(?:" + tqc.pathRegex + ")$")
+		if err != nil {
+			return errors.Wrap(err, "parse path regex")
+		}
+
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		var queries []queryfrontend.LoggedQuery
+		for _, file := range tqc.logFiles {
+			qs, err := readQueryLog(file)
+			if err != nil {
+				return errors.Wrapf(err, "read %s", file)
+			}
+			for _, q := range qs {
+				if q.Duration < tqc.minDuration || !pathRegex.MatchString(q.Path) {
+					continue
+				}
+				queries = append(queries, q)
+			}
+		}
+		sort.SliceStable(queries, func(i, j int) bool { return queries[i].Time.Before(queries[j].Time) })
+
+		if err := writeQueries(tqc.queriesFile, queries); err != nil {
+			return errors.Wrapf(err, "write %s", tqc.queriesFile)
+		}
+		level.Info(logger).Log("msg", "captured queries", "queries", len(queries), "file", tqc.queriesFile)
+		return nil
+	})
+}
+
+func registerQueryReplay(app extkingpin.AppClause) {
+	cmd := app.Command("replay", "Replay the queries captured by tools query capture against a query API and report their latencies, "+
+		"e.g. to compare the performance of two versions or configurations of queriers and store gateways.")
+	tqc := &queryReplayConfig{}
+	tqc.registerFlag(cmd)
+
+	cmd.Setup(func(g *run.Group, logger log.Logger, _ *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		if tqc.concurrency <= 0 {
+			return errors.New("concurrency has to be positive")
+		}
+		if tqc.speed < 0 {
+			return errors.New("speed cannot be negative")
+		}
+
+		queries, err := readQueries(tqc.queriesFile)
+		if err != nil {
+			return errors.Wrapf(err, "read %s", tqc.queriesFile)
+		}
+
+		clientConf := httpconfig.NewDefaultClientConfig()
+		clientConf.TransportConfig.MaxIdleConnsPerHost = tqc.concurrency
+		httpClient, err := httpconfig.NewHTTPClient(clientConf, "query")
+		if err != nil {
+			return err
+		}
+		httpClient.Timeout = tqc.timeout
+
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			level.Info(logger).Log("msg", "replaying queries", "queries", len(queries), "query", tqc.queryURL, "concurrency", tqc.concurrency, "speed", tqc.speed)
+			results := replayQueries(ctx, httpClient, tqc.queryURL, queries, tqc.concurrency, tqc.speed)
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			report := newQueryReplayReport(queries, results)
+			if tqc.output == string(JSON) {
+				return json.NewEncoder(os.Stdout).Encode(report)
+			}
+			return printQueryReplayReport(os.Stdout, report)
+		}, func(error) {
+			cancel()
+		})
+		return nil
+	})
+}
+
+func readQueryLog(file string) (_ []queryfrontend.LoggedQuery, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer runutil.CloseWithErrCapture(&err, f, "query log")
+
+	return queryfrontend.ParseQueryLog(f)
+}
+
+func writeQueries(file string, queries []queryfrontend.LoggedQuery) (err error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer runutil.CloseWithErrCapture(&err, f, "queries file")
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, q := range queries {
+		if err := enc.Encode(q); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func readQueries(file string) (_ []queryfrontend.LoggedQuery, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer runutil.CloseWithErrCapture(&err, f, "queries file")
+
+	var queries []queryfrontend.LoggedQuery
+	dec := json.NewDecoder(f)
+	for {
+		var q queryfrontend.LoggedQuery
+		if err := dec.Decode(&q); err == io.EOF {
+			return queries, nil
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "decode query %d", len(queries)+1)
+		}
+		queries = append(queries, q)
+	}
+}
+
+// queryReplayResult is the result of a replayed query.
+type queryReplayResult struct {
+	latency time.Duration
+	err     error
+}
+
+// replayQueries sends the queries to the query API at base with at most concurrency queries in flight, and returns their
+// results in the same order. With a positive speed, each query is sent relative to the first one at its logged time divided
+// by speed, or as soon as a query finishes if all concurrency queries are in flight.
+func replayQueries(ctx context.Context, client *http.Client, base *url.URL, queries []queryfrontend.LoggedQuery, concurrency int, speed float64) []queryReplayResult {
+	var (
+		results = make([]queryReplayResult, len(queries))
+		sem     = make(chan struct{}, concurrency)
+		wg      sync.WaitGroup
+		start   = time.Now()
+	)
+
+	for i, q := range queries {
+		if speed > 0 && i > 0 && !q.Time.IsZero() && !queries[0].Time.IsZero() {
+			offset := time.Duration(float64(q.Time.Sub(queries[0].Time)) / speed)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Until(start.Add(offset))):
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+		case sem <- struct{}{}:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, q queryfrontend.LoggedQuery) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			begin := time.Now()
+			err := replayQuery(ctx, client, base, q)
+			results[i] = queryReplayResult{latency: time.Since(begin), err: err}
+		}(i, q)
+	}
+	wg.Wait()
+	return results
+}
+
+func replayQuery(ctx context.Context, client *http.Client, base *url.URL, q queryfrontend.LoggedQuery) (err error) {
+	u := *base
+	u.Path = path.Join(u.Path, q.Path)
+
+	var req *http.Request
+	if q.Method == http.MethodPost {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, u.String(), strings.NewReader(q.Params.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		u.RawQuery = q.Params.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer runutil.ExhaustCloseWithErrCapture(&err, resp.Body, "response body")
+
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("unexpected status %s", resp.Status)
+	}
+	// The latency includes reading the whole response, like for a client of the query API.
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+// queryLatencies is the latency distribution of a set of queries.
+type queryLatencies struct {
+	Queries int           `json:"queries"`
+	Errors  int           `json:"errors"`
+	Min     time.Duration `json:"min"`
+	P50     time.Duration `json:"p50"`
+	P90     time.Duration `json:"p90"`
+	P99     time.Duration `json:"p99"`
+	Max     time.Duration `json:"max"`
+	// LoggedP50 and LoggedP99 are the latencies the queries were logged with, for comparison.
+	LoggedP50 time.Duration `json:"loggedP50"`
+	LoggedP99 time.Duration `json:"loggedP99"`
+
+	latencies, logged []time.Duration
+}
+
+func (l *queryLatencies) add(q queryfrontend.LoggedQuery, r queryReplayResult) {
+	l.Queries++
+	l.logged = append(l.logged, q.Duration)
+	if r.err != nil {
+		l.Errors++
+		return
+	}
+	l.latencies = append(l.latencies, r.latency)
+}
+
+func (l *queryLatencies) compute() {
+	sort.Slice(l.latencies, func(i, j int) bool { return l.latencies[i] < l.latencies[j] })
+	sort.Slice(l.logged, func(i, j int) bool { return l.logged[i] < l.logged[j] })
+
+	if len(l.latencies) > 0 {
+		l.Min, l.Max = l.latencies[0], l.latencies[len(l.latencies)-1]
+	}
+	l.P50, l.P90, l.P99 = quantile(l.latencies, 0.5), quantile(l.latencies, 0.9), quantile(l.latencies, 0.99)
+	l.LoggedP50, l.LoggedP99 = quantile(l.logged, 0.5), quantile(l.logged, 0.99)
+}
+
+// quantile returns the q-quantile of the sorted durations using the nearest rank.
+func quantile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(q*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// queryReplayReport is the report of a replay, with the latencies of all queries and of the queries of each path.
+type queryReplayReport struct {
+	Total  *queryLatencies            `json:"total"`
+	Paths  map[string]*queryLatencies `json:"paths"`
+	Errors map[string]int             `json:"errors,omitempty"`
+}
+
+func newQueryReplayReport(queries []queryfrontend.LoggedQuery, results []queryReplayResult) queryReplayReport {
+	report := queryReplayReport{Total: &queryLatencies{}, Paths: map[string]*queryLatencies{}, Errors: map[string]int{}}
+	for i, q := range queries {
+		r := results[i]
+		report.Total.add(q, r)
+		if _, ok := report.Paths[q.Path]; !ok {
+			report.Paths[q.Path] = &queryLatencies{}
+		}
+		report.Paths[q.Path].add(q, r)
+		if r.err != nil {
+			report.Errors[r.err.Error()]++
+		}
+	}
+	report.Total.compute()
+	for _, l := range report.Paths {
+		l.compute()
+	}
+	return report
+}
+
+func printQueryReplayReport(w io.Writer, report queryReplayReport) error {
+	p := message.NewPrinter(language.English)
+
+	t := Table{Header: []string{"PATH", "QUERIES", "ERRORS", "MIN", "P50", "P90", "P99", "MAX", "LOGGED-P50", "LOGGED-P99"}}
+	line := func(name string, l *queryLatencies) []string {
+		return []string{
+			name,
+			p.Sprintf("%d", l.Queries),
+			p.Sprintf("%d", l.Errors),
+			l.Min.String(),
+			l.P50.String(),
+			l.P90.String(),
+			l.P99.String(),
+			l.Max.String(),
+			l.LoggedP50.String(),
+			l.LoggedP99.String(),
+		}
+	}
+
+	paths := make([]string, 0, len(report.Paths))
+	for name := range report.Paths {
+		paths = append(paths, name)
+	}
+	sort.Strings(paths)
+	for _, name := range paths {
+		t.Lines = append(t.Lines, line(name, report.Paths[name]))
+	}
+	t.Lines = append(t.Lines, line("total", report.Total))
+	if err := printTable(w, t); err != nil {
+		return err
+	}
+
+	errs := make([]string, 0, len(report.Errors))
+	for err := range report.Errors {
+		errs = append(errs, err)
+	}
+	sort.Strings(errs)
+	for _, err := range errs {
+		if _, perr := p.Fprintf(w, "%d queries failed with: %s\n", report.Errors[err], err); perr != nil {
+			return perr
+		}
+	}
+	return nil
+}
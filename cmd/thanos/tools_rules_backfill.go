@@ -0,0 +1,237 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/run"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	prommodel "github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/extkingpin"
+	"github.com/thanos-io/thanos/pkg/extobjstore"
+	"github.com/thanos-io/thanos/pkg/httpconfig"
+	"github.com/thanos-io/thanos/pkg/model"
+	"github.com/thanos-io/thanos/pkg/promclient"
+	"github.com/thanos-io/thanos/pkg/receive"
+	"github.com/thanos-io/thanos/pkg/rules"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+type rulesBackfillConfig struct {
+	rulesFiles    []string
+	queryURL      *url.URL
+	labelStrs     []string
+	dataDir       string
+	evalInterval  time.Duration
+	queryOffset   time.Duration
+	blockDuration *prommodel.Duration
+	tenantHeader  string
+	start, end    *model.TimeOrDurationValue
+}
+
+func (tbc *rulesBackfillConfig) registerFlag(cmd extkingpin.FlagClause) *rulesBackfillConfig {
+	cmd.Flag("rules", "The rule files glob to backfill (repeated).").Required().StringsVar(&tbc.rulesFiles)
+	cmd.Flag("query", "Address of the query API server to evaluate the rules against, e.g. http://localhost:10902.").Required().URLVar(&tbc.queryURL)
+	cmd.Flag("label", "Labels to be applied to all written blocks (repeated). Use the labels of the ruler evaluating the rules, so that the blocks are compacted with its blocks.").
+		PlaceHolder("<name>=\"<value>\"").StringsVar(&tbc.labelStrs)
+	cmd.Flag("data-dir", "Data directory the blocks are written to before they are uploaded. It has to be empty or not exist. A temporary directory is used if not set.").
+		StringVar(&tbc.dataDir)
+	cmd.Flag("eval-interval", "The default evaluation interval to use.").Default("1m").DurationVar(&tbc.evalInterval)
+	cmd.Flag("eval-query-offset", "The default offset of the evaluation queries of rules. Rule groups can override it with the query_offset field.").
+		Default("0s").DurationVar(&tbc.queryOffset)
+	tbc.blockDuration = extkingpin.ModelDuration(cmd.Flag("block-duration", "Block duration of the written blocks.").Default("2h"))
+	cmd.Flag("tenant-header", "HTTP header the tenant of rule groups with a tenant is sent in on their evaluation queries.").
+		Default(receive.DefaultTenantHeader).StringVar(&tbc.tenantHeader)
+	tbc.start = model.TimeOrDuration(cmd.Flag("start", "Start of the time range to evaluate the rules over. Option can be a constant time in RFC3339 format or time duration relative to current time, such as -1d or 2h45m. Valid duration units are ms, s, m, h, d, w, y.").
+		Required())
+	tbc.end = model.TimeOrDuration(cmd.Flag("end", "End of the time range to evaluate the rules over. It has to be before the results written by the ruler, as blocks overlapping blocks of the bucket with the same labels are not backfilled. Option can be a constant time in RFC3339 format or time duration relative to current time, such as -1d or 2h45m. Valid duration units are ms, s, m, h, d, w, y.").
+		Required())
+	return tbc
+}
+
+func registerRulesBackfill(app extkingpin.AppClause) {
+	cmd := app.Command("rules-backfill", "Evaluate recording rules over a past time range against the query API and upload their results as blocks to the bucket, "+
+		"so that new recording rules have history. Rules are evaluated independently, so rules selecting the results of other rules only see the results of those rules written by the ruler. Alerting rules are skipped.")
+	tbc := &rulesBackfillConfig{}
+	tbc.registerFlag(cmd)
+	objStoreConfig := extkingpin.RegisterCommonObjStoreFlags(cmd, "", false)
+
+	cmd.Setup(func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		lset, err := parseFlagLabels(tbc.labelStrs)
+		if err != nil {
+			return errors.Wrap(err, "parse labels")
+		}
+		if len(lset) == 0 {
+			return errors.New("at least one label has to be set, as blocks without external labels are not allowed")
+		}
+		start, end := timestamp.Time(tbc.start.PrometheusTimestamp()), timestamp.Time(tbc.end.PrometheusTimestamp())
+		if !start.Before(end) {
+			return errors.Errorf("start %v has to be before end %v", start, end)
+		}
+		if tbc.evalInterval <= 0 {
+			return errors.Errorf("eval interval %v has to be positive", tbc.evalInterval)
+		}
+		if *tbc.blockDuration <= 0 {
+			return errors.Errorf("block duration %v has to be positive", *tbc.blockDuration)
+		}
+
+		var files []string
+		for _, pat := range tbc.rulesFiles {
+			fs, err := filepath.Glob(pat)
+			if err != nil {
+				return errors.Wrapf(err, "glob %s", pat)
+			}
+			files = append(files, fs...)
+		}
+		if len(files) == 0 {
+			return errors.New("no rule files found")
+		}
+
+		confContentYaml, err := objStoreConfig.Content()
+		if err != nil {
+			return err
+		}
+		bkt, err := extobjstore.NewBucket(logger, confContentYaml, reg, "rules-backfill")
+		if err != nil {
+			return err
+		}
+
+		httpClient, err := httpconfig.NewHTTPClient(httpconfig.ClientConfig{}, "query")
+		if err != nil {
+			return err
+		}
+		httpClient.Transport = rules.TenantTripperware(tbc.tenantHeader, httpClient.Transport)
+		promClient := promclient.NewWithTracingClient(logger, httpClient, "thanos-rules-backfill")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			defer runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+			return rulesBackfill(ctx, logger, bkt, promClient, tbc, files, lset, start, end)
+		}, func(error) {
+			cancel()
+		})
+		return nil
+	})
+}
+
+// rulesBackfill evaluates the recording rules of the given files over [start, end] against the query API, and uploads
+// the written blocks with the given labels to the bucket.
+func rulesBackfill(ctx context.Context, logger log.Logger, bkt objstore.Bucket, promClient *promclient.Client, tbc *rulesBackfillConfig, files []string, lset labels.Labels, start, end time.Time) error {
+	dataDir, cleanup, err := backfillDataDir(tbc.dataDir)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if err := checkBackfillOverlap(ctx, logger, bkt, lset, start, end); err != nil {
+		return err
+	}
+
+	queryRange := func(ctx context.Context, q string, start, end time.Time, step time.Duration, partialResponseStrategy storepb.PartialResponseStrategy) (prommodel.Matrix, error) {
+		m, warns, err := promClient.QueryRange(ctx, tbc.queryURL, q, timestamp.FromTime(start), timestamp.FromTime(end), int64(step/time.Second), promclient.QueryOptions{
+			Deduplicate:             true,
+			PartialResponseStrategy: partialResponseStrategy,
+		})
+		if len(warns) > 0 {
+			level.Warn(logger).Log("msg", "range query returned warnings", "query", q, "warnings", warns)
+		}
+		return m, err
+	}
+	ids, err := rules.Backfill(ctx, logger, dataDir, files, queryRange, rules.BackfillOptions{
+		Start:         start,
+		End:           end,
+		EvalInterval:  tbc.evalInterval,
+		QueryOffset:   tbc.queryOffset,
+		BlockDuration: time.Duration(*tbc.blockDuration),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		bdir := filepath.Join(dataDir, id.String())
+		if _, err := metadata.InjectThanos(logger, bdir, metadata.Thanos{
+			Labels:     lset.Map(),
+			Downsample: metadata.ThanosDownsample{Resolution: 0},
+			Source:     metadata.RulesBackfillSource,
+		}, nil); err != nil {
+			return errors.Wrapf(err, "finalize block %s", id)
+		}
+		if err := block.Upload(ctx, logger, bkt, bdir, metadata.NoneFunc); err != nil {
+			return errors.Wrapf(err, "upload block %s", id)
+		}
+		if err := os.RemoveAll(bdir); err != nil {
+			return err
+		}
+		level.Info(logger).Log("msg", "uploaded block", "id", id)
+	}
+	level.Info(logger).Log("msg", "rules backfill done", "blocks", len(ids))
+	return nil
+}
+
+// backfillDataDir returns the directory to write the blocks to, and a function removing it if it was created here.
+// Without a configured directory a temporary one is created. A configured directory has to be empty or not exist, so
+// that no files of the user are removed.
+func backfillDataDir(dir string) (string, func(), error) {
+	if dir == "" {
+		dir, err := os.MkdirTemp("", "thanos-rules-backfill")
+		if err != nil {
+			return "", nil, errors.Wrap(err, "create temporary data directory")
+		}
+		return dir, func() { _ = os.RemoveAll(dir) }, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err == nil {
+		if len(entries) > 0 {
+			return "", nil, errors.Errorf("data directory %s is not empty", dir)
+		}
+		return dir, func() {}, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", nil, errors.Wrapf(err, "read data directory %s", dir)
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", nil, errors.Wrapf(err, "create data directory %s", dir)
+	}
+	return dir, func() { _ = os.RemoveAll(dir) }, nil
+}
+
+// checkBackfillOverlap returns an error if blocks of the bucket with the given labels overlap [start, end], as the
+// compactor halts on overlapping blocks unless vertical compaction is enabled.
+func checkBackfillOverlap(ctx context.Context, logger log.Logger, bkt objstore.Bucket, lset labels.Labels, start, end time.Time) error {
+	fetcher, err := block.NewMetaFetcher(logger, block.FetcherConcurrency, objstore.WithNoopInstr(bkt), "", nil, nil)
+	if err != nil {
+		return errors.Wrap(err, "create meta fetcher")
+	}
+	metas, _, err := fetcher.Fetch(ctx)
+	if err != nil {
+		return errors.Wrap(err, "fetch metas")
+	}
+	mint, maxt := timestamp.FromTime(start), timestamp.FromTime(end)
+	for id, m := range metas {
+		if m.MinTime > maxt || m.MaxTime <= mint || !labels.Equal(labels.FromMap(m.Thanos.Labels), lset) {
+			continue
+		}
+		return errors.Errorf("block %s with labels %s overlaps the time range to backfill, from %v to %v", id, lset,
+			timestamp.Time(m.MinTime).UTC(), timestamp.Time(m.MaxTime).UTC())
+	}
+	return nil
+}
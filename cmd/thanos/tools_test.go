@@ -4,11 +4,32 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/tombstones"
+	"github.com/thanos-io/objstore"
 
 	"github.com/efficientgo/core/testutil"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/model"
+	"github.com/thanos-io/thanos/pkg/queryfrontend"
 )
 
 func Test_CheckRules(t *testing.T) {
@@ -45,3 +66,207 @@ func Test_CheckRules_Glob(t *testing.T) {
 	files = &[]string{"./testdata/rules-files/*.yamlaaa"}
 	testutil.NotOk(t, checkRulesFiles(logger, files), "expected err for file %s", files)
 }
+
+func Test_parseDeletionFlags(t *testing.T) {
+	var unset model.TimeOrDurationValue
+
+	deletions, err := parseDeletionFlags([]string{`{__name__="up", job="api"}`}, &unset, &unset)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(deletions))
+	testutil.Equals(t, 2, len(deletions[0].Matchers))
+	testutil.Equals(t, 0, len(deletions[0].Intervals))
+
+	minTime := time.Unix(10, 0)
+	deletions, err = parseDeletionFlags([]string{`{job="api"}`, `{job="db"}`}, &model.TimeOrDurationValue{Time: &minTime}, &unset)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(deletions))
+	testutil.Equals(t, tombstones.Intervals{{Mint: 10000, Maxt: math.MaxInt64}}, deletions[1].Intervals)
+
+	_, err = parseDeletionFlags(nil, &model.TimeOrDurationValue{Time: &minTime}, &unset)
+	testutil.NotOk(t, err)
+	_, err = parseDeletionFlags([]string{`{job=}`}, &unset, &unset)
+	testutil.NotOk(t, err)
+}
+
+func Test_matchesInspectFilters(t *testing.T) {
+	meta := &metadata.Meta{Thanos: metadata.Thanos{
+		Labels:     map[string]string{"cluster": "eu-1", "replica": "a"},
+		Downsample: metadata.ThanosDownsample{Resolution: (5 * time.Minute).Milliseconds()},
+	}}
+
+	testutil.Assert(t, matchesInspectFilters(meta, nil, nil))
+	testutil.Assert(t, matchesInspectFilters(meta, []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "cluster", "eu-.*")}, nil))
+	testutil.Assert(t, !matchesInspectFilters(meta, []*labels.Matcher{labels.MustNewMatcher(labels.MatchNotEqual, "replica", "a")}, nil))
+	testutil.Assert(t, !matchesInspectFilters(meta, []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "region", "eu")}, nil))
+	testutil.Assert(t, matchesInspectFilters(meta, nil, []time.Duration{0, 5 * time.Minute}))
+	testutil.Assert(t, !matchesInspectFilters(meta, nil, []time.Duration{time.Hour}))
+}
+
+func Test_selectBlocksToMark(t *testing.T) {
+	meta := func(id uint64, mint, maxt int64, cluster string, resolution time.Duration) *metadata.Meta {
+		return &metadata.Meta{
+			BlockMeta: tsdb.BlockMeta{ULID: ulid.MustNew(id, nil), MinTime: mint, MaxTime: maxt},
+			Thanos: metadata.Thanos{
+				Labels:     map[string]string{"cluster": cluster},
+				Downsample: metadata.ThanosDownsample{Resolution: resolution.Milliseconds()},
+			},
+		}
+	}
+	metas := map[ulid.ULID]*metadata.Meta{}
+	for _, m := range []*metadata.Meta{
+		meta(1, 100, 200, "eu-1", 0),
+		meta(2, 0, 100, "eu-1", 0),
+		meta(3, 150, 300, "eu-1", 0),
+		meta(4, 0, 100, "us-1", 0),
+		meta(5, 0, 100, "eu-1", 5*time.Minute),
+	} {
+		metas[m.ULID] = m
+	}
+	ids := func(metas []*metadata.Meta) (res []uint64) {
+		for _, m := range metas {
+			res = append(res, m.ULID.Time())
+		}
+		return res
+	}
+
+	testutil.Equals(t, []uint64{2, 4, 5, 1, 3}, ids(selectBlocksToMark(metas, math.MinInt64, math.MaxInt64, nil, nil)))
+	// Only blocks with all their data in the time range are selected.
+	testutil.Equals(t, []uint64{2, 4, 5, 1}, ids(selectBlocksToMark(metas, 0, 200, nil, nil)))
+	testutil.Equals(t, []uint64{1, 3}, ids(selectBlocksToMark(metas, 100, math.MaxInt64, nil, nil)))
+	testutil.Equals(t, []uint64{2, 1}, ids(selectBlocksToMark(metas, 0, 200, []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "cluster", "eu-1")}, []time.Duration{0})))
+	testutil.Equals(t, []uint64(nil), ids(selectBlocksToMark(metas, 0, 50, nil, nil)))
+}
+
+func Test_blockSize(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	id := ulid.MustNew(1, nil)
+	testutil.Ok(t, bkt.Upload(ctx, id.String()+"/index", strings.NewReader("index")))
+	testutil.Ok(t, bkt.Upload(ctx, id.String()+"/chunks/000001", strings.NewReader("chunks")))
+
+	// Without files in the meta, the objects of the block are listed.
+	size, err := blockSize(ctx, bkt, &metadata.Meta{BlockMeta: tsdb.BlockMeta{ULID: id}})
+	testutil.Ok(t, err)
+	testutil.Equals(t, int64(11), size)
+
+	size, err = blockSize(ctx, bkt, &metadata.Meta{BlockMeta: tsdb.BlockMeta{ULID: id}, Thanos: metadata.Thanos{Files: []metadata.File{
+		{RelPath: "index", SizeBytes: 100},
+		{RelPath: "chunks/000001", SizeBytes: 200},
+		{RelPath: "meta.json"},
+	}}})
+	testutil.Ok(t, err)
+	testutil.Equals(t, int64(300), size)
+}
+
+func Test_aggregateBlocks(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	meta := func(id uint64, cluster string, series uint64, size int64) *metadata.Meta {
+		return &metadata.Meta{
+			BlockMeta: tsdb.BlockMeta{ULID: ulid.MustNew(id, nil), Stats: tsdb.BlockStats{NumSeries: series, NumSamples: 10 * series}},
+			Thanos: metadata.Thanos{
+				Labels: map[string]string{"cluster": cluster},
+				Files:  []metadata.File{{RelPath: "index", SizeBytes: size}},
+			},
+		}
+	}
+
+	aggs, err := aggregateBlocks(ctx, bkt, []*metadata.Meta{
+		meta(1, "us-1", 1, 100),
+		meta(2, "eu-1", 2, 200),
+		meta(3, "us-1", 3, 300),
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []*lsAggregate{
+		{Labels: map[string]string{"cluster": "eu-1"}, Blocks: 1, Series: 2, Samples: 20, SizeBytes: 200},
+		{Labels: map[string]string{"cluster": "us-1"}, Blocks: 2, Series: 4, Samples: 40, SizeBytes: 400},
+	}, aggs)
+}
+
+func Test_replayQueries(t *testing.T) {
+	var (
+		mtx      sync.Mutex
+		received []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testutil.Ok(t, r.ParseForm())
+		mtx.Lock()
+		received = append(received, r.Method+" "+r.URL.Path+" "+r.Form.Get("query"))
+		mtx.Unlock()
+		if r.Form.Get("query") == "fail" {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"success"}`))
+	}))
+	defer srv.Close()
+
+	base, err := url.Parse(srv.URL + "/prefix")
+	testutil.Ok(t, err)
+
+	now := time.Now()
+	queries := []queryfrontend.LoggedQuery{
+		{Time: now, Method: http.MethodGet, Path: "/api/v1/query", Params: url.Values{"query": []string{"up"}}, Duration: time.Second},
+		{Time: now.Add(time.Second), Method: http.MethodPost, Path: "/api/v1/query_range", Params: url.Values{"query": []string{"rate(x[5m])"}}, Duration: 2 * time.Second},
+		{Time: now.Add(2 * time.Second), Path: "/api/v1/query", Params: url.Values{"query": []string{"fail"}}, Duration: 3 * time.Second},
+	}
+
+	// At speed 20 the queries are sent 50ms apart.
+	begin := time.Now()
+	results := replayQueries(context.Background(), srv.Client(), base, queries, 2, 20)
+	testutil.Assert(t, time.Since(begin) >= 100*time.Millisecond, "queries replayed too fast")
+
+	sort.Strings(received)
+	testutil.Equals(t, []string{
+		"GET /prefix/api/v1/query fail",
+		"GET /prefix/api/v1/query up",
+		"POST /prefix/api/v1/query_range rate(x[5m])",
+	}, received)
+	testutil.Ok(t, results[0].err)
+	testutil.Ok(t, results[1].err)
+	testutil.NotOk(t, results[2].err)
+
+	report := newQueryReplayReport(queries, results)
+	testutil.Equals(t, 3, report.Total.Queries)
+	testutil.Equals(t, 1, report.Total.Errors)
+	testutil.Equals(t, 2*time.Second, report.Total.LoggedP50)
+	testutil.Equals(t, 3*time.Second, report.Total.LoggedP99)
+	testutil.Equals(t, 2, report.Paths["/api/v1/query"].Queries)
+	testutil.Equals(t, 1, report.Paths["/api/v1/query"].Errors)
+	testutil.Equals(t, results[0].latency, report.Paths["/api/v1/query"].P99)
+	testutil.Equals(t, 1, len(report.Errors))
+}
+
+func Test_quantile(t *testing.T) {
+	testutil.Equals(t, time.Duration(0), quantile(nil, 0.5))
+
+	sorted := make([]time.Duration, 0, 100)
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i))
+	}
+	testutil.Equals(t, time.Duration(1), quantile(sorted, 0))
+	testutil.Equals(t, time.Duration(50), quantile(sorted, 0.5))
+	testutil.Equals(t, time.Duration(99), quantile(sorted, 0.99))
+	testutil.Equals(t, time.Duration(100), quantile(sorted, 1))
+}
+
+func Test_checkBackfillOverlap(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	lset := labels.FromStrings("replica", "a")
+
+	id := ulid.MustNew(ulid.Now(), nil)
+	var buf bytes.Buffer
+	testutil.Ok(t, json.NewEncoder(&buf).Encode(metadata.Meta{
+		BlockMeta: tsdb.BlockMeta{ULID: id, MinTime: 7200000, MaxTime: 14400000, Version: 1},
+		Thanos:    metadata.Thanos{Version: metadata.ThanosVersion1, Labels: lset.Map()},
+	}))
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(id.String(), metadata.MetaFilename), &buf))
+
+	logger := log.NewNopLogger()
+	testutil.Ok(t, checkBackfillOverlap(ctx, logger, bkt, lset, time.UnixMilli(0), time.UnixMilli(7199999)))
+	testutil.Ok(t, checkBackfillOverlap(ctx, logger, bkt, lset, time.UnixMilli(14400000), time.UnixMilli(21600000)))
+	testutil.Ok(t, checkBackfillOverlap(ctx, logger, bkt, labels.FromStrings("replica", "b"), time.UnixMilli(0), time.UnixMilli(21600000)))
+	testutil.NotOk(t, checkBackfillOverlap(ctx, logger, bkt, lset, time.UnixMilli(0), time.UnixMilli(7200000)))
+	testutil.NotOk(t, checkBackfillOverlap(ctx, logger, bkt, lset, time.UnixMilli(10000000), time.UnixMilli(12000000)))
+}
@@ -176,6 +176,10 @@ func (f *Handler) reportSlowQuery(r *http.Request, responseHeaders http.Header,
 	if traceID := responseHeaders.Get("X-Thanos-Trace-Id"); traceID != "" {
 		thanosTraceID = traceID
 	}
+	requestID := "-"
+	if id := r.Header.Get("X-Request-ID"); id != "" {
+		requestID = id
+	}
 
 	remoteUser, _, _ := r.BasicAuth()
 
@@ -190,6 +194,7 @@ func (f *Handler) reportSlowQuery(r *http.Request, responseHeaders http.Header,
 		"grafana_dashboard_uid", grafanaDashboardUID,
 		"grafana_panel_id", grafanaPanelID,
 		"trace_id", thanosTraceID,
+		"request_id", requestID,
 	}, formatQueryString(queryString)...)
 
 	level.Info(util_log.WithContext(r.Context(), f.log)).Log(logMessage...)
@@ -224,6 +229,7 @@ func (f *Handler) reportQueryStats(r *http.Request, queryString url.Values, quer
 		"query_wall_time_seconds", wallTime.Seconds(),
 		"fetched_series_count", numSeries,
 		"fetched_chunks_bytes", numBytes,
+		"request_id", r.Header.Get("X-Request-ID"),
 	}, formatQueryString(queryString)...)
 
 	level.Info(util_log.WithContext(r.Context(), f.log)).Log(logMessage...)
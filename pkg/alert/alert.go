@@ -226,14 +226,17 @@ func (q *Queue) Push(alerts []*notifier.Alert) {
 
 // Sender sends notifications to a dynamic set of alertmanagers.
 type Sender struct {
-	logger        log.Logger
+	logger log.Logger
+
+	mtx           sync.RWMutex
 	alertmanagers []*Alertmanager
-	versions      []APIVersion
 
 	sent    *prometheus.CounterVec
 	errs    *prometheus.CounterVec
 	dropped prometheus.Counter
 	latency *prometheus.HistogramVec
+	// discovered is the number of endpoints of the Alertmanagers.
+	discovered prometheus.GaugeFunc
 }
 
 // NewSender returns a new sender. On each call to Send the entire alert batch is sent
@@ -246,20 +249,9 @@ func NewSender(
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
-	var (
-		versions       []APIVersion
-		versionPresent map[APIVersion]struct{}
-	)
-	for _, am := range alertmanagers {
-		if _, found := versionPresent[am.version]; found {
-			continue
-		}
-		versions = append(versions, am.version)
-	}
 	s := &Sender{
 		logger:        logger,
 		alertmanagers: alertmanagers,
-		versions:      versions,
 
 		sent: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
 			Name: "thanos_alert_sender_alerts_sent_total",
@@ -281,9 +273,30 @@ func NewSender(
 			Help: "Latency for sending alert notifications (not including dropped notifications).",
 		}, []string{"alertmanager"}),
 	}
+	s.discovered = promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "thanos_alert_sender_alertmanagers_discovered",
+		Help: "The number of Alertmanager endpoints alerts are sent to.",
+	}, func() float64 {
+		s.mtx.RLock()
+		defer s.mtx.RUnlock()
+
+		var n int
+		for _, am := range s.alertmanagers {
+			n += len(am.dispatcher.Endpoints())
+		}
+		return float64(n)
+	})
 	return s
 }
 
+// SetAlertmanagers replaces the Alertmanagers alerts are sent to, e.g. when the alerting configuration is reloaded.
+// Batches being sent are sent to the previous Alertmanagers.
+func (s *Sender) SetAlertmanagers(alertmanagers []*Alertmanager) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.alertmanagers = alertmanagers
+}
+
 func toAPILabels(labels labels.Labels) models.LabelSet {
 	apiLabels := make(models.LabelSet, len(labels))
 	for _, label := range labels {
@@ -300,8 +313,16 @@ func (s *Sender) Send(ctx context.Context, alerts []*notifier.Alert) {
 		return
 	}
 
+	s.mtx.RLock()
+	alertmanagers := s.alertmanagers
+	s.mtx.RUnlock()
+
 	payload := make(map[APIVersion][]byte)
-	for _, version := range s.versions {
+	for _, am := range alertmanagers {
+		version := am.version
+		if _, ok := payload[version]; ok {
+			continue
+		}
 		var (
 			b   []byte
 			err error
@@ -337,7 +358,7 @@ func (s *Sender) Send(ctx context.Context, alerts []*notifier.Alert) {
 		wg         sync.WaitGroup
 		numSuccess atomic.Uint64
 	)
-	for _, am := range s.alertmanagers {
+	for _, am := range alertmanagers {
 		for _, u := range am.dispatcher.Endpoints() {
 			wg.Add(1)
 			go func(am *Alertmanager, u url.URL) {
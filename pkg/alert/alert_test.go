@@ -215,3 +215,30 @@ func TestSenderSendsAllFail(t *testing.T) {
 	testutil.Equals(t, 1, int(promtestutil.ToFloat64(s.errs.WithLabelValues(poster.urls[1].Host))))
 	testutil.Equals(t, 2, int(promtestutil.ToFloat64(s.dropped)))
 }
+
+func TestSenderSetAlertmanagers(t *testing.T) {
+	v1 := &fakeClient{urls: []*url.URL{{Host: "am1:9090"}}}
+	v2 := &fakeClient{urls: []*url.URL{{Host: "am2:9090"}, {Host: "am3:9090"}}}
+	s := NewSender(nil, nil, []*Alertmanager{NewAlertmanager(nil, v1, time.Minute, APIv1)})
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(s.discovered))
+
+	s.SetAlertmanagers([]*Alertmanager{
+		NewAlertmanager(nil, v1, time.Minute, APIv1),
+		NewAlertmanager(nil, v2, time.Minute, APIv2),
+	})
+	testutil.Equals(t, 3.0, promtestutil.ToFloat64(s.discovered))
+
+	s.Send(context.Background(), []*notifier.Alert{{}})
+	testutil.Equals(t, 1, len(v1.seen))
+	testutil.Equals(t, "/api/v1/alerts", v1.seen[0].Path)
+	assertSameHosts(t, v2.urls, v2.seen)
+	for _, u := range v2.seen {
+		testutil.Equals(t, "/api/v2/alerts", u.Path)
+	}
+
+	s.SetAlertmanagers(nil)
+	testutil.Equals(t, 0.0, promtestutil.ToFloat64(s.discovered))
+	s.Send(context.Background(), []*notifier.Alert{{}})
+	testutil.Equals(t, 1, len(v1.seen))
+	testutil.Equals(t, 1, int(promtestutil.ToFloat64(s.dropped)))
+}
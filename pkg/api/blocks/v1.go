@@ -5,6 +5,7 @@ package v1
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-kit/log"
@@ -13,12 +14,14 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/route"
 	"github.com/thanos-io/objstore"
 
 	"github.com/thanos-io/thanos/pkg/api"
 	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact"
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
 	"github.com/thanos-io/thanos/pkg/logging"
 )
@@ -40,6 +43,13 @@ type BlocksInfo struct {
 	Err         error           `json:"err"`
 }
 
+// SimulationResult is the result of a simulated compactor iteration on the global blocks.
+type SimulationResult struct {
+	Actions     []compact.SimulatedAction `json:"actions"`
+	SimulatedAt time.Time                 `json:"simulatedAt"`
+	RefreshedAt time.Time                 `json:"refreshedAt"`
+}
+
 type ActionType int32
 
 const (
@@ -84,6 +94,7 @@ func (bapi *BlocksAPI) Register(r *route.Router, tracer opentracing.Tracer, logg
 
 	r.Get("/blocks", instr("blocks", bapi.blocks))
 	r.Post("/blocks/mark", instr("blocks_mark", bapi.markBlock))
+	r.Get("/blocks/simulate", instr("blocks_simulate", bapi.simulate))
 }
 
 func (bapi *BlocksAPI) markBlock(r *http.Request) (interface{}, []error, *api.ApiError, func()) {
@@ -130,6 +141,95 @@ func (bapi *BlocksAPI) blocks(r *http.Request) (interface{}, []error, *api.ApiEr
 	return bapi.globalBlocksInfo, nil, nil, func() {}
 }
 
+// simulate returns the actions a compactor configured with the flags given as parameters would take on the global blocks.
+// Parameters not given default to the defaults of the compactor flags.
+func (bapi *BlocksAPI) simulate(r *http.Request) (interface{}, []error, *api.ApiError, func()) {
+	conf, err := parseSimulationConfig(r)
+	if err != nil {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
+	}
+
+	now := bapi.baseAPI.Now()
+	actions, err := compact.Simulate(r.Context(), bapi.logger, bapi.globalBlocksInfo.Blocks, conf, now)
+	if err != nil {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
+	}
+	if actions == nil {
+		actions = []compact.SimulatedAction{}
+	}
+	return &SimulationResult{Actions: actions, SimulatedAt: now, RefreshedAt: bapi.globalBlocksInfo.RefreshedAt}, nil, nil, func() {}
+}
+
+func parseSimulationConfig(r *http.Request) (compact.SimulationConfig, error) {
+	conf := compact.SimulationConfig{
+		RetentionByResolution: map[compact.ResolutionLevel]time.Duration{},
+	}
+
+	ranges := compact.DefaultRanges
+	if rangeParams := r.URL.Query()["compact.level-range"]; len(rangeParams) > 0 {
+		ranges = make([]time.Duration, 0, len(rangeParams))
+		for _, p := range rangeParams {
+			d, err := model.ParseDuration(p)
+			if err != nil {
+				return conf, errors.Wrapf(err, "parse compact.level-range %q", p)
+			}
+			ranges = append(ranges, time.Duration(d))
+		}
+	}
+	if p := r.URL.Query().Get("debug.max-compaction-level"); p != "" {
+		maxLevel, err := strconv.Atoi(p)
+		if err != nil {
+			return conf, errors.Wrapf(err, "parse debug.max-compaction-level %q", p)
+		}
+		if maxLevel >= len(ranges) {
+			return conf, errors.Errorf("debug.max-compaction-level %d is bigger than the highest configured level %d", maxLevel, len(ranges)-1)
+		}
+		if maxLevel >= 0 {
+			ranges = ranges[:maxLevel+1]
+		}
+	}
+	for _, d := range ranges {
+		conf.Ranges = append(conf.Ranges, d.Milliseconds())
+	}
+
+	for name, resolution := range map[string]compact.ResolutionLevel{
+		"retention.resolution-raw": compact.ResolutionLevelRaw,
+		"retention.resolution-5m":  compact.ResolutionLevel5m,
+		"retention.resolution-1h":  compact.ResolutionLevel1h,
+	} {
+		p := r.URL.Query().Get(name)
+		if p == "" {
+			continue
+		}
+		d, err := model.ParseDuration(p)
+		if err != nil {
+			return conf, errors.Wrapf(err, "parse %s %q", name, p)
+		}
+		conf.RetentionByResolution[resolution] = time.Duration(d)
+	}
+
+	var err error
+	if conf.DisableDownsampling, err = parseBoolParam(r, "downsampling.disable"); err != nil {
+		return conf, err
+	}
+	if conf.EnableVerticalCompaction, err = parseBoolParam(r, "compact.enable-vertical-compaction"); err != nil {
+		return conf, err
+	}
+	return conf, nil
+}
+
+func parseBoolParam(r *http.Request, name string) (bool, error) {
+	p := r.URL.Query().Get(name)
+	if p == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(p)
+	if err != nil {
+		return false, errors.Wrapf(err, "parse %s %q", name, p)
+	}
+	return b, nil
+}
+
 func (b *BlocksInfo) set(blocks []metadata.Meta, err error) {
 	if err != nil {
 		// Last view is maintained.
@@ -20,12 +20,14 @@ import (
 	"github.com/oklog/ulid"
 	"github.com/prometheus/common/route"
 	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
 	"github.com/thanos-io/objstore"
 
 	"github.com/efficientgo/core/testutil"
 	baseAPI "github.com/thanos-io/thanos/pkg/api"
 	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact"
 	"github.com/thanos-io/thanos/pkg/testutil/custom"
 	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
 )
@@ -186,3 +188,104 @@ func TestMarkBlockEndpoint(t *testing.T) {
 	_, err = os.Stat(file)
 	testutil.Ok(t, err)
 }
+
+func TestSimulateEndpoint(t *testing.T) {
+	h := time.Hour.Milliseconds()
+	meta := func(id uint64, mint, maxt int64) metadata.Meta {
+		u := ulid.MustNew(id, nil)
+		return metadata.Meta{
+			BlockMeta: tsdb.BlockMeta{
+				ULID:       u,
+				MinTime:    mint,
+				MaxTime:    maxt,
+				Compaction: tsdb.BlockMetaCompaction{Level: 1, Sources: []ulid.ULID{u}},
+			},
+			Thanos: metadata.Thanos{Labels: map[string]string{"a": "1"}},
+		}
+	}
+
+	now := time.Unix(0, 0).Add(24 * time.Hour)
+	refreshedAt := now.Add(-time.Minute)
+	api := &BlocksAPI{
+		baseAPI: &baseAPI.BaseAPI{
+			Now: func() time.Time { return now },
+		},
+		logger: log.NewNopLogger(),
+		globalBlocksInfo: &BlocksInfo{
+			Blocks:      []metadata.Meta{meta(1, 0, 2*h), meta(2, 2*h, 4*h), meta(3, 4*h, 6*h)},
+			Label:       "foo",
+			RefreshedAt: refreshedAt,
+		},
+		loadedBlocksInfo: &BlocksInfo{
+			Blocks: []metadata.Meta{},
+			Label:  "foo",
+		},
+		disableCORS: true,
+	}
+
+	var tests = []endpointTestCase{
+		// Invalid range.
+		{
+			endpoint: api.simulate,
+			query: url.Values{
+				"compact.level-range": []string{"2h", "3h"},
+			},
+			errType: baseAPI.ErrorBadData,
+		},
+		// Max compaction level bigger than the configured levels.
+		{
+			endpoint: api.simulate,
+			query: url.Values{
+				"compact.level-range":        []string{"2h", "4h"},
+				"debug.max-compaction-level": []string{"2"},
+			},
+			errType: baseAPI.ErrorBadData,
+		},
+		// Invalid retention.
+		{
+			endpoint: api.simulate,
+			query: url.Values{
+				"retention.resolution-raw": []string{"1x"},
+			},
+			errType: baseAPI.ErrorBadData,
+		},
+		// Invalid boolean.
+		{
+			endpoint: api.simulate,
+			query: url.Values{
+				"downsampling.disable": []string{"maybe"},
+			},
+			errType: baseAPI.ErrorBadData,
+		},
+		// Nothing to compact with the default ranges and the last block excluded.
+		{
+			endpoint: api.simulate,
+			response: &SimulationResult{
+				Actions:     []compact.SimulatedAction{},
+				SimulatedAt: now,
+				RefreshedAt: refreshedAt,
+			},
+		},
+		// The first two blocks fill a 4h range, the result and the last block exceed the retention.
+		{
+			endpoint: api.simulate,
+			query: url.Values{
+				"compact.level-range":      []string{"2h", "4h"},
+				"retention.resolution-raw": []string{"1h"},
+			},
+			response: 3,
+		},
+	}
+
+	for i, test := range tests {
+		compare := reflect.DeepEqual
+		if n, ok := test.response.(int); ok {
+			compare = func(got, _ interface{}) bool {
+				return len(got.(*SimulationResult).Actions) == n
+			}
+		}
+		if ok := testEndpoint(t, test, fmt.Sprintf("#%d %s", i, test.query.Encode()), compare); !ok {
+			return
+		}
+	}
+}
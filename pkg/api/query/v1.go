@@ -46,6 +46,7 @@ import (
 	promqlapi "github.com/thanos-community/promql-engine/api"
 	"github.com/thanos-community/promql-engine/engine"
 	"github.com/thanos-io/thanos/pkg/api"
+	"github.com/thanos-io/thanos/pkg/audit"
 	"github.com/thanos-io/thanos/pkg/exemplars"
 	"github.com/thanos-io/thanos/pkg/exemplars/exemplarspb"
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
@@ -147,7 +148,7 @@ type QueryAPI struct {
 	enableTargetPartialResponse         bool
 	enableMetricMetadataPartialResponse bool
 	enableExemplarPartialResponse       bool
-	enableQueryPushdown                 bool
+	enableQueryPushdown                 func() bool
 	disableCORS                         bool
 
 	replicaLabels  []string
@@ -160,6 +161,8 @@ type QueryAPI struct {
 	queryRangeHist prometheus.Histogram
 
 	seriesStatsAggregator seriesQueryPerformanceMetricsAggregator
+
+	auditLog *audit.Logger
 }
 
 type seriesQueryPerformanceMetricsAggregator interface {
@@ -185,7 +188,7 @@ func NewQueryAPI(
 	enableTargetPartialResponse bool,
 	enableMetricMetadataPartialResponse bool,
 	enableExemplarPartialResponse bool,
-	enableQueryPushdown bool,
+	enableQueryPushdown func() bool,
 	replicaLabels []string,
 	flagsMap map[string]string,
 	defaultRangeQueryStep time.Duration,
@@ -194,6 +197,7 @@ func NewQueryAPI(
 	disableCORS bool,
 	gate gate.Gate,
 	statsAggregator seriesQueryPerformanceMetricsAggregator,
+	auditLog *audit.Logger,
 	reg *prometheus.Registry,
 ) *QueryAPI {
 	if statsAggregator == nil {
@@ -225,6 +229,7 @@ func NewQueryAPI(
 		defaultMetadataTimeRange:               defaultMetadataTimeRange,
 		disableCORS:                            disableCORS,
 		seriesStatsAggregator:                  statsAggregator,
+		auditLog:                               auditLog,
 
 		queryRangeHist: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
 			Name:    "thanos_query_range_requested_timespan_duration_seconds",
@@ -234,17 +239,68 @@ func NewQueryAPI(
 	}
 }
 
+type auditEntryKey struct{}
+
+// audited records the queries of the endpoint in the audit log, if any. The time range and statistics of the query
+// are recorded by the endpoint in the entry of the request context.
+func (qapi *QueryAPI) audited(endpoint string, f api.ApiFunc) api.ApiFunc {
+	if qapi.auditLog == nil {
+		return f
+	}
+	return func(r *http.Request) (interface{}, []error, *api.ApiError, func()) {
+		entry := qapi.auditLog.NewEntry(r, endpoint)
+		data, warnings, apiErr, releaseResources := f(r.WithContext(context.WithValue(r.Context(), auditEntryKey{}, entry)))
+
+		entry.DurationSeconds = time.Since(entry.Time).Seconds()
+		entry.Warnings = len(warnings)
+		entry.Status = audit.StatusSuccess
+		if apiErr != nil {
+			entry.Status = audit.StatusError
+			entry.ErrorType = string(apiErr.Typ)
+			entry.Error = apiErr.Err.Error()
+		}
+		if err := qapi.auditLog.Log(r.Context(), entry); err != nil {
+			// The audit log is configured to block on a full queue, so no result is returned without being audited.
+			if releaseResources != nil {
+				releaseResources()
+			}
+			return nil, nil, &api.ApiError{Typ: api.ErrorInternal, Err: errors.Wrap(err, "audit query")}, func() {}
+		}
+		return data, warnings, apiErr, releaseResources
+	}
+}
+
+// auditQuery records the time range and the statistics of the series fetched by the query in its audit log entry, if
+// any.
+func auditQuery(ctx context.Context, start, end time.Time, step time.Duration, seriesStats []storepb.SeriesStatsCounter) {
+	entry, ok := ctx.Value(auditEntryKey{}).(*audit.Entry)
+	if !ok {
+		return
+	}
+	entry.Start, entry.End, entry.Step = &start, &end, step.Seconds()
+	for _, s := range seriesStats {
+		entry.Series += s.Series
+		entry.Chunks += s.Chunks
+		entry.Samples += s.Samples
+	}
+}
+
+// queryPushdownEnabled returns whether the query pushdown is currently enabled.
+func (qapi *QueryAPI) queryPushdownEnabled() bool {
+	return qapi.enableQueryPushdown != nil && qapi.enableQueryPushdown()
+}
+
 // Register the API's endpoints in the given router.
 func (qapi *QueryAPI) Register(r *route.Router, tracer opentracing.Tracer, logger log.Logger, ins extpromhttp.InstrumentationMiddleware, logMiddleware *logging.HTTPServerMiddleware) {
 	qapi.baseAPI.Register(r, tracer, logger, ins, logMiddleware)
 
 	instr := api.GetInstr(tracer, logger, ins, logMiddleware, qapi.disableCORS)
 
-	r.Get("/query", instr("query", qapi.query))
-	r.Post("/query", instr("query", qapi.query))
+	r.Get("/query", instr("query", qapi.audited("query", qapi.query)))
+	r.Post("/query", instr("query", qapi.audited("query", qapi.query)))
 
-	r.Get("/query_range", instr("query_range", qapi.queryRange))
-	r.Post("/query_range", instr("query_range", qapi.queryRange))
+	r.Get("/query_range", instr("query_range", qapi.audited("query_range", qapi.queryRange)))
+	r.Post("/query_range", instr("query_range", qapi.audited("query_range", qapi.queryRange)))
 
 	r.Get("/label/:name/values", instr("label_values", qapi.labelValues))
 
@@ -494,7 +550,7 @@ func (qapi *QueryAPI) query(r *http.Request) (interface{}, []error, *api.ApiErro
 			storeDebugMatchers,
 			maxSourceResolution,
 			enablePartialResponse,
-			qapi.enableQueryPushdown,
+			qapi.queryPushdownEnabled(),
 			false,
 			shardInfo,
 			query.NewAggregateStatsReporter(&seriesStats),
@@ -518,6 +574,7 @@ func (qapi *QueryAPI) query(r *http.Request) (interface{}, []error, *api.ApiErro
 
 	beforeRange := time.Now()
 	res := qry.Exec(ctx)
+	auditQuery(ctx, ts, ts, 0, seriesStats)
 	if res.Err != nil {
 		switch res.Err.(type) {
 		case promql.ErrQueryCanceled:
@@ -650,7 +707,7 @@ func (qapi *QueryAPI) queryRange(r *http.Request) (interface{}, []error, *api.Ap
 			storeDebugMatchers,
 			maxSourceResolution,
 			enablePartialResponse,
-			qapi.enableQueryPushdown,
+			qapi.queryPushdownEnabled(),
 			false,
 			shardInfo,
 			query.NewAggregateStatsReporter(&seriesStats),
@@ -675,6 +732,7 @@ func (qapi *QueryAPI) queryRange(r *http.Request) (interface{}, []error, *api.Ap
 
 	beforeRange := time.Now()
 	res := qry.Exec(ctx)
+	auditQuery(ctx, start, end, step, seriesStats)
 	if res.Err != nil {
 		switch res.Err.(type) {
 		case promql.ErrQueryCanceled:
@@ -739,7 +797,7 @@ func (qapi *QueryAPI) labelValues(r *http.Request) (interface{}, []error, *api.A
 		storeDebugMatchers,
 		0,
 		enablePartialResponse,
-		qapi.enableQueryPushdown,
+		qapi.queryPushdownEnabled(),
 		true,
 		nil,
 		query.NoopSeriesStatsReporter,
@@ -835,7 +893,7 @@ func (qapi *QueryAPI) series(r *http.Request) (interface{}, []error, *api.ApiErr
 		storeDebugMatchers,
 		math.MaxInt64,
 		enablePartialResponse,
-		qapi.enableQueryPushdown,
+		qapi.queryPushdownEnabled(),
 		true,
 		nil,
 		query.NoopSeriesStatsReporter,
@@ -895,7 +953,7 @@ func (qapi *QueryAPI) labelNames(r *http.Request) (interface{}, []error, *api.Ap
 		storeDebugMatchers,
 		0,
 		enablePartialResponse,
-		qapi.enableQueryPushdown,
+		qapi.queryPushdownEnabled(),
 		true,
 		nil,
 		query.NoopSeriesStatsReporter,
@@ -25,6 +25,8 @@ import (
 	"math/rand"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -48,11 +50,13 @@ import (
 	promgate "github.com/prometheus/prometheus/util/gate"
 	"github.com/prometheus/prometheus/util/stats"
 	baseAPI "github.com/thanos-io/thanos/pkg/api"
+	"github.com/thanos-io/thanos/pkg/audit"
 	"github.com/thanos-io/thanos/pkg/compact"
 	"github.com/thanos-io/thanos/pkg/component"
 	"github.com/thanos-io/thanos/pkg/gate"
 	"github.com/thanos-io/thanos/pkg/query"
 	"github.com/thanos-io/thanos/pkg/rules/rulespb"
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 	"github.com/thanos-io/thanos/pkg/store"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
@@ -646,6 +650,102 @@ func newProxyStoreWithTSDBStore(db store.TSDBReader) *store.ProxyStore {
 	)
 }
 
+func TestQueryAuditLog(t *testing.T) {
+	db, err := e2eutil.NewTSDB()
+	defer func() { testutil.Ok(t, db.Close()) }()
+	testutil.Ok(t, err)
+
+	app := db.Appender(context.Background())
+	for _, lbl := range []labels.Labels{
+		labels.FromStrings("__name__", "test_metric", "foo", "bar"),
+		labels.FromStrings("__name__", "test_metric", "foo", "boo"),
+	} {
+		for i := int64(0); i < 10; i++ {
+			_, err := app.Append(0, lbl, i*60000, float64(i))
+			testutil.Ok(t, err)
+		}
+	}
+	testutil.Ok(t, app.Commit())
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := audit.NewLogger(log.NewNopLogger(), prometheus.NewRegistry(), []byte("file: "+path), "THANOS-TENANT")
+	testutil.Ok(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		auditLog.Run(ctx)
+		close(done)
+	}()
+
+	api := &QueryAPI{
+		baseAPI: &baseAPI.BaseAPI{
+			Now: time.Now,
+		},
+		queryableCreate: query.NewQueryableCreator(nil, nil, newProxyStoreWithTSDBStore(db), 2, time.Minute),
+		engineFactory: QueryEngineFactory{
+			engineOpts: promql.EngineOpts{MaxSamples: 10000, Timeout: time.Minute},
+		},
+		defaultEngine:         PromqlEnginePrometheus,
+		lookbackDeltaCreate:   func(m int64) time.Duration { return time.Duration(0) },
+		gate:                  gate.New(nil, 4, gate.Queries),
+		defaultRangeQueryStep: time.Second,
+		queryRangeHist: promauto.With(prometheus.NewRegistry()).NewHistogram(prometheus.HistogramOpts{
+			Name: "query_range_hist",
+		}),
+		seriesStatsAggregator: &store.NoopSeriesStatsAggregator{},
+		auditLog:              auditLog,
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/api/v1/query_range?"+url.Values{
+		"query": []string{"test_metric"},
+		"start": []string{"0"},
+		"end":   []string{"540"},
+		"step":  []string{"60"},
+	}.Encode(), nil)
+	testutil.Ok(t, err)
+	req.RemoteAddr = "10.0.0.1:12345"
+	req.Header.Set("THANOS-TENANT", "team-a")
+	req = req.WithContext(middleware.NewContextWithUser(context.Background(), "alice"))
+	_, _, apiErr, release := api.audited("query_range", api.queryRange)(req)
+	testutil.Assert(t, apiErr == nil, "unexpected error %v", apiErr)
+	release()
+
+	req, err = http.NewRequest(http.MethodGet, "/api/v1/query?query=sum(", nil)
+	testutil.Ok(t, err)
+	_, _, apiErr, release = api.audited("query", api.query)(req)
+	testutil.Assert(t, apiErr != nil, "expected error")
+	release()
+
+	cancel()
+	<-done
+
+	content, err := os.ReadFile(path)
+	testutil.Ok(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	testutil.Equals(t, 2, len(lines))
+
+	var entry audit.Entry
+	testutil.Ok(t, json.Unmarshal([]byte(lines[0]), &entry))
+	testutil.Equals(t, "query_range", entry.Endpoint)
+	testutil.Equals(t, "test_metric", entry.Query)
+	testutil.Equals(t, "team-a", entry.Tenant)
+	testutil.Equals(t, "alice", entry.User)
+	testutil.Equals(t, "10.0.0.1", entry.RemoteAddr)
+	testutil.Equals(t, int64(540), entry.End.Unix()-entry.Start.Unix())
+	testutil.Equals(t, 60.0, entry.Step)
+	testutil.Equals(t, audit.StatusSuccess, entry.Status)
+	testutil.Equals(t, 2, entry.Series)
+	testutil.Equals(t, 20, entry.Samples)
+
+	entry = audit.Entry{}
+	testutil.Ok(t, json.Unmarshal([]byte(lines[1]), &entry))
+	testutil.Equals(t, "query", entry.Endpoint)
+	testutil.Equals(t, "sum(", entry.Query)
+	testutil.Equals(t, audit.StatusError, entry.Status)
+	testutil.Equals(t, string(baseAPI.ErrorBadData), entry.ErrorType)
+	testutil.Assert(t, entry.Start == nil, "unexpected time range of query which failed to parse")
+}
+
 func TestMetadataEndpoints(t *testing.T) {
 	var old = []labels.Labels{
 		{
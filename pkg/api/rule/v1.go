@@ -4,10 +4,13 @@
 package v1
 
 import (
+	"bytes"
+	"io"
 	"net/http"
 
 	"github.com/go-kit/log"
 	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/route"
 
@@ -61,4 +64,27 @@ func (rapi *RuleAPI) Register(r *route.Router, tracer opentracing.Tracer, logger
 		return struct{ Alerts []*rulespb.AlertInstance }{Alerts: rapi.alerts.Active()}, nil, nil, func() {}
 	}))
 	r.Get("/rules", instr("rules", qapi.NewRulesHandler(rapi.ruleGroups, false)))
+	r.Post("/rules/validate", instr("rules_validate", rapi.validateRules))
+}
+
+// validateRules validates the rule file in the request body the same way the ruler does when loading it. With the
+// tenant parameter, the rule file is validated as a rule file of the tenant in the rule files bucket.
+func (rapi *RuleAPI) validateRules(r *http.Request) (interface{}, []error, *api.ApiError, func()) {
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: errors.Wrap(err, "read rule file")}, func() {}
+	}
+
+	numRules, errs := rules.ValidateAndCount(bytes.NewReader(content))
+	if errs.Err() != nil {
+		return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: errs.Err()}, func() {}
+	}
+	if tenant := r.URL.Query().Get("tenant"); tenant != "" {
+		if _, err := rules.SetRuleFileTenant(content, tenant); err != nil {
+			return nil, nil, &api.ApiError{Typ: api.ErrorBadData, Err: err}, func() {}
+		}
+	}
+	return struct {
+		Rules int `json:"rules"`
+	}{Rules: numRules}, nil, nil, func() {}
 }
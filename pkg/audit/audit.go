@@ -0,0 +1,362 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package audit implements the audit log of queries, recording who queried which data, to a file or a remote HTTP
+// endpoint.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/jpillora/backoff"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/httpconfig"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
+)
+
+const (
+	// StatusSuccess is the status of queries which succeeded.
+	StatusSuccess = "success"
+	// StatusError is the status of queries which failed.
+	StatusError = "error"
+)
+
+// Config configures the audit log. Entries are written either to a file or to an HTTP endpoint.
+type Config struct {
+	// File is the path of the file entries are appended to, one JSON object per line.
+	File string `yaml:"file"`
+	// URL is the HTTP endpoint batches of entries are POSTed to, as newline delimited JSON objects.
+	URL string `yaml:"url"`
+	// QueueSize is the maximum number of entries waiting to be written. Once it is reached, new entries are dropped,
+	// unless BlockOnFullQueue is set.
+	QueueSize int `yaml:"queue_size"`
+	// BlockOnFullQueue makes queries wait for room in the queue instead of dropping their entries. Queries fail if
+	// their entry can't be queued before they are canceled, so that no query result is returned without being audited.
+	BlockOnFullQueue bool `yaml:"block_on_full_queue"`
+	// BatchSize is the maximum number of entries written at once.
+	BatchSize int `yaml:"batch_size"`
+	// FlushInterval is the maximum time an entry waits for its batch to be full before it is written.
+	FlushInterval model.Duration `yaml:"flush_interval"`
+	// Timeout is the timeout of a single HTTP request.
+	Timeout model.Duration `yaml:"timeout"`
+	// MaxRetries is the maximum number of retries of a batch which failed to be written. 0 disables retries.
+	MaxRetries int `yaml:"max_retries"`
+	// MinBackoff and MaxBackoff bound the exponential backoff between retries.
+	MinBackoff model.Duration `yaml:"min_backoff"`
+	MaxBackoff model.Duration `yaml:"max_backoff"`
+	// HTTPClientConfig configures the HTTP client used to send entries.
+	HTTPClientConfig httpconfig.ClientConfig `yaml:"http_client_config"`
+}
+
+// DefaultConfig returns the audit log configuration with the default values set.
+func DefaultConfig() Config {
+	return Config{
+		QueueSize:        10000,
+		BatchSize:        100,
+		FlushInterval:    model.Duration(5 * time.Second),
+		Timeout:          model.Duration(30 * time.Second),
+		MaxRetries:       3,
+		MinBackoff:       model.Duration(500 * time.Millisecond),
+		MaxBackoff:       model.Duration(10 * time.Second),
+		HTTPClientConfig: httpconfig.NewDefaultClientConfig(),
+	}
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultConfig()
+	type plain Config
+	return unmarshal((*plain)(c))
+}
+
+// ParseConfig parses and validates the YAML audit log configuration.
+func ParseConfig(content []byte) (Config, error) {
+	conf := DefaultConfig()
+	if err := yaml.UnmarshalStrict(content, &conf); err != nil {
+		return Config{}, errors.Wrap(err, "parsing audit log config YAML")
+	}
+	if (conf.File == "") == (conf.URL == "") {
+		return Config{}, errors.New("exactly one of file and url has to be set in the audit log config")
+	}
+	if conf.URL != "" {
+		if u, err := url.Parse(conf.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			return Config{}, errors.Errorf("invalid audit log URL %q", conf.URL)
+		}
+	}
+	if conf.QueueSize <= 0 || conf.BatchSize <= 0 || conf.FlushInterval <= 0 {
+		return Config{}, errors.New("queue_size, batch_size and flush_interval of the audit log config must be positive")
+	}
+	if conf.MaxRetries < 0 {
+		return Config{}, errors.New("max_retries of the audit log config must not be negative")
+	}
+	if conf.MinBackoff > conf.MaxBackoff {
+		return Config{}, errors.New("min_backoff of the audit log config must not be greater than max_backoff")
+	}
+	return conf, nil
+}
+
+// Entry is an audit log entry of a query.
+type Entry struct {
+	// Time is the time the query was received.
+	Time time.Time `json:"ts"`
+	// RequestID is the ID of the query request.
+	RequestID string `json:"request_id,omitempty"`
+	// Tenant is the tenant of the query, if any.
+	Tenant string `json:"tenant,omitempty"`
+	// User is the identity of the user authenticated by the HTTP server, if any.
+	User       string `json:"user,omitempty"`
+	RemoteAddr string `json:"remote_addr"`
+	// Endpoint is the name of the API endpoint, e.g. query or query_range.
+	Endpoint string `json:"endpoint"`
+	// Query is the PromQL expression of the query.
+	Query string `json:"query"`
+	// Start and End are the time range of the query. They are equal for instant queries, and unset for queries
+	// which failed before being executed.
+	Start *time.Time `json:"start,omitempty"`
+	End   *time.Time `json:"end,omitempty"`
+	// Step is the step of range queries in seconds.
+	Step float64 `json:"step,omitempty"`
+	// Status is the status of the query, either success or error.
+	Status string `json:"status"`
+	// ErrorType and Error are the type and message of the error of failed queries.
+	ErrorType string `json:"error_type,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// Warnings is the number of warnings of the query, e.g. about partial responses.
+	Warnings        int     `json:"warnings,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	// Series, Chunks and Samples are the numbers of series, chunks and samples fetched from stores by the query.
+	Series  int `json:"series_fetched"`
+	Chunks  int `json:"chunks_fetched"`
+	Samples int `json:"samples_fetched"`
+}
+
+// Logger writes audit log entries. Entries are queued and written in batches by Run, so that the audit log does not
+// slow down queries, unless the queue is full and BlockOnFullQueue is set.
+type Logger struct {
+	logger       log.Logger
+	conf         Config
+	tenantHeader string
+	queue        chan *Entry
+
+	file   *os.File
+	client *http.Client
+
+	written prometheus.Counter
+	retries prometheus.Counter
+	dropped *prometheus.CounterVec
+}
+
+// NewLogger creates a Logger with the given YAML configuration. The tenant of queries is read from the given header.
+// It has to be run with Run.
+func NewLogger(logger log.Logger, reg prometheus.Registerer, content []byte, tenantHeader string) (*Logger, error) {
+	conf, err := ParseConfig(content)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Logger{
+		logger:       logger,
+		conf:         conf,
+		tenantHeader: tenantHeader,
+		queue:        make(chan *Entry, conf.QueueSize),
+		written: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_audit_log_entries_written_total",
+			Help: "The number of query audit log entries written.",
+		}),
+		retries: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_audit_log_batch_retries_total",
+			Help: "The number of retries of query audit log batches which failed to be written.",
+		}),
+		dropped: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_audit_log_entries_dropped_total",
+			Help: "The number of query audit log entries that could not be written.",
+		}, []string{"reason"}),
+	}
+	if conf.File != "" {
+		l.file, err = os.OpenFile(conf.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+		if err != nil {
+			return nil, errors.Wrap(err, "open audit log file")
+		}
+		return l, nil
+	}
+	l.client, err = httpconfig.NewHTTPClient(conf.HTTPClientConfig, "audit-log")
+	if err != nil {
+		return nil, errors.Wrap(err, "create HTTP client of audit log")
+	}
+	l.client.Timeout = time.Duration(conf.Timeout)
+	return l, nil
+}
+
+// NewEntry returns an entry of the query of the given request, with the request's ID, tenant, user and remote address.
+func (l *Logger) NewEntry(r *http.Request, endpoint string) *Entry {
+	e := &Entry{
+		Time:       time.Now(),
+		Tenant:     r.Header.Get(l.tenantHeader),
+		RemoteAddr: r.RemoteAddr,
+		Endpoint:   endpoint,
+		Query:      r.FormValue("query"),
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		e.RemoteAddr = host
+	}
+	e.RequestID, _ = middleware.RequestIDFromContext(r.Context())
+	e.User, _ = middleware.UserFromContext(r.Context())
+	return e
+}
+
+// Log queues the entry to be written. It is a no-op on a nil Logger. The entry must not be modified afterwards.
+// If the queue is full, the entry is dropped, or, if BlockOnFullQueue is set, Log waits for room in the queue and
+// returns an error if the context is done first.
+func (l *Logger) Log(ctx context.Context, e *Entry) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.queue <- e:
+		return nil
+	default:
+	}
+	if !l.conf.BlockOnFullQueue {
+		l.dropped.WithLabelValues("queue_full").Inc()
+		return nil
+	}
+	select {
+	case l.queue <- e:
+		return nil
+	case <-ctx.Done():
+		l.dropped.WithLabelValues("queue_full").Inc()
+		return errors.Wrap(ctx.Err(), "wait for room in the audit log queue")
+	}
+}
+
+// Run writes the queued entries until the context is done. The entries queued by then are written before it returns.
+func (l *Logger) Run(ctx context.Context) {
+	if l.file != nil {
+		defer runutil.CloseWithLogOnErr(l.logger, l.file, "audit log file")
+	}
+
+	ticker := time.NewTicker(time.Duration(l.conf.FlushInterval))
+	defer ticker.Stop()
+
+	batch := make([]*Entry, 0, l.conf.BatchSize)
+	// Batches are written regardless of the context, so that the entries queued until it is done are not lost. HTTP
+	// requests are bounded by the timeout of the client, and retries by their maximum number.
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := l.writeWithRetries(batch); err != nil {
+			level.Warn(l.logger).Log("msg", "failed to write query audit log entries", "entries", len(batch), "err", err)
+			l.dropped.WithLabelValues("failed").Add(float64(len(batch)))
+		} else {
+			l.written.Add(float64(len(batch)))
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case e := <-l.queue:
+					batch = append(batch, e)
+					if len(batch) == l.conf.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		case e := <-l.queue:
+			batch = append(batch, e)
+			if len(batch) == l.conf.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// writeWithRetries writes the batch, retrying with exponential backoff as long as it fails with a retryable error.
+func (l *Logger) writeWithRetries(batch []*Entry) error {
+	b := &backoff.Backoff{
+		Min: time.Duration(l.conf.MinBackoff),
+		Max: time.Duration(l.conf.MaxBackoff),
+	}
+	for {
+		err := l.write(batch)
+		if err == nil || !isRetryable(err) || int(b.Attempt()) >= l.conf.MaxRetries {
+			return err
+		}
+		d := b.Duration()
+		level.Debug(l.logger).Log("msg", "retrying to write query audit log entries", "entries", len(batch), "backoff", d, "err", err)
+		l.retries.Inc()
+		time.Sleep(d)
+	}
+}
+
+// statusError is the error of an HTTP request which the server answered with a non-2xx status code.
+type statusError struct {
+	code int
+	err  error
+}
+
+func (e statusError) Error() string { return e.err.Error() }
+
+// isRetryable returns whether writing a batch which failed with the error may succeed when retried. Requests rejected
+// by the server are retried only if the server is overloaded or failed.
+func isRetryable(err error) bool {
+	var se statusError
+	if errors.As(err, &se) {
+		return se.code == http.StatusTooManyRequests || se.code/100 == 5
+	}
+	return true
+}
+
+func (l *Logger) write(batch []*Entry) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range batch {
+		if err := enc.Encode(e); err != nil {
+			return errors.Wrap(err, "encode entry")
+		}
+	}
+	if l.file != nil {
+		_, err := l.file.Write(buf.Bytes())
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, l.conf.URL, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer runutil.ExhaustCloseWithLogOnErr(l.logger, resp.Body, "audit log response body")
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return statusError{code: resp.StatusCode, err: errors.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(body))}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
+)
+
+func TestParseConfig(t *testing.T) {
+	conf, err := ParseConfig([]byte(`
+url: http://audit.example.com/entries
+batch_size: 10
+`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, "http://audit.example.com/entries", conf.URL)
+	testutil.Equals(t, 10, conf.BatchSize)
+	testutil.Equals(t, DefaultConfig().QueueSize, conf.QueueSize)
+
+	for _, content := range []string{
+		``,
+		`{file: /tmp/audit.log, url: "http://audit.example.com"}`,
+		`url: audit.example.com`,
+		`{file: /tmp/audit.log, queue_size: 0}`,
+		`{file: /tmp/audit.log, unknown: true}`,
+		`{file: /tmp/audit.log, max_retries: -1}`,
+		`{file: /tmp/audit.log, min_backoff: 1m, max_backoff: 1s}`,
+	} {
+		_, err := ParseConfig([]byte(content))
+		testutil.NotOk(t, err, content)
+	}
+}
+
+func TestLogger_HTTP(t *testing.T) {
+	var (
+		mtx      sync.Mutex
+		entries  []Entry
+		requests int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		requests++
+		testutil.Equals(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+		sc := bufio.NewScanner(r.Body)
+		for sc.Scan() {
+			var e Entry
+			testutil.Ok(t, json.Unmarshal(sc.Bytes(), &e))
+			entries = append(entries, e)
+		}
+	}))
+	defer srv.Close()
+
+	reg := prometheus.NewRegistry()
+	l, err := NewLogger(log.NewNopLogger(), reg, []byte("{url: "+srv.URL+", batch_size: 2, flush_interval: 1h}"), "X-Scope-OrgID")
+	testutil.Ok(t, err)
+
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/query?query=up", nil)
+		r.Header.Set("X-Scope-OrgID", "team-a")
+		r = r.WithContext(middleware.NewContextWithRequestID(middleware.NewContextWithUser(r.Context(), "alice"), "id"))
+		e := l.NewEntry(r, "query")
+		e.Status = StatusSuccess
+		testutil.Ok(t, l.Log(context.Background(), e))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// The queued entries are written on shutdown, two in a first batch and one in a second one.
+	l.Run(ctx)
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	testutil.Equals(t, 2, requests)
+	testutil.Equals(t, 3, len(entries))
+	for _, e := range entries {
+		testutil.Equals(t, "team-a", e.Tenant)
+		testutil.Equals(t, "alice", e.User)
+		testutil.Equals(t, "id", e.RequestID)
+		testutil.Equals(t, "up", e.Query)
+		testutil.Equals(t, "192.0.2.1", e.RemoteAddr)
+	}
+	testutil.Equals(t, 3.0, promtestutil.ToFloat64(l.written))
+}
+
+func TestLogger_HTTPRetries(t *testing.T) {
+	var (
+		mtx      sync.Mutex
+		statuses = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK, http.StatusBadRequest}
+		requests int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		w.WriteHeader(statuses[requests])
+		requests++
+	}))
+	defer srv.Close()
+
+	l, err := NewLogger(log.NewNopLogger(), prometheus.NewRegistry(), []byte("{url: "+srv.URL+", batch_size: 1, min_backoff: 1ms, max_backoff: 1ms}"), "")
+	testutil.Ok(t, err)
+	testutil.Ok(t, l.Log(context.Background(), &Entry{}))
+	testutil.Ok(t, l.Log(context.Background(), &Entry{}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// The first batch is written after two retries, the second one is not retried as it was rejected by the server.
+	l.Run(ctx)
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	testutil.Equals(t, 4, requests)
+	testutil.Equals(t, 2.0, promtestutil.ToFloat64(l.retries))
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(l.written))
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(l.dropped.WithLabelValues("failed")))
+}
+
+func TestLogger_QueueFull(t *testing.T) {
+	l, err := NewLogger(log.NewNopLogger(), prometheus.NewRegistry(), []byte("{file: "+t.TempDir()+"/audit.log, queue_size: 1}"), "")
+	testutil.Ok(t, err)
+
+	testutil.Ok(t, l.Log(context.Background(), &Entry{}))
+	testutil.Ok(t, l.Log(context.Background(), &Entry{}))
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(l.dropped.WithLabelValues("queue_full")))
+
+	// Logging to a nil Logger is a no-op.
+	var nilLogger *Logger
+	testutil.Ok(t, nilLogger.Log(context.Background(), &Entry{}))
+}
+
+func TestLogger_BlockOnFullQueue(t *testing.T) {
+	l, err := NewLogger(log.NewNopLogger(), prometheus.NewRegistry(), []byte("{file: "+t.TempDir()+"/audit.log, queue_size: 1, block_on_full_queue: true}"), "")
+	testutil.Ok(t, err)
+	testutil.Ok(t, l.Log(context.Background(), &Entry{}))
+
+	// The queue is full, so logging fails once the context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	testutil.NotOk(t, l.Log(ctx, &Entry{}))
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(l.dropped.WithLabelValues("queue_full")))
+
+	// Logging waits until the queue is drained.
+	logged := make(chan error, 1)
+	go func() { logged <- l.Log(context.Background(), &Entry{}) }()
+	<-l.queue
+	testutil.Ok(t, <-logged)
+}
@@ -0,0 +1,255 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+const (
+	// indexTOCLen is the length of the table of contents at the end of the index, which is 6 offsets and a checksum.
+	indexTOCLen = 6*8 + 4
+	// postingsOverheadBytes is the length and checksum of each postings list, in addition to the 4 bytes of each of
+	// its series references.
+	postingsOverheadBytes = 8
+)
+
+// postingsSizeBuckets are the upper bounds of the numbers of series of the buckets of the postings size
+// distribution.
+var postingsSizeBuckets = []int64{1, 10, 100, 1000, 10000, 100000, 1000000}
+
+// IndexAnalysis is the cardinality and size analysis of the index of a block.
+type IndexAnalysis struct {
+	ULID       ulid.ULID `json:"ulid"`
+	IndexBytes int64     `json:"index_bytes"`
+	Series     int64     `json:"series"`
+
+	// Sections are the sizes of the sections of the index, in the order of the index.
+	Sections []IndexSection `json:"sections"`
+	// LabelNames are the label names with the most series, by descending number of series.
+	LabelNames []LabelNameCardinality `json:"label_names"`
+	// LabelValues are the label name and value pairs with the most series, by descending number of series.
+	LabelValues []LabelValueCardinality `json:"label_values"`
+	// PostingsSizes is the distribution of the number of series of the postings lists of all label name and value
+	// pairs.
+	PostingsSizes []PostingsSizeBucket `json:"postings_sizes"`
+
+	// series are the hashes of the label sets of all series, to compute the churn with another block.
+	series map[uint64]struct{}
+}
+
+// IndexSection is the size of a section of the index.
+type IndexSection struct {
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+}
+
+// LabelNameCardinality is the number of series and values of a label name.
+type LabelNameCardinality struct {
+	Name   string `json:"name"`
+	Series int64  `json:"series"`
+	Values int64  `json:"values"`
+}
+
+// LabelValueCardinality is the number of series of a label name and value pair.
+type LabelValueCardinality struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Series int64  `json:"series"`
+}
+
+// PostingsSizeBucket is the number of postings lists with at most MaxSeries series, and more series than the
+// previous bucket. MaxSeries is 0 for the last bucket, which has no upper bound.
+type PostingsSizeBucket struct {
+	MaxSeries int64 `json:"max_series"`
+	Postings  int64 `json:"postings"`
+	Bytes     int64 `json:"bytes"`
+}
+
+// SeriesChurn is the number of series of a block not in the previous block, and the other way around.
+type SeriesChurn struct {
+	From    ulid.ULID `json:"from"`
+	To      ulid.ULID `json:"to"`
+	Added   int64     `json:"added"`
+	Removed int64     `json:"removed"`
+}
+
+// AnalyzeIndex returns the analysis of the index file fn of the block id, with the limit label names and label name
+// and value pairs with the most series.
+func AnalyzeIndex(fn string, id ulid.ULID, limit int) (_ *IndexAnalysis, err error) {
+	a := &IndexAnalysis{ULID: id, series: map[uint64]struct{}{}}
+
+	if a.Sections, a.IndexBytes, err = indexSections(fn); err != nil {
+		return nil, errors.Wrap(err, "read index sections")
+	}
+
+	r, err := index.NewFileReader(fn)
+	if err != nil {
+		return nil, errors.Wrap(err, "open index file")
+	}
+	defer runutil.CloseWithErrCapture(&err, r, "analyze index file reader")
+
+	p, err := r.Postings(index.AllPostingsKey())
+	if err != nil {
+		return nil, errors.Wrap(err, "get all postings")
+	}
+	var (
+		builder labels.ScratchBuilder
+		chks    []chunks.Meta
+	)
+	for p.Next() {
+		if err := r.Series(p.At(), &builder, &chks); err != nil {
+			return nil, errors.Wrap(err, "read series")
+		}
+		a.series[builder.Labels().Hash()] = struct{}{}
+	}
+	if p.Err() != nil {
+		return nil, errors.Wrap(p.Err(), "walk postings")
+	}
+	a.Series = int64(len(a.series))
+
+	names, err := r.LabelNames()
+	if err != nil {
+		return nil, errors.Wrap(err, "label names")
+	}
+
+	a.PostingsSizes = make([]PostingsSizeBucket, len(postingsSizeBuckets)+1)
+	for i, b := range postingsSizeBuckets {
+		a.PostingsSizes[i].MaxSeries = b
+	}
+	for _, name := range names {
+		values, err := r.LabelValues(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "label values of %s", name)
+		}
+		// Names and values are cloned, as they refer to the index file, which is unmapped once it is closed.
+		ln := LabelNameCardinality{Name: strings.Clone(name), Values: int64(len(values))}
+		for _, value := range values {
+			p, err := r.Postings(name, value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "postings of %s=%q", name, value)
+			}
+			var series int64
+			for p.Next() {
+				series++
+			}
+			if p.Err() != nil {
+				return nil, errors.Wrapf(p.Err(), "walk postings of %s=%q", name, value)
+			}
+
+			ln.Series += series
+			a.LabelValues = topLabelValues(a.LabelValues, LabelValueCardinality{Name: ln.Name, Value: value, Series: series}, limit)
+
+			b := sort.Search(len(postingsSizeBuckets), func(i int) bool { return postingsSizeBuckets[i] >= series })
+			a.PostingsSizes[b].Postings++
+			a.PostingsSizes[b].Bytes += postingsOverheadBytes + 4*series
+		}
+		a.LabelNames = append(a.LabelNames, ln)
+	}
+
+	sort.SliceStable(a.LabelNames, func(i, j int) bool { return a.LabelNames[i].Series > a.LabelNames[j].Series })
+	if len(a.LabelNames) > limit {
+		a.LabelNames = a.LabelNames[:limit]
+	}
+	return a, nil
+}
+
+// topLabelValues adds v to the label values sorted by descending number of series, keeping at most limit of them.
+func topLabelValues(top []LabelValueCardinality, v LabelValueCardinality, limit int) []LabelValueCardinality {
+	i := sort.Search(len(top), func(i int) bool { return top[i].Series < v.Series })
+	if i >= limit {
+		return top
+	}
+	if len(top) < limit {
+		top = append(top, LabelValueCardinality{})
+	}
+	copy(top[i+1:], top[i:])
+	v.Value = strings.Clone(v.Value)
+	top[i] = v
+	return top
+}
+
+// indexSections returns the sizes of the sections of the index file fn, read from its table of contents, and the
+// size of the index.
+func indexSections(fn string) (_ []IndexSection, _ int64, err error) {
+	f, err := os.Open(filepath.Clean(fn))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer runutil.CloseWithErrCapture(&err, f, "index file")
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := st.Size()
+	if size < indexTOCLen {
+		return nil, 0, errors.Errorf("index of %d bytes is too short for the table of contents", size)
+	}
+
+	b := make([]byte, indexTOCLen)
+	if _, err := f.ReadAt(b, size-indexTOCLen); err != nil {
+		return nil, 0, errors.Wrap(err, "read table of contents")
+	}
+	toc, err := index.NewTOCFromByteSlice(realByteSlice(b))
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "parse table of contents")
+	}
+
+	// The sections are in the order of the index, from the symbols after the header to the table of contents.
+	offsets := []struct {
+		name string
+		off  uint64
+	}{
+		{name: "symbols", off: toc.Symbols},
+		{name: "series", off: toc.Series},
+		{name: "label_indices", off: toc.LabelIndices},
+		{name: "postings", off: toc.Postings},
+		{name: "label_indices_table", off: toc.LabelIndicesTable},
+		{name: "postings_table", off: toc.PostingsTable},
+		{name: "toc", off: uint64(size - indexTOCLen)},
+	}
+	sections := make([]IndexSection, 0, len(offsets)-1)
+	for i, o := range offsets[:len(offsets)-1] {
+		sections = append(sections, IndexSection{Name: o.name, Bytes: int64(offsets[i+1].off - o.off)})
+	}
+	return sections, size, nil
+}
+
+type realByteSlice []byte
+
+func (b realByteSlice) Len() int                    { return len(b) }
+func (b realByteSlice) Range(start, end int) []byte { return b[start:end] }
+
+// Churn returns the churn of the series of the block of a compared to the block of prev.
+func (a *IndexAnalysis) Churn(prev *IndexAnalysis) SeriesChurn {
+	c := SeriesChurn{From: prev.ULID, To: a.ULID}
+	for h := range a.series {
+		if _, ok := prev.series[h]; !ok {
+			c.Added++
+		}
+	}
+	for h := range prev.series {
+		if _, ok := a.series[h]; !ok {
+			c.Removed++
+		}
+	}
+	return c
+}
+
+// ReleaseSeries drops the hashes of the series of the block, once the churn with the next block is computed.
+func (a *IndexAnalysis) ReleaseSeries() {
+	a.series = nil
+}
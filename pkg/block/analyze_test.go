@@ -0,0 +1,62 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestAnalyzeIndex(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+
+	first, err := e2eutil.CreateBlock(ctx, tmpDir, []labels.Labels{
+		labels.FromStrings("a", "1", "b", "1"),
+		labels.FromStrings("a", "1", "b", "2"),
+		labels.FromStrings("a", "2", "b", "1"),
+	}, 10, 0, 1000, nil, 0, metadata.NoneFunc)
+	testutil.Ok(t, err)
+
+	a, err := AnalyzeIndex(filepath.Join(tmpDir, first.String(), IndexFilename), first, 2)
+	testutil.Ok(t, err)
+	testutil.Equals(t, first, a.ULID)
+	testutil.Equals(t, int64(3), a.Series)
+	testutil.Equals(t, []LabelNameCardinality{
+		{Name: "a", Series: 3, Values: 2},
+		{Name: "b", Series: 3, Values: 2},
+	}, a.LabelNames)
+	testutil.Equals(t, []LabelValueCardinality{
+		{Name: "a", Value: "1", Series: 2},
+		{Name: "b", Value: "1", Series: 2},
+	}, a.LabelValues)
+
+	// The header of the index, its sections and table of contents make up the whole index.
+	size := int64(5 + indexTOCLen)
+	for _, s := range a.Sections {
+		size += s.Bytes
+	}
+	testutil.Equals(t, a.IndexBytes, size)
+
+	// Postings lists of all label name and value pairs, including the all postings list.
+	testutil.Equals(t, PostingsSizeBucket{MaxSeries: 1, Postings: 2, Bytes: 2 * (postingsOverheadBytes + 4)}, a.PostingsSizes[0])
+	testutil.Equals(t, PostingsSizeBucket{MaxSeries: 10, Postings: 2, Bytes: 2 * (postingsOverheadBytes + 8)}, a.PostingsSizes[1])
+
+	second, err := e2eutil.CreateBlock(ctx, tmpDir, []labels.Labels{
+		labels.FromStrings("a", "1", "b", "1"),
+		labels.FromStrings("a", "3", "b", "1"),
+	}, 10, 1000, 2000, nil, 0, metadata.NoneFunc)
+	testutil.Ok(t, err)
+
+	b, err := AnalyzeIndex(filepath.Join(tmpDir, second.String(), IndexFilename), second, 2)
+	testutil.Ok(t, err)
+	testutil.Equals(t, SeriesChurn{From: first, To: second, Added: 1, Removed: 2}, b.Churn(a))
+}
@@ -23,8 +23,11 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/thanos-io/objstore"
+	"go.uber.org/atomic"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/extobjstore"
 	"github.com/thanos-io/thanos/pkg/runutil"
 )
 
@@ -109,38 +112,12 @@ func UploadPromBlock(ctx context.Context, logger log.Logger, bkt objstore.Bucket
 // TODO(bplotka): Ensure bucket operations have reasonable backoff retries.
 // NOTE: Upload updates `meta.Thanos.File` section.
 func upload(ctx context.Context, logger log.Logger, bkt objstore.Bucket, bdir string, hf metadata.HashFunc, checkExternalLabels bool, options ...objstore.UploadOption) error {
-	df, err := os.Stat(bdir)
+	id, meta, err := prepareUpload(logger, bdir, hf, checkExternalLabels)
 	if err != nil {
 		return err
 	}
-	if !df.IsDir() {
-		return errors.Errorf("%s is not a directory", bdir)
-	}
-
-	// Verify dir.
-	id, err := ulid.Parse(df.Name())
-	if err != nil {
-		return errors.Wrap(err, "not a block dir")
-	}
-
-	meta, err := metadata.ReadFromDir(bdir)
-	if err != nil {
-		// No meta or broken meta file.
-		return errors.Wrap(err, "read meta")
-	}
-
-	if checkExternalLabels {
-		if meta.Thanos.Labels == nil || len(meta.Thanos.Labels) == 0 {
-			return errors.New("empty external labels are not allowed for Thanos block.")
-		}
-	}
 
 	metaEncoded := strings.Builder{}
-	meta.Thanos.Files, err = GatherFileStats(bdir, hf, logger)
-	if err != nil {
-		return errors.Wrap(err, "gather meta file stats")
-	}
-
 	if err := meta.Write(&metaEncoded); err != nil {
 		return errors.Wrap(err, "encode meta file")
 	}
@@ -165,6 +142,122 @@ func upload(ctx context.Context, logger log.Logger, bkt objstore.Bucket, bdir st
 	return nil
 }
 
+// UploadResumable uploads a TSDB block to the object storage like Upload, resuming the upload of a block partially
+// uploaded before. Files of a partially uploaded block already in the bucket with the size of the local file are not
+// uploaded again. The multipart uploads of files left incomplete are resumed by buckets supporting it, see
+// extobjstore.WithResumableUploads. Unlike Upload, the partially uploaded block is kept on failure for the next attempt
+// to resume it; partial blocks never completed are deleted by the compactor. The files are uploaded by up to the given
+// number of goroutines.
+func UploadResumable(ctx context.Context, logger log.Logger, bkt objstore.Bucket, bdir string, hf metadata.HashFunc, concurrency int) error {
+	id, meta, err := prepareUpload(logger, bdir, hf, true)
+	if err != nil {
+		return err
+	}
+
+	metaEncoded := strings.Builder{}
+	if err := meta.Write(&metaEncoded); err != nil {
+		return errors.Wrap(err, "encode meta file")
+	}
+
+	// Only the files of a partial upload are verified, so that new blocks are uploaded without requests per file.
+	existing := map[string]struct{}{}
+	if err := bkt.Iter(ctx, id.String(), func(name string) error {
+		existing[name] = struct{}{}
+		return nil
+	}, objstore.WithRecursiveIter); err != nil {
+		return errors.Wrap(err, "list partially uploaded files")
+	}
+
+	var files []metadata.File
+	for _, f := range meta.Thanos.Files {
+		if f.RelPath != MetaFilename {
+			files = append(files, f)
+		}
+	}
+
+	g, gctx := errgroup.WithContext(extobjstore.WithResumableUploads(ctx))
+	if concurrency > 0 {
+		g.SetLimit(concurrency)
+	}
+	var resumed atomic.Int64
+	for _, f := range files {
+		f := f
+		g.Go(func() error {
+			src, dst := filepath.Join(bdir, f.RelPath), path.Join(id.String(), filepath.ToSlash(f.RelPath))
+			if _, ok := existing[dst]; ok {
+				ok, err := hasSize(gctx, bkt, dst, f.SizeBytes)
+				if err != nil {
+					return err
+				}
+				if ok {
+					resumed.Inc()
+					return nil
+				}
+			}
+			return objstore.UploadFile(gctx, logger, bkt, src, dst)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return errors.Wrap(err, "upload files")
+	}
+	if n := resumed.Load(); n > 0 {
+		level.Info(logger).Log("msg", "resumed block upload", "id", id, "skipped_files", n)
+	}
+
+	// Meta.json always need to be uploaded as a last item, see upload.
+	if err := bkt.Upload(ctx, path.Join(id.String(), MetaFilename), strings.NewReader(metaEncoded.String())); err != nil {
+		return errors.Wrap(err, "upload meta file")
+	}
+	return nil
+}
+
+// hasSize returns whether the given object exists in the bucket with the given size.
+func hasSize(ctx context.Context, bkt objstore.Bucket, name string, size int64) (bool, error) {
+	attrs, err := bkt.Attributes(ctx, name)
+	if bkt.IsObjNotFoundErr(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "get attributes of %s", name)
+	}
+	return attrs.Size == size, nil
+}
+
+// prepareUpload verifies the given block dir and returns the ID of the block and its meta with the stats of its files.
+func prepareUpload(logger log.Logger, bdir string, hf metadata.HashFunc, checkExternalLabels bool) (ulid.ULID, *metadata.Meta, error) {
+	df, err := os.Stat(bdir)
+	if err != nil {
+		return ulid.ULID{}, nil, err
+	}
+	if !df.IsDir() {
+		return ulid.ULID{}, nil, errors.Errorf("%s is not a directory", bdir)
+	}
+
+	// Verify dir.
+	id, err := ulid.Parse(df.Name())
+	if err != nil {
+		return ulid.ULID{}, nil, errors.Wrap(err, "not a block dir")
+	}
+
+	meta, err := metadata.ReadFromDir(bdir)
+	if err != nil {
+		// No meta or broken meta file.
+		return ulid.ULID{}, nil, errors.Wrap(err, "read meta")
+	}
+
+	if checkExternalLabels {
+		if meta.Thanos.Labels == nil || len(meta.Thanos.Labels) == 0 {
+			return ulid.ULID{}, nil, errors.New("empty external labels are not allowed for Thanos block.")
+		}
+	}
+
+	meta.Thanos.Files, err = GatherFileStats(bdir, hf, logger)
+	if err != nil {
+		return ulid.ULID{}, nil, errors.Wrap(err, "gather meta file stats")
+	}
+	return id, meta, nil
+}
+
 func cleanUp(logger log.Logger, bkt objstore.Bucket, id ulid.ULID, err error) error {
 	// Cleanup the dir with an uncancelable context.
 	cleanErr := Delete(context.Background(), logger, bkt, id)
@@ -11,7 +11,9 @@ import (
 	"io"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -574,6 +576,105 @@ func TestUploadCleanup(t *testing.T) {
 	}
 }
 
+func TestUploadResumable(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	ctx := context.Background()
+
+	tmpDir := t.TempDir()
+
+	bkt := objstore.NewInMemBucket()
+	b1, err := e2eutil.CreateBlock(ctx, tmpDir, []labels.Labels{
+		{{Name: "a", Value: "1"}},
+		{{Name: "a", Value: "2"}},
+		{{Name: "b", Value: "1"}},
+	}, 100, 0, 1000, labels.Labels{{Name: "ext1", Value: "val1"}}, 124, metadata.SHA256Func)
+	testutil.Ok(t, err)
+	bdir := path.Join(tmpDir, b1.String())
+	chunks, index, meta := path.Join(b1.String(), ChunksDirname, "000001"), path.Join(b1.String(), IndexFilename), path.Join(b1.String(), MetaFilename)
+
+	{
+		errBkt := errBucket{Bucket: bkt, failSuffix: "/index"}
+
+		uploadErr := UploadResumable(ctx, log.NewNopLogger(), errBkt, bdir, metadata.SHA256Func, 1)
+		testutil.Assert(t, errors.Is(uploadErr, errUploadFailed))
+
+		// If upload of index fails, the partially uploaded block is kept to be resumed.
+		testutil.Equals(t, 2, len(bkt.Objects()))
+		testutil.Assert(t, len(bkt.Objects()[chunks]) > 0)
+		testutil.Assert(t, len(bkt.Objects()[index]) > 0)
+	}
+	{
+		// Files of the partial upload are skipped, unless their size differs, without reading them.
+		content, err := os.ReadFile(filepath.Join(bdir, IndexFilename))
+		testutil.Ok(t, err)
+		testutil.Ok(t, bkt.Upload(ctx, index, bytes.NewReader(content[:len(content)/2])))
+		recBkt := &recordingBucket{Bucket: bkt}
+
+		testutil.Ok(t, UploadResumable(ctx, log.NewNopLogger(), recBkt, bdir, metadata.SHA256Func, 2))
+		testutil.Equals(t, []string{index, meta}, recBkt.uploaded)
+		testutil.Equals(t, 0, recBkt.gets)
+		testutil.Equals(t, content, bkt.Objects()[index])
+
+		m, err := DownloadMeta(ctx, log.NewNopLogger(), bkt, b1)
+		testutil.Ok(t, err)
+		for _, f := range m.Thanos.Files {
+			if f.RelPath == MetaFilename {
+				continue
+			}
+			testutil.Equals(t, int64(len(bkt.Objects()[path.Join(b1.String(), f.RelPath)])), f.SizeBytes)
+			testutil.Assert(t, f.Hash != nil, "no hash for %s", f.RelPath)
+		}
+	}
+	{
+		// New blocks are uploaded without verifying files, and without hashes if no hash function is given.
+		other := objstore.NewInMemBucket()
+		recBkt := &recordingBucket{Bucket: other}
+
+		testutil.Ok(t, UploadResumable(ctx, log.NewNopLogger(), recBkt, bdir, metadata.NoneFunc, 1))
+		testutil.Equals(t, []string{chunks, index, meta}, recBkt.uploaded)
+		testutil.Equals(t, 0, recBkt.attributes)
+		testutil.Equals(t, 0, recBkt.gets)
+		testutil.Equals(t, bkt.Objects()[index], other.Objects()[index])
+
+		m, err := DownloadMeta(ctx, log.NewNopLogger(), other, b1)
+		testutil.Ok(t, err)
+		for _, f := range m.Thanos.Files {
+			testutil.Assert(t, f.Hash == nil, "hash for %s", f.RelPath)
+		}
+	}
+}
+
+type recordingBucket struct {
+	objstore.Bucket
+
+	mtx        sync.Mutex
+	uploaded   []string
+	attributes int
+	gets       int
+}
+
+func (rb *recordingBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	rb.mtx.Lock()
+	rb.uploaded = append(rb.uploaded, name)
+	rb.mtx.Unlock()
+	return rb.Bucket.Upload(ctx, name, r)
+}
+
+func (rb *recordingBucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	rb.mtx.Lock()
+	rb.attributes++
+	rb.mtx.Unlock()
+	return rb.Bucket.Attributes(ctx, name)
+}
+
+func (rb *recordingBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	rb.mtx.Lock()
+	rb.gets++
+	rb.mtx.Unlock()
+	return rb.Bucket.Get(ctx, name)
+}
+
 var errUploadFailed = errors.New("upload failed")
 
 type errBucket struct {
@@ -0,0 +1,176 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+	"github.com/prometheus/prometheus/tsdb/index"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ChunksHealthStats are the results of cross-checking the chunk references of a block index against its chunk
+// segment files.
+type ChunksHealthStats struct {
+	// TotalChunks represents the number of chunk references in the index.
+	TotalChunks int64
+	// DanglingChunks represents the number of chunk references pointing to a segment file which does not exist, or
+	// to an offset outside of it.
+	DanglingChunks int
+	// TruncatedChunks represents the number of chunks whose data, according to their length, extends beyond the end
+	// of their segment file.
+	TruncatedChunks int
+	// CorruptedChunks represents the number of chunks whose CRC32 does not match their data.
+	CorruptedChunks int
+}
+
+// Err returns an error if the stats indicate any chunk which cannot be read.
+func (s ChunksHealthStats) Err() error {
+	var errMsg []string
+	if s.DanglingChunks > 0 {
+		errMsg = append(errMsg, fmt.Sprintf("found %d dangling chunk references", s.DanglingChunks))
+	}
+	if s.TruncatedChunks > 0 {
+		errMsg = append(errMsg, fmt.Sprintf("found %d truncated chunks", s.TruncatedChunks))
+	}
+	if s.CorruptedChunks > 0 {
+		errMsg = append(errMsg, fmt.Sprintf("found %d chunks with CRC32 mismatch", s.CorruptedChunks))
+	}
+	if len(errMsg) > 0 {
+		return errors.Errorf("%s out of %d chunks", strings.Join(errMsg, ", "), s.TotalChunks)
+	}
+	return nil
+}
+
+// VerifyChunks checks that every chunk referenced by the index of the block in dir exists in the chunk segment files,
+// is not truncated and matches its CRC32.
+func VerifyChunks(logger log.Logger, dir string) error {
+	stats, err := GatherChunksHealthStats(logger, dir)
+	if err != nil {
+		return err
+	}
+	return stats.Err()
+}
+
+// GatherChunksHealthStats cross-checks every chunk reference in the index of the block in dir against the chunk
+// segment files. Each chunk which cannot be read is logged with its series and reference.
+func GatherChunksHealthStats(logger log.Logger, dir string) (stats ChunksHealthStats, err error) {
+	r, err := index.NewFileReader(filepath.Join(dir, IndexFilename))
+	if err != nil {
+		return stats, errors.Wrap(err, "open index file")
+	}
+	defer runutil.CloseWithErrCapture(&err, r, "gather chunks health stats index reader")
+
+	var (
+		segments = map[int][]byte{}
+		files    []*fileutil.MmapFile
+	)
+	defer func() {
+		for _, f := range files {
+			runutil.CloseWithErrCapture(&err, f, "chunk segment file")
+		}
+	}()
+	segment := func(i int) ([]byte, error) {
+		if b, ok := segments[i]; ok {
+			return b, nil
+		}
+		// Segment files are numbered from 1, while the references of their chunks start from 0.
+		fn := filepath.Join(dir, ChunksDirname, fmt.Sprintf("%0.6d", i+1))
+		fi, err := os.Stat(fn)
+		if os.IsNotExist(err) {
+			segments[i] = nil
+			return nil, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "stat chunk segment file %s", fn)
+		}
+		if fi.Size() == 0 {
+			// Empty files cannot be mapped.
+			segments[i] = []byte{}
+			return segments[i], nil
+		}
+		f, err := fileutil.OpenMmapFile(fn)
+		if err != nil {
+			return nil, errors.Wrapf(err, "open chunk segment file %s", fn)
+		}
+		files = append(files, f)
+		segments[i] = f.Bytes()
+		return segments[i], nil
+	}
+
+	p, err := r.Postings(index.AllPostingsKey())
+	if err != nil {
+		return stats, errors.Wrap(err, "get all postings")
+	}
+	var (
+		builder labels.ScratchBuilder
+		chks    []chunks.Meta
+	)
+	for p.Next() {
+		if err := r.Series(p.At(), &builder, &chks); err != nil {
+			return stats, errors.Wrap(err, "read series")
+		}
+		for _, c := range chks {
+			stats.TotalChunks++
+
+			sgmIndex, chkStart := chunks.BlockChunkRef(c.Ref).Unpack()
+			b, err := segment(sgmIndex)
+			if err != nil {
+				return stats, err
+			}
+			if problem := checkChunk(b, chkStart); problem != "" {
+				level.Warn(logger).Log("msg", "found unreadable chunk", "problem", problem, "series", builder.Labels().String(),
+					"ref", c.Ref, "segment", sgmIndex+1, "offset", chkStart, "mint", c.MinTime, "maxt", c.MaxTime)
+				switch problem {
+				case "dangling":
+					stats.DanglingChunks++
+				case "truncated":
+					stats.TruncatedChunks++
+				default:
+					stats.CorruptedChunks++
+				}
+			}
+		}
+	}
+	if err := p.Err(); err != nil {
+		return stats, errors.Wrap(err, "walk postings")
+	}
+	return stats, nil
+}
+
+// checkChunk returns the problem of the chunk starting at chkStart in the segment b, or empty string if it is intact.
+// A nil b is a missing segment.
+func checkChunk(b []byte, chkStart int) string {
+	if b == nil || chkStart < chunks.SegmentHeaderSize || chkStart >= len(b) {
+		return "dangling"
+	}
+
+	chkDataLen, n := binary.Uvarint(b[chkStart:])
+	if n <= 0 {
+		return "truncated"
+	}
+	chkEncStart := chkStart + n
+	chkDataEnd := chkEncStart + chunks.ChunkEncodingSize + int(chkDataLen)
+	if chkDataEnd+crc32.Size > len(b) {
+		return "truncated"
+	}
+	if crc32.Checksum(b[chkEncStart:chkDataEnd], castagnoliTable) != binary.BigEndian.Uint32(b[chkDataEnd:chkDataEnd+crc32.Size]) {
+		return "corrupted"
+	}
+	return ""
+}
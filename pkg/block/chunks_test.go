@@ -0,0 +1,76 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestGatherChunksHealthStats(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+
+	createBlock := func(t *testing.T) (string, string) {
+		tmpDir := t.TempDir()
+		id, err := e2eutil.CreateBlock(ctx, tmpDir, []labels.Labels{
+			labels.FromStrings("a", "1"),
+			labels.FromStrings("a", "2"),
+			labels.FromStrings("a", "3"),
+		}, 100, 0, 1000, labels.EmptyLabels(), 0, metadata.NoneFunc)
+		testutil.Ok(t, err)
+		dir := filepath.Join(tmpDir, id.String())
+		return dir, filepath.Join(dir, ChunksDirname, "000001")
+	}
+
+	t.Run("healthy", func(t *testing.T) {
+		dir, _ := createBlock(t)
+		stats, err := GatherChunksHealthStats(logger, dir)
+		testutil.Ok(t, err)
+		testutil.Equals(t, ChunksHealthStats{TotalChunks: 3}, stats)
+		testutil.Ok(t, stats.Err())
+		testutil.Ok(t, VerifyChunks(logger, dir))
+	})
+	t.Run("corrupted", func(t *testing.T) {
+		dir, segment := createBlock(t)
+		b, err := os.ReadFile(segment)
+		testutil.Ok(t, err)
+		// Flip a byte of the data of the first chunk, after the segment header, the length and the encoding.
+		b[12] ^= 0xff
+		testutil.Ok(t, os.WriteFile(segment, b, os.ModePerm))
+
+		stats, err := GatherChunksHealthStats(logger, dir)
+		testutil.Ok(t, err)
+		testutil.Equals(t, ChunksHealthStats{TotalChunks: 3, CorruptedChunks: 1}, stats)
+		testutil.NotOk(t, VerifyChunks(logger, dir))
+	})
+	t.Run("truncated", func(t *testing.T) {
+		dir, segment := createBlock(t)
+		fi, err := os.Stat(segment)
+		testutil.Ok(t, err)
+		testutil.Ok(t, os.Truncate(segment, fi.Size()-1))
+
+		stats, err := GatherChunksHealthStats(logger, dir)
+		testutil.Ok(t, err)
+		testutil.Equals(t, ChunksHealthStats{TotalChunks: 3, TruncatedChunks: 1}, stats)
+	})
+	t.Run("dangling", func(t *testing.T) {
+		dir, segment := createBlock(t)
+		testutil.Ok(t, os.Remove(segment))
+
+		stats, err := GatherChunksHealthStats(logger, dir)
+		testutil.Ok(t, err)
+		testutil.Equals(t, ChunksHealthStats{TotalChunks: 3, DanglingChunks: 3}, stats)
+		testutil.Equals(t, "found 3 dangling chunk references out of 3 chunks", stats.Err().Error())
+	})
+}
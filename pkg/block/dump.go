@@ -0,0 +1,220 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// DumpFormat is the format Dump writes the series of a block in.
+type DumpFormat string
+
+const (
+	// DumpText writes a line with the labels, value and timestamp in milliseconds of each sample.
+	DumpText DumpFormat = "text"
+	// DumpOpenMetrics writes the float samples in the OpenMetrics text format. Series without metric name and native
+	// histogram samples are skipped.
+	DumpOpenMetrics DumpFormat = "openmetrics"
+	// DumpChunks writes a line with the labels, reference, time range, encoding and number of samples of each chunk
+	// instead of the samples.
+	DumpChunks DumpFormat = "chunks"
+)
+
+// DumpOptions are the options of Dump.
+type DumpOptions struct {
+	// MinTime and MaxTime are the inclusive time range of the dumped samples and chunks in milliseconds.
+	MinTime, MaxTime int64
+	// Matchers select the dumped series. All series are dumped if there are none.
+	Matchers []*labels.Matcher
+	Format   DumpFormat
+}
+
+// DumpStats are the statistics of a dump.
+type DumpStats struct {
+	Series  int
+	Samples int
+	Chunks  int
+	// Skipped is the number of samples or series which cannot be written in the format.
+	Skipped int
+	// Errors is the number of series which could not be read completely, e.g. because of corrupted chunks.
+	Errors int
+}
+
+// Dump writes the series of the block in dir selected by the options to w. Series which cannot be read are logged and
+// counted in the returned stats, so the rest of a corrupted block can still be inspected. Deleted samples are not
+// written.
+func Dump(logger log.Logger, dir string, w io.Writer, opts DumpOptions) (stats DumpStats, err error) {
+	b, err := tsdb.OpenBlock(logger, dir, nil)
+	if err != nil {
+		return stats, errors.Wrap(err, "open block")
+	}
+	defer runutil.CloseWithErrCapture(&err, b, "block")
+
+	matchers := opts.Matchers
+	if len(matchers) == 0 {
+		matchers = []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "", ".*")}
+	}
+
+	bw := bufio.NewWriter(w)
+	switch opts.Format {
+	case DumpText, DumpOpenMetrics:
+		err = dumpSamples(logger, b, bw, opts, matchers, &stats)
+	case DumpChunks:
+		err = dumpChunks(logger, b, bw, opts, matchers, &stats)
+	default:
+		return stats, errors.Errorf("unknown dump format %q", opts.Format)
+	}
+	if err != nil {
+		return stats, err
+	}
+	if opts.Format == DumpOpenMetrics {
+		if _, err := bw.WriteString("# EOF\n"); err != nil {
+			return stats, err
+		}
+	}
+	return stats, bw.Flush()
+}
+
+func dumpSamples(logger log.Logger, b *tsdb.Block, w *bufio.Writer, opts DumpOptions, matchers []*labels.Matcher, stats *DumpStats) (err error) {
+	q, err := tsdb.NewBlockQuerier(b, opts.MinTime, opts.MaxTime)
+	if err != nil {
+		return errors.Wrap(err, "create querier")
+	}
+	defer runutil.CloseWithErrCapture(&err, q, "querier")
+
+	// Sorted series keep the series of each metric together, as required by OpenMetrics.
+	ss := q.Select(true, nil, matchers...)
+	var it chunkenc.Iterator
+	for ss.Next() {
+		s := ss.At()
+		lset := s.Labels()
+		stats.Series++
+
+		var series string
+		if opts.Format == DumpOpenMetrics {
+			if lset.Get(labels.MetricName) == "" {
+				stats.Skipped++
+				continue
+			}
+			series = openMetricsSeries(lset)
+		} else {
+			series = lset.String()
+		}
+
+		it = s.Iterator(it)
+		for vt := it.Next(); vt != chunkenc.ValNone; vt = it.Next() {
+			var line string
+			switch {
+			case vt == chunkenc.ValFloat:
+				t, v := it.At()
+				if opts.Format == DumpOpenMetrics {
+					line = fmt.Sprintf("%s %s %s\n", series, strconv.FormatFloat(v, 'g', -1, 64), strconv.FormatFloat(float64(t)/1000, 'f', -1, 64))
+				} else {
+					line = fmt.Sprintf("%s %g %d\n", series, v, t)
+				}
+			case opts.Format == DumpOpenMetrics:
+				stats.Skipped++
+				continue
+			case vt == chunkenc.ValHistogram:
+				t, h := it.AtHistogram()
+				line = fmt.Sprintf("%s %s %d\n", series, h.String(), t)
+			default:
+				t, h := it.AtFloatHistogram()
+				line = fmt.Sprintf("%s %s %d\n", series, h.String(), t)
+			}
+			if _, err := w.WriteString(line); err != nil {
+				return err
+			}
+			stats.Samples++
+		}
+		if err := it.Err(); err != nil {
+			level.Error(logger).Log("msg", "failed to read samples of series", "series", lset.String(), "err", err)
+			stats.Errors++
+		}
+	}
+	if err := ss.Err(); err != nil {
+		return errors.Wrap(err, "select series")
+	}
+	if ws := ss.Warnings(); len(ws) > 0 {
+		return errors.Wrap(ws[0], "select series")
+	}
+	return nil
+}
+
+func dumpChunks(logger log.Logger, b *tsdb.Block, w *bufio.Writer, opts DumpOptions, matchers []*labels.Matcher, stats *DumpStats) (err error) {
+	q, err := tsdb.NewBlockChunkQuerier(b, opts.MinTime, opts.MaxTime)
+	if err != nil {
+		return errors.Wrap(err, "create chunk querier")
+	}
+	defer runutil.CloseWithErrCapture(&err, q, "chunk querier")
+
+	ss := q.Select(true, nil, matchers...)
+	for ss.Next() {
+		s := ss.At()
+		stats.Series++
+
+		it := s.Iterator(nil)
+		for it.Next() {
+			c := it.At()
+			line := fmt.Sprintf("%s ref=%d mint=%d maxt=%d encoding=%s samples=%d\n", s.Labels().String(), c.Ref, c.MinTime, c.MaxTime, c.Chunk.Encoding(), c.Chunk.NumSamples())
+			if _, err := w.WriteString(line); err != nil {
+				return err
+			}
+			stats.Chunks++
+		}
+		if err := it.Err(); err != nil {
+			level.Error(logger).Log("msg", "failed to read chunks of series", "series", s.Labels().String(), "err", err)
+			stats.Errors++
+		}
+	}
+	if err := ss.Err(); err != nil {
+		return errors.Wrap(err, "select series")
+	}
+	if ws := ss.Warnings(); len(ws) > 0 {
+		return errors.Wrap(ws[0], "select series")
+	}
+	return nil
+}
+
+// openMetricsSeries returns the metric name and labels of the series in the OpenMetrics text format.
+func openMetricsSeries(lset labels.Labels) string {
+	var b strings.Builder
+	b.WriteString(lset.Get(labels.MetricName))
+
+	first := true
+	lset.Range(func(l labels.Label) {
+		if l.Name == labels.MetricName {
+			return
+		}
+		if first {
+			b.WriteByte('{')
+			first = false
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(l.Name)
+		b.WriteString(`="`)
+		b.WriteString(openMetricsEscaper.Replace(l.Value))
+		b.WriteByte('"')
+	})
+	if !first {
+		b.WriteByte('}')
+	}
+	return b.String()
+}
+
+var openMetricsEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
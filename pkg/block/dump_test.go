@@ -0,0 +1,95 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestDump(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+
+	series := []labels.Labels{
+		labels.FromStrings("__name__", "up", "job", "a"),
+		labels.FromStrings("__name__", "up", "job", "b\"c"),
+		labels.FromStrings("a", "1"),
+	}
+	id, err := e2eutil.CreateBlock(ctx, tmpDir, series, 100, 0, 1000, labels.EmptyLabels(), 0, metadata.NoneFunc)
+	testutil.Ok(t, err)
+	dir := filepath.Join(tmpDir, id.String())
+
+	for _, tc := range []struct {
+		name          string
+		opts          DumpOptions
+		expectedStats DumpStats
+		expectedFirst string
+		expectedLast  string
+	}{
+		{
+			name:          "text",
+			opts:          DumpOptions{MinTime: 0, MaxTime: 1000, Format: DumpText},
+			expectedStats: DumpStats{Series: 3, Samples: 300},
+			expectedFirst: `{__name__="up", job="a"} `,
+			expectedLast:  `{a="1"} `,
+		},
+		{
+			name: "text with matchers and time range",
+			opts: DumpOptions{
+				MinTime:  0,
+				MaxTime:  99,
+				Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "job", "a")},
+				Format:   DumpText,
+			},
+			expectedStats: DumpStats{Series: 1, Samples: 12},
+			expectedFirst: `{__name__="up", job="a"} `,
+			expectedLast:  `{__name__="up", job="a"} `,
+		},
+		{
+			name:          "openmetrics",
+			opts:          DumpOptions{MinTime: 0, MaxTime: 1000, Format: DumpOpenMetrics},
+			expectedStats: DumpStats{Series: 3, Samples: 200, Skipped: 1},
+			expectedFirst: `up{job="a"} `,
+			expectedLast:  `# EOF`,
+		},
+		{
+			name:          "chunks",
+			opts:          DumpOptions{MinTime: 0, MaxTime: 1000, Format: DumpChunks},
+			expectedStats: DumpStats{Series: 3, Chunks: 3},
+			expectedFirst: `{__name__="up", job="a"} ref=`,
+			expectedLast:  `{a="1"} ref=`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			stats, err := Dump(log.NewNopLogger(), dir, &buf, tc.opts)
+			testutil.Ok(t, err)
+			testutil.Equals(t, tc.expectedStats, stats)
+
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			testutil.Assert(t, strings.HasPrefix(lines[0], tc.expectedFirst), "unexpected first line %q", lines[0])
+			testutil.Assert(t, strings.HasPrefix(lines[len(lines)-1], tc.expectedLast), "unexpected last line %q", lines[len(lines)-1])
+		})
+	}
+
+	var buf bytes.Buffer
+	_, err = Dump(log.NewNopLogger(), dir, &buf, DumpOptions{MinTime: 0, MaxTime: 1000, Format: DumpOpenMetrics})
+	testutil.Ok(t, err)
+	testutil.Assert(t, strings.Contains(buf.String(), `up{job="b\"c"} `), "label value not escaped")
+	testutil.Assert(t, strings.Contains(buf.String(), ` 0.009`+"\n"), "timestamp not in seconds")
+
+	_, err = Dump(log.NewNopLogger(), dir, &buf, DumpOptions{Format: "unknown"})
+	testutil.NotOk(t, err)
+}
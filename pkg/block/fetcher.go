@@ -783,6 +783,124 @@ func (f *ConsistencyDelayMetaFilter) Filter(_ context.Context, metas map[ulid.UL
 	return nil
 }
 
+// UploadGracePeriodMetaFilter is a BaseFetcher filter that filters out blocks which were uploaded to the bucket
+// less than a grace period ago. Contrary to ConsistencyDelayMetaFilter it checks the upload time of the meta.json
+// file instead of the block ULID, so blocks created long ago but uploaded recently are filtered out as well.
+// Not go-routine safe.
+type UploadGracePeriodMetaFilter struct {
+	logger      log.Logger
+	bkt         objstore.InstrumentedBucketReader
+	gracePeriod time.Duration
+	concurrency int
+
+	// uploadTimes caches upload times of blocks, since they do not change once the meta.json file is uploaded.
+	uploadTimes map[ulid.ULID]time.Time
+}
+
+// NewUploadGracePeriodMetaFilter creates UploadGracePeriodMetaFilter.
+func NewUploadGracePeriodMetaFilter(logger log.Logger, bkt objstore.InstrumentedBucketReader, gracePeriod time.Duration, concurrency int, reg prometheus.Registerer) *UploadGracePeriodMetaFilter {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	_ = promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "upload_grace_period_seconds",
+		Help: "Configured grace period after upload in seconds.",
+	}, func() float64 {
+		return gracePeriod.Seconds()
+	})
+
+	return &UploadGracePeriodMetaFilter{
+		logger:      logger,
+		bkt:         bkt,
+		gracePeriod: gracePeriod,
+		concurrency: concurrency,
+		uploadTimes: map[ulid.ULID]time.Time{},
+	}
+}
+
+// Filter filters out blocks which were uploaded less than the grace period ago.
+func (f *UploadGracePeriodMetaFilter) Filter(ctx context.Context, metas map[ulid.ULID]*metadata.Meta, synced GaugeVec, modified GaugeVec) error {
+	if f.gracePeriod <= 0 {
+		return nil
+	}
+
+	var toCheck []ulid.ULID
+	for id, meta := range metas {
+		// Blocks produced by the compactor itself do not race with any other uploader.
+		if meta.Thanos.Source == metadata.BucketRepairSource ||
+			meta.Thanos.Source == metadata.CompactorSource ||
+			meta.Thanos.Source == metadata.CompactorRepairSource {
+			continue
+		}
+		if _, ok := f.uploadTimes[id]; !ok {
+			toCheck = append(toCheck, id)
+		}
+	}
+
+	var (
+		eg  errgroup.Group
+		ch  = make(chan ulid.ULID, f.concurrency)
+		mtx sync.Mutex
+	)
+
+	for i := 0; i < f.concurrency; i++ {
+		eg.Go(func() error {
+			var lastErr error
+			for id := range ch {
+				attrs, err := f.bkt.ReaderWithExpectedErrs(f.bkt.IsObjNotFoundErr).Attributes(ctx, path.Join(id.String(), MetaFilename))
+				if err != nil {
+					if f.bkt.IsObjNotFoundErr(err) {
+						// Block was deleted in the meantime.
+						continue
+					}
+					// Remember the last error and continue to drain the channel.
+					lastErr = errors.Wrapf(err, "get attributes of meta file of block %s", id)
+					continue
+				}
+				mtx.Lock()
+				f.uploadTimes[id] = attrs.LastModified
+				mtx.Unlock()
+			}
+
+			return lastErr
+		})
+	}
+
+	// Workers scheduled, distribute blocks.
+	eg.Go(func() error {
+		defer close(ch)
+
+		for _, id := range toCheck {
+			select {
+			case ch <- id:
+				// Nothing to do.
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	if err := eg.Wait(); err != nil {
+		return errors.Wrap(err, "filter blocks in upload grace period")
+	}
+
+	for id := range f.uploadTimes {
+		if _, ok := metas[id]; !ok {
+			delete(f.uploadTimes, id)
+		}
+	}
+	for id, uploaded := range f.uploadTimes {
+		if time.Since(uploaded) < f.gracePeriod {
+			level.Debug(f.logger).Log("msg", "block was uploaded too recently", "block", id, "uploaded", uploaded)
+			synced.WithLabelValues(tooFreshMeta).Inc()
+			delete(metas, id)
+		}
+	}
+	return nil
+}
+
 // IgnoreDeletionMarkFilter is a filter that filters out the blocks that are marked for deletion after a given delay.
 // The delay duration is to make sure that the replacement block can be fetched before we filter out the old block.
 // Delay is not considered when computing DeletionMarkBlocks map.
@@ -1070,6 +1070,62 @@ func TestConsistencyDelayMetaFilter_Filter_0(t *testing.T) {
 	})
 }
 
+func TestUploadGracePeriodMetaFilter_Filter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	bkt := objstore.NewInMemBucket()
+	for i := 1; i <= 3; i++ {
+		testutil.Ok(t, bkt.Upload(ctx, path.Join(ULID(i).String(), MetaFilename), bytes.NewBufferString("{}")))
+	}
+
+	newInput := func() map[ulid.ULID]*metadata.Meta {
+		return map[ulid.ULID]*metadata.Meta{
+			ULID(1): {Thanos: metadata.Thanos{Source: metadata.SidecarSource}},
+			ULID(2): {Thanos: metadata.Thanos{Source: metadata.ReceiveSource}},
+			ULID(3): {Thanos: metadata.Thanos{Source: metadata.CompactorSource}},
+			// Meta file deleted in the meantime.
+			ULID(4): {Thanos: metadata.Thanos{Source: metadata.SidecarSource}},
+		}
+	}
+
+	t.Run("grace period 0 (turned off)", func(t *testing.T) {
+		m := newTestFetcherMetrics()
+		reg := prometheus.NewRegistry()
+		f := NewUploadGracePeriodMetaFilter(nil, objstore.WithNoopInstr(bkt), 0, 32, reg)
+		testutil.Equals(t, map[string]float64{"upload_grace_period_seconds{}": 0.0}, extprom.CurrentGaugeValuesFor(t, reg, "upload_grace_period_seconds"))
+
+		input := newInput()
+		testutil.Ok(t, f.Filter(ctx, input, m.Synced, nil))
+		testutil.Equals(t, 0.0, promtest.ToFloat64(m.Synced.WithLabelValues(tooFreshMeta)))
+		testutil.Equals(t, newInput(), input)
+	})
+
+	t.Run("grace period 30m", func(t *testing.T) {
+		m := newTestFetcherMetrics()
+		f := NewUploadGracePeriodMetaFilter(nil, objstore.WithNoopInstr(bkt), 30*time.Minute, 32, nil)
+
+		input := newInput()
+		testutil.Ok(t, f.Filter(ctx, input, m.Synced, nil))
+		testutil.Equals(t, 2.0, promtest.ToFloat64(m.Synced.WithLabelValues(tooFreshMeta)))
+		testutil.Equals(t, map[ulid.ULID]*metadata.Meta{
+			ULID(3): {Thanos: metadata.Thanos{Source: metadata.CompactorSource}},
+			ULID(4): {Thanos: metadata.Thanos{Source: metadata.SidecarSource}},
+		}, input)
+	})
+
+	t.Run("grace period passed", func(t *testing.T) {
+		m := newTestFetcherMetrics()
+		f := NewUploadGracePeriodMetaFilter(nil, objstore.WithNoopInstr(bkt), 10*time.Millisecond, 32, nil)
+		time.Sleep(20 * time.Millisecond)
+
+		input := newInput()
+		testutil.Ok(t, f.Filter(ctx, input, m.Synced, nil))
+		testutil.Equals(t, 0.0, promtest.ToFloat64(m.Synced.WithLabelValues(tooFreshMeta)))
+		testutil.Equals(t, newInput(), input)
+	})
+}
+
 func TestIgnoreDeletionMarkFilter_Filter(t *testing.T) {
 	objtesting.ForeachStore(t, func(t *testing.T, bkt objstore.Bucket) {
 		ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
@@ -0,0 +1,201 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// ImportOptions are the options of ImportPrometheusBlocks.
+type ImportOptions struct {
+	// BlockDuration is the duration of the imported blocks, which are aligned to it. The data of the Prometheus blocks
+	// is split and merged into these ranges. Zero keeps the ranges of the Prometheus blocks.
+	BlockDuration time.Duration
+	// IncludeHead imports the samples of the head too, replayed from the WAL of the data directory.
+	IncludeHead bool
+}
+
+// ImportPrometheusBlocks writes the data of the blocks of the Prometheus data directory or snapshot src as new blocks
+// to dst, and returns their IDs sorted by time. The blocks are rewritten, so deleted samples are dropped, and src is
+// not modified.
+func ImportPrometheusBlocks(ctx context.Context, logger log.Logger, src, dst string, opts ImportOptions) (_ []ulid.ULID, err error) {
+	if opts.BlockDuration < 0 {
+		return nil, errors.Errorf("negative block duration %v", opts.BlockDuration)
+	}
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	db, err := tsdb.OpenDBReadOnly(src, logger)
+	if err != nil {
+		return nil, errors.Wrap(err, "open Prometheus data directory")
+	}
+	defer runutil.CloseWithErrCapture(&err, db, "Prometheus data directory")
+
+	// Pieces are written to a directory of dst, then moved or merged to dst.
+	piecesDir := filepath.Join(dst, "pieces")
+	if err := os.RemoveAll(piecesDir); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rerr := os.RemoveAll(piecesDir); rerr != nil {
+			level.Warn(logger).Log("msg", "failed to delete dir", "dir", piecesDir, "err", rerr)
+		}
+	}()
+
+	// The head is flushed first, as flushing it closes the blocks opened before.
+	var head *tsdb.Block
+	if opts.IncludeHead {
+		if head, err = flushHead(db, src, filepath.Join(piecesDir, "head")); err != nil {
+			return nil, errors.Wrap(err, "flush head")
+		}
+		if head != nil {
+			defer runutil.CloseWithErrCapture(&err, head, "head block")
+		}
+	}
+
+	blocks, err := db.Blocks()
+	if err != nil {
+		return nil, errors.Wrap(err, "open Prometheus blocks")
+	}
+	if head != nil {
+		blocks = append(blocks, head)
+	}
+
+	comp, err := tsdb.NewLeveledCompactor(ctx, nil, logger, []int64{int64(2 * time.Hour / time.Millisecond)}, nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "create compactor")
+	}
+
+	// The pieces of the blocks written to each imported block, by start of its range.
+	var (
+		groups  []*importGroup
+		byStart = map[int64]*importGroup{}
+	)
+	for _, b := range blocks {
+		meta := b.Meta()
+		for _, r := range importRanges(meta.MinTime, meta.MaxTime, opts.BlockDuration.Milliseconds()) {
+			id, err := comp.Write(piecesDir, b, r.mint, r.maxt, nil)
+			if err != nil {
+				return nil, errors.Wrapf(err, "write range [%d, %d) of block %s", r.mint, r.maxt, meta.ULID)
+			}
+			if id == (ulid.ULID{}) {
+				// No samples in the range.
+				continue
+			}
+
+			dir := filepath.Join(piecesDir, id.String())
+			if opts.BlockDuration == 0 {
+				// The ranges of the Prometheus blocks are kept, even if they overlap.
+				groups = append(groups, &importGroup{start: r.start, dirs: []string{dir}})
+				continue
+			}
+			g, ok := byStart[r.start]
+			if !ok {
+				g = &importGroup{start: r.start}
+				byStart[r.start] = g
+				groups = append(groups, g)
+			}
+			g.dirs = append(g.dirs, dir)
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].start < groups[j].start })
+
+	var ids []ulid.ULID
+	for _, g := range groups {
+		if len(g.dirs) == 1 {
+			id := ulid.MustParse(filepath.Base(g.dirs[0]))
+			if err := fileutil.Rename(g.dirs[0], filepath.Join(dst, id.String())); err != nil {
+				return nil, errors.Wrapf(err, "move block %s", id)
+			}
+			ids = append(ids, id)
+			continue
+		}
+
+		id, err := comp.Compact(dst, g.dirs, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "merge blocks of range starting at %d", g.start)
+		}
+		if id != (ulid.ULID{}) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// importGroup is the pieces of the Prometheus blocks written to an imported block.
+type importGroup struct {
+	start int64
+	dirs  []string
+}
+
+// importRange is the range [mint, maxt) of the data of a Prometheus block within the imported block starting at start.
+type importRange struct {
+	start, mint, maxt int64
+}
+
+// importRanges returns the ranges of the data of the block between mint and maxt within the imported blocks, aligned to
+// duration. The whole block is a single range if duration is zero.
+func importRanges(mint, maxt, duration int64) []importRange {
+	if duration == 0 {
+		return []importRange{{start: mint, mint: mint, maxt: maxt}}
+	}
+
+	start := mint - mint%duration
+	if mint%duration < 0 {
+		start -= duration
+	}
+	var ranges []importRange
+	for ; start < maxt; start += duration {
+		r := importRange{start: start, mint: start, maxt: start + duration}
+		if r.mint < mint {
+			r.mint = mint
+		}
+		if r.maxt > maxt {
+			r.maxt = maxt
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// flushHead writes the head of the Prometheus data directory src, replayed from its WAL, as a block to dir, and opens it.
+// It returns nil if the head is empty.
+func flushHead(db *tsdb.DBReadOnly, src, dir string) (*tsdb.Block, error) {
+	// Flushing creates the WAL directory if it does not exist, which would modify src.
+	if _, err := os.Stat(filepath.Join(src, "wal")); err != nil {
+		return nil, errors.Wrap(err, "stat WAL directory")
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err := db.FlushWAL(dir); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if _, err := ulid.Parse(e.Name()); err != nil || !e.IsDir() {
+			continue
+		}
+		return tsdb.OpenBlock(nil, filepath.Join(dir, e.Name()), nil)
+	}
+	return nil, nil
+}
@@ -0,0 +1,86 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestImportPrometheusBlocks(t *testing.T) {
+	ctx := context.Background()
+	src := t.TempDir()
+
+	const h = int64(time.Hour / time.Millisecond)
+	series := []labels.Labels{labels.FromStrings("a", "1"), labels.FromStrings("a", "2")}
+	_, err := e2eutil.CreateBlock(ctx, src, series, 240, 0, 4*h, nil, 0, metadata.NoneFunc)
+	testutil.Ok(t, err)
+	_, err = e2eutil.CreateBlock(ctx, src, series, 120, 4*h, 6*h, nil, 0, metadata.NoneFunc)
+	testutil.Ok(t, err)
+
+	for _, tcase := range []struct {
+		name           string
+		blockDuration  time.Duration
+		expectedRanges [][2]int64
+	}{
+		{name: "keep ranges", expectedRanges: [][2]int64{{0, 4 * h}, {4 * h, 6 * h}}},
+		{name: "split", blockDuration: 2 * time.Hour, expectedRanges: [][2]int64{{0, 2 * h}, {2 * h, 4 * h}, {4 * h, 6 * h}}},
+		{name: "merge", blockDuration: 8 * time.Hour, expectedRanges: [][2]int64{{0, 6 * h}}},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			dst := t.TempDir()
+			ids, err := ImportPrometheusBlocks(ctx, log.NewNopLogger(), src, dst, ImportOptions{BlockDuration: tcase.blockDuration})
+			testutil.Ok(t, err)
+			testutil.Equals(t, len(tcase.expectedRanges), len(ids))
+
+			var samples uint64
+			for i, id := range ids {
+				meta, err := metadata.ReadFromDir(filepath.Join(dst, id.String()))
+				testutil.Ok(t, err)
+				testutil.Equals(t, tcase.expectedRanges[i], [2]int64{meta.MinTime, meta.MaxTime})
+				testutil.Equals(t, uint64(2), meta.Stats.NumSeries)
+				samples += meta.Stats.NumSamples
+			}
+			testutil.Equals(t, uint64(2*(240+120)), samples)
+		})
+	}
+}
+
+func TestImportPrometheusBlocks_IncludeHead(t *testing.T) {
+	ctx := context.Background()
+	src := t.TempDir()
+
+	// Samples only in the WAL, as the head is not compacted.
+	db, err := tsdb.Open(src, nil, nil, tsdb.DefaultOptions(), nil)
+	testutil.Ok(t, err)
+	app := db.Appender(ctx)
+	for ts := int64(0); ts < 100; ts++ {
+		_, err := app.Append(0, labels.FromStrings("a", "1"), ts, 1)
+		testutil.Ok(t, err)
+	}
+	testutil.Ok(t, app.Commit())
+	testutil.Ok(t, db.Close())
+
+	ids, err := ImportPrometheusBlocks(ctx, log.NewNopLogger(), src, t.TempDir(), ImportOptions{})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(ids))
+
+	dst := t.TempDir()
+	ids, err = ImportPrometheusBlocks(ctx, log.NewNopLogger(), src, dst, ImportOptions{IncludeHead: true})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(ids))
+	meta, err := metadata.ReadFromDir(filepath.Join(dst, ids[0].String()))
+	testutil.Ok(t, err)
+	testutil.Equals(t, uint64(100), meta.Stats.NumSamples)
+}
@@ -41,6 +41,8 @@ const (
 	RulerSource           SourceType = "ruler"
 	BucketRepairSource    SourceType = "bucket.repair"
 	BucketRewriteSource   SourceType = "bucket.rewrite"
+	RulesBackfillSource   SourceType = "rules.backfill"
+	ImportSource          SourceType = "import"
 	TestSource            SourceType = "test"
 )
 
@@ -90,15 +92,38 @@ type Thanos struct {
 
 	// Rewrites is present when any rewrite (deletion, relabel etc) were applied to this block. Optional.
 	Rewrites []Rewrite `json:"rewrites,omitempty"`
+
+	// StorageClass is the storage class the objects of the block were uploaded with by the compactor, if set by its
+	// storage class rules. Optional.
+	StorageClass *StorageClass `json:"storage_class,omitempty"`
+}
+
+// StorageClass is the storage class of the objects of a block.
+type StorageClass struct {
+	// Name is the storage class of the provider, e.g. COLDLINE.
+	Name string `json:"name"`
+	// Cold is true if the storage class is a cold tier, reads from which are slower or more expensive.
+	Cold bool `json:"cold,omitempty"`
 }
 
 type Rewrite struct {
 	// ULIDs of all source head blocks that went into the block.
 	Sources []ulid.ULID `json:"sources,omitempty"`
+	// Block is the ULID of the block which was rewritten. Optional.
+	Block *ulid.ULID `json:"block,omitempty"`
+	// Time is the time of the rewrite, in milliseconds since epoch. Optional.
+	Time int64 `json:"time,omitempty"`
+	// Version is the version of Thanos which rewrote the block. Optional.
+	Version string `json:"version,omitempty"`
+	// Dropped are the series, chunks and samples of the rewritten block which were dropped by the rewrite, e.g.
+	// deleted. Optional.
+	Dropped *tsdb.BlockStats `json:"dropped,omitempty"`
 	// Deletions if applied (in order).
 	DeletionsApplied []DeletionRequest `json:"deletions_applied,omitempty"`
 	// Relabels if applied.
 	RelabelsApplied []*relabel.Config `json:"relabels_applied,omitempty"`
+	// Label rewrites if applied (in order).
+	LabelRewritesApplied []LabelRewrite `json:"label_rewrites_applied,omitempty"`
 }
 
 type Matchers []*labels.Matcher
@@ -117,6 +142,13 @@ type DeletionRequest struct {
 	RequestID string               `json:"request_id,omitempty" yaml:"request_id,omitempty"`
 }
 
+// LabelRewrite renames the metric and drops labels of all series matching the given matchers.
+type LabelRewrite struct {
+	Matchers     Matchers `json:"matchers" yaml:"matchers"`
+	RenameMetric string   `json:"rename_metric,omitempty" yaml:"rename_metric,omitempty"`
+	DropLabels   []string `json:"drop_labels,omitempty" yaml:"drop_labels,omitempty"`
+}
+
 type File struct {
 	RelPath string `json:"rel_path"`
 	// SizeBytes is optional (e.g meta.json does not show size).
@@ -137,6 +169,10 @@ func InjectThanos(logger log.Logger, bdir string, meta Thanos, downsampledMeta *
 	if err != nil {
 		return nil, errors.Wrap(err, "read new meta")
 	}
+	// Keep rewrites applied to the block before it was finalized, e.g. label rewrites applied during compaction.
+	if len(meta.Rewrites) == 0 {
+		meta.Rewrites = newMeta.Thanos.Rewrites
+	}
 	newMeta.Thanos = meta
 
 	// While downsampling we need to copy original compaction.
@@ -0,0 +1,129 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/version"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/tsdb"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// SplitOptions are the options of Split. Exactly one of them has to be set.
+type SplitOptions struct {
+	// Duration is the duration of the blocks the block is split into, which are aligned to it.
+	Duration time.Duration
+	// MaxIndexSize is the maximum size in bytes of the index of the blocks the block is split into. The duration of the
+	// blocks, which are not aligned to it, is derived from it assuming the size of the index is proportional to the
+	// time range of the block. This is only an estimate, since series spanning multiple blocks are indexed in each of
+	// them.
+	MaxIndexSize int64
+}
+
+// Split writes the data of the block in dir as new blocks to dst, and returns their metas sorted by time. The new
+// blocks keep the external labels of the block, and record it as the rewritten block. Ranges without samples are
+// skipped. Downsampled blocks cannot be split.
+func Split(ctx context.Context, logger log.Logger, dir, dst string, opts SplitOptions) (_ []*metadata.Meta, err error) {
+	if (opts.Duration > 0) == (opts.MaxIndexSize > 0) {
+		return nil, errors.New("either a positive duration or a positive maximum index size has to be given")
+	}
+
+	meta, err := metadata.ReadFromDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read meta")
+	}
+	if meta.Thanos.Downsample.Resolution > 0 {
+		return nil, errors.Errorf("block %s is downsampled, only raw blocks can be split", meta.ULID)
+	}
+
+	var (
+		duration = opts.Duration.Milliseconds()
+		ranges   []importRange
+	)
+	if opts.MaxIndexSize > 0 {
+		fi, err := os.Stat(filepath.Join(dir, IndexFilename))
+		if err != nil {
+			return nil, errors.Wrap(err, "stat index")
+		}
+		parts := (fi.Size() + opts.MaxIndexSize - 1) / opts.MaxIndexSize
+		duration = (meta.MaxTime - meta.MinTime + parts - 1) / parts
+
+		// Derived durations are not aligned, to split into as few blocks as possible.
+		for mint := meta.MinTime; mint < meta.MaxTime; mint += duration {
+			r := importRange{start: mint, mint: mint, maxt: mint + duration}
+			if r.maxt > meta.MaxTime {
+				r.maxt = meta.MaxTime
+			}
+			ranges = append(ranges, r)
+		}
+	} else {
+		ranges = importRanges(meta.MinTime, meta.MaxTime, duration)
+	}
+	if len(ranges) < 2 {
+		return nil, errors.Errorf("block %s with range [%d, %d) does not need to be split into blocks of %s", meta.ULID, meta.MinTime, meta.MaxTime, time.Duration(duration)*time.Millisecond)
+	}
+
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		return nil, err
+	}
+	b, err := tsdb.OpenBlock(logger, dir, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "open block")
+	}
+	defer runutil.CloseWithErrCapture(&err, b, "block")
+
+	comp, err := tsdb.NewLeveledCompactor(ctx, nil, logger, []int64{duration}, nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "create compactor")
+	}
+
+	var (
+		metas []*metadata.Meta
+		oldID = meta.ULID
+		now   = timestamp.FromTime(time.Now())
+	)
+	for _, r := range ranges {
+		id, err := comp.Write(dst, b, r.mint, r.maxt, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "write range [%d, %d)", r.mint, r.maxt)
+		}
+		if id == (ulid.ULID{}) {
+			// No samples in the range.
+			continue
+		}
+
+		thanos := meta.Thanos
+		thanos.Source = metadata.BucketRewriteSource
+		thanos.Files = nil
+		thanos.Rewrites = append(append([]metadata.Rewrite(nil), meta.Thanos.Rewrites...), metadata.Rewrite{
+			Sources: meta.Compaction.Sources,
+			Block:   &oldID,
+			Time:    now,
+			Version: version.Version,
+		})
+		newMeta, err := metadata.InjectThanos(logger, filepath.Join(dst, id.String()), thanos, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "write meta of block %s", id)
+		}
+
+		if opts.MaxIndexSize > 0 {
+			if fi, err := os.Stat(filepath.Join(dst, id.String(), IndexFilename)); err == nil && fi.Size() > opts.MaxIndexSize {
+				level.Warn(logger).Log("msg", "index of split block is larger than the maximum index size", "block", id, "size", fi.Size(), "max", opts.MaxIndexSize)
+			}
+		}
+		metas = append(metas, newMeta)
+	}
+	return metas, nil
+}
@@ -0,0 +1,67 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestSplit(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	tmpDir := t.TempDir()
+	hour := time.Hour.Milliseconds()
+
+	series := []labels.Labels{labels.FromStrings("a", "1"), labels.FromStrings("a", "2")}
+	id, err := e2eutil.CreateBlock(ctx, tmpDir, series, 360, hour/2, 3*hour, labels.FromStrings("cluster", "eu-1"), 0, metadata.NoneFunc)
+	testutil.Ok(t, err)
+	dir := filepath.Join(tmpDir, id.String())
+
+	t.Run("duration", func(t *testing.T) {
+		metas, err := Split(ctx, logger, dir, t.TempDir(), SplitOptions{Duration: time.Hour})
+		testutil.Ok(t, err)
+		testutil.Equals(t, 3, len(metas))
+
+		var samples uint64
+		for i, m := range metas {
+			testutil.Equals(t, map[string]string{"cluster": "eu-1"}, m.Thanos.Labels)
+			testutil.Equals(t, metadata.BucketRewriteSource, m.Thanos.Source)
+			testutil.Equals(t, 1, len(m.Thanos.Rewrites))
+			testutil.Equals(t, id, *m.Thanos.Rewrites[0].Block)
+			testutil.Equals(t, []ulid.ULID{id}, m.Thanos.Rewrites[0].Sources)
+			testutil.Assert(t, m.MinTime >= int64(i)*hour && m.MaxTime <= int64(i+1)*hour, "block %d not within its range: [%d, %d)", i, m.MinTime, m.MaxTime)
+			samples += m.Stats.NumSamples
+		}
+		testutil.Equals(t, uint64(2*360), samples)
+	})
+	t.Run("max index size", func(t *testing.T) {
+		fi, err := os.Stat(filepath.Join(dir, IndexFilename))
+		testutil.Ok(t, err)
+		metas, err := Split(ctx, logger, dir, t.TempDir(), SplitOptions{MaxIndexSize: fi.Size() / 2})
+		testutil.Ok(t, err)
+		testutil.Equals(t, 2, len(metas))
+	})
+	t.Run("no split needed", func(t *testing.T) {
+		_, err := Split(ctx, logger, dir, t.TempDir(), SplitOptions{Duration: 4 * time.Hour})
+		testutil.NotOk(t, err)
+	})
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := Split(ctx, logger, dir, t.TempDir(), SplitOptions{})
+		testutil.NotOk(t, err)
+		_, err = Split(ctx, logger, dir, t.TempDir(), SplitOptions{Duration: time.Hour, MaxIndexSize: 1})
+		testutil.NotOk(t, err)
+	})
+}
@@ -62,6 +62,9 @@ var (
 var (
 	_ RemoteCacheClient = (*memcachedClient)(nil)
 	_ RemoteCacheClient = (*RedisClient)(nil)
+
+	_ Pinger = (*memcachedClient)(nil)
+	_ Pinger = (*RedisClient)(nil)
 )
 
 // RemoteCacheClient is a high level client to interact with remote cache.
@@ -79,6 +82,12 @@ type RemoteCacheClient interface {
 	Stop()
 }
 
+// Pinger is implemented by remote cache clients able to check whether their servers are reachable.
+type Pinger interface {
+	// Ping returns an error if the servers of the cache cannot be reached.
+	Ping(ctx context.Context) error
+}
+
 // MemcachedClient for compatible.
 type MemcachedClient = RemoteCacheClient
 
@@ -427,6 +436,30 @@ func (c *memcachedClient) SetAsync(key string, value []byte, ttl time.Duration)
 	return err
 }
 
+// Ping implements Pinger, checking that memcached servers are resolved and that all of them accept connections.
+func (c *memcachedClient) Ping(ctx context.Context) error {
+	var addrs []net.Addr
+	if err := c.selector.Each(func(addr net.Addr) error {
+		addrs = append(addrs, addr)
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "list memcached servers")
+	}
+	if len(addrs) == 0 {
+		return errors.New("no memcached server resolved")
+	}
+
+	var dialer net.Dialer
+	for _, addr := range addrs {
+		conn, err := dialer.DialContext(ctx, addr.Network(), addr.String())
+		if err != nil {
+			return errors.Wrapf(err, "connect to memcached server %s", addr)
+		}
+		_ = conn.Close()
+	}
+	return nil
+}
+
 func (c *memcachedClient) GetMulti(ctx context.Context, keys []string) map[string][]byte {
 	if len(keys) == 0 {
 		return nil
@@ -311,6 +311,11 @@ func (c *RedisClient) GetMulti(ctx context.Context, keys []string) map[string][]
 	return results
 }
 
+// Ping implements Pinger.
+func (c *RedisClient) Ping(ctx context.Context) error {
+	return errors.Wrap(c.client.Do(ctx, c.client.B().Ping().Build()).Error(), "ping redis")
+}
+
 // Stop implement RemoteCacheClient.
 func (c *RedisClient) Stop() {
 	c.client.Close()
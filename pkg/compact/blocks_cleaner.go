@@ -14,6 +14,7 @@ import (
 	"github.com/thanos-io/objstore"
 
 	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/extobjstore"
 )
 
 // BlocksCleaner is a struct that deletes blocks from bucket which are marked for deletion.
@@ -21,13 +22,13 @@ type BlocksCleaner struct {
 	logger                   log.Logger
 	ignoreDeletionMarkFilter *block.IgnoreDeletionMarkFilter
 	bkt                      objstore.Bucket
-	deleteDelay              time.Duration
+	deleteDelay              *DeleteDelayPolicy
 	blocksCleaned            prometheus.Counter
 	blockCleanupFailures     prometheus.Counter
 }
 
 // NewBlocksCleaner creates a new BlocksCleaner.
-func NewBlocksCleaner(logger log.Logger, bkt objstore.Bucket, ignoreDeletionMarkFilter *block.IgnoreDeletionMarkFilter, deleteDelay time.Duration, blocksCleaned, blockCleanupFailures prometheus.Counter) *BlocksCleaner {
+func NewBlocksCleaner(logger log.Logger, bkt objstore.Bucket, ignoreDeletionMarkFilter *block.IgnoreDeletionMarkFilter, deleteDelay *DeleteDelayPolicy, blocksCleaned, blockCleanupFailures prometheus.Counter) *BlocksCleaner {
 	return &BlocksCleaner{
 		logger:                   logger,
 		ignoreDeletionMarkFilter: ignoreDeletionMarkFilter,
@@ -39,20 +40,41 @@ func NewBlocksCleaner(logger log.Logger, bkt objstore.Bucket, ignoreDeletionMark
 }
 
 // DeleteMarkedBlocks uses ignoreDeletionMarkFilter to gather the blocks that are marked for deletion and deletes those
-// if older than given deleteDelay.
+// if older than the delete delay of the given block.
 func (s *BlocksCleaner) DeleteMarkedBlocks(ctx context.Context) error {
 	level.Info(s.logger).Log("msg", "started cleaning of blocks marked for deletion")
 
 	deletionMarkMap := s.ignoreDeletionMarkFilter.DeletionMarkBlocks()
 	for _, deletionMark := range deletionMarkMap {
-		if time.Since(time.Unix(deletionMark.DeletionTime, 0)).Seconds() > s.deleteDelay.Seconds() {
-			if err := block.Delete(ctx, s.logger, s.bkt, deletionMark.ID); err != nil {
-				s.blockCleanupFailures.Inc()
-				return errors.Wrap(err, "delete block")
+		sinceMarked := time.Since(time.Unix(deletionMark.DeletionTime, 0))
+		if sinceMarked <= s.deleteDelay.MinDeleteDelay() {
+			continue
+		}
+
+		// Metadata is only needed when the delay depends on the block itself.
+		if s.deleteDelay.HasOverrides() && sinceMarked <= s.deleteDelay.MaxDeleteDelay() {
+			meta, err := block.DownloadMeta(ctx, s.logger, s.bkt, deletionMark.ID)
+			if err != nil {
+				// Without meta.json we cannot tell which override applies, so keep the block for the longest delay.
+				level.Warn(s.logger).Log("msg", "failed to read meta of block marked for deletion; keeping it until the maximum delete delay", "block", deletionMark.ID, "err", err)
+				continue
+			}
+			if sinceMarked <= s.deleteDelay.DeleteDelay(&meta) {
+				continue
+			}
+		}
+
+		if err := block.Delete(ctx, s.logger, s.bkt, deletionMark.ID); err != nil {
+			if extobjstore.IsImmutableErr(err) {
+				// The block stays deleted logically by its deletion mark, until its objects can be deleted.
+				level.Info(s.logger).Log("msg", "block marked for deletion is protected from deletion by the bucket; keeping its deletion mark and retrying in next iteration", "block", deletionMark.ID, "err", err)
+				continue
 			}
-			s.blocksCleaned.Inc()
-			level.Info(s.logger).Log("msg", "deleted block marked for deletion", "block", deletionMark.ID)
+			s.blockCleanupFailures.Inc()
+			return errors.Wrap(err, "delete block")
 		}
+		s.blocksCleaned.Inc()
+		level.Info(s.logger).Log("msg", "deleted block marked for deletion", "block", deletionMark.ID)
 	}
 
 	level.Info(s.logger).Log("msg", "cleaning of blocks marked for deletion done")
@@ -0,0 +1,68 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/minio/minio-go/v7"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+// lockedBucket fails to delete objects, as they are protected by S3 Object Lock.
+type lockedBucket struct {
+	objstore.Bucket
+}
+
+func (b lockedBucket) Delete(context.Context, string) error {
+	return minio.ErrorResponse{Code: "ObjectLocked", Message: "Object is WORM protected and cannot be overwritten", StatusCode: 400}
+}
+
+func TestBlocksCleaner_ImmutableBlocks(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+
+	id := ulid.MustNew(ulid.Now(), nil)
+	var buf bytes.Buffer
+	testutil.Ok(t, json.NewEncoder(&buf).Encode(metadata.Meta{BlockMeta: tsdb.BlockMeta{ULID: id, MinTime: 0, MaxTime: 1000, Version: 1}, Thanos: metadata.Thanos{Version: metadata.ThanosVersion1}}))
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(id.String(), metadata.MetaFilename), &buf))
+	buf.Reset()
+	testutil.Ok(t, json.NewEncoder(&buf).Encode(metadata.DeletionMark{ID: id, DeletionTime: time.Now().Add(-time.Hour).Unix(), Version: metadata.DeletionMarkVersion1}))
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(id.String(), metadata.DeletionMarkFilename), &buf))
+
+	locked := objstore.WithNoopInstr(lockedBucket{Bucket: bkt})
+	filter := block.NewIgnoreDeletionMarkFilter(log.NewNopLogger(), locked, time.Minute, 1)
+	fetcher, err := block.NewMetaFetcher(log.NewNopLogger(), 1, locked, "", nil, []block.MetadataFilter{filter})
+	testutil.Ok(t, err)
+	metas, _, err := fetcher.Fetch(ctx)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(metas))
+
+	blocksCleaned := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
+	blockCleanupFailures := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
+	cleaner := NewBlocksCleaner(log.NewNopLogger(), locked, filter, NewDeleteDelayPolicy(time.Minute), blocksCleaned, blockCleanupFailures)
+
+	// The block can't be deleted, so it is kept with its deletion mark without failing the cleanup.
+	testutil.Ok(t, cleaner.DeleteMarkedBlocks(ctx))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(blocksCleaned))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(blockCleanupFailures))
+	exists, err := bkt.Exists(ctx, path.Join(id.String(), metadata.DeletionMarkFilename))
+	testutil.Ok(t, err)
+	testutil.Assert(t, exists)
+}
@@ -5,15 +5,21 @@ package compact
 
 import (
 	"context"
+	"path"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/thanos-io/objstore"
 
 	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/extobjstore"
 )
 
 const (
@@ -51,6 +57,10 @@ func BestEffortCleanAbortedPartialUploads(
 		// long PartialUploadThresholdAge already.
 		// TODO(bwplotka): Fix some edge cases: https://github.com/thanos-io/thanos/issues/2470 .
 		if err := block.Delete(ctx, logger, bkt, id); err != nil {
+			if extobjstore.IsImmutableErr(err) {
+				level.Info(logger).Log("msg", "aborted partial upload is protected from deletion by the bucket; will retry in next iteration", "block", id, "err", err)
+				continue
+			}
 			blockCleanupFailures.Inc()
 			level.Warn(logger).Log("msg", "failed to delete aborted partial upload; will retry in next iteration", "block", id, "thresholdAge", PartialUploadThresholdAge, "err", err)
 			continue
@@ -60,3 +70,162 @@ func BestEffortCleanAbortedPartialUploads(
 	}
 	level.Info(logger).Log("msg", "cleaning of aborted partial uploads done")
 }
+
+// OrphanedBlock is the data of a block without a valid meta.json file which can be deleted.
+type OrphanedBlock struct {
+	ID      ulid.ULID
+	Objects int
+	Bytes   int64
+	// LastModified is the time the most recently modified object of the block was modified.
+	LastModified time.Time
+	// DeletionMarked is true if the data was left over by an interrupted deletion.
+	DeletionMarked bool
+}
+
+// FindOrphanedBlockData returns the data of the partial blocks which is left over by aborted uploads or by deletions which
+// were interrupted after the meta.json file was removed, sorted by ULID. Blocks with an object modified within the
+// safety window are skipped, as their upload might still be in progress, unless they have a deletion mark. Blocks
+// whose objects cannot be listed are logged and skipped.
+func FindOrphanedBlockData(ctx context.Context, logger log.Logger, partial map[ulid.ULID]error, bkt objstore.Bucket, safetyWindow time.Duration) []OrphanedBlock {
+	var orphaned []OrphanedBlock
+	for id := range partial {
+		b, err := gatherOrphanedBlock(ctx, bkt, id)
+		if err != nil {
+			level.Warn(logger).Log("msg", "failed to gather objects of block without meta.json; will retry in next iteration", "block", id, "err", err)
+			continue
+		}
+		if b.Objects == 0 {
+			continue
+		}
+		if !b.DeletionMarked && time.Since(b.LastModified) <= safetyWindow {
+			// Upload might still be in progress, ignore for now.
+			continue
+		}
+		orphaned = append(orphaned, b)
+	}
+	sort.Slice(orphaned, func(i, j int) bool { return orphaned[i].ID.Compare(orphaned[j].ID) < 0 })
+	return orphaned
+}
+
+// BestEffortCleanOrphanedBlockData deletes data of blocks without a valid meta.json file, left over by aborted uploads or
+// by deletions which were interrupted after the meta.json file was removed. Contrary to BestEffortCleanAbortedPartialUploads,
+// the safety window is based on the time the block objects were last modified instead of the block creation time, so
+// blocks still being uploaded are never deleted. Data of interrupted deletions, recognized by the presence of the deletion
+// mark, is deleted right away. It returns the number of bytes deleted.
+func BestEffortCleanOrphanedBlockData(
+	ctx context.Context,
+	logger log.Logger,
+	partial map[ulid.ULID]error,
+	bkt objstore.Bucket,
+	safetyWindow time.Duration,
+	deleteAttempts prometheus.Counter,
+	blockCleanups prometheus.Counter,
+	blockCleanupFailures prometheus.Counter,
+	reclaimedBytes prometheus.Counter,
+) int64 {
+	level.Info(logger).Log("msg", "started cleaning of orphaned block data")
+
+	orphaned := FindOrphanedBlockData(ctx, logger, partial, bkt, safetyWindow)
+	var reclaimed int64
+	for _, b := range orphaned {
+		deleteAttempts.Inc()
+		level.Info(logger).Log("msg", "found orphaned block data; deleting", "block", b.ID, "interrupted_deletion", b.DeletionMarked,
+			"last_modified", b.LastModified, "objects", b.Objects, "bytes", b.Bytes)
+		if err := block.Delete(ctx, logger, bkt, b.ID); err != nil {
+			if extobjstore.IsImmutableErr(err) {
+				level.Info(logger).Log("msg", "orphaned block data is protected from deletion by the bucket; will retry in next iteration", "block", b.ID, "err", err)
+				continue
+			}
+			blockCleanupFailures.Inc()
+			level.Warn(logger).Log("msg", "failed to delete orphaned block data; will retry in next iteration", "block", b.ID, "err", err)
+			continue
+		}
+		blockCleanups.Inc()
+		reclaimedBytes.Add(float64(b.Bytes))
+		reclaimed += b.Bytes
+		level.Info(logger).Log("msg", "deleted orphaned block data", "block", b.ID, "bytes", b.Bytes)
+	}
+	level.Info(logger).Log("msg", "cleaning of orphaned block data done", "reclaimed_bytes", reclaimed)
+	return reclaimed
+}
+
+// DebugMeta is a debug meta file in block.DebugMetas.
+type DebugMeta struct {
+	ID           ulid.ULID
+	Bytes        int64
+	LastModified time.Time
+}
+
+// FindDebugMetasWithoutBlock returns the debug meta files in block.DebugMetas of blocks which have no object anymore,
+// and which were not modified within the safety window, sorted by ULID. Older Thanos versions uploaded a copy of the
+// meta.json file of each block there before the block itself, and never deleted it, so that the history of the bucket
+// can be investigated. They are not left over by aborted uploads, and are only deleted on request.
+func FindDebugMetasWithoutBlock(ctx context.Context, bkt objstore.Bucket, safetyWindow time.Duration) ([]DebugMeta, error) {
+	var metas []DebugMeta
+	err := bkt.Iter(ctx, block.DebugMetas, func(name string) error {
+		id, err := ulid.Parse(strings.TrimSuffix(path.Base(name), ".json"))
+		if err != nil {
+			return nil
+		}
+		attrs, err := bkt.Attributes(ctx, name)
+		if err != nil {
+			if bkt.IsObjNotFoundErr(err) {
+				// Deleted in the meantime.
+				return nil
+			}
+			return errors.Wrapf(err, "get attributes of %s", name)
+		}
+		if time.Since(attrs.LastModified) <= safetyWindow {
+			return nil
+		}
+
+		hasObjects := false
+		if err := bkt.Iter(ctx, id.String(), func(string) error {
+			hasObjects = true
+			return errStopIter
+		}); err != nil && !errors.Is(err, errStopIter) {
+			return errors.Wrapf(err, "list objects of block %s", id)
+		}
+		if hasObjects {
+			return nil
+		}
+		metas = append(metas, DebugMeta{ID: id, Bytes: attrs.Size, LastModified: attrs.LastModified})
+		return nil
+	})
+	sort.Slice(metas, func(i, j int) bool { return metas[i].ID.Compare(metas[j].ID) < 0 })
+	return metas, err
+}
+
+var errStopIter = errors.New("stop iteration")
+
+// DeleteDebugMeta deletes the debug meta file of the block.
+func DeleteDebugMeta(ctx context.Context, bkt objstore.Bucket, id ulid.ULID) error {
+	if err := bkt.Delete(ctx, path.Join(block.DebugMetas, id.String()+".json")); err != nil && !bkt.IsObjNotFoundErr(err) {
+		return errors.Wrap(err, "delete debug meta")
+	}
+	return nil
+}
+
+func gatherOrphanedBlock(ctx context.Context, bkt objstore.Bucket, id ulid.ULID) (OrphanedBlock, error) {
+	b := OrphanedBlock{ID: id}
+	err := bkt.Iter(ctx, id.String(), func(name string) error {
+		attrs, err := bkt.Attributes(ctx, name)
+		if err != nil {
+			if bkt.IsObjNotFoundErr(err) {
+				// Deleted in the meantime.
+				return nil
+			}
+			return errors.Wrapf(err, "get attributes of %s", name)
+		}
+		if name == path.Join(id.String(), metadata.DeletionMarkFilename) {
+			b.DeletionMarked = true
+		}
+		b.Objects++
+		b.Bytes += attrs.Size
+		if attrs.LastModified.After(b.LastModified) {
+			b.LastModified = attrs.LastModified
+		}
+		return nil
+	}, objstore.WithRecursiveIter)
+	return b, err
+}
@@ -82,3 +82,116 @@ func TestBestEffortCleanAbortedPartialUploads(t *testing.T) {
 	testutil.Ok(t, err)
 	testutil.Equals(t, true, exists)
 }
+
+func TestBestEffortCleanOrphanedBlockData(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	bkt := objstore.WithNoopInstr(objstore.NewInMemBucket())
+	logger := log.NewNopLogger()
+
+	metaFetcher, err := block.NewMetaFetcher(nil, 32, bkt, "", nil, nil)
+	testutil.Ok(t, err)
+
+	const safetyWindow = 50 * time.Millisecond
+
+	// 1. No meta, not modified within the safety window, should be removed.
+	abortedID := ulid.MustNew(1, nil)
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(abortedID.String(), "chunks", "000001"), bytes.NewReader([]byte{0, 1, 2, 3})))
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(abortedID.String(), "chunks", "000002"), bytes.NewReader([]byte{0, 1, 2, 3})))
+	time.Sleep(2 * safetyWindow)
+
+	// 2. Old block with meta, so should be kept.
+	withMetaID := ulid.MustNew(2, nil)
+	var meta metadata.Meta
+	meta.Version = 1
+	meta.ULID = withMetaID
+
+	var buf bytes.Buffer
+	testutil.Ok(t, json.NewEncoder(&buf).Encode(&meta))
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(withMetaID.String(), metadata.MetaFilename), &buf))
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(withMetaID.String(), "chunks", "000001"), bytes.NewReader([]byte{0, 1, 2, 3})))
+
+	// 3. No meta, still being uploaded even though the block is old, should be kept.
+	uploadingID := ulid.MustNew(3, nil)
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(uploadingID.String(), "chunks", "000001"), bytes.NewReader([]byte{0, 1, 2, 3})))
+
+	// 4. Deletion interrupted after meta was deleted, should be removed right away.
+	deletedID := ulid.MustNew(4, nil)
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(deletedID.String(), "chunks", "000001"), bytes.NewReader([]byte{0, 1, 2, 3})))
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(deletedID.String(), metadata.DeletionMarkFilename), bytes.NewReader([]byte("{}"))))
+
+	deleteAttempts := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
+	blockCleanups := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
+	blockCleanupFailures := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
+	reclaimedBytes := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
+	_, partial, err := metaFetcher.Fetch(ctx)
+	testutil.Ok(t, err)
+
+	BestEffortCleanOrphanedBlockData(ctx, logger, partial, bkt, safetyWindow, deleteAttempts, blockCleanups, blockCleanupFailures, reclaimedBytes)
+	testutil.Equals(t, 2.0, promtest.ToFloat64(deleteAttempts))
+	testutil.Equals(t, 2.0, promtest.ToFloat64(blockCleanups))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(blockCleanupFailures))
+	testutil.Equals(t, 14.0, promtest.ToFloat64(reclaimedBytes))
+
+	for id, expected := range map[ulid.ULID]bool{
+		abortedID:   false,
+		withMetaID:  true,
+		uploadingID: true,
+		deletedID:   false,
+	} {
+		exists, err := bkt.Exists(ctx, path.Join(id.String(), "chunks", "000001"))
+		testutil.Ok(t, err)
+		testutil.Equals(t, expected, exists, "block %s", id)
+	}
+}
+
+func TestFindDebugMetasWithoutBlock(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	bkt := objstore.WithNoopInstr(objstore.NewInMemBucket())
+	logger := log.NewNopLogger()
+
+	metaFetcher, err := block.NewMetaFetcher(nil, 32, bkt, "", nil, nil)
+	testutil.Ok(t, err)
+
+	const safetyWindow = 50 * time.Millisecond
+
+	// 1. Only a debug meta, not modified within the safety window, should be returned.
+	debugOnlyID := ulid.MustNew(1, nil)
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(block.DebugMetas, debugOnlyID.String()+".json"), bytes.NewReader([]byte("{}"))))
+
+	// 2. Debug meta of a partial block, which is kept when the data of the block is deleted.
+	partialID := ulid.MustNew(2, nil)
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(block.DebugMetas, partialID.String()+".json"), bytes.NewReader([]byte("{}"))))
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(partialID.String(), "chunks", "000001"), bytes.NewReader([]byte{0, 1, 2, 3})))
+	time.Sleep(2 * safetyWindow)
+
+	// 3. Only a debug meta, but modified within the safety window, should be kept.
+	freshID := ulid.MustNew(3, nil)
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(block.DebugMetas, freshID.String()+".json"), bytes.NewReader([]byte("{}"))))
+
+	metas, err := FindDebugMetasWithoutBlock(ctx, bkt, safetyWindow)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(metas))
+	testutil.Equals(t, debugOnlyID, metas[0].ID)
+	testutil.Equals(t, int64(2), metas[0].Bytes)
+	testutil.Ok(t, DeleteDebugMeta(ctx, bkt, metas[0].ID))
+
+	// Cleaning the orphaned block data does not delete debug metas.
+	_, partial, err := metaFetcher.Fetch(ctx)
+	testutil.Ok(t, err)
+	BestEffortCleanOrphanedBlockData(ctx, logger, partial, bkt, safetyWindow, promauto.With(nil).NewCounter(prometheus.CounterOpts{}),
+		promauto.With(nil).NewCounter(prometheus.CounterOpts{}), promauto.With(nil).NewCounter(prometheus.CounterOpts{}), promauto.With(nil).NewCounter(prometheus.CounterOpts{}))
+
+	var left []string
+	testutil.Ok(t, bkt.Iter(ctx, "", func(name string) error {
+		left = append(left, name)
+		return nil
+	}, objstore.WithRecursiveIter))
+	testutil.Equals(t, []string{
+		path.Join(block.DebugMetas, partialID.String()+".json"),
+		path.Join(block.DebugMetas, freshID.String()+".json"),
+	}, left)
+}
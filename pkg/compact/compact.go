@@ -217,42 +217,55 @@ type Grouper interface {
 // DefaultGrouper is the Thanos built-in grouper. It groups blocks based on downsample
 // resolution and block's labels.
 type DefaultGrouper struct {
-	bkt                           objstore.Bucket
-	logger                        log.Logger
-	acceptMalformedIndex          bool
-	enableVerticalCompaction      bool
-	compactions                   *prometheus.CounterVec
-	compactionRunsStarted         *prometheus.CounterVec
-	compactionRunsCompleted       *prometheus.CounterVec
-	compactionFailures            *prometheus.CounterVec
-	verticalCompactions           *prometheus.CounterVec
-	garbageCollectedBlocks        prometheus.Counter
-	blocksMarkedForDeletion       prometheus.Counter
-	blocksMarkedForNoCompact      prometheus.Counter
-	hashFunc                      metadata.HashFunc
-	blockFilesConcurrency         int
-	compactBlocksFetchConcurrency int
+	bkt                      objstore.Bucket
+	logger                   log.Logger
+	compactions              *prometheus.CounterVec
+	compactionRunsStarted    *prometheus.CounterVec
+	compactionRunsCompleted  *prometheus.CounterVec
+	compactionFailures       *prometheus.CounterVec
+	verticalCompactions      *prometheus.CounterVec
+	garbageCollectedBlocks   prometheus.Counter
+	blocksMarkedForDeletion  prometheus.Counter
+	blocksMarkedForNoCompact prometheus.Counter
+	// groupOpts are passed to all groups as they are, so that new options do not need to be threaded through.
+	groupOpts GroupOptions
+}
+
+// GroupOptions configures how compaction groups fetch, verify, compact and upload blocks.
+type GroupOptions struct {
+	// AcceptMalformedIndex makes the group compact blocks with an index that has out-of-order labels.
+	AcceptMalformedIndex bool
+	// EnableVerticalCompaction allows the group to compact overlapping blocks.
+	EnableVerticalCompaction bool
+	// HashFunc is the function used to calculate the hashes of the compacted block files.
+	HashFunc metadata.HashFunc
+	// BlockFilesConcurrency is the number of goroutines used to download and upload the files of a block. Must be > 0.
+	BlockFilesConcurrency int
+	// CompactBlocksFetchConcurrency is the number of blocks downloaded concurrently before compaction.
+	CompactBlocksFetchConcurrency int
+	// ResumeCompactions resumes compactions interrupted after the upload of the resulting block.
+	ResumeCompactions bool
+	// RepairIndexIssues repairs index issues of the source blocks instead of halting.
+	RepairIndexIssues bool
+	// VerifyChunks verifies the chunks of the compacted block before uploading it.
+	VerifyChunks bool
+	// StorageClasses selects the storage class of the uploaded blocks. Nil uses the bucket default.
+	StorageClasses *StorageClassPolicy
 }
 
 // NewDefaultGrouper makes a new DefaultGrouper.
 func NewDefaultGrouper(
 	logger log.Logger,
 	bkt objstore.Bucket,
-	acceptMalformedIndex bool,
-	enableVerticalCompaction bool,
 	reg prometheus.Registerer,
 	blocksMarkedForDeletion prometheus.Counter,
 	garbageCollectedBlocks prometheus.Counter,
 	blocksMarkedForNoCompact prometheus.Counter,
-	hashFunc metadata.HashFunc,
-	blockFilesConcurrency int,
-	compactBlocksFetchConcurrency int,
+	opts GroupOptions,
 ) *DefaultGrouper {
 	return &DefaultGrouper{
-		bkt:                      bkt,
-		logger:                   logger,
-		acceptMalformedIndex:     acceptMalformedIndex,
-		enableVerticalCompaction: enableVerticalCompaction,
+		bkt:    bkt,
+		logger: logger,
 		compactions: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
 			Name: "thanos_compact_group_compactions_total",
 			Help: "Total number of group compaction attempts that resulted in a new block.",
@@ -273,12 +286,10 @@ func NewDefaultGrouper(
 			Name: "thanos_compact_group_vertical_compactions_total",
 			Help: "Total number of group compaction attempts that resulted in a new block based on overlapping blocks.",
 		}, []string{"group"}),
-		blocksMarkedForNoCompact:      blocksMarkedForNoCompact,
-		garbageCollectedBlocks:        garbageCollectedBlocks,
-		blocksMarkedForDeletion:       blocksMarkedForDeletion,
-		hashFunc:                      hashFunc,
-		blockFilesConcurrency:         blockFilesConcurrency,
-		compactBlocksFetchConcurrency: compactBlocksFetchConcurrency,
+		blocksMarkedForNoCompact: blocksMarkedForNoCompact,
+		garbageCollectedBlocks:   garbageCollectedBlocks,
+		blocksMarkedForDeletion:  blocksMarkedForDeletion,
+		groupOpts:                opts,
 	}
 }
 
@@ -297,8 +308,6 @@ func (g *DefaultGrouper) Groups(blocks map[ulid.ULID]*metadata.Meta) (res []*Gro
 				groupKey,
 				lbls,
 				m.Thanos.Downsample.Resolution,
-				g.acceptMalformedIndex,
-				g.enableVerticalCompaction,
 				g.compactions.WithLabelValues(groupKey),
 				g.compactionRunsStarted.WithLabelValues(groupKey),
 				g.compactionRunsCompleted.WithLabelValues(groupKey),
@@ -307,9 +316,7 @@ func (g *DefaultGrouper) Groups(blocks map[ulid.ULID]*metadata.Meta) (res []*Gro
 				g.garbageCollectedBlocks,
 				g.blocksMarkedForDeletion,
 				g.blocksMarkedForNoCompact,
-				g.hashFunc,
-				g.blockFilesConcurrency,
-				g.compactBlocksFetchConcurrency,
+				g.groupOpts,
 			)
 			if err != nil {
 				return nil, errors.Wrap(err, "create compaction group")
@@ -330,26 +337,22 @@ func (g *DefaultGrouper) Groups(blocks map[ulid.ULID]*metadata.Meta) (res []*Gro
 // Group captures a set of blocks that have the same origin labels and downsampling resolution.
 // Those blocks generally contain the same series and can thus efficiently be compacted.
 type Group struct {
-	logger                        log.Logger
-	bkt                           objstore.Bucket
-	key                           string
-	labels                        labels.Labels
-	resolution                    int64
-	mtx                           sync.Mutex
-	metasByMinTime                []*metadata.Meta
-	acceptMalformedIndex          bool
-	enableVerticalCompaction      bool
-	compactions                   prometheus.Counter
-	compactionRunsStarted         prometheus.Counter
-	compactionRunsCompleted       prometheus.Counter
-	compactionFailures            prometheus.Counter
-	verticalCompactions           prometheus.Counter
-	groupGarbageCollectedBlocks   prometheus.Counter
-	blocksMarkedForDeletion       prometheus.Counter
-	blocksMarkedForNoCompact      prometheus.Counter
-	hashFunc                      metadata.HashFunc
-	blockFilesConcurrency         int
-	compactBlocksFetchConcurrency int
+	logger                      log.Logger
+	bkt                         objstore.Bucket
+	key                         string
+	labels                      labels.Labels
+	resolution                  int64
+	mtx                         sync.Mutex
+	metasByMinTime              []*metadata.Meta
+	compactions                 prometheus.Counter
+	compactionRunsStarted       prometheus.Counter
+	compactionRunsCompleted     prometheus.Counter
+	compactionFailures          prometheus.Counter
+	verticalCompactions         prometheus.Counter
+	groupGarbageCollectedBlocks prometheus.Counter
+	blocksMarkedForDeletion     prometheus.Counter
+	blocksMarkedForNoCompact    prometheus.Counter
+	opts                        GroupOptions
 }
 
 // NewGroup returns a new compaction group.
@@ -359,8 +362,6 @@ func NewGroup(
 	key string,
 	lset labels.Labels,
 	resolution int64,
-	acceptMalformedIndex bool,
-	enableVerticalCompaction bool,
 	compactions prometheus.Counter,
 	compactionRunsStarted prometheus.Counter,
 	compactionRunsCompleted prometheus.Counter,
@@ -369,37 +370,31 @@ func NewGroup(
 	groupGarbageCollectedBlocks prometheus.Counter,
 	blocksMarkedForDeletion prometheus.Counter,
 	blocksMarkedForNoCompact prometheus.Counter,
-	hashFunc metadata.HashFunc,
-	blockFilesConcurrency int,
-	compactBlocksFetchConcurrency int,
+	opts GroupOptions,
 ) (*Group, error) {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
 
-	if blockFilesConcurrency <= 0 {
-		return nil, errors.Errorf("invalid concurrency level (%d), blockFilesConcurrency level must be > 0", blockFilesConcurrency)
+	if opts.BlockFilesConcurrency <= 0 {
+		return nil, errors.Errorf("invalid concurrency level (%d), blockFilesConcurrency level must be > 0", opts.BlockFilesConcurrency)
 	}
 
 	g := &Group{
-		logger:                        logger,
-		bkt:                           bkt,
-		key:                           key,
-		labels:                        lset,
-		resolution:                    resolution,
-		acceptMalformedIndex:          acceptMalformedIndex,
-		enableVerticalCompaction:      enableVerticalCompaction,
-		compactions:                   compactions,
-		compactionRunsStarted:         compactionRunsStarted,
-		compactionRunsCompleted:       compactionRunsCompleted,
-		compactionFailures:            compactionFailures,
-		verticalCompactions:           verticalCompactions,
-		groupGarbageCollectedBlocks:   groupGarbageCollectedBlocks,
-		blocksMarkedForDeletion:       blocksMarkedForDeletion,
-		blocksMarkedForNoCompact:      blocksMarkedForNoCompact,
-		hashFunc:                      hashFunc,
-		blockFilesConcurrency:         blockFilesConcurrency,
-		compactBlocksFetchConcurrency: compactBlocksFetchConcurrency,
+		logger:                      logger,
+		bkt:                         bkt,
+		key:                         key,
+		labels:                      lset,
+		resolution:                  resolution,
+		compactions:                 compactions,
+		compactionRunsStarted:       compactionRunsStarted,
+		compactionRunsCompleted:     compactionRunsCompleted,
+		compactionFailures:          compactionFailures,
+		verticalCompactions:         verticalCompactions,
+		groupGarbageCollectedBlocks: groupGarbageCollectedBlocks,
+		blocksMarkedForDeletion:     blocksMarkedForDeletion,
+		blocksMarkedForNoCompact:    blocksMarkedForNoCompact,
+		opts:                        opts,
 	}
 	return g, nil
 }
@@ -824,13 +819,26 @@ func IsOutOfOrderChunkError(err error) bool {
 	return ok
 }
 
+// Reasons of halt errors.
+const (
+	haltReasonCorrupted = "corrupted"
+	haltReasonOverlap   = "overlap"
+	haltReasonUnknown   = "unknown"
+)
+
 // HaltError is a type wrapper for errors that should halt any further progress on compactions.
 type HaltError struct {
-	err error
+	err    error
+	reason string
 }
 
 func halt(err error) HaltError {
-	return HaltError{err: err}
+	return HaltError{err: err, reason: haltReasonUnknown}
+}
+
+// haltWithReason returns a HaltError with the given reason, corrupted or overlap.
+func haltWithReason(reason string, err error) HaltError {
+	return HaltError{err: err, reason: reason}
 }
 
 func (e HaltError) Error() string {
@@ -853,6 +861,23 @@ func IsHaltError(err error) bool {
 	return ok
 }
 
+// haltReason returns the reason of the halt error in err, or of the first one if a multierror is passed: corrupted,
+// overlap or unknown.
+func haltReason(err error) string {
+	if multiErr, ok := errors.Cause(err).(errutil.NonNilMultiError); ok {
+		for _, err := range multiErr {
+			if h, ok := errors.Cause(err).(HaltError); ok {
+				return h.reason
+			}
+		}
+		return haltReasonUnknown
+	}
+	if h, ok := errors.Cause(err).(HaltError); ok {
+		return h.reason
+	}
+	return haltReasonUnknown
+}
+
 // RetryError is a type wrapper for errors that should trigger warning log and retry whole compaction loop, but aborting
 // current compaction further progress.
 type RetryError struct {
@@ -983,8 +1008,8 @@ func (cg *Group) compact(ctx context.Context, dir string, planner Planner, comp
 	if err := cg.areBlocksOverlapping(nil); err != nil {
 		// TODO(bwplotka): It would really nice if we could still check for other overlaps than replica. In fact this should be checked
 		// in syncer itself. Otherwise with vertical compaction enabled we will sacrifice this important check.
-		if !cg.enableVerticalCompaction {
-			return false, ulid.ULID{}, halt(errors.Wrap(err, "pre compaction overlap check"))
+		if !cg.opts.EnableVerticalCompaction {
+			return false, ulid.ULID{}, haltWithReason(haltReasonOverlap, errors.Wrap(err, "pre compaction overlap check"))
 		}
 
 		overlappingBlocks = true
@@ -1002,31 +1027,116 @@ func (cg *Group) compact(ctx context.Context, dir string, planner Planner, comp
 		return false, ulid.ULID{}, nil
 	}
 
-	level.Info(cg.logger).Log("msg", "compaction available and planned; downloading blocks", "plan", fmt.Sprintf("%v", toCompact))
-
 	// Due to #183 we verify that none of the blocks in the plan have overlapping sources.
 	// This is one potential source of how we could end up with duplicated chunks.
 	uniqueSources := map[ulid.ULID]struct{}{}
-
-	// Once we have a plan we need to download the actual data.
-	groupCompactionBegin := time.Now()
-	begin := groupCompactionBegin
-	g, errCtx := errgroup.WithContext(ctx)
-	g.SetLimit(cg.compactBlocksFetchConcurrency)
-
 	toCompactDirs := make([]string, 0, len(toCompact))
 	for _, m := range toCompact {
-		bdir := filepath.Join(dir, m.ULID.String())
 		for _, s := range m.Compaction.Sources {
 			if _, ok := uniqueSources[s]; ok {
-				return false, ulid.ULID{}, halt(errors.Errorf("overlapping sources detected for plan %v", toCompact))
+				return false, ulid.ULID{}, haltWithReason(haltReasonOverlap, errors.Errorf("overlapping sources detected for plan %v", toCompact))
 			}
 			uniqueSources[s] = struct{}{}
 		}
+		toCompactDirs = append(toCompactDirs, filepath.Join(dir, m.ULID.String()))
+	}
+	sourceBlockStr := fmt.Sprintf("%v", toCompactDirs)
+
+	groupCompactionBegin := time.Now()
+
+	var newMeta *metadata.Meta
+	if id, ok := cg.resumableResult(dir, toCompact); ok {
+		compID = id
+		m, err := metadata.ReadFromDir(filepath.Join(dir, compID.String()))
+		if err != nil {
+			return false, ulid.ULID{}, errors.Wrapf(err, "read meta of resumed block %s", compID)
+		}
+		newMeta = m
+		level.Info(cg.logger).Log("msg", "found local block compacted from the same plan before restart; resuming from upload", "result_block", compID, "plan", sourceBlockStr)
+	} else {
+		var err error
+		compID, newMeta, err = cg.compactPlan(ctx, dir, toCompact, toCompactDirs, comp, overlappingBlocks)
+		if err != nil {
+			return false, ulid.ULID{}, err
+		}
+		if compID == (ulid.ULID{}) {
+			// Even though this block was empty, there may be more work to do.
+			return true, ulid.ULID{}, nil
+		}
+	}
+	bdir := filepath.Join(dir, compID.String())
+
+	// Ensure the output block is not overlapping with anything else,
+	// unless vertical compaction is enabled.
+	if !cg.opts.EnableVerticalCompaction {
+		if err := cg.areBlocksOverlapping(newMeta, toCompact...); err != nil {
+			return false, ulid.ULID{}, haltWithReason(haltReasonOverlap, errors.Wrapf(err, "resulted compacted block %s overlaps with something", bdir))
+		}
+	}
+
+	begin := time.Now()
+
+	err := tracing.DoInSpanWithErr(ctx, "compaction_block_upload", func(ctx context.Context) error {
+		return UploadBlock(ctx, cg.logger, cg.bkt, bdir, cg.opts.HashFunc, cg.opts.StorageClasses, objstore.WithUploadConcurrency(cg.opts.BlockFilesConcurrency))
+	})
+	if err != nil {
+		return false, ulid.ULID{}, retry(errors.Wrapf(err, "upload of %s failed", compID))
+	}
+	level.Info(cg.logger).Log("msg", "uploaded block", "result_block", compID, "duration", time.Since(begin), "duration_ms", time.Since(begin).Milliseconds())
+
+	// Mark for deletion the blocks we just compacted from the group and bucket so they do not get included
+	// into the next planning cycle.
+	// Eventually the block we just uploaded should get synced into the group again (including sync-delay).
+	for _, meta := range toCompact {
+		err = tracing.DoInSpanWithErr(ctx, "compaction_block_delete", func(ctx context.Context) error {
+			return cg.deleteBlock(meta.ULID, filepath.Join(dir, meta.ULID.String()))
+		}, opentracing.Tags{"block.id": meta.ULID})
+		if err != nil {
+			return false, ulid.ULID{}, retry(errors.Wrapf(err, "mark old block for deletion from bucket"))
+		}
+		cg.groupGarbageCollectedBlocks.Inc()
+	}
+
+	level.Info(cg.logger).Log("msg", "finished compacting blocks", "result_block", compID, "source_blocks", sourceBlockStr,
+		"duration", time.Since(groupCompactionBegin), "duration_ms", time.Since(groupCompactionBegin).Milliseconds())
+	return true, compID, nil
+}
+
+// resumableResult returns the ID of a local block which was already compacted, verified and finalized from the
+// given plan before the compactor was interrupted, if resuming compactions is enabled.
+func (cg *Group) resumableResult(dir string, toCompact []*metadata.Meta) (ulid.ULID, bool) {
+	if !cg.opts.ResumeCompactions {
+		return ulid.ULID{}, false
+	}
+	return findResumableResult(cg.logger, dir, toCompact)
+}
+
+// compactPlan downloads and verifies blocks of the plan, compacts them and finalizes the resulted block.
+// Zero ULID is returned if the resulted block would be empty.
+func (cg *Group) compactPlan(ctx context.Context, dir string, toCompact []*metadata.Meta, toCompactDirs []string, comp Compactor, overlappingBlocks bool) (compID ulid.ULID, _ *metadata.Meta, _ error) {
+	level.Info(cg.logger).Log("msg", "compaction available and planned; downloading blocks", "plan", fmt.Sprintf("%v", toCompact))
+
+	// Once we have a plan we need to download the actual data.
+	begin := time.Now()
+	g, errCtx := errgroup.WithContext(ctx)
+	g.SetLimit(cg.opts.CompactBlocksFetchConcurrency)
+
+	for i, m := range toCompact {
+		bdir := toCompactDirs[i]
 		func(ctx context.Context, meta *metadata.Meta) {
 			g.Go(func() error {
+				if cg.opts.ResumeCompactions {
+					if readResumeState(cg.logger, bdir) != nil {
+						level.Debug(cg.logger).Log("msg", "reusing block downloaded and verified before restart", "block", meta.ULID)
+						return nil
+					}
+					if err := os.Remove(filepath.Join(bdir, ResumeStateFilename)); err != nil && !os.IsNotExist(err) {
+						return errors.Wrapf(err, "remove stale resume state of block %s", meta.ULID)
+					}
+				}
+
 				if err := tracing.DoInSpanWithErr(ctx, "compaction_block_download", func(ctx context.Context) error {
-					return block.Download(ctx, cg.logger, cg.bkt, meta.ULID, bdir, objstore.WithFetchConcurrency(cg.blockFilesConcurrency))
+					return block.Download(ctx, cg.logger, cg.bkt, meta.ULID, bdir, objstore.WithFetchConcurrency(cg.opts.BlockFilesConcurrency))
 				}, opentracing.Tags{"block.id": meta.ULID}); err != nil {
 					return retry(errors.Wrapf(err, "download block %s", meta.ULID))
 				}
@@ -1040,8 +1150,14 @@ func (cg *Group) compact(ctx context.Context, dir string, planner Planner, comp
 					return errors.Wrapf(err, "gather index issues for block %s", bdir)
 				}
 
+				if cg.opts.RepairIndexIssues && repairableIndexIssues(stats) {
+					if err := cg.repairBlock(ctx, bdir, meta, &stats); err != nil {
+						level.Warn(cg.logger).Log("msg", "failed to repair block index issues", "block", meta.ULID, "err", err)
+					}
+				}
+
 				if err := stats.CriticalErr(); err != nil {
-					return halt(errors.Wrapf(err, "block with not healthy index found %s; Compaction level %v; Labels: %v", bdir, meta.Compaction.Level, meta.Thanos.Labels))
+					return haltWithReason(haltReasonCorrupted, errors.Wrapf(err, "block with not healthy index found %s; Compaction level %v; Labels: %v", bdir, meta.Compaction.Level, meta.Thanos.Labels))
 				}
 
 				if err := stats.OutOfOrderChunksErr(); err != nil {
@@ -1052,20 +1168,37 @@ func (cg *Group) compact(ctx context.Context, dir string, planner Planner, comp
 					return issue347Error(errors.Wrapf(err, "invalid, but reparable block %s", bdir), meta.ULID)
 				}
 
-				if err := stats.OutOfOrderLabelsErr(); !cg.acceptMalformedIndex && err != nil {
+				if err := stats.OutOfOrderLabelsErr(); !cg.opts.AcceptMalformedIndex && err != nil {
 					return errors.Wrapf(err,
 						"block id %s, try running with --debug.accept-malformed-index", meta.ULID)
 				}
+
+				if cg.opts.VerifyChunks {
+					var chunksStats block.ChunksHealthStats
+					if err := tracing.DoInSpanWithErr(ctx, "compaction_block_chunks_health_stats", func(ctx context.Context) (e error) {
+						chunksStats, e = block.GatherChunksHealthStats(cg.logger, bdir)
+						return e
+					}, opentracing.Tags{"block.id": meta.ULID}); err != nil {
+						return errors.Wrapf(err, "gather chunks issues for block %s", bdir)
+					}
+					if err := chunksStats.Err(); err != nil {
+						return haltWithReason(haltReasonCorrupted, errors.Wrapf(err, "block with unreadable chunks found %s; Compaction level %v; Labels: %v", bdir, meta.Compaction.Level, meta.Thanos.Labels))
+					}
+				}
+
+				if cg.opts.ResumeCompactions {
+					if err := writeResumeState(cg.logger, bdir, nil); err != nil {
+						level.Warn(cg.logger).Log("msg", "failed to write compaction resume state of downloaded block", "block", meta.ULID, "err", err)
+					}
+				}
 				return nil
 			})
 		}(errCtx, m)
-
-		toCompactDirs = append(toCompactDirs, bdir)
 	}
 	sourceBlockStr := fmt.Sprintf("%v", toCompactDirs)
 
 	if err := g.Wait(); err != nil {
-		return false, ulid.ULID{}, err
+		return ulid.ULID{}, nil, err
 	}
 
 	level.Info(cg.logger).Log("msg", "downloaded and verified blocks; compacting blocks", "plan", sourceBlockStr, "duration", time.Since(begin), "duration_ms", time.Since(begin).Milliseconds())
@@ -1075,7 +1208,7 @@ func (cg *Group) compact(ctx context.Context, dir string, planner Planner, comp
 		compID, e = comp.Compact(dir, toCompactDirs, nil)
 		return e
 	}); err != nil {
-		return false, ulid.ULID{}, halt(errors.Wrapf(err, "compact blocks %v", toCompactDirs))
+		return ulid.ULID{}, nil, halt(errors.Wrapf(err, "compact blocks %v", toCompactDirs))
 	}
 	if compID == (ulid.ULID{}) {
 		// Prometheus compactor found that the compacted block would have no samples.
@@ -1087,8 +1220,7 @@ func (cg *Group) compact(ctx context.Context, dir string, planner Planner, comp
 				}
 			}
 		}
-		// Even though this block was empty, there may be more work to do.
-		return true, ulid.ULID{}, nil
+		return ulid.ULID{}, nil, nil
 	}
 	cg.compactions.Inc()
 	if overlappingBlocks {
@@ -1107,55 +1239,47 @@ func (cg *Group) compact(ctx context.Context, dir string, planner Planner, comp
 		SegmentFiles: block.GetSegmentFiles(bdir),
 	}, nil)
 	if err != nil {
-		return false, ulid.ULID{}, errors.Wrapf(err, "failed to finalize the block %s", bdir)
+		return ulid.ULID{}, nil, errors.Wrapf(err, "failed to finalize the block %s", bdir)
 	}
 
 	if err = os.Remove(filepath.Join(bdir, "tombstones")); err != nil {
-		return false, ulid.ULID{}, errors.Wrap(err, "remove tombstones")
+		return ulid.ULID{}, nil, errors.Wrap(err, "remove tombstones")
 	}
 
 	// Ensure the output block is valid.
 	err = tracing.DoInSpanWithErr(ctx, "compaction_verify_index", func(ctx context.Context) error {
 		return block.VerifyIndex(cg.logger, index, newMeta.MinTime, newMeta.MaxTime)
 	})
-	if !cg.acceptMalformedIndex && err != nil {
-		return false, ulid.ULID{}, halt(errors.Wrapf(err, "invalid result block %s", bdir))
+	if !cg.opts.AcceptMalformedIndex && err != nil {
+		return ulid.ULID{}, nil, haltWithReason(haltReasonCorrupted, errors.Wrapf(err, "invalid result block %s", bdir))
 	}
 
-	// Ensure the output block is not overlapping with anything else,
-	// unless vertical compaction is enabled.
-	if !cg.enableVerticalCompaction {
-		if err := cg.areBlocksOverlapping(newMeta, toCompact...); err != nil {
-			return false, ulid.ULID{}, halt(errors.Wrapf(err, "resulted compacted block %s overlaps with something", bdir))
+	if cg.opts.ResumeCompactions {
+		if err := writeResumeState(cg.logger, bdir, planIDs(toCompact)); err != nil {
+			level.Warn(cg.logger).Log("msg", "failed to write compaction resume state of compacted block", "block", compID, "err", err)
 		}
 	}
+	return compID, newMeta, nil
+}
 
-	begin = time.Now()
+// repairBlock repairs index issues of the downloaded block in place and updates the given stats
+// with stats of the repaired block.
+func (cg *Group) repairBlock(ctx context.Context, bdir string, meta *metadata.Meta, stats *block.HealthStats) error {
+	level.Info(cg.logger).Log("msg", "repairing index issues of block before compaction", "block", meta.ULID,
+		"out_of_order_labels", stats.OutOfOrderLabels, "duplicated_chunks", stats.DuplicatedChunks)
 
-	err = tracing.DoInSpanWithErr(ctx, "compaction_block_upload", func(ctx context.Context) error {
-		return block.Upload(ctx, cg.logger, cg.bkt, bdir, cg.hashFunc, objstore.WithUploadConcurrency(cg.blockFilesConcurrency))
-	})
-	if err != nil {
-		return false, ulid.ULID{}, retry(errors.Wrapf(err, "upload of %s failed", compID))
+	if err := tracing.DoInSpanWithErr(ctx, "compaction_block_repair", func(ctx context.Context) error {
+		return repairBlockInPlace(cg.logger, bdir, meta)
+	}, opentracing.Tags{"block.id": meta.ULID}); err != nil {
+		return err
 	}
-	level.Info(cg.logger).Log("msg", "uploaded block", "result_block", compID, "duration", time.Since(begin), "duration_ms", time.Since(begin).Milliseconds())
-
-	// Mark for deletion the blocks we just compacted from the group and bucket so they do not get included
-	// into the next planning cycle.
-	// Eventually the block we just uploaded should get synced into the group again (including sync-delay).
-	for _, meta := range toCompact {
-		err = tracing.DoInSpanWithErr(ctx, "compaction_block_delete", func(ctx context.Context) error {
-			return cg.deleteBlock(meta.ULID, filepath.Join(dir, meta.ULID.String()))
-		}, opentracing.Tags{"block.id": meta.ULID})
-		if err != nil {
-			return false, ulid.ULID{}, retry(errors.Wrapf(err, "mark old block for deletion from bucket"))
-		}
-		cg.groupGarbageCollectedBlocks.Inc()
+	repaired, err := block.GatherIndexHealthStats(cg.logger, filepath.Join(bdir, block.IndexFilename), meta.MinTime, meta.MaxTime)
+	if err != nil {
+		return errors.Wrapf(err, "gather index issues for repaired block %s", bdir)
 	}
-
-	level.Info(cg.logger).Log("msg", "finished compacting blocks", "result_block", compID, "source_blocks", sourceBlockStr,
-		"duration", time.Since(groupCompactionBegin), "duration_ms", time.Since(groupCompactionBegin).Milliseconds())
-	return true, compID, nil
+	*stats = repaired
+	level.Info(cg.logger).Log("msg", "repaired index issues of block", "block", meta.ULID)
+	return nil
 }
 
 func (cg *Group) deleteBlock(id ulid.ULID, bdir string) error {
@@ -1184,9 +1308,11 @@ type BucketCompactor struct {
 	bkt                            objstore.Bucket
 	concurrency                    int
 	skipBlocksWithOutOfOrderChunks bool
+	scheduler                      *GroupScheduler
+	quarantine                     *GroupQuarantine
 }
 
-// NewBucketCompactor creates a new bucket compactor.
+// NewBucketCompactor creates a new bucket compactor which compacts groups in the order returned by the grouper.
 func NewBucketCompactor(
 	logger log.Logger,
 	sy *Syncer,
@@ -1197,6 +1323,28 @@ func NewBucketCompactor(
 	bkt objstore.Bucket,
 	concurrency int,
 	skipBlocksWithOutOfOrderChunks bool,
+) (*BucketCompactor, error) {
+	scheduler, err := NewGroupScheduler(nil, SchedulingStrategyFixed, "")
+	if err != nil {
+		return nil, err
+	}
+	return NewBucketCompactorWithScheduler(logger, sy, grouper, planner, comp, compactDir, bkt, concurrency, skipBlocksWithOutOfOrderChunks, scheduler, nil)
+}
+
+// NewBucketCompactorWithScheduler creates a new bucket compactor which compacts groups in the order decided by the given scheduler.
+// If quarantine is not nil, groups failing with a halt error are quarantined instead of failing the whole compaction.
+func NewBucketCompactorWithScheduler(
+	logger log.Logger,
+	sy *Syncer,
+	grouper Grouper,
+	planner Planner,
+	comp Compactor,
+	compactDir string,
+	bkt objstore.Bucket,
+	concurrency int,
+	skipBlocksWithOutOfOrderChunks bool,
+	scheduler *GroupScheduler,
+	quarantine *GroupQuarantine,
 ) (*BucketCompactor, error) {
 	if concurrency <= 0 {
 		return nil, errors.Errorf("invalid concurrency level (%d), concurrency level must be > 0", concurrency)
@@ -1211,6 +1359,8 @@ func NewBucketCompactor(
 		bkt:                            bkt,
 		concurrency:                    concurrency,
 		skipBlocksWithOutOfOrderChunks: skipBlocksWithOutOfOrderChunks,
+		scheduler:                      scheduler,
+		quarantine:                     quarantine,
 	}, nil
 }
 
@@ -1282,6 +1432,11 @@ func (c *BucketCompactor) Compact(ctx context.Context) (rerr error) {
 							continue
 						}
 					}
+					// Quarantine the group on critical errors, so other groups can still be compacted.
+					if IsHaltError(err) && c.quarantine != nil {
+						c.quarantine.Add(g.Key(), err)
+						continue
+					}
 					errChan <- errors.Wrapf(err, "group %s", g.Key())
 					return
 				}
@@ -1310,6 +1465,10 @@ func (c *BucketCompactor) Compact(ctx context.Context) (rerr error) {
 			for _, grID := range gr.IDs() {
 				ignoreDirs = append(ignoreDirs, filepath.Join(gr.Key(), grID.String()))
 			}
+			// Keep blocks compacted before an interruption, so their compaction can be resumed.
+			for _, id := range resultBlocksInDir(filepath.Join(c.compactDir, gr.Key())) {
+				ignoreDirs = append(ignoreDirs, filepath.Join(gr.Key(), id.String()))
+			}
 		}
 
 		if err := runutil.DeleteAll(c.compactDir, ignoreDirs...); err != nil {
@@ -1318,14 +1477,21 @@ func (c *BucketCompactor) Compact(ctx context.Context) (rerr error) {
 
 		level.Info(c.logger).Log("msg", "start of compactions")
 
+		// Ignore groups with only one block because there is nothing to compact.
+		toCompact := make([]*Group, 0, len(groups))
+		for _, g := range groups {
+			if len(g.IDs()) > 1 {
+				toCompact = append(toCompact, g)
+			}
+		}
+		if c.quarantine != nil {
+			toCompact = c.quarantine.Filter(toCompact)
+		}
+
 		// Send all groups found during this pass to the compaction workers.
 		var groupErrs errutil.MultiError
 	groupLoop:
-		for _, g := range groups {
-			// Ignore groups with only one block because there is nothing to compact.
-			if len(g.IDs()) == 1 {
-				continue
-			}
+		for _, g := range c.scheduler.Schedule(toCompact) {
 			select {
 			case groupErr := <-errChan:
 				groupErrs.Add(groupErr)
@@ -138,7 +138,7 @@ func TestSyncer_GarbageCollect_e2e(t *testing.T) {
 		testutil.Ok(t, sy.GarbageCollect(ctx))
 
 		// Only the level 3 block, the last source block in both resolutions should be left.
-		grouper := NewDefaultGrouper(nil, bkt, false, false, nil, blocksMarkedForDeletion, garbageCollectedBlocks, blockMarkedForNoCompact, metadata.NoneFunc, 10, 10)
+		grouper := NewDefaultGrouper(nil, bkt, nil, blocksMarkedForDeletion, garbageCollectedBlocks, blockMarkedForNoCompact, GroupOptions{HashFunc: metadata.NoneFunc, BlockFilesConcurrency: 10, CompactBlocksFetchConcurrency: 10})
 		groups, err := grouper.Groups(sy.Metas())
 		testutil.Ok(t, err)
 
@@ -211,7 +211,7 @@ func testGroupCompactE2e(t *testing.T, mergeFunc storage.VerticalChunkSeriesMerg
 		testutil.Ok(t, err)
 
 		planner := NewPlanner(logger, []int64{1000, 3000}, noCompactMarkerFilter)
-		grouper := NewDefaultGrouper(logger, bkt, false, false, reg, blocksMarkedForDeletion, garbageCollectedBlocks, blocksMaredForNoCompact, metadata.NoneFunc, 10, 10)
+		grouper := NewDefaultGrouper(logger, bkt, reg, blocksMarkedForDeletion, garbageCollectedBlocks, blocksMaredForNoCompact, GroupOptions{HashFunc: metadata.NoneFunc, BlockFilesConcurrency: 10, CompactBlocksFetchConcurrency: 10, VerifyChunks: true})
 		bComp, err := NewBucketCompactor(logger, sy, grouper, planner, comp, dir, bkt, 2, true)
 		testutil.Ok(t, err)
 
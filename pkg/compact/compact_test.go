@@ -210,7 +210,7 @@ func TestRetentionProgressCalculate(t *testing.T) {
 
 	var bkt objstore.Bucket
 	temp := promauto.With(reg).NewCounter(prometheus.CounterOpts{Name: "test_metric_for_group", Help: "this is a test metric for compact progress tests"})
-	grouper := NewDefaultGrouper(logger, bkt, false, false, reg, temp, temp, temp, "", 1, 1)
+	grouper := NewDefaultGrouper(logger, bkt, reg, temp, temp, temp, GroupOptions{BlockFilesConcurrency: 1, CompactBlocksFetchConcurrency: 1})
 
 	type groupedResult map[string]float64
 
@@ -376,7 +376,7 @@ func TestCompactProgressCalculate(t *testing.T) {
 
 	var bkt objstore.Bucket
 	temp := promauto.With(reg).NewCounter(prometheus.CounterOpts{Name: "test_metric_for_group", Help: "this is a test metric for compact progress tests"})
-	grouper := NewDefaultGrouper(logger, bkt, false, false, reg, temp, temp, temp, "", 1, 1)
+	grouper := NewDefaultGrouper(logger, bkt, reg, temp, temp, temp, GroupOptions{BlockFilesConcurrency: 1, CompactBlocksFetchConcurrency: 1})
 
 	for _, tcase := range []struct {
 		testName string
@@ -498,7 +498,7 @@ func TestDownsampleProgressCalculate(t *testing.T) {
 
 	var bkt objstore.Bucket
 	temp := promauto.With(reg).NewCounter(prometheus.CounterOpts{Name: "test_metric_for_group", Help: "this is a test metric for downsample progress tests"})
-	grouper := NewDefaultGrouper(logger, bkt, false, false, reg, temp, temp, temp, "", 1, 1)
+	grouper := NewDefaultGrouper(logger, bkt, reg, temp, temp, temp, GroupOptions{BlockFilesConcurrency: 1, CompactBlocksFetchConcurrency: 1})
 
 	for _, tcase := range []struct {
 		testName string
@@ -0,0 +1,134 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+// DeleteDelayConfig is the YAML configuration of delete delay overrides.
+type DeleteDelayConfig struct {
+	Overrides []DeleteDelayOverride `yaml:"overrides"`
+}
+
+// DeleteDelayOverride overrides the default delete delay for blocks of the given resolution
+// whose external labels match the given selector. Empty resolution or matchers match all blocks.
+type DeleteDelayOverride struct {
+	Resolution  string         `yaml:"resolution"`
+	Matchers    string         `yaml:"matchers"`
+	DeleteDelay model.Duration `yaml:"delete_delay"`
+}
+
+var resolutionLevelsByName = map[string]ResolutionLevel{
+	"raw": ResolutionLevelRaw,
+	"5m":  ResolutionLevel5m,
+	"1h":  ResolutionLevel1h,
+}
+
+type deleteDelayRule struct {
+	resolution *ResolutionLevel
+	matchers   []*labels.Matcher
+	delay      time.Duration
+}
+
+func (r deleteDelayRule) matches(m *metadata.Meta) bool {
+	if r.resolution != nil && ResolutionLevel(m.Thanos.Downsample.Resolution) != *r.resolution {
+		return false
+	}
+	for _, matcher := range r.matchers {
+		if !matcher.Matches(m.Thanos.Labels[matcher.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// DeleteDelayPolicy decides how long a block marked for deletion is kept in the bucket before it is deleted.
+// Overrides are evaluated in order and the first matching one wins. Blocks not matching any override use the default delay.
+type DeleteDelayPolicy struct {
+	defaultDelay time.Duration
+	rules        []deleteDelayRule
+}
+
+// NewDeleteDelayPolicy returns a DeleteDelayPolicy that uses the given delay for all blocks.
+func NewDeleteDelayPolicy(defaultDelay time.Duration) *DeleteDelayPolicy {
+	return &DeleteDelayPolicy{defaultDelay: defaultDelay}
+}
+
+// ParseDeleteDelayPolicy parses the YAML delete delay overrides on top of the given default delay.
+func ParseDeleteDelayPolicy(contentYaml []byte, defaultDelay time.Duration) (*DeleteDelayPolicy, error) {
+	p := NewDeleteDelayPolicy(defaultDelay)
+	if len(contentYaml) == 0 {
+		return p, nil
+	}
+
+	var conf DeleteDelayConfig
+	if err := yaml.UnmarshalStrict(contentYaml, &conf); err != nil {
+		return nil, errors.Wrap(err, "parsing delete delay configuration")
+	}
+	for i, o := range conf.Overrides {
+		r := deleteDelayRule{delay: time.Duration(o.DeleteDelay)}
+		if o.Resolution != "" {
+			res, ok := resolutionLevelsByName[o.Resolution]
+			if !ok {
+				return nil, errors.Errorf("override %d: unsupported resolution %q, expected one of raw, 5m, 1h", i, o.Resolution)
+			}
+			r.resolution = &res
+		}
+		if o.Matchers != "" {
+			matchers, err := parser.ParseMetricSelector(o.Matchers)
+			if err != nil {
+				return nil, errors.Wrapf(err, "override %d: parse matchers", i)
+			}
+			r.matchers = matchers
+		}
+		p.rules = append(p.rules, r)
+	}
+	return p, nil
+}
+
+// DeleteDelay returns the delete delay for the given block.
+func (p *DeleteDelayPolicy) DeleteDelay(m *metadata.Meta) time.Duration {
+	for _, r := range p.rules {
+		if r.matches(m) {
+			return r.delay
+		}
+	}
+	return p.defaultDelay
+}
+
+// HasOverrides returns true if any override is configured, which means block metadata is needed to decide the delay.
+func (p *DeleteDelayPolicy) HasOverrides() bool {
+	return len(p.rules) > 0
+}
+
+// MinDeleteDelay returns the shortest delete delay any block can get.
+func (p *DeleteDelayPolicy) MinDeleteDelay() time.Duration {
+	min := p.defaultDelay
+	for _, r := range p.rules {
+		if r.delay < min {
+			min = r.delay
+		}
+	}
+	return min
+}
+
+// MaxDeleteDelay returns the longest delete delay any block can get.
+func (p *DeleteDelayPolicy) MaxDeleteDelay() time.Duration {
+	max := p.defaultDelay
+	for _, r := range p.rules {
+		if r.delay > max {
+			max = r.delay
+		}
+	}
+	return max
+}
@@ -0,0 +1,58 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+func TestDeleteDelayPolicy(t *testing.T) {
+	p, err := ParseDeleteDelayPolicy([]byte(`
+overrides:
+- resolution: raw
+  matchers: '{tenant="bulk"}'
+  delete_delay: 1h
+- matchers: '{tenant=~"gold|silver"}'
+  delete_delay: 14d
+- resolution: 1h
+  delete_delay: 7d
+`), 48*time.Hour)
+	testutil.Ok(t, err)
+	testutil.Assert(t, p.HasOverrides())
+	testutil.Equals(t, time.Hour, p.MinDeleteDelay())
+	testutil.Equals(t, 14*24*time.Hour, p.MaxDeleteDelay())
+
+	meta := func(res ResolutionLevel, lset map[string]string) *metadata.Meta {
+		return &metadata.Meta{Thanos: metadata.Thanos{Labels: lset, Downsample: metadata.ThanosDownsample{Resolution: int64(res)}}}
+	}
+	for _, tcase := range []struct {
+		meta *metadata.Meta
+		want time.Duration
+	}{
+		{meta: meta(ResolutionLevelRaw, map[string]string{"tenant": "bulk"}), want: time.Hour},
+		{meta: meta(ResolutionLevel5m, map[string]string{"tenant": "bulk"}), want: 48 * time.Hour},
+		{meta: meta(ResolutionLevelRaw, map[string]string{"tenant": "gold"}), want: 14 * 24 * time.Hour},
+		{meta: meta(ResolutionLevel1h, map[string]string{"tenant": "silver"}), want: 14 * 24 * time.Hour},
+		{meta: meta(ResolutionLevel1h, map[string]string{"tenant": "bulk"}), want: 7 * 24 * time.Hour},
+		{meta: meta(ResolutionLevelRaw, nil), want: 48 * time.Hour},
+	} {
+		testutil.Equals(t, tcase.want, p.DeleteDelay(tcase.meta))
+	}
+
+	p, err = ParseDeleteDelayPolicy(nil, 48*time.Hour)
+	testutil.Ok(t, err)
+	testutil.Assert(t, !p.HasOverrides())
+	testutil.Equals(t, 48*time.Hour, p.MinDeleteDelay())
+
+	_, err = ParseDeleteDelayPolicy([]byte(`overrides: [{resolution: 2h, delete_delay: 1h}]`), 48*time.Hour)
+	testutil.NotOk(t, err)
+
+	_, err = ParseDeleteDelayPolicy([]byte(`overrides: [{matchers: 'tenant="a"', delete_delay: 1h}]`), 48*time.Hour)
+	testutil.NotOk(t, err)
+}
@@ -10,6 +10,7 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
@@ -48,6 +49,9 @@ const (
 	ResLevel2DownsampleRange = 10 * 24 * 60 * 60 * 1000 // 10 days.
 )
 
+// sampleSize is the in-memory size of a single buffered raw sample, an int64 timestamp and a float64 value.
+const sampleSize = 16
+
 // Downsample downsamples the given block. It writes a new block into dir and returns its ID.
 func Downsample(
 	logger log.Logger,
@@ -55,6 +59,21 @@ func Downsample(
 	b tsdb.BlockReader,
 	dir string,
 	resolution int64,
+) (id ulid.ULID, err error) {
+	return DownsampleWithMemoryBudget(logger, origMeta, b, dir, resolution, 0)
+}
+
+// DownsampleWithMemoryBudget downsamples the given block like Downsample, but bounds the memory used for raw samples
+// of a single series by the given budget in bytes. Raw chunks are read and expanded one at a time, and once the buffered
+// samples exceed the budget, all complete aggregation windows are aggregated into chunks and dropped from the buffer.
+// Zero budget means all samples of a series are buffered before aggregating them.
+func DownsampleWithMemoryBudget(
+	logger log.Logger,
+	origMeta *metadata.Meta,
+	b tsdb.BlockReader,
+	dir string,
+	resolution int64,
+	memoryBudget int64,
 ) (id ulid.ULID, err error) {
 	if origMeta.Thanos.Downsample.Resolution >= resolution {
 		return id, errors.New("target resolution not lower than existing one")
@@ -134,30 +153,40 @@ func Downsample(
 			}
 		}
 
-		// While #183 exists, we sanitize the chunks we retrieved from the block
-		// before retrieving their samples.
-		for i, c := range chks {
-			chk, err := chunkr.Chunk(c)
-			if err != nil {
-				return id, errors.Wrapf(err, "get chunk %d, series %d", c.Ref, postings.At())
-			}
-			chks[i].Chunk = chk
-		}
-
 		// Raw and already downsampled data need different processing.
 		if origMeta.Thanos.Downsample.Resolution == 0 {
+			var downsampled []chunks.Meta
 			for _, c := range chks {
+				// Read chunks one at a time, so only the buffered samples are kept in memory.
+				chk, err := chunkr.Chunk(c)
+				if err != nil {
+					return id, errors.Wrapf(err, "get chunk %d, series %d", c.Ref, postings.At())
+				}
 				// TODO(bwplotka): We can optimze this further by using in WriteSeries iterators of each chunk instead of
 				// samples. Also ensure 120 sample limit, otherwise we have gigantic chunks.
 				// https://github.com/thanos-io/thanos/issues/2542.
-				if err := expandChunkIterator(c.Chunk.Iterator(reuseIt), &all); err != nil {
+				if err := expandChunkIterator(chk.Iterator(reuseIt), &all); err != nil {
 					return id, errors.Wrapf(err, "expand chunk %d, series %d", c.Ref, postings.At())
 				}
+				if memoryBudget > 0 && int64(len(all))*sampleSize > memoryBudget {
+					downsampled = append(downsampled, downsampleCompleteWindows(&all, resolution)...)
+				}
 			}
-			if err := streamedBlockWriter.WriteSeries(lset, DownsampleRaw(all, resolution)); err != nil {
+			downsampled = append(downsampled, DownsampleRaw(all, resolution)...)
+			if err := streamedBlockWriter.WriteSeries(lset, downsampled); err != nil {
 				return id, errors.Wrapf(err, "downsample raw data, series: %d", postings.At())
 			}
 		} else {
+			// While #183 exists, we sanitize the chunks we retrieved from the block
+			// before retrieving their samples.
+			for i, c := range chks {
+				chk, err := chunkr.Chunk(c)
+				if err != nil {
+					return id, errors.Wrapf(err, "get chunk %d, series %d", c.Ref, postings.At())
+				}
+				chks[i].Chunk = chk
+			}
+
 			// Downsample a block that contains aggregated chunks already.
 			for _, c := range chks {
 				ac, ok := c.Chunk.(*AggrChunk)
@@ -208,6 +237,23 @@ func Downsample(
 	return
 }
 
+// downsampleCompleteWindows aggregates buffered samples of all aggregation windows except the last one, which
+// might still get more samples, and removes them from the buffer.
+func downsampleCompleteWindows(buf *[]sample, resolution int64) []chunks.Meta {
+	data := *buf
+	if len(data) == 0 {
+		return nil
+	}
+	lastWindowStart := currentWindow(data[len(data)-1].t, resolution) - resolution + 1
+	complete := sort.Search(len(data), func(i int) bool { return data[i].t >= lastWindowStart })
+	if complete == 0 {
+		return nil
+	}
+	res := DownsampleRaw(data[:complete], resolution)
+	*buf = data[:copy(data, data[complete:])]
+	return res
+}
+
 // currentWindow returns the end timestamp of the window that t falls into.
 func currentWindow(t, r int64) int64 {
 	// The next timestamp is the next number after s.t that's aligned with window.
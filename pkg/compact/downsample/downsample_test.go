@@ -22,16 +22,16 @@ import (
 	"github.com/prometheus/prometheus/tsdb/index"
 	"github.com/prometheus/prometheus/tsdb/tombstones"
 	"github.com/prometheus/prometheus/tsdb/tsdbutil"
-	"go.uber.org/goleak"
 
 	"github.com/efficientgo/core/testutil"
 
 	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/custom"
 )
 
 func TestMain(m *testing.M) {
-	goleak.VerifyTestMain(m)
+	custom.TolerantVerifyLeakMain(m)
 }
 
 func TestDownsampleCounterBoundaryReset(t *testing.T) {
@@ -628,6 +628,93 @@ func TestDownsampleAggrAndNonEmptyXORChunks(t *testing.T) {
 
 }
 
+func TestDownsampleWithMemoryBudget(t *testing.T) {
+	logger := log.NewNopLogger()
+
+	// 40 chunks of 120 samples scraped every 15s, with a counter reset in the middle.
+	var inRaw [][]sample
+	for c := int64(0); c < 40; c++ {
+		var chk []sample
+		for i := int64(0); i < 120; i++ {
+			ts := (c*120 + i) * 15_000
+			chk = append(chk, sample{t: ts, v: float64((c%20)*120 + i)})
+		}
+		inRaw = append(inRaw, chk)
+	}
+
+	downsampled := func(memoryBudget int64) map[AggrType][]float64 {
+		dir := t.TempDir()
+		mb := newMemBlock()
+		mb.addSeries(chunksToSeriesIteratable(t, inRaw, nil))
+
+		id, err := DownsampleWithMemoryBudget(logger, &metadata.Meta{}, mb, dir, ResLevel1, memoryBudget)
+		testutil.Ok(t, err)
+
+		indexr, err := index.NewFileReader(filepath.Join(dir, id.String(), block.IndexFilename))
+		testutil.Ok(t, err)
+		defer func() { testutil.Ok(t, indexr.Close()) }()
+
+		chunkr, err := chunks.NewDirReader(filepath.Join(dir, id.String(), block.ChunksDirname), NewPool())
+		testutil.Ok(t, err)
+		defer func() { testutil.Ok(t, chunkr.Close()) }()
+
+		pall, err := indexr.Postings(index.AllPostingsKey())
+		testutil.Ok(t, err)
+		testutil.Assert(t, pall.Next(), "series expected")
+
+		var builder labels.ScratchBuilder
+		var chks []chunks.Meta
+		testutil.Ok(t, indexr.Series(pall.At(), &builder, &chks))
+
+		res := map[AggrType][]float64{}
+		for _, c := range chks {
+			chk, err := chunkr.Chunk(c)
+			testutil.Ok(t, err)
+			for _, at := range []AggrType{AggrCount, AggrSum, AggrMin, AggrMax} {
+				ac, err := chk.(*AggrChunk).Get(at)
+				testutil.Ok(t, err)
+
+				var buf []sample
+				testutil.Ok(t, expandChunkIterator(ac.Iterator(nil), &buf))
+				for _, s := range buf {
+					res[at] = append(res[at], s.v)
+				}
+			}
+		}
+		return res
+	}
+
+	expected := downsampled(0)
+	testutil.Equals(t, 4800/20, len(expected[AggrCount]))
+	// Budget of 100 samples forces aggregating complete windows many times per series.
+	testutil.Equals(t, expected, downsampled(100*sampleSize))
+}
+
+func TestDownsampleCompleteWindows(t *testing.T) {
+	var buf []sample
+	for ts := int64(0); ts < 3*ResLevel1+60_000; ts += 60_000 {
+		buf = append(buf, sample{t: ts, v: 1})
+	}
+
+	chks := downsampleCompleteWindows(&buf, ResLevel1)
+	var count float64
+	for _, c := range chks {
+		ac, err := c.Chunk.(*AggrChunk).Get(AggrCount)
+		testutil.Ok(t, err)
+		it := ac.Iterator(nil)
+		for it.Next() != chunkenc.ValNone {
+			_, v := it.At()
+			count += v
+		}
+	}
+	testutil.Equals(t, 15.0, count)
+	// Only the sample of the last, possibly incomplete window is left.
+	testutil.Equals(t, []sample{{t: 3 * ResLevel1, v: 1}}, buf)
+
+	testutil.Equals(t, 0, len(downsampleCompleteWindows(&buf, ResLevel1)))
+	testutil.Equals(t, 1, len(buf))
+}
+
 func chunksToSeriesIteratable(t *testing.T, inRaw [][]sample, inAggr []map[AggrType][]sample) *series {
 	if len(inRaw) > 0 && len(inAggr) > 0 {
 		t.Fatalf("test must not have raw and aggregate input data at once")
@@ -0,0 +1,79 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// GroupQuarantine keeps track of compaction groups which failed with a halt error. Instead of halting the whole
+// compactor, quarantined groups are skipped until the compactor is restarted, while other groups are still compacted.
+type GroupQuarantine struct {
+	logger log.Logger
+
+	mtx    sync.Mutex
+	groups map[string]error
+
+	quarantined *prometheus.GaugeVec
+}
+
+// NewGroupQuarantine creates a new GroupQuarantine.
+func NewGroupQuarantine(logger log.Logger, reg prometheus.Registerer) *GroupQuarantine {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return &GroupQuarantine{
+		logger: logger,
+		groups: map[string]error{},
+		quarantined: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_compact_group_quarantined",
+			Help: "Set to 1 for compaction groups which are not compacted anymore due to a critical error, until the compactor is restarted. The reason of the error is corrupted, overlap or unknown.",
+		}, []string{"group", "reason"}),
+	}
+}
+
+// Add quarantines the group with the given key due to the given error.
+func (q *GroupQuarantine) Add(groupKey string, err error) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if _, ok := q.groups[groupKey]; ok {
+		return
+	}
+	reason := haltReason(err)
+	level.Error(q.logger).Log("msg", "critical error detected; quarantining compaction group", "group", groupKey, "reason", reason, "err", err)
+	q.groups[groupKey] = err
+	// The error is only logged, as its message would make the cardinality of the metric unbounded.
+	q.quarantined.WithLabelValues(groupKey, reason).Set(1)
+}
+
+// IsQuarantined returns true if the group with the given key is quarantined.
+func (q *GroupQuarantine) IsQuarantined(groupKey string) bool {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	_, ok := q.groups[groupKey]
+	return ok
+}
+
+// Filter returns the given groups without the quarantined ones.
+func (q *GroupQuarantine) Filter(groups []*Group) []*Group {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	res := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		if _, ok := q.groups[g.Key()]; ok {
+			level.Debug(q.logger).Log("msg", "skipping quarantined compaction group", "group", g.Key())
+			continue
+		}
+		res = append(res, g)
+	}
+	return res
+}
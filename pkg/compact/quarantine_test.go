@@ -0,0 +1,118 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/errutil"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestGroupQuarantine(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	q := NewGroupQuarantine(nil, reg)
+
+	c := prometheus.NewCounter(prometheus.CounterOpts{})
+	newGroup := func(key string) *Group {
+		g, err := NewGroup(nil, nil, key, labels.EmptyLabels(), 0, c, c, c, c, c, c, c, c, GroupOptions{HashFunc: metadata.NoneFunc, BlockFilesConcurrency: 1, CompactBlocksFetchConcurrency: 1})
+		testutil.Ok(t, err)
+		return g
+	}
+	groups := []*Group{newGroup("a"), newGroup("b"), newGroup("c")}
+
+	testutil.Equals(t, groups, q.Filter(groups))
+
+	q.Add("b", haltWithReason(haltReasonOverlap, errors.New("overlaps")))
+	q.Add("b", halt(errors.New("another error")))
+	testutil.Assert(t, q.IsQuarantined("b"), "group b expected to be quarantined")
+	testutil.Assert(t, !q.IsQuarantined("a"), "group a not expected to be quarantined")
+	testutil.Equals(t, []*Group{groups[0], groups[2]}, q.Filter(groups))
+	testutil.Equals(t, 1, promtest.CollectAndCount(q.quarantined))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(q.quarantined.WithLabelValues("b", "overlap")))
+
+	// Errors without reason, or wrapped in multierrors, are labeled by the reason of their halt error.
+	q.Add("c", errors.Wrap(halt(errors.New("compact blocks")), "group c"))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(q.quarantined.WithLabelValues("c", "unknown")))
+	errs := errutil.MultiError{}
+	errs.Add(errors.New("retry"))
+	errs.Add(haltWithReason(haltReasonCorrupted, errors.New("block with unreadable chunks found")))
+	q.Add("d", errs.Err())
+	testutil.Equals(t, 1.0, promtest.ToFloat64(q.quarantined.WithLabelValues("d", "corrupted")))
+}
+
+func TestBucketCompactor_QuarantinesHaltedGroups(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+
+	for _, withQuarantine := range []bool{false, true} {
+		bkt := objstore.NewInMemBucket()
+		dir := t.TempDir()
+
+		// Blocks of tenant "a" overlap, which halts the compaction of their group without vertical compaction.
+		for _, spec := range []struct {
+			tenant     string
+			mint, maxt int64
+		}{
+			{tenant: "a", mint: 0, maxt: 1000},
+			{tenant: "a", mint: 500, maxt: 1000},
+			{tenant: "b", mint: 0, maxt: 1000},
+			{tenant: "b", mint: 1000, maxt: 2000},
+		} {
+			id, err := e2eutil.CreateBlock(ctx, dir, []labels.Labels{labels.FromStrings("a", "1")}, 10, spec.mint, spec.maxt, labels.FromStrings("tenant", spec.tenant), 0, metadata.NoneFunc)
+			testutil.Ok(t, err)
+			testutil.Ok(t, block.Upload(ctx, logger, bkt, filepath.Join(dir, id.String()), metadata.NoneFunc))
+		}
+
+		metaFetcher, err := block.NewMetaFetcher(nil, 32, objstore.WithNoopInstr(bkt), "", nil, nil)
+		testutil.Ok(t, err)
+		c := prometheus.NewCounter(prometheus.CounterOpts{})
+		sy, err := NewMetaSyncer(nil, nil, bkt, metaFetcher, block.NewDeduplicateFilter(1), block.NewIgnoreDeletionMarkFilter(logger, objstore.WithNoopInstr(bkt), 0, 1), c, c)
+		testutil.Ok(t, err)
+		comp, err := tsdb.NewLeveledCompactor(ctx, nil, logger, []int64{1000, 2000}, nil, nil)
+		testutil.Ok(t, err)
+		scheduler, err := NewGroupScheduler(nil, SchedulingStrategyFixed, "")
+		testutil.Ok(t, err)
+
+		var quarantine *GroupQuarantine
+		if withQuarantine {
+			quarantine = NewGroupQuarantine(logger, nil)
+		}
+		grouper := NewDefaultGrouper(logger, bkt, nil, c, c, c, GroupOptions{HashFunc: metadata.NoneFunc, BlockFilesConcurrency: 1, CompactBlocksFetchConcurrency: 1})
+		bComp, err := NewBucketCompactorWithScheduler(logger, sy, grouper, planAll{}, comp, t.TempDir(), bkt, 1, false, scheduler, quarantine)
+		testutil.Ok(t, err)
+
+		err = bComp.Compact(ctx)
+		if !withQuarantine {
+			testutil.Assert(t, IsHaltError(err), "halt error expected without quarantine, got %v", err)
+			continue
+		}
+		testutil.Ok(t, err)
+
+		testutil.Ok(t, sy.SyncMetas(ctx))
+		var compacted int
+		for _, m := range sy.Metas() {
+			if m.Thanos.Labels["tenant"] == "b" && m.Compaction.Level > 1 {
+				compacted++
+			}
+			if m.Thanos.Labels["tenant"] == "a" {
+				testutil.Assert(t, quarantine.IsQuarantined(m.Thanos.GroupKey()), "group of tenant a expected to be quarantined")
+			}
+		}
+		testutil.Equals(t, 1, compacted)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"sort"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+// DefaultRanges are the default time ranges of the blocks produced by each compaction level, starting from level 0.
+var DefaultRanges = []time.Duration{
+	1 * time.Hour,
+	2 * time.Hour,
+	8 * time.Hour,
+	2 * 24 * time.Hour,
+	14 * 24 * time.Hour,
+}
+
+// ValidateRanges checks that the given compaction ranges in milliseconds can be used by the compactor.
+// Ranges have to be positive, increasing and each range has to be a multiple of the previous one, so blocks
+// of one level always fit into a single block of the next level.
+func ValidateRanges(ranges []int64) error {
+	if len(ranges) == 0 {
+		return errors.New("at least one compaction range is required")
+	}
+	for i, r := range ranges {
+		if r <= 0 {
+			return errors.Errorf("compaction range %v has to be positive", time.Duration(r)*time.Millisecond)
+		}
+		if i == 0 {
+			continue
+		}
+		if r <= ranges[i-1] {
+			return errors.Errorf("compaction ranges have to be increasing, got %v after %v", time.Duration(r)*time.Millisecond, time.Duration(ranges[i-1])*time.Millisecond)
+		}
+		if r%ranges[i-1] != 0 {
+			return errors.Errorf("compaction range %v is not a multiple of the previous range %v", time.Duration(r)*time.Millisecond, time.Duration(ranges[i-1])*time.Millisecond)
+		}
+	}
+	return nil
+}
+
+// ValidateBlocksAgainstRanges checks that the given blocks can still be compacted with the given compaction ranges
+// in milliseconds. Every block has to fit within a single time range of the smallest range not shorter than the block.
+// Blocks longer than the biggest range are fine, they are just not compacted further.
+func ValidateBlocksAgainstRanges(metas map[ulid.ULID]*metadata.Meta, ranges []int64) error {
+	var misaligned []ulid.ULID
+	for id, m := range metas {
+		dur := m.MaxTime - m.MinTime
+		i := sort.Search(len(ranges), func(i int) bool { return ranges[i] >= dur })
+		if i == len(ranges) {
+			continue
+		}
+		if rangeStart(m.MinTime, ranges[i]) != rangeStart(m.MaxTime-1, ranges[i]) {
+			misaligned = append(misaligned, id)
+		}
+	}
+	if len(misaligned) == 0 {
+		return nil
+	}
+	sort.Slice(misaligned, func(i, j int) bool { return misaligned[i].Compare(misaligned[j]) < 0 })
+	return errors.Errorf("%d blocks in the bucket cross boundaries of the configured compaction ranges and would never be compacted further, e.g. %v; "+
+		"configure ranges which are multiples of the ranges used so far", len(misaligned), misaligned[0])
+}
+
+// rangeStart returns the start of the time range of the given size the timestamp belongs to.
+func rangeStart(t, size int64) int64 {
+	if t >= 0 {
+		return size * (t / size)
+	}
+	return size * ((t - size + 1) / size)
+}
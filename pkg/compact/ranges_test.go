@@ -0,0 +1,48 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/tsdb"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+func TestValidateRanges(t *testing.T) {
+	h := time.Hour.Milliseconds()
+
+	testutil.Ok(t, ValidateRanges([]int64{2 * h, 8 * h, 48 * h, 30 * 48 * h}))
+	testutil.NotOk(t, ValidateRanges(nil))
+	testutil.NotOk(t, ValidateRanges([]int64{0, 2 * h}))
+	testutil.NotOk(t, ValidateRanges([]int64{8 * h, 2 * h}))
+	testutil.NotOk(t, ValidateRanges([]int64{2 * h, 2 * h}))
+	testutil.NotOk(t, ValidateRanges([]int64{2 * h, 3 * h}))
+}
+
+func TestValidateBlocksAgainstRanges(t *testing.T) {
+	h := time.Hour.Milliseconds()
+	d := 24 * h
+
+	newMetas := func(ranges ...[2]int64) map[ulid.ULID]*metadata.Meta {
+		res := map[ulid.ULID]*metadata.Meta{}
+		for i, r := range ranges {
+			id := ulid.MustNew(uint64(i), nil)
+			res[id] = &metadata.Meta{BlockMeta: tsdb.BlockMeta{ULID: id, MinTime: r[0], MaxTime: r[1]}}
+		}
+		return res
+	}
+
+	// 14d blocks aligned with 14d ranges fit into 28d ranges.
+	testutil.Ok(t, ValidateBlocksAgainstRanges(newMetas([2]int64{0, 2 * h}, [2]int64{14 * d, 28 * d}), []int64{2 * h, 2 * d, 14 * d, 28 * d}))
+	// Blocks bigger than the biggest range are not compacted anymore.
+	testutil.Ok(t, ValidateBlocksAgainstRanges(newMetas([2]int64{14 * d, 28 * d}), []int64{2 * h, 8 * h, 2 * d}))
+	// 14d blocks aligned with 14d ranges can cross 30d boundaries.
+	testutil.NotOk(t, ValidateBlocksAgainstRanges(newMetas([2]int64{28 * d, 42 * d}), []int64{2 * h, 2 * d, 30 * d}))
+	testutil.NotOk(t, ValidateBlocksAgainstRanges(newMetas([2]int64{-h, h}), []int64{2 * h, 8 * h}))
+}
@@ -0,0 +1,71 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+// repairableIndexIssues returns true if the given stats indicate index issues which can be repaired in place
+// during compaction, and no other issues. Those are out of order labels and out of order chunks which are all
+// exact duplicates.
+func repairableIndexIssues(stats block.HealthStats) bool {
+	if stats.CriticalErr() != nil || stats.Issue347OutsideChunksErr() != nil {
+		return false
+	}
+	if stats.OutOfOrderChunks > stats.DuplicatedChunks {
+		return false
+	}
+	return stats.OutOfOrderLabels > 0 || stats.OutOfOrderChunks > 0
+}
+
+// repairBlockInPlace rewrites the downloaded block in the given directory, sorting labels and dropping
+// duplicated chunks. The repaired block keeps the ID of the original block, so it is compacted and
+// marked for deletion as the original one. The original block in the bucket is left untouched.
+func repairBlockInPlace(logger log.Logger, bdir string, meta *metadata.Meta) (err error) {
+	if meta.Thanos.Downsample.Resolution > 0 {
+		return errors.New("cannot repair downsampled block")
+	}
+
+	dir := filepath.Dir(bdir)
+	resid, err := block.Repair(logger, dir, meta.ULID, metadata.CompactorRepairSource, block.IgnoreDuplicateOutsideChunk)
+	resdir := filepath.Join(dir, resid.String())
+	defer func() {
+		if err == nil {
+			return
+		}
+		if rerr := os.RemoveAll(resdir); rerr != nil {
+			level.Warn(logger).Log("msg", "failed to remove repaired block dir", "dir", resdir, "err", rerr)
+		}
+	}()
+	if err != nil {
+		return errors.Wrapf(err, "repair block %s", meta.ULID)
+	}
+
+	if err := block.VerifyIndex(logger, filepath.Join(resdir, block.IndexFilename), meta.MinTime, meta.MaxTime); err != nil {
+		return errors.Wrapf(err, "repaired block is invalid %s", resid)
+	}
+
+	resmeta, err := metadata.ReadFromDir(resdir)
+	if err != nil {
+		return errors.Wrapf(err, "read meta of repaired block %s", resid)
+	}
+	resmeta.ULID = meta.ULID
+	if err := resmeta.WriteToDir(logger, resdir); err != nil {
+		return errors.Wrapf(err, "write meta of repaired block %s", resid)
+	}
+
+	if err := os.RemoveAll(bdir); err != nil {
+		return errors.Wrapf(err, "remove broken block dir %s", bdir)
+	}
+	return errors.Wrapf(os.Rename(resdir, bdir), "replace broken block dir %s", bdir)
+}
@@ -0,0 +1,72 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestRepairableIndexIssues(t *testing.T) {
+	for _, tcase := range []struct {
+		name     string
+		stats    block.HealthStats
+		expected bool
+	}{
+		{name: "healthy", stats: block.HealthStats{}, expected: false},
+		{name: "out of order labels", stats: block.HealthStats{OutOfOrderLabels: 3}, expected: true},
+		{name: "duplicated chunks", stats: block.HealthStats{OutOfOrderSeries: 1, OutOfOrderChunks: 2, DuplicatedChunks: 2}, expected: true},
+		{name: "overlapping chunks which are not duplicates", stats: block.HealthStats{OutOfOrderSeries: 1, OutOfOrderChunks: 2, DuplicatedChunks: 1}, expected: false},
+		{name: "out of order labels and chunks outside of block", stats: block.HealthStats{OutOfOrderLabels: 3, OutsideChunks: 1, CompleteOutsideChunks: 1}, expected: false},
+		{name: "out of order labels and issue 347", stats: block.HealthStats{OutOfOrderLabels: 3, OutsideChunks: 1, Issue347OutsideChunks: 1}, expected: false},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			testutil.Equals(t, tcase.expected, repairableIndexIssues(tcase.stats))
+		})
+	}
+}
+
+func TestRepairBlockInPlace(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	dir := t.TempDir()
+
+	id, err := e2eutil.CreateBlock(ctx, dir, []labels.Labels{
+		labels.FromStrings("a", "1"),
+		labels.FromStrings("a", "2"),
+	}, 10, 0, 1000, labels.FromStrings("ext", "1"), 0, metadata.NoneFunc)
+	testutil.Ok(t, err)
+	bdir := filepath.Join(dir, id.String())
+
+	meta, err := metadata.ReadFromDir(bdir)
+	testutil.Ok(t, err)
+	testutil.Ok(t, repairBlockInPlace(logger, bdir, meta))
+
+	// The repaired block replaces the original one under the same ID.
+	entries, err := os.ReadDir(dir)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(entries))
+	testutil.Equals(t, id.String(), entries[0].Name())
+
+	repaired, err := metadata.ReadFromDir(bdir)
+	testutil.Ok(t, err)
+	testutil.Equals(t, id, repaired.ULID)
+	testutil.Equals(t, metadata.CompactorRepairSource, repaired.Thanos.Source)
+	testutil.Equals(t, meta.Thanos.Labels, repaired.Thanos.Labels)
+	testutil.Equals(t, meta.Stats.NumSeries, repaired.Stats.NumSeries)
+	testutil.Ok(t, block.VerifyIndex(logger, filepath.Join(bdir, block.IndexFilename), meta.MinTime, meta.MaxTime))
+
+	meta.Thanos.Downsample.Resolution = 1000
+	testutil.NotOk(t, repairBlockInPlace(logger, bdir, meta))
+}
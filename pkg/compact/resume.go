@@ -0,0 +1,143 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+// ResumeStateFilename is the name of the file the compactor writes into a local block directory once the block is
+// fully downloaded and verified or fully compacted. It allows to reuse the block after the compactor was interrupted.
+const ResumeStateFilename = "compact-resume-state.json"
+
+// resumeState describes a local block directory which is complete and can be reused by the next compaction attempt.
+type resumeState struct {
+	// CompactedFrom is set for blocks produced by compaction and lists the blocks of the compaction plan.
+	CompactedFrom []ulid.ULID `json:"compacted_from,omitempty"`
+	// Files lists files of the block with their checksums at the time the state was written.
+	Files []metadata.File `json:"files"`
+}
+
+// writeResumeState records the block in the given directory as complete.
+func writeResumeState(logger log.Logger, bdir string, compactedFrom []ulid.ULID) error {
+	files, err := block.GatherFileStats(bdir, metadata.SHA256Func, logger)
+	if err != nil {
+		return errors.Wrap(err, "gather file stats")
+	}
+	b, err := json.Marshal(resumeState{CompactedFrom: sortedULIDs(compactedFrom), Files: files})
+	if err != nil {
+		return errors.Wrap(err, "marshal resume state")
+	}
+
+	path := filepath.Join(bdir, ResumeStateFilename)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(err, "write resume state")
+	}
+	return errors.Wrap(os.Rename(tmp, path), "rename resume state")
+}
+
+// readResumeState returns the resume state of the block in the given directory if the block is still intact,
+// meaning all recorded files exist and match their recorded size and checksum. Otherwise nil is returned.
+func readResumeState(logger log.Logger, bdir string) *resumeState {
+	b, err := os.ReadFile(filepath.Join(bdir, ResumeStateFilename))
+	if err != nil {
+		return nil
+	}
+	var s resumeState
+	if err := json.Unmarshal(b, &s); err != nil {
+		level.Warn(logger).Log("msg", "ignoring unreadable compaction resume state", "dir", bdir, "err", err)
+		return nil
+	}
+	for _, f := range s.Files {
+		p := filepath.Join(bdir, f.RelPath)
+		fi, err := os.Stat(p)
+		if err != nil || (f.SizeBytes > 0 && fi.Size() != f.SizeBytes) {
+			return nil
+		}
+		if f.Hash == nil {
+			continue
+		}
+		h, err := metadata.CalculateHash(p, f.Hash.Func, logger)
+		if err != nil || h.Value != f.Hash.Value {
+			level.Warn(logger).Log("msg", "local block file does not match compaction resume state", "file", p)
+			return nil
+		}
+	}
+	return &s
+}
+
+// findResumableResult looks for a block in the group directory which was already compacted from exactly the given plan.
+func findResumableResult(logger log.Logger, groupDir string, plan []*metadata.Meta) (ulid.ULID, bool) {
+	ids := sortedULIDs(planIDs(plan))
+
+	for _, id := range resultBlocksInDir(groupDir) {
+		s := readResumeState(logger, filepath.Join(groupDir, id.String()))
+		if s == nil || len(s.CompactedFrom) != len(ids) {
+			continue
+		}
+		match := true
+		for i := range ids {
+			if s.CompactedFrom[i] != ids[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return id, true
+		}
+	}
+	return ulid.ULID{}, false
+}
+
+// resultBlocksInDir returns IDs of blocks in the given directory which have resume state of a compacted block.
+// Checksums are not verified.
+func resultBlocksInDir(dir string) []ulid.ULID {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var res []ulid.ULID
+	for _, e := range entries {
+		id, err := ulid.Parse(e.Name())
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name(), ResumeStateFilename))
+		if err != nil {
+			continue
+		}
+		var s resumeState
+		if err := json.Unmarshal(b, &s); err != nil || len(s.CompactedFrom) == 0 {
+			continue
+		}
+		res = append(res, id)
+	}
+	return res
+}
+
+func planIDs(plan []*metadata.Meta) []ulid.ULID {
+	ids := make([]ulid.ULID, 0, len(plan))
+	for _, m := range plan {
+		ids = append(ids, m.ULID)
+	}
+	return ids
+}
+
+func sortedULIDs(ids []ulid.ULID) []ulid.ULID {
+	res := append([]ulid.ULID(nil), ids...)
+	sort.Slice(res, func(i, j int) bool { return res[i].Compare(res[j]) < 0 })
+	return res
+}
@@ -0,0 +1,115 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+type planAll struct{}
+
+func (planAll) Plan(_ context.Context, metasByMinTime []*metadata.Meta) ([]*metadata.Meta, error) {
+	return metasByMinTime, nil
+}
+
+type failingCompactor struct {
+	Compactor
+}
+
+func (failingCompactor) Compact(string, []string, []*tsdb.Block) (ulid.ULID, error) {
+	return ulid.ULID{}, errors.New("interrupted")
+}
+
+func TestResumeState(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	dir := t.TempDir()
+
+	id, err := e2eutil.CreateBlock(ctx, dir, []labels.Labels{labels.FromStrings("a", "1")}, 10, 0, 1000, labels.EmptyLabels(), 0, metadata.NoneFunc)
+	testutil.Ok(t, err)
+	bdir := filepath.Join(dir, id.String())
+
+	testutil.Assert(t, readResumeState(logger, bdir) == nil, "no state expected before it was written")
+
+	source := ulid.MustNew(1, nil)
+	testutil.Ok(t, writeResumeState(logger, bdir, []ulid.ULID{source}))
+	s := readResumeState(logger, bdir)
+	testutil.Assert(t, s != nil, "state expected")
+	testutil.Equals(t, []ulid.ULID{source}, s.CompactedFrom)
+	testutil.Equals(t, []ulid.ULID{id}, resultBlocksInDir(dir))
+
+	found, ok := findResumableResult(logger, dir, []*metadata.Meta{{BlockMeta: tsdb.BlockMeta{ULID: source}}})
+	testutil.Assert(t, ok, "result block expected")
+	testutil.Equals(t, id, found)
+	_, ok = findResumableResult(logger, dir, []*metadata.Meta{{BlockMeta: tsdb.BlockMeta{ULID: ulid.MustNew(2, nil)}}})
+	testutil.Assert(t, !ok, "result block compacted from different plan must not be resumed")
+
+	// Corrupted files invalidate the state.
+	testutil.Ok(t, os.WriteFile(filepath.Join(bdir, block.IndexFilename), []byte("corrupted"), 0600))
+	testutil.Assert(t, readResumeState(logger, bdir) == nil, "state of corrupted block must not be used")
+}
+
+func TestGroupCompact_ResumesWithoutDownloadingSources(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	dir := t.TempDir()
+	bkt := objstore.NewInMemBucket()
+	extLset := labels.FromStrings("tenant", "a")
+
+	var metas []*metadata.Meta
+	for i := 0; i < 2; i++ {
+		id, err := e2eutil.CreateBlock(ctx, dir, []labels.Labels{labels.FromStrings("a", "1")}, 10, int64(i*1000), int64((i+1)*1000), extLset, 0, metadata.NoneFunc)
+		testutil.Ok(t, err)
+		testutil.Ok(t, block.Upload(ctx, logger, bkt, filepath.Join(dir, id.String()), metadata.NoneFunc))
+		m, err := metadata.ReadFromDir(filepath.Join(dir, id.String()))
+		testutil.Ok(t, err)
+		metas = append(metas, m)
+	}
+
+	newGroup := func() *Group {
+		c := prometheus.NewCounter(prometheus.CounterOpts{})
+		g, err := NewGroup(logger, bkt, "key", extLset, 0, c, c, c, c, c, c, c, c, GroupOptions{HashFunc: metadata.NoneFunc, BlockFilesConcurrency: 1, CompactBlocksFetchConcurrency: 1, ResumeCompactions: true})
+		testutil.Ok(t, err)
+		for _, m := range metas {
+			testutil.Ok(t, g.AppendMeta(m))
+		}
+		return g
+	}
+
+	workDir := t.TempDir()
+	_, _, err := newGroup().Compact(ctx, workDir, planAll{}, failingCompactor{})
+	testutil.NotOk(t, err)
+
+	// Break source blocks in the bucket, so the compaction can only succeed with blocks downloaded before.
+	for _, m := range metas {
+		testutil.Ok(t, bkt.Delete(ctx, path.Join(m.ULID.String(), block.MetaFilename)))
+	}
+
+	comp, err := tsdb.NewLeveledCompactor(ctx, nil, logger, []int64{1000, 2000}, nil, nil)
+	testutil.Ok(t, err)
+	shouldRerun, id, err := newGroup().Compact(ctx, workDir, planAll{}, comp)
+	testutil.Ok(t, err)
+	testutil.Assert(t, shouldRerun, "compaction expected")
+
+	exists, err := bkt.Exists(ctx, path.Join(id.String(), block.MetaFilename))
+	testutil.Ok(t, err)
+	testutil.Assert(t, exists, "compacted block expected in the bucket")
+}
@@ -0,0 +1,209 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/tombstones"
+	"gopkg.in/yaml.v3"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compactv2"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// ParseLabelRewrites parses YAML list of label rewrites applied to blocks during compaction.
+func ParseLabelRewrites(contentYaml []byte) ([]metadata.LabelRewrite, error) {
+	var rewrites []metadata.LabelRewrite
+	if err := yaml.Unmarshal(contentYaml, &rewrites); err != nil {
+		return nil, errors.Wrap(err, "parsing label rewrites configuration")
+	}
+	for i, r := range rewrites {
+		if len(r.Matchers) == 0 {
+			return nil, errors.Errorf("label rewrite %d: matchers are required", i)
+		}
+		if r.RenameMetric == "" && len(r.DropLabels) == 0 {
+			return nil, errors.Errorf("label rewrite %d: either rename_metric or drop_labels has to be specified", i)
+		}
+		if r.RenameMetric != "" && !model.IsValidMetricName(model.LabelValue(r.RenameMetric)) {
+			return nil, errors.Errorf("label rewrite %d: invalid metric name %q", i, r.RenameMetric)
+		}
+		for _, l := range r.DropLabels {
+			if l == labels.MetricName {
+				return nil, errors.Errorf("label rewrite %d: metric name cannot be dropped", i)
+			}
+		}
+	}
+	return rewrites, nil
+}
+
+// RewritingCompactor is a Compactor that rewrites series labels of every block produced by the wrapped compactor.
+// This allows to progressively apply schema changes like dropping labels or renaming metrics to historical data.
+// Blocks without any series matching the rewrites are kept as they are.
+type RewritingCompactor struct {
+	Compactor
+
+	logger    log.Logger
+	chunkPool chunkenc.Pool
+	rewrites  []metadata.LabelRewrite
+
+	modifiedSeries prometheus.Counter
+	skippedBlocks  prometheus.Counter
+}
+
+// NewRewritingCompactor returns a RewritingCompactor applying the given label rewrites.
+func NewRewritingCompactor(logger log.Logger, reg prometheus.Registerer, comp Compactor, rewrites []metadata.LabelRewrite) *RewritingCompactor {
+	return &RewritingCompactor{
+		Compactor: comp,
+		logger:    logger,
+		chunkPool: chunkenc.NewPool(),
+		rewrites:  rewrites,
+		modifiedSeries: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_compact_label_rewrite_modified_series_total",
+			Help: "Total number of series modified by label rewrites during compaction.",
+		}),
+		skippedBlocks: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_compact_label_rewrite_skipped_blocks_total",
+			Help: "Total number of compacted blocks not rewritten as none of their series match the label rewrites.",
+		}),
+	}
+}
+
+// Compact runs compaction using the wrapped compactor and rewrites the resulted block.
+// The ULID of the rewritten block is returned, or the one of the resulted block if it was not rewritten.
+func (c *RewritingCompactor) Compact(dest string, dirs []string, open []*tsdb.Block) (ulid.ULID, error) {
+	id, err := c.Compactor.Compact(dest, dirs, open)
+	if err != nil || id == (ulid.ULID{}) {
+		return id, err
+	}
+	newID, err := c.rewrite(dest, id)
+	if err != nil {
+		return ulid.ULID{}, errors.Wrapf(err, "rewrite compacted block %s", id)
+	}
+	return newID, nil
+}
+
+func (c *RewritingCompactor) rewrite(dest string, id ulid.ULID) (_ ulid.ULID, err error) {
+	bdir := filepath.Join(dest, id.String())
+	meta, err := metadata.ReadFromDir(bdir)
+	if err != nil {
+		return ulid.ULID{}, errors.Wrap(err, "read meta")
+	}
+
+	b, err := tsdb.OpenBlock(c.logger, bdir, c.chunkPool)
+	if err != nil {
+		return ulid.ULID{}, errors.Wrap(err, "open block")
+	}
+	closeBlock := func() {
+		if b != nil {
+			runutil.CloseWithErrCapture(&err, b, "close compacted block")
+			b = nil
+		}
+	}
+	defer closeBlock()
+
+	ok, err := c.hasMatchingSeries(b)
+	if err != nil {
+		return ulid.ULID{}, errors.Wrap(err, "match series")
+	}
+	if !ok {
+		level.Debug(c.logger).Log("msg", "no series of compacted block match label rewrites; not rewriting it", "block", id)
+		c.skippedBlocks.Inc()
+		return id, nil
+	}
+
+	newID := ulid.MustNew(ulid.Now(), rand.Reader)
+	newDir := filepath.Join(dest, newID.String())
+	if err := os.MkdirAll(newDir, os.ModePerm); err != nil {
+		return ulid.ULID{}, err
+	}
+
+	ctx := context.Background()
+	d, err := block.NewDiskWriter(ctx, c.logger, newDir)
+	if err != nil {
+		return ulid.ULID{}, err
+	}
+
+	p := compactv2.NewProgressLogger(log.With(c.logger, "block", id), int(meta.Stats.NumSeries))
+	comp := compactv2.New(dest, c.logger, &countingChangeLog{modified: c.modifiedSeries}, c.chunkPool)
+	if err := comp.WriteSeries(ctx, []block.Reader{b}, d, p, compactv2.WithLabelRewriteModifier(c.rewrites...)); err != nil {
+		return ulid.ULID{}, errors.Wrap(err, "write series")
+	}
+
+	meta.Stats, err = d.Flush()
+	if err != nil {
+		return ulid.ULID{}, errors.Wrap(err, "flush")
+	}
+	// Compaction sources stay untouched, so the compactor can still track which blocks were compacted.
+	meta.ULID = newID
+	meta.Thanos.Rewrites = append(meta.Thanos.Rewrites, metadata.Rewrite{
+		Sources:              meta.Compaction.Sources,
+		LabelRewritesApplied: c.rewrites,
+	})
+	if err := meta.WriteToDir(c.logger, newDir); err != nil {
+		return ulid.ULID{}, errors.Wrap(err, "write meta")
+	}
+	if _, err := tombstones.WriteFile(c.logger, newDir, tombstones.NewMemTombstones()); err != nil {
+		return ulid.ULID{}, errors.Wrap(err, "write tombstones")
+	}
+
+	closeBlock()
+	if err != nil {
+		return ulid.ULID{}, err
+	}
+	if err := os.RemoveAll(bdir); err != nil {
+		return ulid.ULID{}, errors.Wrap(err, "remove compacted block before rewrite")
+	}
+	level.Info(c.logger).Log("msg", "rewrote labels of compacted block", "source", id, "new", newID)
+	return newID, nil
+}
+
+// hasMatchingSeries returns whether any series of the block matches the matchers of a rewrite, looking up postings
+// only.
+func (c *RewritingCompactor) hasMatchingSeries(b *tsdb.Block) (_ bool, err error) {
+	ir, err := b.Index()
+	if err != nil {
+		return false, errors.Wrap(err, "open index")
+	}
+	defer runutil.CloseWithErrCapture(&err, ir, "close index")
+
+	for _, r := range c.rewrites {
+		p, err := tsdb.PostingsForMatchers(ir, r.Matchers...)
+		if err != nil {
+			return false, errors.Wrapf(err, "postings for matchers %v", r.Matchers)
+		}
+		if p.Next() {
+			return true, nil
+		}
+		if err := p.Err(); err != nil {
+			return false, errors.Wrapf(err, "postings for matchers %v", r.Matchers)
+		}
+	}
+	return false, nil
+}
+
+type countingChangeLog struct {
+	modified prometheus.Counter
+}
+
+func (l *countingChangeLog) DeleteSeries(labels.Labels, tombstones.Intervals) {}
+
+func (l *countingChangeLog) ModifySeries(labels.Labels, labels.Labels) {
+	l.modified.Inc()
+}
@@ -0,0 +1,150 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestParseLabelRewrites(t *testing.T) {
+	rewrites, err := ParseLabelRewrites([]byte(`
+- matchers: '{__name__="http_requests"}'
+  rename_metric: http_requests_total
+- matchers: '{job=~"api|web"}'
+  drop_labels: [pod_template_hash]
+`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(rewrites))
+	testutil.Equals(t, "http_requests_total", rewrites[0].RenameMetric)
+	testutil.Equals(t, []string{"pod_template_hash"}, rewrites[1].DropLabels)
+
+	for _, c := range []string{
+		`[{rename_metric: a}]`,
+		`[{matchers: '{a="b"}'}]`,
+		`[{matchers: '{a="b"}', rename_metric: "0invalid"}]`,
+		`[{matchers: '{a="b"}', drop_labels: [__name__]}]`,
+	} {
+		_, err := ParseLabelRewrites([]byte(c))
+		testutil.NotOk(t, err, c)
+	}
+}
+
+func TestRewritingCompactor(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	dir := t.TempDir()
+
+	var dirs []string
+	for i := 0; i < 2; i++ {
+		id, err := e2eutil.CreateBlock(ctx, dir, []labels.Labels{
+			labels.FromStrings("__name__", "old", "pod", "a"),
+			labels.FromStrings("__name__", "old", "pod", "b"),
+			labels.FromStrings("__name__", "kept", "pod", "a"),
+		}, 10, int64(i*1000), int64((i+1)*1000), labels.EmptyLabels(), 0, metadata.NoneFunc)
+		testutil.Ok(t, err)
+		dirs = append(dirs, filepath.Join(dir, id.String()))
+	}
+
+	tsdbComp, err := tsdb.NewLeveledCompactor(ctx, nil, logger, []int64{1000, 2000}, nil, nil)
+	testutil.Ok(t, err)
+
+	reg := prometheus.NewRegistry()
+	rewrites, err := ParseLabelRewrites([]byte(`[{matchers: '{__name__="old"}', rename_metric: new, drop_labels: [pod]}]`))
+	testutil.Ok(t, err)
+	comp := NewRewritingCompactor(logger, reg, tsdbComp, rewrites)
+
+	id, err := comp.Compact(dir, dirs, nil)
+	testutil.Ok(t, err)
+
+	bdir := filepath.Join(dir, id.String())
+	_, err = os.Stat(filepath.Join(bdir, "tombstones"))
+	testutil.Ok(t, err)
+
+	meta, err := metadata.ReadFromDir(bdir)
+	testutil.Ok(t, err)
+	testutil.Equals(t, id, meta.ULID)
+	testutil.Equals(t, uint64(2), meta.Stats.NumSeries)
+	testutil.Equals(t, 2, len(meta.Compaction.Sources))
+	testutil.Equals(t, 1, len(meta.Thanos.Rewrites))
+	testutil.Equals(t, 2.0, promtest.ToFloat64(comp.modifiedSeries))
+
+	b, err := tsdb.OpenBlock(logger, bdir, chunkenc.NewPool())
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, b.Close()) }()
+
+	ir, err := b.Index()
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, ir.Close()) }()
+
+	p, err := ir.Postings(index.AllPostingsKey())
+	testutil.Ok(t, err)
+	var (
+		got     []labels.Labels
+		builder labels.ScratchBuilder
+		chks    []chunks.Meta
+	)
+	for p.Next() {
+		testutil.Ok(t, ir.Series(p.At(), &builder, &chks))
+		got = append(got, builder.Labels())
+	}
+	testutil.Ok(t, p.Err())
+	testutil.Equals(t, []labels.Labels{
+		labels.FromStrings("__name__", "kept", "pod", "a"),
+		labels.FromStrings("__name__", "new"),
+	}, got)
+
+	// Block should be readable by the rest of the compaction flow.
+	_, err = block.GatherIndexHealthStats(logger, filepath.Join(bdir, block.IndexFilename), meta.MinTime, meta.MaxTime)
+	testutil.Ok(t, err)
+}
+
+func TestRewritingCompactor_NoMatchingSeries(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	dir := t.TempDir()
+
+	var dirs []string
+	for i := 0; i < 2; i++ {
+		id, err := e2eutil.CreateBlock(ctx, dir, []labels.Labels{
+			labels.FromStrings("__name__", "kept", "pod", "a"),
+		}, 10, int64(i*1000), int64((i+1)*1000), labels.EmptyLabels(), 0, metadata.NoneFunc)
+		testutil.Ok(t, err)
+		dirs = append(dirs, filepath.Join(dir, id.String()))
+	}
+
+	tsdbComp, err := tsdb.NewLeveledCompactor(ctx, nil, logger, []int64{1000, 2000}, nil, nil)
+	testutil.Ok(t, err)
+
+	rewrites, err := ParseLabelRewrites([]byte(`[{matchers: '{__name__="old"}', rename_metric: new}]`))
+	testutil.Ok(t, err)
+	comp := NewRewritingCompactor(logger, prometheus.NewRegistry(), tsdbComp, rewrites)
+
+	id, err := comp.Compact(dir, dirs, nil)
+	testutil.Ok(t, err)
+
+	// The compacted block is kept as it is.
+	meta, err := metadata.ReadFromDir(filepath.Join(dir, id.String()))
+	testutil.Ok(t, err)
+	testutil.Equals(t, id, meta.ULID)
+	testutil.Equals(t, 0, len(meta.Thanos.Rewrites))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(comp.skippedBlocks))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(comp.modifiedSeries))
+}
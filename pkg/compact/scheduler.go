@@ -0,0 +1,122 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// SchedulingStrategyFixed hands compaction groups out in the order returned by the Grouper.
+	SchedulingStrategyFixed = "fixed"
+	// SchedulingStrategyFair interleaves compaction groups of different tenants, preferring tenants
+	// which were served the least so far, so one tenant's backlog cannot starve the others.
+	SchedulingStrategyFair = "fair"
+)
+
+// GroupScheduler decides in which order compaction groups are handed to compaction workers.
+type GroupScheduler struct {
+	strategy    string
+	tenantLabel string
+
+	mtx    sync.Mutex
+	served map[string]uint64
+
+	backlog   *prometheus.GaugeVec
+	scheduled *prometheus.CounterVec
+}
+
+// NewGroupScheduler creates a new GroupScheduler using the given strategy. With fair scheduling, groups are attributed
+// to tenants by the value of the given external label. If it is empty, each external label set is treated as a separate tenant.
+func NewGroupScheduler(reg prometheus.Registerer, strategy, tenantLabel string) (*GroupScheduler, error) {
+	if strategy != SchedulingStrategyFixed && strategy != SchedulingStrategyFair {
+		return nil, errors.Errorf("unsupported compaction scheduling strategy %q", strategy)
+	}
+	return &GroupScheduler{
+		strategy:    strategy,
+		tenantLabel: tenantLabel,
+		served:      map[string]uint64{},
+		backlog: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_compact_group_backlog_blocks",
+			Help: "Number of blocks in the compaction group at the time it was last scheduled.",
+		}, []string{"group"}),
+		scheduled: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_compact_tenant_scheduled_groups_total",
+			Help: "Total number of compaction groups handed to compaction workers per tenant.",
+		}, []string{"tenant"}),
+	}, nil
+}
+
+func (s *GroupScheduler) tenant(g *Group) string {
+	if s.tenantLabel == "" {
+		return g.Labels().String()
+	}
+	return g.Labels().Get(s.tenantLabel)
+}
+
+// Schedule returns the given groups in the order they should be compacted and accounts them as served.
+func (s *GroupScheduler) Schedule(groups []*Group) []*Group {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.backlog.Reset()
+	for _, g := range groups {
+		s.backlog.WithLabelValues(g.Key()).Set(float64(len(g.IDs())))
+	}
+
+	res := groups
+	if s.strategy == SchedulingStrategyFair {
+		res = s.fairOrder(groups)
+	}
+	for _, g := range res {
+		t := s.tenant(g)
+		s.served[t]++
+		s.scheduled.WithLabelValues(t).Inc()
+	}
+	return res
+}
+
+// fairOrder interleaves groups of tenants in round-robin fashion. Tenants served the least so far go first,
+// and within a tenant groups with the biggest backlog go first.
+func (s *GroupScheduler) fairOrder(groups []*Group) []*Group {
+	byTenant := map[string][]*Group{}
+	var tenants []string
+	for _, g := range groups {
+		t := s.tenant(g)
+		if _, ok := byTenant[t]; !ok {
+			tenants = append(tenants, t)
+		}
+		byTenant[t] = append(byTenant[t], g)
+	}
+
+	sort.SliceStable(tenants, func(i, j int) bool {
+		if s.served[tenants[i]] != s.served[tenants[j]] {
+			return s.served[tenants[i]] < s.served[tenants[j]]
+		}
+		return tenants[i] < tenants[j]
+	})
+	for _, t := range tenants {
+		tg := byTenant[t]
+		sort.SliceStable(tg, func(i, j int) bool {
+			return len(tg[i].IDs()) > len(tg[j].IDs())
+		})
+	}
+
+	res := make([]*Group, 0, len(groups))
+	for len(res) < len(groups) {
+		for _, t := range tenants {
+			if len(byTenant[t]) == 0 {
+				continue
+			}
+			res = append(res, byTenant[t][0])
+			byTenant[t] = byTenant[t][1:]
+		}
+	}
+	return res
+}
@@ -0,0 +1,73 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+func newTestGroup(tenant, cluster string, blocks int) *Group {
+	lset := labels.FromStrings("cluster", cluster, "tenant", tenant)
+	g := &Group{key: fmt.Sprintf("0@%s", lset.String()), labels: lset}
+	for i := 0; i < blocks; i++ {
+		g.metasByMinTime = append(g.metasByMinTime, &metadata.Meta{BlockMeta: tsdb.BlockMeta{ULID: ulid.MustNew(uint64(i), nil)}})
+	}
+	return g
+}
+
+func groupKeys(groups []*Group) (keys []string) {
+	for _, g := range groups {
+		keys = append(keys, g.Labels().Get("tenant")+"/"+g.Labels().Get("cluster"))
+	}
+	return keys
+}
+
+func TestGroupScheduler(t *testing.T) {
+	groups := []*Group{
+		newTestGroup("a", "1", 2),
+		newTestGroup("a", "2", 5),
+		newTestGroup("a", "3", 3),
+		newTestGroup("b", "1", 2),
+		newTestGroup("c", "1", 4),
+		newTestGroup("c", "2", 2),
+	}
+
+	t.Run("fixed", func(t *testing.T) {
+		s, err := NewGroupScheduler(nil, SchedulingStrategyFixed, "tenant")
+		testutil.Ok(t, err)
+		testutil.Equals(t, groupKeys(groups), groupKeys(s.Schedule(groups)))
+	})
+
+	t.Run("fair", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		s, err := NewGroupScheduler(reg, SchedulingStrategyFair, "tenant")
+		testutil.Ok(t, err)
+
+		testutil.Equals(t, []string{"a/2", "b/1", "c/1", "a/3", "c/2", "a/1"}, groupKeys(s.Schedule(groups)))
+		testutil.Equals(t, 3.0, promtest.ToFloat64(s.scheduled.WithLabelValues("a")))
+		testutil.Equals(t, 5.0, promtest.ToFloat64(s.backlog.WithLabelValues(groups[1].Key())))
+
+		// Tenant b was served the least, so it goes first in the next pass.
+		testutil.Equals(t, []string{"b/1", "c/1", "a/2"}, groupKeys(s.Schedule([]*Group{groups[1], groups[3], groups[4]})))
+	})
+
+	t.Run("fair without tenant label", func(t *testing.T) {
+		s, err := NewGroupScheduler(nil, SchedulingStrategyFair, "")
+		testutil.Ok(t, err)
+		testutil.Equals(t, 6, len(s.Schedule(groups)))
+	})
+
+	_, err := NewGroupScheduler(nil, "random", "")
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,263 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+)
+
+// SimulationConfig is the configuration of the compactor simulated by Simulate.
+type SimulationConfig struct {
+	// Ranges are the time ranges of the blocks produced by each compaction level in milliseconds, as used by the planner.
+	Ranges                   []int64
+	EnableVerticalCompaction bool
+	DisableDownsampling      bool
+	// RetentionByResolution is the retention of the blocks of each resolution. Zero disables the retention of a resolution.
+	RetentionByResolution map[ResolutionLevel]time.Duration
+}
+
+// SimulatedActionType is the type of an action taken by the compactor.
+type SimulatedActionType string
+
+const (
+	SimulatedCompaction SimulatedActionType = "compaction"
+	SimulatedDownsample SimulatedActionType = "downsample"
+	SimulatedDeletion   SimulatedActionType = "deletion"
+	SimulatedHalt       SimulatedActionType = "halt"
+)
+
+const (
+	simulatedTombstones  = "more than 5% of the series have tombstones"
+	simulatedOverlapping = "vertical compaction of overlapping blocks"
+)
+
+// SimulatedAction is an action the compactor would take.
+type SimulatedAction struct {
+	Type  SimulatedActionType `json:"type"`
+	Group string              `json:"group"`
+	// Blocks are the blocks the action is taken on. They can be blocks produced by previous actions.
+	Blocks []ulid.ULID `json:"blocks"`
+	// Result is the placeholder ID of the block produced by a compaction or downsampling.
+	Result     *ulid.ULID `json:"result,omitempty"`
+	MinTime    int64      `json:"minTime"`
+	MaxTime    int64      `json:"maxTime"`
+	Resolution int64      `json:"resolution"`
+	Reason     string     `json:"reason,omitempty"`
+}
+
+// Simulate returns the actions a single iteration of the compactor with the given configuration would take on the given
+// blocks at the given time, without accessing the bucket: the compactions of each group until nothing is planned anymore,
+// the downsampling of the resulting blocks and the deletion of the blocks exceeding their retention. Blocks marked for no
+// compaction are planned like any other block. If vertical compaction is disabled, the simulation stops at the first group
+// with overlapping blocks, as the compactor would halt.
+func Simulate(ctx context.Context, logger log.Logger, metas []metadata.Meta, conf SimulationConfig, now time.Time) ([]SimulatedAction, error) {
+	if err := ValidateRanges(conf.Ranges); err != nil {
+		return nil, err
+	}
+	planner := NewTSDBBasedPlanner(logger, conf.Ranges)
+	entropy := ulid.Monotonic(rand.New(rand.NewSource(now.UnixNano())), 0)
+
+	groups := map[string][]*metadata.Meta{}
+	for i := range metas {
+		m := metas[i]
+		groups[m.Thanos.GroupKey()] = append(groups[m.Thanos.GroupKey()], &m)
+	}
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var actions []SimulatedAction
+	for _, key := range keys {
+		for {
+			group := groups[key]
+			if len(group) < 2 {
+				break
+			}
+			sort.Slice(group, func(i, j int) bool { return group[i].MinTime < group[j].MinTime })
+
+			overlapping := false
+			if overlaps := tsdb.OverlappingBlocks(blockMetas(group)); len(overlaps) > 0 {
+				if !conf.EnableVerticalCompaction {
+					return append(actions, SimulatedAction{
+						Type:       SimulatedHalt,
+						Group:      key,
+						Blocks:     blockIDs(group),
+						MinTime:    group[0].MinTime,
+						MaxTime:    group[len(group)-1].MaxTime,
+						Resolution: group[0].Thanos.Downsample.Resolution,
+						Reason:     fmt.Sprintf("overlaps found while gathering blocks. %s", overlaps),
+					}), nil
+				}
+				overlapping = true
+			}
+
+			plan, err := planner.Plan(ctx, group)
+			if err != nil {
+				return nil, errors.Wrapf(err, "plan group %s", key)
+			}
+			if len(plan) == 0 {
+				break
+			}
+
+			parents := make([]*tsdb.BlockMeta, 0, len(plan))
+			planned := make(map[ulid.ULID]struct{}, len(plan))
+			for _, p := range plan {
+				parents = append(parents, &p.BlockMeta)
+				planned[p.ULID] = struct{}{}
+			}
+			result := &metadata.Meta{
+				BlockMeta: *tsdb.CompactBlockMetas(ulid.MustNew(ulid.Timestamp(now), entropy), parents...),
+				Thanos: metadata.Thanos{
+					Labels:     plan[0].Thanos.Labels,
+					Downsample: plan[0].Thanos.Downsample,
+					Source:     metadata.CompactorSource,
+				},
+			}
+
+			action := SimulatedAction{
+				Type:       SimulatedCompaction,
+				Group:      key,
+				Blocks:     blockIDs(plan),
+				Result:     &result.ULID,
+				MinTime:    result.MinTime,
+				MaxTime:    result.MaxTime,
+				Resolution: result.Thanos.Downsample.Resolution,
+			}
+			if len(plan) == 1 {
+				action.Reason = simulatedTombstones
+			} else if overlapping && len(tsdb.OverlappingBlocks(blockMetas(plan))) > 0 {
+				action.Reason = simulatedOverlapping
+			}
+			actions = append(actions, action)
+
+			remaining := []*metadata.Meta{result}
+			for _, m := range group {
+				if _, ok := planned[m.ULID]; !ok {
+					remaining = append(remaining, m)
+				}
+			}
+			groups[key] = remaining
+		}
+	}
+
+	var all []*metadata.Meta
+	for _, key := range keys {
+		all = append(all, groups[key]...)
+	}
+
+	if !conf.DisableDownsampling {
+		// Like the compactor, raw blocks are downsampled first, so the resulting blocks can be downsampled further.
+		for _, resolution := range []int64{downsample.ResLevel1, downsample.ResLevel2} {
+			downsampled := simulateDownsample(all, resolution, entropy, now)
+			for _, m := range downsampled {
+				actions = append(actions, SimulatedAction{
+					Type:       SimulatedDownsample,
+					Group:      m.source.Thanos.GroupKey(),
+					Blocks:     []ulid.ULID{m.source.ULID},
+					Result:     &m.result.ULID,
+					MinTime:    m.result.MinTime,
+					MaxTime:    m.result.MaxTime,
+					Resolution: resolution,
+				})
+				all = append(all, m.result)
+			}
+		}
+	}
+
+	for _, m := range all {
+		retention := conf.RetentionByResolution[ResolutionLevel(m.Thanos.Downsample.Resolution)]
+		if retention.Seconds() == 0 {
+			continue
+		}
+		if now.After(time.Unix(m.MaxTime/1000, 0).Add(retention)) {
+			actions = append(actions, SimulatedAction{
+				Type:       SimulatedDeletion,
+				Group:      m.Thanos.GroupKey(),
+				Blocks:     []ulid.ULID{m.ULID},
+				MinTime:    m.MinTime,
+				MaxTime:    m.MaxTime,
+				Resolution: m.Thanos.Downsample.Resolution,
+				Reason:     fmt.Sprintf("block exceeding retention of %v", retention),
+			})
+		}
+	}
+	return actions, nil
+}
+
+type simulatedDownsample struct {
+	source, result *metadata.Meta
+}
+
+// simulateDownsample returns the blocks downsampled to the given resolution, using the same conditions as the compactor:
+// blocks of the previous resolution with sources not downsampled yet and a time range long enough for the resolution.
+func simulateDownsample(metas []*metadata.Meta, resolution int64, entropy io.Reader, now time.Time) []simulatedDownsample {
+	from, minRange := int64(downsample.ResLevel0), int64(downsample.ResLevel1DownsampleRange)
+	if resolution == downsample.ResLevel2 {
+		from, minRange = downsample.ResLevel1, downsample.ResLevel2DownsampleRange
+	}
+
+	sources := map[ulid.ULID]struct{}{}
+	for _, m := range metas {
+		if m.Thanos.Downsample.Resolution != resolution {
+			continue
+		}
+		for _, id := range m.Compaction.Sources {
+			sources[id] = struct{}{}
+		}
+	}
+
+	var res []simulatedDownsample
+	for _, m := range metas {
+		if m.Thanos.Downsample.Resolution != from || m.MaxTime-m.MinTime < minRange {
+			continue
+		}
+		missing := false
+		for _, id := range m.Compaction.Sources {
+			if _, ok := sources[id]; !ok {
+				missing = true
+				break
+			}
+		}
+		if !missing {
+			continue
+		}
+
+		result := *m
+		result.ULID = ulid.MustNew(ulid.Timestamp(now), entropy)
+		result.Thanos.Downsample.Resolution = resolution
+		res = append(res, simulatedDownsample{source: m, result: &result})
+	}
+	return res
+}
+
+func blockMetas(metas []*metadata.Meta) []tsdb.BlockMeta {
+	res := make([]tsdb.BlockMeta, 0, len(metas))
+	for _, m := range metas {
+		res = append(res, m.BlockMeta)
+	}
+	return res
+}
+
+func blockIDs(metas []*metadata.Meta) []ulid.ULID {
+	res := make([]ulid.ULID, 0, len(metas))
+	for _, m := range metas {
+		res = append(res, m.ULID)
+	}
+	return res
+}
@@ -0,0 +1,122 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/tsdb"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+)
+
+func TestSimulate(t *testing.T) {
+	h := time.Hour.Milliseconds()
+	d := 24 * h
+	ranges := []int64{2 * h, 8 * h, 48 * h}
+
+	meta := func(id uint64, mint, maxt int64, resolution int64, lbl string) metadata.Meta {
+		u := ulid.MustNew(id, nil)
+		return metadata.Meta{
+			BlockMeta: tsdb.BlockMeta{
+				ULID:       u,
+				MinTime:    mint,
+				MaxTime:    maxt,
+				Compaction: tsdb.BlockMetaCompaction{Level: 1, Sources: []ulid.ULID{u}},
+			},
+			Thanos: metadata.Thanos{
+				Labels:     map[string]string{"a": lbl},
+				Downsample: metadata.ThanosDownsample{Resolution: resolution},
+			},
+		}
+	}
+	now := time.Unix(0, 0).Add(30 * 24 * time.Hour)
+
+	t.Run("compaction, downsampling and retention", func(t *testing.T) {
+		metas := []metadata.Meta{
+			// Compacted into [0, 8h), the last block is left for the next range.
+			meta(1, 0, 2*h, downsample.ResLevel0, "1"),
+			meta(2, 2*h, 4*h, downsample.ResLevel0, "1"),
+			meta(3, 4*h, 6*h, downsample.ResLevel0, "1"),
+			meta(4, 6*h, 8*h, downsample.ResLevel0, "1"),
+			meta(5, 8*h, 10*h, downsample.ResLevel0, "1"),
+			// Long enough to be downsampled to 5m, but not to 1h.
+			meta(6, 26*d, 28*d, downsample.ResLevel0, "2"),
+			// Already downsampled and exceeding the 5m retention.
+			meta(7, 0, 2*d, downsample.ResLevel0, "3"),
+			meta(8, 0, 2*d, downsample.ResLevel1, "3"),
+		}
+		metas[7].Compaction.Sources = metas[6].Compaction.Sources
+
+		actions, err := Simulate(context.Background(), log.NewNopLogger(), metas, SimulationConfig{
+			Ranges: ranges,
+			RetentionByResolution: map[ResolutionLevel]time.Duration{
+				ResolutionLevel5m: 7 * 24 * time.Hour,
+			},
+		}, now)
+		testutil.Ok(t, err)
+		testutil.Equals(t, 3, len(actions))
+
+		testutil.Equals(t, SimulatedCompaction, actions[0].Type)
+		testutil.Equals(t, metas[0].Thanos.GroupKey(), actions[0].Group)
+		testutil.Equals(t, []ulid.ULID{metas[0].ULID, metas[1].ULID, metas[2].ULID, metas[3].ULID}, actions[0].Blocks)
+		testutil.Equals(t, int64(0), actions[0].MinTime)
+		testutil.Equals(t, 8*h, actions[0].MaxTime)
+
+		testutil.Equals(t, SimulatedDownsample, actions[1].Type)
+		testutil.Equals(t, []ulid.ULID{metas[5].ULID}, actions[1].Blocks)
+		testutil.Equals(t, int64(downsample.ResLevel1), actions[1].Resolution)
+
+		testutil.Equals(t, SimulatedDeletion, actions[2].Type)
+		testutil.Equals(t, []ulid.ULID{metas[7].ULID}, actions[2].Blocks)
+	})
+
+	t.Run("downsampling to both resolutions before retention", func(t *testing.T) {
+		metas := []metadata.Meta{meta(1, 0, 10*d, downsample.ResLevel0, "1")}
+
+		actions, err := Simulate(context.Background(), log.NewNopLogger(), metas, SimulationConfig{
+			Ranges: ranges,
+			RetentionByResolution: map[ResolutionLevel]time.Duration{
+				ResolutionLevelRaw: 24 * time.Hour,
+			},
+		}, now)
+		testutil.Ok(t, err)
+		testutil.Equals(t, 3, len(actions))
+		testutil.Equals(t, SimulatedDownsample, actions[0].Type)
+		testutil.Equals(t, SimulatedDownsample, actions[1].Type)
+		testutil.Equals(t, *actions[0].Result, actions[1].Blocks[0])
+		testutil.Equals(t, int64(downsample.ResLevel2), actions[1].Resolution)
+		testutil.Equals(t, SimulatedDeletion, actions[2].Type)
+		testutil.Equals(t, []ulid.ULID{metas[0].ULID}, actions[2].Blocks)
+	})
+
+	t.Run("overlapping blocks", func(t *testing.T) {
+		metas := []metadata.Meta{
+			meta(1, 0, 2*h, downsample.ResLevel0, "1"),
+			meta(2, h, 3*h, downsample.ResLevel0, "1"),
+		}
+
+		actions, err := Simulate(context.Background(), log.NewNopLogger(), metas, SimulationConfig{Ranges: ranges}, now)
+		testutil.Ok(t, err)
+		testutil.Equals(t, 1, len(actions))
+		testutil.Equals(t, SimulatedHalt, actions[0].Type)
+
+		actions, err = Simulate(context.Background(), log.NewNopLogger(), metas, SimulationConfig{Ranges: ranges, EnableVerticalCompaction: true}, now)
+		testutil.Ok(t, err)
+		testutil.Equals(t, 1, len(actions))
+		testutil.Equals(t, SimulatedCompaction, actions[0].Type)
+		testutil.Equals(t, simulatedOverlapping, actions[0].Reason)
+	})
+
+	t.Run("invalid ranges", func(t *testing.T) {
+		_, err := Simulate(context.Background(), log.NewNopLogger(), nil, SimulationConfig{Ranges: []int64{2 * h, 3 * h}}, now)
+		testutil.NotOk(t, err)
+	})
+}
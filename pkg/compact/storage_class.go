@@ -0,0 +1,120 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/thanos-io/objstore"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/extobjstore"
+)
+
+// StorageClassConfig is the YAML configuration of the storage classes of the blocks uploaded by the compactor.
+type StorageClassConfig struct {
+	Rules []StorageClassRule `yaml:"rules"`
+}
+
+// StorageClassRule sets the storage class of blocks of the given resolution whose data is older than the given age.
+// Empty resolution matches all blocks. Cold marks the storage class as a cold tier, so that the store gateway warns
+// about queries touching the blocks.
+type StorageClassRule struct {
+	Resolution   string         `yaml:"resolution"`
+	MinAge       model.Duration `yaml:"min_age"`
+	StorageClass string         `yaml:"storage_class"`
+	Cold         bool           `yaml:"cold"`
+}
+
+type storageClassRule struct {
+	resolution *ResolutionLevel
+	minAge     time.Duration
+	class      metadata.StorageClass
+}
+
+func (r storageClassRule) matches(m *metadata.Meta, now time.Time) bool {
+	if r.resolution != nil && ResolutionLevel(m.Thanos.Downsample.Resolution) != *r.resolution {
+		return false
+	}
+	return now.Sub(timestamp.Time(m.MaxTime)) >= r.minAge
+}
+
+// StorageClassPolicy decides the storage class of the blocks uploaded by the compactor, from their resolution and
+// the age of their data. Rules are evaluated in order and the first matching one wins. Blocks not matching any rule
+// are uploaded with the storage class of the bucket.
+type StorageClassPolicy struct {
+	rules []storageClassRule
+}
+
+// ParseStorageClassPolicy parses the YAML storage class rules. It returns nil if there are no rules.
+func ParseStorageClassPolicy(contentYaml []byte) (*StorageClassPolicy, error) {
+	if len(contentYaml) == 0 {
+		return nil, nil
+	}
+
+	var conf StorageClassConfig
+	if err := yaml.UnmarshalStrict(contentYaml, &conf); err != nil {
+		return nil, errors.Wrap(err, "parsing storage class configuration")
+	}
+	p := &StorageClassPolicy{}
+	for i, rc := range conf.Rules {
+		if rc.StorageClass == "" {
+			return nil, errors.Errorf("rule %d: storage_class must be set", i)
+		}
+		r := storageClassRule{minAge: time.Duration(rc.MinAge), class: metadata.StorageClass{Name: rc.StorageClass, Cold: rc.Cold}}
+		if rc.Resolution != "" {
+			res, ok := resolutionLevelsByName[rc.Resolution]
+			if !ok {
+				return nil, errors.Errorf("rule %d: unsupported resolution %q, expected one of raw, 5m, 1h", i, rc.Resolution)
+			}
+			r.resolution = &res
+		}
+		p.rules = append(p.rules, r)
+	}
+	if len(p.rules) == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// StorageClass returns the storage class of the given block at the given time, if any rule matches it.
+func (p *StorageClassPolicy) StorageClass(m *metadata.Meta, now time.Time) (metadata.StorageClass, bool) {
+	for _, r := range p.rules {
+		if r.matches(m, now) {
+			return r.class, true
+		}
+	}
+	return metadata.StorageClass{}, false
+}
+
+// UploadBlock uploads the block in bdir like block.Upload. If the policy is not nil, the objects of the block are
+// uploaded with the storage class of the first matching rule, which is recorded in the meta.json file of the block.
+func UploadBlock(ctx context.Context, logger log.Logger, bkt objstore.Bucket, bdir string, hf metadata.HashFunc, p *StorageClassPolicy, options ...objstore.UploadOption) error {
+	if p != nil {
+		meta, err := metadata.ReadFromDir(bdir)
+		if err != nil {
+			return errors.Wrap(err, "read meta")
+		}
+		class, ok := p.StorageClass(meta, time.Now())
+		// Downsampled blocks inherit the storage class of their source block, which may not apply to them.
+		meta.Thanos.StorageClass = nil
+		if ok {
+			meta.Thanos.StorageClass = &class
+			ctx = extobjstore.WithStorageClass(ctx, class.Name)
+			level.Info(logger).Log("msg", "uploading block with storage class", "block", meta.ULID, "storage_class", class.Name, "cold", class.Cold)
+		}
+		if err := meta.WriteToDir(logger, bdir); err != nil {
+			return errors.Wrap(err, "write meta")
+		}
+	}
+	return block.Upload(ctx, logger, bkt, bdir, hf, options...)
+}
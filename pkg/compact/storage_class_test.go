@@ -0,0 +1,95 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestParseStorageClassPolicy(t *testing.T) {
+	p, err := ParseStorageClassPolicy(nil)
+	testutil.Ok(t, err)
+	testutil.Assert(t, p == nil)
+
+	p, err = ParseStorageClassPolicy([]byte(`rules:
+- resolution: 1h
+  min_age: 90d
+  storage_class: COLDLINE
+  cold: true
+- min_age: 30d
+  storage_class: NEARLINE
+`))
+	testutil.Ok(t, err)
+
+	now := time.Now()
+	meta := func(res int64, age time.Duration) *metadata.Meta {
+		m := &metadata.Meta{Thanos: metadata.Thanos{Downsample: metadata.ThanosDownsample{Resolution: res}}}
+		m.MaxTime = timestamp.FromTime(now.Add(-age))
+		return m
+	}
+	for _, tcase := range []struct {
+		meta  *metadata.Meta
+		class metadata.StorageClass
+		ok    bool
+	}{
+		{meta: meta(int64(ResolutionLevel1h), 100*24*time.Hour), class: metadata.StorageClass{Name: "COLDLINE", Cold: true}, ok: true},
+		{meta: meta(int64(ResolutionLevel1h), 50*24*time.Hour), class: metadata.StorageClass{Name: "NEARLINE"}, ok: true},
+		{meta: meta(int64(ResolutionLevelRaw), 100*24*time.Hour), class: metadata.StorageClass{Name: "NEARLINE"}, ok: true},
+		{meta: meta(int64(ResolutionLevel5m), 24*time.Hour)},
+	} {
+		class, ok := p.StorageClass(tcase.meta, now)
+		testutil.Equals(t, tcase.ok, ok)
+		testutil.Equals(t, tcase.class, class)
+	}
+
+	for _, conf := range []string{
+		"rules:\n- min_age: 1d\n",
+		"rules:\n- resolution: 10m\n  storage_class: COLDLINE\n",
+		"rules:\n- storage_class: COLDLINE\n  tier: cold\n",
+	} {
+		_, err := ParseStorageClassPolicy([]byte(conf))
+		testutil.NotOk(t, err)
+	}
+}
+
+func TestUploadBlock_StorageClass(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	bkt := objstore.NewInMemBucket()
+
+	p, err := ParseStorageClassPolicy([]byte("rules:\n- min_age: 90d\n  storage_class: COLDLINE\n  cold: true\n"))
+	testutil.Ok(t, err)
+
+	series := []labels.Labels{labels.FromStrings("a", "1")}
+	oldMaxt := timestamp.FromTime(time.Now().Add(-100 * 24 * time.Hour))
+	oldID, err := e2eutil.CreateBlock(ctx, dir, series, 10, oldMaxt-1000, oldMaxt, labels.FromStrings("ext", "1"), 0, metadata.NoneFunc)
+	testutil.Ok(t, err)
+	newMaxt := timestamp.FromTime(time.Now())
+	newID, err := e2eutil.CreateBlock(ctx, dir, series, 10, newMaxt-1000, newMaxt, labels.FromStrings("ext", "1"), 0, metadata.NoneFunc)
+	testutil.Ok(t, err)
+
+	testutil.Ok(t, UploadBlock(ctx, log.NewNopLogger(), bkt, filepath.Join(dir, oldID.String()), metadata.NoneFunc, p))
+	testutil.Ok(t, UploadBlock(ctx, log.NewNopLogger(), bkt, filepath.Join(dir, newID.String()), metadata.NoneFunc, p))
+
+	meta, err := block.DownloadMeta(ctx, log.NewNopLogger(), bkt, oldID)
+	testutil.Ok(t, err)
+	testutil.Equals(t, &metadata.StorageClass{Name: "COLDLINE", Cold: true}, meta.Thanos.StorageClass)
+
+	meta, err = block.DownloadMeta(ctx, log.NewNopLogger(), bkt, newID)
+	testutil.Ok(t, err)
+	testutil.Assert(t, meta.Thanos.StorageClass == nil)
+}
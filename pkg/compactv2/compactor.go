@@ -51,6 +51,8 @@ type Compactor struct {
 	changeLogger ChangeLogger
 
 	dryRun bool
+	// dryRunStats are the stats of the block the last dry run would have written.
+	dryRunStats tsdb.BlockStats
 }
 
 type seriesReader struct {
@@ -73,6 +75,11 @@ func NewDryRun(tmpDir string, logger log.Logger, changeLogger ChangeLogger, pool
 	return s
 }
 
+// DryRunStats returns the stats of the block the last dry run would have written.
+func (w *Compactor) DryRunStats() tsdb.BlockStats {
+	return w.dryRunStats
+}
+
 // TODO(bwplotka): Upstream this.
 func (w *Compactor) WriteSeries(ctx context.Context, readers []block.Reader, sWriter block.Writer, p ProgressLogger, modifiers ...Modifier) (err error) {
 	if len(readers) == 0 {
@@ -122,6 +129,7 @@ func (w *Compactor) WriteSeries(ctx context.Context, readers []block.Reader, sWr
 	}
 
 	if w.dryRun {
+		w.dryRunStats = tsdb.BlockStats{}
 		// Even for dry run, we need to exhaust iterators to see potential changes.
 		for set.Next() {
 			select {
@@ -132,11 +140,19 @@ func (w *Compactor) WriteSeries(ctx context.Context, readers []block.Reader, sWr
 
 			s := set.At()
 			iter := s.Iterator(nil)
+			var chks uint64
 			for iter.Next() {
+				chks++
+				w.dryRunStats.NumSamples += uint64(iter.At().Chunk.NumSamples())
 			}
 			if err := iter.Err(); err != nil {
 				level.Error(w.logger).Log("msg", "error while iterating over chunks", "series", s.Labels(), "err", err)
 			}
+			// Series with all chunks deleted are not written.
+			if chks > 0 {
+				w.dryRunStats.NumSeries++
+				w.dryRunStats.NumChunks += chks
+			}
 			p.SeriesProcessed()
 		}
 		if err := set.Err(); err != nil {
@@ -6,6 +6,7 @@ package compactv2
 import (
 	"bytes"
 	"context"
+	"io"
 	"math"
 	"os"
 	"path/filepath"
@@ -587,6 +588,38 @@ func TestCompactor_WriteSeries_e2e(t *testing.T) {
 				NumChunks:  1,
 			},
 		},
+		{
+			name: "1 block + label rewrite modifier, metric renamed and label dropped, series merged",
+			input: [][]seriesSamples{
+				{
+					{lset: labels.Labels{{Name: "__name__", Value: "old"}, {Name: "a", Value: "1"}, {Name: "pod", Value: "x"}},
+						chunks: [][]sample{{{1, 1}, {2, 2}}}},
+					{lset: labels.Labels{{Name: "__name__", Value: "old"}, {Name: "a", Value: "1"}, {Name: "pod", Value: "y"}},
+						chunks: [][]sample{{{3, 3}, {4, 4}}}},
+					{lset: labels.Labels{{Name: "__name__", Value: "other"}, {Name: "pod", Value: "z"}},
+						chunks: [][]sample{{{1, 1}}}},
+				},
+			},
+			modifiers: []Modifier{WithLabelRewriteModifier(
+				metadata.LabelRewrite{
+					Matchers:     []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "old")},
+					RenameMetric: "new",
+					DropLabels:   []string{"pod"},
+				},
+			)},
+			expected: []seriesSamples{
+				{lset: labels.Labels{{Name: "__name__", Value: "new"}, {Name: "a", Value: "1"}},
+					chunks: [][]sample{{{1, 1}, {2, 2}, {3, 3}, {4, 4}}}},
+				{lset: labels.Labels{{Name: "__name__", Value: "other"}, {Name: "pod", Value: "z"}},
+					chunks: [][]sample{{{1, 1}}}},
+			},
+			expectedChanges: "Relabelled {__name__=\"old\", a=\"1\", pod=\"x\"} {__name__=\"new\", a=\"1\"}\nRelabelled {__name__=\"old\", a=\"1\", pod=\"y\"} {__name__=\"new\", a=\"1\"}\n",
+			expectedStats: tsdb.BlockStats{
+				NumSamples: 5,
+				NumSeries:  2,
+				NumChunks:  2,
+			},
+		},
 	} {
 		t.Run(tcase.name, func(t *testing.T) {
 			tmpDir := t.TempDir()
@@ -636,6 +669,11 @@ func TestCompactor_WriteSeries_e2e(t *testing.T) {
 			testutil.Equals(t, tcase.expectedChanges, changes.String())
 			testutil.Equals(t, tcase.expectedStats, stats)
 			testutil.Equals(t, tcase.expected, readBlockSeries(t, filepath.Join(tmpDir, id.String())))
+
+			// A dry run reports the stats of the block it would write.
+			dry := NewDryRun(tmpDir, logger, NewChangeLog(io.Discard), chunkPool)
+			testutil.Ok(t, dry.WriteSeries(ctx, blocks, d, NewProgressLogger(logger, series), tcase.modifiers...))
+			testutil.Equals(t, tcase.expectedStats, dry.DryRunStats())
 		})
 	}
 }
@@ -363,6 +363,51 @@ func WithRelabelModifier(relabels ...*relabel.Config) *RelabelModifier {
 }
 
 func (d *RelabelModifier) Modify(_ index.StringIter, set storage.ChunkSeriesSet, log ChangeLogger, p ProgressLogger) (index.StringIter, storage.ChunkSeriesSet) {
+	return modifyLabels(set, log, p, func(lbls labels.Labels) labels.Labels {
+		// The labels have to be copied because `relabel.Process` is now overwriting the original
+		// labels to same memory. This happens since Prometheus v2.39.0.
+		processedLabels, _ := relabel.Process(lbls.Copy(), d.relabels...)
+		return processedLabels
+	})
+}
+
+type LabelRewriteModifier struct {
+	rewrites []metadata.LabelRewrite
+}
+
+func WithLabelRewriteModifier(rewrites ...metadata.LabelRewrite) *LabelRewriteModifier {
+	return &LabelRewriteModifier{rewrites: rewrites}
+}
+
+func (d *LabelRewriteModifier) Modify(_ index.StringIter, set storage.ChunkSeriesSet, log ChangeLogger, p ProgressLogger) (index.StringIter, storage.ChunkSeriesSet) {
+	return modifyLabels(set, log, p, func(lbls labels.Labels) labels.Labels {
+		for _, r := range d.rewrites {
+			if !matchesAll(r.Matchers, lbls) {
+				continue
+			}
+			b := labels.NewBuilder(lbls)
+			if r.RenameMetric != "" {
+				b.Set(labels.MetricName, r.RenameMetric)
+			}
+			b.Del(r.DropLabels...)
+			lbls = b.Labels()
+		}
+		return lbls
+	})
+}
+
+func matchesAll(ms []*labels.Matcher, lbls labels.Labels) bool {
+	for _, m := range ms {
+		if !m.Matches(lbls.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// modifyLabels applies the given function to labels of each series in the set. Series that end up with
+// the same labels are merged and series left without any label are deleted.
+func modifyLabels(set storage.ChunkSeriesSet, log ChangeLogger, p ProgressLogger, modify func(labels.Labels) labels.Labels) (index.StringIter, storage.ChunkSeriesSet) {
 	// Gather symbols.
 	symbols := make(map[string]struct{})
 	chunkSeriesMap := make(map[string]*mergeChunkSeries)
@@ -372,9 +417,7 @@ func (d *RelabelModifier) Modify(_ index.StringIter, set storage.ChunkSeriesSet,
 		lbls := s.Labels()
 		chksIter := s.Iterator(nil)
 
-		// The labels have to be copied because `relabel.Process` is now overwriting the original
-		// labels to same memory. This happens since Prometheus v2.39.0.
-		if processedLabels, _ := relabel.Process(lbls.Copy(), d.relabels...); len(processedLabels) == 0 {
+		if processedLabels := modify(lbls); len(processedLabels) == 0 {
 			// Special case: Delete whole series if no labels are present.
 			var (
 				minT int64 = math.MaxInt64
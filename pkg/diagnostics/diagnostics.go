@@ -0,0 +1,587 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package diagnostics captures profiles of the process when it is under resource pressure, and uploads them to object
+// storage, so that they are available for post-mortem analysis even if the process is killed shortly after.
+package diagnostics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/units"
+	extflag "github.com/efficientgo/tools/extkingpin"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/version"
+	"github.com/thanos-io/objstore"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/extkingpin"
+	"github.com/thanos-io/thanos/pkg/extobjstore"
+	"github.com/thanos-io/thanos/pkg/memlimit"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+const (
+	// ProfileHeap is the profile of the memory allocated by live objects.
+	ProfileHeap = "heap"
+	// ProfileAllocs is the profile of all past memory allocations.
+	ProfileAllocs = "allocs"
+	// ProfileGoroutine is the profile of the stack traces of all goroutines.
+	ProfileGoroutine = "goroutine"
+	// ProfileCPU is the profile of the CPU usage during the configured duration.
+	ProfileCPU = "cpu"
+
+	// MetadataFile is the name of the file describing a capture.
+	MetadataFile = "meta.json"
+)
+
+const (
+	triggerMemory     = "memory"
+	triggerGoroutines = "goroutines"
+	triggerLatency    = "latency"
+)
+
+// Config configures the capture of profiles on resource pressure.
+type Config struct {
+	// Directory is the directory profiles are written to. Captures are removed once uploaded, and the oldest captures
+	// not uploaded are removed when there are more than MaxLocalCaptures of them.
+	Directory string `yaml:"directory"`
+	// Bucket is the configuration of the object storage profiles are uploaded to. Profiles are only kept locally
+	// if not set.
+	Bucket interface{} `yaml:"bucket"`
+	// Prefix is the prefix of the objects of the profiles in the bucket.
+	Prefix string `yaml:"prefix"`
+	// CheckInterval is the interval between checks of the triggers.
+	CheckInterval model.Duration `yaml:"check_interval"`
+	// MinInterval is the minimum time between two captures, limiting their rate while the pressure lasts.
+	MinInterval model.Duration `yaml:"min_interval"`
+	// Profiles are the profiles captured, among heap, allocs, goroutine and cpu.
+	Profiles []string `yaml:"profiles"`
+	// CPUProfileDuration is the duration of the CPU profile.
+	CPUProfileDuration model.Duration `yaml:"cpu_profile_duration"`
+	// MaxLocalCaptures is the maximum number of captures kept in the directory, e.g. when no bucket is configured or
+	// their upload failed.
+	MaxLocalCaptures int `yaml:"max_local_captures"`
+	// Triggers are the thresholds whose crossing triggers a capture.
+	Triggers TriggersConfig `yaml:"triggers"`
+}
+
+// TriggersConfig configures the thresholds triggering a capture. Unset thresholds are not checked.
+type TriggersConfig struct {
+	// MemoryBytes is the maximum memory obtained from the OS by the Go runtime and not released to it.
+	MemoryBytes units.Base2Bytes `yaml:"memory_bytes"`
+	// MemoryLimitRatio is the maximum ratio of the memory obtained from the OS by the Go runtime to the memory
+	// limit of the container, or to the memory limit of the Go runtime (GOMEMLIMIT) if the container is not limited.
+	MemoryLimitRatio float64 `yaml:"memory_limit_ratio"`
+	// Goroutines is the maximum number of goroutines.
+	Goroutines int `yaml:"goroutines"`
+	// Latency are the maximum latencies of requests, computed from histograms of the component.
+	Latency []LatencyTrigger `yaml:"latency"`
+}
+
+// LatencyTrigger triggers a capture when a quantile of the latencies observed by a histogram of the component since
+// the previous check exceeds the threshold.
+type LatencyTrigger struct {
+	// Metric is the name of the histogram, e.g. http_request_duration_seconds. Its observations are in seconds.
+	Metric string `yaml:"metric"`
+	// Quantile is the quantile of the latencies compared to the threshold.
+	Quantile float64 `yaml:"quantile"`
+	// Threshold is the maximum latency.
+	Threshold model.Duration `yaml:"threshold"`
+}
+
+// DefaultConfig returns the diagnostics configuration with the default values set.
+func DefaultConfig() Config {
+	return Config{
+		Directory:          "./diagnostics",
+		CheckInterval:      model.Duration(5 * time.Second),
+		MinInterval:        model.Duration(15 * time.Minute),
+		Profiles:           []string{ProfileHeap, ProfileGoroutine, ProfileCPU},
+		CPUProfileDuration: model.Duration(10 * time.Second),
+		MaxLocalCaptures:   10,
+	}
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultConfig()
+	type plain Config
+	return unmarshal((*plain)(c))
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (t *LatencyTrigger) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*t = LatencyTrigger{Quantile: 0.99}
+	type plain LatencyTrigger
+	return unmarshal((*plain)(t))
+}
+
+// ParseConfig parses and validates the YAML diagnostics configuration.
+func ParseConfig(content []byte) (Config, error) {
+	conf := DefaultConfig()
+	if err := yaml.UnmarshalStrict(content, &conf); err != nil {
+		return Config{}, errors.Wrap(err, "parsing diagnostics config YAML")
+	}
+	if conf.Directory == "" {
+		return Config{}, errors.New("directory of the diagnostics config must be set")
+	}
+	if conf.CheckInterval <= 0 || conf.MinInterval < 0 || conf.CPUProfileDuration <= 0 {
+		return Config{}, errors.New("check_interval and cpu_profile_duration of the diagnostics config must be positive, and min_interval must not be negative")
+	}
+	if len(conf.Profiles) == 0 {
+		return Config{}, errors.New("profiles of the diagnostics config must not be empty")
+	}
+	for _, p := range conf.Profiles {
+		switch p {
+		case ProfileHeap, ProfileAllocs, ProfileGoroutine, ProfileCPU:
+		default:
+			return Config{}, errors.Errorf("unknown profile %q in the diagnostics config, must be one of heap, allocs, goroutine or cpu", p)
+		}
+	}
+	if conf.Triggers.MemoryLimitRatio < 0 || conf.Triggers.MemoryLimitRatio > 1 {
+		return Config{}, errors.Errorf("memory_limit_ratio of the diagnostics config must be in [0, 1], got %v", conf.Triggers.MemoryLimitRatio)
+	}
+	for _, l := range conf.Triggers.Latency {
+		if l.Metric == "" || l.Threshold <= 0 || l.Quantile <= 0 || l.Quantile > 1 {
+			return Config{}, errors.Errorf("latency trigger of metric %q of the diagnostics config needs a metric, a positive threshold and a quantile in (0, 1]", l.Metric)
+		}
+	}
+	if conf.Triggers.MemoryBytes == 0 && conf.Triggers.MemoryLimitRatio == 0 && conf.Triggers.Goroutines == 0 && len(conf.Triggers.Latency) == 0 {
+		return Config{}, errors.New("at least one trigger of the diagnostics config must be set")
+	}
+	return conf, nil
+}
+
+// Metadata describes a capture. It is written along with its profiles.
+type Metadata struct {
+	Component string    `json:"component"`
+	Hostname  string    `json:"hostname"`
+	Version   string    `json:"version"`
+	GoVersion string    `json:"go_version"`
+	Time      time.Time `json:"time"`
+	// Trigger is the trigger of the capture, either memory, goroutines or latency.
+	Trigger string `json:"trigger"`
+	// Reason describes the threshold which was crossed.
+	Reason string `json:"reason"`
+	// Values are the values checked by the triggers when the capture was triggered.
+	Values   map[string]float64 `json:"values"`
+	Profiles []string           `json:"profiles"`
+}
+
+// Diagnostics captures profiles when the thresholds of its triggers are crossed.
+type Diagnostics struct {
+	logger    log.Logger
+	conf      Config
+	component string
+	hostname  string
+	gatherer  prometheus.Gatherer
+	bkt       objstore.Bucket
+
+	memoryLimit uint64
+	lastCapture time.Time
+	// latencies are the cumulative buckets of the histograms of the latency triggers at the previous check.
+	latencies map[string]cumulativeBuckets
+
+	captures        *prometheus.CounterVec
+	skipped         *prometheus.CounterVec
+	captureFailures prometheus.Counter
+}
+
+// Flags are the flags of the diagnostics configuration, registered on the commands of the long-running components.
+type Flags struct {
+	configs []*extflag.PathOrContent
+}
+
+// RegisterFlags registers the flags of the diagnostics configuration on the command of a component.
+func (f *Flags) RegisterFlags(cmd extkingpin.FlagClause) {
+	f.configs = append(f.configs, extkingpin.RegisterDiagnosticsFlags(cmd))
+}
+
+// Content returns the diagnostics configuration given to the command which was run, or nil if none was given. The
+// flags of the other commands are not set.
+func (f *Flags) Content() ([]byte, error) {
+	for _, c := range f.configs {
+		content, err := c.Content()
+		if err != nil {
+			return nil, err
+		}
+		if len(content) > 0 {
+			return content, nil
+		}
+	}
+	return nil, nil
+}
+
+// New returns Diagnostics of the given component configured with the given YAML configuration. Latency triggers read
+// the histograms of the given gatherer.
+func New(logger log.Logger, reg prometheus.Registerer, gatherer prometheus.Gatherer, confContentYaml []byte, component string) (*Diagnostics, error) {
+	conf, err := ParseConfig(confContentYaml)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Diagnostics{
+		logger:    log.With(logger, "component", "diagnostics"),
+		conf:      conf,
+		component: strings.ReplaceAll(component, " ", "-"),
+		gatherer:  gatherer,
+		latencies: map[string]cumulativeBuckets{},
+		captures: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_diagnostics_captures_total",
+			Help: "Total number of captures of profiles, by trigger.",
+		}, []string{"trigger"}),
+		skipped: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_diagnostics_captures_skipped_total",
+			Help: "Total number of captures skipped because of the minimum interval between captures, by trigger.",
+		}, []string{"trigger"}),
+		captureFailures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_diagnostics_capture_failures_total",
+			Help: "Total number of captures which failed to be written or uploaded.",
+		}),
+	}
+	if d.hostname, err = os.Hostname(); err != nil {
+		d.hostname = "unknown"
+	}
+
+	if conf.Triggers.MemoryLimitRatio > 0 {
+		containerLimit, err := memlimit.ContainerLimit(memlimit.CgroupRoot)
+		if err != nil {
+			return nil, errors.Wrap(err, "read container memory limit")
+		}
+		d.memoryLimit = containerLimit
+		// A negative value reads the limit without changing it.
+		if goLimit := debug.SetMemoryLimit(-1); d.memoryLimit == 0 && goLimit != math.MaxInt64 {
+			d.memoryLimit = uint64(goLimit)
+		}
+		if d.memoryLimit == 0 {
+			level.Warn(d.logger).Log("msg", "memory_limit_ratio trigger is ignored, as neither the container nor the Go runtime have a memory limit")
+		}
+	}
+
+	if conf.Bucket != nil {
+		bucketConf, err := yaml.Marshal(conf.Bucket)
+		if err != nil {
+			return nil, errors.Wrap(err, "marshal bucket of the diagnostics config")
+		}
+		// The metrics of the bucket client would clash with the ones of the bucket of the component.
+		if d.bkt, err = extobjstore.NewBucket(logger, bucketConf, nil, component); err != nil {
+			return nil, errors.Wrap(err, "create diagnostics bucket")
+		}
+	}
+	return d, nil
+}
+
+// Run checks the triggers every check interval, and captures profiles when one of them fires, until the context is
+// canceled.
+func (d *Diagnostics) Run(ctx context.Context) error {
+	if d.bkt != nil {
+		defer runutil.CloseWithLogOnErr(d.logger, d.bkt, "diagnostics bucket")
+	}
+
+	ticker := time.NewTicker(time.Duration(d.conf.CheckInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		trigger, reason, values := d.check()
+		if trigger == "" {
+			continue
+		}
+		if !d.lastCapture.IsZero() && time.Since(d.lastCapture) < time.Duration(d.conf.MinInterval) {
+			d.skipped.WithLabelValues(trigger).Inc()
+			continue
+		}
+		d.lastCapture = time.Now()
+		d.captures.WithLabelValues(trigger).Inc()
+
+		level.Warn(d.logger).Log("msg", "capturing profiles", "trigger", trigger, "reason", reason)
+		if err := d.capture(ctx, Metadata{Trigger: trigger, Reason: reason, Values: values}); err != nil {
+			d.captureFailures.Inc()
+			level.Error(d.logger).Log("msg", "failed to capture profiles", "trigger", trigger, "err", err)
+		}
+	}
+}
+
+// check returns the first trigger whose threshold is crossed, if any, with the reason and the values checked.
+func (d *Diagnostics) check() (trigger, reason string, values map[string]float64) {
+	t := d.conf.Triggers
+	values = map[string]float64{}
+
+	if t.MemoryBytes > 0 || d.memoryLimit > 0 {
+		memory := memoryInUse()
+		values["memory_bytes"] = float64(memory)
+		if t.MemoryBytes > 0 && memory > uint64(t.MemoryBytes) {
+			trigger, reason = triggerMemory, fmt.Sprintf("memory %s exceeds %s", units.Base2Bytes(memory), t.MemoryBytes)
+		}
+		if d.memoryLimit > 0 {
+			values["memory_limit_bytes"] = float64(d.memoryLimit)
+			if ratio := float64(memory) / float64(d.memoryLimit); trigger == "" && ratio > t.MemoryLimitRatio {
+				trigger, reason = triggerMemory, fmt.Sprintf("memory %s is %.0f%% of the %s limit", units.Base2Bytes(memory), 100*ratio, units.Base2Bytes(d.memoryLimit))
+			}
+		}
+	}
+
+	if t.Goroutines > 0 {
+		goroutines := runtime.NumGoroutine()
+		values["goroutines"] = float64(goroutines)
+		if trigger == "" && goroutines > t.Goroutines {
+			trigger, reason = triggerGoroutines, fmt.Sprintf("%d goroutines exceed %d", goroutines, t.Goroutines)
+		}
+	}
+
+	if len(t.Latency) > 0 {
+		families, err := d.gatherer.Gather()
+		if err != nil {
+			level.Warn(d.logger).Log("msg", "failed to gather metrics for latency triggers", "err", err)
+		}
+		for _, l := range t.Latency {
+			latency, ok := d.latency(families, l)
+			if !ok {
+				continue
+			}
+			values[fmt.Sprintf("%s{quantile=\"%v\"}", l.Metric, l.Quantile)] = latency
+			if trigger == "" && latency > time.Duration(l.Threshold).Seconds() {
+				trigger, reason = triggerLatency, fmt.Sprintf("%v quantile of %s %.3fs exceeds %s", l.Quantile, l.Metric, latency, l.Threshold)
+			}
+		}
+	}
+	return trigger, reason, values
+}
+
+// latency returns the quantile of the latencies observed by the histogram of the trigger since the previous check.
+// It returns false if there was no observation, or on the first check.
+func (d *Diagnostics) latency(families []*dto.MetricFamily, l LatencyTrigger) (float64, bool) {
+	var current cumulativeBuckets
+	for _, mf := range families {
+		if mf.GetName() == l.Metric && mf.GetType() == dto.MetricType_HISTOGRAM {
+			current = sumHistograms(mf.GetMetric())
+			break
+		}
+	}
+	if current == nil {
+		return 0, false
+	}
+
+	previous, ok := d.latencies[l.Metric]
+	d.latencies[l.Metric] = current
+	if !ok {
+		return 0, false
+	}
+	delta := current.sub(previous)
+	if delta.count() <= 0 {
+		return 0, false
+	}
+	return delta.quantile(l.Quantile), true
+}
+
+// capture writes the profiles along with their metadata to a new directory, and uploads each file once written, so
+// that the first profiles are available even if the process is killed before all of them were captured. Captures
+// failing to be written or uploaded are kept in the directory, which keeps at most MaxLocalCaptures of them.
+func (d *Diagnostics) capture(ctx context.Context, meta Metadata) (err error) {
+	now := time.Now().UTC()
+	name := fmt.Sprintf("%s-%s", now.Format("20060102T150405Z"), meta.Trigger)
+	dir := filepath.Join(d.conf.Directory, name)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return errors.Wrap(err, "create capture directory")
+	}
+	defer func() {
+		if rerr := d.removeOldCaptures(); rerr != nil && err == nil {
+			err = rerr
+		}
+	}()
+
+	meta.Component = d.component
+	meta.Hostname = d.hostname
+	meta.Version = version.Version
+	meta.GoVersion = runtime.Version()
+	meta.Time = now
+	meta.Profiles = d.conf.Profiles
+
+	b, err := json.MarshalIndent(meta, "", "\t")
+	if err != nil {
+		return errors.Wrap(err, "marshal capture metadata")
+	}
+	if err := d.writeAndUpload(ctx, dir, MetadataFile, func(f *os.File) error {
+		_, err := f.Write(b)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	for _, p := range d.conf.Profiles {
+		p := p
+		if err := d.writeAndUpload(ctx, dir, p+".pb.gz", func(f *os.File) error {
+			return writeProfile(ctx, f, p, time.Duration(d.conf.CPUProfileDuration))
+		}); err != nil {
+			return err
+		}
+	}
+
+	if d.bkt != nil {
+		return errors.Wrap(os.RemoveAll(dir), "remove uploaded capture")
+	}
+	return nil
+}
+
+func (d *Diagnostics) writeAndUpload(ctx context.Context, dir, file string, write func(*os.File) error) error {
+	src := filepath.Join(dir, file)
+	f, err := os.Create(src)
+	if err != nil {
+		return errors.Wrapf(err, "create %s", file)
+	}
+	if err := write(f); err != nil {
+		runutil.CloseWithLogOnErr(d.logger, f, "capture file")
+		return errors.Wrapf(err, "write %s", file)
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "close %s", file)
+	}
+
+	if d.bkt == nil {
+		return nil
+	}
+	dst := path.Join(d.conf.Prefix, d.component, d.hostname, filepath.Base(dir), file)
+	return errors.Wrapf(objstore.UploadFile(ctx, d.logger, d.bkt, src, dst), "upload %s", file)
+}
+
+// removeOldCaptures removes the oldest captures of the directory beyond the maximum number kept.
+func (d *Diagnostics) removeOldCaptures() error {
+	entries, err := os.ReadDir(d.conf.Directory)
+	if err != nil {
+		return errors.Wrap(err, "read diagnostics directory")
+	}
+	var captures []string
+	for _, e := range entries {
+		if e.IsDir() {
+			captures = append(captures, e.Name())
+		}
+	}
+	// Names start with the time of the capture.
+	sort.Strings(captures)
+	for i := 0; i < len(captures)-d.conf.MaxLocalCaptures; i++ {
+		if err := os.RemoveAll(filepath.Join(d.conf.Directory, captures[i])); err != nil {
+			return errors.Wrap(err, "remove old capture")
+		}
+	}
+	return nil
+}
+
+// writeProfile writes the given profile in the gzipped protobuf format.
+func writeProfile(ctx context.Context, f *os.File, profile string, cpuDuration time.Duration) error {
+	if profile != ProfileCPU {
+		return pprof.Lookup(profile).WriteTo(f, 0)
+	}
+
+	// Only one CPU profile can run at a time, which fails if one is being taken through the HTTP server.
+	if err := pprof.StartCPUProfile(f); err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(cpuDuration):
+	}
+	pprof.StopCPUProfile()
+	return nil
+}
+
+// memoryInUse returns the memory obtained from the OS by the Go runtime and not released to it, which is close to
+// the resident memory of the process, unless it maps files in memory.
+func memoryInUse() uint64 {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	if samples[0].Value.Kind() != metrics.KindUint64 || samples[1].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return samples[0].Value.Uint64() - samples[1].Value.Uint64()
+}
+
+// cumulativeBuckets maps the upper bounds of the buckets of a histogram, including +Inf, to their cumulative counts.
+type cumulativeBuckets map[float64]float64
+
+func sumHistograms(ms []*dto.Metric) cumulativeBuckets {
+	buckets := cumulativeBuckets{}
+	for _, m := range ms {
+		h := m.GetHistogram()
+		if h == nil {
+			continue
+		}
+		for _, b := range h.GetBucket() {
+			// The +Inf bucket is implicit, but may be given too.
+			if !math.IsInf(b.GetUpperBound(), 1) {
+				buckets[b.GetUpperBound()] += float64(b.GetCumulativeCount())
+			}
+		}
+		buckets[math.Inf(1)] += float64(h.GetSampleCount())
+	}
+	return buckets
+}
+
+func (b cumulativeBuckets) sub(o cumulativeBuckets) cumulativeBuckets {
+	delta := make(cumulativeBuckets, len(b))
+	for bound, count := range b {
+		delta[bound] = count - o[bound]
+	}
+	return delta
+}
+
+func (b cumulativeBuckets) count() float64 {
+	return b[math.Inf(1)]
+}
+
+// quantile estimates the quantile of the observations assuming they are uniformly distributed within buckets, the
+// same way as the histogram_quantile PromQL function does.
+func (b cumulativeBuckets) quantile(q float64) float64 {
+	bounds := make([]float64, 0, len(b))
+	for bound := range b {
+		bounds = append(bounds, bound)
+	}
+	sort.Float64s(bounds)
+
+	rank := q * b.count()
+	lowerBound, lowerCount := 0.0, 0.0
+	for i, bound := range bounds {
+		count := b[bound]
+		if count < rank {
+			lowerBound, lowerCount = bound, count
+			continue
+		}
+		if math.IsInf(bound, 1) {
+			// Observations above the highest finite bound cannot be located more precisely.
+			if i > 0 {
+				return bounds[i-1]
+			}
+			return 0
+		}
+		if count == lowerCount {
+			return bound
+		}
+		return lowerBound + (bound-lowerBound)*(rank-lowerCount)/(count-lowerCount)
+	}
+	return bounds[len(bounds)-1]
+}
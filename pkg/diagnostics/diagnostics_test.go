@@ -0,0 +1,229 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package diagnostics
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/units"
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/providers/filesystem"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+func TestParseConfig(t *testing.T) {
+	conf, err := ParseConfig([]byte(`
+directory: /tmp/diagnostics
+triggers:
+  memory_bytes: 2GiB
+  latency:
+  - metric: http_request_duration_seconds
+    threshold: 5s
+`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2*units.GiB, conf.Triggers.MemoryBytes)
+	testutil.Equals(t, 0.99, conf.Triggers.Latency[0].Quantile)
+	testutil.Equals(t, model.Duration(15*time.Minute), conf.MinInterval)
+	testutil.Equals(t, []string{ProfileHeap, ProfileGoroutine, ProfileCPU}, conf.Profiles)
+
+	for _, content := range []string{
+		`directory: /tmp/diagnostics`,
+		`{triggers: {goroutines: 1000}, unknown: true}`,
+		`{triggers: {goroutines: 1000}, profiles: [threadcreate]}`,
+		`{triggers: {memory_limit_ratio: 90}}`,
+		`{triggers: {latency: [{metric: http_request_duration_seconds}]}}`,
+		`{triggers: {goroutines: 1000}, directory: ""}`,
+	} {
+		_, err := ParseConfig([]byte(content))
+		testutil.NotOk(t, err, content)
+	}
+}
+
+func TestCumulativeBucketsQuantile(t *testing.T) {
+	b := cumulativeBuckets{0.1: 50, 1: 90, 10: 100, math.Inf(1): 100}
+	testutil.Equals(t, 0.1, b.quantile(0.5))
+	testutil.Equals(t, 0.55, b.quantile(0.7))
+	testutil.Equals(t, 10.0, b.quantile(1))
+
+	// Observations above the highest finite bound.
+	b = cumulativeBuckets{0.1: 0, 1: 0, math.Inf(1): 10}
+	testutil.Equals(t, 1.0, b.quantile(0.99))
+}
+
+func TestDiagnostics_LatencyTrigger(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Buckets: []float64{0.1, 1, 10},
+	})
+	reg.MustRegister(h)
+
+	d, err := New(log.NewNopLogger(), prometheus.NewRegistry(), reg, []byte(`
+directory: `+t.TempDir()+`
+triggers:
+  latency:
+  - metric: http_request_duration_seconds
+    quantile: 0.9
+    threshold: 2s
+`), "store")
+	testutil.Ok(t, err)
+
+	// The first check only records the histogram.
+	h.Observe(5)
+	trigger, _, _ := d.check()
+	testutil.Equals(t, "", trigger)
+
+	// Slow requests observed before the previous check are not taken into account.
+	for i := 0; i < 10; i++ {
+		h.Observe(0.05)
+	}
+	trigger, _, _ = d.check()
+	testutil.Equals(t, "", trigger)
+
+	for i := 0; i < 10; i++ {
+		h.Observe(5)
+	}
+	trigger, reason, values := d.check()
+	testutil.Equals(t, triggerLatency, trigger)
+	testutil.Assert(t, strings.Contains(reason, "http_request_duration_seconds"), reason)
+	testutil.Equals(t, 1, len(values))
+}
+
+func TestDiagnostics_Capture(t *testing.T) {
+	bktDir := t.TempDir()
+	dir := t.TempDir()
+
+	d, err := New(log.NewNopLogger(), prometheus.NewRegistry(), prometheus.NewRegistry(), []byte(`
+directory: `+dir+`
+prefix: diagnostics
+bucket:
+  type: FILESYSTEM
+  config:
+    directory: `+bktDir+`
+check_interval: 10ms
+min_interval: 1h
+profiles: [heap, goroutine]
+triggers:
+  goroutines: 1
+`), "store")
+	testutil.Ok(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- d.Run(ctx) }()
+
+	// Captures are rate limited, so that only the first one is taken while goroutines exceed the threshold.
+	retryCtx, retryCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer retryCancel()
+	testutil.Ok(t, runutil.Retry(10*time.Millisecond, retryCtx.Done(), func() error {
+		if promtestutil.ToFloat64(d.skipped.WithLabelValues(triggerGoroutines)) == 0 {
+			return errors.New("no capture skipped yet")
+		}
+		return nil
+	}))
+	cancel()
+	testutil.Ok(t, <-done)
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(d.captures.WithLabelValues(triggerGoroutines)))
+	testutil.Equals(t, 0.0, promtestutil.ToFloat64(d.captureFailures))
+
+	// Uploaded captures are removed from the directory.
+	entries, err := os.ReadDir(dir)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(entries))
+
+	bkt, err := filesystem.NewBucket(bktDir)
+	testutil.Ok(t, err)
+	var files []string
+	testutil.Ok(t, bkt.Iter(context.Background(), "diagnostics/store/"+d.hostname+"/", func(capture string) error {
+		return bkt.Iter(context.Background(), capture, func(file string) error {
+			files = append(files, filepath.Base(file))
+			return nil
+		})
+	}))
+	testutil.Equals(t, []string{"goroutine.pb.gz", "heap.pb.gz", MetadataFile}, files)
+
+	var meta Metadata
+	testutil.Ok(t, filepath.Walk(bktDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.Name() != MetadataFile {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(b, &meta)
+	}))
+	testutil.Equals(t, "store", meta.Component)
+	testutil.Equals(t, triggerGoroutines, meta.Trigger)
+	testutil.Assert(t, meta.Values["goroutines"] > 1, "goroutines should be recorded")
+}
+
+func TestDiagnostics_RemoveOldCaptures(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"20230101T000000Z-memory", "20230102T000000Z-memory", "20230103T000000Z-latency"} {
+		testutil.Ok(t, os.MkdirAll(filepath.Join(dir, name), os.ModePerm))
+	}
+
+	d, err := New(log.NewNopLogger(), prometheus.NewRegistry(), prometheus.NewRegistry(), []byte(`
+directory: `+dir+`
+max_local_captures: 2
+triggers:
+  goroutines: 1000
+`), "store")
+	testutil.Ok(t, err)
+	testutil.Ok(t, d.removeOldCaptures())
+
+	entries, err := os.ReadDir(dir)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(entries))
+	testutil.Equals(t, "20230102T000000Z-memory", entries[0].Name())
+}
+
+func TestDiagnostics_CaptureUploadFailure(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"20230101T000000Z-memory", "20230102T000000Z-memory"} {
+		testutil.Ok(t, os.MkdirAll(filepath.Join(dir, name), os.ModePerm))
+	}
+
+	d, err := New(log.NewNopLogger(), prometheus.NewRegistry(), prometheus.NewRegistry(), []byte(`
+directory: `+dir+`
+profiles: [goroutine]
+max_local_captures: 2
+triggers:
+  goroutines: 1000
+`), "store")
+	testutil.Ok(t, err)
+	d.bkt = failingBucket{Bucket: objstore.NewInMemBucket()}
+
+	// Captures failing to be uploaded are kept, but count against the maximum number of local captures.
+	testutil.NotOk(t, d.capture(context.Background(), Metadata{Trigger: triggerGoroutines}))
+	entries, err := os.ReadDir(dir)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(entries))
+	testutil.Equals(t, "20230102T000000Z-memory", entries[0].Name())
+	testutil.Assert(t, strings.HasSuffix(entries[1].Name(), "-"+triggerGoroutines), entries[1].Name())
+}
+
+type failingBucket struct {
+	objstore.Bucket
+}
+
+func (failingBucket) Upload(context.Context, string, io.Reader) error {
+	return errors.New("upload failed")
+}
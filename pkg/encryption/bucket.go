@@ -0,0 +1,541 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package encryption implements client-side envelope encryption of objects in the bucket. Every object is encrypted
+// with its own random data key using AES-256-GCM, and the data key, wrapped by a master key, is stored in the header
+// of the object. The master key is kept in a key management service, so that encryption does not depend on the
+// server-side encryption of the provider.
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+
+	"github.com/go-kit/log"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+const (
+	// Objects are encrypted in segments, so that ranges of objects can be read without decrypting them whole.
+	defaultSegmentSize = 64 * 1024
+	dataKeySize        = 32
+
+	// The header of encrypted objects is the magic, the length of the header content and the header content.
+	magic         = "TNSENC01"
+	maxHeaderSize = 64 * 1024
+	// Size of the range read to fetch the header of objects, which fits the wrapped data keys of all master keys.
+	headerFetchSize = 4 * 1024
+
+	cacheSize = 10000
+)
+
+var errNotEncrypted = errors.New("object is not encrypted")
+
+// Config is the configuration of client-side encryption, in the encryption section of the bucket configuration.
+type Config struct {
+	Type   MasterKeyType `yaml:"type"`
+	Config interface{}   `yaml:"config"`
+	// AllowUnencryptedReads allows to read objects which are not encrypted, e.g. uploaded before encryption was enabled.
+	AllowUnencryptedReads bool `yaml:"allow_unencrypted_reads"`
+}
+
+// WrapBucket returns a bucket encrypting the objects uploaded to bkt and decrypting the objects read from it, with
+// data keys wrapped by the given master key.
+func WrapBucket(bkt objstore.InstrumentedBucket, key MasterKey, allowUnencryptedReads bool, reg prometheus.Registerer) objstore.InstrumentedBucket {
+	keys, _ := lru.New(cacheSize)
+	headers, _ := lru.New(cacheSize)
+	c := &crypter{
+		key:                   key,
+		segmentSize:           defaultSegmentSize,
+		allowUnencryptedReads: allowUnencryptedReads,
+		keys:                  keys,
+		headers:               headers,
+		keyOps: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name:        "thanos_objstore_encryption_master_key_operations_total",
+			Help:        "Total number of data keys wrapped or unwrapped with the master key.",
+			ConstLabels: prometheus.Labels{"bucket": bkt.Name()},
+		}, []string{"operation"}),
+		keyOpFailures: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name:        "thanos_objstore_encryption_master_key_operation_failures_total",
+			Help:        "Total number of data keys which failed to be wrapped or unwrapped with the master key.",
+			ConstLabels: prometheus.Labels{"bucket": bkt.Name()},
+		}, []string{"operation"}),
+	}
+	for _, op := range []string{"wrap", "unwrap"} {
+		c.keyOps.WithLabelValues(op)
+		c.keyOpFailures.WithLabelValues(op)
+	}
+	return &instrumentedBucket{bucket: bucket{Bucket: bkt, c: c}, ib: bkt}
+}
+
+type instrumentedBucket struct {
+	bucket
+	ib objstore.InstrumentedBucket
+}
+
+func (b *instrumentedBucket) WithExpectedErrs(f objstore.IsOpFailureExpectedFunc) objstore.Bucket {
+	return &bucket{Bucket: b.ib.WithExpectedErrs(f), c: b.c}
+}
+
+func (b *instrumentedBucket) ReaderWithExpectedErrs(f objstore.IsOpFailureExpectedFunc) objstore.BucketReader {
+	return &reader{BucketReader: b.ib.ReaderWithExpectedErrs(f), c: b.c}
+}
+
+type bucket struct {
+	objstore.Bucket
+	c *crypter
+}
+
+func (b *bucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	er, err := b.c.encrypt(ctx, r)
+	if err != nil {
+		return errors.Wrapf(err, "encrypt %s", name)
+	}
+	// Uploads can replace the object, along with its header. The header is also dropped after the upload, as it might
+	// have been cached by reads of the replaced object during the upload.
+	b.c.headers.Remove(name)
+	defer b.c.headers.Remove(name)
+	return b.Bucket.Upload(ctx, name, er)
+}
+
+func (b *bucket) Delete(ctx context.Context, name string) error {
+	defer b.c.headers.Remove(name)
+	return b.Bucket.Delete(ctx, name)
+}
+
+func (b *bucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.c.get(ctx, b.Bucket, name)
+}
+
+func (b *bucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	return b.c.getRange(ctx, b.Bucket, name, off, length)
+}
+
+func (b *bucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	return b.c.attributes(ctx, b.Bucket, name)
+}
+
+type reader struct {
+	objstore.BucketReader
+	c *crypter
+}
+
+func (r *reader) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return r.c.get(ctx, r.BucketReader, name)
+}
+
+func (r *reader) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	return r.c.getRange(ctx, r.BucketReader, name, off, length)
+}
+
+func (r *reader) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	return r.c.attributes(ctx, r.BucketReader, name)
+}
+
+// header is the header of encrypted objects.
+type header struct {
+	DataKey     []byte `json:"data_key"`
+	SegmentSize int64  `json:"segment_size"`
+}
+
+// objectHeader is the parsed header of an object, cached by object name for range reads.
+type objectHeader struct {
+	encrypted   bool
+	size        int64
+	headerSize  int64
+	segmentSize int64
+	aead        cipher.AEAD
+}
+
+type crypter struct {
+	key                   MasterKey
+	segmentSize           int64
+	allowUnencryptedReads bool
+
+	// Unwrapped data keys by wrapped data key, and headers of objects by name.
+	keys, headers *lru.Cache
+
+	keyOps, keyOpFailures *prometheus.CounterVec
+}
+
+func (c *crypter) encrypt(ctx context.Context, r io.Reader) (io.Reader, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, errors.Wrap(err, "generate data key")
+	}
+	c.keyOps.WithLabelValues("wrap").Inc()
+	wrapped, err := c.key.Wrap(ctx, dataKey)
+	if err != nil {
+		c.keyOpFailures.WithLabelValues("wrap").Inc()
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr, err := json.Marshal(header{DataKey: wrapped, SegmentSize: c.segmentSize})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(magic)+4, len(magic)+4+len(hdr))
+	copy(out, magic)
+	binary.BigEndian.PutUint32(out[len(magic):], uint32(len(hdr)))
+	out = append(out, hdr...)
+
+	er := &encryptReader{
+		r:     bufio.NewReaderSize(r, int(c.segmentSize)),
+		aead:  aead,
+		buf:   make([]byte, c.segmentSize),
+		out:   out,
+		size:  -1,
+		sizer: r,
+	}
+	if size, err := objstore.TryToGetSize(r); err == nil {
+		segments := (size + c.segmentSize - 1) / c.segmentSize
+		if segments == 0 {
+			segments = 1
+		}
+		er.size = int64(len(out)) + size + segments*int64(aead.Overhead())
+	}
+	return er, nil
+}
+
+// readHeader reads the header of the object from r. It returns errNotEncrypted and the bytes read if the object has
+// no header.
+func (c *crypter) readHeader(ctx context.Context, r io.Reader) (*objectHeader, int64, []byte, error) {
+	prefix := make([]byte, len(magic)+4)
+	n, err := io.ReadFull(r, prefix)
+	if err == io.EOF || err == io.ErrUnexpectedEOF || (err == nil && string(prefix[:len(magic)]) != magic) {
+		return nil, 0, prefix[:n], errNotEncrypted
+	}
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	hdrLen := binary.BigEndian.Uint32(prefix[len(magic):])
+	if hdrLen > maxHeaderSize {
+		return nil, 0, nil, errors.Errorf("header size %d exceeds the max header size", hdrLen)
+	}
+	b := make([]byte, hdrLen)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, 0, nil, errors.Wrap(err, "read header")
+	}
+	var hdr header
+	if err := json.Unmarshal(b, &hdr); err != nil {
+		return nil, 0, nil, errors.Wrap(err, "unmarshal header")
+	}
+	if hdr.SegmentSize <= 0 {
+		return nil, 0, nil, errors.Errorf("invalid segment size %d", hdr.SegmentSize)
+	}
+
+	aead, err := c.dataKey(ctx, hdr.DataKey)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	return &objectHeader{encrypted: true, segmentSize: hdr.SegmentSize, aead: aead}, int64(len(prefix)) + int64(hdrLen), nil, nil
+}
+
+func (c *crypter) dataKey(ctx context.Context, wrapped []byte) (cipher.AEAD, error) {
+	if aead, ok := c.keys.Get(string(wrapped)); ok {
+		return aead.(cipher.AEAD), nil
+	}
+	c.keyOps.WithLabelValues("unwrap").Inc()
+	dataKey, err := c.key.Unwrap(ctx, wrapped)
+	if err != nil {
+		c.keyOpFailures.WithLabelValues("unwrap").Inc()
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	c.keys.Add(string(wrapped), aead)
+	return aead, nil
+}
+
+func (c *crypter) get(ctx context.Context, bkt objstore.BucketReader, name string) (io.ReadCloser, error) {
+	rc, err := bkt.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(rc)
+	hdr, _, prefix, err := c.readHeader(ctx, br)
+	if err == errNotEncrypted && c.allowUnencryptedReads {
+		return readCloser{Reader: io.MultiReader(bytes.NewReader(prefix), br), Closer: rc}, nil
+	}
+	if err != nil {
+		runutil.CloseWithLogOnErr(log.NewNopLogger(), rc, "close object")
+		return nil, errors.Wrapf(err, "read header of %s", name)
+	}
+	return &decryptReader{r: br, Closer: rc, aead: hdr.aead, buf: make([]byte, hdr.segmentSize+int64(hdr.aead.Overhead()))}, nil
+}
+
+// header returns the header of the object, fetched from the bucket if not cached.
+func (c *crypter) header(ctx context.Context, bkt objstore.BucketReader, name string) (*objectHeader, error) {
+	if hdr, ok := c.headers.Get(name); ok {
+		return hdr.(*objectHeader), nil
+	}
+
+	attrs, err := bkt.Attributes(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := bkt.GetRange(ctx, name, 0, headerFetchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer runutil.CloseWithLogOnErr(log.NewNopLogger(), rc, "close object")
+
+	var r io.Reader = rc
+	if attrs.Size > headerFetchSize {
+		// Headers longer than the fetched range are read on.
+		r = io.MultiReader(rc, &lazyRangeReader{ctx: ctx, bkt: bkt, name: name, off: headerFetchSize})
+	}
+	hdr, hdrSize, _, err := c.readHeader(ctx, r)
+	if err == errNotEncrypted {
+		if !c.allowUnencryptedReads {
+			return nil, errors.Wrapf(err, "read header of %s", name)
+		}
+		hdr, err = &objectHeader{encrypted: false, size: attrs.Size}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "read header of %s", name)
+	}
+
+	if hdr.encrypted {
+		// Segments are sealed with an overhead, the last segment of the object possibly being shorter.
+		bodySize := attrs.Size - hdrSize
+		sealedSegment := hdr.segmentSize + int64(hdr.aead.Overhead())
+		segments := (bodySize + sealedSegment - 1) / sealedSegment
+		hdr.size = bodySize - segments*int64(hdr.aead.Overhead())
+		hdr.headerSize = hdrSize
+	}
+	c.headers.Add(name, hdr)
+	return hdr, nil
+}
+
+func (c *crypter) getRange(ctx context.Context, bkt objstore.BucketReader, name string, off, length int64) (io.ReadCloser, error) {
+	hdr, err := c.header(ctx, bkt, name)
+	if err != nil {
+		return nil, err
+	}
+	if !hdr.encrypted {
+		return bkt.GetRange(ctx, name, off, length)
+	}
+	if off < 0 || off > hdr.size {
+		return nil, errors.Errorf("offset %d out of range of %s of size %d", off, name, hdr.size)
+	}
+	if length == -1 || off+length > hdr.size {
+		length = hdr.size - off
+	}
+	if length == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	var (
+		sealedSegment = hdr.segmentSize + int64(hdr.aead.Overhead())
+		first         = off / hdr.segmentSize
+		last          = (off + length - 1) / hdr.segmentSize
+	)
+	rc, err := bkt.GetRange(ctx, name, hdr.headerSize+first*sealedSegment, (last-first+1)*sealedSegment)
+	if err != nil {
+		return nil, err
+	}
+	dr := &decryptReader{
+		r:        bufio.NewReader(rc),
+		Closer:   rc,
+		aead:     hdr.aead,
+		buf:      make([]byte, sealedSegment),
+		segment:  uint64(first),
+		lastSeg:  uint64((hdr.size - 1) / hdr.segmentSize),
+		skip:     off - first*hdr.segmentSize,
+		knownEnd: true,
+	}
+	return readCloser{Reader: io.LimitReader(dr, length), Closer: dr}, nil
+}
+
+func (c *crypter) attributes(ctx context.Context, bkt objstore.BucketReader, name string) (objstore.ObjectAttributes, error) {
+	attrs, err := bkt.Attributes(ctx, name)
+	if err != nil {
+		return attrs, err
+	}
+	hdr, err := c.header(ctx, bkt, name)
+	if err != nil {
+		return attrs, err
+	}
+	attrs.Size = hdr.size
+	return attrs, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// lazyRangeReader reads the object from the given offset, on first read.
+type lazyRangeReader struct {
+	ctx  context.Context
+	bkt  objstore.BucketReader
+	name string
+	off  int64
+
+	r io.ReadCloser
+}
+
+func (l *lazyRangeReader) Read(p []byte) (int, error) {
+	if l.r == nil {
+		rc, err := l.bkt.GetRange(l.ctx, l.name, l.off, maxHeaderSize)
+		if err != nil {
+			return 0, err
+		}
+		l.r = rc
+	}
+	n, err := l.r.Read(p)
+	if err == io.EOF {
+		runutil.CloseWithLogOnErr(log.NewNopLogger(), l.r, "close object")
+	}
+	return n, err
+}
+
+// nonce returns the nonce of the given segment. Data keys are unique to objects, so segments are numbered, and the
+// last segment of objects is flagged to detect truncated objects.
+func nonce(segment uint64, last bool) []byte {
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint64(n, segment)
+	if last {
+		n[8] = 1
+	}
+	return n
+}
+
+type encryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	segment uint64
+	buf     []byte
+	sealed  []byte
+	out     []byte
+	done    bool
+
+	size  int64
+	sizer io.Reader
+}
+
+func (e *encryptReader) Read(p []byte) (int, error) {
+	for len(e.out) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		if err := e.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+func (e *encryptReader) next() error {
+	n, err := io.ReadFull(e.r, e.buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	last := err != nil
+	if !last {
+		if _, err := e.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	e.sealed = e.aead.Seal(e.sealed[:0], nonce(e.segment, last), e.buf[:n], nil)
+	e.out = e.sealed
+	e.segment++
+	e.done = last
+	return nil
+}
+
+// ObjectSize returns the size of the encrypted object, as some providers need it upfront.
+func (e *encryptReader) ObjectSize() (int64, error) {
+	if e.size < 0 {
+		return objstore.TryToGetSize(e.sizer)
+	}
+	return e.size, nil
+}
+
+type decryptReader struct {
+	io.Closer
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	segment uint64
+	buf     []byte
+	out     []byte
+	skip    int64
+	done    bool
+
+	// Ranges of objects end before the last segment, so the last segment is known from the object size for them.
+	// Otherwise, the last segment read is the last segment of the object.
+	knownEnd bool
+	lastSeg  uint64
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+func (d *decryptReader) next() error {
+	n, err := io.ReadFull(d.r, d.buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	end := err != nil
+	if !end {
+		if _, err := d.r.Peek(1); err == io.EOF {
+			end = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	last := end
+	if d.knownEnd {
+		last = d.segment == d.lastSeg
+		if end && !last && n < len(d.buf) {
+			return errors.New("object truncated")
+		}
+	}
+	out, err := d.aead.Open(d.buf[:0], nonce(d.segment, last), d.buf[:n], nil)
+	if err != nil {
+		return errors.Wrapf(err, "decrypt segment %d", d.segment)
+	}
+	if d.skip > 0 {
+		out = out[d.skip:]
+		d.skip = 0
+	}
+	d.out = out
+	d.segment++
+	d.done = end
+	return nil
+}
@@ -0,0 +1,210 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/efficientgo/core/testutil"
+	"github.com/thanos-io/objstore"
+)
+
+func writeTestIdentity(t *testing.T) (string, *age.X25519Identity) {
+	id, err := age.GenerateX25519Identity()
+	testutil.Ok(t, err)
+	identityFile := filepath.Join(t.TempDir(), "key.txt")
+	testutil.Ok(t, os.WriteFile(identityFile, []byte(id.String()+"\n"), 0600))
+	return identityFile, id
+}
+
+func newTestAgeKey(t *testing.T) MasterKey {
+	identityFile, _ := writeTestIdentity(t)
+	k, err := NewMasterKey(AGE, []byte("identity_file: "+identityFile))
+	testutil.Ok(t, err)
+	return k
+}
+
+func TestBucket_Acceptance(t *testing.T) {
+	objstore.AcceptanceTest(t, WrapBucket(objstore.WithNoopInstr(objstore.NewInMemBucket()), newTestAgeKey(t), false, nil))
+}
+
+func TestBucket(t *testing.T) {
+	ctx := context.Background()
+	inmem := objstore.NewInMemBucket()
+	bkt := WrapBucket(objstore.WithNoopInstr(inmem), newTestAgeKey(t), false, nil)
+	bkt.(*instrumentedBucket).c.segmentSize = 16
+
+	for _, size := range []int{0, 1, 15, 16, 17, 32, 100} {
+		content := make([]byte, size)
+		_, err := rand.Read(content)
+		testutil.Ok(t, err)
+		testutil.Ok(t, bkt.Upload(ctx, "obj", bytes.NewReader(content)))
+
+		// Objects are encrypted in the bucket.
+		r, err := inmem.Get(ctx, "obj")
+		testutil.Ok(t, err)
+		stored, err := io.ReadAll(r)
+		testutil.Ok(t, err)
+		testutil.Assert(t, strings.HasPrefix(string(stored), magic))
+		if size > 0 {
+			testutil.Assert(t, !bytes.Contains(stored, content))
+		}
+
+		r, err = bkt.Get(ctx, "obj")
+		testutil.Ok(t, err)
+		got, err := io.ReadAll(r)
+		testutil.Ok(t, err)
+		testutil.Equals(t, content, got)
+
+		attrs, err := bkt.Attributes(ctx, "obj")
+		testutil.Ok(t, err)
+		testutil.Equals(t, int64(size), attrs.Size)
+
+		for off := 0; off <= size; off++ {
+			for length := 1; off+length <= size; length++ {
+				r, err := bkt.GetRange(ctx, "obj", int64(off), int64(length))
+				testutil.Ok(t, err)
+				got, err := io.ReadAll(r)
+				testutil.Ok(t, err)
+				testutil.Equals(t, content[off:off+length], got, "off %d length %d", off, length)
+			}
+			r, err := bkt.GetRange(ctx, "obj", int64(off), -1)
+			testutil.Ok(t, err)
+			got, err := io.ReadAll(r)
+			testutil.Ok(t, err)
+			testutil.Equals(t, content[off:], got, "off %d", off)
+		}
+
+		// Truncated objects fail to be read.
+		if size > 16 {
+			testutil.Ok(t, inmem.Upload(ctx, "truncated", bytes.NewReader(stored[:len(stored)-16-16])))
+			r, err = bkt.Get(ctx, "truncated")
+			testutil.Ok(t, err)
+			_, err = io.ReadAll(r)
+			testutil.NotOk(t, err)
+		}
+	}
+}
+
+func TestBucket_UnencryptedReads(t *testing.T) {
+	ctx := context.Background()
+	inmem := objstore.NewInMemBucket()
+	testutil.Ok(t, inmem.Upload(ctx, "plain", strings.NewReader("plain content")))
+	testutil.Ok(t, inmem.Upload(ctx, "short", strings.NewReader("a")))
+
+	key := newTestAgeKey(t)
+	_, err := WrapBucket(objstore.WithNoopInstr(inmem), key, false, nil).Get(ctx, "plain")
+	testutil.NotOk(t, err)
+
+	bkt := WrapBucket(objstore.WithNoopInstr(inmem), key, true, nil)
+	for name, content := range map[string]string{"plain": "plain content", "short": "a"} {
+		r, err := bkt.Get(ctx, name)
+		testutil.Ok(t, err)
+		got, err := io.ReadAll(r)
+		testutil.Ok(t, err)
+		testutil.Equals(t, content, string(got))
+
+		r, err = bkt.GetRange(ctx, name, 0, 1)
+		testutil.Ok(t, err)
+		got, err = io.ReadAll(r)
+		testutil.Ok(t, err)
+		testutil.Equals(t, content[:1], string(got))
+	}
+
+	// Objects encrypted with another key fail to be read.
+	testutil.Ok(t, WrapBucket(objstore.WithNoopInstr(inmem), newTestAgeKey(t), true, nil).Upload(ctx, "other", strings.NewReader("a")))
+	_, err = bkt.Get(ctx, "other")
+	testutil.NotOk(t, err)
+}
+
+func TestVaultKey(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testutil.Equals(t, "token", r.Header.Get("X-Vault-Token"))
+		var req map[string]string
+		testutil.Ok(t, json.NewDecoder(r.Body).Decode(&req))
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/thanos":
+			_, _ = w.Write([]byte(`{"data":{"ciphertext":"vault:v1:` + req["plaintext"] + `"}}`))
+		case "/v1/transit/decrypt/thanos":
+			_, _ = w.Write([]byte(`{"data":{"plaintext":"` + strings.TrimPrefix(req["ciphertext"], "vault:v1:") + `"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	k, err := NewMasterKey(VAULT, []byte("address: "+srv.URL+"\ntoken: token\nkey_name: thanos"))
+	testutil.Ok(t, err)
+	wrapped, err := k.Wrap(context.Background(), []byte("data key"))
+	testutil.Ok(t, err)
+	key, err := k.Unwrap(context.Background(), wrapped)
+	testutil.Ok(t, err)
+	testutil.Equals(t, "data key", string(key))
+}
+
+func TestBucket_HeaderInvalidation(t *testing.T) {
+	ctx := context.Background()
+	inmem := objstore.NewInMemBucket()
+	key := newTestAgeKey(t)
+	bkt := WrapBucket(objstore.WithNoopInstr(inmem), key, false, nil)
+
+	readRange := func(off, length int64) string {
+		r, err := bkt.GetRange(ctx, "obj", off, length)
+		testutil.Ok(t, err)
+		got, err := io.ReadAll(r)
+		testutil.Ok(t, err)
+		return string(got)
+	}
+
+	// Range reads cache the header of the object, which is dropped when the object is overwritten.
+	testutil.Ok(t, bkt.Upload(ctx, "obj", strings.NewReader("first content")))
+	testutil.Equals(t, "first", readRange(0, 5))
+	testutil.Ok(t, bkt.Upload(ctx, "obj", strings.NewReader("second")))
+	testutil.Equals(t, "second", readRange(0, -1))
+
+	// And when the object is deleted, e.g. before it is uploaded again by another component.
+	testutil.Ok(t, bkt.Delete(ctx, "obj"))
+	testutil.Ok(t, WrapBucket(objstore.WithNoopInstr(inmem), key, false, nil).Upload(ctx, "obj", strings.NewReader("third content")))
+	testutil.Equals(t, "third content", readRange(0, -1))
+}
+
+func TestAgeKey(t *testing.T) {
+	ctx := context.Background()
+	identityFile, id := writeTestIdentity(t)
+	otherFile, other := writeTestIdentity(t)
+
+	// Data keys are wrapped for all recipients, so that they can be unwrapped with the identity of any of them.
+	k, err := NewMasterKey(AGE, []byte("identity_file: "+identityFile+"\nrecipients: ["+id.Recipient().String()+", "+other.Recipient().String()+"]"))
+	testutil.Ok(t, err)
+	wrapped, err := k.Wrap(ctx, []byte("data key"))
+	testutil.Ok(t, err)
+
+	rotated, err := NewMasterKey(AGE, []byte("identity_file: "+otherFile))
+	testutil.Ok(t, err)
+	key, err := rotated.Unwrap(ctx, wrapped)
+	testutil.Ok(t, err)
+	testutil.Equals(t, "data key", string(key))
+
+	// Data keys wrapped for other recipients fail to be unwrapped.
+	wrapped, err = rotated.Wrap(ctx, []byte("data key"))
+	testutil.Ok(t, err)
+	k, err = NewMasterKey(AGE, []byte("identity_file: "+identityFile))
+	testutil.Ok(t, err)
+	_, err = k.Unwrap(ctx, wrapped)
+	testutil.NotOk(t, err)
+
+	_, err = NewMasterKey(AGE, []byte("identity_file: "+identityFile+"\nrecipients: [invalid]"))
+	testutil.NotOk(t, err)
+}
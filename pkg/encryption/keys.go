@@ -0,0 +1,297 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// MasterKeyType is the type of the master key wrapping the data keys of objects.
+type MasterKeyType string
+
+const (
+	AWSKMS MasterKeyType = "AWS_KMS"
+	VAULT  MasterKeyType = "VAULT"
+	AGE    MasterKeyType = "AGE"
+)
+
+// MasterKey wraps and unwraps the data keys objects are encrypted with.
+type MasterKey interface {
+	// Wrap encrypts the given data key.
+	Wrap(ctx context.Context, key []byte) ([]byte, error)
+	// Unwrap decrypts the given data key wrapped by Wrap.
+	Unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// NewMasterKey returns the master key of the given type from its YAML configuration.
+func NewMasterKey(typ MasterKeyType, conf []byte) (MasterKey, error) {
+	switch MasterKeyType(strings.ToUpper(string(typ))) {
+	case AWSKMS:
+		return newAWSKMSKey(conf)
+	case VAULT:
+		return newVaultKey(conf)
+	case AGE:
+		return newAgeKey(conf)
+	default:
+		return nil, errors.Errorf("master key with type %s is not supported", typ)
+	}
+}
+
+// AWSKMSConfig is the configuration of a master key in AWS KMS.
+type AWSKMSConfig struct {
+	KeyID     string `yaml:"key_id"`
+	Region    string `yaml:"region"`
+	Endpoint  string `yaml:"endpoint"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+}
+
+type awsKMSKey struct {
+	keyID  string
+	client *kms.KMS
+}
+
+func newAWSKMSKey(conf []byte) (*awsKMSKey, error) {
+	var c AWSKMSConfig
+	if err := yaml.UnmarshalStrict(conf, &c); err != nil {
+		return nil, errors.Wrap(err, "parsing AWS KMS configuration")
+	}
+	if c.KeyID == "" {
+		return nil, errors.New("no AWS KMS key_id specified")
+	}
+
+	awsConf := aws.NewConfig()
+	if c.Region != "" {
+		awsConf = awsConf.WithRegion(c.Region)
+	}
+	if c.Endpoint != "" {
+		awsConf = awsConf.WithEndpoint(c.Endpoint)
+	}
+	// Credentials are looked up by the AWS SDK by default, e.g. from the environment or the instance role.
+	if c.AccessKey != "" {
+		awsConf = awsConf.WithCredentials(credentials.NewStaticCredentials(c.AccessKey, c.SecretKey, ""))
+	}
+	sess, err := session.NewSession(awsConf)
+	if err != nil {
+		return nil, errors.Wrap(err, "create AWS session")
+	}
+	return &awsKMSKey{keyID: c.KeyID, client: kms.New(sess)}, nil
+}
+
+func (k *awsKMSKey) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	out, err := k.client.EncryptWithContext(ctx, &kms.EncryptInput{KeyId: aws.String(k.keyID), Plaintext: key})
+	if err != nil {
+		return nil, errors.Wrap(err, "encrypt data key with AWS KMS")
+	}
+	return out.CiphertextBlob, nil
+}
+
+func (k *awsKMSKey) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	out, err := k.client.DecryptWithContext(ctx, &kms.DecryptInput{KeyId: aws.String(k.keyID), CiphertextBlob: wrapped})
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypt data key with AWS KMS")
+	}
+	return out.Plaintext, nil
+}
+
+// VaultConfig is the configuration of a master key in the transit secrets engine of HashiCorp Vault.
+type VaultConfig struct {
+	Address   string        `yaml:"address"`
+	Token     string        `yaml:"token"`
+	TokenFile string        `yaml:"token_file"`
+	Namespace string        `yaml:"namespace"`
+	MountPath string        `yaml:"mount_path"`
+	KeyName   string        `yaml:"key_name"`
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+type vaultKey struct {
+	conf   VaultConfig
+	client *http.Client
+}
+
+func newVaultKey(conf []byte) (*vaultKey, error) {
+	c := VaultConfig{MountPath: "transit", Timeout: 30 * time.Second}
+	if err := yaml.UnmarshalStrict(conf, &c); err != nil {
+		return nil, errors.Wrap(err, "parsing Vault configuration")
+	}
+	if c.Address == "" || c.KeyName == "" {
+		return nil, errors.New("no Vault address or key_name specified")
+	}
+	if c.Token != "" && c.TokenFile != "" {
+		return nil, errors.New("at most one of Vault token and token_file can be specified")
+	}
+	return &vaultKey{conf: c, client: &http.Client{Timeout: c.Timeout}}, nil
+}
+
+func (k *vaultKey) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	var resp struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	if err := k.do(ctx, "encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(key)}, &resp); err != nil {
+		return nil, errors.Wrap(err, "encrypt data key with Vault")
+	}
+	return []byte(resp.Data.Ciphertext), nil
+}
+
+func (k *vaultKey) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var resp struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	if err := k.do(ctx, "decrypt", map[string]string{"ciphertext": string(wrapped)}, &resp); err != nil {
+		return nil, errors.Wrap(err, "decrypt data key with Vault")
+	}
+	return base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+}
+
+func (k *vaultKey) do(ctx context.Context, op string, body map[string]string, resp interface{}) (err error) {
+	token := k.conf.Token
+	// The token file is read on every request, so that tokens can be renewed without restarts.
+	if k.conf.TokenFile != "" {
+		b, err := os.ReadFile(k.conf.TokenFile)
+		if err != nil {
+			return errors.Wrap(err, "read token file")
+		}
+		token = strings.TrimSpace(string(b))
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	u := strings.TrimSuffix(k.conf.Address, "/") + "/" + path.Join("v1", k.conf.MountPath, op, k.conf.KeyName)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if k.conf.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", k.conf.Namespace)
+	}
+
+	res, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer runutil.ExhaustCloseWithErrCapture(&err, res.Body, "close body")
+
+	b, err = io.ReadAll(res.Body)
+	if err != nil {
+		return errors.Wrap(err, "read body")
+	}
+	if res.StatusCode/100 != 2 {
+		return errors.Errorf("expected 2xx response, got %d. Body: %v", res.StatusCode, string(b))
+	}
+	return json.Unmarshal(b, resp)
+}
+
+// AgeConfig is the configuration of an age (https://age-encryption.org) master key, the identities of which are
+// stored in a local file.
+type AgeConfig struct {
+	// IdentityFile is the file with the identities unwrapping data keys, e.g. generated with age-keygen.
+	IdentityFile string `yaml:"identity_file"`
+	// Recipients are the recipients data keys are wrapped for. Defaults to the recipients of the X25519 identities of
+	// the identity file. Data keys are wrapped for all recipients, so that keys can be rotated by adding recipients.
+	Recipients []string `yaml:"recipients"`
+}
+
+type ageKey struct {
+	identities []age.Identity
+	recipients []age.Recipient
+}
+
+func newAgeKey(conf []byte) (*ageKey, error) {
+	var c AgeConfig
+	if err := yaml.UnmarshalStrict(conf, &c); err != nil {
+		return nil, errors.Wrap(err, "parsing age configuration")
+	}
+	b, err := os.ReadFile(c.IdentityFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "read identity file")
+	}
+	identities, err := age.ParseIdentities(bytes.NewReader(b))
+	if err != nil {
+		return nil, errors.Wrap(err, "parse identity file")
+	}
+
+	k := &ageKey{identities: identities}
+	for _, r := range c.Recipients {
+		recipient, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse recipient %s", r)
+		}
+		k.recipients = append(k.recipients, recipient)
+	}
+	if len(k.recipients) == 0 {
+		for _, id := range identities {
+			if x, ok := id.(*age.X25519Identity); ok {
+				k.recipients = append(k.recipients, x.Recipient())
+			}
+		}
+	}
+	if len(k.recipients) == 0 {
+		return nil, errors.New("no age recipients specified, and no X25519 identity in the identity file")
+	}
+	return k, nil
+}
+
+func (k *ageKey) Wrap(_ context.Context, key []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, k.recipients...)
+	if err != nil {
+		return nil, errors.Wrap(err, "encrypt data key with age")
+	}
+	if _, err := w.Write(key); err != nil {
+		return nil, errors.Wrap(err, "encrypt data key with age")
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "encrypt data key with age")
+	}
+	return buf.Bytes(), nil
+}
+
+func (k *ageKey) Unwrap(_ context.Context, wrapped []byte) ([]byte, error) {
+	r, err := age.Decrypt(bytes.NewReader(wrapped), k.identities...)
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypt data key with age, was it encrypted for another identity?")
+	}
+	key, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypt data key with age")
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "create cipher")
+	}
+	return cipher.NewGCM(block)
+}
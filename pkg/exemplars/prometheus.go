@@ -5,6 +5,7 @@ package exemplars
 
 import (
 	"context"
+	"fmt"
 	"net/url"
 
 	"github.com/prometheus/prometheus/model/labels"
@@ -19,35 +20,41 @@ import (
 type Prometheus struct {
 	base   *url.URL
 	client *promclient.Client
+	cache  *promclient.ResponseCache
 
 	extLabels func() labels.Labels
 }
 
-// NewPrometheus creates new exemplars.Prometheus.
-func NewPrometheus(base *url.URL, client *promclient.Client, extLabels func() labels.Labels) *Prometheus {
+// NewPrometheus creates new exemplars.Prometheus. Responses are cached by the given cache, if not nil.
+func NewPrometheus(base *url.URL, client *promclient.Client, cache *promclient.ResponseCache, extLabels func() labels.Labels) *Prometheus {
 	return &Prometheus{
 		base:      base,
 		client:    client,
+		cache:     cache,
 		extLabels: extLabels,
 	}
 }
 
 // Exemplars returns all specified exemplars from Prometheus.
 func (p *Prometheus) Exemplars(r *exemplarspb.ExemplarsRequest, s exemplarspb.Exemplars_ExemplarsServer) error {
-	exemplars, err := p.client.ExemplarsInGRPC(s.Context(), p.base, r.Query, r.Start, r.End)
+	resp, err := p.cache.Get(fmt.Sprintf("%s\x00%d\x00%d", r.Query, r.Start, r.End), func() (interface{}, error) {
+		return p.client.ExemplarsInGRPC(s.Context(), p.base, r.Query, r.Start, r.End)
+	})
 	if err != nil {
 		return err
 	}
 
 	// Prometheus does not add external labels, so we need to add on our own.
 	extLset := p.extLabels()
-	for _, e := range exemplars {
+	for _, e := range resp.([]*exemplarspb.ExemplarData) {
+		// Copy the exemplars, as they might be cached.
+		e := *e
 		// Make sure the returned series labels are sorted.
 		e.SetSeriesLabels(labelpb.ExtendSortedLabels(e.SeriesLabels.PromLabels(), extLset))
 
 		var err error
 		tracing.DoInSpan(s.Context(), "send_exemplars_response", func(_ context.Context) {
-			err = s.Send(&exemplarspb.ExemplarsResponse{Result: &exemplarspb.ExemplarsResponse_Data{Data: e}})
+			err = s.Send(&exemplarspb.ExemplarsResponse{Result: &exemplarspb.ExemplarsResponse_Data{Data: &e}})
 		})
 		if err != nil {
 			return err
@@ -4,7 +4,9 @@
 package extgrpc
 
 import (
+	"context"
 	"math"
+	"net"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
@@ -57,12 +59,16 @@ func StoreClientGRPCOpts(logger log.Logger, reg *prometheus.Registry, tracer ope
 			grpc_middleware.ChainUnaryClient(
 				grpcMets.UnaryClientInterceptor(),
 				tracing.UnaryClientInterceptor(tracer),
+				RequestIDUnaryClientInterceptor(),
+				TenantUnaryClientInterceptor(),
 			),
 		),
 		grpc.WithStreamInterceptor(
 			grpc_middleware.ChainStreamClient(
 				grpcMets.StreamClientInterceptor(),
 				tracing.StreamClientInterceptor(tracer),
+				RequestIDStreamClientInterceptor(),
+				TenantStreamClientInterceptor(),
 			),
 		),
 	}
@@ -76,9 +82,41 @@ func StoreClientGRPCOpts(logger log.Logger, reg *prometheus.Registry, tracer ope
 
 	level.Info(logger).Log("msg", "enabling client to server TLS")
 
-	tlsCfg, err := tls.NewClientConfig(logger, cert, key, caCert, serverName, skipVerify)
+	creds, err := newReloadingTLSCredentials(logger, cert, key, caCert, serverName, skipVerify)
 	if err != nil {
 		return nil, err
 	}
-	return append(dialOpts, grpc.WithTransportCredentials(credentials.NewTLS(tlsCfg))), nil
+	return append(dialOpts, grpc.WithTransportCredentials(creds)), nil
+}
+
+// reloadingTLSCredentials are TLS transport credentials using the current TLS configuration of the reloader for each
+// handshake, so rotated CA certificates are used by new connections without a restart.
+type reloadingTLSCredentials struct {
+	credentials.TransportCredentials
+
+	reloader *tls.ClientConfigReloader
+}
+
+func newReloadingTLSCredentials(logger log.Logger, cert, key, caCert, serverName string, skipVerify bool) (*reloadingTLSCredentials, error) {
+	reloader, err := tls.NewClientConfigReloader(logger, cert, key, caCert, serverName, skipVerify)
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg, err := reloader.Config()
+	if err != nil {
+		return nil, err
+	}
+	return &reloadingTLSCredentials{TransportCredentials: credentials.NewTLS(tlsCfg), reloader: reloader}, nil
+}
+
+func (c *reloadingTLSCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	tlsCfg, err := c.reloader.Config()
+	if err != nil {
+		return nil, nil, err
+	}
+	return credentials.NewTLS(tlsCfg).ClientHandshake(ctx, authority, rawConn)
+}
+
+func (c *reloadingTLSCredentials) Clone() credentials.TransportCredentials {
+	return &reloadingTLSCredentials{TransportCredentials: c.TransportCredentials.Clone(), reloader: c.reloader}
 }
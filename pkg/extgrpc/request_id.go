@@ -0,0 +1,89 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extgrpc
+
+import (
+	"context"
+	"strings"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/tags"
+	"github.com/opentracing/opentracing-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
+)
+
+// requestIDMetadataKey is the gRPC metadata key carrying the request id of the HTTP request a gRPC request is made for.
+var requestIDMetadataKey = strings.ToLower(middleware.RequestIDHeader)
+
+// RequestIDUnaryClientInterceptor returns a new unary client interceptor propagating the request id of the context.
+func RequestIDUnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoingContextWithRequestID(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// RequestIDStreamClientInterceptor returns a new streaming client interceptor propagating the request id of the context.
+func RequestIDStreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoingContextWithRequestID(ctx), desc, cc, method, opts...)
+	}
+}
+
+// RequestIDUnaryServerInterceptor returns a new unary server interceptor adding the propagated request id to the
+// context, the span and the logging tags of the request.
+func RequestIDUnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(incomingContextWithRequestID(ctx), req)
+	}
+}
+
+// RequestIDStreamServerInterceptor returns a new streaming server interceptor adding the propagated request id to the
+// context, the span and the logging tags of the request.
+func RequestIDStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &contextServerStream{ServerStream: stream, ctx: incomingContextWithRequestID(stream.Context())})
+	}
+}
+
+func outgoingContextWithRequestID(ctx context.Context) context.Context {
+	reqID, ok := middleware.RequestIDFromContext(ctx)
+	if !ok || reqID == "" {
+		return ctx
+	}
+	// Requests forwarded by a server already carry the request id in their metadata.
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(requestIDMetadataKey)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, requestIDMetadataKey, reqID)
+}
+
+func incomingContextWithRequestID(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	ids := md.Get(requestIDMetadataKey)
+	if len(ids) == 0 || ids[0] == "" {
+		return ctx
+	}
+	reqID := ids[0]
+
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		span.SetTag("request_id", reqID)
+	}
+	tags.Extract(ctx).Set("request_id", reqID)
+	return middleware.NewContextWithRequestID(ctx, reqID)
+}
+
+// contextServerStream is a grpc.ServerStream with the context set by a server interceptor.
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,72 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extgrpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
+)
+
+func TestRequestIDPropagation(t *testing.T) {
+	ctx := middleware.NewContextWithRequestID(context.Background(), "query-1")
+
+	var md metadata.MD
+	err := RequestIDUnaryClientInterceptor()(ctx, "/thanos.Store/Series", nil, nil, nil, func(ctx context.Context, _ string, _, _ interface{}, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"query-1"}, md.Get("x-request-id"))
+
+	var reqID string
+	_, err = RequestIDUnaryServerInterceptor()(metadata.NewIncomingContext(context.Background(), md), nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		reqID, _ = middleware.RequestIDFromContext(ctx)
+		return nil, nil
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, "query-1", reqID)
+
+	// Without request id, nothing is propagated.
+	err = RequestIDUnaryClientInterceptor()(context.Background(), "/thanos.Store/Series", nil, nil, nil, func(ctx context.Context, _ string, _, _ interface{}, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(md.Get("x-request-id")))
+}
+
+func TestTenantPropagation(t *testing.T) {
+	ctx := middleware.NewContextWithTenant(context.Background(), "team-a")
+
+	var md metadata.MD
+	err := TenantUnaryClientInterceptor()(ctx, "/thanos.Store/Series", nil, nil, nil, func(ctx context.Context, _ string, _, _ interface{}, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"team-a"}, md.Get("thanos-tenant"))
+
+	var tenant string
+	_, err = TenantUnaryServerInterceptor()(metadata.NewIncomingContext(context.Background(), md), nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		tenant, _ = middleware.TenantFromContext(ctx)
+		return nil, nil
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, "team-a", tenant)
+
+	// The tenant already in the metadata, e.g. sent by rulers, is kept.
+	ctx = metadata.AppendToOutgoingContext(ctx, "thanos-tenant", "team-b")
+	err = TenantUnaryClientInterceptor()(ctx, "/thanos.Store/Series", nil, nil, nil, func(ctx context.Context, _ string, _, _ interface{}, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"team-b"}, md.Get("thanos-tenant"))
+}
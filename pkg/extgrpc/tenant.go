@@ -0,0 +1,73 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extgrpc
+
+import (
+	"context"
+	"strings"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/tags"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
+)
+
+// tenantMetadataKey is the gRPC metadata key carrying the tenant of the request a gRPC request is made for.
+var tenantMetadataKey = strings.ToLower(middleware.TenantHeader)
+
+// TenantUnaryClientInterceptor returns a new unary client interceptor propagating the tenant of the context.
+func TenantUnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoingContextWithTenant(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// TenantStreamClientInterceptor returns a new streaming client interceptor propagating the tenant of the context.
+func TenantStreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoingContextWithTenant(ctx), desc, cc, method, opts...)
+	}
+}
+
+// TenantUnaryServerInterceptor returns a new unary server interceptor adding the propagated tenant to the context and
+// the logging tags of the request.
+func TenantUnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(incomingContextWithTenant(ctx), req)
+	}
+}
+
+// TenantStreamServerInterceptor returns a new streaming server interceptor adding the propagated tenant to the
+// context and the logging tags of the request.
+func TenantStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &contextServerStream{ServerStream: stream, ctx: incomingContextWithTenant(stream.Context())})
+	}
+}
+
+func outgoingContextWithTenant(ctx context.Context) context.Context {
+	tenant, ok := middleware.TenantFromContext(ctx)
+	if !ok || tenant == "" {
+		return ctx
+	}
+	// Requests forwarded by a server, or sent by rulers, may already carry the tenant in their metadata.
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(tenantMetadataKey)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, tenantMetadataKey, tenant)
+}
+
+func incomingContextWithTenant(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	tenants := md.Get(tenantMetadataKey)
+	if len(tenants) == 0 || tenants[0] == "" {
+		return ctx
+	}
+	tags.Extract(ctx).Set("tenant", tenants[0])
+	return middleware.NewContextWithTenant(ctx, tenants[0])
+}
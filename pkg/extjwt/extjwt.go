@@ -0,0 +1,122 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package extjwt validates JWT bearer tokens, for the authentication of HTTP requests and the tenancy of receivers.
+package extjwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+)
+
+// KeySet gives the public keys tokens with the given key ID can be signed with.
+type KeySet interface {
+	Keys(kid string) ([]crypto.PublicKey, error)
+}
+
+// StaticKeys are public keys any token can be signed with, regardless of its key ID.
+type StaticKeys []crypto.PublicKey
+
+// Keys implements KeySet.
+func (k StaticKeys) Keys(string) ([]crypto.PublicKey, error) {
+	return k, nil
+}
+
+// ReadPEMKeys reads the PEM encoded public keys of the given file.
+func ReadPEMKeys(path string) (StaticKeys, error) {
+	pemKeys, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read JWT public keys file")
+	}
+	var keys StaticKeys
+	for block, rest := pem.Decode(pemKeys); block != nil; block, rest = pem.Decode(rest) {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse JWT public key")
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.Errorf("no PEM encoded public key found in %s", path)
+	}
+	return keys, nil
+}
+
+// Validator validates JWT tokens against the keys of a KeySet, and the issuer and audience they must have, if set.
+type Validator struct {
+	keys     KeySet
+	issuer   string
+	audience string
+}
+
+// NewValidator returns a Validator of tokens signed with the given keys.
+func NewValidator(keys KeySet, issuer, audience string) *Validator {
+	return &Validator{keys: keys, issuer: issuer, audience: audience}
+}
+
+// Validate validates the signature, expiration, issuer and audience of the token and returns its claims. Tokens
+// without expiration are rejected, as they would stay valid forever once leaked.
+func (v *Validator) Validate(token string) (jwt.MapClaims, error) {
+	unverified, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid bearer token")
+	}
+	kid, _ := unverified.Header["kid"].(string)
+	keys, err := v.keys.Keys(kid)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid bearer token")
+	}
+
+	var claims jwt.MapClaims
+	err = errors.New("no key to verify token with")
+	for _, key := range keys {
+		claims = jwt.MapClaims{}
+		if _, err = jwt.ParseWithClaims(token, claims, keyFunc(key)); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid bearer token")
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, errors.New("invalid bearer token: missing or expired exp claim")
+	}
+	if v.issuer != "" && !claims.VerifyIssuer(v.issuer, true) {
+		return nil, errors.New("invalid bearer token: unexpected issuer")
+	}
+	if v.audience != "" && !claims.VerifyAudience(v.audience, true) {
+		return nil, errors.New("invalid bearer token: unexpected audience")
+	}
+	return claims, nil
+}
+
+// keyFunc returns the key to verify tokens with, as long as they are signed with a method of the key's type.
+func keyFunc(key crypto.PublicKey) jwt.Keyfunc {
+	return func(t *jwt.Token) (interface{}, error) {
+		var ok bool
+		switch key.(type) {
+		case *rsa.PublicKey:
+			switch t.Method.(type) {
+			case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+				ok = true
+			}
+		case *ecdsa.PublicKey:
+			_, ok = t.Method.(*jwt.SigningMethodECDSA)
+		case ed25519.PublicKey:
+			_, ok = t.Method.(*jwt.SigningMethodEd25519)
+		}
+		if !ok {
+			return nil, errors.Errorf("unexpected signing method %s", t.Method.Alg())
+		}
+		return key, nil
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extjwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// minJWKSRefreshInterval limits how often tokens signed with an unknown key can cause the JWKS to be fetched.
+const minJWKSRefreshInterval = time.Minute
+
+// JWKS is the JSON Web Key Set served by a URL, which is fetched again once it is older than the refresh interval or
+// a token is signed with an unknown key.
+type JWKS struct {
+	logger          log.Logger
+	client          *http.Client
+	url             string
+	refreshInterval time.Duration
+
+	mtx         sync.Mutex
+	keys        map[string]crypto.PublicKey
+	refreshedAt time.Time
+}
+
+// NewJWKS fetches the JSON Web Key Set served by the given URL.
+func NewJWKS(logger log.Logger, url string, refreshInterval time.Duration) (*JWKS, error) {
+	s := &JWKS{
+		logger:          logger,
+		client:          &http.Client{Timeout: 30 * time.Second},
+		url:             url,
+		refreshInterval: refreshInterval,
+	}
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	s.refreshedAt = time.Now()
+	return s, nil
+}
+
+// Keys implements KeySet. It returns the key with the given key ID.
+func (s *JWKS) Keys(kid string) ([]crypto.PublicKey, error) {
+	s.mtx.Lock()
+	key, ok := s.keys[kid]
+	sinceRefresh := time.Since(s.refreshedAt)
+	refresh := sinceRefresh > s.refreshInterval || (!ok && sinceRefresh > minJWKSRefreshInterval)
+	if refresh {
+		// Refresh attempts are rate limited even if they fail, and only one request refreshes at a time.
+		s.refreshedAt = time.Now()
+	}
+	s.mtx.Unlock()
+
+	if refresh {
+		if err := s.refresh(); err != nil {
+			// Keep using the keys fetched before.
+			level.Warn(s.logger).Log("msg", "failed to refresh JWKS", "url", s.url, "err", err)
+		}
+		s.mtx.Lock()
+		key, ok = s.keys[kid]
+		s.mtx.Unlock()
+	}
+	if !ok {
+		if kid == "" {
+			return nil, errors.New("no key ID in token header and JWKS has more than one key")
+		}
+		return nil, errors.Errorf("unknown key ID %s", kid)
+	}
+	return []crypto.PublicKey{key}, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// refresh fetches the JSON Web Key Set. Keys of unsupported types and keys not used for signatures are ignored.
+func (s *JWKS) refresh() error {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return errors.Wrap(err, "fetch JWKS")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("fetch JWKS: unexpected status %s", resp.Status)
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return errors.Wrap(err, "decode JWKS")
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			level.Warn(s.logger).Log("msg", "ignoring JWKS key", "kid", k.Kid, "err", err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return errors.Errorf("no supported signing key found in JWKS from %s", s.url)
+	}
+	// Tokens without key ID can be validated when the set has a single key.
+	if len(jwks.Keys) == 1 {
+		for kid, key := range keys {
+			if kid != "" {
+				keys[""] = key
+			}
+		}
+	}
+
+	s.mtx.Lock()
+	s.keys = keys
+	s.mtx.Unlock()
+	return nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, errors.Wrap(err, "decode modulus")
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, errors.Wrap(err, "decode exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, errors.Wrap(err, "decode x coordinate")
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, errors.Wrap(err, "decode y coordinate")
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, errors.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, errors.Wrap(err, "decode public key")
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.Errorf("invalid Ed25519 public key size %d", len(x))
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, errors.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
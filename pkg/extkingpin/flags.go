@@ -83,6 +83,16 @@ func RegisterHTTPFlags(cmd FlagClause) (httpBindAddr *string, httpGracePeriod *m
 	return httpBindAddr, httpGracePeriod, httpTLSConfig
 }
 
+// RegisterHTTPAuthFlag registers the flag to pass the authentication configuration of the HTTP endpoints.
+func RegisterHTTPAuthFlag(cmd FlagClause) *extflag.PathOrContent {
+	return extflag.RegisterPathOrContent(
+		cmd,
+		"http.auth-config",
+		"[EXPERIMENTAL] YAML file with the basic auth, bearer token or JWT authentication required by all HTTP endpoints but the probes. See format details: https://thanos.io/tip/operating/https.md/#authentication-configuration ",
+		extflag.WithEnvSubstitution(),
+	)
+}
+
 // RegisterCommonObjStoreFlags register flags to specify object storage configuration.
 func RegisterCommonObjStoreFlags(cmd FlagClause, suffix string, required bool, extraDesc ...string) *extflag.PathOrContent {
 	help := fmt.Sprintf("YAML file that contains object store%s configuration. See format details: https://thanos.io/tip/thanos/storage.md/#configuration ", suffix)
@@ -104,6 +114,16 @@ func RegisterCommonTracingFlags(app FlagClause) *extflag.PathOrContent {
 	)
 }
 
+// RegisterDiagnosticsFlags registers flags to pass the configuration of the capture of profiles on resource pressure.
+func RegisterDiagnosticsFlags(app FlagClause) *extflag.PathOrContent {
+	return extflag.RegisterPathOrContent(
+		app,
+		"diagnostics.config",
+		"YAML file with the configuration of the capture of profiles when the component is under resource pressure, and of their upload to object storage. See format details: https://thanos.io/tip/operating/diagnostics.md/#configuration",
+		extflag.WithEnvSubstitution(),
+	)
+}
+
 // RegisterRequestLoggingFlags registers flags to pass a request logging configuration to be used.
 func RegisterRequestLoggingFlags(app FlagClause) *extflag.PathOrContent {
 	return extflag.RegisterPathOrContent(
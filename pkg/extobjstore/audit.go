@@ -0,0 +1,278 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
+	"github.com/thanos-io/thanos/pkg/tracing"
+)
+
+const (
+	auditOpUpload = "upload"
+	auditOpDelete = "delete"
+	auditOpMark   = "mark"
+
+	// maxPendingAuditRecords is the maximum number of audit records kept while they can't be written to the bucket.
+	maxPendingAuditRecords = 100000
+)
+
+// AuditConfig is the configuration of the audit log of the mutating operations against the bucket, in the audit
+// section of the bucket configuration. Exactly one of file and prefix must be set.
+type AuditConfig struct {
+	// File is the file audit records are appended to.
+	File string `yaml:"file"`
+	// Prefix is the prefix of the bucket audit records are written to, in an object every flush interval.
+	Prefix        string         `yaml:"prefix"`
+	FlushInterval model.Duration `yaml:"flush_interval"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *AuditConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = AuditConfig{FlushInterval: model.Duration(time.Minute)}
+	type plain AuditConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if (c.File == "") == (c.Prefix == "") {
+		return errors.New("exactly one of file and prefix must be set")
+	}
+	if c.FlushInterval <= 0 {
+		return errors.New("flush_interval must be positive")
+	}
+	return nil
+}
+
+type tenantKey struct{}
+
+// WithTenant returns a context, the operations with which are recorded in the audit log with the given tenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// auditRecord is a line of the audit log.
+type auditRecord struct {
+	Time      time.Time `json:"time"`
+	Component string    `json:"component"`
+	Operation string    `json:"operation"`
+	Object    string    `json:"object"`
+	Tenant    string    `json:"tenant,omitempty"`
+	TraceID   string    `json:"trace_id,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Result    string    `json:"result"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// auditLog writes audit records to a file, or to objects of a bucket.
+type auditLog struct {
+	logger    log.Logger
+	component string
+	failures  prometheus.Counter
+	dropped   prometheus.Counter
+
+	file *os.File
+
+	bkt           objstore.Bucket
+	prefix        string
+	flushInterval time.Duration
+	stop, done    chan struct{}
+
+	mtx     sync.Mutex
+	pending [][]byte
+}
+
+func (l *auditLog) record(ctx context.Context, op, name string, err error) {
+	r := auditRecord{Time: time.Now().UTC(), Component: l.component, Operation: op, Object: name, Result: "success"}
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+		r.Tenant = tenant
+	}
+	r.TraceID, _ = tracing.TraceIDFromContext(ctx)
+	r.RequestID, _ = middleware.RequestIDFromContext(ctx)
+	if err != nil {
+		r.Result = "error"
+		r.Error = err.Error()
+	}
+	line, jerr := json.Marshal(r)
+	if jerr != nil {
+		l.failures.Inc()
+		level.Error(l.logger).Log("msg", "failed to marshal audit record", "err", jerr)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.file != nil {
+		if _, err := l.file.Write(line); err != nil {
+			l.failures.Inc()
+			level.Error(l.logger).Log("msg", "failed to write audit record", "file", l.file.Name(), "err", err)
+		}
+		return
+	}
+	if len(l.pending) >= maxPendingAuditRecords {
+		l.pending = l.pending[1:]
+		l.dropped.Inc()
+	}
+	l.pending = append(l.pending, line)
+}
+
+func (l *auditLog) run() {
+	defer close(l.done)
+	t := time.NewTicker(l.flushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-t.C:
+			l.flush()
+		}
+	}
+}
+
+// flush writes the pending audit records to an object of the bucket. Records are kept if the upload fails, to be
+// written with the next flush.
+func (l *auditLog) flush() {
+	l.mtx.Lock()
+	pending := l.pending
+	l.pending = nil
+	l.mtx.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	name := path.Join(l.prefix, l.component, fmt.Sprintf("%s-%s.jsonl", now.Format("20060102T150405Z"), ulid.MustNew(ulid.Timestamp(now), rand.Reader)))
+	if err := l.bkt.Upload(context.Background(), name, bytes.NewReader(bytes.Join(pending, nil))); err != nil {
+		l.failures.Inc()
+		level.Error(l.logger).Log("msg", "failed to upload audit records", "object", name, "err", err)
+
+		l.mtx.Lock()
+		defer l.mtx.Unlock()
+		l.pending = append(pending, l.pending...)
+		if n := len(l.pending) - maxPendingAuditRecords; n > 0 {
+			l.pending = l.pending[n:]
+			l.dropped.Add(float64(n))
+		}
+	}
+}
+
+func (l *auditLog) close() error {
+	if l.file != nil {
+		return l.file.Close()
+	}
+	close(l.stop)
+	<-l.done
+	l.flush()
+	return nil
+}
+
+// WrapWithAudit returns a bucket recording the mutating operations against bkt in an audit log, with the component,
+// the tenant of WithTenant, the trace and request IDs of the context, the object and the result of the operation.
+// Uploads of block markers are recorded as mark operations. Records written to the bucket are flushed on Close.
+func WrapWithAudit(logger log.Logger, bkt objstore.InstrumentedBucket, conf AuditConfig, component string, reg prometheus.Registerer) (objstore.InstrumentedBucket, error) {
+	constLabels := prometheus.Labels{"bucket": bkt.Name()}
+	l := &auditLog{
+		logger:    logger,
+		component: component,
+		failures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name:        "thanos_objstore_bucket_audit_write_failures_total",
+			Help:        "Total number of failed writes of records of the audit log of the bucket.",
+			ConstLabels: constLabels,
+		}),
+		dropped: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name:        "thanos_objstore_bucket_audit_records_dropped_total",
+			Help:        "Total number of records of the audit log of the bucket dropped, as they could not be written to the bucket.",
+			ConstLabels: constLabels,
+		}),
+	}
+	if conf.File != "" {
+		f, err := os.OpenFile(conf.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, errors.Wrap(err, "open audit log file")
+		}
+		l.file = f
+	} else {
+		// Records are written to the bucket directly, so that they are not recorded themselves.
+		l.bkt = bkt
+		l.prefix = conf.Prefix
+		l.flushInterval = time.Duration(conf.FlushInterval)
+		l.stop = make(chan struct{})
+		l.done = make(chan struct{})
+		go l.run()
+	}
+	return &auditInstrumentedBucket{auditBucket: auditBucket{Bucket: bkt, l: l}, ib: bkt}, nil
+}
+
+type auditInstrumentedBucket struct {
+	auditBucket
+	ib objstore.InstrumentedBucket
+}
+
+func (b *auditInstrumentedBucket) WithExpectedErrs(f objstore.IsOpFailureExpectedFunc) objstore.Bucket {
+	return &auditBucket{Bucket: b.ib.WithExpectedErrs(f), l: b.l}
+}
+
+func (b *auditInstrumentedBucket) ReaderWithExpectedErrs(f objstore.IsOpFailureExpectedFunc) objstore.BucketReader {
+	return b.ib.ReaderWithExpectedErrs(f)
+}
+
+type auditBucket struct {
+	objstore.Bucket
+	l *auditLog
+}
+
+func isMarker(name string) bool {
+	for _, marker := range []string{metadata.DeletionMarkFilename, metadata.NoCompactMarkFilename, metadata.NoDownsampleMarkFilename} {
+		if strings.HasSuffix(name, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *auditBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	err := b.Bucket.Upload(ctx, name, r)
+	op := auditOpUpload
+	if isMarker(name) {
+		op = auditOpMark
+	}
+	b.l.record(ctx, op, name, err)
+	return err
+}
+
+func (b *auditBucket) Delete(ctx context.Context, name string) error {
+	err := b.Bucket.Delete(ctx, name)
+	b.l.record(ctx, auditOpDelete, name, err)
+	return err
+}
+
+// Close flushes the audit log, and closes the bucket.
+func (b *auditBucket) Close() error {
+	if err := b.l.close(); err != nil {
+		level.Error(b.l.logger).Log("msg", "failed to close audit log", "err", err)
+	}
+	return b.Bucket.Close()
+}
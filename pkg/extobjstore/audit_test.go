@@ -0,0 +1,132 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
+)
+
+func readAuditRecords(t *testing.T, r io.Reader) []auditRecord {
+	var records []auditRecord
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		var rec auditRecord
+		testutil.Ok(t, json.Unmarshal(s.Bytes(), &rec))
+		records = append(records, rec)
+	}
+	testutil.Ok(t, s.Err())
+	return records
+}
+
+type failingDeleteBucket struct {
+	objstore.Bucket
+}
+
+func (b failingDeleteBucket) Delete(context.Context, string) error {
+	return errors.New("delete failed")
+}
+
+func TestWrapWithAudit_File(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.jsonl")
+	bkt, err := WrapWithAudit(log.NewNopLogger(), objstore.WithNoopInstr(failingDeleteBucket{Bucket: objstore.NewInMemBucket()}), AuditConfig{File: file}, "compact", nil)
+	testutil.Ok(t, err)
+
+	// Uploads are done in a request, with its request ID.
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("X-Request-ID", "request-1")
+	middleware.RequestID(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		ctx := WithTenant(r.Context(), "tenant-a")
+		testutil.Ok(t, bkt.Upload(ctx, "01GXXX/meta.json", strings.NewReader("{}")))
+		testutil.Ok(t, bkt.Upload(ctx, "01GXXX/deletion-mark.json", strings.NewReader("{}")))
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	testutil.NotOk(t, bkt.WithExpectedErrs(bkt.IsObjNotFoundErr).Delete(context.Background(), "01GXXX/meta.json"))
+	_, err = bkt.Get(context.Background(), "01GXXX/meta.json")
+	testutil.Ok(t, err)
+	testutil.Ok(t, bkt.Close())
+
+	f, err := os.Open(file)
+	testutil.Ok(t, err)
+	defer f.Close()
+	records := readAuditRecords(t, f)
+	testutil.Equals(t, 3, len(records))
+
+	testutil.Equals(t, auditOpUpload, records[0].Operation)
+	testutil.Equals(t, "01GXXX/meta.json", records[0].Object)
+	testutil.Equals(t, "compact", records[0].Component)
+	testutil.Equals(t, "tenant-a", records[0].Tenant)
+	testutil.Equals(t, "request-1", records[0].RequestID)
+	testutil.Equals(t, "success", records[0].Result)
+
+	testutil.Equals(t, auditOpMark, records[1].Operation)
+
+	testutil.Equals(t, auditOpDelete, records[2].Operation)
+	testutil.Equals(t, "", records[2].Tenant)
+	testutil.Equals(t, "error", records[2].Result)
+	testutil.Equals(t, "delete failed", records[2].Error)
+}
+
+func TestWrapWithAudit_Prefix(t *testing.T) {
+	inner := objstore.NewInMemBucket()
+	bkt, err := WrapWithAudit(log.NewNopLogger(), objstore.WithNoopInstr(inner), AuditConfig{Prefix: "audit", FlushInterval: model.Duration(time.Hour)}, "store", nil)
+	testutil.Ok(t, err)
+
+	ctx := context.Background()
+	testutil.Ok(t, bkt.Upload(ctx, "obj", strings.NewReader("content")))
+	testutil.Ok(t, bkt.Delete(ctx, "obj"))
+	testutil.Ok(t, bkt.Close())
+
+	// Records are flushed on close, and the objects of the audit log are not recorded themselves.
+	var names []string
+	testutil.Ok(t, inner.Iter(ctx, "audit/store/", func(name string) error {
+		names = append(names, name)
+		return nil
+	}))
+	testutil.Equals(t, 1, len(names))
+	testutil.Assert(t, strings.HasSuffix(names[0], ".jsonl"))
+
+	r, err := inner.Get(ctx, names[0])
+	testutil.Ok(t, err)
+	b, err := io.ReadAll(r)
+	testutil.Ok(t, err)
+	records := readAuditRecords(t, bytes.NewReader(b))
+	testutil.Equals(t, 2, len(records))
+	testutil.Equals(t, auditOpUpload, records[0].Operation)
+	testutil.Equals(t, auditOpDelete, records[1].Operation)
+}
+
+func TestNewBucket_Audit(t *testing.T) {
+	dir := t.TempDir()
+	bkt, err := NewBucket(log.NewNopLogger(), []byte("type: FILESYSTEM\nconfig:\n  directory: "+dir+"\naudit:\n  file: "+filepath.Join(dir, "audit.jsonl")+"\n"), nil, "store")
+	testutil.Ok(t, err)
+	testutil.Ok(t, bkt.Upload(context.Background(), "obj", strings.NewReader("content")))
+	testutil.Ok(t, bkt.Close())
+
+	for _, conf := range []string{
+		"audit: {}\n",
+		"audit:\n  file: audit.jsonl\n  prefix: audit\n",
+		"audit:\n  prefix: audit\n  flush_interval: 0s\n",
+	} {
+		_, err = NewBucket(log.NewNopLogger(), []byte("type: FILESYSTEM\nconfig:\n  directory: "+dir+"\n"+conf), nil, "store")
+		testutil.NotOk(t, err)
+	}
+}
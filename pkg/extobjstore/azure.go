@@ -0,0 +1,305 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/client"
+	"github.com/thanos-io/objstore/exthttp"
+	"github.com/thanos-io/objstore/providers/azure"
+	"gopkg.in/yaml.v2"
+)
+
+// AzureWorkloadIdentityConfig is the configuration of the Azure AD workload identity Azure containers are accessed
+// with, in the azure_workload_identity section of the bucket configuration. Empty fields are read from the
+// environment variables set by the workload identity webhook of AKS.
+type AzureWorkloadIdentityConfig struct {
+	TenantID string `yaml:"tenant_id"`
+	ClientID string `yaml:"client_id"`
+	// FederatedTokenFile is read whenever a new access token is requested, as the token is rotated.
+	FederatedTokenFile string `yaml:"federated_token_file"`
+	// AuthorityHost is the Azure AD endpoint, e.g. of a sovereign cloud. The Azure public cloud is used if empty.
+	AuthorityHost string `yaml:"authority_host"`
+}
+
+// resolve returns the configuration with empty fields set from the environment.
+func (c AzureWorkloadIdentityConfig) resolve() (AzureWorkloadIdentityConfig, error) {
+	for _, f := range []struct {
+		val *string
+		env string
+	}{
+		{val: &c.TenantID, env: "AZURE_TENANT_ID"},
+		{val: &c.ClientID, env: "AZURE_CLIENT_ID"},
+		{val: &c.FederatedTokenFile, env: "AZURE_FEDERATED_TOKEN_FILE"},
+		{val: &c.AuthorityHost, env: "AZURE_AUTHORITY_HOST"},
+	} {
+		if *f.val == "" {
+			*f.val = os.Getenv(f.env)
+		}
+	}
+	if c.TenantID == "" || c.ClientID == "" || c.FederatedTokenFile == "" {
+		return c, errors.New("tenant_id, client_id and federated_token_file of the workload identity must be configured, or set with the AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_FEDERATED_TOKEN_FILE environment variables")
+	}
+	return c, nil
+}
+
+// assertion returns the federated token of the workload identity.
+func (c AzureWorkloadIdentityConfig) assertion(context.Context) (string, error) {
+	token, err := os.ReadFile(c.FederatedTokenFile)
+	if err != nil {
+		return "", errors.Wrap(err, "read federated token file")
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// newAzureWorkloadIdentityBucket returns the Azure container of conf, accessed with the workload identity of wiConf.
+func newAzureWorkloadIdentityBucket(logger log.Logger, conf client.BucketConfig, wiConf AzureWorkloadIdentityConfig, uploads UploadsConfig, reg prometheus.Registerer, component string) (objstore.InstrumentedBucket, error) {
+	if !strings.EqualFold(string(conf.Type), string(client.AZURE)) {
+		return nil, errors.Errorf("azure_workload_identity section is not supported by %s buckets", conf.Type)
+	}
+	content, err := yaml.Marshal(conf.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal content of bucket configuration")
+	}
+	azConf := azure.DefaultConfig
+	if err := yaml.UnmarshalStrict(content, &azConf); err != nil {
+		return nil, errors.Wrap(err, "parsing Azure configuration")
+	}
+	// Like the Azure client, the generic max_retries applies if the specific retries are not set.
+	if azConf.MaxRetries > 0 {
+		if azConf.PipelineConfig.MaxTries == 0 {
+			azConf.PipelineConfig.MaxTries = int32(azConf.MaxRetries)
+		}
+		if azConf.ReaderConfig.MaxRetryRequests == 0 {
+			azConf.ReaderConfig.MaxRetryRequests = azConf.MaxRetries
+		}
+	}
+	switch {
+	case azConf.StorageAccountName == "":
+		return nil, errors.New("storage_account_name is required but not configured")
+	case azConf.ContainerName == "":
+		return nil, errors.New("no container specified")
+	case azConf.StorageAccountKey != "" || azConf.UserAssignedID != "":
+		return nil, errors.New("storage_account_key and user_assigned_id cannot be set when using workload identity authentication")
+	}
+
+	wiConf, err = wiConf.resolve()
+	if err != nil {
+		return nil, err
+	}
+	dt, err := exthttp.DefaultTransport(azConf.HTTPConfig)
+	if err != nil {
+		return nil, err
+	}
+	clientOpts := azcore.ClientOptions{
+		Retry: policy.RetryOptions{
+			MaxRetries:    azConf.PipelineConfig.MaxTries,
+			TryTimeout:    time.Duration(azConf.PipelineConfig.TryTimeout),
+			RetryDelay:    time.Duration(azConf.PipelineConfig.RetryDelay),
+			MaxRetryDelay: time.Duration(azConf.PipelineConfig.MaxRetryDelay),
+		},
+		Telemetry: policy.TelemetryOptions{
+			ApplicationID: "Thanos",
+		},
+		Transport: &http.Client{Transport: dt},
+	}
+	credOpts := &azidentity.ClientAssertionCredentialOptions{ClientOptions: clientOpts}
+	if wiConf.AuthorityHost != "" {
+		credOpts.Cloud = cloud.Configuration{ActiveDirectoryAuthorityHost: wiConf.AuthorityHost}
+	}
+	// Access tokens are cached, and requested again with the current federated token before they expire.
+	cred, err := azidentity.NewClientAssertionCredential(wiConf.TenantID, wiConf.ClientID, wiConf.assertion, credOpts)
+	if err != nil {
+		return nil, errors.Wrap(err, "create workload identity credential")
+	}
+	containerURL := fmt.Sprintf("https://%s.%s/%s", azConf.StorageAccountName, azConf.Endpoint, azConf.ContainerName)
+	containerClient, err := container.NewClient(containerURL, cred, &container.ClientOptions{ClientOptions: clientOpts})
+	if err != nil {
+		return nil, errors.Wrap(err, "create Azure container client")
+	}
+
+	// Check if storage account container already exists, and create one if it does not.
+	ctx := context.Background()
+	if _, err := containerClient.GetProperties(ctx, &container.GetPropertiesOptions{}); err != nil {
+		if !bloberror.HasCode(err, bloberror.ContainerNotFound) {
+			return nil, err
+		}
+		if _, err := containerClient.Create(ctx, nil); err != nil {
+			return nil, errors.Wrapf(err, "error creating Azure blob container: %s", azConf.ContainerName)
+		}
+		level.Info(logger).Log("msg", "Azure blob container successfully created", "address", azConf.ContainerName)
+	}
+
+	level.Info(logger).Log("msg", "accessing Azure container with workload identity", "client_id", wiConf.ClientID, "component", component)
+	bkt := &azureBucket{
+		logger:           logger,
+		containerClient:  containerClient,
+		containerName:    azConf.ContainerName,
+		readerMaxRetries: azConf.ReaderConfig.MaxRetryRequests,
+		blockSize:        3 * 1024 * 1024,
+		concurrency:      4,
+	}
+	if uploads.PartSize > 0 {
+		bkt.blockSize = int(uploads.PartSize)
+	}
+	if uploads.Concurrency > 0 {
+		bkt.concurrency = uploads.Concurrency
+	}
+	return objstore.NewTracingBucket(objstore.BucketWithMetrics(bkt.Name(), objstore.NewPrefixedBucket(bkt, conf.Prefix), reg)), nil
+}
+
+// azureBucket is the bucket of the Azure client, with a container client authenticated with other credentials.
+type azureBucket struct {
+	logger           log.Logger
+	containerClient  *container.Client
+	containerName    string
+	readerMaxRetries int
+	blockSize        int
+	concurrency      int
+}
+
+func (b *azureBucket) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	prefix := dir
+	if prefix != "" && !strings.HasSuffix(prefix, azure.DirDelim) {
+		prefix += azure.DirDelim
+	}
+
+	if objstore.ApplyIterOptions(options...).Recursive {
+		pager := b.containerClient.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{Prefix: &prefix})
+		for pager.More() {
+			resp, err := pager.NextPage(ctx)
+			if err != nil {
+				return err
+			}
+			for _, blob := range resp.Segment.BlobItems {
+				if err := f(*blob.Name); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	pager := b.containerClient.NewListBlobsHierarchyPager(azure.DirDelim, &container.ListBlobsHierarchyOptions{Prefix: &prefix})
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, blobItem := range resp.Segment.BlobItems {
+			if err := f(*blobItem.Name); err != nil {
+				return err
+			}
+		}
+		for _, blobPrefix := range resp.Segment.BlobPrefixes {
+			if err := f(*blobPrefix.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *azureBucket) IsObjNotFoundErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	return bloberror.HasCode(err, bloberror.BlobNotFound) || bloberror.HasCode(err, bloberror.InvalidURI)
+}
+
+func (b *azureBucket) getBlobReader(ctx context.Context, name string, httpRange blob.HTTPRange) (io.ReadCloser, error) {
+	if name == "" {
+		return nil, errors.New("blob name cannot be empty")
+	}
+	blobClient := b.containerClient.NewBlobClient(name)
+	resp, err := blobClient.DownloadStream(ctx, &blob.DownloadStreamOptions{Range: httpRange})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot download blob, address: %s", blobClient.URL())
+	}
+	return resp.NewRetryReader(ctx, &azblob.RetryReaderOptions{MaxRetries: int32(b.readerMaxRetries)}), nil
+}
+
+func (b *azureBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.getBlobReader(ctx, name, blob.HTTPRange{})
+}
+
+func (b *azureBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	return b.getBlobReader(ctx, name, blob.HTTPRange{Offset: off, Count: length})
+}
+
+func (b *azureBucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	resp, err := b.containerClient.NewBlobClient(name).GetProperties(ctx, nil)
+	if err != nil {
+		return objstore.ObjectAttributes{}, err
+	}
+	return objstore.ObjectAttributes{
+		Size:         *resp.ContentLength,
+		LastModified: *resp.LastModified,
+	}, nil
+}
+
+func (b *azureBucket) Exists(ctx context.Context, name string) (bool, error) {
+	if _, err := b.containerClient.NewBlobClient(name).GetProperties(ctx, nil); err != nil {
+		if b.IsObjNotFoundErr(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "cannot get properties for Azure blob, address: %s", name)
+	}
+	return true, nil
+}
+
+func (b *azureBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	opts := &blockblob.UploadStreamOptions{
+		BlockSize:   b.blockSize,
+		Concurrency: b.concurrency,
+	}
+	// The storage class is the access tier of the blob, e.g. Cool.
+	if class := storageClassFromContext(ctx, ""); class != "" {
+		opts.AccessTier = to.Ptr(blob.AccessTier(class))
+	}
+	if _, err := b.containerClient.NewBlockBlobClient(name).UploadStream(ctx, r, opts); err != nil {
+		return errors.Wrapf(err, "cannot upload Azure blob, address: %s", name)
+	}
+	return nil
+}
+
+func (b *azureBucket) Delete(ctx context.Context, name string) error {
+	opt := &blob.DeleteOptions{
+		DeleteSnapshots: to.Ptr(blob.DeleteSnapshotsOptionTypeInclude),
+	}
+	if _, err := b.containerClient.NewBlobClient(name).Delete(ctx, opt); err != nil {
+		return errors.Wrapf(err, "error deleting blob, address: %s", name)
+	}
+	return nil
+}
+
+func (b *azureBucket) Name() string {
+	return b.containerName
+}
+
+func (b *azureBucket) Close() error {
+	return nil
+}
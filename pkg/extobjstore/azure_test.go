@@ -0,0 +1,52 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+)
+
+func TestAzureWorkloadIdentityConfig(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	testutil.Ok(t, os.WriteFile(tokenFile, []byte("token-1\n"), 0600))
+
+	t.Setenv("AZURE_TENANT_ID", "")
+	t.Setenv("AZURE_CLIENT_ID", "")
+	t.Setenv("AZURE_FEDERATED_TOKEN_FILE", "")
+	t.Setenv("AZURE_AUTHORITY_HOST", "")
+	_, err := AzureWorkloadIdentityConfig{}.resolve()
+	testutil.NotOk(t, err)
+
+	// Fields are set by the environment variables of the workload identity webhook, unless configured.
+	t.Setenv("AZURE_TENANT_ID", "tenant")
+	t.Setenv("AZURE_CLIENT_ID", "client")
+	t.Setenv("AZURE_FEDERATED_TOKEN_FILE", tokenFile)
+	conf, err := AzureWorkloadIdentityConfig{ClientID: "configured"}.resolve()
+	testutil.Ok(t, err)
+	testutil.Equals(t, AzureWorkloadIdentityConfig{TenantID: "tenant", ClientID: "configured", FederatedTokenFile: tokenFile}, conf)
+
+	// The token file is read again for every assertion, as the token is rotated.
+	token, err := conf.assertion(context.Background())
+	testutil.Ok(t, err)
+	testutil.Equals(t, "token-1", token)
+	testutil.Ok(t, os.WriteFile(tokenFile, []byte("token-2"), 0600))
+	token, err = conf.assertion(context.Background())
+	testutil.Ok(t, err)
+	testutil.Equals(t, "token-2", token)
+
+	for _, content := range []string{
+		"type: FILESYSTEM\nconfig:\n  directory: " + t.TempDir() + "\nazure_workload_identity: {}\n",
+		"type: AZURE\nconfig:\n  container: test\nazure_workload_identity: {}\n",
+		"type: AZURE\nconfig:\n  storage_account: test\n  storage_account_key: key\n  container: test\nazure_workload_identity: {}\n",
+	} {
+		_, err = NewBucket(log.NewNopLogger(), []byte(content), nil, "test")
+		testutil.NotOk(t, err)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"context"
+	"io"
+	"path"
+	"time"
+
+	"github.com/jpillora/backoff"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+// ConsistencyCheckConfig is the configuration of the checks that critical objects are visible after their upload, in
+// the consistency_check section of the bucket configuration.
+type ConsistencyCheckConfig struct {
+	// MaxRetries is the maximum number of retries of the check of an uploaded object.
+	MaxRetries int `yaml:"max_retries"`
+	// MinBackoff and MaxBackoff bound the exponential backoff between checks.
+	MinBackoff model.Duration `yaml:"min_backoff"`
+	MaxBackoff model.Duration `yaml:"max_backoff"`
+}
+
+// DefaultConsistencyCheckConfig returns the consistency check configuration with the default values set.
+func DefaultConsistencyCheckConfig() ConsistencyCheckConfig {
+	return ConsistencyCheckConfig{
+		MaxRetries: 5,
+		MinBackoff: model.Duration(100 * time.Millisecond),
+		MaxBackoff: model.Duration(5 * time.Second),
+	}
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *ConsistencyCheckConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultConsistencyCheckConfig()
+	type plain ConsistencyCheckConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.MaxRetries < 0 {
+		return errors.New("max_retries must not be negative")
+	}
+	if c.MinBackoff > c.MaxBackoff {
+		return errors.New("min_backoff must not be greater than max_backoff")
+	}
+	return nil
+}
+
+// isCriticalObject returns true if the object is the meta.json file or a marker of a block, the visibility of which
+// decides how components handle the block.
+func isCriticalObject(name string) bool {
+	return path.Base(name) == metadata.MetaFilename || isMarker(name)
+}
+
+// WrapWithConsistencyCheck returns a bucket checking that the meta.json files and markers of blocks uploaded to bkt
+// are visible, with the uploaded size if it is known, before reporting their upload as successful. Checks are
+// retried with exponential backoff, and the upload fails if the object is still not visible.
+func WrapWithConsistencyCheck(bkt objstore.InstrumentedBucket, conf ConsistencyCheckConfig, reg prometheus.Registerer) objstore.InstrumentedBucket {
+	constLabels := prometheus.Labels{"bucket": bkt.Name()}
+	c := &consistencyChecker{
+		conf: conf,
+		retries: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name:        "thanos_objstore_bucket_consistency_check_retries_total",
+			Help:        "Total number of retries of checks that uploaded objects are visible in the bucket.",
+			ConstLabels: constLabels,
+		}),
+		failures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name:        "thanos_objstore_bucket_consistency_check_failures_total",
+			Help:        "Total number of uploaded objects that were not visible in the bucket after all retries of their check.",
+			ConstLabels: constLabels,
+		}),
+	}
+	// Objects not visible yet are expected while checking them.
+	r := bkt.ReaderWithExpectedErrs(bkt.IsObjNotFoundErr)
+	return &consistencyCheckingInstrumentedBucket{consistencyCheckingBucket: consistencyCheckingBucket{Bucket: bkt, c: c, r: r}, ib: bkt}
+}
+
+type consistencyChecker struct {
+	conf              ConsistencyCheckConfig
+	retries, failures prometheus.Counter
+}
+
+// check checks that the object is visible in the bucket, with the given size if it is not negative.
+func (c *consistencyChecker) check(ctx context.Context, bkt objstore.BucketReader, name string, size int64) error {
+	b := backoff.Backoff{
+		Factor: 2,
+		Min:    time.Duration(c.conf.MinBackoff),
+		Max:    time.Duration(c.conf.MaxBackoff),
+		Jitter: true,
+	}
+	for {
+		attrs, err := bkt.Attributes(ctx, name)
+		if err == nil && size >= 0 && attrs.Size != size {
+			err = errors.Errorf("size is %d instead of %d", attrs.Size, size)
+		}
+		if err == nil {
+			return nil
+		}
+		if int(b.Attempt()) >= c.conf.MaxRetries || ctx.Err() != nil {
+			c.failures.Inc()
+			// The error is not wrapped, so that the upload is retried even if the object was not found.
+			return errors.Errorf("uploaded object %s is not visible: %v", name, err)
+		}
+		c.retries.Inc()
+		select {
+		case <-ctx.Done():
+		case <-time.After(b.Duration()):
+		}
+	}
+}
+
+type consistencyCheckingInstrumentedBucket struct {
+	consistencyCheckingBucket
+	ib objstore.InstrumentedBucket
+}
+
+func (b *consistencyCheckingInstrumentedBucket) WithExpectedErrs(f objstore.IsOpFailureExpectedFunc) objstore.Bucket {
+	return &consistencyCheckingBucket{Bucket: b.ib.WithExpectedErrs(f), c: b.c, r: b.r}
+}
+
+func (b *consistencyCheckingInstrumentedBucket) ReaderWithExpectedErrs(f objstore.IsOpFailureExpectedFunc) objstore.BucketReader {
+	return b.ib.ReaderWithExpectedErrs(f)
+}
+
+type consistencyCheckingBucket struct {
+	objstore.Bucket
+	c *consistencyChecker
+	r objstore.BucketReader
+}
+
+func (b *consistencyCheckingBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	if !isCriticalObject(name) {
+		return b.Bucket.Upload(ctx, name, r)
+	}
+	size, err := objstore.TryToGetSize(r)
+	if err != nil {
+		size = -1
+	}
+	if err := b.Bucket.Upload(ctx, name, r); err != nil {
+		return err
+	}
+	return b.c.check(ctx, b.r, name, size)
+}
@@ -0,0 +1,83 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/objstore"
+)
+
+// eventuallyConsistentBucket hides uploaded objects from the given number of reads of their attributes.
+type eventuallyConsistentBucket struct {
+	objstore.Bucket
+	hiddenReads int
+	reads       map[string]int
+}
+
+func (b *eventuallyConsistentBucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	if b.reads[name]++; b.reads[name] <= b.hiddenReads {
+		return objstore.ObjectAttributes{}, errors.New("not found")
+	}
+	return b.Bucket.Attributes(ctx, name)
+}
+
+func TestWrapWithConsistencyCheck(t *testing.T) {
+	ctx := context.Background()
+	conf := ConsistencyCheckConfig{MaxRetries: 3, MinBackoff: model.Duration(time.Millisecond), MaxBackoff: model.Duration(time.Millisecond)}
+
+	inner := &eventuallyConsistentBucket{Bucket: objstore.NewInMemBucket(), hiddenReads: 2, reads: map[string]int{}}
+	bkt := WrapWithConsistencyCheck(objstore.WithNoopInstr(inner), conf, nil).(*consistencyCheckingInstrumentedBucket)
+
+	// Objects that are not critical are not checked.
+	testutil.Ok(t, bkt.Upload(ctx, "01GXXX/chunks/000001", strings.NewReader("chunks")))
+	testutil.Equals(t, 0, inner.reads["01GXXX/chunks/000001"])
+
+	testutil.Ok(t, bkt.Upload(ctx, "01GXXX/meta.json", bytes.NewReader([]byte("{}"))))
+	testutil.Equals(t, 3, inner.reads["01GXXX/meta.json"])
+	testutil.Equals(t, 2.0, promtestutil.ToFloat64(bkt.c.retries))
+
+	// Uploads fail if objects are not visible after all retries.
+	inner.hiddenReads = 10
+	err := bkt.Upload(ctx, "01GXXX/deletion-mark.json", strings.NewReader("{}"))
+	testutil.NotOk(t, err)
+	testutil.Assert(t, !bkt.IsObjNotFoundErr(err))
+	testutil.Equals(t, 4, inner.reads["01GXXX/deletion-mark.json"])
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(bkt.c.failures))
+
+	// Objects must have the uploaded size.
+	inner.hiddenReads = 0
+	testutil.NotOk(t, bkt.Upload(ctx, "01GXXX/no-compact-mark.json", &truncatingReader{r: strings.NewReader("{}")}))
+	// Objects of unknown size are checked without their size.
+	testutil.Ok(t, bkt.Upload(ctx, "01GXXX/no-compact-mark.json", io.MultiReader(strings.NewReader("{}"))))
+}
+
+// truncatingReader reads nothing of r, while having its size.
+type truncatingReader struct {
+	r *strings.Reader
+}
+
+func (r *truncatingReader) Read([]byte) (int, error) { return 0, io.EOF }
+
+func (r *truncatingReader) ObjectSize() (int64, error) { return r.r.Size(), nil }
+
+func TestNewBucket_ConsistencyCheck(t *testing.T) {
+	dir := t.TempDir()
+	bkt, err := NewBucket(log.NewNopLogger(), []byte("type: FILESYSTEM\nconfig:\n  directory: "+dir+"\nconsistency_check:\n  max_retries: 2\n"), nil, "store")
+	testutil.Ok(t, err)
+	testutil.Ok(t, bkt.Upload(context.Background(), "01GXXX/meta.json", strings.NewReader("{}")))
+
+	_, err = NewBucket(log.NewNopLogger(), []byte("type: FILESYSTEM\nconfig:\n  directory: "+dir+"\nconsistency_check:\n  min_backoff: 1m\n  max_backoff: 1s\n"), nil, "store")
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,296 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/objstore"
+)
+
+// DeadlinesConfig is the configuration of the deadlines of bucket operations, in the deadlines section of the bucket
+// configuration. The deadline of an operation is the deadline of the context of its caller, or the configured timeout
+// of the operation if the caller has no deadline, bounded by MinTimeout and MaxTimeout.
+type DeadlinesConfig struct {
+	// Timeout is the timeout of operations whose caller has no deadline. 0 means no timeout.
+	Timeout model.Duration `yaml:"timeout"`
+	// GetRangeTimeout is the timeout of GetRange operations whose caller has no deadline, instead of Timeout.
+	GetRangeTimeout model.Duration `yaml:"get_range_timeout"`
+	// MinTimeout is the minimum time operations are given, even if the deadline of their caller is sooner.
+	MinTimeout model.Duration `yaml:"min_timeout"`
+	// MaxTimeout is the maximum time operations are given, even if their caller has a later deadline or none.
+	// 0 means no maximum.
+	MaxTimeout model.Duration `yaml:"max_timeout"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *DeadlinesConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain DeadlinesConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.Timeout < 0 || c.GetRangeTimeout < 0 || c.MinTimeout < 0 || c.MaxTimeout < 0 {
+		return errors.New("timeouts must not be negative")
+	}
+	if c.MaxTimeout > 0 && c.MinTimeout > c.MaxTimeout {
+		return errors.New("min_timeout must not be greater than max_timeout")
+	}
+	return nil
+}
+
+// WrapWithDeadlines returns a bucket applying the deadlines of conf to the operations against bkt. Get, GetRange,
+// Exists and Attributes operations return once their deadline is exceeded, even if the provider does not honor the
+// context, and readers of objects fail once their deadline is exceeded. Iter, Upload and Delete operations rely on the
+// provider honoring the context, as their callbacks and readers must not be used after they returned.
+func WrapWithDeadlines(bkt objstore.InstrumentedBucket, conf DeadlinesConfig) objstore.InstrumentedBucket {
+	d := &deadlines{conf: conf}
+	return &deadlineInstrumentedBucket{deadlineBucket: deadlineBucket{Bucket: bkt, d: d}, ib: bkt}
+}
+
+type deadlines struct {
+	conf DeadlinesConfig
+}
+
+// timeout returns the timeout of the operation with the given caller context, if any.
+func (d *deadlines) timeout(ctx context.Context, op string) (time.Duration, bool) {
+	var (
+		t  time.Duration
+		ok bool
+	)
+	if deadline, has := ctx.Deadline(); has {
+		t, ok = time.Until(deadline), true
+	} else if op == objstore.OpGetRange && d.conf.GetRangeTimeout > 0 {
+		t, ok = time.Duration(d.conf.GetRangeTimeout), true
+	} else if d.conf.Timeout > 0 {
+		t, ok = time.Duration(d.conf.Timeout), true
+	}
+	if max := time.Duration(d.conf.MaxTimeout); max > 0 && (!ok || t > max) {
+		t, ok = max, true
+	}
+	if min := time.Duration(d.conf.MinTimeout); ok && t < min {
+		t = min
+	}
+	return t, ok
+}
+
+// context returns the context of the operation with its deadline applied.
+func (d *deadlines) context(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	t, ok := d.timeout(ctx, op)
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	if deadline, has := ctx.Deadline(); has && time.Now().Add(t).After(deadline) {
+		// The minimum timeout extends the deadline of the caller.
+		detached, cancelDetached := withoutDeadline(ctx)
+		ctx, cancel := context.WithTimeout(detached, t)
+		return ctx, func() {
+			cancel()
+			cancelDetached()
+		}
+	}
+	return context.WithTimeout(ctx, t)
+}
+
+// get calls f in the background, returning once it returned or the deadline of the operation is exceeded. The reader
+// of the object fails once the deadline is exceeded, and its context is canceled once it is closed.
+func (d *deadlines) get(ctx context.Context, op string, f func(context.Context) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	ctx, cancel := d.context(ctx, op)
+	type result struct {
+		rc  io.ReadCloser
+		err error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		rc, err := f(ctx)
+		resc <- result{rc: rc, err: err}
+	}()
+
+	select {
+	case res := <-resc:
+		if res.err != nil {
+			cancel()
+			return nil, res.err
+		}
+		return &deadlineReader{ctx: ctx, ReadCloser: res.rc, cancel: cancel, op: op}, nil
+	case <-ctx.Done():
+		cancel()
+		// Readers returned by providers not honoring the context are closed once they are returned.
+		go func() {
+			if res := <-resc; res.err == nil {
+				_ = res.rc.Close()
+			}
+		}()
+		return nil, errors.Wrapf(ctx.Err(), "%s operation", op)
+	}
+}
+
+// call is like get for operations returning no reader.
+func (d *deadlines) call(ctx context.Context, op string, f func(context.Context) error) error {
+	ctx, cancel := d.context(ctx, op)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- f(ctx) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return errors.Wrapf(ctx.Err(), "%s operation", op)
+	}
+}
+
+// run calls f with the context of the operation.
+func (d *deadlines) run(ctx context.Context, op string, f func(context.Context) error) error {
+	ctx, cancel := d.context(ctx, op)
+	defer cancel()
+	return f(ctx)
+}
+
+// detachedContext has the values of its parent, without its deadline and cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// withoutDeadline returns a context with the values of parent, which is canceled once parent is canceled, but not once
+// the deadline of parent is exceeded.
+func withoutDeadline(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(detachedContext{Context: parent})
+	go func() {
+		select {
+		case <-parent.Done():
+			if errors.Is(parent.Err(), context.Canceled) {
+				cancel()
+			}
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+type deadlineReader struct {
+	io.ReadCloser
+	ctx    context.Context
+	cancel context.CancelFunc
+	op     string
+}
+
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, errors.Wrapf(err, "read object of %s operation", r.op)
+	}
+	return r.ReadCloser.Read(p)
+}
+
+func (r *deadlineReader) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
+
+type deadlineInstrumentedBucket struct {
+	deadlineBucket
+	ib objstore.InstrumentedBucket
+}
+
+func (b *deadlineInstrumentedBucket) WithExpectedErrs(f objstore.IsOpFailureExpectedFunc) objstore.Bucket {
+	return &deadlineBucket{Bucket: b.ib.WithExpectedErrs(f), d: b.d}
+}
+
+func (b *deadlineInstrumentedBucket) ReaderWithExpectedErrs(f objstore.IsOpFailureExpectedFunc) objstore.BucketReader {
+	return &deadlineBucketReader{BucketReader: b.ib.ReaderWithExpectedErrs(f), d: b.d}
+}
+
+type deadlineBucket struct {
+	objstore.Bucket
+	d *deadlines
+}
+
+func (b *deadlineBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	return b.d.run(ctx, objstore.OpUpload, func(ctx context.Context) error {
+		return b.Bucket.Upload(ctx, name, r)
+	})
+}
+
+func (b *deadlineBucket) Delete(ctx context.Context, name string) error {
+	return b.d.run(ctx, objstore.OpDelete, func(ctx context.Context) error {
+		return b.Bucket.Delete(ctx, name)
+	})
+}
+
+func (b *deadlineBucket) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	return (&deadlineBucketReader{BucketReader: b.Bucket, d: b.d}).Iter(ctx, dir, f, options...)
+}
+
+func (b *deadlineBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return (&deadlineBucketReader{BucketReader: b.Bucket, d: b.d}).Get(ctx, name)
+}
+
+func (b *deadlineBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	return (&deadlineBucketReader{BucketReader: b.Bucket, d: b.d}).GetRange(ctx, name, off, length)
+}
+
+func (b *deadlineBucket) Exists(ctx context.Context, name string) (bool, error) {
+	return (&deadlineBucketReader{BucketReader: b.Bucket, d: b.d}).Exists(ctx, name)
+}
+
+func (b *deadlineBucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	return (&deadlineBucketReader{BucketReader: b.Bucket, d: b.d}).Attributes(ctx, name)
+}
+
+type deadlineBucketReader struct {
+	objstore.BucketReader
+	d *deadlines
+}
+
+func (b *deadlineBucketReader) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	return b.d.run(ctx, objstore.OpIter, func(ctx context.Context) error {
+		return b.BucketReader.Iter(ctx, dir, f, options...)
+	})
+}
+
+func (b *deadlineBucketReader) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.d.get(ctx, objstore.OpGet, func(ctx context.Context) (io.ReadCloser, error) {
+		return b.BucketReader.Get(ctx, name)
+	})
+}
+
+func (b *deadlineBucketReader) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	return b.d.get(ctx, objstore.OpGetRange, func(ctx context.Context) (io.ReadCloser, error) {
+		return b.BucketReader.GetRange(ctx, name, off, length)
+	})
+}
+
+func (b *deadlineBucketReader) Exists(ctx context.Context, name string) (bool, error) {
+	// The result is sent over the channel, as it must not be written once the operation returned.
+	okc := make(chan bool, 1)
+	err := b.d.call(ctx, objstore.OpExists, func(ctx context.Context) error {
+		ok, err := b.BucketReader.Exists(ctx, name)
+		okc <- ok
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return <-okc, nil
+}
+
+func (b *deadlineBucketReader) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	attrsc := make(chan objstore.ObjectAttributes, 1)
+	err := b.d.call(ctx, objstore.OpAttributes, func(ctx context.Context) error {
+		attrs, err := b.BucketReader.Attributes(ctx, name)
+		attrsc <- attrs
+		return err
+	})
+	if err != nil {
+		return objstore.ObjectAttributes{}, err
+	}
+	return <-attrsc, nil
+}
@@ -0,0 +1,141 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/objstore"
+	"gopkg.in/yaml.v2"
+)
+
+// hungBucket blocks reads until they are released, ignoring their context like a hung provider connection.
+type hungBucket struct {
+	objstore.Bucket
+	release chan struct{}
+}
+
+func (b *hungBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	<-b.release
+	return b.Bucket.GetRange(ctx, name, off, length)
+}
+
+func (b *hungBucket) Exists(ctx context.Context, name string) (bool, error) {
+	<-b.release
+	return b.Bucket.Exists(ctx, name)
+}
+
+// slowBucket delays uploads by delay, honoring their context.
+type slowBucket struct {
+	objstore.Bucket
+	delay time.Duration
+}
+
+func (b *slowBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(b.delay):
+	}
+	return b.Bucket.Upload(ctx, name, r)
+}
+
+func TestDeadlinesConfig(t *testing.T) {
+	var conf DeadlinesConfig
+	testutil.Ok(t, yaml.UnmarshalStrict([]byte("timeout: 1m\nget_range_timeout: 10s\nmin_timeout: 1s\nmax_timeout: 5m\n"), &conf))
+	testutil.Equals(t, DeadlinesConfig{
+		Timeout:         model.Duration(time.Minute),
+		GetRangeTimeout: model.Duration(10 * time.Second),
+		MinTimeout:      model.Duration(time.Second),
+		MaxTimeout:      model.Duration(5 * time.Minute),
+	}, conf)
+
+	testutil.NotOk(t, yaml.UnmarshalStrict([]byte("min_timeout: 1m\nmax_timeout: 10s\n"), &conf))
+	testutil.NotOk(t, yaml.UnmarshalStrict([]byte("timeout: -1s\n"), &conf))
+}
+
+func TestDeadlines_Timeout(t *testing.T) {
+	d := &deadlines{conf: DeadlinesConfig{
+		Timeout:         model.Duration(time.Minute),
+		GetRangeTimeout: model.Duration(10 * time.Second),
+		MinTimeout:      model.Duration(time.Second),
+		MaxTimeout:      model.Duration(5 * time.Minute),
+	}}
+
+	timeout, ok := d.timeout(context.Background(), objstore.OpGet)
+	testutil.Assert(t, ok)
+	testutil.Equals(t, time.Minute, timeout)
+
+	timeout, ok = d.timeout(context.Background(), objstore.OpGetRange)
+	testutil.Assert(t, ok)
+	testutil.Equals(t, 10*time.Second, timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	timeout, ok = d.timeout(ctx, objstore.OpGetRange)
+	testutil.Assert(t, ok)
+	testutil.Equals(t, 5*time.Minute, timeout)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	timeout, ok = d.timeout(ctx, objstore.OpGetRange)
+	testutil.Assert(t, ok)
+	testutil.Equals(t, time.Second, timeout)
+
+	_, ok = (&deadlines{}).timeout(context.Background(), objstore.OpGet)
+	testutil.Assert(t, !ok)
+}
+
+func TestDeadlines_HungProvider(t *testing.T) {
+	ctx := context.Background()
+	inmem := objstore.NewInMemBucket()
+	testutil.Ok(t, inmem.Upload(ctx, "obj", strings.NewReader("content")))
+
+	hung := &hungBucket{Bucket: inmem, release: make(chan struct{})}
+	defer close(hung.release)
+	bkt := WrapWithDeadlines(objstore.WithNoopInstr(hung), DeadlinesConfig{GetRangeTimeout: model.Duration(50 * time.Millisecond), Timeout: model.Duration(time.Hour)})
+
+	start := time.Now()
+	_, err := bkt.GetRange(ctx, "obj", 0, 3)
+	testutil.NotOk(t, err)
+	testutil.Assert(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+	testutil.Assert(t, time.Since(start) < time.Hour)
+
+	// The deadline of the caller applies to other operations.
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = bkt.Exists(ctx, "obj")
+	testutil.NotOk(t, err)
+	testutil.Assert(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+}
+
+func TestDeadlines_MinTimeout(t *testing.T) {
+	inmem := objstore.NewInMemBucket()
+	bkt := WrapWithDeadlines(objstore.WithNoopInstr(&slowBucket{Bucket: inmem, delay: 100 * time.Millisecond}), DeadlinesConfig{MinTimeout: model.Duration(time.Minute)})
+
+	// The upload succeeds after the deadline of the caller, which is extended by the minimum timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	testutil.Ok(t, bkt.Upload(ctx, "obj", strings.NewReader("content")))
+	ok, err := inmem.Exists(context.Background(), "obj")
+	testutil.Ok(t, err)
+	testutil.Assert(t, ok)
+
+	// Callers still cancel operations before their deadline.
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	err = bkt.Upload(ctx, "obj2", strings.NewReader("content"))
+	testutil.NotOk(t, err)
+	testutil.Assert(t, errors.Is(err, context.Canceled), "%v", err)
+}
@@ -0,0 +1,322 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/thanos-io/thanos/pkg/errutil"
+)
+
+// WrapWithSecondary returns a bucket writing objects to both primary and secondary, and reading them from primary,
+// falling back to secondary if reads from primary fail, e.g. as the object was not migrated to it yet. It allows to
+// migrate between buckets without downtime.
+func WrapWithSecondary(primary, secondary objstore.InstrumentedBucket, reg prometheus.Registerer) objstore.InstrumentedBucket {
+	fallbacks := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name:        "thanos_objstore_bucket_secondary_fallbacks_total",
+		Help:        "Total number of read operations served by the secondary bucket, as they failed against the primary bucket.",
+		ConstLabels: prometheus.Labels{"bucket": primary.Name()},
+	}, []string{"operation"})
+	for _, op := range []string{"iter", "get", "get_range", "exists", "attributes"} {
+		fallbacks.WithLabelValues(op)
+	}
+	return &instrumentedDualWriteBucket{
+		dualWriteBucket: dualWriteBucket{primary: primary, secondary: secondary, fallbacks: fallbacks},
+		primary:         primary,
+		secondary:       secondary,
+	}
+}
+
+type instrumentedDualWriteBucket struct {
+	dualWriteBucket
+	primary, secondary objstore.InstrumentedBucket
+}
+
+func (b *instrumentedDualWriteBucket) WithExpectedErrs(f objstore.IsOpFailureExpectedFunc) objstore.Bucket {
+	return &dualWriteBucket{primary: b.primary.WithExpectedErrs(f), secondary: b.secondary.WithExpectedErrs(f), fallbacks: b.fallbacks}
+}
+
+func (b *instrumentedDualWriteBucket) ReaderWithExpectedErrs(f objstore.IsOpFailureExpectedFunc) objstore.BucketReader {
+	return &fallbackReader{primary: b.primary.ReaderWithExpectedErrs(f), secondary: b.secondary.ReaderWithExpectedErrs(f), fallbacks: b.fallbacks}
+}
+
+type dualWriteBucket struct {
+	primary, secondary objstore.Bucket
+	fallbacks          *prometheus.CounterVec
+}
+
+func (b *dualWriteBucket) reader() *fallbackReader {
+	return &fallbackReader{primary: b.primary, secondary: b.secondary, fallbacks: b.fallbacks}
+}
+
+// Upload uploads the object to both buckets concurrently, reading it once.
+func (b *dualWriteBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	size, sizeErr := objstore.TryToGetSize(r)
+	pr, pw := io.Pipe()
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		err := b.primary.Upload(gctx, name, &sizedReader{Reader: io.TeeReader(r, pw), size: size, err: sizeErr})
+		// The secondary upload reads until the primary upload is done.
+		pw.CloseWithError(err)
+		return errors.Wrap(err, "upload to primary bucket")
+	})
+	g.Go(func() error {
+		err := b.secondary.Upload(gctx, name, &sizedReader{Reader: pr, size: size, err: sizeErr})
+		// Unblock the primary upload if the secondary upload stopped reading.
+		pr.CloseWithError(err)
+		return errors.Wrap(err, "upload to secondary bucket")
+	})
+	return g.Wait()
+}
+
+// Delete deletes the object from both buckets. Objects found in one bucket only are deleted without error.
+func (b *dualWriteBucket) Delete(ctx context.Context, name string) error {
+	perr := b.primary.Delete(ctx, name)
+	serr := b.secondary.Delete(ctx, name)
+	switch {
+	case perr == nil && (serr == nil || b.secondary.IsObjNotFoundErr(serr)):
+		return nil
+	case serr == nil && b.primary.IsObjNotFoundErr(perr):
+		return nil
+	case perr != nil && !b.primary.IsObjNotFoundErr(perr):
+		return errors.Wrap(perr, "delete from primary bucket")
+	case serr != nil && !b.secondary.IsObjNotFoundErr(serr):
+		return errors.Wrap(serr, "delete from secondary bucket")
+	}
+	return perr
+}
+
+func (b *dualWriteBucket) Name() string {
+	return b.primary.Name()
+}
+
+func (b *dualWriteBucket) Close() error {
+	errs := errutil.MultiError{}
+	errs.Add(b.primary.Close())
+	errs.Add(b.secondary.Close())
+	return errs.Err()
+}
+
+func (b *dualWriteBucket) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	return b.reader().Iter(ctx, dir, f, options...)
+}
+
+func (b *dualWriteBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.reader().Get(ctx, name)
+}
+
+func (b *dualWriteBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	return b.reader().GetRange(ctx, name, off, length)
+}
+
+func (b *dualWriteBucket) Exists(ctx context.Context, name string) (bool, error) {
+	return b.reader().Exists(ctx, name)
+}
+
+func (b *dualWriteBucket) IsObjNotFoundErr(err error) bool {
+	return b.reader().IsObjNotFoundErr(err)
+}
+
+func (b *dualWriteBucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	return b.reader().Attributes(ctx, name)
+}
+
+type fallbackReader struct {
+	primary, secondary objstore.BucketReader
+	fallbacks          *prometheus.CounterVec
+}
+
+// fallback returns the error of the read against the secondary bucket if the object was not found in the primary
+// bucket, otherwise the error of the read against the primary bucket.
+func (r *fallbackReader) fallback(op string, perr, serr error) error {
+	if serr == nil {
+		r.fallbacks.WithLabelValues(op).Inc()
+		return nil
+	}
+	if r.primary.IsObjNotFoundErr(perr) {
+		return serr
+	}
+	return perr
+}
+
+// Iter lists the objects of both buckets, each of them once, so that objects missing in the primary bucket, e.g. after
+// a failed upload to it, are listed too. The objects of the secondary bucket are listed after the ones of the primary
+// bucket, whose names are kept in memory until the listing is done. If listing the primary bucket fails, the
+// objects of the secondary bucket are listed instead.
+func (r *fallbackReader) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	var (
+		listed = map[string]struct{}{}
+		ferr   error
+	)
+	perr := r.primary.Iter(ctx, dir, func(name string) error {
+		listed[name] = struct{}{}
+		ferr = f(name)
+		return ferr
+	}, options...)
+	if ferr != nil || (perr != nil && ctx.Err() != nil) {
+		return perr
+	}
+	serr := r.secondary.Iter(ctx, dir, func(name string) error {
+		if _, ok := listed[name]; ok {
+			return nil
+		}
+		ferr = f(name)
+		return ferr
+	}, options...)
+	if ferr != nil {
+		return serr
+	}
+	if perr == nil {
+		return errors.Wrap(serr, "list secondary bucket")
+	}
+	return r.fallback("iter", perr, serr)
+}
+
+func (r *fallbackReader) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	rc, perr := r.primary.Get(ctx, name)
+	if perr == nil {
+		return rc, nil
+	}
+	rc, serr := r.secondary.Get(ctx, name)
+	return rc, r.fallback("get", perr, serr)
+}
+
+func (r *fallbackReader) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	rc, perr := r.primary.GetRange(ctx, name, off, length)
+	if perr == nil {
+		return rc, nil
+	}
+	rc, serr := r.secondary.GetRange(ctx, name, off, length)
+	return rc, r.fallback("get_range", perr, serr)
+}
+
+func (r *fallbackReader) Exists(ctx context.Context, name string) (bool, error) {
+	ok, perr := r.primary.Exists(ctx, name)
+	if perr == nil && ok {
+		return true, nil
+	}
+	ok, serr := r.secondary.Exists(ctx, name)
+	if perr == nil {
+		if serr == nil && ok {
+			r.fallbacks.WithLabelValues("exists").Inc()
+		}
+		return ok, serr
+	}
+	return ok, r.fallback("exists", perr, serr)
+}
+
+func (r *fallbackReader) IsObjNotFoundErr(err error) bool {
+	return r.primary.IsObjNotFoundErr(err) || r.secondary.IsObjNotFoundErr(err)
+}
+
+func (r *fallbackReader) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	attrs, perr := r.primary.Attributes(ctx, name)
+	if perr == nil {
+		return attrs, nil
+	}
+	attrs, serr := r.secondary.Attributes(ctx, name)
+	return attrs, r.fallback("attributes", perr, serr)
+}
+
+type sizedReader struct {
+	io.Reader
+	size int64
+	err  error
+}
+
+func (r *sizedReader) ObjectSize() (int64, error) {
+	return r.size, r.err
+}
+
+// ReconcileReport lists the differences between the objects of the primary and secondary buckets.
+type ReconcileReport struct {
+	// Objects is the number of objects in either bucket.
+	Objects            int      `json:"objects"`
+	MissingInPrimary   []string `json:"missing_in_primary"`
+	MissingInSecondary []string `json:"missing_in_secondary"`
+	// SizeMismatches are the objects with different sizes in both buckets.
+	SizeMismatches []string `json:"size_mismatches"`
+}
+
+// Reconcile compares the objects under dir in the primary and secondary buckets, recursively. Up to concurrency
+// objects are compared at once, or all of them if concurrency is not positive.
+func Reconcile(ctx context.Context, primary, secondary objstore.BucketReader, dir string, concurrency int) (*ReconcileReport, error) {
+	list := func(bkt objstore.BucketReader) (map[string]struct{}, error) {
+		names := map[string]struct{}{}
+		err := bkt.Iter(ctx, dir, func(name string) error {
+			names[name] = struct{}{}
+			return nil
+		}, objstore.WithRecursiveIter)
+		return names, err
+	}
+	primaryNames, err := list(primary)
+	if err != nil {
+		return nil, errors.Wrap(err, "list primary bucket")
+	}
+	secondaryNames, err := list(secondary)
+	if err != nil {
+		return nil, errors.Wrap(err, "list secondary bucket")
+	}
+
+	report := &ReconcileReport{}
+	var common []string
+	for name := range primaryNames {
+		if _, ok := secondaryNames[name]; ok {
+			common = append(common, name)
+		} else {
+			report.MissingInSecondary = append(report.MissingInSecondary, name)
+		}
+	}
+	for name := range secondaryNames {
+		if _, ok := primaryNames[name]; !ok {
+			report.MissingInPrimary = append(report.MissingInPrimary, name)
+		}
+	}
+	report.Objects = len(common) + len(report.MissingInPrimary) + len(report.MissingInSecondary)
+
+	var (
+		mtx sync.Mutex
+		g   errgroup.Group
+	)
+	if concurrency > 0 {
+		g.SetLimit(concurrency)
+	}
+	for _, name := range common {
+		name := name
+		g.Go(func() error {
+			pattrs, err := primary.Attributes(ctx, name)
+			if err != nil {
+				return errors.Wrapf(err, "get attributes of %s from primary bucket", name)
+			}
+			sattrs, err := secondary.Attributes(ctx, name)
+			if err != nil {
+				return errors.Wrapf(err, "get attributes of %s from secondary bucket", name)
+			}
+			if pattrs.Size != sattrs.Size {
+				mtx.Lock()
+				report.SizeMismatches = append(report.SizeMismatches, name)
+				mtx.Unlock()
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(report.MissingInPrimary)
+	sort.Strings(report.MissingInSecondary)
+	sort.Strings(report.SizeMismatches)
+	return report, nil
+}
@@ -0,0 +1,133 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
+)
+
+func TestDualWriteBucket_Acceptance(t *testing.T) {
+	objstore.AcceptanceTest(t, WrapWithSecondary(objstore.WithNoopInstr(objstore.NewInMemBucket()), objstore.WithNoopInstr(objstore.NewInMemBucket()), nil))
+}
+
+type failingUploadBucket struct {
+	objstore.Bucket
+}
+
+func (b failingUploadBucket) Upload(_ context.Context, _ string, r io.Reader) error {
+	// Fail after reading part of the object.
+	_, _ = r.Read(make([]byte, 1))
+	return errors.New("upload failed")
+}
+
+type failingIterBucket struct {
+	objstore.Bucket
+}
+
+func (b failingIterBucket) Iter(context.Context, string, func(string) error, ...objstore.IterOption) error {
+	return errors.New("iter failed")
+}
+
+func TestDualWriteBucket(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := objstore.NewInMemBucket(), objstore.NewInMemBucket()
+	bkt := WrapWithSecondary(objstore.WithNoopInstr(primary), objstore.WithNoopInstr(secondary), nil)
+
+	read := func(bkt objstore.BucketReader, name string) string {
+		r, err := bkt.Get(ctx, name)
+		testutil.Ok(t, err)
+		b, err := io.ReadAll(r)
+		testutil.Ok(t, err)
+		return string(b)
+	}
+
+	// Objects are written to both buckets.
+	content := strings.Repeat("a", 1<<20)
+	testutil.Ok(t, bkt.Upload(ctx, "dir/a", strings.NewReader(content)))
+	testutil.Equals(t, content, read(primary, "dir/a"))
+	testutil.Equals(t, content, read(secondary, "dir/a"))
+
+	// Objects missing in the primary bucket are read from the secondary bucket.
+	testutil.Ok(t, secondary.Upload(ctx, "dir/b", strings.NewReader("b")))
+	testutil.Equals(t, "b", read(bkt, "dir/b"))
+	ok, err := bkt.Exists(ctx, "dir/b")
+	testutil.Ok(t, err)
+	testutil.Assert(t, ok)
+	attrs, err := bkt.Attributes(ctx, "dir/b")
+	testutil.Ok(t, err)
+	testutil.Equals(t, int64(1), attrs.Size)
+	_, err = bkt.Get(ctx, "dir/c")
+	testutil.Assert(t, bkt.IsObjNotFoundErr(err))
+
+	// Objects of both buckets are listed once.
+	var names []string
+	testutil.Ok(t, bkt.Iter(ctx, "dir/", func(name string) error {
+		names = append(names, name)
+		return nil
+	}))
+	testutil.Equals(t, []string{"dir/a", "dir/b"}, names)
+
+	// Objects of the secondary bucket are listed if listing the primary bucket fails.
+	names = nil
+	testutil.Ok(t, WrapWithSecondary(objstore.WithNoopInstr(failingIterBucket{primary}), objstore.WithNoopInstr(secondary), nil).Iter(ctx, "dir/", func(name string) error {
+		names = append(names, name)
+		return nil
+	}))
+	testutil.Equals(t, []string{"dir/a", "dir/b"}, names)
+
+	// Listings fail if listing the secondary bucket fails.
+	testutil.NotOk(t, WrapWithSecondary(objstore.WithNoopInstr(primary), objstore.WithNoopInstr(failingIterBucket{secondary}), nil).Iter(ctx, "dir/", func(string) error { return nil }))
+
+	// Objects are deleted from both buckets, and found in one of them is enough.
+	testutil.Ok(t, primary.Upload(ctx, "dir/c", strings.NewReader("c")))
+	testutil.Ok(t, bkt.Delete(ctx, "dir/b"))
+	testutil.Ok(t, bkt.Delete(ctx, "dir/c"))
+	testutil.Ok(t, bkt.Delete(ctx, "dir/a"))
+	testutil.Assert(t, bkt.IsObjNotFoundErr(bkt.Delete(ctx, "dir/a")))
+	testutil.Equals(t, 0, len(primary.Objects())+len(secondary.Objects()))
+
+	// Uploads fail if the upload to either bucket fails.
+	for _, bkt := range []objstore.Bucket{
+		WrapWithSecondary(objstore.WithNoopInstr(failingUploadBucket{primary}), objstore.WithNoopInstr(secondary), nil),
+		WrapWithSecondary(objstore.WithNoopInstr(primary), objstore.WithNoopInstr(failingUploadBucket{secondary}), nil),
+	} {
+		testutil.NotOk(t, bkt.Upload(ctx, "a", strings.NewReader(content)))
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := objstore.NewInMemBucket(), objstore.NewInMemBucket()
+	for name, content := range map[string]string{"a/1": "1", "a/2": "2", "a/3": "3", "b": "b"} {
+		testutil.Ok(t, primary.Upload(ctx, name, strings.NewReader(content)))
+	}
+	for name, content := range map[string]string{"a/1": "1", "a/3": "33", "a/4": "4", "b": "b"} {
+		testutil.Ok(t, secondary.Upload(ctx, name, strings.NewReader(content)))
+	}
+
+	report, err := Reconcile(ctx, primary, secondary, "", 2)
+	testutil.Ok(t, err)
+	testutil.Equals(t, &ReconcileReport{
+		Objects:            5,
+		MissingInPrimary:   []string{"a/4"},
+		MissingInSecondary: []string{"a/2"},
+		SizeMismatches:     []string{"a/3"},
+	}, report)
+
+	report, err = Reconcile(ctx, primary, secondary, "a", 2)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 4, report.Objects)
+
+	// Without a positive concurrency, all objects are compared at once.
+	report, err = Reconcile(ctx, primary, secondary, "", 0)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"a/3"}, report.SizeMismatches)
+}
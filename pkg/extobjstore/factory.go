@@ -0,0 +1,265 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package extobjstore extends the bucket clients of github.com/thanos-io/objstore with Thanos specific wrappers,
+// configured in additional sections of the bucket configuration.
+package extobjstore
+
+import (
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/client"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/encryption"
+	"github.com/thanos-io/thanos/pkg/extobjstore/hdfs"
+	"github.com/thanos-io/thanos/pkg/extobjstore/obs"
+)
+
+// Types of the buckets, which are not supported by client.NewBucket.
+const (
+	OBS  client.ObjProvider = "OBS"
+	HDFS client.ObjProvider = "HDFS"
+)
+
+// BucketConfig is the bucket configuration of client.BucketConfig, with the additional sections of Thanos.
+type BucketConfig struct {
+	client.BucketConfig `yaml:",inline"`
+
+	ProviderConfig `yaml:",inline"`
+
+	// Encryption encrypts objects on upload and decrypts them on read.
+	Encryption *encryption.Config `yaml:"encryption"`
+	// RateLimits throttle the operations against the bucket.
+	RateLimits *RateLimitsConfig `yaml:"rate_limits"`
+	// Retry retries failed operations against the bucket.
+	Retry *RetryConfig `yaml:"retry"`
+	// Faults are the faults injected into the operations against the bucket, for testing.
+	Faults *FaultsConfig `yaml:"faults"`
+	// Deadlines are the deadlines of the operations against the bucket, derived from the contexts of their callers.
+	Deadlines *DeadlinesConfig `yaml:"deadlines"`
+	// ConsistencyCheck checks that critical objects are visible after their upload.
+	ConsistencyCheck *ConsistencyCheckConfig `yaml:"consistency_check"`
+	// Audit is the audit log of the mutating operations against the bucket.
+	Audit *AuditConfig `yaml:"audit"`
+	// Secondary is the bucket objects are written to along with the bucket, e.g. while migrating to it, and read
+	// from if reads from the bucket fail.
+	Secondary *client.BucketConfig `yaml:"secondary"`
+}
+
+// ProviderConfig are the sections of the bucket configuration specific to a provider. They do not apply to the
+// secondary bucket.
+type ProviderConfig struct {
+	// STS is the AWS STS role S3 buckets are accessed with.
+	STS *STSConfig `yaml:"sts"`
+	// GCS configures the objects uploaded to GCS buckets.
+	GCS *GCSConfig `yaml:"gcs"`
+	// AzureWorkloadIdentity is the Azure AD workload identity Azure containers are accessed with.
+	AzureWorkloadIdentity *AzureWorkloadIdentityConfig `yaml:"azure_workload_identity"`
+	// Uploads configures the multipart uploads of the bucket.
+	Uploads *UploadsConfig `yaml:"uploads"`
+	// Costs are the prices of the requests and transfers of the bucket.
+	Costs *CostsConfig `yaml:"costs"`
+	// ObjectLock is the retention of the objects uploaded to S3 buckets with Object Lock enabled.
+	ObjectLock *ObjectLockConfig `yaml:"object_lock"`
+}
+
+// NewBucket returns the bucket of the given bucket configuration like client.NewBucket, wrapped according to the
+// additional sections of the configuration, see BucketConfig.
+// NOTE: confContentYaml can contain secrets.
+func NewBucket(logger log.Logger, confContentYaml []byte, reg prometheus.Registerer, component string) (objstore.InstrumentedBucket, error) {
+	conf := &BucketConfig{}
+	if err := yaml.UnmarshalStrict(confContentYaml, conf); err != nil {
+		return nil, errors.Wrap(err, "parsing config YAML file")
+	}
+
+	var bkt objstore.InstrumentedBucket
+	if conf.Secondary != nil {
+		primary, secondary, err := newPrimaryAndSecondary(logger, conf, reg, component)
+		if err != nil {
+			return nil, err
+		}
+		level.Info(logger).Log("msg", "writing objects to the secondary bucket too", "secondary", conf.Secondary.Type)
+		bkt = WrapWithSecondary(primary, secondary, reg)
+	} else {
+		var err error
+		bkt, err = newClientBucket(logger, conf.BucketConfig, conf.ProviderConfig, reg, component)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Faults are injected into the requests against the bucket, so that all other sections handle them.
+	if conf.Faults != nil {
+		level.Warn(logger).Log("msg", "injecting faults into bucket operations; do not use in production", "operations", strings.Join(conf.Faults.Operations, ","))
+		bkt = WrapWithFaults(bkt, *conf.Faults, reg)
+	}
+	// Deadlines apply to the requests against the bucket, so waiting for the rate limits and between retries is
+	// bounded by the deadline of the caller only.
+	if conf.Deadlines != nil {
+		level.Info(logger).Log("msg", "deadlines of bucket operations enabled", "timeout", conf.Deadlines.Timeout, "min_timeout", conf.Deadlines.MinTimeout, "max_timeout", conf.Deadlines.MaxTimeout)
+		bkt = WrapWithDeadlines(bkt, *conf.Deadlines)
+	}
+	// Rate limits apply to the requests against the bucket, so encrypted objects are throttled by their encrypted size.
+	if conf.RateLimits != nil {
+		level.Info(logger).Log("msg", "rate limits of bucket operations enabled")
+		bkt = WrapWithRateLimits(bkt, *conf.RateLimits, reg)
+	}
+	if conf.Encryption != nil {
+		keyConf, err := yaml.Marshal(conf.Encryption.Config)
+		if err != nil {
+			return nil, errors.Wrap(err, "marshal content of encryption configuration")
+		}
+		key, err := encryption.NewMasterKey(conf.Encryption.Type, keyConf)
+		if err != nil {
+			return nil, errors.Wrapf(err, "create %s master key", conf.Encryption.Type)
+		}
+		level.Info(logger).Log("msg", "client-side encryption of objects enabled", "master_key", conf.Encryption.Type)
+		bkt = encryption.WrapBucket(bkt, key, conf.Encryption.AllowUnencryptedReads, reg)
+	}
+	// Objects are checked with their size before encryption, and uploads are retried if the check fails.
+	if conf.ConsistencyCheck != nil {
+		level.Info(logger).Log("msg", "consistency checks of uploaded objects enabled", "max_retries", conf.ConsistencyCheck.MaxRetries)
+		bkt = WrapWithConsistencyCheck(bkt, *conf.ConsistencyCheck, reg)
+	}
+	// Retries wrap the other sections, so that uploads are retried with the reader of the caller, which can be
+	// rewound, and every attempt waits for the rate limits.
+	if conf.Retry != nil {
+		level.Info(logger).Log("msg", "retries of bucket operations enabled", "max_retries", conf.Retry.MaxRetries)
+		bkt = WrapWithRetries(bkt, *conf.Retry, reg)
+	}
+	// The audit log records the result of operations after all their retries.
+	if conf.Audit != nil {
+		level.Info(logger).Log("msg", "audit log of bucket operations enabled", "file", conf.Audit.File, "prefix", conf.Audit.Prefix)
+		return WrapWithAudit(logger, bkt, *conf.Audit, component, reg)
+	}
+	return bkt, nil
+}
+
+// NewPrimaryAndSecondary returns the bucket and the secondary bucket of the given bucket configuration, which must
+// have a secondary section. Other sections are ignored, except the provider specific sections of the bucket.
+func NewPrimaryAndSecondary(logger log.Logger, confContentYaml []byte, reg prometheus.Registerer, component string) (primary, secondary objstore.InstrumentedBucket, _ error) {
+	conf := &BucketConfig{}
+	if err := yaml.UnmarshalStrict(confContentYaml, conf); err != nil {
+		return nil, nil, errors.Wrap(err, "parsing config YAML file")
+	}
+	if conf.Secondary == nil {
+		return nil, nil, errors.New("no secondary bucket configured")
+	}
+	return newPrimaryAndSecondary(logger, conf, reg, component)
+}
+
+func newPrimaryAndSecondary(logger log.Logger, conf *BucketConfig, reg prometheus.Registerer, component string) (primary, secondary objstore.InstrumentedBucket, _ error) {
+	// Both buckets can have the same name, so their metrics are distinguished by role.
+	for _, b := range []struct {
+		role      string
+		conf      client.BucketConfig
+		providers ProviderConfig
+		bkt       *objstore.InstrumentedBucket
+	}{
+		{role: "primary", conf: conf.BucketConfig, providers: conf.ProviderConfig, bkt: &primary},
+		{role: "secondary", conf: *conf.Secondary, bkt: &secondary},
+	} {
+		var err error
+		*b.bkt, err = newClientBucket(logger, b.conf, b.providers, prometheus.WrapRegistererWith(prometheus.Labels{"role": b.role}, reg), component)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "create %s bucket", b.role)
+		}
+	}
+	return primary, secondary, nil
+}
+
+// newClientBucket returns the bucket of conf like client.NewBucket, configured with the provider specific sections,
+// and exposing the bytes transferred by operations and the progress of uploads. GCS buckets upload objects with the
+// storage class of WithStorageClass, if any.
+func newClientBucket(logger log.Logger, conf client.BucketConfig, providers ProviderConfig, reg prometheus.Registerer, component string) (objstore.InstrumentedBucket, error) {
+	bkt, err := newProviderBucket(logger, conf, providers, reg, component)
+	if err != nil {
+		return nil, err
+	}
+	return WrapWithUploadMetrics(WrapWithTransferMetrics(bkt, component, providers.Costs, reg), reg), nil
+}
+
+func newProviderBucket(logger log.Logger, conf client.BucketConfig, providers ProviderConfig, reg prometheus.Registerer, component string) (objstore.InstrumentedBucket, error) {
+	var uploads UploadsConfig
+	if providers.Uploads != nil {
+		uploads = *providers.Uploads
+	}
+	// GCS and Azure retain objects with the retention policies of buckets and containers instead.
+	if providers.ObjectLock != nil && !strings.EqualFold(string(conf.Type), string(client.S3)) {
+		return nil, errors.Errorf("object_lock section is not supported by %s buckets", conf.Type)
+	}
+	switch {
+	case providers.STS != nil:
+		if providers.Uploads != nil {
+			return nil, errors.New("uploads section is not supported along with the sts section")
+		}
+		if providers.ObjectLock != nil {
+			return nil, errors.New("object_lock section is not supported along with the sts section")
+		}
+		return newSTSBucket(logger, conf, *providers.STS, reg, component)
+	case providers.AzureWorkloadIdentity != nil:
+		return newAzureWorkloadIdentityBucket(logger, conf, *providers.AzureWorkloadIdentity, uploads, reg, component)
+	case providers.GCS != nil:
+		return newGCSBucket(logger, conf, *providers.GCS, uploads, reg, component)
+	case strings.EqualFold(string(conf.Type), string(client.GCS)):
+		return newGCSBucket(logger, conf, GCSConfig{}, uploads, reg, component)
+	case strings.EqualFold(string(conf.Type), string(OBS)):
+		return newOBSBucket(logger, conf, uploads, reg, component)
+	case strings.EqualFold(string(conf.Type), string(HDFS)):
+		if providers.Uploads != nil {
+			return nil, errors.New("uploads section is not supported by HDFS buckets")
+		}
+		return newHDFSBucket(logger, conf, reg)
+	case strings.EqualFold(string(conf.Type), string(client.S3)):
+		return newS3UploadsBucket(logger, conf, uploads, providers.ObjectLock, reg, component)
+	case providers.Uploads != nil:
+		return nil, errors.Errorf("uploads section is not supported by %s buckets", conf.Type)
+	}
+	bucketConf, err := yaml.Marshal(conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal bucket configuration")
+	}
+	return client.NewBucket(logger, bucketConf, reg, component)
+}
+
+// newOBSBucket returns the HuaweiCloud OBS bucket of conf, instrumented like the buckets of client.NewBucket.
+func newOBSBucket(logger log.Logger, conf client.BucketConfig, uploads UploadsConfig, reg prometheus.Registerer, component string) (objstore.InstrumentedBucket, error) {
+	content, err := yaml.Marshal(conf.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal content of bucket configuration")
+	}
+	obsConf := obs.DefaultConfig
+	if err := yaml.UnmarshalStrict(content, &obsConf); err != nil {
+		return nil, errors.Wrap(err, "parsing OBS configuration")
+	}
+	if uploads.PartSize > 0 {
+		obsConf.PartSize = uint64(uploads.PartSize)
+	}
+	if uploads.Concurrency > 0 {
+		obsConf.UploadConcurrency = uploads.Concurrency
+	}
+	bkt, err := obs.NewBucketWithConfig(logger, obsConf, component)
+	if err != nil {
+		return nil, errors.Wrap(err, "create OBS client")
+	}
+	return objstore.NewTracingBucket(objstore.BucketWithMetrics(bkt.Name(), objstore.NewPrefixedBucket(bkt, conf.Prefix), reg)), nil
+}
+
+// newHDFSBucket returns the HDFS bucket of conf, instrumented like the buckets of client.NewBucket.
+func newHDFSBucket(logger log.Logger, conf client.BucketConfig, reg prometheus.Registerer) (objstore.InstrumentedBucket, error) {
+	content, err := yaml.Marshal(conf.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal content of bucket configuration")
+	}
+	bkt, err := hdfs.NewBucket(logger, content)
+	if err != nil {
+		return nil, errors.Wrap(err, "create HDFS client")
+	}
+	return objstore.NewTracingBucket(objstore.BucketWithMetrics(bkt.Name(), objstore.NewPrefixedBucket(bkt, conf.Prefix), reg)), nil
+}
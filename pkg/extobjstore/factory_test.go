@@ -0,0 +1,68 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+)
+
+func TestNewBucket(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keyFile := filepath.Join(t.TempDir(), "key")
+	testutil.Ok(t, os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(make([]byte, 32))), 0600))
+
+	bkt, err := NewBucket(log.NewNopLogger(), []byte(`type: FILESYSTEM
+config:
+  directory: `+dir+`
+encryption:
+  type: FILE
+  config:
+    key_file: `+keyFile+`
+rate_limits:
+  read:
+    ops_per_second: 100
+    bytes_per_second: 1MiB
+`), nil, "test")
+	testutil.Ok(t, err)
+	testutil.Ok(t, bkt.Upload(ctx, "obj", strings.NewReader("content")))
+	r, err := bkt.Get(ctx, "obj")
+	testutil.Ok(t, err)
+	got, err := io.ReadAll(r)
+	testutil.Ok(t, err)
+	testutil.Equals(t, "content", string(got))
+
+	// Objects are encrypted in the bucket.
+	plainBkt, err := NewBucket(log.NewNopLogger(), []byte("type: FILESYSTEM\nconfig:\n  directory: "+dir), nil, "test")
+	testutil.Ok(t, err)
+	r, err = plainBkt.Get(ctx, "obj")
+	testutil.Ok(t, err)
+	got, err = io.ReadAll(r)
+	testutil.Ok(t, err)
+	testutil.Assert(t, !strings.Contains(string(got), "content"))
+
+	_, err = NewBucket(log.NewNopLogger(), []byte("type: FILESYSTEM\nconfig:\n  directory: "+dir+"\nencryption:\n  type: UNKNOWN"), nil, "test")
+	testutil.NotOk(t, err)
+	_, err = NewBucket(log.NewNopLogger(), []byte("type: FILESYSTEM\nconfig:\n  directory: "+dir+"\nrate_limits:\n  unknown: 1"), nil, "test")
+	testutil.NotOk(t, err)
+
+	// OBS buckets are created by this package, as they are not supported by the client package.
+	obsBkt, err := NewBucket(log.NewNopLogger(), []byte("type: obs\nconfig:\n  bucket: test\n  endpoint: obs.example.com\nprefix: prefix"), nil, "test")
+	testutil.Ok(t, err)
+	testutil.Equals(t, "tracing: test", obsBkt.Name())
+	_, err = NewBucket(log.NewNopLogger(), []byte("type: OBS\nconfig:\n  endpoint: obs.example.com"), nil, "test")
+	testutil.NotOk(t, err)
+	hdfsBkt, err := NewBucket(log.NewNopLogger(), []byte("type: HDFS\nconfig:\n  address: http://namenode:9870\n  directory: /thanos"), nil, "test")
+	testutil.Ok(t, err)
+	testutil.Equals(t, "tracing: hdfs: /thanos", hdfsBkt.Name())
+}
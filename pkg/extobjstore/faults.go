@@ -0,0 +1,353 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/objstore"
+)
+
+// Types of the injected faults.
+const (
+	faultLatency     = "latency"
+	faultThrottling  = "throttling"
+	faultPartialRead = "partial_read"
+	faultInvisible   = "invisible"
+)
+
+// FaultsConfig is the configuration of the faults injected into bucket operations, in the faults section of the
+// bucket configuration. It is meant for testing how components handle failures of the object storage, e.g. in e2e
+// tests and staging environments, and must not be used in production.
+type FaultsConfig struct {
+	// Operations are the operations faults are injected into, e.g. get_range. Empty means all operations.
+	Operations []string `yaml:"operations"`
+	// Latency is added to operations, with a random jitter of up to LatencyJitter.
+	Latency       model.Duration `yaml:"latency"`
+	LatencyJitter model.Duration `yaml:"latency_jitter"`
+	// ThrottlingRate is the ratio of operations failing with a throttling error, from 0 to 1.
+	ThrottlingRate float64 `yaml:"throttling_rate"`
+	// PartialReadRate is the ratio of objects returned by Get and GetRange operations that fail after a random part of
+	// them was read, from 0 to 1.
+	PartialReadRate float64 `yaml:"partial_read_rate"`
+	// ConsistencyDelay is the time uploaded objects are not visible for, as in eventually consistent object storages.
+	ConsistencyDelay model.Duration `yaml:"consistency_delay"`
+	// Seed is the seed of the random faults, to reproduce them. 0 means a random seed.
+	Seed int64 `yaml:"seed"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *FaultsConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain FaultsConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	for _, op := range c.Operations {
+		if _, ok := operationsByName[op]; !ok {
+			return errors.Errorf("unsupported operation %q", op)
+		}
+	}
+	if c.Latency < 0 || c.LatencyJitter < 0 || c.ConsistencyDelay < 0 {
+		return errors.New("durations must not be negative")
+	}
+	if c.ThrottlingRate < 0 || c.ThrottlingRate > 1 || c.PartialReadRate < 0 || c.PartialReadRate > 1 {
+		return errors.New("rates must be between 0 and 1")
+	}
+	return nil
+}
+
+var operationsByName = map[string]struct{}{
+	objstore.OpIter:       {},
+	objstore.OpGet:        {},
+	objstore.OpGetRange:   {},
+	objstore.OpExists:     {},
+	objstore.OpUpload:     {},
+	objstore.OpDelete:     {},
+	objstore.OpAttributes: {},
+}
+
+// ErrThrottled is the error of operations failed with an injected throttling error, which is retried like the
+// throttling errors of providers.
+var ErrThrottled = errors.New("injected throttling error")
+
+// errInvisible is the error of reads of objects, which are not visible yet after their upload.
+var errInvisible = errors.New("injected not found error of object not visible yet")
+
+// errPartialRead is the error of injected partial reads.
+var errPartialRead = errors.Wrap(io.ErrUnexpectedEOF, "injected partial read")
+
+// WrapWithFaults returns a bucket injecting the faults of conf into the operations against bkt.
+func WrapWithFaults(bkt objstore.InstrumentedBucket, conf FaultsConfig, reg prometheus.Registerer) objstore.InstrumentedBucket {
+	seed := conf.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	f := &faults{
+		conf:     conf,
+		ops:      map[string]struct{}{},
+		rand:     rand.New(rand.NewSource(seed)),
+		uploaded: map[string]time.Time{},
+		injected: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name:        "thanos_objstore_bucket_injected_faults_total",
+			Help:        "Total number of faults injected into operations against the bucket, by operation and fault.",
+			ConstLabels: prometheus.Labels{"bucket": bkt.Name()},
+		}, []string{"operation", "fault"}),
+	}
+	for _, op := range conf.Operations {
+		f.ops[op] = struct{}{}
+	}
+	return &faultyInstrumentedBucket{faultyBucket: faultyBucket{Bucket: bkt, f: f}, ib: bkt}
+}
+
+type faults struct {
+	conf     FaultsConfig
+	ops      map[string]struct{}
+	injected *prometheus.CounterVec
+
+	mtx      sync.Mutex
+	rand     *rand.Rand
+	uploaded map[string]time.Time
+}
+
+func (f *faults) applies(op string) bool {
+	if len(f.ops) == 0 {
+		return true
+	}
+	_, ok := f.ops[op]
+	return ok
+}
+
+// chance returns true with the given probability.
+func (f *faults) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.rand.Float64() < p
+}
+
+// before injects the latency and throttling errors of the operation, before it is sent to the bucket.
+func (f *faults) before(ctx context.Context, op string) error {
+	if !f.applies(op) {
+		return nil
+	}
+	if latency := time.Duration(f.conf.Latency); latency > 0 || f.conf.LatencyJitter > 0 {
+		if jitter := int64(f.conf.LatencyJitter); jitter > 0 {
+			f.mtx.Lock()
+			latency += time.Duration(f.rand.Int63n(jitter))
+			f.mtx.Unlock()
+		}
+		f.injected.WithLabelValues(op, faultLatency).Inc()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(latency):
+		}
+	}
+	if f.chance(f.conf.ThrottlingRate) {
+		f.injected.WithLabelValues(op, faultThrottling).Inc()
+		return ErrThrottled
+	}
+	return nil
+}
+
+// uploadedObject records the upload of the object, which is not visible until the consistency delay passed.
+func (f *faults) uploadedObject(name string) {
+	if f.conf.ConsistencyDelay <= 0 || !f.applies(objstore.OpUpload) {
+		return
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.uploaded[name] = time.Now()
+}
+
+// visible returns true if the object was not uploaded within the consistency delay.
+func (f *faults) visible(op, name string) bool {
+	if f.conf.ConsistencyDelay <= 0 {
+		return true
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	t, ok := f.uploaded[name]
+	if !ok {
+		return true
+	}
+	if time.Since(t) >= time.Duration(f.conf.ConsistencyDelay) {
+		delete(f.uploaded, name)
+		return true
+	}
+	f.injected.WithLabelValues(op, faultInvisible).Inc()
+	return false
+}
+
+// reader injects partial reads into the reader of the object.
+func (f *faults) reader(op string, rc io.ReadCloser) io.ReadCloser {
+	if !f.applies(op) || !f.chance(f.conf.PartialReadRate) {
+		return rc
+	}
+	f.injected.WithLabelValues(op, faultPartialRead).Inc()
+	f.mtx.Lock()
+	// Reads fail after up to 4KiB of the object.
+	limit := f.rand.Int63n(4096)
+	f.mtx.Unlock()
+	return &partialReader{ReadCloser: rc, left: limit}
+}
+
+type partialReader struct {
+	io.ReadCloser
+	left int64
+}
+
+func (r *partialReader) Read(p []byte) (int, error) {
+	if r.left <= 0 {
+		return 0, errPartialRead
+	}
+	if int64(len(p)) > r.left {
+		p = p[:r.left]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.left -= int64(n)
+	return n, err
+}
+
+type faultyInstrumentedBucket struct {
+	faultyBucket
+	ib objstore.InstrumentedBucket
+}
+
+func (b *faultyInstrumentedBucket) WithExpectedErrs(f objstore.IsOpFailureExpectedFunc) objstore.Bucket {
+	return &faultyBucket{Bucket: b.ib.WithExpectedErrs(f), f: b.f}
+}
+
+func (b *faultyInstrumentedBucket) ReaderWithExpectedErrs(f objstore.IsOpFailureExpectedFunc) objstore.BucketReader {
+	return &faultyBucketReader{BucketReader: b.ib.ReaderWithExpectedErrs(f), f: b.f}
+}
+
+type faultyBucket struct {
+	objstore.Bucket
+	f *faults
+}
+
+func (b *faultyBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	if err := b.f.before(ctx, objstore.OpUpload); err != nil {
+		return err
+	}
+	if err := b.Bucket.Upload(ctx, name, r); err != nil {
+		return err
+	}
+	b.f.uploadedObject(name)
+	return nil
+}
+
+func (b *faultyBucket) Delete(ctx context.Context, name string) error {
+	if err := b.f.before(ctx, objstore.OpDelete); err != nil {
+		return err
+	}
+	return b.Bucket.Delete(ctx, name)
+}
+
+func (b *faultyBucket) IsObjNotFoundErr(err error) bool {
+	return errors.Is(err, errInvisible) || b.Bucket.IsObjNotFoundErr(err)
+}
+
+func (b *faultyBucket) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	return (&faultyBucketReader{BucketReader: b.Bucket, f: b.f}).Iter(ctx, dir, f, options...)
+}
+
+func (b *faultyBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return (&faultyBucketReader{BucketReader: b.Bucket, f: b.f}).Get(ctx, name)
+}
+
+func (b *faultyBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	return (&faultyBucketReader{BucketReader: b.Bucket, f: b.f}).GetRange(ctx, name, off, length)
+}
+
+func (b *faultyBucket) Exists(ctx context.Context, name string) (bool, error) {
+	return (&faultyBucketReader{BucketReader: b.Bucket, f: b.f}).Exists(ctx, name)
+}
+
+func (b *faultyBucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	return (&faultyBucketReader{BucketReader: b.Bucket, f: b.f}).Attributes(ctx, name)
+}
+
+type faultyBucketReader struct {
+	objstore.BucketReader
+	f *faults
+}
+
+func (b *faultyBucketReader) IsObjNotFoundErr(err error) bool {
+	return errors.Is(err, errInvisible) || b.BucketReader.IsObjNotFoundErr(err)
+}
+
+// Iter lists the objects, except the objects that are not visible yet. Directories are listed even if all their
+// objects are not visible yet.
+func (b *faultyBucketReader) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	if err := b.f.before(ctx, objstore.OpIter); err != nil {
+		return err
+	}
+	return b.BucketReader.Iter(ctx, dir, func(name string) error {
+		if !strings.HasSuffix(name, objstore.DirDelim) && !b.f.visible(objstore.OpIter, name) {
+			return nil
+		}
+		return f(name)
+	}, options...)
+}
+
+func (b *faultyBucketReader) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := b.f.before(ctx, objstore.OpGet); err != nil {
+		return nil, err
+	}
+	if !b.f.visible(objstore.OpGet, name) {
+		return nil, errInvisible
+	}
+	rc, err := b.BucketReader.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return b.f.reader(objstore.OpGet, rc), nil
+}
+
+func (b *faultyBucketReader) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	if err := b.f.before(ctx, objstore.OpGetRange); err != nil {
+		return nil, err
+	}
+	if !b.f.visible(objstore.OpGetRange, name) {
+		return nil, errInvisible
+	}
+	rc, err := b.BucketReader.GetRange(ctx, name, off, length)
+	if err != nil {
+		return nil, err
+	}
+	return b.f.reader(objstore.OpGetRange, rc), nil
+}
+
+func (b *faultyBucketReader) Exists(ctx context.Context, name string) (bool, error) {
+	if err := b.f.before(ctx, objstore.OpExists); err != nil {
+		return false, err
+	}
+	if !b.f.visible(objstore.OpExists, name) {
+		return false, nil
+	}
+	return b.BucketReader.Exists(ctx, name)
+}
+
+func (b *faultyBucketReader) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	if err := b.f.before(ctx, objstore.OpAttributes); err != nil {
+		return objstore.ObjectAttributes{}, err
+	}
+	if !b.f.visible(objstore.OpAttributes, name) {
+		return objstore.ObjectAttributes{}, errInvisible
+	}
+	return b.BucketReader.Attributes(ctx, name)
+}
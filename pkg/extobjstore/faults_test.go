@@ -0,0 +1,103 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/objstore"
+	"gopkg.in/yaml.v2"
+)
+
+func TestFaultsConfig(t *testing.T) {
+	var conf FaultsConfig
+	testutil.Ok(t, yaml.UnmarshalStrict([]byte("operations: [get_range, exists]\nlatency: 10ms\nthrottling_rate: 0.5\n"), &conf))
+	testutil.Equals(t, []string{objstore.OpGetRange, objstore.OpExists}, conf.Operations)
+
+	testutil.NotOk(t, yaml.UnmarshalStrict([]byte("operations: [list]\n"), &conf))
+	testutil.NotOk(t, yaml.UnmarshalStrict([]byte("throttling_rate: 2\n"), &conf))
+	testutil.NotOk(t, yaml.UnmarshalStrict([]byte("latency: -1s\n"), &conf))
+}
+
+func TestFaults_Throttling(t *testing.T) {
+	ctx := context.Background()
+	bkt := WrapWithFaults(objstore.WithNoopInstr(objstore.NewInMemBucket()), FaultsConfig{Operations: []string{objstore.OpExists}, ThrottlingRate: 1}, nil)
+
+	testutil.Ok(t, bkt.Upload(ctx, "obj", strings.NewReader("content")))
+	_, err := bkt.Exists(ctx, "obj")
+	testutil.Assert(t, errors.Is(err, ErrThrottled), "%v", err)
+	testutil.Equals(t, 1.0, promtest.ToFloat64(bkt.(*faultyInstrumentedBucket).f.injected.WithLabelValues(objstore.OpExists, faultThrottling)))
+
+	// Injected throttling errors are retried.
+	code, ok := statusCode(err)
+	testutil.Assert(t, ok)
+	testutil.Equals(t, 429, code)
+}
+
+func TestFaults_PartialReads(t *testing.T) {
+	ctx := context.Background()
+	bkt := WrapWithFaults(objstore.WithNoopInstr(objstore.NewInMemBucket()), FaultsConfig{PartialReadRate: 1, Seed: 1}, nil)
+
+	testutil.Ok(t, bkt.Upload(ctx, "obj", bytes.NewReader(make([]byte, 8192))))
+	rc, err := bkt.Get(ctx, "obj")
+	testutil.Ok(t, err)
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	testutil.Assert(t, errors.Is(err, io.ErrUnexpectedEOF), "%v", err)
+	testutil.Assert(t, len(b) < 8192)
+}
+
+func TestFaults_ConsistencyDelay(t *testing.T) {
+	ctx := context.Background()
+	bkt := WrapWithFaults(objstore.WithNoopInstr(objstore.NewInMemBucket()), FaultsConfig{ConsistencyDelay: model.Duration(100 * time.Millisecond)}, nil)
+
+	testutil.Ok(t, bkt.Upload(ctx, "dir/obj", strings.NewReader("content")))
+
+	ok, err := bkt.Exists(ctx, "dir/obj")
+	testutil.Ok(t, err)
+	testutil.Assert(t, !ok)
+	_, err = bkt.Get(ctx, "dir/obj")
+	testutil.Assert(t, bkt.IsObjNotFoundErr(err), "%v", err)
+	_, err = bkt.ReaderWithExpectedErrs(bkt.IsObjNotFoundErr).Attributes(ctx, "dir/obj")
+	testutil.Assert(t, bkt.IsObjNotFoundErr(err), "%v", err)
+	var listed []string
+	testutil.Ok(t, bkt.Iter(ctx, "dir", func(name string) error {
+		listed = append(listed, name)
+		return nil
+	}))
+	testutil.Equals(t, 0, len(listed))
+
+	time.Sleep(100 * time.Millisecond)
+	ok, err = bkt.Exists(ctx, "dir/obj")
+	testutil.Ok(t, err)
+	testutil.Assert(t, ok)
+}
+
+func TestFaults_Config(t *testing.T) {
+	bkt, err := NewBucket(log.NewNopLogger(), []byte(`type: FILESYSTEM
+config:
+  directory: `+t.TempDir()+`
+faults:
+  operations: [upload]
+  throttling_rate: 1
+retry:
+  max_retries: 2
+  min_backoff: 1ms
+  max_backoff: 1ms
+`), nil, "test")
+	testutil.Ok(t, err)
+
+	err = bkt.Upload(context.Background(), "obj", strings.NewReader("content"))
+	testutil.Assert(t, errors.Is(err, ErrThrottled), "%v", err)
+}
@@ -0,0 +1,93 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/client"
+	"github.com/thanos-io/objstore/providers/gcs"
+	"gopkg.in/yaml.v2"
+)
+
+// GCSConfig is the configuration of the objects uploaded to GCS buckets, in the gcs section of the bucket configuration.
+type GCSConfig struct {
+	// KMSKeyName is the Cloud KMS key objects are encrypted with, instead of the default key of the bucket.
+	KMSKeyName string `yaml:"kms_key_name"`
+	// StorageClass is the storage class of uploaded objects, unless set with WithStorageClass. The default storage class
+	// of the bucket is used if empty.
+	StorageClass string `yaml:"storage_class"`
+}
+
+type storageClassKey struct{}
+
+// WithStorageClass returns a context, uploads with which store objects with the given storage class in buckets
+// supporting it, e.g. to store downsampled blocks with a cheaper storage class. It is supported by GCS and S3 buckets,
+// and Azure containers accessed with workload identity, the access tier of blobs of which it is.
+func WithStorageClass(ctx context.Context, class string) context.Context {
+	if class == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, storageClassKey{}, class)
+}
+
+func storageClassFromContext(ctx context.Context, def string) string {
+	if class, ok := ctx.Value(storageClassKey{}).(string); ok {
+		return class
+	}
+	return def
+}
+
+// newGCSBucket returns the GCS bucket of conf, uploading objects as configured by gcsConf, in chunks of the part size
+// of uploads, if set.
+func newGCSBucket(logger log.Logger, conf client.BucketConfig, gcsConf GCSConfig, uploads UploadsConfig, reg prometheus.Registerer, component string) (objstore.InstrumentedBucket, error) {
+	if !strings.EqualFold(string(conf.Type), string(client.GCS)) {
+		return nil, errors.Errorf("gcs section is not supported by %s buckets", conf.Type)
+	}
+	// Resumable uploads of GCS upload their chunks one after another.
+	if uploads.Concurrency > 1 {
+		return nil, errors.New("concurrency of uploads is not supported by GCS buckets")
+	}
+	content, err := yaml.Marshal(conf.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal content of bucket configuration")
+	}
+	bkt, err := gcs.NewBucket(context.Background(), logger, content, component)
+	if err != nil {
+		return nil, errors.Wrap(err, "create GCS client")
+	}
+	if gcsConf != (GCSConfig{}) {
+		level.Info(logger).Log("msg", "uploading objects to GCS bucket with custom attributes", "kms_key_name", gcsConf.KMSKeyName, "storage_class", gcsConf.StorageClass)
+	}
+	return objstore.NewTracingBucket(objstore.BucketWithMetrics(bkt.Name(), objstore.NewPrefixedBucket(&gcsBucket{Bucket: bkt, conf: gcsConf, chunkSize: int(uploads.PartSize)}, conf.Prefix), reg)), nil
+}
+
+type gcsBucket struct {
+	*gcs.Bucket
+	conf GCSConfig
+	// chunkSize is the size of the chunks of resumable uploads, or 0 for the default of the GCS client.
+	chunkSize int
+}
+
+// Upload uploads the object with the configured encryption key and storage class.
+func (b *gcsBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	w := b.Handle().Object(name).NewWriter(ctx)
+	w.KMSKeyName = b.conf.KMSKeyName
+	w.StorageClass = storageClassFromContext(ctx, b.conf.StorageClass)
+	if b.chunkSize > 0 {
+		w.ChunkSize = b.chunkSize
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	return w.Close()
+}
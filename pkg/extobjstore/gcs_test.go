@@ -0,0 +1,92 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+)
+
+type gcsUpload struct {
+	name, kmsKeyName, storageClass, content string
+}
+
+// fakeGCS serves multipart uploads of the GCS JSON API.
+type fakeGCS struct {
+	mtx     sync.Mutex
+	uploads []gcsUpload
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || r.URL.Query().Get("uploadType") != "multipart" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	var attrs struct {
+		Name         string `json:"name"`
+		StorageClass string `json:"storageClass"`
+	}
+	part, err := mr.NextPart()
+	if err == nil {
+		err = json.NewDecoder(part).Decode(&attrs)
+	}
+	var content []byte
+	if err == nil {
+		part, err = mr.NextPart()
+	}
+	if err == nil {
+		content, err = io.ReadAll(part)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mtx.Lock()
+	f.uploads = append(f.uploads, gcsUpload{name: attrs.Name, kmsKeyName: r.URL.Query().Get("kmsKeyName"), storageClass: attrs.StorageClass, content: string(content)})
+	f.mtx.Unlock()
+	_ = json.NewEncoder(w).Encode(map[string]string{"bucket": "test", "name": attrs.Name})
+}
+
+func TestGCSBucket(t *testing.T) {
+	fake := &fakeGCS{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	t.Setenv("STORAGE_EMULATOR_HOST", srv.URL)
+
+	ctx := context.Background()
+	bkt, err := NewBucket(log.NewNopLogger(), []byte("type: GCS\nconfig:\n  bucket: test\ngcs:\n  kms_key_name: projects/p/locations/l/keyRings/r/cryptoKeys/k\n  storage_class: STANDARD\n"), nil, "test")
+	testutil.Ok(t, err)
+	testutil.Ok(t, bkt.Upload(ctx, "raw", strings.NewReader("raw")))
+	testutil.Ok(t, bkt.Upload(WithStorageClass(ctx, "NEARLINE"), "downsampled", strings.NewReader("downsampled")))
+
+	// The storage class of the context applies to buckets without gcs section too.
+	bkt, err = NewBucket(log.NewNopLogger(), []byte("type: GCS\nconfig:\n  bucket: test\n"), nil, "test")
+	testutil.Ok(t, err)
+	testutil.Ok(t, bkt.Upload(ctx, "default", strings.NewReader("default")))
+	testutil.Ok(t, bkt.Upload(WithStorageClass(ctx, "COLDLINE"), "coldline", strings.NewReader("coldline")))
+
+	testutil.Equals(t, []gcsUpload{
+		{name: "raw", kmsKeyName: "projects/p/locations/l/keyRings/r/cryptoKeys/k", storageClass: "STANDARD", content: "raw"},
+		{name: "downsampled", kmsKeyName: "projects/p/locations/l/keyRings/r/cryptoKeys/k", storageClass: "NEARLINE", content: "downsampled"},
+		{name: "default", content: "default"},
+		{name: "coldline", storageClass: "COLDLINE", content: "coldline"},
+	}, fake.uploads)
+
+	_, err = NewBucket(log.NewNopLogger(), []byte("type: FILESYSTEM\nconfig:\n  directory: "+t.TempDir()+"\ngcs:\n  storage_class: NEARLINE\n"), nil, "test")
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,430 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package hdfs implements the objstore.Bucket interface against HDFS, using the WebHDFS REST API of the NameNode.
+package hdfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/exthttp"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// DefaultConfig is the HDFS configuration with the default values set.
+var DefaultConfig = Config{
+	HTTPConfig: exthttp.HTTPConfig{
+		IdleConnTimeout:       model.Duration(90 * time.Second),
+		ResponseHeaderTimeout: model.Duration(2 * time.Minute),
+		TLSHandshakeTimeout:   model.Duration(10 * time.Second),
+		ExpectContinueTimeout: model.Duration(1 * time.Second),
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   100,
+		MaxConnsPerHost:       0,
+	},
+}
+
+// Config is the configuration of an HDFS bucket.
+type Config struct {
+	// Address is the HTTP address of the NameNode, e.g. http://namenode:9870.
+	Address string `yaml:"address"`
+	// Directory is the absolute path of the directory objects are stored in.
+	Directory string `yaml:"directory"`
+	// User is the user of the requests, with simple authentication.
+	User string `yaml:"user"`
+	// DelegationToken is the delegation token of the requests, in clusters with Kerberos authentication.
+	DelegationToken string             `yaml:"delegation_token"`
+	HTTPConfig      exthttp.HTTPConfig `yaml:"http_config"`
+}
+
+func (conf *Config) validate() error {
+	if conf.Address == "" {
+		return errors.New("no HDFS NameNode address specified")
+	}
+	if !path.IsAbs(conf.Directory) {
+		return errors.Errorf("HDFS directory must be an absolute path, got %q", conf.Directory)
+	}
+	if conf.User != "" && conf.DelegationToken != "" {
+		return errors.New("user and delegation_token are mutually exclusive")
+	}
+	return nil
+}
+
+// Bucket implements the objstore.Bucket interface against HDFS. Objects are files in the directory of the bucket,
+// and directories of their names are directories of HDFS.
+type Bucket struct {
+	logger  log.Logger
+	conf    Config
+	baseURL *url.URL
+	client  *http.Client
+}
+
+// NewBucket returns a new Bucket using the provided HDFS configuration.
+func NewBucket(logger log.Logger, conf []byte) (*Bucket, error) {
+	config := DefaultConfig
+	if err := yaml.UnmarshalStrict(conf, &config); err != nil {
+		return nil, errors.Wrap(err, "parsing HDFS configuration")
+	}
+	return NewBucketWithConfig(logger, config)
+}
+
+// NewBucketWithConfig returns a new Bucket using the provided HDFS configuration struct.
+func NewBucketWithConfig(logger log.Logger, conf Config) (*Bucket, error) {
+	if err := conf.validate(); err != nil {
+		return nil, errors.Wrap(err, "validating HDFS configuration")
+	}
+	baseURL, err := url.Parse(strings.TrimSuffix(conf.Address, "/"))
+	if err != nil {
+		return nil, errors.Wrap(err, "parse HDFS NameNode address")
+	}
+
+	rt := conf.HTTPConfig.Transport
+	if rt == nil {
+		if rt, err = exthttp.DefaultTransport(conf.HTTPConfig); err != nil {
+			return nil, errors.Wrap(err, "create HDFS transport")
+		}
+	}
+	return &Bucket{
+		logger:  logger,
+		conf:    conf,
+		baseURL: baseURL,
+		client: &http.Client{
+			Transport: rt,
+			// Reads are redirected to the DataNodes by the NameNode. Writes are redirected too, but are sent to the
+			// DataNodes by Upload, as the body can't be sent twice.
+			CheckRedirect: func(req *http.Request, _ []*http.Request) error {
+				if req.Method != http.MethodGet {
+					return http.ErrUseLastResponse
+				}
+				return nil
+			},
+		},
+	}, nil
+}
+
+// RemoteException is an error response of WebHDFS.
+type RemoteException struct {
+	StatusCode    int    `json:"-"`
+	Exception     string `json:"exception"`
+	JavaClassName string `json:"javaClassName"`
+	Message       string `json:"message"`
+}
+
+func (e *RemoteException) Error() string {
+	if e.Exception == "" {
+		return fmt.Sprintf("WebHDFS request failed with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("WebHDFS request failed with status %d: %s: %s", e.StatusCode, e.Exception, e.Message)
+}
+
+type fileStatus struct {
+	PathSuffix string `json:"pathSuffix"`
+	Type       string `json:"type"`
+	Length     int64  `json:"length"`
+	// ModificationTime is the time of the last modification in milliseconds since the epoch.
+	ModificationTime int64 `json:"modificationTime"`
+}
+
+// opURL returns the URL of the operation on the object or directory name.
+func (b *Bucket) opURL(name, op string, params url.Values) string {
+	u := *b.baseURL
+	u.Path += "/webhdfs/v1" + path.Join(b.conf.Directory, name)
+	query := url.Values{"op": {op}}
+	for k, v := range params {
+		query[k] = v
+	}
+	if b.conf.User != "" {
+		query.Set("user.name", b.conf.User)
+	}
+	if b.conf.DelegationToken != "" {
+		query.Set("delegation", b.conf.DelegationToken)
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// do sends the request, returning the response of 2xx and 307 status codes, and the remote exception otherwise.
+func (b *Bucket) do(ctx context.Context, method, u string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 || resp.StatusCode == http.StatusTemporaryRedirect {
+		return resp, nil
+	}
+
+	defer runutil.ExhaustCloseWithLogOnErr(b.logger, resp.Body, "WebHDFS error response")
+	var errResp struct {
+		RemoteException RemoteException `json:"RemoteException"`
+	}
+	// Error responses of proxies have no JSON body, and are returned with their status code only.
+	_ = json.NewDecoder(resp.Body).Decode(&errResp)
+	errResp.RemoteException.StatusCode = resp.StatusCode
+	return nil, &errResp.RemoteException
+}
+
+// doJSON sends the request to the NameNode and decodes the JSON response into v.
+func (b *Bucket) doJSON(ctx context.Context, method, name, op string, params url.Values, v interface{}) error {
+	resp, err := b.do(ctx, method, b.opURL(name, op, params), nil)
+	if err != nil {
+		return err
+	}
+	defer runutil.ExhaustCloseWithLogOnErr(b.logger, resp.Body, "WebHDFS response")
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.Wrapf(err, "decode %s response", op)
+	}
+	return nil
+}
+
+func (b *Bucket) listStatus(ctx context.Context, dir string) ([]fileStatus, error) {
+	var resp struct {
+		FileStatuses struct {
+			FileStatus []fileStatus `json:"FileStatus"`
+		} `json:"FileStatuses"`
+	}
+	if err := b.doJSON(ctx, http.MethodGet, dir, "LISTSTATUS", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.FileStatuses.FileStatus, nil
+}
+
+// Name returns the bucket name for the provider.
+func (b *Bucket) Name() string {
+	return fmt.Sprintf("hdfs: %s", b.conf.Directory)
+}
+
+func (b *Bucket) Close() error {
+	return nil
+}
+
+// Iter calls f for each entry in the given directory (not recursive). The argument to f is the full
+// object name including the prefix of the inspected directory.
+func (b *Bucket) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	if dir != "" {
+		dir = strings.TrimSuffix(dir, objstore.DirDelim) + objstore.DirDelim
+	}
+	statuses, err := b.listStatus(ctx, dir)
+	if err != nil {
+		if b.IsObjNotFoundErr(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "list directory %s", dir)
+	}
+
+	recursive := objstore.ApplyIterOptions(options...).Recursive
+	for _, status := range statuses {
+		// Files are listed with an empty path suffix, if dir is a file.
+		if status.PathSuffix == "" {
+			continue
+		}
+		name := dir + status.PathSuffix
+		if status.Type == "FILE" && strings.HasSuffix(name, tmpSuffix) {
+			// Objects being uploaded.
+			continue
+		}
+		if status.Type == "DIRECTORY" {
+			name += objstore.DirDelim
+			if recursive {
+				if err := b.Iter(ctx, name, f, options...); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if err := f(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get returns a reader for the given object name.
+func (b *Bucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.GetRange(ctx, name, 0, -1)
+}
+
+// GetRange returns a new range reader for the given object name and range.
+func (b *Bucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	if name == "" {
+		return nil, errors.New("object name is empty")
+	}
+	params := url.Values{}
+	if off > 0 {
+		params.Set("offset", strconv.FormatInt(off, 10))
+	}
+	if length >= 0 {
+		// A length of 0 reads the whole file with WebHDFS.
+		if length == 0 {
+			if _, err := b.Attributes(ctx, name); err != nil {
+				return nil, err
+			}
+			return io.NopCloser(bytes.NewReader(nil)), nil
+		}
+		params.Set("length", strconv.FormatInt(length, 10))
+	}
+	resp, err := b.do(ctx, http.MethodGet, b.opURL(name, "OPEN", params), nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Exists checks if the given object exists in the bucket.
+func (b *Bucket) Exists(ctx context.Context, name string) (bool, error) {
+	if _, err := b.Attributes(ctx, name); err != nil {
+		if b.IsObjNotFoundErr(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "get file status of %s", name)
+	}
+	return true, nil
+}
+
+// Attributes returns information about the specified object.
+func (b *Bucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	var resp struct {
+		FileStatus fileStatus `json:"FileStatus"`
+	}
+	if err := b.doJSON(ctx, http.MethodGet, name, "GETFILESTATUS", nil, &resp); err != nil {
+		return objstore.ObjectAttributes{}, err
+	}
+	if resp.FileStatus.Type != "FILE" {
+		return objstore.ObjectAttributes{}, &RemoteException{StatusCode: http.StatusNotFound, Exception: "FileNotFoundException", Message: fmt.Sprintf("%s is a directory", name)}
+	}
+	return objstore.ObjectAttributes{
+		Size:         resp.FileStatus.Length,
+		LastModified: time.UnixMilli(resp.FileStatus.ModificationTime),
+	}, nil
+}
+
+// tmpSuffix is the suffix of the files objects are written to before being renamed into place, so that readers never
+// see partially written objects. These files are not listed by Iter.
+const tmpSuffix = ".thanos-tmp"
+
+// Upload the contents of the reader as an object into the bucket. The object is written to a temporary file which is
+// renamed into place once complete. HDFS does not rename over existing files, so an object being overwritten is
+// missing for the time between its deletion and the rename. The directories of the object are created by HDFS.
+func (b *Bucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	tmp := fmt.Sprintf("%s.%x%s", name, rand.Uint64(), tmpSuffix)
+	if err := b.create(ctx, tmp, r); err != nil {
+		b.deleteTmp(ctx, tmp)
+		return err
+	}
+
+	renamed, err := b.rename(ctx, tmp, name)
+	if err == nil && !renamed {
+		if err = b.Delete(ctx, name); err == nil || b.IsObjNotFoundErr(err) {
+			renamed, err = b.rename(ctx, tmp, name)
+		}
+	}
+	if err == nil && !renamed {
+		err = errors.New("rename returned false")
+	}
+	if err != nil {
+		b.deleteTmp(ctx, tmp)
+		return errors.Wrapf(err, "rename %s to %s", tmp, name)
+	}
+	return nil
+}
+
+// create writes the contents of the reader to the file with the given name, overwriting it if it exists.
+func (b *Bucket) create(ctx context.Context, name string, r io.Reader) error {
+	resp, err := b.do(ctx, http.MethodPut, b.opURL(name, "CREATE", url.Values{"overwrite": {"true"}}), nil)
+	if err != nil {
+		return errors.Wrapf(err, "create file %s", name)
+	}
+	runutil.ExhaustCloseWithLogOnErr(b.logger, resp.Body, "WebHDFS create response")
+	location, err := resp.Location()
+	if resp.StatusCode != http.StatusTemporaryRedirect || err != nil {
+		return errors.Errorf("create file %s: expected redirect to DataNode, got status %d", name, resp.StatusCode)
+	}
+
+	resp, err = b.do(ctx, http.MethodPut, location.String(), r)
+	if err != nil {
+		return errors.Wrapf(err, "write file %s", name)
+	}
+	runutil.ExhaustCloseWithLogOnErr(b.logger, resp.Body, "WebHDFS write response")
+	return nil
+}
+
+// rename renames the file src to dst. It returns false if HDFS refused to, e.g. because dst exists.
+func (b *Bucket) rename(ctx context.Context, src, dst string) (bool, error) {
+	var resp struct {
+		Boolean bool `json:"boolean"`
+	}
+	if err := b.doJSON(ctx, http.MethodPut, src, "RENAME", url.Values{"destination": {path.Join(b.conf.Directory, dst)}}, &resp); err != nil {
+		return false, err
+	}
+	return resp.Boolean, nil
+}
+
+// deleteTmp deletes the temporary file of a failed upload on a best effort basis.
+func (b *Bucket) deleteTmp(ctx context.Context, tmp string) {
+	var resp struct {
+		Boolean bool `json:"boolean"`
+	}
+	if err := b.doJSON(ctx, http.MethodDelete, tmp, "DELETE", nil, &resp); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to delete temporary file of failed upload", "file", tmp, "err", err)
+	}
+}
+
+// Delete removes the object with the given name, and the directories of it left empty.
+func (b *Bucket) Delete(ctx context.Context, name string) error {
+	var resp struct {
+		Boolean bool `json:"boolean"`
+	}
+	if err := b.doJSON(ctx, http.MethodDelete, name, "DELETE", nil, &resp); err != nil {
+		return errors.Wrapf(err, "delete file %s", name)
+	}
+	if !resp.Boolean {
+		return &RemoteException{StatusCode: http.StatusNotFound, Exception: "FileNotFoundException", Message: fmt.Sprintf("%s does not exist", name)}
+	}
+
+	// Empty directories are deleted on a best effort basis, as they are listed by Iter otherwise.
+	for dir := path.Dir(strings.TrimSuffix(name, objstore.DirDelim)); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		statuses, err := b.listStatus(ctx, dir)
+		if err != nil || len(statuses) > 0 {
+			break
+		}
+		// Directories are deleted without recursive, as objects might have been uploaded into them meanwhile.
+		if err := b.doJSON(ctx, http.MethodDelete, dir, "DELETE", url.Values{"recursive": {"false"}}, &resp); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to delete empty directory", "dir", dir, "err", err)
+			break
+		}
+	}
+	return nil
+}
+
+// IsObjNotFoundErr returns true if error means that object is not found. Relevant to Get operations.
+func (b *Bucket) IsObjNotFoundErr(err error) bool {
+	var remoteErr *RemoteException
+	if !errors.As(err, &remoteErr) {
+		return false
+	}
+	return remoteErr.Exception == "FileNotFoundException" || (remoteErr.Exception == "" && remoteErr.StatusCode == http.StatusNotFound)
+}
@@ -0,0 +1,227 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package hdfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/thanos-io/objstore"
+)
+
+// fakeWebHDFS serves the WebHDFS API of a NameNode, redirecting reads and writes to a DataNode at /datanode.
+type fakeWebHDFS struct {
+	mtx   sync.Mutex
+	files map[string][]byte
+	dirs  map[string]bool
+}
+
+func newFakeWebHDFS() *fakeWebHDFS {
+	return &fakeWebHDFS{files: map[string][]byte{}, dirs: map[string]bool{"/": true}}
+}
+
+func (f *fakeWebHDFS) remoteException(w http.ResponseWriter, status int, exception string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]map[string]string{"RemoteException": {"exception": exception}})
+}
+
+func (f *fakeWebHDFS) children(dir string) []map[string]interface{} {
+	var statuses []map[string]interface{}
+	for p, content := range f.files {
+		if path.Dir(p) == dir {
+			statuses = append(statuses, map[string]interface{}{"pathSuffix": path.Base(p), "type": "FILE", "length": len(content)})
+		}
+	}
+	for p := range f.dirs {
+		if p != "/" && path.Dir(p) == dir {
+			statuses = append(statuses, map[string]interface{}{"pathSuffix": path.Base(p), "type": "DIRECTORY"})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i]["pathSuffix"].(string) < statuses[j]["pathSuffix"].(string)
+	})
+	return statuses
+}
+
+func (f *fakeWebHDFS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	query := r.URL.Query()
+	if query.Get("user.name") != "thanos" {
+		f.remoteException(w, http.StatusUnauthorized, "SecurityException")
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/datanode/") {
+		f.serveDataNode(w, r)
+		return
+	}
+	p := path.Clean(strings.TrimPrefix(r.URL.Path, "/webhdfs/v1"))
+
+	switch query.Get("op") {
+	case "LISTSTATUS":
+		if content, ok := f.files[p]; ok {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"FileStatuses": map[string]interface{}{"FileStatus": []map[string]interface{}{{"pathSuffix": "", "type": "FILE", "length": len(content)}}}})
+			return
+		}
+		if !f.dirs[p] {
+			f.remoteException(w, http.StatusNotFound, "FileNotFoundException")
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"FileStatuses": map[string]interface{}{"FileStatus": f.children(p)}})
+	case "GETFILESTATUS":
+		status := map[string]interface{}{"type": "DIRECTORY", "modificationTime": 1600000000000}
+		if content, ok := f.files[p]; ok {
+			status["type"] = "FILE"
+			status["length"] = len(content)
+		} else if !f.dirs[p] {
+			f.remoteException(w, http.StatusNotFound, "FileNotFoundException")
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"FileStatus": status})
+	case "OPEN", "CREATE":
+		if query.Get("op") == "OPEN" {
+			if _, ok := f.files[p]; !ok {
+				f.remoteException(w, http.StatusNotFound, "FileNotFoundException")
+				return
+			}
+		}
+		http.Redirect(w, r, "/datanode"+p+"?"+query.Encode(), http.StatusTemporaryRedirect)
+	case "RENAME":
+		content, ok := f.files[p]
+		dst := query.Get("destination")
+		_, exists := f.files[dst]
+		if ok && !exists && !f.dirs[dst] {
+			delete(f.files, p)
+			f.files[dst] = content
+		}
+		_ = json.NewEncoder(w).Encode(map[string]bool{"boolean": ok && !exists && !f.dirs[dst]})
+	case "DELETE":
+		_, isFile := f.files[p]
+		switch {
+		case isFile:
+			delete(f.files, p)
+		case f.dirs[p] && len(f.children(p)) == 0:
+			delete(f.dirs, p)
+		case f.dirs[p]:
+			f.remoteException(w, http.StatusForbidden, "PathIsNotEmptyDirectoryException")
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]bool{"boolean": isFile || !f.dirs[p]})
+	default:
+		f.remoteException(w, http.StatusBadRequest, "IllegalArgumentException")
+	}
+}
+
+func (f *fakeWebHDFS) serveDataNode(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/datanode")
+	query := r.URL.Query()
+
+	switch query.Get("op") {
+	case "OPEN":
+		content := f.files[p]
+		off, _ := strconv.Atoi(query.Get("offset"))
+		end := len(content)
+		if length := query.Get("length"); length != "" {
+			n, _ := strconv.Atoi(length)
+			if off+n < end {
+				end = off + n
+			}
+		}
+		_, _ = w.Write(content[off:end])
+	case "CREATE":
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			f.remoteException(w, http.StatusBadRequest, "IOException")
+			return
+		}
+		f.files[p] = content
+		for dir := path.Dir(p); dir != "/"; dir = path.Dir(dir) {
+			f.dirs[dir] = true
+		}
+		w.WriteHeader(http.StatusCreated)
+	default:
+		f.remoteException(w, http.StatusBadRequest, "IllegalArgumentException")
+	}
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, errors.New("read failed") }
+
+func newTestBucket(t *testing.T, fake *fakeWebHDFS) *Bucket {
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	conf := DefaultConfig
+	conf.Address = srv.URL
+	conf.Directory = "/thanos"
+	conf.User = "thanos"
+	bkt, err := NewBucketWithConfig(log.NewNopLogger(), conf)
+	testutil.Ok(t, err)
+	return bkt
+}
+
+func TestBucket_Acceptance(t *testing.T) {
+	fake := newFakeWebHDFS()
+	objstore.AcceptanceTest(t, newTestBucket(t, fake))
+
+	// Directories left empty by deleted objects are deleted.
+	testutil.Equals(t, map[string]bool{"/": true, "/thanos": true, "/thanos/id1": true, "/thanos/id1/sub": true}, fake.dirs)
+}
+
+func TestBucket_Errors(t *testing.T) {
+	fake := newFakeWebHDFS()
+	bkt := newTestBucket(t, fake)
+	ctx := context.Background()
+
+	_, err := bkt.Get(ctx, "missing")
+	testutil.Assert(t, bkt.IsObjNotFoundErr(err))
+	testutil.Ok(t, bkt.Upload(ctx, "dir/obj", strings.NewReader("content")))
+	_, err = bkt.Attributes(ctx, "dir")
+	testutil.Assert(t, bkt.IsObjNotFoundErr(err))
+	ok, err := bkt.Exists(ctx, "dir")
+	testutil.Ok(t, err)
+	testutil.Assert(t, !ok)
+
+	// Objects are overwritten and failed uploads leave neither the object nor a temporary file behind.
+	testutil.Ok(t, bkt.Upload(ctx, "dir/obj", strings.NewReader("new content")))
+	testutil.NotOk(t, bkt.Upload(ctx, "dir/failed", io.MultiReader(strings.NewReader("partial"), errReader{})))
+	testutil.Equals(t, map[string][]byte{"/thanos/dir/obj": []byte("new content")}, fake.files)
+
+	// Temporary files of uploads in progress are not listed.
+	fake.files["/thanos/dir/other.1"+tmpSuffix] = []byte("partial")
+	var names []string
+	testutil.Ok(t, bkt.Iter(ctx, "dir/", func(name string) error {
+		names = append(names, name)
+		return nil
+	}))
+	testutil.Equals(t, []string{"dir/obj"}, names)
+
+	bkt.conf.User = "unknown"
+	_, err = bkt.Get(ctx, "dir/obj")
+	testutil.NotOk(t, err)
+	testutil.Assert(t, !bkt.IsObjNotFoundErr(err))
+
+	for _, conf := range []string{
+		"directory: /thanos",
+		"address: http://namenode:9870\ndirectory: thanos",
+		"address: http://namenode:9870\ndirectory: /thanos\nuser: thanos\ndelegation_token: token",
+	} {
+		_, err := NewBucket(log.NewNopLogger(), []byte(conf))
+		testutil.NotOk(t, err)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/minio/minio-go/v7"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"google.golang.org/api/googleapi"
+)
+
+// ObjectLockConfig is the S3 Object Lock retention of the objects uploaded to the bucket, in the object_lock section
+// of the bucket configuration. The bucket must have Object Lock enabled.
+type ObjectLockConfig struct {
+	// Mode is the retention mode, GOVERNANCE or COMPLIANCE.
+	Mode string `yaml:"mode"`
+	// Retention is the duration objects are retained for after their upload.
+	Retention model.Duration `yaml:"retention"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *ObjectLockConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = ObjectLockConfig{Mode: string(minio.Governance)}
+	type plain ObjectLockConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if !minio.RetentionMode(c.Mode).IsValid() {
+		return errors.Errorf("invalid mode %q, must be %s or %s", c.Mode, minio.Governance, minio.Compliance)
+	}
+	if c.Retention <= 0 {
+		return errors.New("retention must be positive")
+	}
+	return nil
+}
+
+// IsImmutableErr returns true if the error is for an object that can't be deleted or overwritten, as it is protected
+// by the S3 Object Lock, the GCS retention policy or holds, or the Azure immutability policy or legal hold of the
+// bucket.
+func IsImmutableErr(err error) bool {
+	var minioErr minio.ErrorResponse
+	if errors.As(err, &minioErr) {
+		// S3 denies access to locked object versions, and MinIO reports them as locked.
+		return minioErr.Code == "ObjectLocked" ||
+			(minioErr.Code == "AccessDenied" && strings.Contains(strings.ToLower(minioErr.Message), "object lock"))
+	}
+	var gcsErr *googleapi.Error
+	if errors.As(err, &gcsErr) {
+		if gcsErr.Code != http.StatusForbidden {
+			return false
+		}
+		for _, e := range gcsErr.Errors {
+			if e.Reason == "retentionPolicyNotMet" {
+				return true
+			}
+		}
+		return strings.Contains(gcsErr.Message, "retention policy") || strings.Contains(gcsErr.Message, "hold")
+	}
+	var azureErr *azcore.ResponseError
+	if errors.As(err, &azureErr) {
+		return azureErr.ErrorCode == "BlobImmutableDueToPolicy" || azureErr.ErrorCode == "BlobImmutableDueToLegalHold"
+	}
+	return false
+}
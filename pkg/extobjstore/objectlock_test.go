@@ -0,0 +1,87 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/minio/minio-go/v7"
+	"github.com/pkg/errors"
+	"google.golang.org/api/googleapi"
+)
+
+func TestIsImmutableErr(t *testing.T) {
+	for _, tcase := range []struct {
+		err  error
+		want bool
+	}{
+		{err: minio.ErrorResponse{Code: "ObjectLocked"}, want: true},
+		{err: errors.Wrap(minio.ErrorResponse{Code: "AccessDenied", Message: "Access Denied because object protected by object lock."}, "delete"), want: true},
+		{err: minio.ErrorResponse{Code: "AccessDenied", Message: "Access Denied"}},
+		{err: &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "retentionPolicyNotMet"}}}, want: true},
+		{err: &googleapi.Error{Code: http.StatusForbidden, Message: "Object is under active Temporary hold and cannot be deleted"}, want: true},
+		{err: &googleapi.Error{Code: http.StatusForbidden, Message: "Permission denied"}},
+		{err: &azcore.ResponseError{ErrorCode: "BlobImmutableDueToPolicy"}, want: true},
+		{err: &azcore.ResponseError{ErrorCode: "AuthorizationFailure"}},
+		{err: errors.New("connection reset")},
+	} {
+		testutil.Equals(t, tcase.want, IsImmutableErr(tcase.err), "%v", tcase.err)
+	}
+}
+
+func TestS3ObjectLock(t *testing.T) {
+	var headers http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		headers = r.Header.Clone()
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("ETag", `"etag"`)
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	testutil.Ok(t, err)
+
+	bkt, err := NewBucket(log.NewNopLogger(), []byte(`type: S3
+config:
+  bucket: test
+  endpoint: `+u.Host+`
+  region: us-east-1
+  insecure: true
+  access_key: key
+  secret_key: secret
+object_lock:
+  mode: COMPLIANCE
+  retention: 30d
+`), nil, "test")
+	testutil.Ok(t, err)
+
+	testutil.Ok(t, bkt.Upload(context.Background(), "obj", strings.NewReader("content")))
+	testutil.Equals(t, "COMPLIANCE", headers.Get("X-Amz-Object-Lock-Mode"))
+	testutil.Assert(t, headers.Get("Content-Md5") != "")
+	retainUntil, err := time.Parse(time.RFC3339, headers.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+	testutil.Ok(t, err)
+	testutil.Assert(t, time.Until(retainUntil) > 29*24*time.Hour)
+
+	for _, conf := range []string{
+		"type: S3\nconfig:\n  bucket: test\n  endpoint: " + u.Host + "\nobject_lock:\n  mode: LEGAL\n  retention: 1d\n",
+		"type: S3\nconfig:\n  bucket: test\n  endpoint: " + u.Host + "\nobject_lock:\n  mode: GOVERNANCE\n",
+		"type: FILESYSTEM\nconfig:\n  directory: " + t.TempDir() + "\nobject_lock:\n  retention: 1d\n",
+	} {
+		_, err = NewBucket(log.NewNopLogger(), []byte(conf), nil, "test")
+		testutil.NotOk(t, err)
+	}
+}
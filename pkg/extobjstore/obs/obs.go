@@ -0,0 +1,517 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package obs implements the objstore.Bucket interface against the native API of HuaweiCloud Object Storage Service.
+// OBS has an S3 compatible API, which however does not behave like S3 for some listings and range reads.
+package obs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/exthttp"
+	"golang.org/x/sync/errgroup"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// DefaultConfig is the OBS configuration with the default values set.
+var DefaultConfig = Config{
+	PartSize:          1024 * 1024 * 64, // 64MB.
+	UploadConcurrency: 4,
+	HTTPConfig: exthttp.HTTPConfig{
+		IdleConnTimeout:       model.Duration(90 * time.Second),
+		ResponseHeaderTimeout: model.Duration(2 * time.Minute),
+		TLSHandshakeTimeout:   model.Duration(10 * time.Second),
+		ExpectContinueTimeout: model.Duration(1 * time.Second),
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   100,
+		MaxConnsPerHost:       0,
+	},
+}
+
+// Config is the configuration of an OBS bucket.
+type Config struct {
+	Bucket string `yaml:"bucket"`
+	// Endpoint is the regional endpoint of OBS, e.g. obs.cn-north-4.myhuaweicloud.com.
+	Endpoint      string `yaml:"endpoint"`
+	AccessKey     string `yaml:"access_key"`
+	SecretKey     string `yaml:"secret_key"`
+	SecurityToken string `yaml:"security_token"`
+	Insecure      bool   `yaml:"insecure"`
+	// PartSize is the size of the parts of multipart uploads, used for objects larger than it.
+	PartSize uint64 `yaml:"part_size"`
+	// UploadConcurrency is the number of parts of a multipart upload uploaded in parallel.
+	UploadConcurrency int                `yaml:"upload_concurrency"`
+	HTTPConfig        exthttp.HTTPConfig `yaml:"http_config"`
+}
+
+func (conf *Config) validate() error {
+	if conf.Bucket == "" {
+		return errors.New("no OBS bucket specified")
+	}
+	if conf.Endpoint == "" {
+		return errors.New("no OBS endpoint specified")
+	}
+	if (conf.AccessKey == "") != (conf.SecretKey == "") {
+		return errors.New("access_key and secret_key must be both set, or both empty for anonymous access")
+	}
+	// OBS supports up to 10000 parts of at least 100KB.
+	if conf.PartSize < 100*1024 {
+		return errors.New("part_size must be at least 100KB")
+	}
+	if conf.UploadConcurrency < 1 {
+		return errors.New("upload_concurrency must be positive")
+	}
+	return nil
+}
+
+// Bucket implements the objstore.Bucket interface against OBS.
+type Bucket struct {
+	logger log.Logger
+	name   string
+	conf   Config
+	// baseURL is the virtual hosted URL of the bucket.
+	baseURL   *url.URL
+	client    *http.Client
+	userAgent string
+}
+
+// NewBucket returns a new Bucket using the provided OBS configuration.
+func NewBucket(logger log.Logger, conf []byte, component string) (*Bucket, error) {
+	config := DefaultConfig
+	if err := yaml.UnmarshalStrict(conf, &config); err != nil {
+		return nil, errors.Wrap(err, "parsing OBS configuration")
+	}
+	return NewBucketWithConfig(logger, config, component)
+}
+
+// NewBucketWithConfig returns a new Bucket using the provided OBS configuration struct.
+func NewBucketWithConfig(logger log.Logger, conf Config, component string) (*Bucket, error) {
+	if err := conf.validate(); err != nil {
+		return nil, errors.Wrap(err, "validating OBS configuration")
+	}
+
+	rt := conf.HTTPConfig.Transport
+	if rt == nil {
+		var err error
+		if rt, err = exthttp.DefaultTransport(conf.HTTPConfig); err != nil {
+			return nil, errors.Wrap(err, "create OBS transport")
+		}
+	}
+	scheme := "https"
+	if conf.Insecure {
+		scheme = "http"
+	}
+	return &Bucket{
+		logger:    logger,
+		name:      conf.Bucket,
+		conf:      conf,
+		baseURL:   &url.URL{Scheme: scheme, Host: conf.Bucket + "." + conf.Endpoint},
+		client:    &http.Client{Transport: rt},
+		userAgent: fmt.Sprintf("thanos-%s", component),
+	}, nil
+}
+
+// Error is an error response of OBS.
+type Error struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+	RequestID  string `xml:"RequestId"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("OBS request failed with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("OBS request failed with status %d: %s: %s (request ID %s)", e.StatusCode, e.Code, e.Message, e.RequestID)
+}
+
+// request sends a signed request for the object key, or the bucket if key is empty. Sub-resources are part of the
+// signature, while the other query parameters are not.
+func (b *Bucket) request(ctx context.Context, method, key string, subresources, query url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	u := *b.baseURL
+	u.Path = "/" + key
+	params := url.Values{}
+	for k, v := range query {
+		params[k] = v
+	}
+	for k, v := range subresources {
+		params[k] = v
+	}
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("User-Agent", b.userAgent)
+	b.sign(req, "/"+b.name+u.EscapedPath(), subresources)
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer runutil.ExhaustCloseWithLogOnErr(b.logger, resp.Body, "OBS error response")
+		obsErr := &Error{}
+		if method != http.MethodHead {
+			// Error responses without XML body, e.g. of the load balancer, are returned with their status code only.
+			_ = xml.NewDecoder(resp.Body).Decode(obsErr)
+		}
+		obsErr.StatusCode = resp.StatusCode
+		return nil, obsErr
+	}
+	return resp, nil
+}
+
+// sign signs the request with the access key, as described in
+// https://support.huaweicloud.com/intl/en-us/api-obs/obs_04_0010.html.
+func (b *Bucket) sign(req *http.Request, resource string, subresources url.Values) {
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	if b.conf.SecurityToken != "" {
+		req.Header.Set("x-obs-security-token", b.conf.SecurityToken)
+	}
+	if b.conf.AccessKey == "" {
+		return
+	}
+
+	var obsHeaders []string
+	for k := range req.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-obs-") {
+			obsHeaders = append(obsHeaders, k)
+		}
+	}
+	sort.Strings(obsHeaders)
+
+	var sb strings.Builder
+	sb.WriteString(req.Method + "\n")
+	sb.WriteString(req.Header.Get("Content-MD5") + "\n")
+	sb.WriteString(req.Header.Get("Content-Type") + "\n")
+	sb.WriteString(req.Header.Get("Date") + "\n")
+	for _, k := range obsHeaders {
+		sb.WriteString(k + ":" + strings.Join(req.Header.Values(k), ",") + "\n")
+	}
+	sb.WriteString(resource)
+	if len(subresources) > 0 {
+		keys := make([]string, 0, len(subresources))
+		for k := range subresources {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for i, k := range keys {
+			sep := "&"
+			if i == 0 {
+				sep = "?"
+			}
+			sb.WriteString(sep + k)
+			if v := subresources.Get(k); v != "" {
+				sb.WriteString("=" + v)
+			}
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(b.conf.SecretKey))
+	mac.Write([]byte(sb.String()))
+	req.Header.Set("Authorization", "OBS "+b.conf.AccessKey+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// Name returns the bucket name for the provider.
+func (b *Bucket) Name() string {
+	return b.name
+}
+
+func (b *Bucket) Close() error {
+	return nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated bool   `xml:"IsTruncated"`
+	NextMarker  string `xml:"NextMarker"`
+}
+
+// Iter calls f for each entry in the given directory (not recursive). The argument to f is the full
+// object name including the prefix of the inspected directory.
+func (b *Bucket) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	if dir != "" {
+		dir = strings.TrimSuffix(dir, objstore.DirDelim) + objstore.DirDelim
+	}
+	query := url.Values{"prefix": {dir}, "max-keys": {"1000"}}
+	if !objstore.ApplyIterOptions(options...).Recursive {
+		query.Set("delimiter", objstore.DirDelim)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		resp, err := b.request(ctx, http.MethodGet, "", nil, query, nil, nil, 0)
+		if err != nil {
+			return errors.Wrapf(err, "list objects of %s", dir)
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		runutil.ExhaustCloseWithLogOnErr(b.logger, resp.Body, "OBS list response")
+		if err != nil {
+			return errors.Wrapf(err, "decode listing of %s", dir)
+		}
+
+		for _, object := range result.Contents {
+			// Directories created by the OBS console are listed as empty objects.
+			if object.Key == dir {
+				continue
+			}
+			if err := f(object.Key); err != nil {
+				return err
+			}
+		}
+		for _, prefix := range result.CommonPrefixes {
+			if err := f(prefix.Prefix); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated {
+			return nil
+		}
+
+		// The next marker is not returned for listings without delimiter, which continue after the last object.
+		marker := result.NextMarker
+		if marker == "" && len(result.Contents) > 0 {
+			marker = result.Contents[len(result.Contents)-1].Key
+		}
+		if marker == "" {
+			return errors.Errorf("truncated listing of %s without marker", dir)
+		}
+		query.Set("marker", marker)
+	}
+}
+
+func (b *Bucket) getRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	if name == "" {
+		return nil, errors.New("object name cannot be empty")
+	}
+	header := http.Header{}
+	switch {
+	case length == 0:
+		// An empty range is not a valid Range header.
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	case length > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+length-1))
+	case off > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-", off))
+	}
+	resp, err := b.request(ctx, http.MethodGet, name, nil, nil, header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Get returns a reader for the given object name.
+func (b *Bucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.getRange(ctx, name, 0, -1)
+}
+
+// GetRange returns a new range reader for the given object name and range.
+func (b *Bucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	return b.getRange(ctx, name, off, length)
+}
+
+// Exists checks if the given object exists in the bucket.
+func (b *Bucket) Exists(ctx context.Context, name string) (bool, error) {
+	if _, err := b.Attributes(ctx, name); err != nil {
+		if b.IsObjNotFoundErr(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "getting object metadata of %s", name)
+	}
+	return true, nil
+}
+
+// Attributes returns information about the specified object.
+func (b *Bucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	resp, err := b.request(ctx, http.MethodHead, name, nil, nil, nil, nil, 0)
+	if err != nil {
+		return objstore.ObjectAttributes{}, err
+	}
+	runutil.ExhaustCloseWithLogOnErr(b.logger, resp.Body, "OBS head response")
+
+	size, err := exthttp.ParseContentLength(resp.Header)
+	if err != nil {
+		return objstore.ObjectAttributes{}, err
+	}
+	lastModified, err := exthttp.ParseLastModified(resp.Header, http.TimeFormat)
+	if err != nil {
+		return objstore.ObjectAttributes{}, err
+	}
+	return objstore.ObjectAttributes{Size: size, LastModified: lastModified}, nil
+}
+
+// Upload the contents of the reader as an object into the bucket. Objects larger than the part size are uploaded
+// with multipart uploads.
+func (b *Bucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	buf := make([]byte, b.conf.PartSize)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		resp, err := b.request(ctx, http.MethodPut, name, nil, nil, nil, bytes.NewReader(buf[:n]), int64(n))
+		if err != nil {
+			return errors.Wrapf(err, "upload object %s", name)
+		}
+		runutil.ExhaustCloseWithLogOnErr(b.logger, resp.Body, "OBS upload response")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "read object %s", name)
+	}
+
+	uploadID, err := b.initiateMultipartUpload(ctx, name)
+	if err != nil {
+		return errors.Wrapf(err, "initiate multipart upload of %s", name)
+	}
+	if err := b.uploadParts(ctx, name, uploadID, buf, r); err != nil {
+		// Parts of aborted uploads are deleted, as they are billed otherwise.
+		if resp, aerr := b.request(context.Background(), http.MethodDelete, name, url.Values{"uploadId": {uploadID}}, nil, nil, nil, 0); aerr == nil {
+			runutil.ExhaustCloseWithLogOnErr(b.logger, resp.Body, "OBS abort response")
+		}
+		return errors.Wrapf(err, "multipart upload of %s", name)
+	}
+	return nil
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []part   `xml:"Part"`
+}
+
+type part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (b *Bucket) initiateMultipartUpload(ctx context.Context, name string) (string, error) {
+	resp, err := b.request(ctx, http.MethodPost, name, url.Values{"uploads": {""}}, nil, nil, nil, 0)
+	if err != nil {
+		return "", err
+	}
+	defer runutil.ExhaustCloseWithLogOnErr(b.logger, resp.Body, "OBS initiate multipart upload response")
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", errors.Wrap(err, "decode initiate multipart upload response")
+	}
+	return result.UploadID, nil
+}
+
+// uploadParts uploads the first full part in buf, and the remaining parts read from r, with up to the configured
+// number of parts uploaded in parallel.
+func (b *Bucket) uploadParts(ctx context.Context, name, uploadID string, buf []byte, r io.Reader) error {
+	g, gctx := errgroup.WithContext(ctx)
+	sem := make(chan struct{}, b.conf.UploadConcurrency)
+	var (
+		mtx   sync.Mutex
+		etags = map[int]string{}
+		parts int
+	)
+	for partNumber := 1; buf != nil; partNumber++ {
+		select {
+		case sem <- struct{}{}:
+		case <-gctx.Done():
+			return g.Wait()
+		}
+		partNumber, body := partNumber, buf
+		g.Go(func() error {
+			defer func() { <-sem }()
+			resp, err := b.request(gctx, http.MethodPut, name, url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadID}}, nil, nil, bytes.NewReader(body), int64(len(body)))
+			if err != nil {
+				return errors.Wrapf(err, "upload part %d", partNumber)
+			}
+			runutil.ExhaustCloseWithLogOnErr(b.logger, resp.Body, "OBS upload part response")
+			mtx.Lock()
+			etags[partNumber] = resp.Header.Get("ETag")
+			mtx.Unlock()
+			return nil
+		})
+		parts = partNumber
+
+		buf = make([]byte, b.conf.PartSize)
+		n, err := io.ReadFull(r, buf)
+		switch {
+		case err == io.EOF:
+			buf = nil
+		case err == io.ErrUnexpectedEOF:
+			buf = buf[:n]
+		case err != nil:
+			_ = g.Wait()
+			return errors.Wrapf(err, "read part %d", partNumber+1)
+		}
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	complete := completeMultipartUpload{}
+	for partNumber := 1; partNumber <= parts; partNumber++ {
+		complete.Parts = append(complete.Parts, part{PartNumber: partNumber, ETag: etags[partNumber]})
+	}
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return errors.Wrap(err, "marshal complete multipart upload request")
+	}
+	resp, err := b.request(ctx, http.MethodPost, name, url.Values{"uploadId": {uploadID}}, nil, http.Header{"Content-Type": {"application/xml"}}, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return errors.Wrap(err, "complete multipart upload")
+	}
+	runutil.ExhaustCloseWithLogOnErr(b.logger, resp.Body, "OBS complete multipart upload response")
+	return nil
+}
+
+// Delete removes the object with the given name.
+func (b *Bucket) Delete(ctx context.Context, name string) error {
+	resp, err := b.request(ctx, http.MethodDelete, name, nil, nil, nil, nil, 0)
+	if err != nil {
+		return errors.Wrapf(err, "delete object %s", name)
+	}
+	runutil.ExhaustCloseWithLogOnErr(b.logger, resp.Body, "OBS delete response")
+	return nil
+}
+
+// IsObjNotFoundErr returns true if error means that object is not found. Relevant to Get operations.
+func (b *Bucket) IsObjNotFoundErr(err error) bool {
+	var obsErr *Error
+	if !errors.As(err, &obsErr) {
+		return false
+	}
+	return obsErr.Code == "NoSuchKey" || (obsErr.Code == "" && obsErr.StatusCode == http.StatusNotFound)
+}
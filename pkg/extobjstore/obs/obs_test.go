@@ -0,0 +1,244 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package obs
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/thanos-io/objstore"
+)
+
+// fakeOBS serves the object API of OBS for the bucket test, with listings of at most two entries per page.
+type fakeOBS struct {
+	mtx     sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+	parts   int
+}
+
+func newFakeOBS() *fakeOBS {
+	return &fakeOBS{objects: map[string][]byte{}, uploads: map[string]map[int][]byte{}}
+}
+
+func (f *fakeOBS) error(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+	}{Code: code})
+}
+
+func (f *fakeOBS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Host != "test.obs.example.com" || !strings.HasPrefix(r.Header.Get("Authorization"), "OBS ak:") || r.Header.Get("x-obs-security-token") != "token" {
+		f.error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	query := r.URL.Query()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		f.error(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, query.Get("prefix"), query.Get("delimiter"), query.Get("marker"))
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id := strconv.Itoa(len(f.uploads))
+		f.uploads[id] = map[int][]byte{}
+		_, _ = fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		f.uploads[query.Get("uploadId")][partNumber] = body
+		f.parts++
+		w.Header().Set("ETag", fmt.Sprintf("\"%d\"", partNumber))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		var complete completeMultipartUpload
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			f.error(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		var content []byte
+		for i, p := range complete.Parts {
+			if p.PartNumber != i+1 || p.ETag != fmt.Sprintf("\"%d\"", p.PartNumber) {
+				f.error(w, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			content = append(content, f.uploads[query.Get("uploadId")][p.PartNumber]...)
+		}
+		delete(f.uploads, query.Get("uploadId"))
+		f.objects[key] = content
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		content, ok := f.objects[key]
+		if !ok {
+			f.error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if rng := r.Header.Get("Range"); rng != "" {
+			var start, end int
+			bounds := strings.SplitN(strings.TrimPrefix(rng, "bytes="), "-", 2)
+			start, _ = strconv.Atoi(bounds[0])
+			end = len(content) - 1
+			if bounds[1] != "" {
+				end, _ = strconv.Atoi(bounds[1])
+			}
+			if end >= len(content) {
+				end = len(content) - 1
+			}
+			content = content[start : end+1]
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.WriteHeader(http.StatusPartialContent)
+		} else {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		}
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(content)
+		}
+	default:
+		f.error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (f *fakeOBS) list(w http.ResponseWriter, prefix, delimiter, marker string) {
+	keys := make([]string, 0, len(f.objects))
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var result listBucketResult
+	var last string
+	for _, k := range keys {
+		if !strings.HasPrefix(k, prefix) || k <= marker {
+			continue
+		}
+		entry := k
+		if i := strings.Index(k[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			entry = k[:len(prefix)+i+1]
+		}
+		if entry <= marker || entry == last {
+			continue
+		}
+		if len(result.Contents)+len(result.CommonPrefixes) == 2 {
+			result.IsTruncated = true
+			break
+		}
+		if entry == k {
+			result.Contents = append(result.Contents, struct {
+				Key string `xml:"Key"`
+			}{Key: k})
+		} else {
+			result.CommonPrefixes = append(result.CommonPrefixes, struct {
+				Prefix string `xml:"Prefix"`
+			}{Prefix: entry})
+		}
+		last = entry
+	}
+	// As OBS, the next marker is only returned for listings with delimiter.
+	if result.IsTruncated && delimiter != "" {
+		result.NextMarker = last
+	}
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+func newTestBucket(t *testing.T, fake *fakeOBS) *Bucket {
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	// The virtual hosted URLs of the bucket are sent to the test server.
+	transport := &http.Transport{DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+	}}
+	conf := DefaultConfig
+	conf.Bucket = "test"
+	conf.Endpoint = "obs.example.com"
+	conf.AccessKey = "ak"
+	conf.SecretKey = "sk"
+	conf.SecurityToken = "token"
+	conf.Insecure = true
+	conf.PartSize = 16 * 1024 * 1024
+	conf.HTTPConfig.Transport = transport
+
+	bkt, err := NewBucketWithConfig(log.NewNopLogger(), conf, "test")
+	testutil.Ok(t, err)
+	return bkt
+}
+
+func TestBucket_Acceptance(t *testing.T) {
+	fake := newFakeOBS()
+	bkt := newTestBucket(t, fake)
+	objstore.AcceptanceTest(t, bkt)
+
+	// The last object of the acceptance test is uploaded in parts.
+	testutil.Equals(t, 13, fake.parts)
+	testutil.Equals(t, 0, len(fake.uploads))
+}
+
+func TestBucket_Upload(t *testing.T) {
+	fake := newFakeOBS()
+	bkt := newTestBucket(t, fake)
+	ctx := context.Background()
+
+	// Objects of exactly the part size are uploaded in a single part.
+	content := bytes.Repeat([]byte("a"), int(bkt.conf.PartSize))
+	testutil.Ok(t, bkt.Upload(ctx, "part", bytes.NewReader(content)))
+	testutil.Equals(t, 1, fake.parts)
+	testutil.Equals(t, content, fake.objects["part"])
+
+	// Objects smaller than the part size are uploaded without multipart upload.
+	testutil.Ok(t, bkt.Upload(ctx, "small", strings.NewReader("small")))
+	testutil.Equals(t, 1, fake.parts)
+	testutil.Equals(t, "small", string(fake.objects["small"]))
+}
+
+func TestBucket_Errors(t *testing.T) {
+	bkt := newTestBucket(t, newFakeOBS())
+	ctx := context.Background()
+
+	_, err := bkt.Get(ctx, "missing")
+	testutil.NotOk(t, err)
+	testutil.Assert(t, bkt.IsObjNotFoundErr(err))
+	_, err = bkt.Attributes(ctx, "missing")
+	testutil.Assert(t, bkt.IsObjNotFoundErr(err))
+
+	bkt.conf.SecurityToken = "expired"
+	_, err = bkt.Get(ctx, "missing")
+	testutil.NotOk(t, err)
+	testutil.Assert(t, !bkt.IsObjNotFoundErr(err))
+
+	for _, conf := range []string{
+		"endpoint: obs.example.com",
+		"bucket: test",
+		"bucket: test\nendpoint: obs.example.com\naccess_key: ak",
+		"bucket: test\nendpoint: obs.example.com\npart_size: 1024",
+	} {
+		_, err := NewBucket(log.NewNopLogger(), []byte(conf), "test")
+		testutil.NotOk(t, err)
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"context"
+	"io"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+	"golang.org/x/time/rate"
+
+	"github.com/thanos-io/thanos/pkg/model"
+)
+
+const (
+	opRead  = "read"
+	opWrite = "write"
+)
+
+// RateLimitsConfig is the configuration of the rate limits of bucket operations, in the rate_limits section of the
+// bucket configuration. Reads are Iter, Get, GetRange, Exists and Attributes operations, and writes are Upload and
+// Delete operations.
+type RateLimitsConfig struct {
+	Read  RateLimits `yaml:"read"`
+	Write RateLimits `yaml:"write"`
+}
+
+// RateLimits are the limits of operations per second and bytes per second. 0 means no limit.
+type RateLimits struct {
+	OpsPerSecond   float64     `yaml:"ops_per_second"`
+	BytesPerSecond model.Bytes `yaml:"bytes_per_second"`
+}
+
+type limiter struct {
+	ops, bytes *rate.Limiter
+	// throttled is nil if the time waited for the limits is not tracked.
+	throttled *prometheus.CounterVec
+	op        string
+}
+
+func newLimiter(l RateLimits, throttled *prometheus.CounterVec, op string) *limiter {
+	lim := &limiter{throttled: throttled, op: op}
+	if l.OpsPerSecond > 0 {
+		// Bursts of up to a second worth of operations are allowed.
+		lim.ops = rate.NewLimiter(rate.Limit(l.OpsPerSecond), int(math.Max(1, math.Ceil(l.OpsPerSecond))))
+	}
+	if l.BytesPerSecond > 0 {
+		// At most a second worth of bytes are transferred at once.
+		burst := uint64(l.BytesPerSecond)
+		if burst > math.MaxInt32 {
+			burst = math.MaxInt32
+		}
+		lim.bytes = rate.NewLimiter(rate.Limit(l.BytesPerSecond), int(burst))
+	}
+	if throttled != nil {
+		throttled.WithLabelValues(op, "ops")
+		throttled.WithLabelValues(op, "bytes")
+	}
+	return lim
+}
+
+func (l *limiter) waitOp(ctx context.Context) error {
+	if l.ops == nil {
+		return nil
+	}
+	start := time.Now()
+	err := l.ops.Wait(ctx)
+	l.observeThrottled("ops", start)
+	return err
+}
+
+func (l *limiter) waitBytes(ctx context.Context, n int) error {
+	start := time.Now()
+	err := l.bytes.WaitN(ctx, n)
+	l.observeThrottled("bytes", start)
+	return err
+}
+
+func (l *limiter) observeThrottled(limit string, start time.Time) {
+	if l.throttled != nil {
+		l.throttled.WithLabelValues(l.op, limit).Add(time.Since(start).Seconds())
+	}
+}
+
+func (l *limiter) reader(ctx context.Context, r io.Reader) io.Reader {
+	if l.bytes == nil {
+		return r
+	}
+	lr := rateLimitedReader{ctx: ctx, r: r, l: l}
+	// Readers of files stay io.ReaderAt, so that providers can still upload, or resume, their parts in parallel.
+	if ra, ok := r.(io.ReaderAt); ok {
+		return &rateLimitedReaderAt{rateLimitedReader: lr, ra: ra}
+	}
+	return &lr
+}
+
+func (l *limiter) readCloser(ctx context.Context, rc io.ReadCloser) io.ReadCloser {
+	if l.bytes == nil {
+		return rc
+	}
+	return &rateLimitedReadCloser{rateLimitedReader: rateLimitedReader{ctx: ctx, r: rc, l: l}, Closer: rc}
+}
+
+type limiters struct {
+	read, write *limiter
+}
+
+// WrapWithRateLimits returns a bucket limiting the operations and bytes per second of reads and writes against
+// bkt. Limits are shared by all concurrent operations.
+func WrapWithRateLimits(bkt objstore.InstrumentedBucket, conf RateLimitsConfig, reg prometheus.Registerer) objstore.InstrumentedBucket {
+	throttled := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name:        "thanos_objstore_bucket_rate_limited_seconds_total",
+		Help:        "Total time operations against the bucket waited for the rate limits, by operation type and limit.",
+		ConstLabels: prometheus.Labels{"bucket": bkt.Name()},
+	}, []string{"operation", "limit"})
+	l := &limiters{
+		read:  newLimiter(conf.Read, throttled, opRead),
+		write: newLimiter(conf.Write, throttled, opWrite),
+	}
+	return &rateLimitedInstrumentedBucket{rateLimitedBucket: rateLimitedBucket{Bucket: bkt, l: l}, ib: bkt}
+}
+
+// WrapWithUploadBandwidthLimit returns a bucket limiting the bytes per second uploaded to bkt, across all concurrent
+// uploads. Unlike the rate limits of the bucket configuration, it is meant for the uploads of a single component, e.g.
+// so that replicating blocks does not saturate the network.
+func WrapWithUploadBandwidthLimit(bkt objstore.Bucket, bytesPerSecond int64) objstore.Bucket {
+	return &rateLimitedBucket{Bucket: bkt, l: &limiters{
+		read:  newLimiter(RateLimits{}, nil, opRead),
+		write: newLimiter(RateLimits{BytesPerSecond: model.Bytes(bytesPerSecond)}, nil, opWrite),
+	}}
+}
+
+type rateLimitedInstrumentedBucket struct {
+	rateLimitedBucket
+	ib objstore.InstrumentedBucket
+}
+
+func (b *rateLimitedInstrumentedBucket) WithExpectedErrs(f objstore.IsOpFailureExpectedFunc) objstore.Bucket {
+	return &rateLimitedBucket{Bucket: b.ib.WithExpectedErrs(f), l: b.l}
+}
+
+func (b *rateLimitedInstrumentedBucket) ReaderWithExpectedErrs(f objstore.IsOpFailureExpectedFunc) objstore.BucketReader {
+	return &rateLimitedBucketReader{BucketReader: b.ib.ReaderWithExpectedErrs(f), l: b.l}
+}
+
+type rateLimitedBucket struct {
+	objstore.Bucket
+	l *limiters
+}
+
+func (b *rateLimitedBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	if err := b.l.write.waitOp(ctx); err != nil {
+		return err
+	}
+	return b.Bucket.Upload(ctx, name, b.l.write.reader(ctx, r))
+}
+
+func (b *rateLimitedBucket) Delete(ctx context.Context, name string) error {
+	if err := b.l.write.waitOp(ctx); err != nil {
+		return err
+	}
+	return b.Bucket.Delete(ctx, name)
+}
+
+func (b *rateLimitedBucket) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	return (&rateLimitedBucketReader{BucketReader: b.Bucket, l: b.l}).Iter(ctx, dir, f, options...)
+}
+
+func (b *rateLimitedBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return (&rateLimitedBucketReader{BucketReader: b.Bucket, l: b.l}).Get(ctx, name)
+}
+
+func (b *rateLimitedBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	return (&rateLimitedBucketReader{BucketReader: b.Bucket, l: b.l}).GetRange(ctx, name, off, length)
+}
+
+func (b *rateLimitedBucket) Exists(ctx context.Context, name string) (bool, error) {
+	return (&rateLimitedBucketReader{BucketReader: b.Bucket, l: b.l}).Exists(ctx, name)
+}
+
+func (b *rateLimitedBucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	return (&rateLimitedBucketReader{BucketReader: b.Bucket, l: b.l}).Attributes(ctx, name)
+}
+
+type rateLimitedBucketReader struct {
+	objstore.BucketReader
+	l *limiters
+}
+
+func (b *rateLimitedBucketReader) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	if err := b.l.read.waitOp(ctx); err != nil {
+		return err
+	}
+	return b.BucketReader.Iter(ctx, dir, f, options...)
+}
+
+func (b *rateLimitedBucketReader) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := b.l.read.waitOp(ctx); err != nil {
+		return nil, err
+	}
+	rc, err := b.BucketReader.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return b.l.read.readCloser(ctx, rc), nil
+}
+
+func (b *rateLimitedBucketReader) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	if err := b.l.read.waitOp(ctx); err != nil {
+		return nil, err
+	}
+	rc, err := b.BucketReader.GetRange(ctx, name, off, length)
+	if err != nil {
+		return nil, err
+	}
+	return b.l.read.readCloser(ctx, rc), nil
+}
+
+func (b *rateLimitedBucketReader) Exists(ctx context.Context, name string) (bool, error) {
+	if err := b.l.read.waitOp(ctx); err != nil {
+		return false, err
+	}
+	return b.BucketReader.Exists(ctx, name)
+}
+
+func (b *rateLimitedBucketReader) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	if err := b.l.read.waitOp(ctx); err != nil {
+		return objstore.ObjectAttributes{}, err
+	}
+	return b.BucketReader.Attributes(ctx, name)
+}
+
+type rateLimitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *limiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > r.l.bytes.Burst() {
+		p = p[:r.l.bytes.Burst()]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.l.waitBytes(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// ObjectSize returns the size of the underlying reader, as some providers need it upfront.
+func (r *rateLimitedReader) ObjectSize() (int64, error) {
+	return objstore.TryToGetSize(r.r)
+}
+
+// rateLimitedReaderAt is the rateLimitedReader of readers, the parts of which providers upload in parallel.
+type rateLimitedReaderAt struct {
+	rateLimitedReader
+	ra io.ReaderAt
+}
+
+func (r *rateLimitedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	read := 0
+	for read < len(p) {
+		chunk := p[read:]
+		if len(chunk) > r.l.bytes.Burst() {
+			chunk = chunk[:r.l.bytes.Burst()]
+		}
+		n, err := r.ra.ReadAt(chunk, off+int64(read))
+		read += n
+		if n > 0 {
+			if werr := r.l.waitBytes(r.ctx, n); werr != nil {
+				return read, werr
+			}
+		}
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+type rateLimitedReadCloser struct {
+	rateLimitedReader
+	io.Closer
+}
@@ -0,0 +1,129 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/thanos-io/objstore"
+)
+
+func TestRateLimitedBucket_Acceptance(t *testing.T) {
+	objstore.AcceptanceTest(t, WrapWithRateLimits(objstore.WithNoopInstr(objstore.NewInMemBucket()), RateLimitsConfig{
+		Read:  RateLimits{OpsPerSecond: 10000, BytesPerSecond: 1 << 30},
+		Write: RateLimits{OpsPerSecond: 10000, BytesPerSecond: 1 << 30},
+	}, nil))
+}
+
+func TestRateLimitedBucket(t *testing.T) {
+	ctx := context.Background()
+	reg := prometheus.NewRegistry()
+	bkt := WrapWithRateLimits(objstore.WithNoopInstr(objstore.NewInMemBucket()), RateLimitsConfig{
+		Read:  RateLimits{OpsPerSecond: 2},
+		Write: RateLimits{BytesPerSecond: 100},
+	}, reg)
+	throttled := func(op, limit string) float64 {
+		return promtestutil.ToFloat64(bkt.(*rateLimitedInstrumentedBucket).l.read.throttled.WithLabelValues(op, limit))
+	}
+
+	// The first 100 bytes are uploaded at once, the other 50 bytes after half a second.
+	content := strings.Repeat("a", 150)
+	start := time.Now()
+	testutil.Ok(t, bkt.Upload(ctx, "a", strings.NewReader(content)))
+	testutil.Assert(t, time.Since(start) >= 400*time.Millisecond, "upload took %v", time.Since(start))
+	testutil.Assert(t, throttled(opWrite, "bytes") > 0)
+	testutil.Equals(t, 0.0, throttled(opWrite, "ops"))
+
+	// The first 2 reads are done at once, the third one after half a second.
+	start = time.Now()
+	for i := 0; i < 2; i++ {
+		_, err := bkt.Exists(ctx, "a")
+		testutil.Ok(t, err)
+	}
+	testutil.Assert(t, time.Since(start) < 400*time.Millisecond, "reads took %v", time.Since(start))
+	r, err := bkt.Get(ctx, "a")
+	testutil.Ok(t, err)
+	testutil.Assert(t, time.Since(start) >= 400*time.Millisecond, "reads took %v", time.Since(start))
+	b, err := io.ReadAll(r)
+	testutil.Ok(t, err)
+	testutil.Equals(t, content, string(b))
+	testutil.Assert(t, throttled(opRead, "ops") > 0)
+
+	// Operations stop once the context is canceled.
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	testutil.NotOk(t, bkt.Upload(cctx, "b", strings.NewReader(content)))
+	_, err = bkt.Exists(cctx, "a")
+	testutil.NotOk(t, err)
+}
+
+func TestWrapWithUploadBandwidthLimit(t *testing.T) {
+	ctx := context.Background()
+	inmem := objstore.NewInMemBucket()
+	bkt := WrapWithUploadBandwidthLimit(inmem, 100)
+
+	// The first 100 bytes are uploaded at once, the other 50 bytes after half a second.
+	content := strings.Repeat("a", 150)
+	start := time.Now()
+	testutil.Ok(t, bkt.Upload(ctx, "a", strings.NewReader(content)))
+	testutil.Assert(t, time.Since(start) >= 400*time.Millisecond, "upload took %v", time.Since(start))
+	testutil.Equals(t, content, string(inmem.Objects()["a"]))
+
+	// Reads are not limited.
+	start = time.Now()
+	for i := 0; i < 10; i++ {
+		_, err := bkt.Exists(ctx, "a")
+		testutil.Ok(t, err)
+	}
+	testutil.Assert(t, time.Since(start) < 400*time.Millisecond, "reads took %v", time.Since(start))
+
+	// Uploads stop once the context is canceled.
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	testutil.NotOk(t, bkt.Upload(cctx, "b", strings.NewReader(content)))
+}
+
+func TestWrapWithUploadBandwidthLimit_ReaderAt(t *testing.T) {
+	ctx := context.Background()
+	captured := &readerCapturingBucket{Bucket: objstore.NewInMemBucket()}
+	bkt := WrapWithUploadBandwidthLimit(captured, 100)
+
+	// Readers of files keep their size and stay io.ReaderAt, so that their parts can be uploaded in parallel.
+	content := []byte(strings.Repeat("a", 50) + strings.Repeat("b", 100))
+	testutil.Ok(t, bkt.Upload(ctx, "a", bytes.NewReader(content)))
+	testutil.Ok(t, captured.sizeErr)
+	testutil.Equals(t, int64(len(content)), captured.size)
+
+	ra, ok := captured.r.(io.ReaderAt)
+	testutil.Assert(t, ok, "expected io.ReaderAt, got %T", captured.r)
+	b := make([]byte, 120)
+	start := time.Now()
+	n, err := ra.ReadAt(b, 30)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 120, n)
+	testutil.Equals(t, content[30:], b)
+	testutil.Assert(t, time.Since(start) >= 400*time.Millisecond, "read took %v", time.Since(start))
+}
+
+// readerCapturingBucket keeps the reader of the last upload and its size.
+type readerCapturingBucket struct {
+	objstore.Bucket
+	r       io.Reader
+	size    int64
+	sizeErr error
+}
+
+func (b *readerCapturingBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	b.r = r
+	b.size, b.sizeErr = objstore.TryToGetSize(r)
+	return b.Bucket.Upload(ctx, name, r)
+}
@@ -0,0 +1,334 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/jpillora/backoff"
+	"github.com/minio/minio-go/v7"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/objstore"
+	"google.golang.org/api/googleapi"
+)
+
+// RetryConfig is the configuration of the retries of failed bucket operations, in the retry section of the bucket
+// configuration. It applies the same policy to all providers, on top of the retries of their clients.
+type RetryConfig struct {
+	// MaxRetries is the maximum number of retries of a failed operation. 0 disables retries.
+	MaxRetries int `yaml:"max_retries"`
+	// MinBackoff and MaxBackoff bound the exponential backoff between retries.
+	MinBackoff model.Duration `yaml:"min_backoff"`
+	MaxBackoff model.Duration `yaml:"max_backoff"`
+	// RetryableStatusCodes are the HTTP status codes of the provider responses that are retried. Errors without
+	// status code, e.g. connection errors, are always retried.
+	RetryableStatusCodes []int `yaml:"retryable_status_codes"`
+	// Timeout is the timeout of an operation, including its retries. 0 means no timeout.
+	Timeout model.Duration `yaml:"timeout"`
+}
+
+// DefaultRetryConfig returns the retry configuration with the default values set.
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{
+		MaxRetries: 3,
+		MinBackoff: model.Duration(100 * time.Millisecond),
+		MaxBackoff: model.Duration(10 * time.Second),
+		RetryableStatusCodes: []int{
+			http.StatusRequestTimeout,
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *RetryConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultRetryConfig()
+	type plain RetryConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.MaxRetries < 0 {
+		return errors.New("max_retries must not be negative")
+	}
+	if c.MinBackoff > c.MaxBackoff {
+		return errors.New("min_backoff must not be greater than max_backoff")
+	}
+	return nil
+}
+
+// WrapWithRetries returns a bucket retrying failed operations against bkt with exponential backoff. Operations
+// failing as the object was not found, or as their context was canceled, are not retried. Uploads are retried only
+// if the reader can be rewound, and listings only if no object was listed yet.
+func WrapWithRetries(bkt objstore.InstrumentedBucket, conf RetryConfig, reg prometheus.Registerer) objstore.InstrumentedBucket {
+	retries := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name:        "thanos_objstore_bucket_operation_retries_total",
+		Help:        "Total number of retries of failed operations against the bucket.",
+		ConstLabels: prometheus.Labels{"bucket": bkt.Name()},
+	}, []string{"operation"})
+	for _, op := range []string{objstore.OpIter, objstore.OpGet, objstore.OpGetRange, objstore.OpExists, objstore.OpUpload, objstore.OpDelete, objstore.OpAttributes} {
+		retries.WithLabelValues(op)
+	}
+	r := &retrier{conf: conf, codes: map[int]struct{}{}, retries: retries}
+	for _, code := range conf.RetryableStatusCodes {
+		r.codes[code] = struct{}{}
+	}
+	return &retryingInstrumentedBucket{retryingBucket: retryingBucket{Bucket: bkt, r: r}, ib: bkt}
+}
+
+type retrier struct {
+	conf    RetryConfig
+	codes   map[int]struct{}
+	retries *prometheus.CounterVec
+}
+
+// permanentError is an error of an operation that must not be retried.
+type permanentError struct {
+	error
+}
+
+// statusCode returns the HTTP status code of the response of the provider the error is for, if any.
+func statusCode(err error) (int, bool) {
+	if errors.Is(err, ErrThrottled) {
+		return http.StatusTooManyRequests, true
+	}
+	var minioErr minio.ErrorResponse
+	if errors.As(err, &minioErr) && minioErr.StatusCode != 0 {
+		return minioErr.StatusCode, true
+	}
+	var gcsErr *googleapi.Error
+	if errors.As(err, &gcsErr) {
+		return gcsErr.Code, true
+	}
+	var azureErr *azcore.ResponseError
+	if errors.As(err, &azureErr) {
+		return azureErr.StatusCode, true
+	}
+	return 0, false
+}
+
+func (r *retrier) retryable(ctx context.Context, err error, isObjNotFound func(error) bool) bool {
+	if ctx.Err() != nil || isObjNotFound(err) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	code, ok := statusCode(err)
+	if !ok {
+		return true
+	}
+	_, ok = r.codes[code]
+	return ok
+}
+
+// do calls f until it succeeds, fails with an error that is not retryable, or the maximum number of retries is reached.
+func (r *retrier) do(ctx context.Context, op string, isObjNotFound func(error) bool, f func(context.Context) error) error {
+	b := backoff.Backoff{
+		Factor: 2,
+		Min:    time.Duration(r.conf.MinBackoff),
+		Max:    time.Duration(r.conf.MaxBackoff),
+		Jitter: true,
+	}
+	for {
+		err := f(ctx)
+		if perr, ok := err.(permanentError); ok {
+			return perr.error
+		}
+		if err == nil || int(b.Attempt()) >= r.conf.MaxRetries || !r.retryable(ctx, err, isObjNotFound) {
+			return err
+		}
+		r.retries.WithLabelValues(op).Inc()
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(b.Duration()):
+		}
+	}
+}
+
+// run is like do, with the configured timeout applied to the operation.
+func (r *retrier) run(ctx context.Context, op string, isObjNotFound func(error) bool, f func(context.Context) error) error {
+	if r.conf.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(r.conf.Timeout))
+		defer cancel()
+	}
+	return r.do(ctx, op, isObjNotFound, f)
+}
+
+// get is like run for operations returning a reader of the object. The timeout applies until the reader is returned,
+// and its context is canceled once the reader is closed.
+func (r *retrier) get(ctx context.Context, op string, isObjNotFound func(error) bool, f func(context.Context) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := func() bool { return true }
+	if r.conf.Timeout > 0 {
+		stop = time.AfterFunc(time.Duration(r.conf.Timeout), cancel).Stop
+	}
+
+	var rc io.ReadCloser
+	err := r.do(ctx, op, isObjNotFound, func(ctx context.Context) (err error) {
+		rc, err = f(ctx)
+		return err
+	})
+	if !stop() {
+		if err == nil {
+			_ = rc.Close()
+		}
+		cancel()
+		return nil, errors.Errorf("%s operation exceeded timeout of %s", op, r.conf.Timeout)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &cancelOnCloseReader{ReadCloser: rc, cancel: cancel}, nil
+}
+
+type cancelOnCloseReader struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelOnCloseReader) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
+
+type retryingInstrumentedBucket struct {
+	retryingBucket
+	ib objstore.InstrumentedBucket
+}
+
+func (b *retryingInstrumentedBucket) WithExpectedErrs(f objstore.IsOpFailureExpectedFunc) objstore.Bucket {
+	return &retryingBucket{Bucket: b.ib.WithExpectedErrs(f), r: b.r}
+}
+
+func (b *retryingInstrumentedBucket) ReaderWithExpectedErrs(f objstore.IsOpFailureExpectedFunc) objstore.BucketReader {
+	return &retryingBucketReader{BucketReader: b.ib.ReaderWithExpectedErrs(f), r: b.r}
+}
+
+type retryingBucket struct {
+	objstore.Bucket
+	r *retrier
+}
+
+// Upload uploads the object, retrying failed uploads only if the reader can be rewound to upload the object again.
+func (b *retryingBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	s, ok := r.(io.Seeker)
+	var start int64
+	if ok {
+		var err error
+		if start, err = s.Seek(0, io.SeekCurrent); err != nil {
+			ok = false
+		}
+	}
+
+	attempt := 0
+	return b.r.run(ctx, objstore.OpUpload, b.IsObjNotFoundErr, func(ctx context.Context) error {
+		if attempt > 0 {
+			if _, err := s.Seek(start, io.SeekStart); err != nil {
+				return permanentError{errors.Wrap(err, "rewind object reader")}
+			}
+		}
+		attempt++
+		err := b.Bucket.Upload(ctx, name, r)
+		if err != nil && !ok {
+			return permanentError{err}
+		}
+		return err
+	})
+}
+
+// Delete deletes the object. Objects not found after the first attempt are deleted without error, as they were
+// deleted by a previous attempt.
+func (b *retryingBucket) Delete(ctx context.Context, name string) error {
+	attempt := 0
+	return b.r.run(ctx, objstore.OpDelete, func(error) bool { return false }, func(ctx context.Context) error {
+		attempt++
+		err := b.Bucket.Delete(ctx, name)
+		if b.IsObjNotFoundErr(err) {
+			if attempt > 1 {
+				return nil
+			}
+			return permanentError{err}
+		}
+		return err
+	})
+}
+
+func (b *retryingBucket) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	return (&retryingBucketReader{BucketReader: b.Bucket, r: b.r}).Iter(ctx, dir, f, options...)
+}
+
+func (b *retryingBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return (&retryingBucketReader{BucketReader: b.Bucket, r: b.r}).Get(ctx, name)
+}
+
+func (b *retryingBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	return (&retryingBucketReader{BucketReader: b.Bucket, r: b.r}).GetRange(ctx, name, off, length)
+}
+
+func (b *retryingBucket) Exists(ctx context.Context, name string) (bool, error) {
+	return (&retryingBucketReader{BucketReader: b.Bucket, r: b.r}).Exists(ctx, name)
+}
+
+func (b *retryingBucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	return (&retryingBucketReader{BucketReader: b.Bucket, r: b.r}).Attributes(ctx, name)
+}
+
+type retryingBucketReader struct {
+	objstore.BucketReader
+	r *retrier
+}
+
+// Iter lists the objects, retrying failed listings only if no object was listed yet.
+func (b *retryingBucketReader) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	return b.r.run(ctx, objstore.OpIter, b.IsObjNotFoundErr, func(ctx context.Context) error {
+		listed := false
+		err := b.BucketReader.Iter(ctx, dir, func(name string) error {
+			listed = true
+			return f(name)
+		}, options...)
+		if err != nil && listed {
+			return permanentError{err}
+		}
+		return err
+	})
+}
+
+func (b *retryingBucketReader) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.r.get(ctx, objstore.OpGet, b.IsObjNotFoundErr, func(ctx context.Context) (io.ReadCloser, error) {
+		return b.BucketReader.Get(ctx, name)
+	})
+}
+
+func (b *retryingBucketReader) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	return b.r.get(ctx, objstore.OpGetRange, b.IsObjNotFoundErr, func(ctx context.Context) (io.ReadCloser, error) {
+		return b.BucketReader.GetRange(ctx, name, off, length)
+	})
+}
+
+func (b *retryingBucketReader) Exists(ctx context.Context, name string) (ok bool, err error) {
+	err = b.r.run(ctx, objstore.OpExists, b.IsObjNotFoundErr, func(ctx context.Context) (err error) {
+		ok, err = b.BucketReader.Exists(ctx, name)
+		return err
+	})
+	return ok, err
+}
+
+func (b *retryingBucketReader) Attributes(ctx context.Context, name string) (attrs objstore.ObjectAttributes, err error) {
+	err = b.r.run(ctx, objstore.OpAttributes, b.IsObjNotFoundErr, func(ctx context.Context) (err error) {
+		attrs, err = b.BucketReader.Attributes(ctx, name)
+		return err
+	})
+	return attrs, err
+}
@@ -0,0 +1,199 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/minio/minio-go/v7"
+	"github.com/pkg/errors"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/objstore"
+	"gopkg.in/yaml.v2"
+)
+
+// failingBucket fails the first failures operations of each type with err.
+type failingBucket struct {
+	objstore.Bucket
+	failures int
+	err      error
+	calls    map[string]int
+}
+
+func (b *failingBucket) fail(op string) error {
+	b.calls[op]++
+	if b.calls[op] <= b.failures {
+		return b.err
+	}
+	return nil
+}
+
+func (b *failingBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	if err := b.fail(objstore.OpUpload); err != nil {
+		// Consume part of the object, as failed uploads do.
+		_, _ = r.Read(make([]byte, 1))
+		return err
+	}
+	return b.Bucket.Upload(ctx, name, r)
+}
+
+func (b *failingBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := b.fail(objstore.OpGet); err != nil {
+		return nil, err
+	}
+	return b.Bucket.Get(ctx, name)
+}
+
+func (b *failingBucket) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	if err := b.fail(objstore.OpIter); err != nil {
+		return err
+	}
+	return b.Bucket.Iter(ctx, dir, f, options...)
+}
+
+func (b *failingBucket) Delete(ctx context.Context, name string) error {
+	if err := b.Bucket.Delete(ctx, name); err != nil {
+		return err
+	}
+	// The object is deleted, but the response is lost.
+	return b.fail(objstore.OpDelete)
+}
+
+func testRetryConfig() RetryConfig {
+	conf := DefaultRetryConfig()
+	conf.MinBackoff = model.Duration(time.Millisecond)
+	conf.MaxBackoff = model.Duration(time.Millisecond)
+	return conf
+}
+
+func TestRetryConfig(t *testing.T) {
+	conf := &BucketConfig{}
+	testutil.Ok(t, yaml.UnmarshalStrict([]byte("type: FILESYSTEM\nretry:\n  max_retries: 5\n"), conf))
+	expected := DefaultRetryConfig()
+	expected.MaxRetries = 5
+	testutil.Equals(t, expected, *conf.Retry)
+
+	testutil.NotOk(t, yaml.UnmarshalStrict([]byte("type: FILESYSTEM\nretry:\n  min_backoff: 1m\n  max_backoff: 1s\n"), conf))
+}
+
+func TestWrapWithRetries_Acceptance(t *testing.T) {
+	objstore.AcceptanceTest(t, WrapWithRetries(objstore.WithNoopInstr(objstore.NewInMemBucket()), testRetryConfig(), nil))
+}
+
+func TestWrapWithRetries(t *testing.T) {
+	ctx := context.Background()
+	retryable := minio.ErrorResponse{StatusCode: http.StatusServiceUnavailable, Code: "SlowDown"}
+	notRetryable := minio.ErrorResponse{StatusCode: http.StatusForbidden, Code: "AccessDenied"}
+
+	for _, tcase := range []struct {
+		name          string
+		failures      int
+		err           error
+		expectedCalls int
+		ok            bool
+	}{
+		{name: "no failures", failures: 0, err: retryable, expectedCalls: 1, ok: true},
+		{name: "retryable status code", failures: 3, err: retryable, expectedCalls: 4, ok: true},
+		{name: "too many failures", failures: 4, err: retryable, expectedCalls: 4},
+		{name: "not retryable status code", failures: 1, err: notRetryable, expectedCalls: 1},
+		{name: "no status code", failures: 1, err: errors.New("connection reset"), expectedCalls: 2, ok: true},
+		{name: "canceled", failures: 1, err: errors.Wrap(context.Canceled, "get"), expectedCalls: 1},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			inmem := objstore.NewInMemBucket()
+			testutil.Ok(t, inmem.Upload(ctx, "dir/obj", strings.NewReader("content")))
+			failing := &failingBucket{Bucket: inmem, failures: tcase.failures, err: tcase.err, calls: map[string]int{}}
+			bkt := WrapWithRetries(objstore.WithNoopInstr(failing), testRetryConfig(), nil)
+
+			rc, err := bkt.Get(ctx, "dir/obj")
+			testutil.Equals(t, tcase.expectedCalls, failing.calls[objstore.OpGet])
+			testutil.Equals(t, tcase.ok, err == nil)
+			if tcase.ok {
+				content, err := io.ReadAll(rc)
+				testutil.Ok(t, err)
+				testutil.Ok(t, rc.Close())
+				testutil.Equals(t, "content", string(content))
+			}
+			testutil.Equals(t, float64(tcase.expectedCalls-1), promtest.ToFloat64(bkt.(*retryingInstrumentedBucket).r.retries.WithLabelValues(objstore.OpGet)))
+		})
+	}
+}
+
+func TestWrapWithRetries_Operations(t *testing.T) {
+	ctx := context.Background()
+	err := minio.ErrorResponse{StatusCode: http.StatusInternalServerError}
+	inmem := objstore.NewInMemBucket()
+	failing := &failingBucket{Bucket: inmem, failures: 1, err: err, calls: map[string]int{}}
+	bkt := WrapWithRetries(objstore.WithNoopInstr(failing), testRetryConfig(), nil)
+
+	// Uploads are retried from the start of the object.
+	testutil.Ok(t, bkt.Upload(ctx, "obj", strings.NewReader("content")))
+	testutil.Equals(t, 2, failing.calls[objstore.OpUpload])
+	r, err2 := inmem.Get(ctx, "obj")
+	testutil.Ok(t, err2)
+	content, err2 := io.ReadAll(r)
+	testutil.Ok(t, err2)
+	testutil.Equals(t, "content", string(content))
+
+	// Uploads of readers which cannot be rewound are not retried.
+	failing.calls[objstore.OpUpload] = 0
+	testutil.NotOk(t, bkt.Upload(ctx, "obj2", io.MultiReader(strings.NewReader("content"))))
+	testutil.Equals(t, 1, failing.calls[objstore.OpUpload])
+
+	var listed []string
+	testutil.Ok(t, bkt.Iter(ctx, "", func(name string) error {
+		listed = append(listed, name)
+		return nil
+	}))
+	testutil.Equals(t, []string{"obj"}, listed)
+	testutil.Equals(t, 2, failing.calls[objstore.OpIter])
+
+	// Objects deleted by a failed attempt are not found by the retry.
+	testutil.Ok(t, bkt.Delete(ctx, "obj"))
+	testutil.Equals(t, 1, failing.calls[objstore.OpDelete])
+	_, err2 = inmem.Get(ctx, "obj")
+	testutil.Assert(t, inmem.IsObjNotFoundErr(err2))
+
+	// Objects not found are not retried.
+	failing.failures = 0
+	_, err2 = bkt.Get(ctx, "obj")
+	testutil.Assert(t, bkt.IsObjNotFoundErr(err2))
+	testutil.Equals(t, 1, failing.calls[objstore.OpGet])
+}
+
+func TestWrapWithRetries_Timeout(t *testing.T) {
+	ctx := context.Background()
+	inmem := objstore.NewInMemBucket()
+	testutil.Ok(t, inmem.Upload(ctx, "obj", strings.NewReader("content")))
+	failing := &failingBucket{Bucket: inmem, failures: 100, err: errors.New("connection reset"), calls: map[string]int{}}
+
+	conf := DefaultRetryConfig()
+	conf.MaxRetries = 100
+	conf.MinBackoff = model.Duration(10 * time.Millisecond)
+	conf.MaxBackoff = model.Duration(10 * time.Millisecond)
+	conf.Timeout = model.Duration(50 * time.Millisecond)
+	bkt := WrapWithRetries(objstore.WithNoopInstr(failing), conf, nil)
+
+	start := time.Now()
+	_, err := bkt.Get(ctx, "obj")
+	testutil.NotOk(t, err)
+	testutil.Assert(t, time.Since(start) < time.Second, "operation was not stopped by the timeout")
+
+	// The timeout does not apply to reading objects.
+	failing.failures = 0
+	rc, err := bkt.Get(ctx, "obj")
+	testutil.Ok(t, err)
+	time.Sleep(100 * time.Millisecond)
+	content, err := io.ReadAll(rc)
+	testutil.Ok(t, err)
+	testutil.Ok(t, rc.Close())
+	testutil.Equals(t, "content", string(content))
+}
@@ -0,0 +1,242 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/client"
+	"github.com/thanos-io/objstore/exthttp"
+	"github.com/thanos-io/objstore/providers/s3"
+	"gopkg.in/yaml.v2"
+)
+
+// STSConfig is the configuration of the AWS STS roles S3 buckets are accessed with, in the sts section of the bucket
+// configuration. Temporary credentials of the role are refreshed before they expire.
+type STSConfig struct {
+	// RoleARN is the role assumed with AssumeRole, or with AssumeRoleWithWebIdentity if WebIdentityTokenFile is set.
+	// If both are empty, the role and token file of EKS IAM roles for service accounts are used.
+	RoleARN string `yaml:"role_arn"`
+	// ExternalID is passed to AssumeRole, as required by roles of third party accounts.
+	ExternalID      string `yaml:"external_id"`
+	RoleSessionName string `yaml:"role_session_name"`
+	// WebIdentityTokenFile is read on every refresh of the credentials, as the token is rotated.
+	WebIdentityTokenFile string         `yaml:"web_identity_token_file"`
+	Duration             model.Duration `yaml:"duration"`
+}
+
+// newSTSBucket returns the S3 bucket of conf, accessed with the credentials of the role of stsConf. Credentials of
+// the S3 configuration are used to assume the role, if any, otherwise the default credentials of the AWS SDK.
+func newSTSBucket(logger log.Logger, conf client.BucketConfig, stsConf STSConfig, reg prometheus.Registerer, component string) (objstore.InstrumentedBucket, error) {
+	if !strings.EqualFold(string(conf.Type), string(client.S3)) {
+		return nil, errors.Errorf("sts section is not supported by %s buckets", conf.Type)
+	}
+	content, err := yaml.Marshal(conf.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal content of bucket configuration")
+	}
+	s3Conf := s3.DefaultConfig
+	s3Conf.PutUserMetadata = map[string]string{}
+	if err := yaml.UnmarshalStrict(content, &s3Conf); err != nil {
+		return nil, errors.Wrap(err, "parsing S3 configuration")
+	}
+
+	creds, err := newSTSCredentials(s3Conf, stsConf, component)
+	if err != nil {
+		return nil, err
+	}
+	// Buckets are recreated with new credentials, and share the connections of the transport.
+	if s3Conf.HTTPConfig.Transport == nil {
+		if s3Conf.HTTPConfig.Transport, err = exthttp.DefaultTransport(s3Conf.HTTPConfig); err != nil {
+			return nil, errors.Wrap(err, "create S3 transport")
+		}
+	}
+	s3Conf.AWSSDKAuth = false
+
+	bkt := &stsBucket{logger: logger, conf: s3Conf, creds: creds, component: component}
+	if _, err := bkt.bucket(context.Background()); err != nil {
+		return nil, err
+	}
+	level.Info(logger).Log("msg", "accessing S3 bucket with STS credentials", "role_arn", stsConf.RoleARN)
+	return objstore.NewTracingBucket(objstore.BucketWithMetrics(bkt.Name(), objstore.NewPrefixedBucket(bkt, conf.Prefix), reg)), nil
+}
+
+func newSTSCredentials(s3Conf s3.Config, c STSConfig, component string) (*credentials.Credentials, error) {
+	awsConf := aws.NewConfig()
+	if s3Conf.Region != "" {
+		awsConf = awsConf.WithRegion(s3Conf.Region)
+	}
+	if s3Conf.STSEndpoint != "" {
+		awsConf = awsConf.WithEndpoint(s3Conf.STSEndpoint)
+	}
+	// Credentials are looked up by the AWS SDK by default, e.g. from the environment or the instance role.
+	if s3Conf.AccessKey != "" {
+		awsConf = awsConf.WithCredentials(credentials.NewStaticCredentials(s3Conf.AccessKey, s3Conf.SecretKey, s3Conf.SessionToken))
+	}
+	sess, err := session.NewSession(awsConf)
+	if err != nil {
+		return nil, errors.Wrap(err, "create AWS session")
+	}
+	svc := sts.New(sess)
+
+	sessionName := c.RoleSessionName
+	if sessionName == "" {
+		sessionName = "thanos-" + component
+	}
+	roleARN, tokenFile := c.RoleARN, c.WebIdentityTokenFile
+	if roleARN == "" && tokenFile == "" {
+		// EKS IAM roles for service accounts set these variables.
+		roleARN, tokenFile = os.Getenv("AWS_ROLE_ARN"), os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+		if roleARN == "" || tokenFile == "" {
+			return nil, errors.New("no role_arn specified in sts configuration, and AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE are not set")
+		}
+	}
+	if roleARN == "" {
+		return nil, errors.New("no role_arn specified in sts configuration while web_identity_token_file is present")
+	}
+
+	if tokenFile != "" {
+		if c.ExternalID != "" {
+			return nil, errors.New("external_id is not supported with web_identity_token_file")
+		}
+		return credentials.NewCredentials(stscreds.NewWebIdentityRoleProviderWithOptions(svc, roleARN, sessionName, stscreds.FetchTokenPath(tokenFile), func(p *stscreds.WebIdentityRoleProvider) {
+			p.Duration = time.Duration(c.Duration)
+		})), nil
+	}
+	return stscreds.NewCredentialsWithClient(svc, roleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = sessionName
+		p.Duration = time.Duration(c.Duration)
+		if c.ExternalID != "" {
+			p.ExternalID = aws.String(c.ExternalID)
+		}
+	}), nil
+}
+
+// stsBucket is an S3 bucket recreated with the current credentials of the role, as the S3 client has static
+// credentials.
+type stsBucket struct {
+	logger    log.Logger
+	conf      s3.Config
+	creds     *credentials.Credentials
+	component string
+
+	mtx sync.RWMutex
+	val credentials.Value
+	bkt *s3.Bucket
+}
+
+// bucket returns the bucket with the current credentials, refreshing them if they expired.
+func (b *stsBucket) bucket(ctx context.Context) (*s3.Bucket, error) {
+	val, err := b.creds.GetWithContext(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "get STS credentials")
+	}
+	b.mtx.RLock()
+	bkt := b.bkt
+	current := b.val == val
+	b.mtx.RUnlock()
+	if current {
+		return bkt, nil
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.val == val {
+		return b.bkt, nil
+	}
+	conf := b.conf
+	conf.AccessKey, conf.SecretKey, conf.SessionToken = val.AccessKeyID, val.SecretAccessKey, val.SessionToken
+	bkt, err = s3.NewBucketWithConfig(b.logger, conf, b.component)
+	if err != nil {
+		return nil, errors.Wrap(err, "create S3 client")
+	}
+	b.val, b.bkt = val, bkt
+	return bkt, nil
+}
+
+func (b *stsBucket) Name() string {
+	return b.conf.Bucket
+}
+
+func (b *stsBucket) Close() error {
+	return nil
+}
+
+func (b *stsBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	bkt, err := b.bucket(ctx)
+	if err != nil {
+		return err
+	}
+	return bkt.Upload(ctx, name, r)
+}
+
+func (b *stsBucket) Delete(ctx context.Context, name string) error {
+	bkt, err := b.bucket(ctx)
+	if err != nil {
+		return err
+	}
+	return bkt.Delete(ctx, name)
+}
+
+func (b *stsBucket) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	bkt, err := b.bucket(ctx)
+	if err != nil {
+		return err
+	}
+	return bkt.Iter(ctx, dir, f, options...)
+}
+
+func (b *stsBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	bkt, err := b.bucket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return bkt.Get(ctx, name)
+}
+
+func (b *stsBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	bkt, err := b.bucket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return bkt.GetRange(ctx, name, off, length)
+}
+
+func (b *stsBucket) Exists(ctx context.Context, name string) (bool, error) {
+	bkt, err := b.bucket(ctx)
+	if err != nil {
+		return false, err
+	}
+	return bkt.Exists(ctx, name)
+}
+
+func (b *stsBucket) IsObjNotFoundErr(err error) bool {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+	return b.bkt.IsObjNotFoundErr(err)
+}
+
+func (b *stsBucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	bkt, err := b.bucket(ctx)
+	if err != nil {
+		return objstore.ObjectAttributes{}, err
+	}
+	return bkt.Attributes(ctx, name)
+}
@@ -0,0 +1,127 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+)
+
+// fakeAWS serves STS role assumptions and S3 requests, recording the credentials S3 requests are signed with.
+type fakeAWS struct {
+	mtx           sync.Mutex
+	assumed       []url.Values
+	expiration    time.Time
+	accessKeys    []string
+	sessionTokens []string
+}
+
+func (f *fakeAWS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if r.Method == http.MethodPost && r.URL.Path == "/" {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.assumed = append(f.assumed, r.PostForm)
+		action := r.PostForm.Get("Action")
+		n := len(f.assumed)
+		_, _ = fmt.Fprintf(w, `<%[1]sResponse><%[1]sResult><Credentials><AccessKeyId>key-%[2]d</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token-%[2]d</SessionToken><Expiration>%[3]s</Expiration></Credentials></%[1]sResult></%[1]sResponse>`,
+			action, n, f.expiration.UTC().Format(time.RFC3339))
+		return
+	}
+
+	auth := r.Header.Get("Authorization")
+	start := strings.Index(auth, "Credential=") + len("Credential=")
+	f.accessKeys = append(f.accessKeys, auth[start:start+strings.Index(auth[start:], "/")])
+	f.sessionTokens = append(f.sessionTokens, r.Header.Get("X-Amz-Security-Token"))
+	w.WriteHeader(http.StatusNotFound)
+	_, _ = w.Write([]byte(`<Error><Code>NoSuchKey</Code></Error>`))
+}
+
+func newSTSTestBucketConfig(t *testing.T, srv *httptest.Server, sts string) []byte {
+	u, err := url.Parse(srv.URL)
+	testutil.Ok(t, err)
+	return []byte(fmt.Sprintf(`type: S3
+config:
+  bucket: test
+  endpoint: %s
+  region: us-east-1
+  insecure: true
+  access_key: source
+  secret_key: source
+  sts_endpoint: %s
+sts:
+%s`, u.Host, srv.URL, sts))
+}
+
+func TestSTSBucket_AssumeRole(t *testing.T) {
+	fake := &fakeAWS{expiration: time.Now().Add(-time.Minute)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	bkt, err := NewBucket(log.NewNopLogger(), newSTSTestBucketConfig(t, srv, "  role_arn: arn:aws:iam::123456789012:role/thanos\n  external_id: ext\n"), nil, "test")
+	testutil.Ok(t, err)
+
+	// Credentials expired already, so they are refreshed for every operation.
+	for i := 0; i < 2; i++ {
+		ok, err := bkt.Exists(context.Background(), "obj")
+		testutil.Ok(t, err)
+		testutil.Assert(t, !ok)
+	}
+
+	fake.mtx.Lock()
+	defer fake.mtx.Unlock()
+	testutil.Equals(t, 3, len(fake.assumed))
+	testutil.Equals(t, "AssumeRole", fake.assumed[0].Get("Action"))
+	testutil.Equals(t, "arn:aws:iam::123456789012:role/thanos", fake.assumed[0].Get("RoleArn"))
+	testutil.Equals(t, "ext", fake.assumed[0].Get("ExternalId"))
+	testutil.Equals(t, "thanos-test", fake.assumed[0].Get("RoleSessionName"))
+	testutil.Equals(t, []string{"key-2", "key-3"}, fake.accessKeys)
+	testutil.Equals(t, []string{"token-2", "token-3"}, fake.sessionTokens)
+}
+
+func TestSTSBucket_WebIdentity(t *testing.T) {
+	fake := &fakeAWS{expiration: time.Now().Add(time.Hour)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	testutil.Ok(t, os.WriteFile(tokenFile, []byte("web-identity-token"), 0600))
+
+	bkt, err := NewBucket(log.NewNopLogger(), newSTSTestBucketConfig(t, srv, "  role_arn: arn:aws:iam::123456789012:role/thanos\n  web_identity_token_file: "+tokenFile+"\n"), nil, "test")
+	testutil.Ok(t, err)
+	for i := 0; i < 2; i++ {
+		ok, err := bkt.Exists(context.Background(), "obj")
+		testutil.Ok(t, err)
+		testutil.Assert(t, !ok)
+	}
+
+	fake.mtx.Lock()
+	defer fake.mtx.Unlock()
+	testutil.Equals(t, 1, len(fake.assumed))
+	testutil.Equals(t, "AssumeRoleWithWebIdentity", fake.assumed[0].Get("Action"))
+	testutil.Equals(t, "web-identity-token", fake.assumed[0].Get("WebIdentityToken"))
+	testutil.Equals(t, []string{"key-1", "key-1"}, fake.accessKeys)
+
+	// External IDs are not supported by AssumeRoleWithWebIdentity, and sts sections by other providers.
+	_, err = NewBucket(log.NewNopLogger(), newSTSTestBucketConfig(t, srv, "  role_arn: arn\n  web_identity_token_file: "+tokenFile+"\n  external_id: ext\n"), nil, "test")
+	testutil.NotOk(t, err)
+	_, err = NewBucket(log.NewNopLogger(), []byte("type: FILESYSTEM\nconfig:\n  directory: "+t.TempDir()+"\nsts:\n  role_arn: arn\n"), nil, "test")
+	testutil.NotOk(t, err)
+}
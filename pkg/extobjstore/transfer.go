@@ -0,0 +1,240 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+)
+
+// CostsConfig are the prices of the requests and transfers of the bucket, in the costs section of the bucket
+// configuration, to estimate the cost of the operations of components. Prices are in the currency of the provider.
+type CostsConfig struct {
+	// GetRequests is the price of 1000 GET requests, which are sent by Get, GetRange, Exists and Attributes operations.
+	GetRequests float64 `yaml:"price_per_1k_get_requests"`
+	// PutRequests is the price of 1000 PUT requests, which are sent by Upload and Iter operations.
+	PutRequests float64 `yaml:"price_per_1k_put_requests"`
+	// DownloadedGiB and UploadedGiB are the prices of a GiB downloaded from and uploaded to the bucket.
+	DownloadedGiB float64 `yaml:"price_per_downloaded_gib"`
+	UploadedGiB   float64 `yaml:"price_per_uploaded_gib"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *CostsConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain CostsConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.GetRequests < 0 || c.PutRequests < 0 || c.DownloadedGiB < 0 || c.UploadedGiB < 0 {
+		return errors.New("prices must not be negative")
+	}
+	return nil
+}
+
+func (c CostsConfig) request(op string) float64 {
+	switch op {
+	case objstore.OpGet, objstore.OpGetRange, objstore.OpExists, objstore.OpAttributes:
+		return c.GetRequests / 1000
+	case objstore.OpUpload, objstore.OpIter:
+		return c.PutRequests / 1000
+	}
+	return 0
+}
+
+func (c CostsConfig) transfer(op string, bytes int) float64 {
+	price := c.DownloadedGiB
+	if op == objstore.OpUpload {
+		price = c.UploadedGiB
+	}
+	return price * float64(bytes) / (1 << 30)
+}
+
+// WrapWithTransferMetrics returns a bucket counting the bytes uploaded to and downloaded from bkt by operation, and
+// estimating the cost of the operations if costs are given. The metrics have the component using the bucket as label,
+// so that the costs of object storage can be attributed to components.
+func WrapWithTransferMetrics(bkt objstore.InstrumentedBucket, component string, costs *CostsConfig, reg prometheus.Registerer) objstore.InstrumentedBucket {
+	constLabels := prometheus.Labels{"bucket": bkt.Name(), "component": component}
+	m := &transferMetrics{
+		transferred: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name:        "thanos_objstore_bucket_operation_transferred_bytes_total",
+			Help:        "Total number of bytes uploaded to and downloaded from the bucket by operation.",
+			ConstLabels: constLabels,
+		}, []string{"operation"}),
+		costs: costs,
+	}
+	ops := []string{objstore.OpIter, objstore.OpGet, objstore.OpGetRange, objstore.OpExists, objstore.OpUpload, objstore.OpDelete, objstore.OpAttributes}
+	for _, op := range []string{objstore.OpGet, objstore.OpGetRange, objstore.OpUpload} {
+		m.transferred.WithLabelValues(op)
+	}
+	if costs != nil {
+		m.cost = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name:        "thanos_objstore_bucket_operation_estimated_cost_total",
+			Help:        "Estimated cost of the operations against the bucket, from the configured prices of requests and transfers.",
+			ConstLabels: constLabels,
+		}, []string{"operation"})
+		for _, op := range ops {
+			m.cost.WithLabelValues(op)
+		}
+	}
+	return &transferMetricsInstrumentedBucket{transferMetricsBucket: transferMetricsBucket{Bucket: bkt, m: m}, ib: bkt}
+}
+
+type transferMetrics struct {
+	transferred *prometheus.CounterVec
+	cost        *prometheus.CounterVec
+	costs       *CostsConfig
+}
+
+func (m *transferMetrics) request(op string) {
+	if m.costs != nil {
+		m.cost.WithLabelValues(op).Add(m.costs.request(op))
+	}
+}
+
+func (m *transferMetrics) transfer(op string, n int) {
+	if n <= 0 {
+		return
+	}
+	m.transferred.WithLabelValues(op).Add(float64(n))
+	if m.costs != nil {
+		m.cost.WithLabelValues(op).Add(m.costs.transfer(op, n))
+	}
+}
+
+type transferMetricsInstrumentedBucket struct {
+	transferMetricsBucket
+	ib objstore.InstrumentedBucket
+}
+
+func (b *transferMetricsInstrumentedBucket) WithExpectedErrs(f objstore.IsOpFailureExpectedFunc) objstore.Bucket {
+	return &transferMetricsBucket{Bucket: b.ib.WithExpectedErrs(f), m: b.m}
+}
+
+func (b *transferMetricsInstrumentedBucket) ReaderWithExpectedErrs(f objstore.IsOpFailureExpectedFunc) objstore.BucketReader {
+	return &transferMetricsBucketReader{BucketReader: b.ib.ReaderWithExpectedErrs(f), m: b.m}
+}
+
+type transferMetricsBucket struct {
+	objstore.Bucket
+	m *transferMetrics
+}
+
+func (b *transferMetricsBucket) reader() *transferMetricsBucketReader {
+	return &transferMetricsBucketReader{BucketReader: b.Bucket, m: b.m}
+}
+
+func (b *transferMetricsBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	b.m.request(objstore.OpUpload)
+	cr := countingReader{r: r, m: b.m, op: objstore.OpUpload}
+	// Readers that can be read at offsets are kept, as their parts are uploaded in parallel.
+	if ra, ok := r.(io.ReaderAt); ok {
+		return b.Bucket.Upload(ctx, name, &countingReaderAt{countingReader: cr, ra: ra})
+	}
+	return b.Bucket.Upload(ctx, name, &cr)
+}
+
+func (b *transferMetricsBucket) Delete(ctx context.Context, name string) error {
+	b.m.request(objstore.OpDelete)
+	return b.Bucket.Delete(ctx, name)
+}
+
+func (b *transferMetricsBucket) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	return b.reader().Iter(ctx, dir, f, options...)
+}
+
+func (b *transferMetricsBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.reader().Get(ctx, name)
+}
+
+func (b *transferMetricsBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	return b.reader().GetRange(ctx, name, off, length)
+}
+
+func (b *transferMetricsBucket) Exists(ctx context.Context, name string) (bool, error) {
+	return b.reader().Exists(ctx, name)
+}
+
+func (b *transferMetricsBucket) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	return b.reader().Attributes(ctx, name)
+}
+
+type transferMetricsBucketReader struct {
+	objstore.BucketReader
+	m *transferMetrics
+}
+
+func (b *transferMetricsBucketReader) Iter(ctx context.Context, dir string, f func(string) error, options ...objstore.IterOption) error {
+	b.m.request(objstore.OpIter)
+	return b.BucketReader.Iter(ctx, dir, f, options...)
+}
+
+func (b *transferMetricsBucketReader) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	b.m.request(objstore.OpGet)
+	rc, err := b.BucketReader.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &countingReadCloser{countingReader: countingReader{r: rc, m: b.m, op: objstore.OpGet}, c: rc}, nil
+}
+
+func (b *transferMetricsBucketReader) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	b.m.request(objstore.OpGetRange)
+	rc, err := b.BucketReader.GetRange(ctx, name, off, length)
+	if err != nil {
+		return nil, err
+	}
+	return &countingReadCloser{countingReader: countingReader{r: rc, m: b.m, op: objstore.OpGetRange}, c: rc}, nil
+}
+
+func (b *transferMetricsBucketReader) Exists(ctx context.Context, name string) (bool, error) {
+	b.m.request(objstore.OpExists)
+	return b.BucketReader.Exists(ctx, name)
+}
+
+func (b *transferMetricsBucketReader) Attributes(ctx context.Context, name string) (objstore.ObjectAttributes, error) {
+	b.m.request(objstore.OpAttributes)
+	return b.BucketReader.Attributes(ctx, name)
+}
+
+// countingReader counts the bytes read from r as transferred by op. It keeps the size of r.
+type countingReader struct {
+	r  io.Reader
+	m  *transferMetrics
+	op string
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.m.transfer(r.op, n)
+	return n, err
+}
+
+func (r *countingReader) ObjectSize() (int64, error) {
+	return objstore.TryToGetSize(r.r)
+}
+
+type countingReaderAt struct {
+	countingReader
+	ra io.ReaderAt
+}
+
+func (r *countingReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	n, err := r.ra.ReadAt(b, off)
+	r.m.transfer(r.op, n)
+	return n, err
+}
+
+type countingReadCloser struct {
+	countingReader
+	c io.Closer
+}
+
+func (r *countingReadCloser) Close() error {
+	return r.c.Close()
+}
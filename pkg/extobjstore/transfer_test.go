@@ -0,0 +1,68 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/thanos-io/objstore"
+)
+
+func TestWrapWithTransferMetrics(t *testing.T) {
+	ctx := context.Background()
+	costs := &CostsConfig{GetRequests: 0.4, PutRequests: 5, DownloadedGiB: 0.09, UploadedGiB: 0.01}
+	bkt := WrapWithTransferMetrics(objstore.WithNoopInstr(objstore.NewInMemBucket()), "compact", costs, nil).(*transferMetricsInstrumentedBucket)
+	m := bkt.m
+
+	content := bytes.Repeat([]byte("a"), 1<<20)
+	testutil.Ok(t, bkt.Upload(ctx, "obj", bytes.NewReader(content)))
+	r, err := bkt.Get(ctx, "obj")
+	testutil.Ok(t, err)
+	_, err = io.Copy(io.Discard, r)
+	testutil.Ok(t, err)
+	testutil.Ok(t, r.Close())
+	r, err = bkt.ReaderWithExpectedErrs(bkt.IsObjNotFoundErr).GetRange(ctx, "obj", 10, 100)
+	testutil.Ok(t, err)
+	_, err = io.Copy(io.Discard, r)
+	testutil.Ok(t, err)
+	_, err = bkt.Exists(ctx, "obj")
+	testutil.Ok(t, err)
+	testutil.Ok(t, bkt.Iter(ctx, "", func(string) error { return nil }))
+	testutil.Ok(t, bkt.Delete(ctx, "obj"))
+
+	testutil.Equals(t, float64(1<<20), promtestutil.ToFloat64(m.transferred.WithLabelValues(objstore.OpUpload)))
+	testutil.Equals(t, float64(1<<20), promtestutil.ToFloat64(m.transferred.WithLabelValues(objstore.OpGet)))
+	testutil.Equals(t, 100.0, promtestutil.ToFloat64(m.transferred.WithLabelValues(objstore.OpGetRange)))
+
+	// Requests are priced per 1000, and transfers per GiB.
+	testutil.Assert(t, math.Abs(0.005+0.01/1024-promtestutil.ToFloat64(m.cost.WithLabelValues(objstore.OpUpload))) < 1e-12)
+	testutil.Assert(t, math.Abs(0.0004+0.09/1024-promtestutil.ToFloat64(m.cost.WithLabelValues(objstore.OpGet))) < 1e-12)
+	testutil.Assert(t, math.Abs(0.0004-promtestutil.ToFloat64(m.cost.WithLabelValues(objstore.OpExists))) < 1e-12)
+	testutil.Assert(t, math.Abs(0.005-promtestutil.ToFloat64(m.cost.WithLabelValues(objstore.OpIter))) < 1e-12)
+	testutil.Assert(t, math.Abs(0.0-promtestutil.ToFloat64(m.cost.WithLabelValues(objstore.OpDelete))) < 1e-12)
+
+	// The size of uploaded readers is kept.
+	size, err := objstore.TryToGetSize(&countingReader{r: strings.NewReader("content")})
+	testutil.Ok(t, err)
+	testutil.Equals(t, int64(7), size)
+}
+
+func TestNewBucket_Costs(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	_, err := NewBucket(log.NewNopLogger(), []byte("type: FILESYSTEM\nconfig:\n  directory: "+t.TempDir()+"\ncosts:\n  price_per_1k_get_requests: 0.4\n"), reg, "store")
+	testutil.Ok(t, err)
+	testutil.Equals(t, 7, promtestutil.CollectAndCount(reg, "thanos_objstore_bucket_operation_estimated_cost_total"))
+
+	_, err = NewBucket(log.NewNopLogger(), []byte("type: FILESYSTEM\nconfig:\n  directory: "+t.TempDir()+"\ncosts:\n  price_per_1k_get_requests: -1\n"), nil, "store")
+	testutil.NotOk(t, err)
+}
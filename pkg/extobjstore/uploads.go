@@ -0,0 +1,520 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"github.com/minio/sha256-simd"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/version"
+	"github.com/thanos-io/objstore"
+	"github.com/thanos-io/objstore/client"
+	"github.com/thanos-io/objstore/exthttp"
+	"github.com/thanos-io/objstore/providers/s3"
+	"go.uber.org/atomic"
+	"golang.org/x/sync/errgroup"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/model"
+)
+
+// UploadsConfig is the configuration of the multipart uploads of the bucket, in the uploads section of the bucket
+// configuration. It is supported by S3, GCS, Azure containers accessed with workload identity, and OBS buckets.
+type UploadsConfig struct {
+	// PartSize is the size of the parts of multipart uploads, replacing the part size of the provider. 0 keeps the
+	// part size of the provider.
+	PartSize model.Bytes `yaml:"part_size"`
+	// Concurrency is the number of parts of an upload uploaded in parallel. 0 keeps the concurrency of the provider.
+	Concurrency int `yaml:"concurrency"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *UploadsConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain UploadsConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.Concurrency < 0 {
+		return errors.New("concurrency must not be negative")
+	}
+	return nil
+}
+
+// WrapWithUploadMetrics returns a bucket exposing the progress of the uploads to bkt, to see their throughput and
+// uploads that are stuck. Uploads progress as the provider reads their content.
+func WrapWithUploadMetrics(bkt objstore.InstrumentedBucket, reg prometheus.Registerer) objstore.InstrumentedBucket {
+	t := &uploadTracker{uploads: map[*uploadProgress]struct{}{}}
+	constLabels := prometheus.Labels{"bucket": bkt.Name()}
+	t.inProgress = promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name:        "thanos_objstore_bucket_uploads_in_progress",
+		Help:        "Number of uploads to the bucket in progress.",
+		ConstLabels: constLabels,
+	})
+	t.sentBytes = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name:        "thanos_objstore_bucket_upload_sent_bytes_total",
+		Help:        "Total number of bytes of uploads read by the bucket client.",
+		ConstLabels: constLabels,
+	})
+	promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "thanos_objstore_bucket_upload_stalled_seconds",
+		Help:        "Longest time an upload in progress has not progressed for, or 0 if there is no upload in progress.",
+		ConstLabels: constLabels,
+	}, t.stalled)
+	return &uploadMetricsInstrumentedBucket{uploadMetricsBucket: uploadMetricsBucket{Bucket: bkt, t: t}, ib: bkt}
+}
+
+type uploadTracker struct {
+	inProgress prometheus.Gauge
+	sentBytes  prometheus.Counter
+
+	mtx     sync.Mutex
+	uploads map[*uploadProgress]struct{}
+}
+
+type uploadProgress struct {
+	t            *uploadTracker
+	lastProgress time.Time
+}
+
+func (t *uploadTracker) start() *uploadProgress {
+	p := &uploadProgress{t: t, lastProgress: time.Now()}
+	t.mtx.Lock()
+	t.uploads[p] = struct{}{}
+	t.mtx.Unlock()
+	t.inProgress.Inc()
+	return p
+}
+
+func (t *uploadTracker) done(p *uploadProgress) {
+	t.mtx.Lock()
+	delete(t.uploads, p)
+	t.mtx.Unlock()
+	t.inProgress.Dec()
+}
+
+func (t *uploadTracker) stalled() float64 {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	var stalled time.Duration
+	for p := range t.uploads {
+		if d := time.Since(p.lastProgress); d > stalled {
+			stalled = d
+		}
+	}
+	return stalled.Seconds()
+}
+
+func (p *uploadProgress) progress(n int) {
+	if n <= 0 {
+		return
+	}
+	p.t.sentBytes.Add(float64(n))
+	p.t.mtx.Lock()
+	p.lastProgress = time.Now()
+	p.t.mtx.Unlock()
+}
+
+// progressReader reports the progress of the upload of r. It keeps the size of r, which providers use to choose
+// between single and multipart uploads.
+type progressReader struct {
+	r io.Reader
+	p *uploadProgress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.progress(n)
+	return n, err
+}
+
+func (r *progressReader) ObjectSize() (int64, error) {
+	return objstore.TryToGetSize(r.r)
+}
+
+// progressReaderAt is the progressReader of readers, the parts of which providers upload in parallel.
+type progressReaderAt struct {
+	progressReader
+	ra io.ReaderAt
+}
+
+func (r *progressReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	n, err := r.ra.ReadAt(b, off)
+	r.p.progress(n)
+	return n, err
+}
+
+type uploadMetricsInstrumentedBucket struct {
+	uploadMetricsBucket
+	ib objstore.InstrumentedBucket
+}
+
+func (b *uploadMetricsInstrumentedBucket) WithExpectedErrs(f objstore.IsOpFailureExpectedFunc) objstore.Bucket {
+	return &uploadMetricsBucket{Bucket: b.ib.WithExpectedErrs(f), t: b.t}
+}
+
+func (b *uploadMetricsInstrumentedBucket) ReaderWithExpectedErrs(f objstore.IsOpFailureExpectedFunc) objstore.BucketReader {
+	return b.ib.ReaderWithExpectedErrs(f)
+}
+
+type uploadMetricsBucket struct {
+	objstore.Bucket
+	t *uploadTracker
+}
+
+func (b *uploadMetricsBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	p := b.t.start()
+	defer b.t.done(p)
+
+	pr := progressReader{r: r, p: p}
+	if ra, ok := r.(io.ReaderAt); ok {
+		return b.Bucket.Upload(ctx, name, &progressReaderAt{progressReader: pr, ra: ra})
+	}
+	return b.Bucket.Upload(ctx, name, &pr)
+}
+
+// newS3UploadsBucket returns the S3 bucket of conf, uploading objects with the part size and concurrency of uploads,
+// with the retention of the object lock if any, and with the storage class of WithStorageClass if any.
+func newS3UploadsBucket(logger log.Logger, conf client.BucketConfig, uploads UploadsConfig, lock *ObjectLockConfig, reg prometheus.Registerer, component string) (objstore.InstrumentedBucket, error) {
+	content, err := yaml.Marshal(conf.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal content of bucket configuration")
+	}
+	s3Conf := s3.DefaultConfig
+	if err := yaml.UnmarshalStrict(content, &s3Conf); err != nil {
+		return nil, errors.Wrap(err, "parsing S3 configuration")
+	}
+	if uploads.PartSize > 0 {
+		s3Conf.PartSize = uint64(uploads.PartSize)
+	}
+	// The S3 client removes the storage class from the metadata of the configuration it is created with.
+	bktConf := s3Conf
+	bktConf.PutUserMetadata = make(map[string]string, len(s3Conf.PutUserMetadata))
+	for k, v := range s3Conf.PutUserMetadata {
+		bktConf.PutUserMetadata[k] = v
+	}
+	bkt, err := s3.NewBucketWithConfig(logger, bktConf, component)
+	if err != nil {
+		return nil, err
+	}
+
+	// The concurrency of the uploads of the S3 client is fixed, and it does not set the retention or the storage class
+	// of single objects, nor resume multipart uploads, so such uploads are sent with another client, created by the
+	// first of them.
+	uploadBkt, err := newS3UploadingBucket(logger, bkt, s3Conf, uploads.Concurrency, lock, component)
+	if err != nil {
+		return nil, err
+	}
+	if lock != nil {
+		level.Info(logger).Log("msg", "uploading objects to S3 bucket with object lock", "mode", lock.Mode, "retention", lock.Retention)
+	}
+	if uploads != (UploadsConfig{}) {
+		level.Info(logger).Log("msg", "uploading objects to S3 bucket", "part_size", s3Conf.PartSize, "concurrency", uploads.Concurrency)
+	}
+	return objstore.NewTracingBucket(objstore.BucketWithMetrics(bkt.Name(), objstore.NewPrefixedBucket(uploadBkt, conf.Prefix), reg)), nil
+}
+
+// s3UploadingBucket is the bucket of the S3 client, uploading parts of objects with the configured concurrency, objects
+// with the retention of the configured object lock and the storage class of WithStorageClass, and resuming uploads with
+// WithResumableUploads. Other uploads are sent by the S3 client.
+type s3UploadingBucket struct {
+	*s3.Bucket
+	logger log.Logger
+	// newClient creates the client of the uploads not sent by the S3 client. It is called once by uploadClient.
+	newClient  func() (*minio.Client, error)
+	clientOnce sync.Once
+	client     *minio.Client
+	clientErr  error
+	bucket     string
+	opts       minio.PutObjectOptions
+	retention  time.Duration
+	// custom is true if all uploads are sent with the client of the bucket.
+	custom bool
+}
+
+// newS3UploadingBucket returns bkt uploading with a client configured like the client of bkt. The client is only
+// created by the first upload not sent by the S3 client. A concurrency of 0 keeps the concurrency of the client.
+func newS3UploadingBucket(logger log.Logger, bkt *s3.Bucket, conf s3.Config, concurrency int, lock *ObjectLockConfig, component string) (*s3UploadingBucket, error) {
+	var provider credentials.Provider
+	switch {
+	case conf.AWSSDKAuth:
+		provider = &s3.AWSSDKAuth{Region: conf.Region}
+	case conf.AccessKey != "":
+		provider = &credentials.Static{Value: credentials.Value{
+			AccessKeyID:     conf.AccessKey,
+			SecretAccessKey: conf.SecretKey,
+			SessionToken:    conf.SessionToken,
+			SignerType:      credentials.SignatureV4,
+		}}
+	default:
+		provider = &credentials.Chain{Providers: []credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}, Endpoint: conf.STSEndpoint},
+		}}
+	}
+	if conf.SignatureV2 {
+		provider = &signatureV2Provider{Provider: provider}
+	}
+
+	var err error
+	b := &s3UploadingBucket{
+		Bucket: bkt,
+		logger: logger,
+		custom: concurrency > 0 || lock != nil,
+		newClient: func() (*minio.Client, error) {
+			rt := conf.HTTPConfig.Transport
+			if rt == nil {
+				var err error
+				if rt, err = exthttp.DefaultTransport(conf.HTTPConfig); err != nil {
+					return nil, err
+				}
+			}
+			minioClient, err := minio.New(conf.Endpoint, &minio.Options{
+				Creds:        credentials.New(provider),
+				Secure:       !conf.Insecure,
+				Region:       conf.Region,
+				Transport:    rt,
+				BucketLookup: conf.BucketLookupType.MinioType(),
+			})
+			if err != nil {
+				return nil, errors.Wrap(err, "initialize s3 client")
+			}
+			minioClient.SetAppInfo(fmt.Sprintf("thanos-%s", component), fmt.Sprintf("%s (%s)", version.Version, runtime.Version()))
+			return minioClient, nil
+		},
+		bucket: conf.Bucket,
+		opts: minio.PutObjectOptions{
+			PartSize:     conf.PartSize,
+			NumThreads:   uint(concurrency),
+			UserMetadata: map[string]string{},
+		},
+	}
+	for k, v := range conf.PutUserMetadata {
+		if strings.EqualFold(k, "X-Amz-Storage-Class") {
+			b.opts.StorageClass = v
+			continue
+		}
+		b.opts.UserMetadata[k] = v
+	}
+	switch conf.SSEConfig.Type {
+	case "":
+	case s3.SSEKMS:
+		kmsContext := conf.SSEConfig.KMSEncryptionContext
+		if kmsContext == nil {
+			kmsContext = map[string]string{}
+		}
+		b.opts.ServerSideEncryption, err = encrypt.NewSSEKMS(conf.SSEConfig.KMSKeyID, kmsContext)
+	case s3.SSEC:
+		var key []byte
+		if key, err = os.ReadFile(conf.SSEConfig.EncryptionKey); err == nil {
+			b.opts.ServerSideEncryption, err = encrypt.NewSSEC(key)
+		}
+	case s3.SSES3:
+		b.opts.ServerSideEncryption = encrypt.NewSSE()
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "initialize s3 client SSE")
+	}
+	if lock != nil {
+		b.opts.Mode = minio.RetentionMode(lock.Mode)
+		b.retention = time.Duration(lock.Retention)
+		// S3 requires the checksum of the content of objects uploaded with a retention.
+		b.opts.SendContentMd5 = true
+	}
+	return b, nil
+}
+
+// uploadClient returns the client of the uploads not sent by the S3 client, creating it on the first call.
+func (b *s3UploadingBucket) uploadClient() (*minio.Client, error) {
+	b.clientOnce.Do(func() {
+		b.client, b.clientErr = b.newClient()
+	})
+	return b.client, b.clientErr
+}
+
+type resumableUploadsKey struct{}
+
+// WithResumableUploads returns a context, uploads with which resume the multipart upload of the same object left
+// incomplete by an earlier failed upload instead of starting over, and leave their multipart upload incomplete on
+// failure to be resumed. Parts uploaded before are only reused if their checksum matches the content. It is supported by
+// S3 buckets, for contents larger than the part size which are io.ReaderAt, like files. S3 keeps the parts of
+// incomplete multipart uploads which are never resumed until they are aborted, e.g. by a lifecycle rule of the bucket.
+func WithResumableUploads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, resumableUploadsKey{}, true)
+}
+
+// Upload uploads the object like the S3 client, with the configured concurrency and retention.
+func (b *s3UploadingBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	class := storageClassFromContext(ctx, "")
+	resumable, _ := ctx.Value(resumableUploadsKey{}).(bool)
+	size, err := objstore.TryToGetSize(r)
+	if err != nil {
+		size = -1
+	}
+	opts := b.opts
+	if size < int64(opts.PartSize) {
+		opts.PartSize = 0
+	}
+	_, isReaderAt := r.(io.ReaderAt)
+	// Uploads of a single part cannot be resumed.
+	resumable = resumable && isReaderAt && opts.PartSize > 0
+	if !b.custom && class == "" && !resumable {
+		return b.Bucket.Upload(ctx, name, r)
+	}
+	client, err := b.uploadClient()
+	if err != nil {
+		return err
+	}
+	if b.retention > 0 {
+		opts.RetainUntilDate = time.Now().Add(b.retention).UTC()
+	}
+	if class != "" {
+		opts.StorageClass = class
+	}
+	if resumable {
+		return b.uploadResumable(ctx, client, name, r.(io.ReaderAt), size, opts)
+	}
+	if _, err := client.PutObject(ctx, b.bucket, name, r, size, opts); err != nil {
+		return errors.Wrap(err, "upload s3 object")
+	}
+	return nil
+}
+
+// uploadResumable uploads the object in parts, resuming the latest incomplete multipart upload of the object if any.
+// The multipart upload is not aborted on failure.
+func (b *s3UploadingBucket) uploadResumable(ctx context.Context, client *minio.Client, name string, r io.ReaderAt, size int64, opts minio.PutObjectOptions) error {
+	core := minio.Core{Client: client}
+	uploadID, uploaded, err := b.incompleteUpload(ctx, core, name)
+	if err != nil {
+		return err
+	}
+	if uploadID == "" {
+		if uploadID, err = core.NewMultipartUpload(ctx, b.bucket, name, opts); err != nil {
+			return errors.Wrap(err, "initiate s3 multipart upload")
+		}
+	}
+
+	partSize := int64(opts.PartSize)
+	parts := make([]minio.CompletePart, (size+partSize-1)/partSize)
+	g, gctx := errgroup.WithContext(ctx)
+	if opts.NumThreads > 0 {
+		g.SetLimit(int(opts.NumThreads))
+	} else {
+		g.SetLimit(4)
+	}
+	var reused atomic.Int64
+	for i := range parts {
+		num, off := i+1, int64(i)*partSize
+		length := partSize
+		if off+length > size {
+			length = size - off
+		}
+		g.Go(func() error {
+			md5Hash, sha256Hash := md5.New(), sha256.New()
+			if _, err := io.Copy(io.MultiWriter(md5Hash, sha256Hash), io.NewSectionReader(r, off, length)); err != nil {
+				return errors.Wrapf(err, "read part %d", num)
+			}
+			sum := md5Hash.Sum(nil)
+			if p, ok := uploaded[num]; ok && p.Size == length && strings.Trim(p.ETag, `"`) == hex.EncodeToString(sum) {
+				reused.Inc()
+				parts[num-1] = minio.CompletePart{PartNumber: num, ETag: p.ETag}
+				return nil
+			}
+			p, err := core.PutObjectPart(gctx, b.bucket, name, uploadID, num, io.NewSectionReader(r, off, length), length, base64.StdEncoding.EncodeToString(sum), hex.EncodeToString(sha256Hash.Sum(nil)), opts.ServerSideEncryption)
+			if err != nil {
+				return errors.Wrapf(err, "upload part %d", num)
+			}
+			parts[num-1] = minio.CompletePart{PartNumber: num, ETag: p.ETag}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return errors.Wrap(err, "upload s3 object parts")
+	}
+	if n := reused.Load(); n > 0 {
+		level.Info(b.logger).Log("msg", "resumed s3 multipart upload", "object", name, "upload_id", uploadID, "reused_parts", n, "parts", len(parts))
+	}
+	if _, err := core.CompleteMultipartUpload(ctx, b.bucket, name, uploadID, parts, opts); err != nil {
+		return errors.Wrap(err, "complete s3 multipart upload")
+	}
+	return nil
+}
+
+// incompleteUpload returns the ID and the uploaded parts of the latest incomplete multipart upload of the object, or an
+// empty ID if there is none.
+func (b *s3UploadingBucket) incompleteUpload(ctx context.Context, core minio.Core, name string) (string, map[int]minio.ObjectPart, error) {
+	var (
+		latest              minio.ObjectMultipartInfo
+		keyMarker, idMarker string
+	)
+	for {
+		res, err := core.ListMultipartUploads(ctx, b.bucket, name, keyMarker, idMarker, "", 1000)
+		if err != nil {
+			return "", nil, errors.Wrap(err, "list s3 multipart uploads")
+		}
+		for _, u := range res.Uploads {
+			if u.Key == name && !u.Initiated.Before(latest.Initiated) {
+				latest = u
+			}
+		}
+		if !res.IsTruncated {
+			break
+		}
+		keyMarker, idMarker = res.NextKeyMarker, res.NextUploadIDMarker
+	}
+	if latest.UploadID == "" {
+		return "", nil, nil
+	}
+
+	parts := map[int]minio.ObjectPart{}
+	for marker := 0; ; {
+		res, err := core.ListObjectParts(ctx, b.bucket, name, latest.UploadID, marker, 1000)
+		if err != nil {
+			return "", nil, errors.Wrap(err, "list s3 multipart upload parts")
+		}
+		for _, p := range res.ObjectParts {
+			parts[p.PartNumber] = p
+		}
+		if !res.IsTruncated {
+			break
+		}
+		marker = res.NextPartNumberMarker
+	}
+	return latest.UploadID, parts, nil
+}
+
+// signatureV2Provider signs requests with signature V2, like the S3 client with signature_version2.
+type signatureV2Provider struct {
+	credentials.Provider
+}
+
+func (p *signatureV2Provider) Retrieve() (credentials.Value, error) {
+	v, err := p.Provider.Retrieve()
+	if err != nil {
+		return v, err
+	}
+	if !v.SignerType.IsAnonymous() {
+		v.SignerType = credentials.SignatureV2
+	}
+	return v, nil
+}
@@ -0,0 +1,296 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extobjstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/thanos-io/objstore"
+)
+
+// blockingReader blocks the second read until unblocked.
+type blockingReader struct {
+	r       io.Reader
+	reads   int
+	read    chan struct{}
+	unblock chan struct{}
+}
+
+func (r *blockingReader) Read(b []byte) (int, error) {
+	if r.reads++; r.reads == 2 {
+		close(r.read)
+		<-r.unblock
+	}
+	if len(b) > 3 {
+		b = b[:3]
+	}
+	return r.r.Read(b)
+}
+
+func TestWrapWithUploadMetrics(t *testing.T) {
+	ctx := context.Background()
+	bkt := WrapWithUploadMetrics(objstore.WithNoopInstr(objstore.NewInMemBucket()), nil).(*uploadMetricsInstrumentedBucket)
+
+	r := &blockingReader{r: strings.NewReader("content"), read: make(chan struct{}), unblock: make(chan struct{})}
+	errc := make(chan error)
+	go func() { errc <- bkt.Upload(ctx, "obj", r) }()
+	<-r.read
+	time.Sleep(10 * time.Millisecond)
+
+	// The upload is stalled after the first read.
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(bkt.t.inProgress))
+	testutil.Equals(t, 3.0, promtestutil.ToFloat64(bkt.t.sentBytes))
+	testutil.Assert(t, bkt.t.stalled() >= 0.01)
+
+	close(r.unblock)
+	testutil.Ok(t, <-errc)
+	testutil.Equals(t, 0.0, promtestutil.ToFloat64(bkt.t.inProgress))
+	testutil.Equals(t, 7.0, promtestutil.ToFloat64(bkt.t.sentBytes))
+	testutil.Equals(t, 0.0, bkt.t.stalled())
+
+	// The size of the reader is kept, and the reader can be read at offsets if the reader of the caller can be.
+	size, err := objstore.TryToGetSize(&progressReader{r: bytes.NewReader([]byte("content"))})
+	testutil.Ok(t, err)
+	testutil.Equals(t, int64(7), size)
+	var uploaded io.Reader
+	testutil.Ok(t, (&uploadMetricsBucket{Bucket: readerAtCheckingBucket{Bucket: objstore.NewInMemBucket(), uploaded: &uploaded}, t: bkt.t}).Upload(ctx, "obj", bytes.NewReader([]byte("content"))))
+	_, ok := uploaded.(io.ReaderAt)
+	testutil.Assert(t, ok)
+}
+
+type readerAtCheckingBucket struct {
+	objstore.Bucket
+	uploaded *io.Reader
+}
+
+func (b readerAtCheckingBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	*b.uploaded = r
+	return b.Bucket.Upload(ctx, name, r)
+}
+
+// fakeS3Multipart serves the multipart uploads of S3, recording the maximum number of parts uploaded in parallel.
+type fakeS3Multipart struct {
+	mtx                sync.Mutex
+	active, maxActive  int
+	parts              map[string][]byte
+	completed, storage string
+}
+
+func (f *fakeS3Multipart) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.mtx.Lock()
+		f.storage = r.Header.Get("X-Amz-Storage-Class")
+		f.mtx.Unlock()
+		_, _ = fmt.Fprint(w, `<InitiateMultipartUploadResult><Bucket>test</Bucket><Key>obj</Key><UploadId>1</UploadId></InitiateMultipartUploadResult>`)
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		f.mtx.Lock()
+		f.active++
+		if f.active > f.maxActive {
+			f.maxActive = f.active
+		}
+		f.mtx.Unlock()
+
+		body, err := io.ReadAll(r.Body)
+		time.Sleep(50 * time.Millisecond)
+
+		f.mtx.Lock()
+		f.active--
+		f.parts[query.Get("partNumber")] = body
+		f.mtx.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("ETag", `"`+query.Get("partNumber")+`"`)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.mtx.Lock()
+		f.completed = r.URL.Path
+		f.mtx.Unlock()
+		_, _ = fmt.Fprint(w, `<CompleteMultipartUploadResult><Bucket>test</Bucket><Key>obj</Key><ETag>"etag"</ETag></CompleteMultipartUploadResult>`)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestS3UploadsBucket(t *testing.T) {
+	fake := &fakeS3Multipart{parts: map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	testutil.Ok(t, err)
+
+	bkt, err := NewBucket(log.NewNopLogger(), []byte(`type: S3
+config:
+  bucket: test
+  endpoint: `+u.Host+`
+  region: us-east-1
+  insecure: true
+  access_key: key
+  secret_key: secret
+  put_user_metadata:
+    X-Amz-Storage-Class: STANDARD_IA
+uploads:
+  part_size: 5MiB
+  concurrency: 4
+`), nil, "test")
+	testutil.Ok(t, err)
+
+	content := bytes.Repeat([]byte("a"), 20*1024*1024)
+	testutil.Ok(t, bkt.Upload(context.Background(), "obj", bytes.NewReader(content)))
+	testutil.Equals(t, 4, len(fake.parts))
+	testutil.Equals(t, 4, fake.maxActive)
+	testutil.Equals(t, "/test/obj", fake.completed)
+	testutil.Equals(t, "STANDARD_IA", fake.storage)
+
+	for _, conf := range []string{
+		"type: FILESYSTEM\nconfig:\n  directory: " + t.TempDir() + "\nuploads:\n  concurrency: 2\n",
+		"type: GCS\nconfig:\n  bucket: test\nuploads:\n  concurrency: 2\n",
+		"type: S3\nconfig:\n  bucket: test\n  endpoint: " + u.Host + "\nuploads:\n  concurrency: -1\n",
+	} {
+		_, err = NewBucket(log.NewNopLogger(), []byte(conf), nil, "test")
+		testutil.NotOk(t, err)
+	}
+}
+
+// fakeS3ResumableMultipart serves the multipart uploads of S3 with the listing of incomplete uploads and their parts,
+// failing the upload of the part failPart once.
+type fakeS3ResumableMultipart struct {
+	mtx         sync.Mutex
+	uploads     map[string]map[int][]byte
+	objects     map[string][]byte
+	failPart    int
+	partUploads []int
+}
+
+func (f *fakeS3ResumableMultipart) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	query := r.URL.Query()
+	key := strings.TrimPrefix(r.URL.Path, "/test/")
+	switch {
+	case r.Method == http.MethodGet && query.Has("uploads"):
+		_, _ = fmt.Fprint(w, `<ListMultipartUploadsResult>`)
+		for id := range f.uploads {
+			_, _ = fmt.Fprintf(w, `<Upload><Key>%s</Key><UploadId>%s</UploadId><Initiated>2020-01-01T00:00:00.000Z</Initiated></Upload>`, query.Get("prefix"), id)
+		}
+		_, _ = fmt.Fprint(w, `</ListMultipartUploadsResult>`)
+	case r.Method == http.MethodGet && query.Has("uploadId"):
+		_, _ = fmt.Fprint(w, `<ListPartsResult>`)
+		for num, part := range f.uploads[query.Get("uploadId")] {
+			sum := md5.Sum(part)
+			_, _ = fmt.Fprintf(w, `<Part><PartNumber>%d</PartNumber><ETag>"%s"</ETag><Size>%d</Size></Part>`, num, hex.EncodeToString(sum[:]), len(part))
+		}
+		_, _ = fmt.Fprint(w, `</ListPartsResult>`)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id := fmt.Sprint(len(f.uploads) + 1)
+		f.uploads[id] = map[int][]byte{}
+		_, _ = fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>test</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, key, id)
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		var num int
+		_, _ = fmt.Sscan(query.Get("partNumber"), &num)
+		f.partUploads = append(f.partUploads, num)
+		body, err := readAWSChunked(r)
+		if err != nil || num == f.failPart {
+			f.failPart = 0
+			http.Error(w, "part upload failed", http.StatusBadRequest)
+			return
+		}
+		f.uploads[query.Get("uploadId")][num] = body
+		sum := md5.Sum(body)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		parts := f.uploads[query.Get("uploadId")]
+		var content []byte
+		for num := 1; num <= len(parts); num++ {
+			content = append(content, parts[num]...)
+		}
+		f.objects[key] = content
+		delete(f.uploads, query.Get("uploadId"))
+		_, _ = fmt.Fprintf(w, `<CompleteMultipartUploadResult><Bucket>test</Bucket><Key>%s</Key><ETag>"etag"</ETag></CompleteMultipartUploadResult>`, key)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestS3UploadsBucket_ResumableUploads(t *testing.T) {
+	fake := &fakeS3ResumableMultipart{uploads: map[string]map[int][]byte{}, objects: map[string][]byte{}, failPart: 3}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	testutil.Ok(t, err)
+
+	bkt, err := NewBucket(log.NewNopLogger(), []byte(`type: S3
+config:
+  bucket: test
+  endpoint: `+u.Host+`
+  region: us-east-1
+  insecure: true
+  access_key: key
+  secret_key: secret
+uploads:
+  part_size: 5MiB
+  concurrency: 1
+`), nil, "test")
+	testutil.Ok(t, err)
+	ctx := WithResumableUploads(context.Background())
+
+	// The failed multipart upload is kept to be resumed.
+	content := bytes.Repeat([]byte("a"), 12*1024*1024)
+	testutil.NotOk(t, bkt.Upload(ctx, "obj", bytes.NewReader(content)))
+	testutil.Equals(t, []int{1, 2, 3}, fake.partUploads)
+	testutil.Equals(t, 1, len(fake.uploads))
+
+	// Only the failed part and the parts which changed since are uploaded when resuming.
+	content[6*1024*1024] = 'b'
+	fake.partUploads = nil
+	testutil.Ok(t, bkt.Upload(ctx, "obj", bytes.NewReader(content)))
+	testutil.Equals(t, []int{2, 3}, fake.partUploads)
+	testutil.Equals(t, content, fake.objects["obj"])
+	testutil.Equals(t, 0, len(fake.uploads))
+}
+
+// readAWSChunked reads the body of the request, decoding the chunks of streaming signed payloads.
+func readAWSChunked(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil || r.Header.Get("X-Amz-Content-Sha256") != "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" {
+		return body, err
+	}
+	var content []byte
+	for {
+		header, rest, ok := bytes.Cut(body, []byte("\r\n"))
+		if !ok {
+			return nil, errors.New("malformed chunk")
+		}
+		size, _, _ := bytes.Cut(header, []byte(";"))
+		n, err := strconv.ParseInt(string(size), 16, 64)
+		if err != nil || int64(len(rest)) < n+2 {
+			return nil, errors.New("malformed chunk")
+		}
+		if n == 0 {
+			return content, nil
+		}
+		content = append(content, rest[:n]...)
+		body = rest[n+2:]
+	}
+}
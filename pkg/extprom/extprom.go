@@ -3,7 +3,12 @@
 
 package extprom
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"crypto/md5"
+	"encoding/binary"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
 
 // WrapRegistererWithPrefix is like prometheus.WrapRegistererWithPrefix but it passes nil straight through
 // which allows nil check.
@@ -22,3 +27,13 @@ func WrapRegistererWith(labels prometheus.Labels, reg prometheus.Registerer) pro
 	}
 	return prometheus.WrapRegistererWith(labels, reg)
 }
+
+// HashAsMetricValue generates metric value from hash of data, e.g. to expose the hash of a configuration file.
+func HashAsMetricValue(data []byte) float64 {
+	sum := md5.Sum(data)
+	// We only want 48 bits as a float64 only has a 53 bit mantissa.
+	smallSum := sum[0:6]
+	var bytes = make([]byte, 8)
+	copy(bytes, smallSum)
+	return float64(binary.LittleEndian.Uint64(bytes))
+}
@@ -9,11 +9,11 @@ import (
 	"strings"
 	"time"
 
-	"github.com/opentracing/opentracing-go"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/uber/jaeger-client-go"
-	"go.opentelemetry.io/otel/trace"
+
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
+	"github.com/thanos-io/thanos/pkg/tracing"
 )
 
 // InstrumentationMiddleware holds necessary metrics to instrument an http.Server
@@ -76,40 +76,19 @@ func httpInstrumentationHandler(baseLabels prometheus.Labels, metrics *defaultMe
 
 						requestLabels := prometheus.Labels{"code": wd.Status(), "method": strings.ToLower(r.Method)}
 						observer := metrics.requestDuration.MustCurryWith(baseLabels).With(requestLabels)
-						observer.Observe(time.Since(now).Seconds())
-
-						// If we find a tracingID we'll expose it as Exemplar.
-						var (
-							traceID string
-							OTfound bool
-						)
-
-						span := opentracing.SpanFromContext(r.Context())
-						if span != nil {
-							spanCtx, ok := span.Context().(jaeger.SpanContext)
-							if ok && spanCtx.IsSampled() {
-								traceID = spanCtx.TraceID().String()
-							}
-
-							OTfound = ok
-						}
 
-						// If OpenTracing span not found, try OTEL.
-						if !OTfound {
-							span := trace.SpanFromContext(r.Context())
-							if span != nil && span.SpanContext().IsSampled() {
-								traceID = span.SpanContext().TraceID().String()
-							}
+						// If we find a sampled trace, we'll expose its ID, and the ID of the request identifying
+						// the query, as exemplar.
+						traceID, ok := tracing.TraceIDFromContext(r.Context())
+						if !ok {
+							observer.Observe(time.Since(now).Seconds())
+							return
 						}
-
-						if traceID != "" {
-							observer.(prometheus.ExemplarObserver).ObserveWithExemplar(
-								time.Since(now).Seconds(),
-								prometheus.Labels{
-									"traceID": traceID,
-								},
-							)
+						exemplar := prometheus.Labels{"traceID": traceID}
+						if reqID := r.Header.Get(middleware.RequestIDHeader); reqID != "" {
+							exemplar["requestID"] = reqID
 						}
+						observer.(prometheus.ExemplarObserver).ObserveWithExemplar(time.Since(now).Seconds(), exemplar)
 					}),
 				),
 			),
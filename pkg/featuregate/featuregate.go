@@ -0,0 +1,398 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package featuregate implements the feature gates of Thanos components, which enable experimental features. Gates are
+// enabled by the --enable-feature flag and, for the gates which can safely change while the component runs, by the
+// runtime configuration or the feature gates HTTP API.
+package featuregate
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/thanos-io/thanos/pkg/api"
+	"github.com/thanos-io/thanos/pkg/extkingpin"
+)
+
+// APIPath is the path of the HTTP endpoint serving and changing the feature gates.
+const APIPath = "/api/v1/status/feature-gates"
+
+const (
+	// QueryPushdown is the feature gate of the query pushdown of the querier.
+	QueryPushdown = "query-pushdown"
+	// NativeHistograms is the feature gate of the ingestion of native histograms by the receiver.
+	NativeHistograms = "native-histograms"
+	// PostingsS2Encoding is the feature gate of the S2 compression of postings cached by the store gateway.
+	PostingsS2Encoding = "postings-s2-encoding"
+)
+
+// Sources of the state of a feature gate.
+const (
+	SourceDefault       = "default"
+	SourceFlag          = "flag"
+	SourceRuntimeConfig = "runtime-config"
+	SourceAPI           = "api"
+)
+
+// Gate is a feature gate.
+type Gate struct {
+	// Name is the name of the gate, given to the --enable-feature flag.
+	Name string
+	// Description describes the feature enabled by the gate.
+	Description string
+	// Components are the components which implement the feature.
+	Components []string
+	// Runtime is whether the gate can be changed while the component runs.
+	Runtime bool
+}
+
+var gates = []Gate{
+	{
+		Name:        NativeHistograms,
+		Description: "Ingestion of native histograms by all tenants, like --tsdb.enable-native-histograms.",
+		Components:  []string{"receive"},
+		Runtime:     true,
+	},
+	{
+		Name:        PostingsS2Encoding,
+		Description: "S2 compression of the postings stored in the index cache, producing smaller entries still readable by all store gateways, at the cost of more CPU.",
+		Components:  []string{"store"},
+		Runtime:     true,
+	},
+	{
+		Name:        QueryPushdown,
+		Description: "Pushdown of some PromQL functions to the StoreAPI components.",
+		Components:  []string{"query"},
+		Runtime:     true,
+	},
+}
+
+// removedFeatures are the features which are permanently enabled, and whose name is ignored by --enable-feature.
+var removedFeatures = map[string]struct{}{
+	"promql-at-modifier":     {},
+	"promql-negative-offset": {},
+}
+
+// Lookup returns the feature gate of the given name.
+func Lookup(name string) (Gate, bool) {
+	for _, g := range gates {
+		if g.Name == name {
+			return g, true
+		}
+	}
+	return Gate{}, false
+}
+
+// Names returns the names of all feature gates.
+func Names() []string {
+	names := make([]string, 0, len(gates))
+	for _, g := range gates {
+		names = append(names, g.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Settings enable or disable feature gates by name, like the feature gates of the runtime configuration.
+type Settings map[string]bool
+
+// Validate returns an error if a gate is unknown or cannot be changed at runtime. Gates which are not implemented by the
+// component are ignored by it, so that components can share the settings.
+func (s Settings) Validate() error {
+	for name := range s {
+		gate, ok := Lookup(name)
+		if !ok {
+			return errors.Errorf("unknown feature gate %q, expected one of %v", name, Names())
+		}
+		if !gate.Runtime {
+			return errors.Errorf("feature gate %q cannot be changed at runtime", name)
+		}
+	}
+	return nil
+}
+
+// Status is the state of a feature gate.
+type Status struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Runtime     bool   `json:"runtime"`
+	Enabled     bool   `json:"enabled"`
+	// Source is what sets the state of the gate: default, flag, runtime-config or api.
+	Source string `json:"source"`
+}
+
+// Gates are the feature gates of a component. The state of a gate is given, by order of precedence, by the API, the
+// runtime configuration, then the --enable-feature flag. Gates which cannot change at runtime are only given by the flag.
+type Gates struct {
+	features  []string
+	enableAPI bool
+
+	logger       log.Logger
+	gates        []Gate
+	flags        map[string]bool
+	runtimeGates func() Settings
+
+	// updateMtx serializes updates, so that listeners see the changes in order.
+	updateMtx sync.Mutex
+	mtx       sync.Mutex
+	overrides map[string]bool
+	enabled   map[string]bool
+	listeners map[string][]func(enabled bool)
+
+	enabledGauge *prometheus.GaugeVec
+	changes      *prometheus.CounterVec
+}
+
+// New returns new feature gates, all disabled until Init is called.
+func New() *Gates {
+	return &Gates{
+		logger:    log.NewNopLogger(),
+		flags:     map[string]bool{},
+		overrides: map[string]bool{},
+		enabled:   map[string]bool{},
+		listeners: map[string][]func(bool){},
+	}
+}
+
+// RegisterFlags registers the flags of the feature gates.
+func (g *Gates) RegisterFlags(cmd extkingpin.FlagClause) {
+	cmd.Flag("enable-feature", "Comma separated experimental feature names to enable (repeated). The current list of features is "+strings.Join(Names(), ", ")+". See https://thanos.io/tip/operating/feature-gates.md").
+		PlaceHolder("<feature>").StringsVar(&g.features)
+	cmd.Flag("feature-gates.enable-api", "[EXPERIMENTAL] Enable the PUT and DELETE methods of the feature gates API, which change feature gates while the component runs. Only GET is served otherwise.").
+		Default("false").BoolVar(&g.enableAPI)
+}
+
+// Init sets up the feature gates of the given component from the flags, and registers their metrics. The state of the
+// gates given by the runtime configuration is returned by runtimeGates, and applied by Update.
+func (g *Gates) Init(logger log.Logger, reg prometheus.Registerer, component string, runtimeGates func() Settings) error {
+	g.logger = logger
+	g.runtimeGates = runtimeGates
+
+	for _, gate := range gates {
+		for _, c := range gate.Components {
+			if c == component {
+				g.gates = append(g.gates, gate)
+			}
+		}
+	}
+
+	for _, features := range g.features {
+		for _, feature := range strings.Split(features, ",") {
+			feature = strings.TrimSpace(feature)
+			if feature == "" {
+				continue
+			}
+			if _, ok := removedFeatures[feature]; ok {
+				level.Warn(logger).Log("msg", "This option for --enable-feature is now permanently enabled and therefore a no-op.", "option", feature)
+				continue
+			}
+			if _, ok := Lookup(feature); !ok {
+				return errors.Errorf("unknown feature %q given to --enable-feature, expected one of %v", feature, Names())
+			}
+			if _, ok := g.gate(feature); !ok {
+				level.Warn(logger).Log("msg", "feature given to --enable-feature is not implemented by this component, ignoring it", "feature", feature, "component", component)
+				continue
+			}
+			g.flags[feature] = true
+		}
+	}
+
+	g.enabledGauge = promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "thanos_feature_gate_enabled",
+		Help: "Whether the feature gate is enabled.",
+	}, []string{"name"})
+	g.changes = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_feature_gate_changes_total",
+		Help: "The number of times the feature gate was enabled or disabled while the component was running.",
+	}, []string{"name"})
+
+	g.Update()
+	return nil
+}
+
+func (g *Gates) gate(name string) (Gate, bool) {
+	for _, gate := range g.gates {
+		if gate.Name == name {
+			return gate, true
+		}
+	}
+	return Gate{}, false
+}
+
+// Enabled returns whether the feature gate of the given name is enabled.
+func (g *Gates) Enabled(name string) bool {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	return g.enabled[name]
+}
+
+// OnChange registers a function called with the new state of the feature gate of the given name whenever it changes.
+func (g *Gates) OnChange(name string, f func(enabled bool)) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	g.listeners[name] = append(g.listeners[name], f)
+}
+
+// Update applies the state of the gates given by the runtime configuration. It must be called when it changes.
+func (g *Gates) Update() {
+	g.updateMtx.Lock()
+	defer g.updateMtx.Unlock()
+
+	var runtimeGates Settings
+	if g.runtimeGates != nil {
+		runtimeGates = g.runtimeGates()
+	}
+
+	type change struct {
+		enabled   bool
+		listeners []func(bool)
+	}
+	var changes []change
+
+	g.mtx.Lock()
+	for _, gate := range g.gates {
+		s := g.status(gate, runtimeGates)
+		if prev, ok := g.enabled[gate.Name]; ok && prev != s.Enabled {
+			level.Info(g.logger).Log("msg", "feature gate changed", "name", gate.Name, "enabled", s.Enabled, "source", s.Source)
+			g.changes.WithLabelValues(gate.Name).Inc()
+			changes = append(changes, change{enabled: s.Enabled, listeners: g.listeners[gate.Name]})
+		}
+		g.enabled[gate.Name] = s.Enabled
+		g.enabledGauge.WithLabelValues(gate.Name).Set(boolToFloat(s.Enabled))
+	}
+	g.mtx.Unlock()
+
+	// Listeners are called without the lock, so that they can check the gates.
+	for _, c := range changes {
+		for _, f := range c.listeners {
+			f(c.enabled)
+		}
+	}
+}
+
+func (g *Gates) status(gate Gate, runtimeGates Settings) Status {
+	s := Status{Name: gate.Name, Description: gate.Description, Runtime: gate.Runtime, Source: SourceDefault}
+	if g.flags[gate.Name] {
+		s.Enabled, s.Source = true, SourceFlag
+	}
+	if !gate.Runtime {
+		return s
+	}
+	if enabled, ok := runtimeGates[gate.Name]; ok {
+		s.Enabled, s.Source = enabled, SourceRuntimeConfig
+	}
+	if enabled, ok := g.overrides[gate.Name]; ok {
+		s.Enabled, s.Source = enabled, SourceAPI
+	}
+	return s
+}
+
+// Statuses returns the state of the feature gates of the component, sorted by name.
+func (g *Gates) Statuses() []Status {
+	var runtimeGates Settings
+	if g.runtimeGates != nil {
+		runtimeGates = g.runtimeGates()
+	}
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	statuses := make([]Status, 0, len(g.gates))
+	for _, gate := range g.gates {
+		statuses = append(statuses, g.status(gate, runtimeGates))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Set enables or disables the feature gate of the given name until the component restarts, regardless of the flags and
+// runtime configuration.
+func (g *Gates) Set(name string, enabled bool) error {
+	if err := g.checkRuntime(name); err != nil {
+		return err
+	}
+	g.mtx.Lock()
+	g.overrides[name] = enabled
+	g.mtx.Unlock()
+
+	g.Update()
+	return nil
+}
+
+// Reset removes the state of the feature gate of the given name set by Set.
+func (g *Gates) Reset(name string) error {
+	if err := g.checkRuntime(name); err != nil {
+		return err
+	}
+	g.mtx.Lock()
+	delete(g.overrides, name)
+	g.mtx.Unlock()
+
+	g.Update()
+	return nil
+}
+
+func (g *Gates) checkRuntime(name string) error {
+	if _, ok := Lookup(name); !ok {
+		return errors.Errorf("unknown feature gate %q, expected one of %v", name, Names())
+	}
+	gate, ok := g.gate(name)
+	if !ok {
+		return errors.Errorf("feature gate %q is not implemented by this component", name)
+	}
+	if !gate.Runtime {
+		return errors.Errorf("feature gate %q cannot be changed at runtime", name)
+	}
+	return nil
+}
+
+// ServeHTTP serves the state of the feature gates on GET requests. If the API is enabled by the feature-gates.enable-api
+// flag, PUT requests enable or disable the gate given by the name parameter according to the enabled parameter, and
+// DELETE requests reset it to the state given by the flags and runtime configuration.
+func (g *Gates) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && !g.enableAPI {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var enabled bool
+		enabled, err = strconv.ParseBool(r.FormValue("enabled"))
+		if err != nil {
+			err = errors.Wrap(err, "parsing enabled parameter")
+			break
+		}
+		err = g.Set(r.FormValue("name"), enabled)
+	case http.MethodDelete:
+		err = g.Reset(r.FormValue("name"))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		api.RespondError(w, &api.ApiError{Typ: api.ErrorBadData, Err: err}, nil)
+		return
+	}
+	api.Respond(w, g.Statuses(), nil)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
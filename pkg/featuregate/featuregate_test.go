@@ -0,0 +1,139 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package featuregate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func newGates(t *testing.T, component string, args ...string) (*Gates, *Settings) {
+	g := New()
+	app := kingpin.New("test", "")
+	g.RegisterFlags(app)
+	_, err := app.Parse(args)
+	testutil.Ok(t, err)
+
+	runtimeGates := Settings{}
+	testutil.Ok(t, g.Init(log.NewNopLogger(), prometheus.NewRegistry(), component, func() Settings { return runtimeGates }))
+	return g, &runtimeGates
+}
+
+func TestGates(t *testing.T) {
+	g, runtimeGates := newGates(t, "query", "--enable-feature=query-pushdown,promql-at-modifier", "--enable-feature=native-histograms")
+	testutil.Assert(t, g.Enabled(QueryPushdown), "query pushdown should be enabled by flag")
+	// Gates of other components are ignored.
+	testutil.Assert(t, !g.Enabled(NativeHistograms), "native histograms should be ignored by the querier")
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(g.enabledGauge.WithLabelValues(QueryPushdown)))
+
+	var changes []bool
+	g.OnChange(QueryPushdown, func(enabled bool) { changes = append(changes, enabled) })
+
+	// The runtime configuration overrides the flag.
+	(*runtimeGates)[QueryPushdown] = false
+	g.Update()
+	testutil.Assert(t, !g.Enabled(QueryPushdown), "query pushdown should be disabled by the runtime configuration")
+	testutil.Equals(t, SourceRuntimeConfig, g.Statuses()[0].Source)
+
+	// The API overrides the runtime configuration, until it is reset.
+	testutil.Ok(t, g.Set(QueryPushdown, true))
+	testutil.Assert(t, g.Enabled(QueryPushdown), "query pushdown should be enabled by the API")
+	testutil.Equals(t, SourceAPI, g.Statuses()[0].Source)
+	testutil.Ok(t, g.Reset(QueryPushdown))
+	testutil.Assert(t, !g.Enabled(QueryPushdown), "query pushdown should be disabled by the runtime configuration")
+
+	delete(*runtimeGates, QueryPushdown)
+	g.Update()
+	testutil.Equals(t, SourceFlag, g.Statuses()[0].Source)
+	testutil.Equals(t, []bool{false, true, false, true}, changes)
+	testutil.Equals(t, 4.0, promtestutil.ToFloat64(g.changes.WithLabelValues(QueryPushdown)))
+
+	testutil.NotOk(t, g.Set(NativeHistograms, true))
+	testutil.NotOk(t, g.Set("unknown", true))
+}
+
+func TestGates_NotRuntime(t *testing.T) {
+	defer func(prev []Gate) { gates = prev }(gates)
+	gates = append(gates, Gate{Name: "static", Components: []string{"store"}})
+
+	g, runtimeGates := newGates(t, "store")
+	(*runtimeGates)["static"] = true
+	g.Update()
+	testutil.Assert(t, !g.Enabled("static"), "gate which cannot change at runtime should only be enabled by flag")
+	testutil.NotOk(t, g.Set("static", true))
+}
+
+func TestGates_UnknownFeature(t *testing.T) {
+	g := New()
+	app := kingpin.New("test", "")
+	g.RegisterFlags(app)
+	_, err := app.Parse([]string{"--enable-feature=unknown"})
+	testutil.Ok(t, err)
+	testutil.NotOk(t, g.Init(log.NewNopLogger(), prometheus.NewRegistry(), "query", nil))
+}
+
+func TestSettings_Validate(t *testing.T) {
+	testutil.Ok(t, Settings{QueryPushdown: true, NativeHistograms: false}.Validate())
+	testutil.NotOk(t, Settings{"unknown": true}.Validate())
+
+	defer func(prev []Gate) { gates = prev }(gates)
+	gates = append(gates, Gate{Name: "static", Components: []string{"store"}})
+	testutil.NotOk(t, Settings{"static": true}.Validate())
+}
+
+func TestGates_ServeHTTP(t *testing.T) {
+	g, _ := newGates(t, "store", "--feature-gates.enable-api")
+
+	serve := func(method, target string) (int, []Status) {
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+
+		var resp struct {
+			Data []Status `json:"data"`
+		}
+		testutil.Ok(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return w.Code, resp.Data
+	}
+
+	code, statuses := serve(http.MethodGet, APIPath)
+	testutil.Equals(t, http.StatusOK, code)
+	testutil.Equals(t, []Status{{Name: PostingsS2Encoding, Description: statuses[0].Description, Runtime: true, Source: SourceDefault}}, statuses)
+
+	code, statuses = serve(http.MethodPut, APIPath+"?name=postings-s2-encoding&enabled=true")
+	testutil.Equals(t, http.StatusOK, code)
+	testutil.Assert(t, statuses[0].Enabled, "gate should be enabled")
+	testutil.Assert(t, g.Enabled(PostingsS2Encoding), "gate should be enabled")
+
+	code, _ = serve(http.MethodPut, APIPath+"?name=postings-s2-encoding&enabled=maybe")
+	testutil.Equals(t, http.StatusBadRequest, code)
+	code, _ = serve(http.MethodPut, APIPath+"?name=query-pushdown&enabled=true")
+	testutil.Equals(t, http.StatusBadRequest, code)
+
+	code, statuses = serve(http.MethodDelete, APIPath+"?name=postings-s2-encoding")
+	testutil.Equals(t, http.StatusOK, code)
+	testutil.Assert(t, !statuses[0].Enabled, "gate should be disabled")
+}
+
+func TestGates_ServeHTTP_APIDisabled(t *testing.T) {
+	g, _ := newGates(t, "store")
+
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, httptest.NewRequest(method, APIPath+"?name=postings-s2-encoding&enabled=true", nil))
+		testutil.Equals(t, http.StatusMethodNotAllowed, w.Code)
+	}
+	testutil.Assert(t, !g.Enabled(PostingsS2Encoding), "gate should not be changed")
+
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, APIPath, nil))
+	testutil.Equals(t, http.StatusOK, w.Code)
+}
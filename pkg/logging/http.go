@@ -5,7 +5,9 @@ package logging
 
 import (
 	"fmt"
+	"math/rand"
 	"net"
+	"os"
 	"sort"
 	"strings"
 
@@ -16,8 +18,38 @@ import (
 	"github.com/go-kit/log/level"
 
 	httputil "github.com/thanos-io/thanos/pkg/server/http"
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
+	"github.com/thanos-io/thanos/pkg/tracing"
 )
 
+// Fields of the HTTP finish call logs.
+const (
+	HTTPFieldMethod     = "method"
+	HTTPFieldRequestID  = "request_id"
+	HTTPFieldStatus     = "status"
+	HTTPFieldDuration   = "duration"
+	HTTPFieldRemoteAddr = "remote_addr"
+	HTTPFieldName       = "name"
+	HTTPFieldBytes      = "bytes"
+	HTTPFieldTenant     = "tenant"
+	HTTPFieldTraceID    = "trace_id"
+)
+
+// DefaultHTTPFields are the fields of the HTTP finish call logs if no fields are selected.
+var DefaultHTTPFields = []string{HTTPFieldMethod, HTTPFieldRequestID, HTTPFieldStatus, HTTPFieldDuration, HTTPFieldRemoteAddr, HTTPFieldName}
+
+var httpFields = map[string]struct{}{
+	HTTPFieldMethod:     {},
+	HTTPFieldRequestID:  {},
+	HTTPFieldStatus:     {},
+	HTTPFieldDuration:   {},
+	HTTPFieldRemoteAddr: {},
+	HTTPFieldName:       {},
+	HTTPFieldBytes:      {},
+	HTTPFieldTenant:     {},
+	HTTPFieldTraceID:    {},
+}
+
 type HTTPServerMiddleware struct {
 	opts   *options
 	logger log.Logger
@@ -25,18 +57,52 @@ type HTTPServerMiddleware struct {
 
 func (m *HTTPServerMiddleware) preCall(name string, start time.Time, r *http.Request) {
 	logger := m.opts.filterLog(m.logger)
-	level.Debug(logger).Log("http.start_time", start.String(), "http.method", fmt.Sprintf("%s %s", r.Method, r.URL), "http.request_id", r.Header.Get("X-Request-ID"), "thanos.method_name", name, "msg", "started call")
+	level.Debug(logger).Log("http.start_time", start.String(), "http.method", fmt.Sprintf("%s %s", r.Method, r.URL), "http.request_id", requestID(r), "thanos.method_name", name, "msg", "started call")
 }
 
 func (m *HTTPServerMiddleware) postCall(name string, start time.Time, wrapped *httputil.ResponseWriterWithStatus, r *http.Request) {
 	status := wrapped.Status()
-	logger := log.With(m.logger, "http.method", fmt.Sprintf("%s %s", r.Method, r.URL), "http.request_id", r.Header.Get("X-Request-ID"), "http.status_code", fmt.Sprintf("%d", status),
-		"http.time_ms", fmt.Sprintf("%v", durationToMilliseconds(time.Since(start))), "http.remote_addr", r.RemoteAddr, "thanos.method_name", name)
+	keyvals := make([]interface{}, 0, 2*len(m.opts.httpFields))
+	for _, field := range m.opts.httpFields {
+		switch field {
+		case HTTPFieldMethod:
+			keyvals = append(keyvals, "http.method", fmt.Sprintf("%s %s", r.Method, r.URL))
+		case HTTPFieldRequestID:
+			keyvals = append(keyvals, "http.request_id", requestID(r))
+		case HTTPFieldStatus:
+			keyvals = append(keyvals, "http.status_code", fmt.Sprintf("%d", status))
+		case HTTPFieldDuration:
+			keyvals = append(keyvals, "http.time_ms", fmt.Sprintf("%v", durationToMilliseconds(time.Since(start))))
+		case HTTPFieldRemoteAddr:
+			keyvals = append(keyvals, "http.remote_addr", r.RemoteAddr)
+		case HTTPFieldName:
+			keyvals = append(keyvals, "thanos.method_name", name)
+		case HTTPFieldBytes:
+			keyvals = append(keyvals, "http.response_bytes", wrapped.BytesWritten())
+		case HTTPFieldTenant:
+			if m.opts.tenantFunc != nil {
+				keyvals = append(keyvals, "http.tenant", m.opts.tenantFunc(r))
+			}
+		case HTTPFieldTraceID:
+			if traceID, ok := tracing.TraceIDFromContext(r.Context()); ok {
+				keyvals = append(keyvals, "http.trace_id", traceID)
+			}
+		}
+	}
+	logger := log.With(m.logger, keyvals...)
 
 	logger = m.opts.filterLog(logger)
 	m.opts.levelFunc(logger, status).Log("msg", "finished call")
 }
 
+// requestID returns the request ID of the request given by the request ID middleware, or its header.
+func requestID(r *http.Request) string {
+	if id, ok := middleware.RequestIDFromContext(r.Context()); ok {
+		return id
+	}
+	return r.Header.Get(middleware.RequestIDHeader)
+}
+
 func (m *HTTPServerMiddleware) HTTPMiddleware(name string, next http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		wrapped := httputil.WrapResponseWriterWithStatus(w)
@@ -58,11 +124,19 @@ func (m *HTTPServerMiddleware) HTTPMiddleware(name string, next http.Handler) ht
 			}
 		}
 
-		deciderURL := r.URL.String()
+		deciderURL := r.URL.Path
 		if len(port) > 0 {
 			deciderURL = net.JoinHostPort(deciderURL, port)
 		}
 		decision := m.opts.shouldLog(deciderURL, nil)
+		if decision != NoLogCall && !m.opts.sample(deciderURL) {
+			// Failed calls are logged even if they were not sampled.
+			next.ServeHTTP(wrapped, r)
+			if wrapped.Status() >= http.StatusInternalServerError {
+				m.postCall(name, start, wrapped, r)
+			}
+			return
+		}
 
 		switch decision {
 		case NoLogCall:
@@ -83,6 +157,12 @@ func (m *HTTPServerMiddleware) HTTPMiddleware(name string, next http.Handler) ht
 // NewHTTPServerMiddleware returns an http middleware.
 func NewHTTPServerMiddleware(logger log.Logger, opts ...Option) *HTTPServerMiddleware {
 	o := evaluateOpt(opts)
+	switch o.format {
+	case LogFormatLogfmt:
+		logger = log.With(log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr)), "ts", log.DefaultTimestampUTC)
+	case LogFormatJSON:
+		logger = log.With(log.NewJSONLogger(log.NewSyncWriter(os.Stderr)), "ts", log.DefaultTimestampUTC)
+	}
 	return &HTTPServerMiddleware{
 		logger: log.With(logger, "protocol", "http", "http.component", "server"),
 		opts:   o,
@@ -157,12 +237,17 @@ func NewHTTPOption(configYAML []byte) ([]Option, error) {
 		return logOpts, err
 	}
 
-	logOpts = []Option{
+	httpOpts, err := newHTTPAccessLogOptions(reqLogConfig.HTTP)
+	if err != nil {
+		return logOpts, err
+	}
+
+	logOpts = append([]Option{
 		WithFilter(func(logger log.Logger) log.Logger {
 			return level.NewFilter(logger, getLevel(globalLevel))
 		}),
 		WithLevels(DefaultCodeToLevel),
-	}
+	}, httpOpts...)
 
 	if len(reqLogConfig.HTTP.Config) == 0 {
 		logOpts = append(logOpts, []Option{WithDecider(func(_ string, err error) Decision {
@@ -193,3 +278,62 @@ func NewHTTPOption(configYAML []byte) ([]Option, error) {
 	return logOpts, nil
 
 }
+
+// newHTTPAccessLogOptions returns the options for the format, fields and sample rates of the HTTP request logs.
+func newHTTPAccessLogOptions(cfg HTTPProtocolConfigs) ([]Option, error) {
+	var opts []Option
+
+	switch cfg.Format {
+	case "":
+	case LogFormatLogfmt, LogFormatJSON:
+		opts = append(opts, WithFormat(cfg.Format))
+	default:
+		return nil, fmt.Errorf("the format of HTTP request logs is invalid. Expected %s/%s, got this %v", LogFormatLogfmt, LogFormatJSON, cfg.Format)
+	}
+
+	if len(cfg.Fields) > 0 {
+		for _, field := range cfg.Fields {
+			if _, ok := httpFields[field]; !ok {
+				return nil, fmt.Errorf("unknown HTTP request log field %v", field)
+			}
+		}
+		opts = append(opts, WithHTTPFields(cfg.Fields...))
+	}
+
+	globalRate := 1.0
+	if cfg.SampleRate != nil {
+		globalRate = *cfg.SampleRate
+	}
+	if err := validateSampleRate(globalRate); err != nil {
+		return nil, err
+	}
+	rates := map[string]float64{}
+	for _, eachConfig := range cfg.Config {
+		if eachConfig.SampleRate == nil {
+			continue
+		}
+		if err := validateSampleRate(*eachConfig.SampleRate); err != nil {
+			return nil, err
+		}
+		rates[fmt.Sprintf("%v:%v", eachConfig.Path, eachConfig.Port)] = *eachConfig.SampleRate
+	}
+	if globalRate == 1 && len(rates) == 0 {
+		return opts, nil
+	}
+
+	opts = append(opts, WithSampler(func(methodName string) bool {
+		rate, ok := rates[methodName]
+		if !ok {
+			rate = globalRate
+		}
+		return rate >= 1 || rand.Float64() < rate
+	}))
+	return opts, nil
+}
+
+func validateSampleRate(rate float64) error {
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("the sample rate of HTTP request logs is invalid. Expected a value between 0 and 1, got this %v", rate)
+	}
+	return nil
+}
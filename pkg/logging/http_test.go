@@ -80,3 +80,58 @@ func TestHTTPServerMiddleware(t *testing.T) {
 	testutil.Equals(t, "Test Works", string(body))
 	testutil.Assert(t, !strings.Contains(b.String(), "err="))
 }
+
+func TestHTTPServerMiddleware_FieldsAndSampling(t *testing.T) {
+	opts, err := NewHTTPOption([]byte(`
+http:
+  options:
+    level: DEBUG
+    decision:
+      log_start: false
+      log_end: true
+  fields: [status, bytes, tenant]
+  config:
+    - path: /api/v1/query
+      port: 10904
+    - path: /api/v1/query_range
+      port: 10904
+      sample_rate: 0
+`))
+	testutil.Ok(t, err)
+
+	b := bytes.Buffer{}
+	m := NewHTTPServerMiddleware(log.NewLogfmtLogger(&b), append(opts, WithTenantFunc(func(r *http.Request) string {
+		return r.Header.Get("THANOS-TENANT")
+	}))...)
+	hm := func(status int) http.HandlerFunc {
+		return m.HTTPMiddleware("test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			_, _ = io.WriteString(w, "Test Works")
+		}))
+	}
+
+	req := httptest.NewRequest("GET", "http://example.com:10904/api/v1/query?query=up", nil)
+	req.Header.Set("THANOS-TENANT", "team-a")
+	hm(http.StatusOK)(httptest.NewRecorder(), req)
+	testutil.Equals(t, "protocol=http http.component=server http.status_code=200 http.response_bytes=10 http.tenant=team-a level=debug msg=\"finished call\"\n", b.String())
+
+	// Requests which are not sampled are only logged if they fail.
+	b.Reset()
+	hm(http.StatusOK)(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com:10904/api/v1/query_range?query=up", nil))
+	testutil.Equals(t, "", b.String())
+
+	hm(http.StatusInternalServerError)(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com:10904/api/v1/query_range?query=up", nil))
+	testutil.Assert(t, strings.Contains(b.String(), "http.status_code=500"), "failed request not logged: %s", b.String())
+}
+
+func TestNewHTTPOption_InvalidAccessLogConfig(t *testing.T) {
+	for _, content := range []string{
+		`http: {options: {level: INFO, decision: {log_end: true}}, format: xml}`,
+		`http: {options: {level: INFO, decision: {log_end: true}}, fields: [unknown]}`,
+		`http: {options: {level: INFO, decision: {log_end: true}}, sample_rate: 2}`,
+		`http: {options: {level: INFO, decision: {log_end: true}}, config: [{path: /api/v1/query, port: 10904, sample_rate: -1}]}`,
+	} {
+		_, err := NewHTTPOption([]byte(content))
+		testutil.NotOk(t, err, content)
+	}
+}
@@ -5,9 +5,11 @@ package logging
 
 import (
 	"os"
+	"sync/atomic"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
 )
 
 const (
@@ -21,36 +23,79 @@ const (
 // if the log level is not error, warn, info or debug. Log level is expected to
 // be validated before passed to this function.
 func NewLogger(logLevel, logFormat, debugName string) log.Logger {
-	var (
-		logger log.Logger
-		lvl    level.Option
-	)
-
-	switch logLevel {
-	case "error":
-		lvl = level.AllowError()
-	case "warn":
-		lvl = level.AllowWarn()
-	case "info":
-		lvl = level.AllowInfo()
-	case "debug":
-		lvl = level.AllowDebug()
-	default:
-		// This enum is already checked and enforced by flag validations, so
-		// this should never happen.
-		panic("unexpected log level")
-	}
+	logger, _ := NewLoggerWithLevelSwitch(logLevel, logFormat, debugName)
+	return logger
+}
 
-	logger = log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+// NewLoggerWithLevelSwitch returns a log.Logger like NewLogger, together with
+// the LevelSwitch allowing to change its level at runtime.
+func NewLoggerWithLevelSwitch(logLevel, logFormat, debugName string) (log.Logger, *LevelSwitch) {
+	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
 	if logFormat == LogFormatJSON {
 		logger = log.NewJSONLogger(log.NewSyncWriter(os.Stderr))
 	}
 
-	logger = level.NewFilter(logger, lvl)
+	sw := newLevelSwitch(logger, logLevel)
+	logger = sw
 
 	if debugName != "" {
 		logger = log.With(logger, "name", debugName)
 	}
 
-	return log.With(logger, "ts", log.DefaultTimestampUTC, "caller", log.DefaultCaller)
+	return log.With(logger, "ts", log.DefaultTimestampUTC, "caller", log.DefaultCaller), sw
+}
+
+// LevelSwitch is a log.Logger filtering log lines by a level which can be changed at runtime.
+type LevelSwitch struct {
+	defaultLevel string
+	loggers      map[string]log.Logger
+
+	current atomic.Value // *leveledLogger
+}
+
+type leveledLogger struct {
+	level  string
+	logger log.Logger
+}
+
+func newLevelSwitch(logger log.Logger, logLevel string) *LevelSwitch {
+	s := &LevelSwitch{
+		defaultLevel: logLevel,
+		loggers: map[string]log.Logger{
+			"error": level.NewFilter(logger, level.AllowError()),
+			"warn":  level.NewFilter(logger, level.AllowWarn()),
+			"info":  level.NewFilter(logger, level.AllowInfo()),
+			"debug": level.NewFilter(logger, level.AllowDebug()),
+		},
+	}
+	if err := s.SetLevel(logLevel); err != nil {
+		// This enum is already checked and enforced by flag validations, so
+		// this should never happen.
+		panic("unexpected log level")
+	}
+	return s
+}
+
+// Log implements log.Logger.
+func (s *LevelSwitch) Log(keyvals ...interface{}) error {
+	return s.current.Load().(*leveledLogger).logger.Log(keyvals...)
+}
+
+// SetLevel changes the level to error, warn, info or debug. An empty level
+// restores the level the logger was created with.
+func (s *LevelSwitch) SetLevel(logLevel string) error {
+	if logLevel == "" {
+		logLevel = s.defaultLevel
+	}
+	logger, ok := s.loggers[logLevel]
+	if !ok {
+		return errors.Errorf("unexpected log level %q, expected one of error, warn, info or debug", logLevel)
+	}
+	s.current.Store(&leveledLogger{level: logLevel, logger: logger})
+	return nil
+}
+
+// Level returns the current level.
+func (s *LevelSwitch) Level() string {
+	return s.current.Load().(*leveledLogger).level
 }
@@ -6,6 +6,7 @@ package logging
 import (
 	"fmt"
 	"math/rand"
+	"net/http"
 	"time"
 
 	extflag "github.com/efficientgo/tools/extkingpin"
@@ -35,6 +36,8 @@ var defaultOptions = &options{
 	levelFunc:         DefaultCodeToLevel,
 	durationFieldFunc: DurationToTimeMillisFields,
 	filterLog:         DefaultFilterLogging,
+	sample:            DefaultSampler,
+	httpFields:        DefaultHTTPFields,
 }
 
 func evaluateOpt(opts []Option) *options {
@@ -69,6 +72,35 @@ func WithFilter(f FilterLogging) Option {
 	}
 }
 
+// WithSampler customizes the function for deciding if a request the decider decided to log is logged.
+func WithSampler(f Sampler) Option {
+	return func(o *options) {
+		o.sample = f
+	}
+}
+
+// WithHTTPFields customizes the fields of the HTTP finish call logs.
+func WithHTTPFields(fields ...string) Option {
+	return func(o *options) {
+		o.httpFields = fields
+	}
+}
+
+// WithFormat makes the HTTP Middlewares write their logs to stderr in the given format, logfmt or json, instead of
+// using the logger they were created with.
+func WithFormat(format string) Option {
+	return func(o *options) {
+		o.format = format
+	}
+}
+
+// WithTenantFunc customizes the function returning the tenant of HTTP requests for the tenant field.
+func WithTenantFunc(f TenantFunc) Option {
+	return func(o *options) {
+		o.tenantFunc = f
+	}
+}
+
 // Interface for the additional methods.
 
 // Types for the Options.
@@ -95,6 +127,17 @@ func DefaultDeciderMethod(_ string, _ error) Decision {
 	return LogStartAndFinishCall
 }
 
+// Sampler function decides if the call with the given method name is logged, after the decider decided to log it.
+type Sampler func(methodName string) bool
+
+// DefaultSampler logs all calls.
+func DefaultSampler(_ string) bool {
+	return true
+}
+
+// TenantFunc function returns the tenant of an HTTP request.
+type TenantFunc func(r *http.Request) string
+
 // CodeToLevel function defines the mapping between HTTP Response codes to log levels for server side.
 type CodeToLevel func(logger log.Logger, code int) log.Logger
 
@@ -115,6 +158,10 @@ type options struct {
 	codeFunc          ErrorToCode
 	durationFieldFunc DurationToFields
 	filterLog         FilterLogging
+	sample            Sampler
+	httpFields        []string
+	format            string
+	tenantFunc        TenantFunc
 }
 
 // DefaultCodeToLevel is the helper mapper that maps HTTP Response codes to log levels.
@@ -10,8 +10,11 @@ type RequestConfig struct {
 }
 
 type HTTPProtocolConfigs struct {
-	Options OptionsConfig        `yaml:"options"`
-	Config  []HTTPProtocolConfig `yaml:"config"`
+	Options    OptionsConfig        `yaml:"options"`
+	Config     []HTTPProtocolConfig `yaml:"config"`
+	Format     string               `yaml:"format"`
+	Fields     []string             `yaml:"fields"`
+	SampleRate *float64             `yaml:"sample_rate"`
 }
 
 type GRPCProtocolConfigs struct {
@@ -30,8 +33,9 @@ type DecisionConfig struct {
 }
 
 type HTTPProtocolConfig struct {
-	Path string `yaml:"path"`
-	Port uint64 `yaml:"port"`
+	Path       string   `yaml:"path"`
+	Port       uint64   `yaml:"port"`
+	SampleRate *float64 `yaml:"sample_rate"`
 }
 
 type GRPCProtocolConfig struct {
@@ -0,0 +1,175 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package memlimit sets the memory limit of the Go runtime (GOMEMLIMIT) from the memory limit of the container the
+// process runs in.
+package memlimit
+
+import (
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/thanos-io/thanos/pkg/extkingpin"
+)
+
+// CgroupRoot is where the cgroup hierarchy is mounted.
+const CgroupRoot = "/sys/fs/cgroup"
+
+// unlimited is the threshold above which cgroup v1 memory limits are considered unset. The kernel reports the maximum
+// int64 rounded down to the page size as limit of unlimited cgroups.
+const unlimited = 1 << 62
+
+// Options are the options of Set.
+type Options struct {
+	// HeadroomPercent is the percentage of the container memory limit which is not given to the Go runtime, left for
+	// memory not managed by it, such as memory mapped files.
+	HeadroomPercent float64
+	// GOGC is the garbage collection target percentage set along with the memory limit. 0 leaves it unchanged, and a
+	// negative value turns off the garbage collection triggered by heap growth, leaving it to the memory limit.
+	GOGC int
+}
+
+// Validate returns an error if the options are invalid.
+func (o Options) Validate() error {
+	if o.HeadroomPercent < 0 || o.HeadroomPercent >= 100 {
+		return errors.Errorf("memory limit headroom has to be in [0, 100), got %v", o.HeadroomPercent)
+	}
+	return nil
+}
+
+// Flags are the flags of the memory limit of the Go runtime, registered on the commands of the long-running
+// components.
+type Flags struct {
+	// Enabled is whether the memory limit is set from the container memory limit.
+	Enabled bool
+	Options Options
+}
+
+// RegisterFlags registers the flags of the memory limit on the command of a component.
+func (f *Flags) RegisterFlags(cmd extkingpin.FlagClause) {
+	cmd.Flag("enable-auto-gomemlimit", "Set the memory limit of the Go runtime (GOMEMLIMIT) from the memory limit of the container (cgroup). It is not changed if the GOMEMLIMIT environment variable is set.").
+		Default("false").BoolVar(&f.Enabled)
+	cmd.Flag("auto-gomemlimit.headroom-percent", "Percentage of the container memory limit left out of the memory limit of the Go runtime, for memory not managed by it, when --enable-auto-gomemlimit is set.").
+		Default("10").Float64Var(&f.Options.HeadroomPercent)
+	cmd.Flag("auto-gomemlimit.gogc", "Garbage collection target percentage (GOGC) set along with the memory limit when --enable-auto-gomemlimit is set. 0 leaves it unchanged, and -1 turns off the garbage collection triggered by heap growth, leaving it to the memory limit.").
+		Default("0").IntVar(&f.Options.GOGC)
+}
+
+// setGCPercent is the GOGC set by Set, 0 if it was not changed.
+var setGCPercent int
+
+// Set sets the memory limit of the Go runtime to the memory limit of the container minus the headroom, and GOGC if
+// given. The limit is not changed if the process is not limited by its container, or if it was given by the GOMEMLIMIT
+// environment variable.
+func Set(logger log.Logger, opts Options) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if v, ok := os.LookupEnv("GOMEMLIMIT"); ok {
+		level.Info(logger).Log("msg", "GOMEMLIMIT environment variable is set, not changing the memory limit", "GOMEMLIMIT", v)
+		return nil
+	}
+
+	containerLimit, err := ContainerLimit(CgroupRoot)
+	if err != nil {
+		return errors.Wrap(err, "read container memory limit")
+	}
+	if containerLimit == 0 {
+		level.Info(logger).Log("msg", "no container memory limit found, not changing the memory limit")
+		return nil
+	}
+
+	limit := int64(float64(containerLimit) * (100 - opts.HeadroomPercent) / 100)
+	debug.SetMemoryLimit(limit)
+	if opts.GOGC != 0 {
+		debug.SetGCPercent(opts.GOGC)
+		setGCPercent = opts.GOGC
+	}
+	level.Info(logger).Log("msg", "set memory limit from container memory limit", "container_limit", containerLimit, "limit", limit, "gogc", gcPercent())
+	return nil
+}
+
+// ContainerLimit returns the memory limit of the cgroup of the process, read from the cgroup v2 or v1 hierarchy
+// mounted at root, or 0 if it is not limited.
+func ContainerLimit(root string) (uint64, error) {
+	for _, file := range []string{
+		// cgroup v2.
+		filepath.Join(root, "memory.max"),
+		// cgroup v1.
+		filepath.Join(root, "memory", "memory.limit_in_bytes"),
+	} {
+		b, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		v := strings.TrimSpace(string(b))
+		if v == "max" {
+			return 0, nil
+		}
+		limit, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return 0, errors.Wrapf(err, "parse %s", file)
+		}
+		if limit >= unlimited {
+			return 0, nil
+		}
+		return limit, nil
+	}
+	return 0, nil
+}
+
+// RegisterMetrics registers metrics exposing the container memory limit and the effective memory limit and GOGC of the
+// Go runtime. It is expected to be called after Set.
+func RegisterMetrics(reg prometheus.Registerer) {
+	// Set reports errors reading the limit already.
+	containerLimit, _ := ContainerLimit(CgroupRoot)
+	promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_container_memory_limit_bytes",
+		Help: "Memory limit of the container the process runs in, or 0 if it is not limited.",
+	}).Set(float64(containerLimit))
+	promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "thanos_go_memory_limit_bytes",
+		Help: "Memory limit of the Go runtime (GOMEMLIMIT).",
+	}, func() float64 {
+		// A negative value reads the limit without changing it.
+		return float64(debug.SetMemoryLimit(-1))
+	})
+	promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_go_gc_percent",
+		Help: "Garbage collection target percentage of the Go runtime (GOGC), negative if turned off.",
+	}).Set(float64(gcPercent()))
+}
+
+// gcPercent returns the current GOGC: the one set by Set, otherwise the one the Go runtime read from the GOGC
+// environment variable.
+func gcPercent() int {
+	if setGCPercent != 0 {
+		return setGCPercent
+	}
+	switch v := os.Getenv("GOGC"); v {
+	case "":
+		return 100
+	case "off":
+		return -1
+	default:
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			// The Go runtime uses the default for invalid values.
+			return 100
+		}
+		return p
+	}
+}
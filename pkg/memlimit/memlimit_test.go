@@ -0,0 +1,59 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package memlimit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+)
+
+func TestContainerLimit(t *testing.T) {
+	for _, tcase := range []struct {
+		name     string
+		files    map[string]string
+		expLimit uint64
+		expErr   bool
+	}{
+		{name: "no cgroup"},
+		{name: "cgroup v2", files: map[string]string{"memory.max": "1073741824\n"}, expLimit: 1 << 30},
+		{name: "cgroup v2 without limit", files: map[string]string{"memory.max": "max\n"}},
+		{name: "cgroup v1", files: map[string]string{"memory/memory.limit_in_bytes": "536870912\n"}, expLimit: 1 << 29},
+		{name: "cgroup v1 without limit", files: map[string]string{"memory/memory.limit_in_bytes": "9223372036854771712\n"}},
+		{name: "invalid limit", files: map[string]string{"memory.max": "1G\n"}, expErr: true},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			root := t.TempDir()
+			for name, content := range tcase.files {
+				testutil.Ok(t, os.MkdirAll(filepath.Dir(filepath.Join(root, name)), os.ModePerm))
+				testutil.Ok(t, os.WriteFile(filepath.Join(root, name), []byte(content), os.ModePerm))
+			}
+
+			limit, err := ContainerLimit(root)
+			if tcase.expErr {
+				testutil.NotOk(t, err)
+				return
+			}
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.expLimit, limit)
+		})
+	}
+}
+
+func TestSet_InvalidHeadroom(t *testing.T) {
+	testutil.NotOk(t, Set(log.NewNopLogger(), Options{HeadroomPercent: -1}))
+	testutil.NotOk(t, Set(log.NewNopLogger(), Options{HeadroomPercent: 100}))
+}
+
+func TestGCPercent(t *testing.T) {
+	t.Setenv("GOGC", "")
+	testutil.Equals(t, 100, gcPercent())
+	t.Setenv("GOGC", "off")
+	testutil.Equals(t, -1, gcPercent())
+	t.Setenv("GOGC", "50")
+	testutil.Equals(t, 50, gcPercent())
+}
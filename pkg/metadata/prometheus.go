@@ -5,6 +5,7 @@ package metadata
 
 import (
 	"context"
+	"fmt"
 	"net/url"
 
 	"github.com/thanos-io/thanos/pkg/metadata/metadatapb"
@@ -16,22 +17,27 @@ import (
 type Prometheus struct {
 	base   *url.URL
 	client *promclient.Client
+	cache  *promclient.ResponseCache
 }
 
-// NewPrometheus creates a new metadata.Prometheus.
-func NewPrometheus(base *url.URL, client *promclient.Client) *Prometheus {
+// NewPrometheus creates a new metadata.Prometheus. Responses are cached by the given cache, if not nil.
+func NewPrometheus(base *url.URL, client *promclient.Client, cache *promclient.ResponseCache) *Prometheus {
 	return &Prometheus{
 		base:   base,
 		client: client,
+		cache:  cache,
 	}
 }
 
 // MetricMetadata returns all specified metric metadata from Prometheus.
 func (p *Prometheus) MetricMetadata(r *metadatapb.MetricMetadataRequest, s metadatapb.Metadata_MetricMetadataServer) error {
-	md, err := p.client.MetricMetadataInGRPC(s.Context(), p.base, r.Metric, int(r.Limit))
+	resp, err := p.cache.Get(fmt.Sprintf("%s\x00%d", r.Metric, r.Limit), func() (interface{}, error) {
+		return p.client.MetricMetadataInGRPC(s.Context(), p.base, r.Metric, int(r.Limit))
+	})
 	if err != nil {
 		return err
 	}
+	md := resp.(map[string][]metadatapb.Meta)
 
 	tracing.DoInSpan(s.Context(), "send_metadata_response", func(_ context.Context) {
 		err = s.Send(&metadatapb.MetricMetadataResponse{Result: &metadatapb.MetricMetadataResponse_Metadata{
@@ -71,7 +71,7 @@ scrape_configs:
 		return errors.New("empty metadata response from Prometheus")
 	}))
 
-	grpcClient := NewGRPCClient(NewPrometheus(u, c))
+	grpcClient := NewGRPCClient(NewPrometheus(u, c, nil))
 	for _, tcase := range []struct {
 		name         string
 		metric       string
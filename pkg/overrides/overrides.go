@@ -0,0 +1,345 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package overrides implements the per-tenant limits shared by queriers, query frontends, store gateways and
+// receivers. The limits are read from a YAML file, either on disk or in object storage, which is reloaded when it
+// changes, so that the quotas of tenants are defined once and adjusted without restarting the components.
+package overrides
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/units"
+	extflag "github.com/efficientgo/tools/extkingpin"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/objstore"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/api"
+	"github.com/thanos-io/thanos/pkg/extkingpin"
+	"github.com/thanos-io/thanos/pkg/extobjstore"
+	"github.com/thanos-io/thanos/pkg/extprom"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
+)
+
+// APIPath is the path of the HTTP endpoint serving the overrides.
+const APIPath = "/api/v1/status/overrides"
+
+// Limits are the limits of a tenant. Unset limits leave the ones given by flags and by the runtime configuration
+// unchanged.
+type Limits struct {
+	// MaxSeriesPerRequest is the maximum number of series of a Series request of the tenant. It is enforced by the
+	// Store API of all components and by the queries of queriers.
+	MaxSeriesPerRequest *uint64 `yaml:"max_series_per_request,omitempty" json:"max_series_per_request,omitempty"`
+	// MaxSamplesPerRequest is the maximum number of samples of a Series request of the tenant, enforced like
+	// MaxSeriesPerRequest.
+	MaxSamplesPerRequest *uint64 `yaml:"max_samples_per_request,omitempty" json:"max_samples_per_request,omitempty"`
+	// MaxQueryLength is the maximum time range of the queries of the tenant, enforced by query frontends.
+	MaxQueryLength *model.Duration `yaml:"max_query_length,omitempty" json:"max_query_length,omitempty"`
+	// MaxQueryParallelism is the maximum number of split queries of a query of the tenant run in parallel by query
+	// frontends.
+	MaxQueryParallelism *int `yaml:"max_query_parallelism,omitempty" json:"max_query_parallelism,omitempty"`
+	// MaxHeadSeries is the maximum number of series in the head of the TSDB of the tenant in receivers.
+	MaxHeadSeries *uint64 `yaml:"max_head_series,omitempty" json:"max_head_series,omitempty"`
+	// MaxWALSize is the maximum size of the WAL of the TSDB of the tenant in receivers.
+	MaxWALSize *units.Base2Bytes `yaml:"max_wal_size,omitempty" json:"max_wal_size,omitempty"`
+}
+
+// merge returns the limits with the limits set in o overriding the ones of l.
+func (l Limits) merge(o Limits) Limits {
+	if o.MaxSeriesPerRequest != nil {
+		l.MaxSeriesPerRequest = o.MaxSeriesPerRequest
+	}
+	if o.MaxSamplesPerRequest != nil {
+		l.MaxSamplesPerRequest = o.MaxSamplesPerRequest
+	}
+	if o.MaxQueryLength != nil {
+		l.MaxQueryLength = o.MaxQueryLength
+	}
+	if o.MaxQueryParallelism != nil {
+		l.MaxQueryParallelism = o.MaxQueryParallelism
+	}
+	if o.MaxHeadSeries != nil {
+		l.MaxHeadSeries = o.MaxHeadSeries
+	}
+	if o.MaxWALSize != nil {
+		l.MaxWALSize = o.MaxWALSize
+	}
+	return l
+}
+
+func (l Limits) validate() error {
+	if l.MaxQueryLength != nil && *l.MaxQueryLength < 0 {
+		return errors.New("max_query_length must not be negative")
+	}
+	if l.MaxQueryParallelism != nil && *l.MaxQueryParallelism <= 0 {
+		return errors.New("max_query_parallelism must be positive")
+	}
+	if l.MaxWALSize != nil && *l.MaxWALSize < 0 {
+		return errors.New("max_wal_size must not be negative")
+	}
+	return nil
+}
+
+// Config is the content of the overrides file.
+type Config struct {
+	// Defaults are the limits of all tenants, and of requests without tenant.
+	Defaults Limits `yaml:"defaults,omitempty" json:"defaults,omitempty"`
+	// Tenants are the limits of specific tenants, overriding the defaults.
+	Tenants map[string]Limits `yaml:"tenants,omitempty" json:"tenants,omitempty"`
+}
+
+// Parse parses and validates the overrides.
+func Parse(content []byte) (*Config, error) {
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, errors.Wrap(err, "parsing overrides YAML")
+	}
+
+	if err := cfg.Defaults.validate(); err != nil {
+		return nil, errors.Wrap(err, "defaults")
+	}
+	for tenant, limits := range cfg.Tenants {
+		if tenant == "" {
+			return nil, errors.New("tenant must not be empty")
+		}
+		if err := limits.validate(); err != nil {
+			return nil, errors.Wrapf(err, "tenant %s", tenant)
+		}
+	}
+	return cfg, nil
+}
+
+// Manager loads the overrides from a file or an object of a bucket, and reloads them when they change. Until they are
+// loaded, or if neither a file nor a bucket is given, no limit is overridden. All methods can be called on a nil
+// Manager, which never overrides limits.
+type Manager struct {
+	path         string
+	bucketConfig *extflag.PathOrContent
+	object       string
+	interval     time.Duration
+	tenant       middleware.TenantResolver
+
+	logger log.Logger
+	bkt    objstore.Bucket
+
+	mtx     sync.RWMutex
+	cfg     *Config
+	content []byte
+
+	hashGauge            prometheus.Gauge
+	successGauge         prometheus.Gauge
+	lastSuccessTimeGauge prometheus.Gauge
+}
+
+// NewManager returns a new Manager without overrides. Its file or bucket are given by the flags registered by
+// RegisterFlags, and are loaded by Init.
+func NewManager() *Manager {
+	return &Manager{cfg: &Config{}, logger: log.NewNopLogger()}
+}
+
+// RegisterFlags registers the flags of the overrides.
+func (m *Manager) RegisterFlags(cmd extkingpin.FlagClause) {
+	cmd.Flag("overrides.file", "Path to YAML file with the per-tenant limits overriding the ones given by flags and by the runtime configuration. The file is reloaded when it changes. See format details: https://thanos.io/tip/operating/overrides.md").
+		Default("").StringVar(&m.path)
+	m.bucketConfig = extkingpin.RegisterCommonObjStoreFlags(cmd, "-overrides", false, "The per-tenant limits are loaded from the --overrides.object object of this bucket instead of the --overrides.file file.")
+	cmd.Flag("overrides.object", "Name of the object with the per-tenant limits in the overrides bucket.").
+		Default("overrides.yaml").StringVar(&m.object)
+	cmd.Flag("overrides.reload-interval", "How often the overrides are checked for changes.").
+		Default("10s").DurationVar(&m.interval)
+}
+
+// RegisterTenantFlags registers the flags identifying the tenant of the HTTP requests of components serving queries.
+func (m *Manager) RegisterTenantFlags(cmd extkingpin.FlagClause) {
+	cmd.Flag("overrides.tenant-header", "HTTP header identifying the tenant of requests, whose limits are applied. The tenant is propagated to the queried StoreAPI servers.").
+		Default(middleware.TenantHeader).StringVar(&m.tenant.Header)
+	cmd.Flag("overrides.tenant-from-auth", "Identify the tenant of requests by the user authenticated with --http.auth-config instead of the --overrides.tenant-header header, so that clients cannot use the limits of other tenants.").
+		Default("false").BoolVar(&m.tenant.FromUser)
+}
+
+// TenantResolver returns the resolver of the tenant of HTTP requests given by the flags registered by
+// RegisterTenantFlags. The tenant can only be taken from the authenticated user if requests are authenticated.
+func (m *Manager) TenantResolver(authenticated bool) (middleware.TenantResolver, error) {
+	if m.tenant.FromUser && !authenticated {
+		return middleware.TenantResolver{}, errors.New("--overrides.tenant-from-auth requires --http.auth-config")
+	}
+	return m.tenant, nil
+}
+
+// Init creates the bucket of the overrides, if any, loads them and registers the metrics of their reloads. The bucket
+// is created on behalf of the given component.
+func (m *Manager) Init(logger log.Logger, reg prometheus.Registerer, component string) error {
+	m.logger = logger
+
+	var bucketConfYAML []byte
+	if m.bucketConfig != nil {
+		var err error
+		bucketConfYAML, err = m.bucketConfig.Content()
+		if err != nil {
+			return errors.Wrap(err, "getting overrides bucket config")
+		}
+	}
+	if m.path != "" && len(bucketConfYAML) > 0 {
+		return errors.New("overrides can be loaded either from a file or from a bucket, not both")
+	}
+	if len(bucketConfYAML) > 0 {
+		bkt, err := extobjstore.NewBucket(logger, bucketConfYAML, nil, component)
+		if err != nil {
+			return errors.Wrap(err, "creating overrides bucket")
+		}
+		m.bkt = bkt
+	}
+	if !m.Enabled() {
+		return nil
+	}
+
+	m.hashGauge = promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_overrides_hash",
+		Help: "Hash of the currently loaded overrides.",
+	})
+	m.successGauge = promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_overrides_last_reload_successful",
+		Help: "Whether the last overrides reload attempt was successful.",
+	})
+	m.lastSuccessTimeGauge = promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_overrides_last_reload_success_timestamp_seconds",
+		Help: "Timestamp of the last successful overrides reload.",
+	})
+	return m.reload(context.Background())
+}
+
+// Enabled returns whether the overrides are loaded from a file or a bucket. It must be called after Init.
+func (m *Manager) Enabled() bool {
+	return m != nil && (m.path != "" || m.bkt != nil)
+}
+
+// Run reloads the overrides when they change until the given context is canceled.
+func (m *Manager) Run(ctx context.Context) error {
+	if m.bkt != nil {
+		defer runutil.CloseWithLogOnErr(m.logger, m.bkt, "overrides bucket")
+	}
+	if !m.Enabled() {
+		<-ctx.Done()
+		return nil
+	}
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := m.reload(ctx); err != nil {
+				level.Error(m.logger).Log("msg", "reloading overrides failed, keeping the previous ones", "source", m.source(), "err", err)
+			}
+		}
+	}
+}
+
+func (m *Manager) source() string {
+	if m.bkt != nil {
+		return m.bkt.Name() + "/" + m.object
+	}
+	return m.path
+}
+
+func (m *Manager) read(ctx context.Context) ([]byte, error) {
+	if m.bkt == nil {
+		return os.ReadFile(m.path)
+	}
+	r, err := m.bkt.Get(ctx, m.object)
+	if err != nil {
+		return nil, err
+	}
+	defer runutil.CloseWithLogOnErr(m.logger, r, "overrides object reader")
+	return io.ReadAll(r)
+}
+
+func (m *Manager) reload(ctx context.Context) error {
+	content, err := m.read(ctx)
+	if err != nil {
+		m.successGauge.Set(0)
+		return errors.Wrapf(err, "reading overrides %s", m.source())
+	}
+
+	m.mtx.RLock()
+	unchanged := m.content != nil && bytes.Equal(m.content, content)
+	m.mtx.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	cfg, err := Parse(content)
+	if err != nil {
+		m.successGauge.Set(0)
+		return errors.Wrapf(err, "loading overrides %s", m.source())
+	}
+
+	m.mtx.Lock()
+	m.cfg = cfg
+	m.content = content
+	m.mtx.Unlock()
+
+	m.hashGauge.Set(extprom.HashAsMetricValue(content))
+	m.successGauge.Set(1)
+	m.lastSuccessTimeGauge.SetToCurrentTime()
+	level.Info(m.logger).Log("msg", "loaded overrides", "source", m.source(), "tenants", len(cfg.Tenants))
+	return nil
+}
+
+// Config returns the current overrides. It must not be modified.
+func (m *Manager) Config() *Config {
+	if m == nil {
+		return &Config{}
+	}
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	return m.cfg
+}
+
+// Limits returns the current limits of the given tenant, which are its overrides on top of the defaults. The limits of
+// requests without tenant are the defaults.
+func (m *Manager) Limits(tenant string) Limits {
+	cfg := m.Config()
+	limits := cfg.Defaults
+	if tenant == "" {
+		return limits
+	}
+	return limits.merge(cfg.Tenants[tenant])
+}
+
+// Tenants returns the sorted tenants with overrides.
+func (m *Manager) Tenants() []string {
+	cfg := m.Config()
+	tenants := make([]string, 0, len(cfg.Tenants))
+	for tenant := range cfg.Tenants {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+// ServeHTTP serves the current overrides.
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	api.Respond(w, m.Config(), nil)
+}
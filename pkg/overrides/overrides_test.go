@@ -0,0 +1,174 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package overrides
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/units"
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
+)
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse([]byte(`
+defaults:
+  max_series_per_request: 1000
+  max_query_length: 7d
+tenants:
+  team-a:
+    max_series_per_request: 5000
+    max_wal_size: 1GiB
+  team-b:
+    max_query_parallelism: 2
+`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, uint64(1000), *cfg.Defaults.MaxSeriesPerRequest)
+	testutil.Equals(t, model.Duration(7*24*time.Hour), *cfg.Defaults.MaxQueryLength)
+	testutil.Equals(t, units.GiB, *cfg.Tenants["team-a"].MaxWALSize)
+
+	for _, content := range []string{
+		`unknown_field: true`,
+		`defaults: {max_series_per_request: -1}`,
+		`defaults: {max_query_parallelism: 0}`,
+		`tenants: {team-a: {max_wal_size: 1XB}}`,
+		`tenants: {"": {max_head_series: 1}}`,
+	} {
+		_, err := Parse([]byte(content))
+		testutil.NotOk(t, err, content)
+	}
+}
+
+func TestManager_Limits(t *testing.T) {
+	// A nil manager does not override any limit.
+	var nilManager *Manager
+	testutil.Equals(t, Limits{}, nilManager.Limits("team-a"))
+	testutil.Equals(t, 0, len(nilManager.Tenants()))
+
+	path := filepath.Join(t.TempDir(), "overrides.yaml")
+	testutil.Ok(t, os.WriteFile(path, []byte(`
+defaults: {max_series_per_request: 1000, max_samples_per_request: 10000}
+tenants:
+  team-a: {max_series_per_request: 5000}
+  team-b: {max_head_series: 100}
+`), os.ModePerm))
+
+	m := NewManager()
+	app := kingpin.New("test", "")
+	m.RegisterFlags(app)
+	_, err := app.Parse([]string{"--overrides.file=" + path})
+	testutil.Ok(t, err)
+	testutil.Ok(t, m.Init(log.NewNopLogger(), prometheus.NewRegistry(), "test"))
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(m.successGauge))
+	testutil.Equals(t, []string{"team-a", "team-b"}, m.Tenants())
+
+	limits := m.Limits("team-a")
+	testutil.Equals(t, uint64(5000), *limits.MaxSeriesPerRequest)
+	testutil.Equals(t, uint64(10000), *limits.MaxSamplesPerRequest)
+	testutil.Assert(t, limits.MaxHeadSeries == nil, "unset limit should be nil")
+
+	limits = m.Limits("")
+	testutil.Equals(t, uint64(1000), *limits.MaxSeriesPerRequest)
+	testutil.Assert(t, limits.MaxHeadSeries == nil, "tenant limits should not apply to requests without tenant")
+
+	t.Run("serve", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, APIPath, nil))
+		testutil.Equals(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Status string `json:"status"`
+			Data   Config `json:"data"`
+		}
+		testutil.Ok(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		testutil.Equals(t, "success", resp.Status)
+		testutil.Equals(t, uint64(100), *resp.Data.Tenants["team-b"].MaxHeadSeries)
+	})
+
+	t.Run("invalid reload keeps previous overrides", func(t *testing.T) {
+		testutil.Ok(t, os.WriteFile(path, []byte("defaults: {max_query_parallelism: 0}\n"), os.ModePerm))
+		testutil.NotOk(t, m.reload(context.Background()))
+		testutil.Equals(t, 0.0, promtestutil.ToFloat64(m.successGauge))
+		testutil.Equals(t, uint64(5000), *m.Limits("team-a").MaxSeriesPerRequest)
+	})
+}
+
+func TestManager_Bucket(t *testing.T) {
+	dir := t.TempDir()
+	testutil.Ok(t, os.WriteFile(filepath.Join(dir, "limits.yaml"), []byte("tenants: {team-a: {max_head_series: 10}}\n"), os.ModePerm))
+
+	m := NewManager()
+	app := kingpin.New("test", "")
+	m.RegisterFlags(app)
+	_, err := app.Parse([]string{
+		"--objstore-overrides.config=type: FILESYSTEM\nconfig:\n  directory: " + dir,
+		"--overrides.object=limits.yaml",
+		"--overrides.reload-interval=10ms",
+	})
+	testutil.Ok(t, err)
+	testutil.Ok(t, m.Init(log.NewNopLogger(), prometheus.NewRegistry(), "test"))
+	testutil.Equals(t, uint64(10), *m.Limits("team-a").MaxHeadSeries)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		testutil.Ok(t, m.Run(ctx))
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	testutil.Ok(t, os.WriteFile(filepath.Join(dir, "limits.yaml"), []byte("tenants: {team-a: {max_head_series: 20}}\n"), os.ModePerm))
+	retryCtx, retryCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer retryCancel()
+	testutil.Ok(t, runutil.Retry(10*time.Millisecond, retryCtx.Done(), func() error {
+		if limit := m.Limits("team-a").MaxHeadSeries; limit == nil || *limit != 20 {
+			return errors.New("overrides not reloaded yet")
+		}
+		return nil
+	}))
+}
+
+func TestManager_FileAndBucket(t *testing.T) {
+	m := NewManager()
+	app := kingpin.New("test", "")
+	m.RegisterFlags(app)
+	_, err := app.Parse([]string{
+		"--overrides.file=overrides.yaml",
+		"--objstore-overrides.config=type: FILESYSTEM\nconfig:\n  directory: " + t.TempDir(),
+	})
+	testutil.Ok(t, err)
+	testutil.NotOk(t, m.Init(log.NewNopLogger(), prometheus.NewRegistry(), "test"))
+}
+
+func TestManager_TenantResolver(t *testing.T) {
+	m := NewManager()
+	app := kingpin.New("test", "")
+	m.RegisterTenantFlags(app)
+	_, err := app.Parse([]string{"--overrides.tenant-header=X-Scope-OrgID", "--overrides.tenant-from-auth"})
+	testutil.Ok(t, err)
+
+	_, err = m.TenantResolver(false)
+	testutil.NotOk(t, err)
+	r, err := m.TenantResolver(true)
+	testutil.Ok(t, err)
+	testutil.Equals(t, middleware.TenantResolver{Header: "X-Scope-OrgID", FromUser: true}, r)
+}
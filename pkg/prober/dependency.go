@@ -0,0 +1,206 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package prober
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// DependencyCheck checks whether a dependency of the component can be used, returning the reason if not.
+type DependencyCheck func(ctx context.Context) error
+
+// DependencyStatus is the state of a dependency as of its last check.
+type DependencyStatus struct {
+	Name string `json:"name"`
+	// Critical dependencies make the component not ready while they fail.
+	Critical       bool      `json:"critical"`
+	Healthy        bool      `json:"healthy"`
+	LastCheck      time.Time `json:"lastCheck"`
+	LastSuccess    time.Time `json:"lastSuccess"`
+	LastError      string    `json:"lastError,omitempty"`
+	LatencySeconds float64   `json:"latencySeconds"`
+}
+
+type dependency struct {
+	check  DependencyCheck
+	status DependencyStatus
+}
+
+// Dependencies periodically checks the dependencies of a component, such as object storage, caches or downstream
+// endpoints. Dependencies are registered before Run is called.
+type Dependencies struct {
+	logger   log.Logger
+	interval time.Duration
+	timeout  time.Duration
+	critical map[string]struct{}
+
+	mtx          sync.RWMutex
+	dependencies map[string]*dependency
+
+	up       *prometheus.GaugeVec
+	duration *prometheus.HistogramVec
+}
+
+// NewDependencies returns Dependencies checking dependencies every interval, each check being given up to timeout.
+// Failing checks of the critical dependencies make the component not ready. Both interval and timeout must be positive.
+func NewDependencies(logger log.Logger, reg prometheus.Registerer, interval, timeout time.Duration, critical []string) (*Dependencies, error) {
+	if interval <= 0 {
+		return nil, errors.Errorf("interval of dependency checks must be positive, got %s", interval)
+	}
+	if timeout <= 0 {
+		return nil, errors.Errorf("timeout of dependency checks must be positive, got %s", timeout)
+	}
+	d := &Dependencies{
+		logger:       logger,
+		interval:     interval,
+		timeout:      timeout,
+		critical:     make(map[string]struct{}, len(critical)),
+		dependencies: map[string]*dependency{},
+		up: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_dependency_up",
+			Help: "Whether the last check of the dependency was successful.",
+		}, []string{"dependency"}),
+		duration: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "thanos_dependency_check_duration_seconds",
+			Help:    "Duration of the checks of the dependency.",
+			Buckets: []float64{0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		}, []string{"dependency"}),
+	}
+	for _, name := range critical {
+		d.critical[name] = struct{}{}
+	}
+	return d, nil
+}
+
+// Register adds a dependency checked by the given function.
+func (d *Dependencies) Register(name string, check DependencyCheck) {
+	if d == nil {
+		return
+	}
+	_, critical := d.critical[name]
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.dependencies[name] = &dependency{check: check, status: DependencyStatus{Name: name, Critical: critical}}
+}
+
+// Run checks all dependencies right away, and then every interval until the context is canceled.
+// It fails if a critical dependency was not registered, as it would otherwise be silently ignored.
+func (d *Dependencies) Run(ctx context.Context) error {
+	d.mtx.RLock()
+	for name := range d.critical {
+		if _, ok := d.dependencies[name]; !ok {
+			d.mtx.RUnlock()
+			return errors.Errorf("critical dependency %q is not a dependency of this component", name)
+		}
+	}
+	d.mtx.RUnlock()
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		d.checkAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Dependencies) checkAll(ctx context.Context) {
+	d.mtx.RLock()
+	names := make([]string, 0, len(d.dependencies))
+	for name := range d.dependencies {
+		names = append(names, name)
+	}
+	d.mtx.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			d.check(ctx, name)
+		}(name)
+	}
+	wg.Wait()
+}
+
+func (d *Dependencies) check(ctx context.Context, name string) {
+	d.mtx.RLock()
+	dep := d.dependencies[name]
+	d.mtx.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := dep.check(ctx)
+	latency := time.Since(start)
+	d.duration.WithLabelValues(name).Observe(latency.Seconds())
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	wasHealthy := dep.status.Healthy || dep.status.LastCheck.IsZero()
+	dep.status.LastCheck = start
+	dep.status.LatencySeconds = latency.Seconds()
+	if err != nil {
+		dep.status.Healthy = false
+		dep.status.LastError = err.Error()
+		d.up.WithLabelValues(name).Set(0)
+		if wasHealthy {
+			level.Warn(d.logger).Log("msg", "dependency check failed", "dependency", name, "err", err)
+		}
+		return
+	}
+	dep.status.Healthy = true
+	dep.status.LastSuccess = start
+	dep.status.LastError = ""
+	d.up.WithLabelValues(name).Set(1)
+	if !wasHealthy {
+		level.Info(d.logger).Log("msg", "dependency check succeeded again", "dependency", name)
+	}
+}
+
+// Err returns the error of the first failing critical dependency, if any. Dependencies not checked yet are not
+// considered failing.
+func (d *Dependencies) Err() error {
+	if d == nil {
+		return nil
+	}
+	for _, s := range d.Statuses() {
+		if s.Critical && !s.Healthy && !s.LastCheck.IsZero() {
+			return errors.Errorf("dependency %s: %s", s.Name, s.LastError)
+		}
+	}
+	return nil
+}
+
+// Statuses returns the status of all dependencies, sorted by name.
+func (d *Dependencies) Statuses() []DependencyStatus {
+	if d == nil {
+		return nil
+	}
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	statuses := make([]DependencyStatus, 0, len(d.dependencies))
+	for _, dep := range d.dependencies {
+		statuses = append(statuses, dep.status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
@@ -0,0 +1,107 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/atomic"
+)
+
+func TestDependencies(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	deps, err := NewDependencies(log.NewNopLogger(), reg, time.Minute, time.Second, []string{"objstore"})
+	testutil.Ok(t, err)
+
+	var objstoreErr atomic.Error
+	deps.Register("objstore", func(context.Context) error { return objstoreErr.Load() })
+	deps.Register("cache", func(context.Context) error { return errors.New("connection refused") })
+
+	// Dependencies not checked yet do not fail.
+	testutil.Ok(t, deps.Err())
+
+	deps.checkAll(context.Background())
+	testutil.Ok(t, deps.Err())
+
+	statuses := deps.Statuses()
+	testutil.Equals(t, 2, len(statuses))
+	testutil.Equals(t, "cache", statuses[0].Name)
+	testutil.Assert(t, !statuses[0].Critical, "cache should not be critical")
+	testutil.Assert(t, !statuses[0].Healthy, "cache should not be healthy")
+	testutil.Equals(t, "connection refused", statuses[0].LastError)
+	testutil.Assert(t, statuses[0].LastSuccess.IsZero(), "cache should never have succeeded")
+	testutil.Equals(t, "objstore", statuses[1].Name)
+	testutil.Assert(t, statuses[1].Critical, "objstore should be critical")
+	testutil.Assert(t, statuses[1].Healthy, "objstore should be healthy")
+	testutil.Equals(t, 0.0, promtestutil.ToFloat64(deps.up.WithLabelValues("cache")))
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(deps.up.WithLabelValues("objstore")))
+
+	objstoreErr.Store(errors.New("access denied"))
+	deps.checkAll(context.Background())
+	testutil.NotOk(t, deps.Err())
+	testutil.Equals(t, "dependency objstore: access denied", deps.Err().Error())
+
+	statuses = deps.Statuses()
+	testutil.Equals(t, "access denied", statuses[1].LastError)
+	testutil.Assert(t, !statuses[1].LastSuccess.IsZero(), "objstore should keep its last success")
+}
+
+func TestDependencies_UnknownCritical(t *testing.T) {
+	deps, err := NewDependencies(log.NewNopLogger(), prometheus.NewRegistry(), time.Minute, time.Second, []string{"hashring"})
+	testutil.Ok(t, err)
+	deps.Register("objstore", func(context.Context) error { return nil })
+	testutil.NotOk(t, deps.Run(context.Background()))
+}
+
+func TestDependencies_InvalidInterval(t *testing.T) {
+	_, err := NewDependencies(log.NewNopLogger(), prometheus.NewRegistry(), 0, time.Second, nil)
+	testutil.NotOk(t, err)
+	_, err = NewDependencies(log.NewNopLogger(), prometheus.NewRegistry(), time.Minute, -time.Second, nil)
+	testutil.NotOk(t, err)
+}
+
+func TestHTTPProberDependencies(t *testing.T) {
+	p := NewHTTP()
+	p.Ready()
+	p.Healthy()
+
+	deps, err := NewDependencies(log.NewNopLogger(), prometheus.NewRegistry(), time.Minute, time.Second, []string{"objstore"})
+	testutil.Ok(t, err)
+	deps.Register("objstore", func(context.Context) error { return errors.New("access denied") })
+	p.SetDependencies(deps)
+	testutil.Assert(t, p.IsReady(), "should be ready until dependencies are checked")
+
+	deps.checkAll(context.Background())
+	testutil.Assert(t, !p.IsReady(), "should not be ready while a critical dependency fails")
+
+	w := httptest.NewRecorder()
+	p.ReadyHandler(log.NewNopLogger())(w, httptest.NewRequest(http.MethodGet, "/-/ready", nil))
+	testutil.Equals(t, http.StatusServiceUnavailable, w.Code)
+	testutil.Equals(t, "NOT OK: dependency objstore: access denied\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	p.HealthHandler(log.NewNopLogger())(w, httptest.NewRequest(http.MethodGet, "/api/v1/status/health", nil))
+	testutil.Equals(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Status string `json:"status"`
+		Data   Health `json:"data"`
+	}
+	testutil.Ok(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	testutil.Equals(t, "success", resp.Status)
+	testutil.Assert(t, resp.Data.Healthy, "should be healthy")
+	testutil.Assert(t, !resp.Data.Ready, "should not be ready")
+	testutil.Equals(t, 1, len(resp.Data.Dependencies))
+	testutil.Equals(t, "access denied", resp.Data.Dependencies[0].LastError)
+}
@@ -4,6 +4,7 @@
 package prober
 
 import (
+	"encoding/json"
 	"io"
 	"net/http"
 
@@ -18,6 +19,8 @@ type check func() bool
 type HTTPProbe struct {
 	ready   atomic.Uint32
 	healthy atomic.Uint32
+
+	dependencies *Dependencies
 }
 
 // NewHTTP returns HTTPProbe representing readiness and healthiness of given component.
@@ -30,9 +33,57 @@ func (p *HTTPProbe) HealthyHandler(logger log.Logger) http.HandlerFunc {
 	return p.handler(logger, p.isHealthy)
 }
 
+// SetDependencies sets the dependencies whose critical ones are required for the component to be ready.
+// It has to be called before the probe is served.
+func (p *HTTPProbe) SetDependencies(d *Dependencies) {
+	p.dependencies = d
+}
+
 // ReadyHandler returns a HTTP Handler which responds readiness checks.
 func (p *HTTPProbe) ReadyHandler(logger log.Logger) http.HandlerFunc {
-	return p.handler(logger, p.IsReady)
+	return func(w http.ResponseWriter, _ *http.Request) {
+		if p.ready.Load() == 0 {
+			http.Error(w, "NOT OK", http.StatusServiceUnavailable)
+			return
+		}
+		if err := p.dependencies.Err(); err != nil {
+			http.Error(w, "NOT OK: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if _, err := io.WriteString(w, "OK"); err != nil {
+			level.Error(logger).Log("msg", "failed to write probe response", "err", err)
+		}
+	}
+}
+
+// Health is the detailed health of a component.
+type Health struct {
+	Healthy      bool               `json:"healthy"`
+	Ready        bool               `json:"ready"`
+	Dependencies []DependencyStatus `json:"dependencies"`
+}
+
+// HealthHandler returns a HTTP Handler which responds the detailed health of the component, including the status
+// of its dependencies.
+func (p *HTTPProbe) HealthHandler(logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		health := Health{
+			Healthy:      p.isHealthy(),
+			Ready:        p.IsReady(),
+			Dependencies: p.dependencies.Statuses(),
+		}
+		if health.Dependencies == nil {
+			health.Dependencies = []DependencyStatus{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(struct {
+			Status string `json:"status"`
+			Data   Health `json:"data"`
+		}{Status: "success", Data: health}); err != nil {
+			level.Error(logger).Log("msg", "failed to write health response", "err", err)
+		}
+	}
 }
 
 func (p *HTTPProbe) handler(logger log.Logger, c check) http.HandlerFunc {
@@ -47,10 +98,10 @@ func (p *HTTPProbe) handler(logger log.Logger, c check) http.HandlerFunc {
 	}
 }
 
-// IsReady returns true if component is ready.
+// IsReady returns true if component is ready and none of its critical dependencies fails.
 func (p *HTTPProbe) IsReady() bool {
 	ready := p.ready.Load()
-	return ready > 0
+	return ready > 0 && p.dependencies.Err() == nil
 }
 
 // isHealthy returns true if component is healthy.
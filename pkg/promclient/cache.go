@@ -0,0 +1,101 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package promclient
+
+import (
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/simplelru"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ResponseCache caches responses of the Prometheus API by request. Responses are served from the cache for the TTL,
+// and for the stale TTL after it if requests to Prometheus fail, e.g. while it restarts. The least recently used
+// responses are evicted beyond the maximum number of entries. A nil ResponseCache does not cache responses.
+type ResponseCache struct {
+	ttl, staleTTL time.Duration
+	now           func() time.Time
+
+	mtx     sync.Mutex
+	entries *lru.LRU
+
+	requests *prometheus.CounterVec
+}
+
+type cachedResponse struct {
+	resp interface{}
+	at   time.Time
+}
+
+// NewResponseCache returns a ResponseCache with the given TTLs caching up to maxEntries responses, or nil if the TTL or
+// maxEntries is 0.
+func NewResponseCache(reg prometheus.Registerer, ttl, staleTTL time.Duration, maxEntries int) (*ResponseCache, error) {
+	if ttl <= 0 || maxEntries <= 0 {
+		return nil, nil
+	}
+	entries, err := lru.NewLRU(maxEntries, nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &ResponseCache{
+		ttl:      ttl,
+		staleTTL: staleTTL,
+		now:      time.Now,
+		entries:  entries,
+		requests: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_prometheus_api_cache_requests_total",
+			Help: "Total number of requests to the Prometheus API response cache by result: hit, miss, or stale when a stale response is served as the request to Prometheus failed.",
+		}, []string{"result"}),
+	}
+	for _, result := range []string{"hit", "miss", "stale"} {
+		c.requests.WithLabelValues(result)
+	}
+	return c, nil
+}
+
+// Get returns the cached response of the given request key if fresh, otherwise the response of fetch, which is cached.
+// If fetch fails, the cached response is returned if younger than the TTL and the stale TTL.
+func (c *ResponseCache) Get(key string, fetch func() (interface{}, error)) (interface{}, error) {
+	if c == nil {
+		return fetch()
+	}
+
+	var e cachedResponse
+	c.mtx.Lock()
+	v, ok := c.entries.Get(key)
+	c.mtx.Unlock()
+	if ok {
+		e = v.(cachedResponse)
+	}
+	if ok && c.now().Sub(e.at) < c.ttl {
+		c.requests.WithLabelValues("hit").Inc()
+		return e.resp, nil
+	}
+
+	resp, err := fetch()
+	if err != nil {
+		if ok && c.now().Sub(e.at) < c.ttl+c.staleTTL {
+			c.requests.WithLabelValues("stale").Inc()
+			return e.resp, nil
+		}
+		return nil, err
+	}
+	c.requests.WithLabelValues("miss").Inc()
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	now := c.now()
+	// Evict the least recently used responses which cannot be served anymore.
+	for {
+		_, v, ok := c.entries.GetOldest()
+		if !ok || now.Sub(v.(cachedResponse).at) < c.ttl+c.staleTTL {
+			break
+		}
+		c.entries.RemoveOldest()
+	}
+	c.entries.Add(key, cachedResponse{resp: resp, at: now})
+	return resp, nil
+}
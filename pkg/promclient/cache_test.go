@@ -0,0 +1,95 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package promclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestResponseCache(t *testing.T) {
+	c, err := NewResponseCache(nil, 0, time.Minute, 10)
+	testutil.Ok(t, err)
+	testutil.Assert(t, c == nil)
+	c, err = NewResponseCache(nil, time.Minute, time.Minute, 0)
+	testutil.Ok(t, err)
+	testutil.Assert(t, c == nil)
+
+	var nilCache *ResponseCache
+	resp, err := nilCache.Get("a", func() (interface{}, error) { return 1, nil })
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, resp)
+
+	reg := prometheus.NewRegistry()
+	c, err = NewResponseCache(reg, time.Minute, time.Minute, 2)
+	testutil.Ok(t, err)
+	now := time.Unix(0, 0)
+	c.now = func() time.Time { return now }
+
+	var (
+		fetches int
+		fetch   = func() (interface{}, error) {
+			fetches++
+			return fetches, nil
+		}
+		fail = func() (interface{}, error) { return nil, errors.New("unavailable") }
+	)
+
+	// Responses are cached by request for the TTL.
+	resp, err = c.Get("a", fetch)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, resp)
+	resp, err = c.Get("b", fetch)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, resp)
+	now = now.Add(30 * time.Second)
+	resp, err = c.Get("a", fail)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, resp)
+
+	now = now.Add(31 * time.Second)
+	resp, err = c.Get("a", fetch)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, resp)
+
+	// Stale responses are served for the stale TTL if the request fails.
+	now = now.Add(90 * time.Second)
+	resp, err = c.Get("a", fail)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, resp)
+	_, err = c.Get("b", fail)
+	testutil.NotOk(t, err)
+
+	now = now.Add(time.Minute)
+	_, err = c.Get("a", fail)
+	testutil.NotOk(t, err)
+
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(c.requests.WithLabelValues("hit")))
+	testutil.Equals(t, 3.0, promtestutil.ToFloat64(c.requests.WithLabelValues("miss")))
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(c.requests.WithLabelValues("stale")))
+
+	// The least recently used responses are evicted beyond the maximum number of entries.
+	resp, err = c.Get("c", fetch)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 4, resp)
+	resp, err = c.Get("d", fetch)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 5, resp)
+	resp, err = c.Get("c", fail)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 4, resp)
+	resp, err = c.Get("e", fetch)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 6, resp)
+	_, err = c.Get("d", fail)
+	testutil.NotOk(t, err)
+	resp, err = c.Get("c", fail)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 4, resp)
+}
@@ -10,6 +10,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
@@ -26,6 +27,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/histogram"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/timestamp"
 	"github.com/prometheus/prometheus/promql"
@@ -480,16 +482,94 @@ func (c *Client) PromqlQueryInstant(ctx context.Context, base *url.URL, query st
 		}
 		sort.Sort(lset)
 
-		vec = append(vec, promql.Sample{
+		sample := promql.Sample{
 			Metric: lset,
 			T:      int64(e.Timestamp),
-			F:      float64(e.Value),
-		})
+		}
+		if e.Histogram != nil {
+			sample.H = SampleHistogramToFloatHistogram(e.Histogram)
+		} else {
+			sample.F = float64(e.Value)
+		}
+		vec = append(vec, sample)
 	}
 
 	return vec, warnings, nil
 }
 
+// SampleHistogramToFloatHistogram converts a native histogram returned by the query API back into a histogram.FloatHistogram.
+// The query API only returns the bounds of the buckets, so their schema and indices are inferred from the bounds.
+func SampleHistogramToFloatHistogram(sh *model.SampleHistogram) *histogram.FloatHistogram {
+	fh := &histogram.FloatHistogram{
+		Count: float64(sh.Count),
+		Sum:   float64(sh.Sum),
+	}
+
+	// The bounds of a bucket are 2^(2^-schema) apart, except for the zero bucket and the buckets of infinite values.
+	for _, b := range sh.Buckets {
+		lower, upper := math.Abs(float64(b.Lower)), math.Abs(float64(b.Upper))
+		if (b.Lower > 0 || b.Upper < 0) && !math.IsInf(lower, 0) && !math.IsInf(upper, 0) && upper != math.MaxFloat64 {
+			fh.Schema = int32(-math.Round(math.Log2(math.Abs(math.Log2(upper / lower)))))
+			break
+		}
+	}
+
+	var positive, negative []indexedCount
+	for _, b := range sh.Buckets {
+		switch {
+		case b.Lower > 0:
+			positive = append(positive, indexedCount{index: bucketIndex(float64(b.Upper), fh.Schema), count: float64(b.Count)})
+		case b.Upper < 0:
+			negative = append(negative, indexedCount{index: bucketIndex(-float64(b.Lower), fh.Schema), count: float64(b.Count)})
+		default:
+			fh.ZeroThreshold = float64(b.Upper)
+			fh.ZeroCount = float64(b.Count)
+		}
+	}
+	fh.PositiveSpans, fh.PositiveBuckets = bucketSpans(positive)
+	fh.NegativeSpans, fh.NegativeBuckets = bucketSpans(negative)
+	return fh
+}
+
+type indexedCount struct {
+	index int32
+	count float64
+}
+
+// bucketIndex returns the index of the bucket with the given upper absolute bound in the given schema.
+func bucketIndex(bound float64, schema int32) int32 {
+	if math.IsInf(bound, 0) {
+		// The bucket of infinite values follows the one ending with math.MaxFloat64.
+		return bucketIndex(math.MaxFloat64, schema) + 1
+	}
+	return int32(math.Round(math.Log2(bound) * math.Exp2(float64(schema))))
+}
+
+// bucketSpans returns the spans and counts of the given buckets.
+func bucketSpans(buckets []indexedCount) ([]histogram.Span, []float64) {
+	if len(buckets) == 0 {
+		return nil, nil
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].index < buckets[j].index })
+
+	var (
+		spans  []histogram.Span
+		counts = make([]float64, 0, len(buckets))
+	)
+	for i, b := range buckets {
+		switch {
+		case i == 0:
+			spans = append(spans, histogram.Span{Offset: b.index, Length: 1})
+		case b.index == buckets[i-1].index+1:
+			spans[len(spans)-1].Length++
+		default:
+			spans = append(spans, histogram.Span{Offset: b.index - buckets[i-1].index - 1, Length: 1})
+		}
+		counts = append(counts, b.count)
+	}
+	return spans, counts
+}
+
 // QueryRange performs a range query using a default HTTP client and returns results in model.Matrix type.
 func (c *Client) QueryRange(ctx context.Context, base *url.URL, query string, startTime, endTime, step int64, opts QueryOptions) (model.Matrix, []string, error) {
 	params, err := url.ParseQuery(base.RawQuery)
@@ -650,6 +730,44 @@ func (c *Client) BuildVersion(ctx context.Context, base *url.URL) (string, error
 	return b.Data.Version, nil
 }
 
+// WALReplayStatus is the progress of the WAL replay of Prometheus on startup, in WAL segments.
+type WALReplayStatus struct {
+	Min     int `json:"min"`
+	Max     int `json:"max"`
+	Current int `json:"current"`
+}
+
+// Done returns true if Prometheus replayed all segments of its WAL.
+func (s WALReplayStatus) Done() bool {
+	return s.Current >= s.Max
+}
+
+// WALReplayStatus returns the WAL replay progress from /api/v1/status/walreplay Prometheus endpoint.
+// For Prometheus versions < 2.28.0 it returns nil, as the endpoint does not exist.
+func (c *Client) WALReplayStatus(ctx context.Context, base *url.URL) (*WALReplayStatus, error) {
+	u := *base
+	u.Path = path.Join(u.Path, "/api/v1/status/walreplay")
+
+	span, ctx := tracing.StartSpan(ctx, "/prom_walreplay HTTP[client]")
+	defer span.Finish()
+
+	body, code, err := c.req2xx(ctx, &u, http.MethodGet)
+	if err != nil {
+		if code == http.StatusNotFound || code == http.StatusMethodNotAllowed {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var b struct {
+		Data WALReplayStatus `json:"data"`
+	}
+	if err = json.Unmarshal(body, &b); err != nil {
+		return nil, errors.Wrap(err, "unmarshal WAL replay status API response")
+	}
+	return &b.Data, nil
+}
+
 func formatTime(t time.Time) string {
 	return strconv.FormatFloat(float64(t.Unix())+float64(t.Nanosecond())/1e9, 'f', -1, 64)
 }
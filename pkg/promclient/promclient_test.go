@@ -0,0 +1,88 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package promclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/histogram"
+)
+
+func TestWALReplayStatus(t *testing.T) {
+	var resp string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testutil.Equals(t, "/prom/api/v1/status/walreplay", r.URL.Path)
+		if resp == "" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(resp))
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL + "/prom")
+	testutil.Ok(t, err)
+	c := NewDefaultClient()
+
+	// Not supported by Prometheus.
+	s, err := c.WALReplayStatus(context.Background(), u)
+	testutil.Ok(t, err)
+	testutil.Assert(t, s == nil)
+
+	resp = `{"status":"success","data":{"min":2,"max":10,"current":5}}`
+	s, err = c.WALReplayStatus(context.Background(), u)
+	testutil.Ok(t, err)
+	testutil.Equals(t, WALReplayStatus{Min: 2, Max: 10, Current: 5}, *s)
+	testutil.Assert(t, !s.Done())
+
+	resp = `{"status":"success","data":{"min":2,"max":10,"current":10}}`
+	s, err = c.WALReplayStatus(context.Background(), u)
+	testutil.Ok(t, err)
+	testutil.Assert(t, s.Done())
+}
+
+func TestSampleHistogramToFloatHistogram(t *testing.T) {
+	for _, fh := range []*histogram.FloatHistogram{
+		{
+			Schema:          1,
+			Count:           13,
+			Sum:             -4.5,
+			ZeroThreshold:   0.001,
+			ZeroCount:       2,
+			PositiveSpans:   []histogram.Span{{Offset: -2, Length: 2}, {Offset: 3, Length: 1}},
+			PositiveBuckets: []float64{1, 2, 3},
+			NegativeSpans:   []histogram.Span{{Offset: 4, Length: 2}},
+			NegativeBuckets: []float64{4, 1},
+		},
+		{
+			Schema:          -1,
+			Count:           3,
+			Sum:             100,
+			PositiveSpans:   []histogram.Span{{Offset: 1, Length: 1}, {Offset: 1, Length: 1}},
+			PositiveBuckets: []float64{1, 2},
+		},
+	} {
+		// The query API returns the buckets with observations with their bounds.
+		sh := &model.SampleHistogram{Count: model.FloatString(fh.Count), Sum: model.FloatString(fh.Sum)}
+		it := fh.AllBucketIterator()
+		for it.Next() {
+			b := it.At()
+			if b.Count == 0 {
+				continue
+			}
+			sh.Buckets = append(sh.Buckets, &model.HistogramBucket{
+				Lower: model.FloatString(b.Lower),
+				Upper: model.FloatString(b.Upper),
+				Count: model.FloatString(b.Count),
+			})
+		}
+		testutil.Equals(t, fh, SampleHistogramToFloatHistogram(sh))
+	}
+}
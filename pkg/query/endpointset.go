@@ -618,6 +618,20 @@ func (e *EndpointSet) GetEndpointStatus() []EndpointStatus {
 	return statuses
 }
 
+// Check returns an error if none of the endpoints is healthy, in which case queries cannot get any data.
+func (e *EndpointSet) Check(context.Context) error {
+	statuses := e.GetEndpointStatus()
+	if len(statuses) == 0 {
+		return errors.New("no endpoint discovered")
+	}
+	for _, s := range statuses {
+		if s.LastError == nil {
+			return nil
+		}
+	}
+	return errors.Errorf("none of the %d endpoints is healthy, %s: %s", len(statuses), statuses[0].Name, statuses[0].LastError.Error())
+}
+
 type endpointRef struct {
 	storepb.StoreClient
 
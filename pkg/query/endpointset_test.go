@@ -490,11 +490,13 @@ func TestEndpointSetUpdate_EndpointGoingAway(t *testing.T) {
 	endpointSet.Update(context.Background())
 	testutil.Equals(t, 1, len(endpointSet.GetEndpointStatus()))
 	testutil.Equals(t, 1, len(endpointSet.GetStoreClients()))
+	testutil.Ok(t, endpointSet.Check(context.Background()))
 
 	endpoints.CloseOne(discoveredEndpointAddr[0])
 	endpointSet.Update(context.Background())
 	testutil.Equals(t, 1, len(endpointSet.GetEndpointStatus()))
 	testutil.Equals(t, 0, len(endpointSet.GetStoreClients()))
+	testutil.NotOk(t, endpointSet.Check(context.Background()))
 }
 
 func TestEndpointSetUpdate_EndpointComingOnline(t *testing.T) {
@@ -518,6 +520,7 @@ func TestEndpointSetUpdate_EndpointComingOnline(t *testing.T) {
 	endpointSet.Update(context.Background())
 	testutil.Equals(t, 0, len(endpointSet.GetEndpointStatus()))
 	testutil.Equals(t, 0, len(endpointSet.GetStoreClients()))
+	testutil.NotOk(t, endpointSet.Check(context.Background()))
 
 	srvAddr := discoveredEndpointAddr[0]
 	endpoints.endpoints[srvAddr].setResponseError(nil)
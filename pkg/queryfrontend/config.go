@@ -22,6 +22,7 @@ import (
 	cortexvalidation "github.com/thanos-io/thanos/internal/cortex/util/validation"
 	"github.com/thanos-io/thanos/pkg/cacheutil"
 	"github.com/thanos-io/thanos/pkg/model"
+	"github.com/thanos-io/thanos/pkg/overrides"
 )
 
 type ResponseCacheProvider string
@@ -206,6 +207,9 @@ type Config struct {
 	DownstreamURL          string
 	ForwardHeaders         []string
 	NumShards              int
+	// Overrides are the per-tenant limits overriding the query range and labels limits of tenants. Requests with a
+	// tenant are given it as org ID.
+	Overrides *overrides.Manager
 }
 
 // QueryRangeConfig holds the config for query range tripperware.
@@ -0,0 +1,46 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"github.com/thanos-io/thanos/internal/cortex/util/validation"
+	"github.com/thanos-io/thanos/pkg/overrides"
+)
+
+// tenantLimits are the limits of tenants given by the overrides on top of the default limits given by flags.
+type tenantLimits struct {
+	defaults  validation.Limits
+	overrides *overrides.Manager
+}
+
+// newTenantLimits returns the limits of tenants given by the overrides on top of the given defaults, or nil if there
+// are no overrides.
+func newTenantLimits(defaults validation.Limits, o *overrides.Manager) validation.TenantLimits {
+	if o == nil {
+		return nil
+	}
+	return &tenantLimits{defaults: defaults, overrides: o}
+}
+
+// ByUserID implements validation.TenantLimits.
+func (l *tenantLimits) ByUserID(userID string) *validation.Limits {
+	limits := l.defaults
+	o := l.overrides.Limits(userID)
+	if o.MaxQueryLength != nil {
+		limits.MaxQueryLength = *o.MaxQueryLength
+	}
+	if o.MaxQueryParallelism != nil {
+		limits.MaxQueryParallelism = *o.MaxQueryParallelism
+	}
+	return &limits
+}
+
+// AllByUserID implements validation.TenantLimits.
+func (l *tenantLimits) AllByUserID() map[string]*validation.Limits {
+	all := map[string]*validation.Limits{}
+	for _, tenant := range l.overrides.Tenants() {
+		all[tenant] = l.ByUserID(tenant)
+	}
+	return all
+}
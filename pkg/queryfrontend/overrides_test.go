@@ -0,0 +1,46 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/thanos-io/thanos/internal/cortex/util/validation"
+	"github.com/thanos-io/thanos/pkg/overrides"
+)
+
+func TestTenantLimits(t *testing.T) {
+	defaults := validation.Limits{MaxQueryLength: model.Duration(24 * time.Hour), MaxQueryParallelism: 14}
+	testutil.Assert(t, newTenantLimits(defaults, nil) == nil, "expected no tenant limits without overrides")
+
+	path := filepath.Join(t.TempDir(), "overrides.yaml")
+	testutil.Ok(t, os.WriteFile(path, []byte(`
+defaults: {max_query_parallelism: 4}
+tenants:
+  team-a: {max_query_length: 7d}
+`), os.ModePerm))
+	o := overrides.NewManager()
+	app := kingpin.New("test", "")
+	o.RegisterFlags(app)
+	_, err := app.Parse([]string{"--overrides.file=" + path})
+	testutil.Ok(t, err)
+	testutil.Ok(t, o.Init(log.NewNopLogger(), prometheus.NewRegistry(), "query-frontend"))
+
+	limits, err := validation.NewOverrides(defaults, newTenantLimits(defaults, o))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 7*24*time.Hour, limits.MaxQueryLength("team-a"))
+	testutil.Equals(t, 4, limits.MaxQueryParallelism("team-a"))
+	testutil.Equals(t, 24*time.Hour, limits.MaxQueryLength("team-b"))
+	testutil.Equals(t, 4, limits.MaxQueryParallelism("team-b"))
+	testutil.Equals(t, 1, len(newTenantLimits(defaults, o).AllByUserID()))
+}
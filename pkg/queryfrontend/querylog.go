@@ -0,0 +1,140 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-logfmt/logfmt"
+	"github.com/pkg/errors"
+)
+
+const (
+	slowQueryLogMessage  = "slow query detected"
+	queryStatsLogMessage = "query stats"
+	queryLogParamPrefix  = "param_"
+)
+
+// LoggedQuery is a query logged by the query frontend.
+type LoggedQuery struct {
+	// Time is the time the query was logged at, so shortly after it was answered.
+	Time   time.Time  `json:"time"`
+	Method string     `json:"method,omitempty"`
+	Path   string     `json:"path"`
+	Params url.Values `json:"params"`
+	// Duration is how long the query took when it was logged.
+	Duration time.Duration `json:"duration"`
+}
+
+// ParseQueryLog returns the queries of the slow query and query stats log lines of the query frontend read from r, in
+// logfmt or JSON format. Other lines are skipped. Parameters with multiple values are logged joined by commas, and are
+// returned as a single value.
+func ParseQueryLog(r io.Reader) ([]LoggedQuery, error) {
+	var queries []LoggedQuery
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for n := 1; s.Scan(); n++ {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		fields, err := parseQueryLogLine(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse line %d", n)
+		}
+		q, ok, err := loggedQuery(fields)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse line %d", n)
+		}
+		if ok {
+			queries = append(queries, q)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrap(err, "read query log")
+	}
+	return queries, nil
+}
+
+func parseQueryLogLine(line []byte) (map[string]string, error) {
+	fields := map[string]string{}
+	if line[0] == '{' {
+		var values map[string]interface{}
+		if err := json.Unmarshal(line, &values); err != nil {
+			return nil, err
+		}
+		for k, v := range values {
+			if s, ok := v.(string); ok {
+				fields[k] = s
+				continue
+			}
+			fields[k] = fmt.Sprint(v)
+		}
+		return fields, nil
+	}
+
+	d := logfmt.NewDecoder(bytes.NewReader(line))
+	for d.ScanRecord() {
+		for d.ScanKeyval() {
+			fields[string(d.Key())] = string(d.Value())
+		}
+	}
+	if err := d.Err(); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// loggedQuery returns the query logged by the given fields of a log line, and false if the line does not log a query.
+func loggedQuery(fields map[string]string) (LoggedQuery, bool, error) {
+	var (
+		q           LoggedQuery
+		durationKey string
+	)
+	switch fields["msg"] {
+	case slowQueryLogMessage:
+		durationKey = "time_taken"
+	case queryStatsLogMessage:
+		durationKey = "response_time"
+	default:
+		return q, false, nil
+	}
+
+	q.Method = fields["method"]
+	q.Path = fields["path"]
+	if q.Path == "" {
+		return q, false, errors.New("query without path")
+	}
+	if ts := fields["ts"]; ts != "" {
+		t, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			return q, false, errors.Wrapf(err, "parse time %q", ts)
+		}
+		q.Time = t
+	}
+	if d := fields[durationKey]; d != "" {
+		duration, err := time.ParseDuration(d)
+		if err != nil {
+			return q, false, errors.Wrapf(err, "parse %s %q", durationKey, d)
+		}
+		q.Duration = duration
+	}
+
+	q.Params = url.Values{}
+	for k, v := range fields {
+		if name := strings.TrimPrefix(k, queryLogParamPrefix); name != k {
+			q.Params.Set(name, v)
+		}
+	}
+	return q, true, nil
+}
@@ -0,0 +1,86 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package queryfrontend
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+)
+
+func TestParseQueryLog(t *testing.T) {
+	ts := time.Date(2023, 3, 1, 10, 0, 0, 123000000, time.UTC)
+
+	for _, tc := range []struct {
+		name     string
+		log      string
+		expected []LoggedQuery
+		err      bool
+	}{
+		{
+			name: "logfmt",
+			log: `level=info ts=2023-03-01T10:00:00.123Z caller=handler.go:180 msg="slow query detected" method=GET host=localhost:9090 path=/api/v1/query_range remote_user= remote_addr=127.0.0.1:5678 time_taken=1.5s grafana_dashboard_uid=- grafana_panel_id=- trace_id=- param_query="sum(rate(up{job=\"a\",instance=\"b\"}[5m]))" param_start=1677657600 param_end=1677661200 param_step=30
+level=info ts=2023-03-01T10:00:01Z caller=main.go:10 msg="starting query frontend"
+
+level=info ts=2023-03-01T10:00:02Z caller=handler.go:230 msg="query stats" component=query-frontend method=POST path=/api/v1/query remote_user= remote_addr=127.0.0.1:5678 response_time=250ms query_wall_time_seconds=0.2 fetched_series_count=1 fetched_chunks_bytes=10 param_query=up`,
+			expected: []LoggedQuery{
+				{
+					Time:   ts,
+					Method: "GET",
+					Path:   "/api/v1/query_range",
+					Params: url.Values{
+						"query": []string{`sum(rate(up{job="a",instance="b"}[5m]))`},
+						"start": []string{"1677657600"},
+						"end":   []string{"1677661200"},
+						"step":  []string{"30"},
+					},
+					Duration: 1500 * time.Millisecond,
+				},
+				{
+					Time:     ts.Add(2*time.Second - 123*time.Millisecond),
+					Method:   "POST",
+					Path:     "/api/v1/query",
+					Params:   url.Values{"query": []string{"up"}},
+					Duration: 250 * time.Millisecond,
+				},
+			},
+		},
+		{
+			name: "json",
+			log:  `{"caller":"handler.go:180","level":"info","msg":"slow query detected","method":"GET","path":"/api/v1/query","param_query":"up","time_taken":"2s","ts":"2023-03-01T10:00:00.123Z"}`,
+			expected: []LoggedQuery{
+				{
+					Time:     ts,
+					Method:   "GET",
+					Path:     "/api/v1/query",
+					Params:   url.Values{"query": []string{"up"}},
+					Duration: 2 * time.Second,
+				},
+			},
+		},
+		{
+			name: "invalid duration",
+			log:  `msg="slow query detected" path=/api/v1/query time_taken=2x`,
+			err:  true,
+		},
+		{
+			name: "invalid line",
+			log:  `{"msg":`,
+			err:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			queries, err := ParseQueryLog(strings.NewReader(tc.log))
+			if tc.err {
+				testutil.NotOk(t, err)
+				return
+			}
+			testutil.Ok(t, err)
+			testutil.Equals(t, tc.expected, queries)
+		})
+	}
+}
@@ -18,6 +18,7 @@ import (
 
 	"github.com/thanos-io/thanos/internal/cortex/querier/queryrange"
 	"github.com/thanos-io/thanos/internal/cortex/util/validation"
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 )
 
 const (
@@ -38,14 +39,14 @@ func NewTripperware(config Config, reg prometheus.Registerer, logger log.Logger)
 		err                            error
 	)
 	if config.QueryRangeConfig.Limits != nil {
-		queryRangeLimits, err = validation.NewOverrides(*config.QueryRangeConfig.Limits, nil)
+		queryRangeLimits, err = validation.NewOverrides(*config.QueryRangeConfig.Limits, newTenantLimits(*config.QueryRangeConfig.Limits, config.Overrides))
 		if err != nil {
 			return nil, errors.Wrap(err, "initialize query range limits")
 		}
 	}
 
 	if config.LabelsConfig.Limits != nil {
-		labelsLimits, err = validation.NewOverrides(*config.LabelsConfig.Limits, nil)
+		labelsLimits, err = validation.NewOverrides(*config.LabelsConfig.Limits, newTenantLimits(*config.LabelsConfig.Limits, config.Overrides))
 		if err != nil {
 			return nil, errors.Wrap(err, "initialize labels limits")
 		}
@@ -55,18 +56,24 @@ func NewTripperware(config Config, reg prometheus.Registerer, logger log.Logger)
 	labelsCodec := NewThanosLabelsCodec(config.LabelsConfig.PartialResponseStrategy, config.DefaultTimeRange)
 	queryInstantCodec := NewThanosQueryInstantCodec(config.QueryRangeConfig.PartialResponseStrategy)
 
+	// The request id is always forwarded, so the query can be identified across the queriers and stores.
+	forwardHeaders := config.ForwardHeaders
+	if !containsHeader(forwardHeaders, middleware.RequestIDHeader) {
+		forwardHeaders = append([]string{middleware.RequestIDHeader}, forwardHeaders...)
+	}
+
 	queryRangeTripperware, err := newQueryRangeTripperware(
 		config.QueryRangeConfig,
 		queryRangeLimits,
 		queryRangeCodec,
 		config.NumShards,
-		prometheus.WrapRegistererWith(prometheus.Labels{"tripperware": "query_range"}, reg), logger, config.ForwardHeaders)
+		prometheus.WrapRegistererWith(prometheus.Labels{"tripperware": "query_range"}, reg), logger, forwardHeaders)
 	if err != nil {
 		return nil, err
 	}
 
 	labelsTripperware, err := newLabelsTripperware(config.LabelsConfig, labelsLimits, labelsCodec,
-		prometheus.WrapRegistererWith(prometheus.Labels{"tripperware": "labels"}, reg), logger, config.ForwardHeaders)
+		prometheus.WrapRegistererWith(prometheus.Labels{"tripperware": "labels"}, reg), logger, forwardHeaders)
 	if err != nil {
 		return nil, err
 	}
@@ -75,7 +82,7 @@ func NewTripperware(config Config, reg prometheus.Registerer, logger log.Logger)
 		queryRangeLimits,
 		queryInstantCodec,
 		prometheus.WrapRegistererWith(prometheus.Labels{"tripperware": "query_instant"}, reg),
-		config.ForwardHeaders,
+		forwardHeaders,
 	)
 	return func(next http.RoundTripper) http.RoundTripper {
 		return newRoundTripper(next, queryRangeTripperware(next), labelsTripperware(next), queryInstantTripperware(next), reg)
@@ -364,3 +371,12 @@ func shouldCache(r queryrange.Request) bool {
 
 	return !r.GetCachingOptions().Disabled
 }
+
+func containsHeader(headers []string, header string) bool {
+	for _, h := range headers {
+		if strings.EqualFold(h, header) {
+			return true
+		}
+	}
+	return false
+}
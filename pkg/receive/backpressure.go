@@ -0,0 +1,121 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/atomic"
+
+	"github.com/thanos-io/thanos/pkg/gate"
+)
+
+// maxRetryAfter caps the Retry-After advertised to clients of an overloaded receiver.
+const maxRetryAfter = time.Minute
+
+// errWriteQueueFull is returned when too many write requests are waiting for the write gate.
+var errWriteQueueFull = errors.New("too many write requests waiting to be processed")
+
+// retryAfterError is the error of a write request rejected because the receiver or the tenant is overloaded.
+// Clients should retry the request after retryAfter.
+type retryAfterError struct {
+	error
+	retryAfter time.Duration
+}
+
+// Cause returns the underlying error, so that retryAfterError is transparent to errors.Cause.
+func (e retryAfterError) Cause() error {
+	return e.error
+}
+
+// Unwrap returns the underlying error, so that retryAfterError is transparent to errors.Is.
+func (e retryAfterError) Unwrap() error {
+	return e.error
+}
+
+// httpError replies to the request with the error and the status code. The Retry-After header is set if the
+// error is a retryAfterError, rounded up to full seconds.
+func httpError(w http.ResponseWriter, err error, code int) {
+	var rerr retryAfterError
+	if errors.As(err, &rerr) {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(rerr.retryAfter)))
+	}
+	http.Error(w, err.Error(), code)
+}
+
+// retryAfterSeconds returns the duration in full seconds, between 1 second and maxRetryAfter.
+func retryAfterSeconds(d time.Duration) int {
+	if d > maxRetryAfter {
+		d = maxRetryAfter
+	}
+	if s := int(math.Ceil(d.Seconds())); s > 1 {
+		return s
+	}
+	return 1
+}
+
+// writeQueue is a gate.Gate limiting the number of write requests waiting for the write gate. Requests beyond
+// the limit are rejected right away, telling clients to retry once the requests waiting now are likely processed,
+// instead of letting the latency grow until clients time out and retry all at once.
+type writeQueue struct {
+	gate      gate.Gate
+	maxQueued int64
+
+	queued  atomic.Int64
+	avgWait atomic.Duration
+
+	depth    prometheus.Gauge
+	rejected prometheus.Counter
+}
+
+func newWriteQueue(reg prometheus.Registerer, g gate.Gate, maxQueued int64) *writeQueue {
+	return &writeQueue{
+		gate:      g,
+		maxQueued: maxQueued,
+		depth: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "thanos_receive_write_requests_queued",
+			Help: "The number of write requests waiting for the write gate.",
+		}),
+		rejected: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_receive_write_requests_queue_full_total",
+			Help: "The number of write requests rejected because too many write requests were waiting for the write gate.",
+		}),
+	}
+}
+
+// Start implements gate.Gate. It returns a retryAfterError wrapping errWriteQueueFull if too many requests are waiting.
+func (q *writeQueue) Start(ctx context.Context) error {
+	if q.queued.Inc() > q.maxQueued {
+		q.queued.Dec()
+		q.rejected.Inc()
+		return retryAfterError{error: errWriteQueueFull, retryAfter: q.avgWait.Load()}
+	}
+	q.depth.Inc()
+	defer func() {
+		q.queued.Dec()
+		q.depth.Dec()
+	}()
+
+	start := time.Now()
+	if err := q.gate.Start(ctx); err != nil {
+		return err
+	}
+	// An exponentially weighted moving average is enough to estimate how long the waiting requests take.
+	wait := time.Since(start)
+	avg := q.avgWait.Load()
+	q.avgWait.Store(avg + (wait-avg)/8)
+	return nil
+}
+
+// Done implements gate.Gate.
+func (q *writeQueue) Done() {
+	q.gate.Done()
+}
@@ -0,0 +1,70 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/thanos-io/thanos/pkg/gate"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+func TestWriteQueue(t *testing.T) {
+	q := newWriteQueue(prometheus.NewRegistry(), gate.New(nil, 1, gate.WriteRequests), 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The first request takes the only slot of the gate, the second one waits for it.
+	testutil.Ok(t, q.Start(ctx))
+	started := make(chan error)
+	go func() { started <- q.Start(ctx) }()
+	testutil.Ok(t, runutil.Retry(10*time.Millisecond, ctx.Done(), func() error {
+		if promtestutil.ToFloat64(q.depth) != 1 {
+			return errors.New("request not queued yet")
+		}
+		return nil
+	}))
+
+	// The third request does not fit into the queue.
+	err := q.Start(ctx)
+	testutil.Assert(t, errors.Is(err, errWriteQueueFull), "expected queue full error, got %v", err)
+	var rerr retryAfterError
+	testutil.Assert(t, errors.As(err, &rerr), "expected retry after error")
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(q.rejected))
+
+	q.Done()
+	testutil.Ok(t, <-started)
+	q.Done()
+	testutil.Equals(t, 0.0, promtestutil.ToFloat64(q.depth))
+	testutil.Assert(t, q.avgWait.Load() > 0, "expected the wait of the queued request to be recorded")
+}
+
+func TestHTTPErrorRetryAfter(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		err        error
+		retryAfter string
+	}{
+		{name: "no retry after", err: errors.New("bad request")},
+		{name: "rounded up", err: retryAfterError{error: errWriteQueueFull, retryAfter: 1500 * time.Millisecond}, retryAfter: "2"},
+		{name: "at least one second", err: retryAfterError{error: errWriteQueueFull}, retryAfter: "1"},
+		{name: "capped", err: errors.Wrap(retryAfterError{error: errWriteQueueFull, retryAfter: time.Hour}, "write"), retryAfter: "60"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			httpError(rec, tc.err, http.StatusTooManyRequests)
+			testutil.Equals(t, http.StatusTooManyRequests, rec.Code)
+			testutil.Equals(t, tc.retryAfter, rec.Header().Get("Retry-After"))
+		})
+	}
+}
@@ -5,12 +5,11 @@ package receive
 
 import (
 	"context"
-	"crypto/md5"
-	"encoding/binary"
 	"encoding/json"
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/go-kit/log"
@@ -20,6 +19,8 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/model"
 	"gopkg.in/fsnotify.v1"
+
+	"github.com/thanos-io/thanos/pkg/extprom"
 )
 
 var (
@@ -42,10 +43,44 @@ const (
 type HashringConfig struct {
 	Hashring  string            `json:"hashring,omitempty"`
 	Tenants   []string          `json:"tenants,omitempty"`
-	Endpoints []string          `json:"endpoints"`
+	Endpoints []Endpoint        `json:"endpoints"`
 	Algorithm HashringAlgorithm `json:"algorithm,omitempty"`
 }
 
+// Endpoint is a receive node of a hashring and the availability zone it runs in.
+type Endpoint struct {
+	Address string `json:"address"`
+	AZ      string `json:"az,omitempty"`
+}
+
+// UnmarshalJSON unmarshals an endpoint either from its address or from an object with its address and availability zone.
+func (e *Endpoint) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &e.Address); err == nil {
+		return nil
+	}
+
+	// Use an alias type to not recurse into this method.
+	type endpointAlias Endpoint
+	var endpoint endpointAlias
+	if err := json.Unmarshal(data, &endpoint); err != nil {
+		return err
+	}
+	*e = Endpoint(endpoint)
+	return nil
+}
+
+// MarshalJSON marshals an endpoint without an availability zone as its address, as before availability zones were
+// supported, and as an object otherwise.
+func (e Endpoint) MarshalJSON() ([]byte, error) {
+	if e.AZ == "" {
+		return json.Marshal(e.Address)
+	}
+
+	// Use an alias type to not recurse into this method.
+	type endpointAlias Endpoint
+	return json.Marshal(endpointAlias(e))
+}
+
 // ConfigWatcher is able to watch a file containing a hashring configuration
 // for updates.
 type ConfigWatcher struct {
@@ -66,6 +101,12 @@ type ConfigWatcher struct {
 
 	// lastLoadedConfigHash is the hash of the last successfully loaded configuration.
 	lastLoadedConfigHash float64
+
+	mtx sync.Mutex
+	// lastRead is the time of the last successful read of the configuration file, changed or not.
+	lastRead time.Time
+	// lastErr is the error of the last read of the configuration file, if it failed.
+	lastErr error
 }
 
 // NewConfigWatcher creates a new ConfigWatcher.
@@ -190,6 +231,24 @@ func (cw *ConfigWatcher) ValidateConfig() error {
 	return err
 }
 
+// Check returns an error if the last read of the configuration file failed, or if the file was not read for more
+// than twice the refresh interval. In both cases, the hashring in use may not match the file anymore.
+func (cw *ConfigWatcher) Check(context.Context) error {
+	cw.mtx.Lock()
+	defer cw.mtx.Unlock()
+
+	if cw.lastErr != nil {
+		return errors.Wrap(cw.lastErr, "reading hashring configuration file")
+	}
+	if cw.lastRead.IsZero() {
+		return errors.New("hashring configuration file not read yet")
+	}
+	if since := time.Since(cw.lastRead); since > 2*cw.interval {
+		return errors.Errorf("hashring configuration file not read for %s", since.Round(time.Second))
+	}
+	return nil
+}
+
 // Stop shuts down the config watcher.
 func (cw *ConfigWatcher) Stop() {
 	level.Debug(cw.logger).Log("msg", "stopping hashring configuration watcher...", "path", cw.path)
@@ -222,6 +281,12 @@ func (cw *ConfigWatcher) refresh(ctx context.Context) {
 	cw.refreshCounter.Inc()
 
 	config, cfgHash, err := loadConfig(cw.logger, cw.path)
+	cw.mtx.Lock()
+	cw.lastErr = err
+	if err == nil {
+		cw.lastRead = time.Now()
+	}
+	cw.mtx.Unlock()
 	if err != nil {
 		cw.errorCounter.Inc()
 		level.Error(cw.logger).Log("msg", "failed to load configuration file", "err", err, "path", cw.path)
@@ -272,7 +337,7 @@ func loadConfig(logger log.Logger, path string) ([]HashringConfig, float64, erro
 		return nil, 0, errors.Wrapf(errEmptyConfigurationFile, "failed to load configuration file, path: %s", path)
 	}
 
-	return config, hashAsMetricValue(cfgContent), nil
+	return config, extprom.HashAsMetricValue(cfgContent), nil
 }
 
 // readFile reads the configuration file and returns content of configuration file.
@@ -296,13 +361,3 @@ func parseConfig(content []byte) ([]HashringConfig, error) {
 	err := json.Unmarshal(content, &config)
 	return config, err
 }
-
-// hashAsMetricValue generates metric value from hash of data.
-func hashAsMetricValue(data []byte) float64 {
-	sum := md5.Sum(data)
-	// We only want 48 bits as a float64 only has a 53 bit mantissa.
-	smallSum := sum[0:6]
-	var bytes = make([]byte, 8)
-	copy(bytes, smallSum)
-	return float64(binary.LittleEndian.Uint64(bytes))
-}
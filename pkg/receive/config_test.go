@@ -4,11 +4,15 @@
 package receive
 
 import (
+	"context"
 	"encoding/json"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
 
 	"github.com/efficientgo/core/testutil"
 )
@@ -38,7 +42,7 @@ func TestValidateConfig(t *testing.T) {
 			name: "valid config",
 			cfg: []HashringConfig{
 				{
-					Endpoints: []string{"node1"},
+					Endpoints: []Endpoint{{Address: "node1"}},
 				},
 			},
 			err: nil, // means it's valid.
@@ -71,3 +75,47 @@ func TestValidateConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigWatcherCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashrings.json")
+	testutil.Ok(t, os.WriteFile(path, []byte(`[{"endpoints": ["node1"]}]`), os.ModePerm))
+
+	cw, err := NewConfigWatcher(nil, nil, path, model.Duration(time.Minute))
+	testutil.Ok(t, err)
+	defer cw.Stop()
+
+	testutil.NotOk(t, cw.Check(context.Background()))
+
+	// Refreshing with a canceled context does not wait for the configuration to be consumed.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cw.refresh(ctx)
+	testutil.Ok(t, cw.Check(context.Background()))
+
+	testutil.Ok(t, os.WriteFile(path, []byte(`[{"endpoints": [`), os.ModePerm))
+	cw.refresh(ctx)
+	testutil.NotOk(t, cw.Check(context.Background()))
+
+	testutil.Ok(t, os.WriteFile(path, []byte(`[{"endpoints": ["node1"]}]`), os.ModePerm))
+	cw.refresh(ctx)
+	testutil.Ok(t, cw.Check(context.Background()))
+
+	cw.mtx.Lock()
+	cw.lastRead = time.Now().Add(-3 * time.Minute)
+	cw.mtx.Unlock()
+	testutil.NotOk(t, cw.Check(context.Background()))
+}
+
+func TestParseConfigEndpoints(t *testing.T) {
+	cfg, err := parseConfig([]byte(`[{"endpoints": ["node1", {"address": "node2", "az": "zone-a"}]}]`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, []Endpoint{{Address: "node1"}, {Address: "node2", AZ: "zone-a"}}, cfg[0].Endpoints)
+
+	_, err = parseConfig([]byte(`[{"endpoints": [1]}]`))
+	testutil.NotOk(t, err)
+
+	// Endpoints without an availability zone are marshaled as their address.
+	b, err := json.Marshal(cfg)
+	testutil.Ok(t, err)
+	testutil.Equals(t, `[{"endpoints":["node1",{"address":"node2","az":"zone-a"}]}]`, string(b))
+}
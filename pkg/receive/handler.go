@@ -70,11 +70,17 @@ const (
 	CertificateFieldOrganization       = "organization"
 	CertificateFieldOrganizationalUnit = "organizationalUnit"
 	CertificateFieldCommonName         = "commonName"
+	// CertificateFieldDNSName is the first DNS name of the subject alternative names.
+	CertificateFieldDNSName = "dnsName"
+	// CertificateFieldURI is the first URI of the subject alternative names, e.g. a SPIFFE ID.
+	CertificateFieldURI = "uri"
 )
 
 var (
 	// errConflict is returned whenever an operation fails due to any conflict-type error.
 	errConflict = errors.New("conflict")
+	// errTenantLimited is returned whenever an operation fails because the tenant reached one of its limits.
+	errTenantLimited = errors.New("tenant limit reached")
 
 	errBadReplica  = errors.New("request replica exceeds receiver replication factor")
 	errNotReady    = errors.New("target not ready")
@@ -82,6 +88,18 @@ var (
 	errInternal    = errors.New("internal error")
 )
 
+// WriteConsistency is the number of replicas that have to acknowledge a write request before it succeeds.
+type WriteConsistency string
+
+const (
+	// WriteConsistencyOne requires a single replica to acknowledge the write request.
+	WriteConsistencyOne WriteConsistency = "one"
+	// WriteConsistencyQuorum requires a majority of the replicas to acknowledge the write request.
+	WriteConsistencyQuorum WriteConsistency = "quorum"
+	// WriteConsistencyAll requires all replicas to acknowledge the write request.
+	WriteConsistencyAll WriteConsistency = "all"
+)
+
 // Options for the web Handler.
 type Options struct {
 	Writer            *Writer
@@ -93,14 +111,48 @@ type Options struct {
 	ReplicaHeader     string
 	Endpoint          string
 	ReplicationFactor uint64
+	WriteConsistency  WriteConsistency
 	ReceiverMode      ReceiverMode
 	Tracer            opentracing.Tracer
 	TLSConfig         *tls.Config
 	DialOpts          []grpc.DialOption
 	ForwardTimeout    time.Duration
 	RelabelConfigs    []*relabel.Config
+	// TenantWriteConfig holds the relabeling configs and validation rules specific to tenants, if any.
+	TenantWriteConfig *TenantWriteConfig
 	TSDBStats         TSDBStats
 	Limiter           *Limiter
+	// TenantJWT derives the tenant from a claim of the JWT bearer token of write requests, if set.
+	TenantJWT *TenantJWTValidator
+	// TenantMapping maps the identities read from client certificates or JWT claims to tenants.
+	// If set, write requests with identities without mapping are rejected.
+	TenantMapping map[string]string
+	// Mirror mirrors the accepted series to remote write endpoints, if set.
+	Mirror *Mirror
+	// TenantUsage accounts the accepted series per tenant, if set.
+	TenantUsage *TenantUsage
+	// OTLPTenantAttribute is the OTLP resource attribute holding the tenant of the resource's metrics, if any.
+	OTLPTenantAttribute string
+	// ReplicationProtocol is the protocol used to forward write requests to other receivers.
+	ReplicationProtocol ReplicationProtocol
+	// ReplicationStreamQueueSize is the maximum number of pending write requests per peer when streaming replication is used,
+	// and the maximum number of write requests of a single incoming replication stream handled concurrently.
+	ReplicationStreamQueueSize int
+	// ReplicationStreamBatchSize is the maximum number of queued write requests merged into a single request when
+	// streaming replication is used.
+	ReplicationStreamBatchSize int
+	// HashringTransitionWindow is the time after a hashring change during which write requests failing because
+	// receivers are not ready are buffered and retried, instead of failing. 0 disables buffering.
+	HashringTransitionWindow time.Duration
+	// HashringTransitionMaxBuffered is the maximum number of write requests buffered at once during a hashring transition.
+	HashringTransitionMaxBuffered int
+	// ReplicationAbortOnQuorum cancels the forward requests still outstanding once the write quorum is reached,
+	// instead of letting them run until they time out. Series are then only guaranteed to be written to a quorum of replicas.
+	ReplicationAbortOnQuorum bool
+	// Authenticator rejects unauthenticated write requests, if set.
+	Authenticator *middleware.Authenticator
+	// HTTPLoggingOptions configure the request logging of the HTTP endpoints.
+	HTTPLoggingOptions []logging.Option
 }
 
 // Handler serves a Prometheus remote write receiving HTTP endpoint.
@@ -117,13 +169,21 @@ type Handler struct {
 	expBackoff   backoff.Backoff
 	peerStates   map[string]*retryState
 	receiverMode ReceiverMode
+	// hashringChangedAt is the time the hashring was last set.
+	hashringChangedAt time.Time
 
 	forwardRequests   *prometheus.CounterVec
+	forwardDuration   *prometheus.HistogramVec
 	replications      *prometheus.CounterVec
 	replicationFactor prometheus.Gauge
 
 	writeSamplesTotal    *prometheus.HistogramVec
 	writeTimeseriesTotal *prometheus.HistogramVec
+	seriesRejected       *prometheus.CounterVec
+
+	transitionSlots    chan struct{}
+	transitionRequests *prometheus.CounterVec
+	transitionBuffered prometheus.Gauge
 
 	Limiter *Limiter
 }
@@ -143,7 +203,7 @@ func NewHandler(logger log.Logger, o *Options) *Handler {
 		writer:       o.Writer,
 		router:       route.New(),
 		options:      o,
-		peers:        newPeerGroup(o.DialOpts...),
+		peers:        newPeerGroup(o.ReplicationProtocol, o.ReplicationStreamQueueSize, o.ReplicationStreamBatchSize, o.DialOpts...),
 		receiverMode: o.ReceiverMode,
 		expBackoff: backoff.Backoff{
 			Factor: 2,
@@ -158,6 +218,13 @@ func NewHandler(logger log.Logger, o *Options) *Handler {
 				Help: "The number of forward requests.",
 			}, []string{"result"},
 		),
+		forwardDuration: promauto.With(registerer).NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "thanos_receive_forward_request_duration_seconds",
+				Help:    "The duration of forward requests, by receiver they are forwarded to.",
+				Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+			}, []string{"endpoint"},
+		),
 		replications: promauto.With(registerer).NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "thanos_receive_replications_total",
@@ -188,6 +255,33 @@ func NewHandler(logger log.Logger, o *Options) *Handler {
 				Buckets:   []float64{10, 50, 100, 500, 1000, 5000, 10000},
 			}, []string{"code", "tenant"},
 		),
+		seriesRejected: promauto.With(registerer).NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "thanos",
+				Subsystem: "receive",
+				Name:      "series_rejected_total",
+				Help:      "The number of series rejected because they break a validation rule of the tenant.",
+			}, []string{"tenant", "reason"},
+		),
+		transitionRequests: promauto.With(registerer).NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "thanos",
+				Subsystem: "receive",
+				Name:      "hashring_transition_requests_total",
+				Help:      "The number of write requests that failed because receivers were not ready during a hashring transition, by outcome of buffering them.",
+			}, []string{"result"},
+		),
+		transitionBuffered: promauto.With(registerer).NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: "thanos",
+				Subsystem: "receive",
+				Name:      "hashring_transition_buffered_requests",
+				Help:      "The number of write requests currently buffered until receivers are ready again after a hashring change.",
+			},
+		),
+	}
+	if o.HashringTransitionWindow > 0 && o.HashringTransitionMaxBuffered > 0 {
+		h.transitionSlots = make(chan struct{}, o.HashringTransitionMaxBuffered)
 	}
 
 	h.forwardRequests.WithLabelValues(labelSuccess)
@@ -210,6 +304,13 @@ func NewHandler(logger log.Logger, o *Options) *Handler {
 		)
 	}
 
+	logMiddleware := logging.NewHTTPServerMiddleware(logger, append(o.HTTPLoggingOptions, logging.WithTenantFunc(func(r *http.Request) string {
+		if tenant := r.Header.Get(o.TenantHeader); tenant != "" {
+			return tenant
+		}
+		return o.DefaultTenantID
+	}))...)
+
 	readyf := h.testReady
 	instrf := func(name string, next func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
 		next = ins.NewHandler(name, http.HandlerFunc(next))
@@ -226,7 +327,19 @@ func NewHandler(logger log.Logger, o *Options) *Handler {
 			"receive",
 			readyf(
 				middleware.RequestID(
-					http.HandlerFunc(h.receiveHTTP),
+					logMiddleware.HTTPMiddleware("receive", http.HandlerFunc(h.receiveHTTP)),
+				),
+			),
+		),
+	)
+
+	h.router.Post(
+		OTLPMetricsPath,
+		instrf(
+			"receive_otlp",
+			readyf(
+				middleware.RequestID(
+					logMiddleware.HTTPMiddleware("receive_otlp", http.HandlerFunc(h.receiveOTLPHTTP)),
 				),
 			),
 		),
@@ -236,7 +349,7 @@ func NewHandler(logger log.Logger, o *Options) *Handler {
 		GetStats: h.getStats,
 		Registry: h.options.Registry,
 	})
-	statusAPI.Register(h.router, o.Tracer, logger, ins, logging.NewHTTPServerMiddleware(logger))
+	statusAPI.Register(h.router, o.Tracer, logger, ins, logMiddleware)
 
 	return h
 }
@@ -250,8 +363,10 @@ func (h *Handler) Hashring(hashring Hashring) {
 	defer h.mtx.Unlock()
 
 	h.hashring = hashring
+	h.hashringChangedAt = time.Now()
 	h.expBackoff.Reset()
 	h.peerStates = make(map[string]*retryState)
+	h.peers.closeRemovedStreams(hashring.Nodes())
 }
 
 // Verifies whether the server is ready or not.
@@ -307,6 +422,7 @@ func (h *Handler) Close() {
 	if h.listener != nil {
 		runutil.CloseWithLogOnErr(h.logger, h.listener, "receive HTTP listener")
 	}
+	h.peers.close()
 }
 
 // Run serves the HTTP endpoints.
@@ -326,8 +442,13 @@ func (h *Handler) Run() error {
 
 	errlog := stdlog.New(log.NewStdlibAdapter(level.Error(h.logger)), "", 0)
 
+	var handler http.Handler = h.router
+	if h.options.Authenticator != nil {
+		handler = h.options.Authenticator.Handler(handler)
+	}
+
 	httpSrv := &http.Server{
-		Handler:   h.router,
+		Handler:   handler,
 		ErrorLog:  errlog,
 		TLSConfig: h.options.TLSConfig,
 	}
@@ -412,22 +533,14 @@ func (h *Handler) isTenantValid(tenant string) error {
 }
 
 func (h *Handler) receiveHTTP(w http.ResponseWriter, r *http.Request) {
-	var err error
 	span, ctx := tracing.StartSpan(r.Context(), "receive_http")
 	defer span.Finish()
 
-	tenant := r.Header.Get(h.options.TenantHeader)
-	if tenant == "" {
-		tenant = h.options.DefaultTenantID
-	}
-
-	if h.options.TenantField != "" {
-		tenant, err = h.getTenantFromCertificate(r)
-		if err != nil {
-			// This must hard fail to ensure hard tenancy when feature is enabled.
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
+	tenant, err := h.getTenant(r)
+	if err != nil {
+		// This must hard fail to ensure hard tenancy when feature is enabled.
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	err = h.isTenantValid(tenant)
@@ -439,29 +552,37 @@ func (h *Handler) receiveHTTP(w http.ResponseWriter, r *http.Request) {
 
 	tLogger := log.With(h.logger, "tenant", tenant)
 
+	// Requests of tenants above their concurrency limit are refused before waiting for the write gate, so that
+	// they do not hold back the requests of other tenants.
+	requestLimiter := h.Limiter.RequestLimiter()
+	allowed, done := requestLimiter.AllowConcurrency(tenant)
+	if !allowed {
+		httpError(w, retryAfterError{error: errors.New("too many concurrent requests"), retryAfter: time.Second}, http.StatusTooManyRequests)
+		return
+	}
+	defer done()
+
 	writeGate := h.Limiter.WriteGate()
 	tracing.DoInSpan(r.Context(), "receive_write_gate_ismyturn", func(ctx context.Context) {
 		err = writeGate.Start(r.Context())
 	})
-	defer writeGate.Done()
+	if errors.Is(err, errWriteQueueFull) {
+		httpError(w, err, http.StatusTooManyRequests)
+		return
+	}
 	if err != nil {
 		level.Error(tLogger).Log("err", err, "msg", "internal server error")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	under, err := h.Limiter.HeadSeriesLimiter.isUnderLimit(tenant)
-	if err != nil {
-		level.Error(tLogger).Log("msg", "error while limiting", "err", err.Error())
-	}
+	defer writeGate.Done()
 
 	// Fail request fully if tenant has exceeded set limit.
-	if !under {
-		http.Error(w, "tenant is above active series limit", http.StatusTooManyRequests)
+	if err := h.headSeriesLimitErr(tLogger, tenant); err != nil {
+		httpError(w, err, http.StatusTooManyRequests)
 		return
 	}
 
-	requestLimiter := h.Limiter.RequestLimiter()
 	// io.ReadAll dynamically adjust the byte slice for read data, starting from 512B.
 	// Since this is receive hot path, grow upfront saving allocations and CPU time.
 	compressed := bytes.Buffer{}
@@ -522,29 +643,111 @@ func (h *Handler) receiveHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if responseStatusCode, err := h.writeRequest(ctx, tLogger, requestLimiter, tenant, rep, &wreq); err != nil {
+		httpError(w, err, responseStatusCode)
+	}
+}
+
+// getTenant returns the tenant of the given HTTP write request, read from the tenant header, from the client
+// certificate if Options.TenantField is configured or from the bearer token if Options.TenantJWT is configured.
+func (h *Handler) getTenant(r *http.Request) (string, error) {
+	if !h.authenticatedTenancy() {
+		tenant := r.Header.Get(h.options.TenantHeader)
+		if tenant == "" {
+			tenant = h.options.DefaultTenantID
+		}
+		return tenant, nil
+	}
+
+	var (
+		identity string
+		err      error
+	)
+	if h.options.TenantField != "" {
+		identity, err = h.getTenantFromCertificate(r)
+	} else {
+		identity, err = h.options.TenantJWT.tenant(r)
+	}
+	if err != nil {
+		return "", err
+	}
+	if h.options.TenantMapping == nil {
+		return identity, nil
+	}
+	tenant, ok := h.options.TenantMapping[identity]
+	if !ok {
+		return "", errors.Errorf("no tenant mapped to identity %s", identity)
+	}
+	return tenant, nil
+}
+
+// authenticatedTenancy returns whether the tenant of write requests is derived from the client certificate
+// or the bearer token, rather than from a header any client can set.
+func (h *Handler) authenticatedTenancy() bool {
+	return h.options.TenantField != "" || h.options.TenantJWT != nil
+}
+
+// headSeriesLimitErr returns an error describing why the tenant is above its active series limit, if it is.
+// Clients are told to retry once the active series are queried from meta-monitoring again.
+func (h *Handler) headSeriesLimitErr(tLogger log.Logger, tenant string) error {
+	under, err := h.Limiter.HeadSeriesLimiter.isUnderLimit(tenant)
+	if !under {
+		if err == nil {
+			err = errors.New("tenant is above active series limit")
+		}
+		return retryAfterError{error: err, retryAfter: MetaMonitoringQueryInterval}
+	}
+	if err != nil {
+		level.Error(tLogger).Log("msg", "error while limiting", "err", err.Error())
+	}
+	return nil
+}
+
+// writeRequest applies the request limits, the relabeling configs and the validation rules to the decoded write request of a tenant
+// and then forwards it. It returns the HTTP status code describing the outcome along with the error, if any.
+func (h *Handler) writeRequest(ctx context.Context, tLogger log.Logger, requestLimiter requestLimiter, tenant string, rep uint64, wreq *prompb.WriteRequest) (int, error) {
 	if !requestLimiter.AllowSeries(tenant, int64(len(wreq.Timeseries))) {
-		http.Error(w, "too many timeseries", http.StatusRequestEntityTooLarge)
-		return
+		return http.StatusRequestEntityTooLarge, errors.New("too many timeseries")
 	}
 
 	totalSamples := 0
 	for _, timeseries := range wreq.Timeseries {
-		totalSamples += len(timeseries.Samples)
+		totalSamples += len(timeseries.Samples) + len(timeseries.Histograms)
 	}
 	if !requestLimiter.AllowSamples(tenant, int64(totalSamples)) {
-		http.Error(w, "too many samples", http.StatusRequestEntityTooLarge)
-		return
+		return http.StatusRequestEntityTooLarge, errors.New("too many samples")
+	}
+
+	if !requestLimiter.AllowSamplesPerSecond(tenant, int64(totalSamples)) {
+		err := errors.New("samples per second limit exceeded")
+		if retryAfter := requestLimiter.SamplesPerSecondRetryAfter(tenant, int64(totalSamples)); retryAfter > 0 {
+			err = retryAfterError{error: err, retryAfter: retryAfter}
+		}
+		return http.StatusTooManyRequests, err
 	}
 
 	// Apply relabeling configs.
-	h.relabel(&wreq)
+	h.relabel(tenant, wreq)
 	if len(wreq.Timeseries) == 0 {
 		level.Debug(tLogger).Log("msg", "remote write request dropped due to relabeling.")
-		return
+		return http.StatusOK, nil
+	}
+
+	// Series breaking the validation rules are rejected, the valid ones are still written.
+	rejectErr := h.applyWriteRules(tenant, wreq)
+	if rejectErr != nil {
+		level.Debug(tLogger).Log("msg", "series rejected by validation rules", "err", rejectErr)
+		if len(wreq.Timeseries) == 0 {
+			return http.StatusBadRequest, rejectErr
+		}
 	}
 
 	responseStatusCode := http.StatusOK
-	if err = h.handleRequest(ctx, rep, tenant, &wreq); err != nil {
+	err := h.handleRequest(ctx, rep, tenant, wreq)
+	if err != nil {
+		err = h.replayDuringHashringTransition(ctx, tLogger, rep, tenant, wreq, err)
+	}
+	if err != nil {
 		level.Debug(tLogger).Log("msg", "failed to handle request", "err", err)
 		switch errors.Cause(err) {
 		case errNotReady:
@@ -553,16 +756,26 @@ func (h *Handler) receiveHTTP(w http.ResponseWriter, r *http.Request) {
 			responseStatusCode = http.StatusServiceUnavailable
 		case errConflict:
 			responseStatusCode = http.StatusConflict
+		case errTenantLimited:
+			// The limits of the TSDB of tenants are only lifted by the next head compaction or WAL size check.
+			responseStatusCode = http.StatusTooManyRequests
+			err = retryAfterError{error: err, retryAfter: time.Minute}
 		case errBadReplica:
 			responseStatusCode = http.StatusBadRequest
 		default:
 			level.Error(tLogger).Log("err", err, "msg", "internal server error")
 			responseStatusCode = http.StatusInternalServerError
 		}
-		http.Error(w, err.Error(), responseStatusCode)
+	} else {
+		h.options.Mirror.Write(tenant, wreq)
+		h.options.TenantUsage.Add(tenant, wreq)
+		if rejectErr != nil {
+			responseStatusCode, err = http.StatusBadRequest, rejectErr
+		}
 	}
 	h.writeTimeseriesTotal.WithLabelValues(strconv.Itoa(responseStatusCode), tenant).Observe(float64(len(wreq.Timeseries)))
 	h.writeSamplesTotal.WithLabelValues(strconv.Itoa(responseStatusCode), tenant).Observe(float64(totalSamples))
+	return responseStatusCode, err
 }
 
 // forward accepts a write request, batches its time series by
@@ -622,7 +835,17 @@ func (h *Handler) forward(ctx context.Context, tenant string, r replica, wreq *p
 
 // writeQuorum returns minimum number of replicas that has to confirm write success before claiming replication success.
 func (h *Handler) writeQuorum() int {
-	return int((h.options.ReplicationFactor / 2) + 1)
+	switch h.options.WriteConsistency {
+	case WriteConsistencyOne:
+		return 1
+	case WriteConsistencyAll:
+		if h.options.ReplicationFactor > 1 {
+			return int(h.options.ReplicationFactor)
+		}
+		return 1
+	default:
+		return int((h.options.ReplicationFactor / 2) + 1)
+	}
 }
 
 func quorumReached(successes []int, successThreshold int) bool {
@@ -640,12 +863,13 @@ func quorumReached(successes []int, successThreshold int) bool {
 func (h *Handler) fanoutForward(pctx context.Context, tenant string, wreqs map[endpointReplica]trackedSeries, numSeries int, seriesReplicated bool) error {
 	var errs writeErrors
 
-	fctx, cancel := context.WithTimeout(tracing.CopyTraceContext(context.Background(), pctx), h.options.ForwardTimeout)
+	fctx, cancel := context.WithDeadline(tracing.CopyTraceContext(context.Background(), pctx), h.forwardDeadline(pctx))
 	defer func() {
-		if errs.ErrOrNil() != nil {
+		if errs.ErrOrNil() != nil || h.options.ReplicationAbortOnQuorum {
 			// NOTICE: The cancel function is not used on all paths intentionally,
 			// if there is no error when quorum is reached,
-			// let forward requests to optimistically run until timeout.
+			// let forward requests to optimistically run until timeout,
+			// unless they are configured to be aborted.
 			cancel()
 		}
 	}()
@@ -734,13 +958,15 @@ func (h *Handler) fanoutForward(pctx context.Context, tenant string, wreqs map[e
 
 			// Create a span to track the request made to another receive node.
 			tracing.DoInSpan(fctx, "receive_forward", func(ctx context.Context) {
+				start := time.Now()
 				// Actually make the request against the endpoint we determined should handle these time series.
-				_, err = cl.RemoteWrite(ctx, &storepb.WriteRequest{
+				err = h.peers.write(ctx, writeTarget.endpoint, cl, &storepb.WriteRequest{
 					Timeseries: wreqs[writeTarget].timeSeries,
 					Tenant:     tenant,
 					// Increment replica since on-the-wire format is 1-indexed and 0 indicates un-replicated.
 					Replica: int64(writeTarget.replica + 1),
 				})
+				h.forwardDuration.WithLabelValues(writeTarget.endpoint).Observe(time.Since(start).Seconds())
 			})
 			if err != nil {
 				// Check if peer connection is unavailable, don't attempt to send requests constantly.
@@ -787,12 +1013,18 @@ func (h *Handler) fanoutForward(pctx context.Context, tenant string, wreqs map[e
 		}()
 	}()
 
-	quorum := h.writeQuorum()
-	if seriesReplicated {
-		quorum = 1
+	quorum, replicas := h.writeQuorum(), int(h.options.ReplicationFactor)
+	if seriesReplicated || replicas < 1 {
+		quorum, replicas = 1, 1
 	}
 	successes := make([]int, numSeries)
-	seriesErrs := newReplicationErrors(quorum, numSeries)
+	// The errors of a series determine the error of the request once they reach the quorum. With the one and all
+	// consistency levels, they do once more of its replicas failed than the consistency level tolerates.
+	threshold := quorum
+	if h.options.WriteConsistency == WriteConsistencyOne || h.options.WriteConsistency == WriteConsistencyAll {
+		threshold = replicas - quorum + 1
+	}
+	seriesErrs := newReplicationErrors(threshold, numSeries)
 	for {
 		select {
 		case <-fctx.Done():
@@ -806,8 +1038,23 @@ func (h *Handler) fanoutForward(pctx context.Context, tenant string, wreqs map[e
 			}
 
 			if wresp.err != nil {
+				var quorumFailed bool
 				for _, tsID := range wresp.seriesIDs {
 					seriesErrs[tsID].Add(wresp.err)
+					quorumFailed = quorumFailed || len(seriesErrs[tsID].errs) >= seriesErrs[tsID].threshold
+				}
+				// Once a series cannot reach the quorum anymore because replicas are unavailable or not ready,
+				// the request fails with a retryable error whatever the outstanding forward requests return,
+				// so do not wait for them. Other errors may still turn into conflicts, which are not retried.
+				if quorumFailed {
+					var ferrs writeErrors
+					for _, rerr := range seriesErrs {
+						ferrs.Add(rerr)
+					}
+					if cause := errors.Cause(&ferrs); cause == errUnavailable || cause == errNotReady {
+						errs = ferrs
+						return errs.ErrOrNil()
+					}
 				}
 				continue
 			}
@@ -821,8 +1068,79 @@ func (h *Handler) fanoutForward(pctx context.Context, tenant string, wreqs map[e
 	}
 }
 
+// forwardDeadline returns the deadline of the forward requests of a write request. It is the forward timeout,
+// or the deadline of the write request if it is earlier, since its client does not wait for them longer.
+func (h *Handler) forwardDeadline(ctx context.Context) time.Time {
+	deadline := time.Now().Add(h.options.ForwardTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		return d
+	}
+	return deadline
+}
+
 // RemoteWrite implements the gRPC remote write handler for storepb.WriteableStore.
 func (h *Handler) RemoteWrite(ctx context.Context, r *storepb.WriteRequest) (*storepb.WriteResponse, error) {
+	if err := h.remoteWrite(ctx, r); err != nil {
+		return nil, err
+	}
+	return &storepb.WriteResponse{}, nil
+}
+
+// RemoteWriteStream implements the gRPC streaming remote write handler for storepb.WriteableStore.
+// Requests received on the stream are handled concurrently, up to the replication stream queue size, and acknowledged
+// as soon as they are handled. Once the limit is reached, no further requests are received until one is handled.
+func (h *Handler) RemoteWriteStream(srv storepb.WriteableStore_RemoteWriteStreamServer) error {
+	concurrency := h.options.ReplicationStreamQueueSize
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var (
+		wg      sync.WaitGroup
+		sendMtx sync.Mutex
+		slots   = make(chan struct{}, concurrency)
+	)
+	defer wg.Wait()
+
+	for {
+		r, err := srv.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(r *storepb.WriteStreamRequest) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			ctx := srv.Context()
+			if r.TimeoutMilliseconds > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, time.Duration(r.TimeoutMilliseconds)*time.Millisecond)
+				defer cancel()
+			}
+
+			resp := &storepb.WriteStreamResponse{Id: r.Id}
+			if err := h.remoteWrite(ctx, &r.Request); err != nil {
+				st, _ := status.FromError(err)
+				resp.Code = int32(st.Code())
+				resp.Message = st.Message()
+			}
+
+			sendMtx.Lock()
+			defer sendMtx.Unlock()
+			if err := srv.Send(resp); err != nil {
+				level.Debug(h.logger).Log("msg", "failed to acknowledge streamed request", "err", err)
+			}
+		}(r)
+	}
+}
+
+// remoteWrite handles a write request received from another receiver and returns a gRPC status error if it failed.
+func (h *Handler) remoteWrite(ctx context.Context, r *storepb.WriteRequest) error {
 	span, ctx := tracing.StartSpan(ctx, "receive_grpc")
 	defer span.Finish()
 
@@ -832,29 +1150,36 @@ func (h *Handler) RemoteWrite(ctx context.Context, r *storepb.WriteRequest) (*st
 	}
 	switch errors.Cause(err) {
 	case nil:
-		return &storepb.WriteResponse{}, nil
+		return nil
 	case errNotReady:
-		return nil, status.Error(codes.Unavailable, err.Error())
+		return status.Error(codes.Unavailable, err.Error())
 	case errUnavailable:
-		return nil, status.Error(codes.Unavailable, err.Error())
+		return status.Error(codes.Unavailable, err.Error())
 	case errConflict:
-		return nil, status.Error(codes.AlreadyExists, err.Error())
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errTenantLimited:
+		return status.Error(codes.ResourceExhausted, err.Error())
 	case errBadReplica:
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		return status.Error(codes.InvalidArgument, err.Error())
 	default:
-		return nil, status.Error(codes.Internal, err.Error())
+		return status.Error(codes.Internal, err.Error())
 	}
 }
 
-// relabel relabels the time series labels in the remote write request.
-func (h *Handler) relabel(wreq *prompb.WriteRequest) {
-	if len(h.options.RelabelConfigs) == 0 {
+// relabel relabels the time series labels in the remote write request, first with the relabeling configs
+// shared by all tenants and then with the ones of the tenant.
+func (h *Handler) relabel(tenant string, wreq *prompb.WriteRequest) {
+	relabelConfigs := h.options.RelabelConfigs
+	if rules := h.options.TenantWriteConfig.rules(tenant); rules != nil && len(rules.RelabelConfigs) > 0 {
+		relabelConfigs = append(relabelConfigs[:len(relabelConfigs):len(relabelConfigs)], rules.RelabelConfigs...)
+	}
+	if len(relabelConfigs) == 0 {
 		return
 	}
 	timeSeries := make([]prompb.TimeSeries, 0, len(wreq.Timeseries))
 	for _, ts := range wreq.Timeseries {
 		var keep bool
-		lbls, keep := relabel.Process(labelpb.ZLabelsToPromLabels(ts.Labels), h.options.RelabelConfigs...)
+		lbls, keep := relabel.Process(labelpb.ZLabelsToPromLabels(ts.Labels), relabelConfigs...)
 		if !keep {
 			continue
 		}
@@ -882,7 +1207,8 @@ func isSampleConflictErr(err error) bool {
 	return err == storage.ErrDuplicateSampleForTimestamp ||
 		err == storage.ErrOutOfOrderSample ||
 		err == storage.ErrOutOfBounds ||
-		err == storage.ErrTooOldSample
+		err == storage.ErrTooOldSample ||
+		err == storage.ErrNativeHistogramsDisabled
 }
 
 // isExemplarConflictErr returns whether or not the given error represents
@@ -901,6 +1227,15 @@ func isLabelsConflictErr(err error) bool {
 		err == labelpb.ErrOutOfOrderLabels
 }
 
+// isTenantLimited returns whether or not the given error represents
+// a tenant exceeding one of its TSDB resource limits.
+func isTenantLimited(err error) bool {
+	return err == errTenantLimited ||
+		err == errTenantHeadSeriesLimit ||
+		err == errTenantWALSizeLimit ||
+		status.Code(err) == codes.ResourceExhausted
+}
+
 // isNotReady returns whether or not the given error represents a not ready error.
 func isNotReady(err error) bool {
 	return err == errNotReady ||
@@ -1023,6 +1358,7 @@ func (es *writeErrors) Cause() error {
 	expErrs := expectedErrors{
 		{err: errUnavailable, cause: isUnavailable},
 		{err: errNotReady, cause: isNotReady},
+		{err: errTenantLimited, cause: isTenantLimited},
 		{err: errConflict, cause: isConflict},
 	}
 
@@ -1072,6 +1408,7 @@ func (es *replicationErrors) Cause() error {
 
 	expErrs := expectedErrors{
 		{err: errConflict, cause: isConflict},
+		{err: errTenantLimited, cause: isTenantLimited},
 		{err: errNotReady, cause: isNotReady},
 		{err: errUnavailable, cause: isUnavailable},
 	}
@@ -1105,12 +1442,16 @@ func newReplicationErrors(threshold, numErrors int) []*replicationErrors {
 	return errs
 }
 
-func newPeerGroup(dialOpts ...grpc.DialOption) *peerGroup {
+func newPeerGroup(protocol ReplicationProtocol, streamQueueSize, streamBatchSize int, dialOpts ...grpc.DialOption) *peerGroup {
 	return &peerGroup{
-		dialOpts: dialOpts,
-		cache:    map[string]storepb.WriteableStoreClient{},
-		m:        sync.RWMutex{},
-		dialer:   grpc.DialContext,
+		dialOpts:        dialOpts,
+		cache:           map[string]storepb.WriteableStoreClient{},
+		protocol:        protocol,
+		streamQueueSize: streamQueueSize,
+		streamBatchSize: streamBatchSize,
+		streams:         map[string]*peerStream{},
+		m:               sync.RWMutex{},
+		dialer:          grpc.DialContext,
 	}
 }
 
@@ -1119,6 +1460,12 @@ type peerGroup struct {
 	cache    map[string]storepb.WriteableStoreClient
 	m        sync.RWMutex
 
+	protocol        ReplicationProtocol
+	streamQueueSize int
+	streamBatchSize int
+	streams         map[string]*peerStream
+	streamsMtx      sync.Mutex
+
 	// dialer is used for testing.
 	dialer func(ctx context.Context, target string, opts ...grpc.DialOption) (conn *grpc.ClientConn, err error)
 }
@@ -1149,12 +1496,57 @@ func (p *peerGroup) get(ctx context.Context, addr string) (storepb.WriteableStor
 	return client, nil
 }
 
+// write sends the write request to the peer at addr using its client. With streaming replication,
+// the request is queued on the long-lived replication stream to the peer.
+func (p *peerGroup) write(ctx context.Context, addr string, client storepb.WriteableStoreClient, req *storepb.WriteRequest) error {
+	if p.protocol != ReplicationProtocolStream {
+		_, err := client.RemoteWrite(ctx, req)
+		return err
+	}
+
+	p.streamsMtx.Lock()
+	s, ok := p.streams[addr]
+	if !ok {
+		s = newPeerStream(client, p.streamQueueSize, p.streamBatchSize)
+		p.streams[addr] = s
+	}
+	p.streamsMtx.Unlock()
+	return s.write(ctx, req)
+}
+
+// closeRemovedStreams closes the replication streams to the peers which are not one of the given nodes anymore.
+func (p *peerGroup) closeRemovedStreams(nodes []string) {
+	keep := make(map[string]struct{}, len(nodes))
+	for _, node := range nodes {
+		keep[node] = struct{}{}
+	}
+
+	p.streamsMtx.Lock()
+	defer p.streamsMtx.Unlock()
+	for addr, s := range p.streams {
+		if _, ok := keep[addr]; !ok {
+			s.close()
+			delete(p.streams, addr)
+		}
+	}
+}
+
+// close closes the replication streams to all peers.
+func (p *peerGroup) close() {
+	p.streamsMtx.Lock()
+	defer p.streamsMtx.Unlock()
+	for addr, s := range p.streams {
+		s.close()
+		delete(p.streams, addr)
+	}
+}
+
 // getTenantFromCertificate extracts the tenant value from a client's presented certificate. The x509 field to use as
 // value can be configured with Options.TenantField. An error is returned when the extraction has not succeeded.
 func (h *Handler) getTenantFromCertificate(r *http.Request) (string, error) {
 	var tenant string
 
-	if len(r.TLS.PeerCertificates) == 0 {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
 		return "", errors.New("could not get required certificate field from client cert")
 	}
 
@@ -1181,6 +1573,18 @@ func (h *Handler) getTenantFromCertificate(r *http.Request) (string, error) {
 		}
 		tenant = cert.Subject.CommonName
 
+	case CertificateFieldDNSName:
+		if len(cert.DNSNames) == 0 {
+			return "", errors.New("could not get dnsName field from client cert")
+		}
+		tenant = cert.DNSNames[0]
+
+	case CertificateFieldURI:
+		if len(cert.URIs) == 0 {
+			return "", errors.New("could not get uri field from client cert")
+		}
+		tenant = cert.URIs[0].String()
+
 	default:
 		return "", errors.New("tls client cert field requested is not supported")
 	}
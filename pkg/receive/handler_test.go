@@ -30,6 +30,7 @@ import (
 	"github.com/golang/snappy"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/model/exemplar"
 	"github.com/prometheus/prometheus/model/histogram"
@@ -172,6 +173,7 @@ func newTestHandlerHashring(appendables []*fakeAppendable, replicationFactor uin
 		dialOpts: nil,
 		m:        sync.RWMutex{},
 		cache:    map[string]storepb.WriteableStoreClient{},
+		streams:  map[string]*peerStream{},
 		dialer: func(context.Context, string, ...grpc.DialOption) (*grpc.ClientConn, error) {
 			// dialer should never be called since we are creating fake clients with fake addresses
 			// this protects against some leaking test that may attempt to dial random IP addresses
@@ -195,7 +197,7 @@ func newTestHandlerHashring(appendables []*fakeAppendable, replicationFactor uin
 		h.peers = peers
 		addr := ag.newAddr()
 		h.options.Endpoint = addr
-		cfg[0].Endpoints = append(cfg[0].Endpoints, h.options.Endpoint)
+		cfg[0].Endpoints = append(cfg[0].Endpoints, Endpoint{Address: h.options.Endpoint})
 		peers.cache[addr] = &fakeRemoteWriteGRPCServer{h: h}
 	}
 	// Use hashmod as default.
@@ -213,7 +215,7 @@ func newTestHandlerHashring(appendables []*fakeAppendable, replicationFactor uin
 	return handlers, hashring, nil
 }
 
-func testReceiveQuorum(t *testing.T, hashringAlgo HashringAlgorithm, withConsistencyDelay bool) {
+func testReceiveQuorum(t *testing.T, hashringAlgo HashringAlgorithm, withConsistencyDelay bool, protocol ReplicationProtocol) {
 	appenderErrFn := func() error { return errors.New("failed to get appender") }
 	conflictErrFn := func() error { return storage.ErrOutOfBounds }
 	tooOldSampleErrFn := func() error { return storage.ErrTooOldSample }
@@ -584,6 +586,11 @@ func testReceiveQuorum(t *testing.T, hashringAlgo HashringAlgorithm, withConsist
 			if err != nil {
 				t.Fatalf("unable to create test handler: %v", err)
 			}
+			// All handlers share the same peer group.
+			handlers[0].peers.protocol = protocol
+			handlers[0].peers.streamQueueSize = 10
+			handlers[0].peers.streamBatchSize = 10
+			defer handlers[0].peers.close()
 			tenant := "test"
 			// Test from the point of view of every node
 			// so that we know status code does not depend
@@ -646,19 +653,123 @@ func testReceiveQuorum(t *testing.T, hashringAlgo HashringAlgorithm, withConsist
 }
 
 func TestReceiveQuorumHashmod(t *testing.T) {
-	testReceiveQuorum(t, AlgorithmHashmod, false)
+	testReceiveQuorum(t, AlgorithmHashmod, false, ReplicationProtocolUnary)
 }
 
 func TestReceiveQuorumKetama(t *testing.T) {
-	testReceiveQuorum(t, AlgorithmKetama, false)
+	testReceiveQuorum(t, AlgorithmKetama, false, ReplicationProtocolUnary)
+}
+
+func TestReceiveQuorumStreamReplication(t *testing.T) {
+	testReceiveQuorum(t, AlgorithmHashmod, false, ReplicationProtocolStream)
 }
 
 func TestReceiveWithConsistencyDelayHashmod(t *testing.T) {
-	testReceiveQuorum(t, AlgorithmHashmod, true)
+	testReceiveQuorum(t, AlgorithmHashmod, true, ReplicationProtocolUnary)
 }
 
 func TestReceiveWithConsistencyDelayKetama(t *testing.T) {
-	testReceiveQuorum(t, AlgorithmKetama, true)
+	testReceiveQuorum(t, AlgorithmKetama, true, ReplicationProtocolUnary)
+}
+
+func TestReceiveWithConsistencyDelayStreamReplication(t *testing.T) {
+	testReceiveQuorum(t, AlgorithmHashmod, true, ReplicationProtocolStream)
+}
+
+func TestReceiveWriteConsistency(t *testing.T) {
+	appenderErrFn := func() error { return errors.New("failed to get appender") }
+	wreq := &prompb.WriteRequest{
+		Timeseries: makeSeriesWithValues(50),
+	}
+
+	for _, tc := range []struct {
+		name        string
+		consistency WriteConsistency
+		replicas    int
+		failing     int
+		status      int
+	}{
+		{name: "one with two failing replicas", consistency: WriteConsistencyOne, replicas: 3, failing: 2, status: http.StatusOK},
+		{name: "one with all replicas failing", consistency: WriteConsistencyOne, replicas: 3, failing: 3, status: http.StatusInternalServerError},
+		{name: "quorum with one failing replica", consistency: WriteConsistencyQuorum, replicas: 3, failing: 1, status: http.StatusOK},
+		{name: "quorum with two failing replicas", consistency: WriteConsistencyQuorum, replicas: 3, failing: 2, status: http.StatusInternalServerError},
+		{name: "quorum of two replicas with one failing replica", consistency: WriteConsistencyQuorum, replicas: 2, failing: 1, status: http.StatusInternalServerError},
+		{name: "quorum of four replicas with one failing replica", consistency: WriteConsistencyQuorum, replicas: 4, failing: 1, status: http.StatusOK},
+		{name: "quorum of four replicas with two failing replicas", consistency: WriteConsistencyQuorum, replicas: 4, failing: 2, status: http.StatusInternalServerError},
+		{name: "all without failing replicas", consistency: WriteConsistencyAll, replicas: 3, failing: 0, status: http.StatusOK},
+		{name: "all with one failing replica", consistency: WriteConsistencyAll, replicas: 3, failing: 1, status: http.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			appendables := make([]*fakeAppendable, tc.replicas)
+			for i := range appendables {
+				appendables[i] = &fakeAppendable{appender: newFakeAppender(nil, nil, nil)}
+				if i < tc.failing {
+					appendables[i].appenderErr = appenderErrFn
+				}
+			}
+			handlers, _, err := newTestHandlerHashring(appendables, uint64(tc.replicas), AlgorithmHashmod)
+			testutil.Ok(t, err)
+
+			for i, handler := range handlers {
+				handler.options.WriteConsistency = tc.consistency
+				rec, err := makeRequest(handler, "test", wreq)
+				testutil.Ok(t, err)
+				testutil.Equals(t, tc.status, rec.Code, "handler %d: unexpected status, body: %s", i, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestReceiveQuorumFailureDoesNotWaitForSlowReplicas(t *testing.T) {
+	notReadyErrFn := func() error { return tsdb.ErrNotReady }
+	release := make(chan struct{})
+	defer close(release)
+
+	// Two replicas are not ready, the third one hangs until the end of the test.
+	appendables := []*fakeAppendable{
+		{appender: newFakeAppender(nil, nil, nil), appenderErr: notReadyErrFn},
+		{appender: newFakeAppender(nil, nil, nil), appenderErr: notReadyErrFn},
+		{appender: newFakeAppender(nil, nil, nil), appenderErr: func() error { <-release; return nil }},
+	}
+	handlers, _, err := newTestHandlerHashring(appendables, 3, AlgorithmHashmod)
+	testutil.Ok(t, err)
+
+	type result struct {
+		rec *httptest.ResponseRecorder
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		rec, err := makeRequest(handlers[0], "test", &prompb.WriteRequest{Timeseries: makeSeriesWithValues(50)})
+		done <- result{rec: rec, err: err}
+	}()
+	var res result
+	select {
+	case res = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("write request waited for the hanging replica")
+	}
+	testutil.Ok(t, res.err)
+	testutil.Equals(t, http.StatusServiceUnavailable, res.rec.Code, "unexpected status, body: %s", res.rec.Body.String())
+	// Only the completed forward request to the second replica is observed.
+	testutil.Equals(t, 1, promtestutil.CollectAndCount(handlers[0].forwardDuration))
+}
+
+func TestForwardDeadline(t *testing.T) {
+	h := &Handler{options: &Options{ForwardTimeout: time.Minute}}
+
+	deadline := h.forwardDeadline(context.Background())
+	testutil.Assert(t, time.Until(deadline) > 50*time.Second, "expected the forward timeout, got %v", time.Until(deadline))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	expected, _ := ctx.Deadline()
+	testutil.Equals(t, expected, h.forwardDeadline(ctx))
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	deadline = h.forwardDeadline(ctx)
+	testutil.Assert(t, time.Until(deadline) <= time.Minute, "expected the forward timeout, got %v", time.Until(deadline))
 }
 
 func TestReceiveWriteRequestLimits(t *testing.T) {
@@ -835,6 +946,89 @@ func (f *fakeRemoteWriteGRPCServer) RemoteWrite(ctx context.Context, in *storepb
 	return f.h.RemoteWrite(ctx, in)
 }
 
+func (f *fakeRemoteWriteGRPCServer) RemoteWriteStream(ctx context.Context, opts ...grpc.CallOption) (storepb.WriteableStore_RemoteWriteStreamClient, error) {
+	s := &inProcessWriteStream{
+		reqs:  make(chan *storepb.WriteStreamRequest),
+		resps: make(chan *storepb.WriteStreamResponse),
+		done:  make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	go func() {
+		s.err = f.h.RemoteWriteStream(&inProcessWriteStreamServer{s: s})
+		close(s.done)
+	}()
+	return &inProcessWriteStreamClient{s: s}, nil
+}
+
+// inProcessWriteStream connects a RemoteWriteStream client to a server without the network.
+type inProcessWriteStream struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	reqs   chan *storepb.WriteStreamRequest
+	resps  chan *storepb.WriteStreamResponse
+	done   chan struct{}
+	err    error
+}
+
+type inProcessWriteStreamClient struct {
+	grpc.ClientStream
+
+	s *inProcessWriteStream
+}
+
+func (c *inProcessWriteStreamClient) Context() context.Context { return c.s.ctx }
+
+func (c *inProcessWriteStreamClient) Send(r *storepb.WriteStreamRequest) error {
+	select {
+	case <-c.s.ctx.Done():
+		return io.EOF
+	case <-c.s.done:
+		return io.EOF
+	case c.s.reqs <- r:
+		return nil
+	}
+}
+
+func (c *inProcessWriteStreamClient) Recv() (*storepb.WriteStreamResponse, error) {
+	select {
+	case <-c.s.ctx.Done():
+		return nil, c.s.ctx.Err()
+	case r := <-c.s.resps:
+		return r, nil
+	case <-c.s.done:
+		if c.s.err != nil {
+			return nil, c.s.err
+		}
+		return nil, io.EOF
+	}
+}
+
+type inProcessWriteStreamServer struct {
+	grpc.ServerStream
+
+	s *inProcessWriteStream
+}
+
+func (s *inProcessWriteStreamServer) Context() context.Context { return s.s.ctx }
+
+func (s *inProcessWriteStreamServer) Send(r *storepb.WriteStreamResponse) error {
+	select {
+	case <-s.s.ctx.Done():
+		return s.s.ctx.Err()
+	case s.s.resps <- r:
+		return nil
+	}
+}
+
+func (s *inProcessWriteStreamServer) Recv() (*storepb.WriteStreamRequest, error) {
+	select {
+	case <-s.s.ctx.Done():
+		return nil, s.s.ctx.Err()
+	case r := <-s.s.reqs:
+		return r, nil
+	}
+}
+
 func BenchmarkHandlerReceiveHTTP(b *testing.B) {
 	benchmarkHandlerMultiTSDBReceiveRemoteWrite(testutil.NewTB(b))
 }
@@ -1515,8 +1709,17 @@ func TestRelabel(t *testing.T) {
 				RelabelConfigs: tcase.relabel,
 			})
 
-			h.relabel(&tcase.writeRequest)
+			h.relabel("", &tcase.writeRequest)
 			testutil.Equals(t, tcase.expectedWriteRequest, tcase.writeRequest)
 		})
 	}
 }
+
+func TestWriteErrorsTenantLimited(t *testing.T) {
+	errs := &writeErrors{}
+	errs.Add(errors.Wrapf(storage.ErrOutOfOrderSample, "add %d samples", 1))
+	errs.Add(errors.Wrapf(errTenantWALSizeLimit, "add %d samples", 2))
+	// Tenant limits take precedence over conflicts, as the request can be retried once the limit is lifted.
+	testutil.Equals(t, errTenantLimited, errs.Cause())
+	testutil.Assert(t, !isConflict(errTenantHeadSeriesLimit), "tenant limits are not conflicts")
+}
@@ -55,6 +55,8 @@ type Hashring interface {
 	Get(tenant string, timeSeries *prompb.TimeSeries) (string, error)
 	// GetN returns the nth node that should handle the given tenant and time series.
 	GetN(tenant string, timeSeries *prompb.TimeSeries, n uint64) (string, error)
+	// Nodes returns the addresses of all nodes of the hashring.
+	Nodes() []string
 }
 
 // SingleNodeHashring always returns the same node.
@@ -73,6 +75,11 @@ func (s SingleNodeHashring) GetN(_ string, _ *prompb.TimeSeries, n uint64) (stri
 	return string(s), nil
 }
 
+// Nodes implements the Hashring interface.
+func (s SingleNodeHashring) Nodes() []string {
+	return []string{string(s)}
+}
+
 // simpleHashring represents a group of nodes handling write requests by hashmoding individual series.
 type simpleHashring []string
 
@@ -90,7 +97,13 @@ func (s simpleHashring) GetN(tenant string, ts *prompb.TimeSeries, n uint64) (st
 	return s[(labelpb.HashWithPrefix(tenant, ts.Labels)+n)%uint64(len(s))], nil
 }
 
+// Nodes returns the addresses of all nodes of the hashring.
+func (s simpleHashring) Nodes() []string {
+	return s
+}
+
 type section struct {
+	az            string
 	endpointIndex uint64
 	hash          uint64
 	replicas      []uint64
@@ -110,7 +123,7 @@ type ketamaHashring struct {
 	numEndpoints uint64
 }
 
-func newKetamaHashring(endpoints []string, sectionsPerNode int, replicationFactor uint64) (*ketamaHashring, error) {
+func newKetamaHashring(endpoints []Endpoint, sectionsPerNode int, replicationFactor uint64) (*ketamaHashring, error) {
 	numSections := len(endpoints) * sectionsPerNode
 
 	if len(endpoints) < int(replicationFactor) {
@@ -118,12 +131,15 @@ func newKetamaHashring(endpoints []string, sectionsPerNode int, replicationFacto
 
 	}
 
+	availabilityZones := make(map[string]struct{})
 	hash := xxhash.New()
 	ringSections := make(sections, 0, numSections)
 	for endpointIndex, endpoint := range endpoints {
+		availabilityZones[endpoint.AZ] = struct{}{}
 		for i := 1; i <= sectionsPerNode; i++ {
-			_, _ = hash.Write([]byte(endpoint + ":" + strconv.Itoa(i)))
+			_, _ = hash.Write([]byte(endpoint.Address + ":" + strconv.Itoa(i)))
 			n := &section{
+				az:            endpoint.AZ,
 				endpointIndex: uint64(endpointIndex),
 				hash:          hash.Sum64(),
 				replicas:      make([]uint64, 0, replicationFactor),
@@ -134,10 +150,10 @@ func newKetamaHashring(endpoints []string, sectionsPerNode int, replicationFacto
 		}
 	}
 	sort.Sort(ringSections)
-	calculateSectionReplicas(ringSections, replicationFactor)
+	calculateSectionReplicas(ringSections, replicationFactor, len(availabilityZones))
 
 	return &ketamaHashring{
-		endpoints:    endpoints,
+		endpoints:    endpointAddresses(endpoints),
 		sections:     ringSections,
 		numEndpoints: uint64(len(endpoints)),
 	}, nil
@@ -145,9 +161,12 @@ func newKetamaHashring(endpoints []string, sectionsPerNode int, replicationFacto
 
 // calculateSectionReplicas pre-calculates replicas for each section,
 // ensuring that replicas for each ring section are owned by different endpoints.
-func calculateSectionReplicas(ringSections sections, replicationFactor uint64) {
+// Replicas are also placed in different availability zones, until all of the
+// numAZs availability zones hold a replica of the section.
+func calculateSectionReplicas(ringSections sections, replicationFactor uint64, numAZs int) {
 	for i, s := range ringSections {
 		replicas := make(map[uint64]struct{})
+		azs := make(map[string]struct{})
 		j := i - 1
 		for uint64(len(replicas)) < replicationFactor {
 			j = (j + 1) % len(ringSections)
@@ -155,7 +174,11 @@ func calculateSectionReplicas(ringSections sections, replicationFactor uint64) {
 			if _, ok := replicas[rep.endpointIndex]; ok {
 				continue
 			}
+			if _, ok := azs[rep.az]; ok && len(azs) < numAZs {
+				continue
+			}
 			replicas[rep.endpointIndex] = struct{}{}
+			azs[rep.az] = struct{}{}
 			s.replicas = append(s.replicas, rep.endpointIndex)
 		}
 	}
@@ -186,6 +209,11 @@ func (c ketamaHashring) GetN(tenant string, ts *prompb.TimeSeries, n uint64) (st
 	return c.endpoints[endpointIndex], nil
 }
 
+// Nodes returns the addresses of all nodes of the hashring.
+func (c ketamaHashring) Nodes() []string {
+	return c.endpoints
+}
+
 // multiHashring represents a set of hashrings.
 // Which hashring to use for a tenant is determined
 // by the tenants field of the hashring configuration.
@@ -193,6 +221,7 @@ type multiHashring struct {
 	cache      map[string]Hashring
 	hashrings  []Hashring
 	tenantSets []map[string]struct{}
+	nodes      []string
 
 	// We need a mutex to guard concurrent access
 	// to the cache map, as this is both written to
@@ -235,6 +264,11 @@ func (m *multiHashring) GetN(tenant string, ts *prompb.TimeSeries, n uint64) (st
 	return "", errors.New("no matching hashring to handle tenant")
 }
 
+// Nodes returns the addresses of the nodes of all hashrings.
+func (m *multiHashring) Nodes() []string {
+	return m.nodes
+}
+
 // newMultiHashring creates a multi-tenant hashring for a given slice of
 // groups.
 // Which hashring to use for a tenant is determined
@@ -243,6 +277,7 @@ func newMultiHashring(algorithm HashringAlgorithm, replicationFactor uint64, cfg
 	m := &multiHashring{
 		cache: make(map[string]Hashring),
 	}
+	seen := make(map[string]struct{})
 
 	for _, h := range cfg {
 		var hashring Hashring
@@ -256,6 +291,12 @@ func newMultiHashring(algorithm HashringAlgorithm, replicationFactor uint64, cfg
 			return nil, err
 		}
 		m.hashrings = append(m.hashrings, hashring)
+		for _, node := range hashring.Nodes() {
+			if _, ok := seen[node]; !ok {
+				seen[node] = struct{}{}
+				m.nodes = append(m.nodes, node)
+			}
+		}
 		var t map[string]struct{}
 		if len(h.Tenants) != 0 {
 			t = make(map[string]struct{})
@@ -311,10 +352,10 @@ func HashringFromConfig(algorithm HashringAlgorithm, replicationFactor uint64, c
 	return newMultiHashring(algorithm, replicationFactor, config)
 }
 
-func newHashring(algorithm HashringAlgorithm, endpoints []string, replicationFactor uint64, hashring string, tenants []string) (Hashring, error) {
+func newHashring(algorithm HashringAlgorithm, endpoints []Endpoint, replicationFactor uint64, hashring string, tenants []string) (Hashring, error) {
 	switch algorithm {
 	case AlgorithmHashmod:
-		return simpleHashring(endpoints), nil
+		return newSimpleHashring(endpoints)
 	case AlgorithmKetama:
 		return newKetamaHashring(endpoints, SectionsPerNode, replicationFactor)
 	default:
@@ -322,6 +363,23 @@ func newHashring(algorithm HashringAlgorithm, endpoints []string, replicationFac
 		level.Warn(l).Log("msg", "Unrecognizable hashring algorithm. Fall back to hashmod algorithm.",
 			"hashring", hashring,
 			"tenants", tenants)
-		return simpleHashring(endpoints), nil
+		return newSimpleHashring(endpoints)
+	}
+}
+
+func newSimpleHashring(endpoints []Endpoint) (Hashring, error) {
+	for _, endpoint := range endpoints {
+		if endpoint.AZ != "" {
+			return nil, errors.Errorf("hashmod: availability zone of endpoint %s is not supported, use the ketama algorithm for availability zone aware replication", endpoint.Address)
+		}
+	}
+	return simpleHashring(endpointAddresses(endpoints)), nil
+}
+
+func endpointAddresses(endpoints []Endpoint) []string {
+	addresses := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		addresses = append(addresses, endpoint.Address)
 	}
+	return addresses
 }
@@ -44,7 +44,7 @@ func TestHashringGet(t *testing.T) {
 			name: "simple",
 			cfg: []HashringConfig{
 				{
-					Endpoints: []string{"node1"},
+					Endpoints: []Endpoint{{Address: "node1"}},
 				},
 			},
 			nodes: map[string]struct{}{"node1": {}},
@@ -53,11 +53,11 @@ func TestHashringGet(t *testing.T) {
 			name: "specific",
 			cfg: []HashringConfig{
 				{
-					Endpoints: []string{"node2"},
+					Endpoints: []Endpoint{{Address: "node2"}},
 					Tenants:   []string{"tenant2"},
 				},
 				{
-					Endpoints: []string{"node1"},
+					Endpoints: []Endpoint{{Address: "node1"}},
 				},
 			},
 			nodes:  map[string]struct{}{"node2": {}},
@@ -67,15 +67,15 @@ func TestHashringGet(t *testing.T) {
 			name: "many tenants",
 			cfg: []HashringConfig{
 				{
-					Endpoints: []string{"node1"},
+					Endpoints: []Endpoint{{Address: "node1"}},
 					Tenants:   []string{"tenant1"},
 				},
 				{
-					Endpoints: []string{"node2"},
+					Endpoints: []Endpoint{{Address: "node2"}},
 					Tenants:   []string{"tenant2"},
 				},
 				{
-					Endpoints: []string{"node3"},
+					Endpoints: []Endpoint{{Address: "node3"}},
 					Tenants:   []string{"tenant3"},
 				},
 			},
@@ -86,15 +86,15 @@ func TestHashringGet(t *testing.T) {
 			name: "many tenants error",
 			cfg: []HashringConfig{
 				{
-					Endpoints: []string{"node1"},
+					Endpoints: []Endpoint{{Address: "node1"}},
 					Tenants:   []string{"tenant1"},
 				},
 				{
-					Endpoints: []string{"node2"},
+					Endpoints: []Endpoint{{Address: "node2"}},
 					Tenants:   []string{"tenant2"},
 				},
 				{
-					Endpoints: []string{"node3"},
+					Endpoints: []Endpoint{{Address: "node3"}},
 					Tenants:   []string{"tenant3"},
 				},
 			},
@@ -104,11 +104,11 @@ func TestHashringGet(t *testing.T) {
 			name: "many nodes",
 			cfg: []HashringConfig{
 				{
-					Endpoints: []string{"node1", "node2", "node3"},
+					Endpoints: []Endpoint{{Address: "node1"}, {Address: "node2"}, {Address: "node3"}},
 					Tenants:   []string{"tenant1"},
 				},
 				{
-					Endpoints: []string{"node4", "node5", "node6"},
+					Endpoints: []Endpoint{{Address: "node4"}, {Address: "node5"}, {Address: "node6"}},
 				},
 			},
 			nodes: map[string]struct{}{
@@ -122,11 +122,11 @@ func TestHashringGet(t *testing.T) {
 			name: "many nodes default",
 			cfg: []HashringConfig{
 				{
-					Endpoints: []string{"node1", "node2", "node3"},
+					Endpoints: []Endpoint{{Address: "node1"}, {Address: "node2"}, {Address: "node3"}},
 					Tenants:   []string{"tenant1"},
 				},
 				{
-					Endpoints: []string{"node4", "node5", "node6"},
+					Endpoints: []Endpoint{{Address: "node4"}, {Address: "node5"}, {Address: "node6"}},
 				},
 			},
 			nodes: map[string]struct{}{
@@ -167,53 +167,53 @@ func TestKetamaHashringGet(t *testing.T) {
 	}
 	tests := []struct {
 		name         string
-		nodes        []string
+		nodes        []Endpoint
 		expectedNode string
 		ts           *prompb.TimeSeries
 		n            uint64
 	}{
 		{
 			name:         "base case",
-			nodes:        []string{"node-1", "node-2", "node-3"},
+			nodes:        []Endpoint{{Address: "node-1"}, {Address: "node-2"}, {Address: "node-3"}},
 			ts:           baseTS,
 			expectedNode: "node-2",
 		},
 		{
 			name:         "base case with replication",
-			nodes:        []string{"node-1", "node-2", "node-3"},
+			nodes:        []Endpoint{{Address: "node-1"}, {Address: "node-2"}, {Address: "node-3"}},
 			ts:           baseTS,
 			n:            1,
 			expectedNode: "node-1",
 		},
 		{
 			name:         "base case with replication",
-			nodes:        []string{"node-1", "node-2", "node-3"},
+			nodes:        []Endpoint{{Address: "node-1"}, {Address: "node-2"}, {Address: "node-3"}},
 			ts:           baseTS,
 			n:            2,
 			expectedNode: "node-3",
 		},
 		{
 			name:         "base case with replication and reordered nodes",
-			nodes:        []string{"node-1", "node-3", "node-2"},
+			nodes:        []Endpoint{{Address: "node-1"}, {Address: "node-3"}, {Address: "node-2"}},
 			ts:           baseTS,
 			n:            2,
 			expectedNode: "node-3",
 		},
 		{
 			name:         "base case with new node at beginning of ring",
-			nodes:        []string{"node-0", "node-1", "node-2", "node-3"},
+			nodes:        []Endpoint{{Address: "node-0"}, {Address: "node-1"}, {Address: "node-2"}, {Address: "node-3"}},
 			ts:           baseTS,
 			expectedNode: "node-2",
 		},
 		{
 			name:         "base case with new node at end of ring",
-			nodes:        []string{"node-1", "node-2", "node-3", "node-4"},
+			nodes:        []Endpoint{{Address: "node-1"}, {Address: "node-2"}, {Address: "node-3"}, {Address: "node-4"}},
 			ts:           baseTS,
 			expectedNode: "node-2",
 		},
 		{
 			name:  "base case with different timeseries",
-			nodes: []string{"node-1", "node-2", "node-3"},
+			nodes: []Endpoint{{Address: "node-1"}, {Address: "node-2"}, {Address: "node-3"}},
 			ts: &prompb.TimeSeries{
 				Labels: []labelpb.ZLabel{
 					{
@@ -239,18 +239,18 @@ func TestKetamaHashringGet(t *testing.T) {
 }
 
 func TestKetamaHashringBadConfigIsRejected(t *testing.T) {
-	_, err := newKetamaHashring([]string{"node-1"}, 1, 2)
+	_, err := newKetamaHashring([]Endpoint{{Address: "node-1"}}, 1, 2)
 	require.Error(t, err)
 }
 
 func TestKetamaHashringConsistency(t *testing.T) {
 	series := makeSeries()
 
-	ringA := []string{"node-1", "node-2", "node-3"}
+	ringA := []Endpoint{{Address: "node-1"}, {Address: "node-2"}, {Address: "node-3"}}
 	a1, err := assignSeries(series, ringA)
 	require.NoError(t, err)
 
-	ringB := []string{"node-1", "node-2", "node-3"}
+	ringB := []Endpoint{{Address: "node-1"}, {Address: "node-2"}, {Address: "node-3"}}
 	a2, err := assignSeries(series, ringB)
 	require.NoError(t, err)
 
@@ -266,19 +266,19 @@ func TestKetamaHashringConsistency(t *testing.T) {
 func TestKetamaHashringIncreaseAtEnd(t *testing.T) {
 	series := makeSeries()
 
-	initialRing := []string{"node-1", "node-2", "node-3"}
+	initialRing := []Endpoint{{Address: "node-1"}, {Address: "node-2"}, {Address: "node-3"}}
 	initialAssignments, err := assignSeries(series, initialRing)
 	require.NoError(t, err)
 
-	resizedRing := []string{"node-1", "node-2", "node-3", "node-4", "node-5"}
+	resizedRing := []Endpoint{{Address: "node-1"}, {Address: "node-2"}, {Address: "node-3"}, {Address: "node-4"}, {Address: "node-5"}}
 	reassignments, err := assignSeries(series, resizedRing)
 	require.NoError(t, err)
 
 	// Assert that the initial nodes have no new keys after increasing the ring size
 	for _, node := range initialRing {
-		for _, ts := range reassignments[node] {
-			foundInInitialAssignment := findSeries(initialAssignments, node, ts)
-			require.True(t, foundInInitialAssignment, "node %s contains new series after resizing", node)
+		for _, ts := range reassignments[node.Address] {
+			foundInInitialAssignment := findSeries(initialAssignments, node.Address, ts)
+			require.True(t, foundInInitialAssignment, "node %s contains new series after resizing", node.Address)
 		}
 	}
 }
@@ -286,19 +286,19 @@ func TestKetamaHashringIncreaseAtEnd(t *testing.T) {
 func TestKetamaHashringIncreaseInMiddle(t *testing.T) {
 	series := makeSeries()
 
-	initialRing := []string{"node-1", "node-3"}
+	initialRing := []Endpoint{{Address: "node-1"}, {Address: "node-3"}}
 	initialAssignments, err := assignSeries(series, initialRing)
 	require.NoError(t, err)
 
-	resizedRing := []string{"node-1", "node-2", "node-3"}
+	resizedRing := []Endpoint{{Address: "node-1"}, {Address: "node-2"}, {Address: "node-3"}}
 	reassignments, err := assignSeries(series, resizedRing)
 	require.NoError(t, err)
 
 	// Assert that the initial nodes have no new keys after increasing the ring size
 	for _, node := range initialRing {
-		for _, ts := range reassignments[node] {
-			foundInInitialAssignment := findSeries(initialAssignments, node, ts)
-			require.True(t, foundInInitialAssignment, "node %s contains new series after resizing", node)
+		for _, ts := range reassignments[node.Address] {
+			foundInInitialAssignment := findSeries(initialAssignments, node.Address, ts)
+			require.True(t, foundInInitialAssignment, "node %s contains new series after resizing", node.Address)
 		}
 	}
 }
@@ -306,23 +306,81 @@ func TestKetamaHashringIncreaseInMiddle(t *testing.T) {
 func TestKetamaHashringReplicationConsistency(t *testing.T) {
 	series := makeSeries()
 
-	initialRing := []string{"node-1", "node-4", "node-5"}
+	initialRing := []Endpoint{{Address: "node-1"}, {Address: "node-4"}, {Address: "node-5"}}
 	initialAssignments, err := assignReplicatedSeries(series, initialRing, 2)
 	require.NoError(t, err)
 
-	resizedRing := []string{"node-4", "node-3", "node-1", "node-2", "node-5"}
+	resizedRing := []Endpoint{{Address: "node-4"}, {Address: "node-3"}, {Address: "node-1"}, {Address: "node-2"}, {Address: "node-5"}}
 	reassignments, err := assignReplicatedSeries(series, resizedRing, 2)
 	require.NoError(t, err)
 
 	// Assert that the initial nodes have no new keys after increasing the ring size
 	for _, node := range initialRing {
-		for _, ts := range reassignments[node] {
-			foundInInitialAssignment := findSeries(initialAssignments, node, ts)
-			require.True(t, foundInInitialAssignment, "node %s contains new series after resizing", node)
+		for _, ts := range reassignments[node.Address] {
+			foundInInitialAssignment := findSeries(initialAssignments, node.Address, ts)
+			require.True(t, foundInInitialAssignment, "node %s contains new series after resizing", node.Address)
 		}
 	}
 }
 
+func TestKetamaHashringAvailabilityZones(t *testing.T) {
+	series := makeSeries()
+
+	for _, tc := range []struct {
+		name              string
+		endpoints         []Endpoint
+		replicationFactor uint64
+		expectedAZs       int
+	}{
+		{
+			name: "one replica per availability zone",
+			endpoints: []Endpoint{
+				{Address: "node-1", AZ: "zone-a"}, {Address: "node-2", AZ: "zone-a"},
+				{Address: "node-3", AZ: "zone-b"}, {Address: "node-4", AZ: "zone-b"},
+				{Address: "node-5", AZ: "zone-c"}, {Address: "node-6", AZ: "zone-c"},
+			},
+			replicationFactor: 3,
+			expectedAZs:       3,
+		},
+		{
+			name: "more replicas than availability zones",
+			endpoints: []Endpoint{
+				{Address: "node-1", AZ: "zone-a"}, {Address: "node-2", AZ: "zone-a"},
+				{Address: "node-3", AZ: "zone-b"}, {Address: "node-4", AZ: "zone-b"},
+			},
+			replicationFactor: 3,
+			expectedAZs:       2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hashRing, err := newKetamaHashring(tc.endpoints, SectionsPerNode, tc.replicationFactor)
+			require.NoError(t, err)
+
+			azs := make(map[string]string, len(tc.endpoints))
+			for _, endpoint := range tc.endpoints {
+				azs[endpoint.Address] = endpoint.AZ
+			}
+			for _, ts := range series {
+				nodes := make(map[string]struct{})
+				seriesAZs := make(map[string]struct{})
+				for n := uint64(0); n < tc.replicationFactor; n++ {
+					node, err := hashRing.GetN("tenant", &ts, n)
+					require.NoError(t, err)
+					nodes[node] = struct{}{}
+					seriesAZs[azs[node]] = struct{}{}
+				}
+				require.Len(t, nodes, int(tc.replicationFactor))
+				require.Len(t, seriesAZs, tc.expectedAZs)
+			}
+		})
+	}
+}
+
+func TestHashmodHashringRejectsAvailabilityZones(t *testing.T) {
+	_, err := newMultiHashring(AlgorithmHashmod, 1, []HashringConfig{{Endpoints: []Endpoint{{Address: "node-1", AZ: "zone-a"}}}})
+	require.Error(t, err)
+}
+
 func makeSeries() []prompb.TimeSeries {
 	numSeries := 10000
 	series := make([]prompb.TimeSeries, numSeries)
@@ -351,11 +409,11 @@ func findSeries(initialAssignments map[string][]prompb.TimeSeries, node string,
 	return false
 }
 
-func assignSeries(series []prompb.TimeSeries, nodes []string) (map[string][]prompb.TimeSeries, error) {
+func assignSeries(series []prompb.TimeSeries, nodes []Endpoint) (map[string][]prompb.TimeSeries, error) {
 	return assignReplicatedSeries(series, nodes, 0)
 }
 
-func assignReplicatedSeries(series []prompb.TimeSeries, nodes []string, replicas uint64) (map[string][]prompb.TimeSeries, error) {
+func assignReplicatedSeries(series []prompb.TimeSeries, nodes []Endpoint, replicas uint64) (map[string][]prompb.TimeSeries, error) {
 	hashRing, err := newKetamaHashring(nodes, SectionsPerNode, replicas)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,91 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/jpillora/backoff"
+	"github.com/pkg/errors"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+// Results of write requests buffered during a hashring transition.
+const (
+	transitionResultReplayed   = "replayed"
+	transitionResultFailed     = "failed"
+	transitionResultExpired    = "expired"
+	transitionResultBufferFull = "buffer_full"
+)
+
+// inHashringTransition returns whether the hashring has changed less than Options.HashringTransitionWindow ago.
+func (h *Handler) inHashringTransition() bool {
+	if h.transitionSlots == nil {
+		return false
+	}
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+	return time.Since(h.hashringChangedAt) < h.options.HashringTransitionWindow
+}
+
+// isTransitionErr returns whether the error is caused by receivers not being ready,
+// as it happens while they flush their storage after a hashring change.
+func isTransitionErr(err error) bool {
+	cause := errors.Cause(err)
+	return cause == errNotReady || cause == errUnavailable
+}
+
+// replayDuringHashringTransition buffers a write request that failed with err because receivers were not ready
+// during a hashring transition and retries it with backoff, until it succeeds, fails with another error, or the
+// transition window ends. The last error is returned. Errors outside of a transition are returned as they are.
+func (h *Handler) replayDuringHashringTransition(ctx context.Context, tLogger log.Logger, rep uint64, tenant string, wreq *prompb.WriteRequest, err error) error {
+	if !isTransitionErr(err) || !h.inHashringTransition() {
+		return err
+	}
+
+	select {
+	case h.transitionSlots <- struct{}{}:
+	default:
+		h.transitionRequests.WithLabelValues(transitionResultBufferFull).Inc()
+		return err
+	}
+	h.transitionBuffered.Inc()
+	defer func() {
+		h.transitionBuffered.Dec()
+		<-h.transitionSlots
+	}()
+
+	level.Debug(tLogger).Log("msg", "buffering write request during hashring transition", "err", err)
+	b := backoff.Backoff{
+		Factor: 2,
+		Min:    100 * time.Millisecond,
+		Max:    time.Second,
+		Jitter: true,
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			h.transitionRequests.WithLabelValues(transitionResultExpired).Inc()
+			return err
+		case <-time.After(b.Duration()):
+		}
+
+		err = h.handleRequest(ctx, rep, tenant, wreq)
+		switch {
+		case err == nil:
+			h.transitionRequests.WithLabelValues(transitionResultReplayed).Inc()
+			return nil
+		case !isTransitionErr(err):
+			h.transitionRequests.WithLabelValues(transitionResultFailed).Inc()
+			return err
+		case !h.inHashringTransition():
+			h.transitionRequests.WithLabelValues(transitionResultExpired).Inc()
+			return err
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/tsdb"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+func TestReceiveDuringHashringTransition(t *testing.T) {
+	wreq := &prompb.WriteRequest{
+		Timeseries: makeSeriesWithValues(5),
+	}
+
+	for _, tc := range []struct {
+		name      string
+		window    time.Duration
+		changedAt time.Duration
+		status    int
+		result    string
+	}{
+		{name: "buffering disabled", status: http.StatusServiceUnavailable},
+		{name: "within transition window", window: time.Minute, status: http.StatusOK, result: transitionResultReplayed},
+		{name: "after transition window", window: time.Minute, changedAt: -2 * time.Minute, status: http.StatusServiceUnavailable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// The storage is not ready for the first three appends, like while it is flushed after a hashring change.
+			var attempts int32
+			appendable := &fakeAppendable{
+				appender: newFakeAppender(nil, nil, nil),
+				appenderErr: func() error {
+					if atomic.AddInt32(&attempts, 1) <= 3 {
+						return tsdb.ErrNotReady
+					}
+					return nil
+				},
+			}
+			handlers, _, err := newTestHandlerHashring([]*fakeAppendable{appendable}, 1, AlgorithmHashmod)
+			testutil.Ok(t, err)
+			h := handlers[0]
+			if tc.window > 0 {
+				h.options.HashringTransitionWindow = tc.window
+				h.transitionSlots = make(chan struct{}, 1)
+			}
+			h.hashringChangedAt = h.hashringChangedAt.Add(tc.changedAt)
+
+			rec, err := makeRequest(h, "test", wreq)
+			testutil.Ok(t, err)
+			testutil.Equals(t, tc.status, rec.Code, "unexpected status, body: %s", rec.Body.String())
+			if tc.result != "" {
+				testutil.Equals(t, 1.0, promtestutil.ToFloat64(h.transitionRequests.WithLabelValues(tc.result)))
+			}
+			testutil.Equals(t, 0.0, promtestutil.ToFloat64(h.transitionBuffered))
+		})
+	}
+}
@@ -5,6 +5,7 @@ package receive
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"net/url"
 	"sync"
@@ -19,6 +20,9 @@ import (
 	"github.com/thanos-io/thanos/pkg/promclient"
 )
 
+// MetaMonitoringQueryInterval is the interval at which the active series of tenants are queried from meta-monitoring.
+const MetaMonitoringQueryInterval = 15 * time.Second
+
 // headSeriesLimit implements headSeriesLimiter interface.
 type headSeriesLimit struct {
 	mtx                    sync.RWMutex
@@ -129,8 +133,22 @@ func (h *headSeriesLimit) QueryMetaMonitoring(ctx context.Context) error {
 	return nil
 }
 
+// headSeriesLimitExceededError is returned by isUnderLimit when a tenant has reached its active series limit.
+type headSeriesLimitExceededError struct {
+	tenant        string
+	currentSeries uint64
+	limit         uint64
+}
+
+func (e *headSeriesLimitExceededError) Error() string {
+	return fmt.Sprintf("tenant %s is above active series limit across all receivers: current series %d, limit %d", e.tenant, e.currentSeries, e.limit)
+}
+
 // isUnderLimit ensures that the current number of active series for a tenant does not exceed given limit.
+// The limit is global: the active series of the tenant are those of all receivers, including replicated ones,
+// as returned by the meta-monitoring query, and not only those of this receiver.
 // It does so in a best-effort way, i.e, in case meta-monitoring is unreachable, it does not impose limits.
+// If the tenant has reached its limit, a *headSeriesLimitExceededError describing the reason is returned.
 func (h *headSeriesLimit) isUnderLimit(tenant string) (bool, error) {
 	h.mtx.RLock()
 	defer h.mtx.RUnlock()
@@ -155,10 +173,15 @@ func (h *headSeriesLimit) isUnderLimit(tenant string) (bool, error) {
 		limit = h.defaultLimit
 	}
 
+	// Limit of 0 means the tenant is unlimited.
+	if limit == 0 {
+		return true, nil
+	}
+
 	if v >= float64(limit) {
 		level.Error(h.logger).Log("msg", "tenant above limit", "tenant", tenant, "currentSeries", v, "limit", limit)
 		h.limitedRequests.WithLabelValues(tenant).Inc()
-		return false, nil
+		return false, &headSeriesLimitExceededError{tenant: tenant, currentSeries: uint64(v), limit: limit}
 	}
 
 	return true, nil
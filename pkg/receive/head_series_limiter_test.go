@@ -0,0 +1,49 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+)
+
+func TestHeadSeriesLimit_isUnderLimit(t *testing.T) {
+	limits := WriteLimitsConfig{
+		DefaultLimits: DefaultLimitsConfig{HeadSeriesLimit: 100},
+		TenantsLimits: TenantsWriteLimitsConfig{
+			"limited":   NewEmptyWriteLimitConfig().SetHeadSeriesLimit(10),
+			"unlimited": NewEmptyWriteLimitConfig().SetHeadSeriesLimit(0),
+		},
+	}
+	limiter := NewHeadSeriesLimit(limits, nil, log.NewNopLogger())
+	limiter.tenantCurrentSeriesMap = map[string]float64{
+		"limited":   20,
+		"unlimited": 1000,
+		"default":   50,
+	}
+
+	for _, tcase := range []struct {
+		tenant    string
+		wantUnder bool
+		wantErr   string
+	}{
+		{tenant: "limited", wantUnder: false, wantErr: "tenant limited is above active series limit across all receivers: current series 20, limit 10"},
+		{tenant: "unlimited", wantUnder: true},
+		{tenant: "default", wantUnder: true},
+		{tenant: "unknown", wantUnder: true, wantErr: "tenant not in current series map"},
+	} {
+		t.Run(tcase.tenant, func(t *testing.T) {
+			under, err := limiter.isUnderLimit(tcase.tenant)
+			testutil.Equals(t, tcase.wantUnder, under)
+			if tcase.wantErr == "" {
+				testutil.Ok(t, err)
+				return
+			}
+			testutil.NotOk(t, err)
+			testutil.Equals(t, tcase.wantErr, err.Error())
+		})
+	}
+}
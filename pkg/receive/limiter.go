@@ -4,20 +4,24 @@
 package receive
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
 	"github.com/thanos-io/thanos/pkg/extkingpin"
 
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	"github.com/thanos-io/thanos/pkg/gate"
+	"github.com/thanos-io/thanos/pkg/runutil"
 )
 
 // Limiter is responsible for managing the configuration and initialization of
@@ -42,9 +46,13 @@ type headSeriesLimiter interface {
 }
 
 type requestLimiter interface {
+	SizeBytesLimit(tenant string) int64
 	AllowSizeBytes(tenant string, contentLengthBytes int64) bool
 	AllowSeries(tenant string, amount int64) bool
 	AllowSamples(tenant string, amount int64) bool
+	AllowSamplesPerSecond(tenant string, amount int64) bool
+	SamplesPerSecondRetryAfter(tenant string, amount int64) time.Duration
+	AllowConcurrency(tenant string) (bool, func())
 }
 
 // fileContent is an interface to avoid a direct dependency on kingpin or extkingpin.
@@ -98,25 +106,50 @@ func NewLimiter(configFile fileContent, reg prometheus.Registerer, r ReceiverMod
 
 // StartConfigReloader starts the automatic configuration reloader based off of
 // the file indicated by pathOrContent. It starts a Go routine in the given
-// *run.Group.
+// *run.Group. Configurations loaded from a bucket are polled for changes instead.
 func (l *Limiter) StartConfigReloader(ctx context.Context) error {
 	if !l.CanReload() {
 		return nil
 	}
 
-	return extkingpin.PathContentReloader(ctx, l.configPathOrContent, l.logger, func() {
-		level.Info(l.logger).Log("msg", "reloading limit config")
-		if err := l.loadConfig(); err != nil {
-			if failedReload := l.configReloadCounter; failedReload != nil {
+	if c, ok := l.configPathOrContent.(*BucketConfigContent); ok {
+		go l.pollBucketConfig(ctx, c)
+		return nil
+	}
+	return extkingpin.PathContentReloader(ctx, l.configPathOrContent, l.logger, l.reloadConfig, 1*time.Second)
+}
+
+// pollBucketConfig reloads the configuration every interval of the given bucket object, if it changed.
+func (l *Limiter) pollBucketConfig(ctx context.Context, c *BucketConfigContent) {
+	_ = runutil.Repeat(c.interval, ctx.Done(), func() error {
+		changed, err := c.changed(ctx)
+		if err != nil {
+			if failedReload := l.configReloadFailedCounter; failedReload != nil {
 				failedReload.Inc()
 			}
-			errMsg := fmt.Sprintf("error reloading tenant limits config from %s", l.configPathOrContent.Path())
-			level.Error(l.logger).Log("msg", errMsg, "err", err)
+			level.Error(l.logger).Log("msg", fmt.Sprintf("error reading tenant limits config from %s", c.Path()), "err", err)
+			return nil
 		}
-		if reloadCounter := l.configReloadCounter; reloadCounter != nil {
-			reloadCounter.Inc()
+		if changed {
+			l.reloadConfig()
+		}
+		return nil
+	})
+}
+
+func (l *Limiter) reloadConfig() {
+	level.Info(l.logger).Log("msg", "reloading limit config")
+	if err := l.loadConfig(); err != nil {
+		if failedReload := l.configReloadFailedCounter; failedReload != nil {
+			failedReload.Inc()
 		}
-	}, 1*time.Second)
+		errMsg := fmt.Sprintf("error reloading tenant limits config from %s", l.configPathOrContent.Path())
+		level.Error(l.logger).Log("msg", errMsg, "err", err)
+		return
+	}
+	if reloadCounter := l.configReloadCounter; reloadCounter != nil {
+		reloadCounter.Inc()
+	}
 }
 
 func (l *Limiter) CanReload() bool {
@@ -146,12 +179,15 @@ func (l *Limiter) loadConfig() error {
 			int(maxWriteConcurrency),
 			gate.WriteRequests,
 		)
+		if maxQueued := config.WriteLimits.GlobalLimits.MaxQueuedRequests; maxQueued > 0 {
+			l.writeGate = newWriteQueue(l.registerer, l.writeGate, maxQueued)
+		}
 	}
 	l.requestLimiter = newConfigRequestLimiter(
 		l.registerer,
 		&config.WriteLimits,
 	)
-	seriesLimitSupported := (l.receiverMode == RouterOnly || l.receiverMode == RouterIngestor) && (len(config.WriteLimits.TenantsLimits) != 0 || config.WriteLimits.DefaultLimits.HeadSeriesLimit != 0)
+	seriesLimitSupported := (l.receiverMode == RouterOnly || l.receiverMode == RouterIngestor) && config.AreHeadSeriesLimitsConfigured()
 	if seriesLimitSupported {
 		l.HeadSeriesLimiter = NewHeadSeriesLimit(config.WriteLimits, l.registerer, l.logger)
 	}
@@ -189,6 +225,62 @@ func ParseLimitConfigContent(limitsConfig fileContent) (*RootLimitsConfig, error
 	return parsedConfig, nil
 }
 
+// BucketConfigContent is a limit configuration stored in an object of a bucket. The object is polled
+// for changes every interval by Limiter.StartConfigReloader.
+type BucketConfigContent struct {
+	bkt      objstore.Bucket
+	object   string
+	interval time.Duration
+
+	mtx sync.Mutex
+	// last is the content last returned by Content.
+	last []byte
+}
+
+var _ fileContent = (*BucketConfigContent)(nil)
+
+// NewBucketConfigContent returns the limit configuration stored in the given object of the bucket.
+func NewBucketConfigContent(bkt objstore.Bucket, object string, interval time.Duration) *BucketConfigContent {
+	return &BucketConfigContent{bkt: bkt, object: object, interval: interval}
+}
+
+// Content returns the content of the object.
+func (c *BucketConfigContent) Content() ([]byte, error) {
+	content, err := c.read(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	c.mtx.Lock()
+	c.last = content
+	c.mtx.Unlock()
+	return content, nil
+}
+
+// changed returns true if the content of the object differs from the one last returned by Content.
+func (c *BucketConfigContent) changed(ctx context.Context) (bool, error) {
+	content, err := c.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return !bytes.Equal(content, c.last), nil
+}
+
+func (c *BucketConfigContent) read(ctx context.Context) (_ []byte, err error) {
+	r, err := c.bkt.Get(ctx, c.object)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get %s", c.object)
+	}
+	defer runutil.CloseWithErrCapture(&err, r, "limit configuration object reader")
+	return io.ReadAll(r)
+}
+
+// Path returns the name of the bucket and the object.
+func (c *BucketConfigContent) Path() string {
+	return c.bkt.Name() + "/" + c.object
+}
+
 type nopConfigContent struct{}
 
 var _ fileContent = (*nopConfigContent)(nil)
@@ -39,6 +39,16 @@ func ParseRootLimitConfig(content []byte) (*RootLimitsConfig, error) {
 		root.WriteLimits.GlobalLimits.metaMonitoringURL = u
 	}
 
+	// Active series are only known across all receivers through meta-monitoring, so that head series limits
+	// cannot be enforced without it.
+	if root.WriteLimits.headSeriesLimitsSet() && root.WriteLimits.GlobalLimits.MetaMonitoringURL == "" {
+		return nil, errors.Newf("head_series_limit requires meta_monitoring_url to be set")
+	}
+
+	if root.WriteLimits.GlobalLimits.MaxQueuedRequests > 0 && root.WriteLimits.GlobalLimits.MaxConcurrency <= 0 {
+		return nil, errors.Newf("max_queued_requests requires max_concurrency to be set")
+	}
+
 	// Set default query if none specified.
 	if root.WriteLimits.GlobalLimits.MetaMonitoringLimitQuery == "" {
 		root.WriteLimits.GlobalLimits.MetaMonitoringLimitQuery = "sum(prometheus_tsdb_head_series) by (tenant)"
@@ -48,7 +58,20 @@ func ParseRootLimitConfig(content []byte) (*RootLimitsConfig, error) {
 }
 
 func (r RootLimitsConfig) AreHeadSeriesLimitsConfigured() bool {
-	return r.WriteLimits.GlobalLimits.MetaMonitoringURL != "" && (len(r.WriteLimits.TenantsLimits) != 0 || r.WriteLimits.DefaultLimits.HeadSeriesLimit != 0)
+	return r.WriteLimits.GlobalLimits.MetaMonitoringURL != "" && r.WriteLimits.headSeriesLimitsSet()
+}
+
+// headSeriesLimitsSet returns true if the default or any tenant has a head series limit.
+func (w WriteLimitsConfig) headSeriesLimitsSet() bool {
+	if w.DefaultLimits.HeadSeriesLimit != 0 {
+		return true
+	}
+	for _, l := range w.TenantsLimits {
+		if l != nil && l.HeadSeriesLimit != nil && *l.HeadSeriesLimit != 0 {
+			return true
+		}
+	}
+	return false
 }
 
 type WriteLimitsConfig struct {
@@ -63,6 +86,8 @@ type WriteLimitsConfig struct {
 type GlobalLimitsConfig struct {
 	// MaxConcurrency represents the maximum concurrency during write operations.
 	MaxConcurrency int64 `yaml:"max_concurrency"`
+	// MaxQueuedRequests represents the maximum number of write requests waiting for the write gate. Requests beyond it are rejected.
+	MaxQueuedRequests int64 `yaml:"max_queued_requests"`
 	// MetaMonitoring options specify the query, url and client for Query API address used in head series limiting.
 	MetaMonitoringURL        string                   `yaml:"meta_monitoring_url"`
 	MetaMonitoringHTTPClient *httpconfig.ClientConfig `yaml:"meta_monitoring_http_client"`
@@ -76,6 +101,13 @@ type DefaultLimitsConfig struct {
 	RequestLimits requestLimitsConfig `yaml:"request"`
 	// HeadSeriesLimit specifies the maximum number of head series allowed for any tenant.
 	HeadSeriesLimit uint64 `yaml:"head_series_limit"`
+	// SamplesPerSecondLimit specifies the maximum rate of samples per second accepted for any tenant.
+	SamplesPerSecondLimit uint64 `yaml:"samples_per_second_limit"`
+	// SamplesPerSecondBurst specifies the maximum number of samples accepted at once for any tenant, on top of the
+	// rate. 0 means one second worth of samples, i.e. the samples per second limit.
+	SamplesPerSecondBurst uint64 `yaml:"samples_per_second_burst"`
+	// MaxConcurrency specifies the maximum number of write requests of any tenant processed concurrently.
+	MaxConcurrency uint64 `yaml:"max_concurrency"`
 }
 
 // TenantsWriteLimitsConfig is a map of tenant IDs to their *WriteLimitConfig.
@@ -88,6 +120,12 @@ type WriteLimitConfig struct {
 	RequestLimits *requestLimitsConfig `yaml:"request"`
 	// HeadSeriesLimit specifies the maximum number of head series allowed for a tenant.
 	HeadSeriesLimit *uint64 `yaml:"head_series_limit"`
+	// SamplesPerSecondLimit specifies the maximum rate of samples per second accepted for a tenant.
+	SamplesPerSecondLimit *uint64 `yaml:"samples_per_second_limit"`
+	// SamplesPerSecondBurst specifies the maximum number of samples accepted at once for a tenant, on top of the rate.
+	SamplesPerSecondBurst *uint64 `yaml:"samples_per_second_burst"`
+	// MaxConcurrency specifies the maximum number of write requests of a tenant processed concurrently.
+	MaxConcurrency *uint64 `yaml:"max_concurrency"`
 }
 
 // Utils for initializing.
@@ -105,6 +143,21 @@ func (w *WriteLimitConfig) SetHeadSeriesLimit(val uint64) *WriteLimitConfig {
 	return w
 }
 
+func (w *WriteLimitConfig) SetSamplesPerSecondLimit(val uint64) *WriteLimitConfig {
+	w.SamplesPerSecondLimit = &val
+	return w
+}
+
+func (w *WriteLimitConfig) SetSamplesPerSecondBurst(val uint64) *WriteLimitConfig {
+	w.SamplesPerSecondBurst = &val
+	return w
+}
+
+func (w *WriteLimitConfig) SetMaxConcurrency(val uint64) *WriteLimitConfig {
+	w.MaxConcurrency = &val
+	return w
+}
+
 type requestLimitsConfig struct {
 	SizeBytesLimit *int64 `yaml:"size_bytes_limit"`
 	SeriesLimit    *int64 `yaml:"series_limit"`
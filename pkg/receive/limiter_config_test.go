@@ -27,6 +27,7 @@ func TestParseLimiterConfig(t *testing.T) {
 				WriteLimits: WriteLimitsConfig{
 					GlobalLimits: GlobalLimitsConfig{
 						MaxConcurrency:           30,
+						MaxQueuedRequests:        100,
 						MetaMonitoringURL:        "http://localhost:9090",
 						MetaMonitoringLimitQuery: "sum(prometheus_tsdb_head_series) by (tenant)",
 						metaMonitoringURL: &url.URL{
@@ -39,7 +40,10 @@ func TestParseLimiterConfig(t *testing.T) {
 							SetSizeBytesLimit(1024).
 							SetSeriesLimit(1000).
 							SetSamplesLimit(10),
-						HeadSeriesLimit: 1000,
+						HeadSeriesLimit:       1000,
+						SamplesPerSecondLimit: 100000,
+						SamplesPerSecondBurst: 50000,
+						MaxConcurrency:        10,
 					},
 					TenantsLimits: TenantsWriteLimitsConfig{
 						"acme": NewEmptyWriteLimitConfig().
@@ -49,17 +53,24 @@ func TestParseLimiterConfig(t *testing.T) {
 									SetSeriesLimit(0).
 									SetSamplesLimit(0),
 							).
-							SetHeadSeriesLimit(2000),
+							SetHeadSeriesLimit(2000).
+							SetMaxConcurrency(20),
 						"ajax": NewEmptyWriteLimitConfig().
 							SetRequestLimits(
 								NewEmptyRequestLimitsConfig().
 									SetSeriesLimit(50000).
 									SetSamplesLimit(500),
-							),
+							).
+							SetSamplesPerSecondLimit(200000),
 					},
 				},
 			},
 		},
+		{
+			name:           "Fails on head series limits without meta-monitoring",
+			configFileName: "head_series_limit_without_meta_monitoring.yaml",
+			wantErr:        true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -70,6 +81,10 @@ func TestParseLimiterConfig(t *testing.T) {
 			}
 
 			got, err := ParseRootLimitConfig(fileContent)
+			if tt.wantErr {
+				testutil.NotOk(t, err)
+				return
+			}
 			testutil.Ok(t, err)
 			testutil.Equals(t, tt.want, got)
 		})
@@ -7,10 +7,14 @@ import (
 	"context"
 	"os"
 	"path"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
+	"github.com/thanos-io/objstore"
 	"github.com/thanos-io/thanos/pkg/extkingpin"
+	"github.com/thanos-io/thanos/pkg/runutil"
 
 	"github.com/efficientgo/core/testutil"
 	"github.com/go-kit/log"
@@ -44,6 +48,40 @@ func TestLimiter_StartConfigReloader(t *testing.T) {
 	testutil.Ok(t, goodLimits.Rewrite(invalidLimits))
 }
 
+func TestLimiter_StartConfigReloader_Bucket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bkt := objstore.NewInMemBucket()
+	testutil.Ok(t, bkt.Upload(ctx, "limits.yaml", strings.NewReader(`
+write:
+  default:
+    request:
+      series_limit: 10
+`)))
+
+	limiter, err := NewLimiter(NewBucketConfigContent(bkt, "limits.yaml", 10*time.Millisecond), nil, RouterIngestor, log.NewNopLogger())
+	testutil.Ok(t, err)
+	testutil.Assert(t, limiter.CanReload())
+	testutil.Equals(t, false, limiter.RequestLimiter().AllowSeries("tenant", 11))
+
+	testutil.Ok(t, limiter.StartConfigReloader(ctx))
+	testutil.Ok(t, bkt.Upload(ctx, "limits.yaml", strings.NewReader(`
+write:
+  default:
+    request:
+      series_limit: 100
+`)))
+	retryCtx, retryCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer retryCancel()
+	testutil.Ok(t, runutil.Retry(10*time.Millisecond, retryCtx.Done(), func() error {
+		if !limiter.RequestLimiter().AllowSeries("tenant", 11) {
+			return errors.New("limit configuration not reloaded yet")
+		}
+		return nil
+	}))
+}
+
 type emptyPathFile struct{}
 
 func (e emptyPathFile) Content() ([]byte, error) {
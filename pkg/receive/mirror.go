@@ -0,0 +1,305 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/jpillora/backoff"
+	"github.com/klauspost/compress/s2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/relabel"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/httpconfig"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+// MirrorConfig configures the mirroring of the series accepted by the receiver to a remote write endpoint.
+type MirrorConfig struct {
+	// URL is the remote write endpoint the series are mirrored to.
+	URL string `yaml:"url"`
+	// Tenants are the tenants whose series are mirrored. The series of all tenants are mirrored if empty.
+	Tenants []string `yaml:"tenants"`
+	// TenantHeader is the HTTP header the tenant of the series is sent in, if set.
+	TenantHeader string `yaml:"tenant_header"`
+	// RelabelConfigs are applied to the series before they are mirrored.
+	RelabelConfigs []*relabel.Config `yaml:"relabel_configs"`
+	// QueueSize is the maximum number of write requests waiting to be mirrored. Once it is reached, new write requests are dropped.
+	QueueSize int `yaml:"queue_size"`
+	// Timeout is the timeout of a single remote write request.
+	Timeout model.Duration `yaml:"timeout"`
+	// MaxRetries is the maximum number of retries of a write request failing with a recoverable error.
+	MaxRetries int `yaml:"max_retries"`
+	// HTTPClientConfig configures the HTTP client used to send write requests.
+	HTTPClientConfig httpconfig.ClientConfig `yaml:"http_client_config"`
+}
+
+// DefaultMirrorConfig returns the mirror configuration with the default values set.
+func DefaultMirrorConfig() MirrorConfig {
+	return MirrorConfig{
+		QueueSize:        1000,
+		Timeout:          model.Duration(30 * time.Second),
+		MaxRetries:       3,
+		HTTPClientConfig: httpconfig.NewDefaultClientConfig(),
+	}
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *MirrorConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultMirrorConfig()
+	type plain MirrorConfig
+	return unmarshal((*plain)(c))
+}
+
+// ParseMirrorConfigs parses a YAML list of mirror configurations.
+func ParseMirrorConfigs(content []byte) ([]MirrorConfig, error) {
+	var confs []MirrorConfig
+	if err := yaml.UnmarshalStrict(content, &confs); err != nil {
+		return nil, errors.Wrap(err, "parsing mirror config YAML")
+	}
+	for _, conf := range confs {
+		if u, err := url.Parse(conf.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, errors.Errorf("invalid mirror URL %q", conf.URL)
+		}
+		if conf.QueueSize <= 0 {
+			return nil, errors.Errorf("queue size of mirror %s must be positive", conf.URL)
+		}
+	}
+	return confs, nil
+}
+
+// Mirror mirrors the series accepted by the receiver to remote write endpoints. Write requests are queued
+// and sent in order by one goroutine per endpoint, so that mirroring never slows down the ingestion.
+type Mirror struct {
+	endpoints []*mirrorEndpoint
+}
+
+// NewMirror creates a Mirror sending to the endpoints of the given configurations. It has to be run with Run.
+func NewMirror(logger log.Logger, reg prometheus.Registerer, confs []MirrorConfig) (*Mirror, error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+
+	sent := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_receive_mirror_requests_total",
+		Help: "The number of write requests sent to mirror remote write endpoints.",
+	}, []string{"endpoint", "result"})
+	dropped := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_receive_mirror_dropped_series_total",
+		Help: "The number of series that could not be mirrored to remote write endpoints.",
+	}, []string{"endpoint", "reason"})
+	queued := promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "thanos_receive_mirror_queue_length",
+		Help: "The number of write requests waiting to be mirrored to remote write endpoints.",
+	}, []string{"endpoint"})
+
+	m := &Mirror{}
+	for _, conf := range confs {
+		u, err := url.Parse(conf.URL)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse mirror URL %s", conf.URL)
+		}
+		client, err := httpconfig.NewHTTPClient(conf.HTTPClientConfig, "receive-mirror")
+		if err != nil {
+			return nil, errors.Wrapf(err, "create HTTP client of mirror %s", u.Redacted())
+		}
+		client.Timeout = time.Duration(conf.Timeout)
+
+		e := &mirrorEndpoint{
+			logger: log.With(logger, "endpoint", u.Redacted()),
+			conf:   conf,
+			client: client,
+			queue:  make(chan mirrorRequest, conf.QueueSize),
+
+			sent:    sent.MustCurryWith(prometheus.Labels{"endpoint": u.Redacted()}),
+			dropped: dropped.MustCurryWith(prometheus.Labels{"endpoint": u.Redacted()}),
+			queued:  queued.WithLabelValues(u.Redacted()),
+		}
+		if len(conf.Tenants) > 0 {
+			e.tenants = make(map[string]struct{}, len(conf.Tenants))
+			for _, tenant := range conf.Tenants {
+				e.tenants[tenant] = struct{}{}
+			}
+		}
+		m.endpoints = append(m.endpoints, e)
+	}
+	return m, nil
+}
+
+// Run sends the queued write requests until the context is done.
+func (m *Mirror) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, e := range m.endpoints {
+		wg.Add(1)
+		go func(e *mirrorEndpoint) {
+			defer wg.Done()
+			e.run(ctx)
+		}(e)
+	}
+	wg.Wait()
+}
+
+// Write queues the series of the tenant's write request for mirroring. It is a no-op on a nil Mirror.
+// The write request can be reused once Write returns.
+func (m *Mirror) Write(tenant string, wreq *prompb.WriteRequest) {
+	if m == nil {
+		return
+	}
+	for _, e := range m.endpoints {
+		e.write(tenant, wreq)
+	}
+}
+
+// mirrorRequest is an encoded write request waiting to be mirrored.
+type mirrorRequest struct {
+	tenant string
+	body   []byte
+	series int
+}
+
+type mirrorEndpoint struct {
+	logger  log.Logger
+	conf    MirrorConfig
+	client  *http.Client
+	tenants map[string]struct{}
+	queue   chan mirrorRequest
+
+	sent    *prometheus.CounterVec
+	dropped *prometheus.CounterVec
+	queued  prometheus.Gauge
+}
+
+func (e *mirrorEndpoint) write(tenant string, wreq *prompb.WriteRequest) {
+	if e.tenants != nil {
+		if _, ok := e.tenants[tenant]; !ok {
+			return
+		}
+	}
+
+	timeSeries := wreq.Timeseries
+	if len(e.conf.RelabelConfigs) > 0 {
+		timeSeries = make([]prompb.TimeSeries, 0, len(wreq.Timeseries))
+		for _, ts := range wreq.Timeseries {
+			lbls, keep := relabel.Process(labelpb.ZLabelsToPromLabels(ts.Labels), e.conf.RelabelConfigs...)
+			if !keep {
+				continue
+			}
+			ts.Labels = labelpb.ZLabelsFromPromLabels(lbls)
+			timeSeries = append(timeSeries, ts)
+		}
+		if len(timeSeries) == 0 {
+			return
+		}
+	}
+
+	// The request is encoded right away, as its labels point into the buffer of the incoming request.
+	buf, err := (&prompb.WriteRequest{Timeseries: timeSeries}).Marshal()
+	if err != nil {
+		level.Warn(e.logger).Log("msg", "failed to encode mirrored write request", "err", err)
+		e.dropped.WithLabelValues("encoding").Add(float64(len(timeSeries)))
+		return
+	}
+
+	e.queued.Inc()
+	select {
+	case e.queue <- mirrorRequest{tenant: tenant, body: s2.EncodeSnappy(nil, buf), series: len(timeSeries)}:
+	default:
+		e.queued.Dec()
+		e.dropped.WithLabelValues("queue_full").Add(float64(len(timeSeries)))
+	}
+}
+
+func (e *mirrorEndpoint) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			if n := len(e.queue); n > 0 {
+				level.Warn(e.logger).Log("msg", "dropping queued mirrored write requests on shutdown", "requests", n)
+			}
+			return
+		case req := <-e.queue:
+			e.queued.Dec()
+			if err := e.send(ctx, req); err != nil {
+				level.Warn(e.logger).Log("msg", "failed to mirror write request", "tenant", req.tenant, "err", err)
+				e.sent.WithLabelValues(labelError).Inc()
+				e.dropped.WithLabelValues("failed").Add(float64(req.series))
+				continue
+			}
+			e.sent.WithLabelValues(labelSuccess).Inc()
+		}
+	}
+}
+
+// send sends the write request, retrying it with backoff on recoverable errors.
+func (e *mirrorEndpoint) send(ctx context.Context, req mirrorRequest) error {
+	b := backoff.Backoff{
+		Factor: 2,
+		Min:    100 * time.Millisecond,
+		Max:    10 * time.Second,
+		Jitter: true,
+	}
+	for {
+		err := e.sendOnce(ctx, req)
+		if err == nil {
+			return nil
+		}
+		var rerr recoverableMirrorError
+		if !errors.As(err, &rerr) || int(b.Attempt()) >= e.conf.MaxRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(b.Duration()):
+		}
+	}
+}
+
+// recoverableMirrorError is an error after which the write request can be retried.
+type recoverableMirrorError struct {
+	error
+}
+
+func (e *mirrorEndpoint) sendOnce(ctx context.Context, req mirrorRequest) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, e.conf.URL, bytes.NewReader(req.body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if e.conf.TenantHeader != "" {
+		httpReq.Header.Set(e.conf.TenantHeader, req.tenant)
+	}
+
+	resp, err := e.client.Do(httpReq)
+	if err != nil {
+		return recoverableMirrorError{err}
+	}
+	defer runutil.ExhaustCloseWithLogOnErr(e.logger, resp.Body, "mirror response body")
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	err = errors.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(body))
+	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+		return recoverableMirrorError{err}
+	}
+	return err
+}
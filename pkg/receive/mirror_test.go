@@ -0,0 +1,119 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/klauspost/compress/s2"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+func TestParseMirrorConfigs(t *testing.T) {
+	_, err := ParseMirrorConfigs([]byte(`- url: /api/v1/write`))
+	testutil.NotOk(t, err)
+
+	confs, err := ParseMirrorConfigs([]byte(`- url: http://remote/api/v1/write
+  tenants: [team-a]
+  max_retries: 0
+`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(confs))
+	testutil.Equals(t, []string{"team-a"}, confs[0].Tenants)
+	testutil.Equals(t, 0, confs[0].MaxRetries)
+	testutil.Equals(t, DefaultMirrorConfig().QueueSize, confs[0].QueueSize)
+	testutil.Equals(t, DefaultMirrorConfig().Timeout, confs[0].Timeout)
+}
+
+func TestMirror(t *testing.T) {
+	var failures int32
+	received := make(chan *prompb.WriteRequest, 10)
+	tenants := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first request with a recoverable error.
+		if atomic.AddInt32(&failures, 1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		compressed, err := io.ReadAll(r.Body)
+		testutil.Ok(t, err)
+		buf, err := s2.Decode(nil, compressed)
+		testutil.Ok(t, err)
+		var wreq prompb.WriteRequest
+		testutil.Ok(t, wreq.Unmarshal(buf))
+
+		tenants <- r.Header.Get(DefaultTenantHeader)
+		received <- &wreq
+	}))
+	defer srv.Close()
+
+	confs, err := ParseMirrorConfigs([]byte(fmt.Sprintf(`- url: %s/api/v1/write
+  tenants: [team-a]
+  tenant_header: %s
+  relabel_configs:
+  - action: labeldrop
+    regex: pod
+`, srv.URL, DefaultTenantHeader)))
+	testutil.Ok(t, err)
+	reg := prometheus.NewRegistry()
+	m, err := NewMirror(nil, reg, confs)
+	testutil.Ok(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	wreq := &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+		Labels:  labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "up", "pod", "a")),
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 10}},
+	}}}
+	m.Write("team-b", wreq)
+	m.Write("team-a", wreq)
+
+	select {
+	case got := <-received:
+		testutil.Equals(t, "team-a", <-tenants)
+		testutil.Equals(t, []prompb.TimeSeries{{
+			Labels:  labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "up")),
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 10}},
+		}}, got.Timeseries)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the mirrored write request")
+	}
+	// The incoming write request is left untouched.
+	testutil.Equals(t, labels.FromStrings("__name__", "up", "pod", "a"), labelpb.ZLabelsToPromLabels(wreq.Timeseries[0].Labels))
+	testutil.Equals(t, int32(2), atomic.LoadInt32(&failures))
+}
+
+func TestMirrorQueueFull(t *testing.T) {
+	confs, err := ParseMirrorConfigs([]byte(`- url: http://localhost/api/v1/write
+  queue_size: 1
+`))
+	testutil.Ok(t, err)
+	m, err := NewMirror(nil, prometheus.NewRegistry(), confs)
+	testutil.Ok(t, err)
+
+	// Without running the mirror, the second write request does not fit into the queue.
+	wreq := &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+		Labels:  labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "up")),
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 10}},
+	}}}
+	m.Write("team-a", wreq)
+	m.Write("team-a", wreq)
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(m.endpoints[0].dropped.WithLabelValues("queue_full")))
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(m.endpoints[0].queued))
+}
@@ -10,27 +10,32 @@ import (
 	"path"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb"
 	"go.uber.org/atomic"
-	"golang.org/x/sync/errgroup"
 
 	"github.com/thanos-io/thanos/pkg/api/status"
 
 	"github.com/thanos-io/objstore"
 
+	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 	"github.com/thanos-io/thanos/pkg/component"
 	"github.com/thanos-io/thanos/pkg/errutil"
 	"github.com/thanos-io/thanos/pkg/exemplars"
+	"github.com/thanos-io/thanos/pkg/extobjstore"
+	"github.com/thanos-io/thanos/pkg/overrides"
 	"github.com/thanos-io/thanos/pkg/shipper"
 	"github.com/thanos-io/thanos/pkg/store"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
@@ -43,6 +48,13 @@ type TSDBStats interface {
 	TenantStats(statsByLabelName string, tenantIDs ...string) []status.TenantStats
 }
 
+// TSDBSnapshotter snapshots the TSDBs of tenants to object storage.
+type TSDBSnapshotter interface {
+	// Snapshot snapshots the TSDBs of the given tenants, including their heads, under the given name.
+	// If no tenantIDs are provided, all tenants are snapshotted. It returns the snapshotted tenants.
+	Snapshot(ctx context.Context, name string, tenantIDs ...string) ([]string, error)
+}
+
 type MultiTSDB struct {
 	dataDir         string
 	logger          log.Logger
@@ -56,6 +68,108 @@ type MultiTSDB struct {
 	tenants               map[string]*tenant
 	allowOutOfOrderUpload bool
 	hashFunc              metadata.HashFunc
+
+	// tenantOutOfOrderTimeWindows overrides the out-of-order time window (in milliseconds) of the TSDB options for specific tenants.
+	tenantOutOfOrderTimeWindows map[string]int64
+	// nativeHistograms enables the ingestion of native histograms for all tenants, replacing the TSDB options.
+	nativeHistograms *atomic.Bool
+	// tenantNativeHistograms enables the ingestion of native histograms for specific tenants.
+	tenantNativeHistograms map[string]struct{}
+	// tenantRetentions overrides the retention duration (in milliseconds) of the TSDB options for specific tenants.
+	tenantRetentions map[string]int64
+	// tenantBlockDurations overrides the min and max block duration (in milliseconds) of the TSDB options for specific tenants.
+	tenantBlockDurations map[string]int64
+	// tenantUploadIntervals is the minimum interval between two uploads of blocks of specific tenants.
+	tenantUploadIntervals map[string]time.Duration
+	// headSeriesLimit is the maximum number of series in the head of a tenant, unless overridden by overrides.
+	headSeriesLimit uint64
+	// walSizeLimit is the maximum size (in bytes) of the WAL of a tenant, unless overridden by overrides.
+	walSizeLimit int64
+	// overrides override the head series and WAL size limits of tenants, and are reloaded at runtime.
+	overrides *overrides.Manager
+
+	// failedTenants are the tenants whose TSDB failed to open. They are isolated from the other tenants
+	// and opening their TSDB is retried after tenantOpenRetryInterval.
+	failedTenants map[string]tenantFailure
+
+	limitsExceeded *prometheus.CounterVec
+	openFailures   *prometheus.CounterVec
+}
+
+// tenantFailure is a failure to open the TSDB of a tenant.
+type tenantFailure struct {
+	err error
+	at  time.Time
+}
+
+// MultiTSDBOption is a functional option for MultiTSDB.
+type MultiTSDBOption func(mt *MultiTSDB)
+
+// WithTenantOutOfOrderTimeWindows configures the out-of-order time window (in milliseconds) for specific tenants,
+// overriding the one of the default TSDB options.
+func WithTenantOutOfOrderTimeWindows(windows map[string]int64) MultiTSDBOption {
+	return func(mt *MultiTSDB) {
+		mt.tenantOutOfOrderTimeWindows = windows
+	}
+}
+
+// WithTenantNativeHistograms enables the ingestion of native histograms for the given tenants,
+// even if it is disabled in the default TSDB options.
+func WithTenantNativeHistograms(tenants ...string) MultiTSDBOption {
+	return func(mt *MultiTSDB) {
+		mt.tenantNativeHistograms = make(map[string]struct{}, len(tenants))
+		for _, tenant := range tenants {
+			mt.tenantNativeHistograms[tenant] = struct{}{}
+		}
+	}
+}
+
+// WithTenantRetentions configures the retention duration (in milliseconds) for specific tenants,
+// overriding the one of the default TSDB options. A retention of 0 means infinite retention.
+func WithTenantRetentions(retentions map[string]int64) MultiTSDBOption {
+	return func(mt *MultiTSDB) {
+		mt.tenantRetentions = retentions
+	}
+}
+
+// WithTenantBlockDurations configures the block duration (in milliseconds) for specific tenants,
+// overriding both the min and max block duration of the default TSDB options.
+func WithTenantBlockDurations(durations map[string]int64) MultiTSDBOption {
+	return func(mt *MultiTSDB) {
+		mt.tenantBlockDurations = durations
+	}
+}
+
+// WithTenantUploadIntervals configures the minimum interval between two uploads of blocks of specific tenants.
+// Blocks of other tenants are uploaded on every Sync.
+func WithTenantUploadIntervals(intervals map[string]time.Duration) MultiTSDBOption {
+	return func(mt *MultiTSDB) {
+		mt.tenantUploadIntervals = intervals
+	}
+}
+
+// WithTenantHeadSeriesLimit limits the number of series in the head of each tenant. Samples of new series beyond the
+// limit are rejected. A limit of 0 means no limit.
+func WithTenantHeadSeriesLimit(limit uint64) MultiTSDBOption {
+	return func(mt *MultiTSDB) {
+		mt.headSeriesLimit = limit
+	}
+}
+
+// WithTenantWALSizeLimit limits the size (in bytes) of the WAL of each tenant. Samples of new series are rejected while
+// the WAL exceeds the limit, until it is truncated by the next head compaction. A limit of 0 means no limit.
+func WithTenantWALSizeLimit(limit int64) MultiTSDBOption {
+	return func(mt *MultiTSDB) {
+		mt.walSizeLimit = limit
+	}
+}
+
+// WithOverrides overrides the head series and WAL size limits of tenants by the limits of the given overrides, which
+// apply to the next appends of tenants when they are reloaded.
+func WithOverrides(o *overrides.Manager) MultiTSDBOption {
+	return func(mt *MultiTSDB) {
+		mt.overrides = o
+	}
 }
 
 // NewMultiTSDB creates new MultiTSDB.
@@ -70,12 +184,13 @@ func NewMultiTSDB(
 	bucket objstore.Bucket,
 	allowOutOfOrderUpload bool,
 	hashFunc metadata.HashFunc,
+	options ...MultiTSDBOption,
 ) *MultiTSDB {
 	if l == nil {
 		l = log.NewNopLogger()
 	}
 
-	return &MultiTSDB{
+	mt := &MultiTSDB{
 		dataDir:               dataDir,
 		logger:                log.With(l, "component", "multi-tsdb"),
 		reg:                   reg,
@@ -87,7 +202,22 @@ func NewMultiTSDB(
 		bucket:                bucket,
 		allowOutOfOrderUpload: allowOutOfOrderUpload,
 		hashFunc:              hashFunc,
+		failedTenants:         map[string]tenantFailure{},
+		nativeHistograms:      atomic.NewBool(tsdbOpts.EnableNativeHistograms),
+		limitsExceeded: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_receive_tenant_limits_exceeded_total",
+			Help: "The number of appends rejected because a tenant exceeded one of its TSDB resource limits.",
+		}, []string{"tenant", "limit"}),
+		openFailures: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_receive_tenant_tsdb_open_failures_total",
+			Help: "The number of times the TSDB of a tenant failed to open.",
+		}, []string{"tenant"}),
+	}
+
+	for _, option := range options {
+		option(mt)
 	}
+	return mt
 }
 
 type localClient struct {
@@ -138,6 +268,8 @@ type tenant struct {
 	storeTSDB     *store.TSDBStore
 	exemplarsTSDB *exemplars.TSDB
 	ship          *shipper.Shipper
+	// lastUpload is the time the blocks of the tenant were last uploaded successfully by Sync.
+	lastUpload time.Time
 
 	mtx *sync.RWMutex
 }
@@ -149,6 +281,28 @@ func newTenant() *tenant {
 	}
 }
 
+// uploadDue reports whether the blocks of the tenant should be uploaded at the given time, given the minimum
+// interval between two successful uploads.
+func (t *tenant) uploadDue(now time.Time, interval time.Duration) bool {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+	return interval <= 0 || now.Sub(t.lastUpload) >= interval
+}
+
+// uploaded records that the blocks of the tenant were uploaded successfully at the given time.
+func (t *tenant) uploaded(now time.Time) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.lastUpload = now
+}
+
+// resetUpload makes the blocks of the tenant be uploaded by the next Sync, regardless of its upload interval.
+func (t *tenant) resetUpload() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.lastUpload = time.Time{}
+}
+
 func (t *tenant) readyStorage() *ReadyStorage {
 	return t.readyS
 }
@@ -183,8 +337,10 @@ func (t *tenant) shipper() *shipper.Shipper {
 	return t.ship
 }
 
-func (t *tenant) set(storeTSDB *store.TSDBStore, tenantTSDB *tsdb.DB, ship *shipper.Shipper, exemplarsTSDB *exemplars.TSDB) {
-	t.readyS.Set(tenantTSDB)
+func (t *tenant) set(storeTSDB *store.TSDBStore, tenantTSDB *tsdb.DB, ship *shipper.Shipper, exemplarsTSDB *exemplars.TSDB, limits *tenantLimits) {
+	t.readyS.mtx.Lock()
+	t.readyS.set(&adapter{db: tenantTSDB, limits: limits})
+	t.readyS.mtx.Unlock()
 	t.mtx.Lock()
 	t.setComponents(storeTSDB, ship, exemplarsTSDB)
 	t.mtx.Unlock()
@@ -206,20 +362,25 @@ func (t *MultiTSDB) Open() error {
 		return err
 	}
 
-	var g errgroup.Group
+	// A tenant whose TSDB fails to open, e.g. because of a corrupted WAL, must not prevent the other tenants
+	// from ingesting. The failure is recorded by startTSDB and the tenant is isolated until it can be opened.
+	var wg sync.WaitGroup
 	for _, f := range files {
 		f := f
 		if !f.IsDir() {
 			continue
 		}
 
-		g.Go(func() error {
-			_, err := t.getOrLoadTenant(f.Name(), true)
-			return err
-		})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := t.getOrLoadTenant(f.Name(), true); err != nil {
+				level.Error(t.logger).Log("msg", "failed to open TSDB, isolating tenant", "tenant", f.Name(), "err", err)
+			}
+		}()
 	}
-
-	return g.Wait()
+	wg.Wait()
+	return nil
 }
 
 func (t *MultiTSDB) Flush() error {
@@ -236,6 +397,8 @@ func (t *MultiTSDB) Flush() error {
 			continue
 		}
 		level.Info(t.logger).Log("msg", "flushing TSDB", "tenant", id)
+		// The flushed head should not wait for the next upload interval of the tenant.
+		tenant.resetUpload()
 		wg.Add(1)
 		go func() {
 			head := db.Head()
@@ -269,11 +432,27 @@ func (t *MultiTSDB) Close() error {
 	return merr.Err()
 }
 
+// tenantRetention returns the retention duration (in milliseconds) of the given tenant.
+func (t *MultiTSDB) tenantRetention(tenantID string) int64 {
+	if retention, ok := t.tenantRetentions[tenantID]; ok {
+		return retention
+	}
+	return t.tsdbOpts.RetentionDuration
+}
+
+// tenantMaxBlockDuration returns the max block duration (in milliseconds) of the given tenant.
+func (t *MultiTSDB) tenantMaxBlockDuration(tenantID string) int64 {
+	if duration, ok := t.tenantBlockDurations[tenantID]; ok {
+		return duration
+	}
+	return t.tsdbOpts.MaxBlockDuration
+}
+
 // Prune flushes and closes the TSDB for tenants that haven't received
-// any new samples for longer than the TSDB retention period.
+// any new samples for longer than their TSDB retention period.
 func (t *MultiTSDB) Prune(ctx context.Context) error {
 	// Retention of 0 means infinite retention.
-	if t.tsdbOpts.RetentionDuration == 0 {
+	if t.tsdbOpts.RetentionDuration == 0 && len(t.tenantRetentions) == 0 {
 		return nil
 	}
 
@@ -291,7 +470,7 @@ func (t *MultiTSDB) Prune(ctx context.Context) error {
 		go func(tenantID string, tenantInstance *tenant) {
 			defer wg.Done()
 			tlog := log.With(t.logger, "tenant", tenantID)
-			pruned, err := t.pruneTSDB(ctx, tlog, tenantInstance)
+			pruned, err := t.pruneTSDB(ctx, tlog, tenantID, tenantInstance)
 			if err != nil {
 				merr.Add(err)
 				return
@@ -324,7 +503,12 @@ func (t *MultiTSDB) Prune(ctx context.Context) error {
 
 // pruneTSDB removes a TSDB if its past the retention period.
 // It compacts the TSDB head, sends all remaining blocks to S3 and removes the TSDB from disk.
-func (t *MultiTSDB) pruneTSDB(ctx context.Context, logger log.Logger, tenantInstance *tenant) (bool, error) {
+func (t *MultiTSDB) pruneTSDB(ctx context.Context, logger log.Logger, tenantID string, tenantInstance *tenant) (bool, error) {
+	retention := t.tenantRetention(tenantID)
+	if retention == 0 {
+		return false, nil
+	}
+
 	tenantTSDB := tenantInstance.readyStorage()
 	if tenantTSDB == nil {
 		return false, nil
@@ -343,7 +527,7 @@ func (t *MultiTSDB) pruneTSDB(ctx context.Context, logger log.Logger, tenantInst
 	}
 
 	sinceLastAppendMillis := time.Since(time.UnixMilli(head.MaxTime())).Milliseconds()
-	compactThreshold := int64(1.5 * float64(t.tsdbOpts.MaxBlockDuration))
+	compactThreshold := int64(1.5 * float64(t.tenantMaxBlockDuration(tenantID)))
 	if sinceLastAppendMillis <= compactThreshold {
 		tenantTSDB.mtx.RUnlock()
 		return false, nil
@@ -368,13 +552,13 @@ func (t *MultiTSDB) pruneTSDB(ctx context.Context, logger log.Logger, tenantInst
 		return false, err
 	}
 
-	if sinceLastAppendMillis <= t.tsdbOpts.RetentionDuration {
+	if sinceLastAppendMillis <= retention {
 		return false, nil
 	}
 
 	level.Info(logger).Log("msg", "Pruning tenant")
 	if tenantInstance.ship != nil {
-		uploaded, err := tenantInstance.ship.Sync(ctx)
+		uploaded, err := tenantInstance.ship.Sync(extobjstore.WithTenant(ctx, tenantID))
 		if err != nil {
 			return false, err
 		}
@@ -413,19 +597,28 @@ func (t *MultiTSDB) Sync(ctx context.Context) (int, error) {
 		uploaded atomic.Int64
 	)
 
+	now := time.Now()
 	for tenantID, tenant := range t.tenants {
-		level.Debug(t.logger).Log("msg", "uploading block for tenant", "tenant", tenantID)
+		tenant := tenant
 		s := tenant.shipper()
 		if s == nil {
 			continue
 		}
+		if !tenant.uploadDue(now, t.tenantUploadIntervals[tenantID]) {
+			continue
+		}
+		level.Debug(t.logger).Log("msg", "uploading block for tenant", "tenant", tenantID)
+		tenantCtx := extobjstore.WithTenant(ctx, tenantID)
 		wg.Add(1)
 		go func() {
-			up, err := s.Sync(ctx)
+			up, err := s.Sync(tenantCtx)
 			if err != nil {
 				errmtx.Lock()
 				merr.Add(errors.Wrap(err, "upload"))
 				errmtx.Unlock()
+			} else {
+				// Failed uploads are retried by the next Sync, regardless of the upload interval.
+				tenant.uploaded(now)
 			}
 			uploaded.Add(int64(up))
 			wg.Done()
@@ -544,6 +737,20 @@ func (t *MultiTSDB) startTSDB(logger log.Logger, tenantID string, tenant *tenant
 
 	level.Info(logger).Log("msg", "opening TSDB")
 	opts := *t.tsdbOpts
+	opts.EnableNativeHistograms = t.nativeHistograms.Load()
+	if window, ok := t.tenantOutOfOrderTimeWindows[tenantID]; ok {
+		opts.OutOfOrderTimeWindow = window
+	}
+	if _, ok := t.tenantNativeHistograms[tenantID]; ok {
+		opts.EnableNativeHistograms = true
+	}
+	if retention, ok := t.tenantRetentions[tenantID]; ok {
+		opts.RetentionDuration = retention
+	}
+	if duration, ok := t.tenantBlockDurations[tenantID]; ok {
+		opts.MinBlockDuration = duration
+		opts.MaxBlockDuration = duration
+	}
 	s, err := tsdb.Open(
 		dataDir,
 		logger,
@@ -552,11 +759,16 @@ func (t *MultiTSDB) startTSDB(logger log.Logger, tenantID string, tenant *tenant
 		nil,
 	)
 	if err != nil {
+		t.openFailures.WithLabelValues(tenantID).Inc()
 		t.mtx.Lock()
 		delete(t.tenants, tenantID)
+		t.failedTenants[tenantID] = tenantFailure{err: err, at: time.Now()}
 		t.mtx.Unlock()
 		return err
 	}
+	t.mtx.Lock()
+	delete(t.failedTenants, tenantID)
+	t.mtx.Unlock()
 	var ship *shipper.Shipper
 	if t.bucket != nil {
 		ship = shipper.New(
@@ -566,16 +778,255 @@ func (t *MultiTSDB) startTSDB(logger log.Logger, tenantID string, tenant *tenant
 			t.bucket,
 			func() labels.Labels { return lset },
 			metadata.ReceiveSource,
-			false,
-			t.allowOutOfOrderUpload,
 			t.hashFunc,
+			shipper.WithAllowOutOfOrderUploads(t.allowOutOfOrderUpload),
 		)
 	}
-	tenant.set(store.NewTSDBStore(logger, s, component.Receive, lset), s, ship, exemplars.NewTSDB(s, lset))
+	tenant.set(store.NewTSDBStore(logger, s, component.Receive, lset), s, ship, exemplars.NewTSDB(s, lset), t.tenantLimits(tenantID))
+	// The ingestion of native histograms may have changed while the TSDB was opening.
+	if _, ok := t.tenantNativeHistograms[tenantID]; !ok && t.nativeHistograms.Load() != opts.EnableNativeHistograms {
+		if t.nativeHistograms.Load() {
+			s.EnableNativeHistograms()
+		} else {
+			s.DisableNativeHistograms()
+		}
+	}
 	level.Info(logger).Log("msg", "TSDB is now ready")
 	return nil
 }
 
+// SnapshotsDir is the directory holding the snapshots of tenants in the bucket, with one directory per snapshot
+// and one directory per tenant inside of it. It is also used within the TSDB directory of tenants while snapshotting.
+const SnapshotsDir = "snapshots"
+
+// Snapshot snapshots the TSDBs of the given tenants, including their heads, and uploads them to the bucket under
+// <SnapshotsDir>/<name>/<tenant>, along with the list of blocks already uploaded by the shipper. If no tenantIDs
+// are provided, all ready tenants are snapshotted. It returns the snapshotted tenants.
+func (t *MultiTSDB) Snapshot(ctx context.Context, name string, tenantIDs ...string) ([]string, error) {
+	if t.bucket == nil {
+		return nil, errors.New("snapshots require object storage to be configured")
+	}
+
+	t.mtx.RLock()
+	all := len(tenantIDs) == 0
+	if all {
+		for tenantID := range t.tenants {
+			tenantIDs = append(tenantIDs, tenantID)
+		}
+	}
+	tenants := make(map[string]*tenant, len(tenantIDs))
+	for _, tenantID := range tenantIDs {
+		tenant, ok := t.tenants[tenantID]
+		if !ok {
+			t.mtx.RUnlock()
+			return nil, errors.Errorf("unknown tenant %s", tenantID)
+		}
+		tenants[tenantID] = tenant
+	}
+	t.mtx.RUnlock()
+
+	snapshotted := make([]string, 0, len(tenants))
+	for tenantID, tenant := range tenants {
+		db := tenant.readyStorage().Get()
+		if db == nil {
+			if all {
+				continue
+			}
+			return nil, errors.Errorf("TSDB of tenant %s is not ready", tenantID)
+		}
+		if err := t.snapshotTSDB(ctx, name, tenantID, db); err != nil {
+			return nil, errors.Wrapf(err, "snapshot tenant %s", tenantID)
+		}
+		snapshotted = append(snapshotted, tenantID)
+	}
+	sort.Strings(snapshotted)
+	return snapshotted, nil
+}
+
+func (t *MultiTSDB) snapshotTSDB(ctx context.Context, name, tenantID string, db *tsdb.DB) error {
+	logger := log.With(t.logger, "tenant", tenantID, "snapshot", name)
+	dataDir := t.defaultTenantDataDir(tenantID)
+	dir := filepath.Join(dataDir, SnapshotsDir, name)
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			level.Warn(logger).Log("msg", "failed to remove local snapshot", "err", err)
+		}
+	}()
+
+	level.Info(logger).Log("msg", "snapshotting TSDB")
+	if err := db.Snapshot(dir, true); err != nil {
+		return errors.Wrap(err, "snapshot TSDB")
+	}
+	// The restored blocks already uploaded by the shipper must not be uploaded again.
+	meta, err := shipper.ReadMetaFile(dataDir)
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return errors.Wrap(err, "read shipper meta file")
+	}
+	if meta != nil {
+		if err := shipper.WriteMetaFile(logger, dir, meta); err != nil {
+			return errors.Wrap(err, "write shipper meta file")
+		}
+	}
+	if err := objstore.UploadDir(ctx, logger, t.bucket, dir, path.Join(SnapshotsDir, name, tenantID)); err != nil {
+		return errors.Wrap(err, "upload snapshot")
+	}
+	level.Info(logger).Log("msg", "uploaded TSDB snapshot")
+	return nil
+}
+
+// RestoreSnapshot downloads the snapshot with the given name from the bucket into the data directory, so that it is
+// loaded by the next Open. It must be called before Open. Tenants that already have a local TSDB are skipped, so that
+// local data is never overwritten. Restored blocks whose time range is already covered by blocks of the tenant in the
+// bucket are marked as uploaded, so that the shipper does not upload their data twice. It returns the restored tenants.
+func (t *MultiTSDB) RestoreSnapshot(ctx context.Context, name string) ([]string, error) {
+	if t.bucket == nil {
+		return nil, errors.New("snapshots require object storage to be configured")
+	}
+
+	var tenantIDs []string
+	if err := t.bucket.Iter(ctx, path.Join(SnapshotsDir, name), func(name string) error {
+		if strings.HasSuffix(name, objstore.DirDelim) {
+			tenantIDs = append(tenantIDs, path.Base(name))
+		}
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "list tenants of snapshot")
+	}
+	if len(tenantIDs) == 0 {
+		return nil, errors.Errorf("snapshot %s not found", name)
+	}
+
+	var (
+		restored    = make([]string, 0, len(tenantIDs))
+		bucketMetas map[ulid.ULID]*metadata.Meta
+	)
+	for _, tenantID := range tenantIDs {
+		logger := log.With(t.logger, "tenant", tenantID, "snapshot", name)
+		dir := t.defaultTenantDataDir(tenantID)
+		if _, err := os.Stat(dir); err == nil {
+			level.Warn(logger).Log("msg", "tenant already has a local TSDB, not restoring snapshot")
+			continue
+		} else if !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "stat TSDB directory of tenant %s", tenantID)
+		}
+
+		level.Info(logger).Log("msg", "restoring TSDB snapshot")
+		src := path.Join(SnapshotsDir, name, tenantID)
+		if err := objstore.DownloadDir(ctx, logger, t.bucket, src, src, dir); err != nil {
+			if rerr := os.RemoveAll(dir); rerr != nil {
+				level.Warn(logger).Log("msg", "failed to remove partially restored TSDB", "err", rerr)
+			}
+			return nil, errors.Wrapf(err, "download snapshot of tenant %s", tenantID)
+		}
+		if bucketMetas == nil {
+			var err error
+			if bucketMetas, err = t.bucketBlockMetas(ctx); err != nil {
+				return nil, err
+			}
+		}
+		if err := t.markShippedBlocks(logger, tenantID, dir, bucketMetas); err != nil {
+			return nil, errors.Wrapf(err, "mark shipped blocks of tenant %s", tenantID)
+		}
+		restored = append(restored, tenantID)
+	}
+	return restored, nil
+}
+
+// bucketBlockMetas returns the metas of the blocks in the bucket.
+func (t *MultiTSDB) bucketBlockMetas(ctx context.Context) (map[ulid.ULID]*metadata.Meta, error) {
+	fetcher, err := block.NewMetaFetcher(t.logger, block.FetcherConcurrency, objstore.WithNoopInstr(t.bucket), "", nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "create meta fetcher")
+	}
+	metas, _, err := fetcher.Fetch(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch metas of bucket blocks")
+	}
+	return metas, nil
+}
+
+// markShippedBlocks adds the restored blocks of the tenant whose time range is covered by the blocks of the tenant in
+// the bucket to the blocks uploaded by the shipper. The snapshot lists the blocks uploaded when it was taken, but its
+// head block, and blocks uploaded since then by the snapshotted receiver, would be uploaded again otherwise. Blocks
+// only partially covered are still uploaded, as they hold data which is not in the bucket.
+func (t *MultiTSDB) markShippedBlocks(logger log.Logger, tenantID, dir string, bucketMetas map[ulid.ULID]*metadata.Meta) error {
+	lset := labelpb.ExtendSortedLabels(t.labels, labels.FromStrings(t.tenantLabelName, tenantID))
+	var tenantMetas []tsdb.BlockMeta
+	for _, m := range bucketMetas {
+		if labels.Equal(labels.FromMap(m.Thanos.Labels), lset) {
+			tenantMetas = append(tenantMetas, m.BlockMeta)
+		}
+	}
+	sort.Slice(tenantMetas, func(i, j int) bool { return tenantMetas[i].MinTime < tenantMetas[j].MinTime })
+
+	meta, err := shipper.ReadMetaFile(dir)
+	if err != nil {
+		if !os.IsNotExist(errors.Cause(err)) {
+			return errors.Wrap(err, "read shipper meta file")
+		}
+		meta = &shipper.Meta{Version: shipper.MetaVersion1}
+	}
+	uploaded := make(map[ulid.ULID]struct{}, len(meta.Uploaded))
+	for _, id := range meta.Uploaded {
+		uploaded[id] = struct{}{}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "read TSDB directory")
+	}
+	for _, e := range entries {
+		id, ok := block.IsBlockDir(e.Name())
+		if !ok {
+			continue
+		}
+		if _, ok := uploaded[id]; ok {
+			continue
+		}
+		m, err := metadata.ReadFromDir(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return errors.Wrapf(err, "read meta of block %s", id)
+		}
+
+		// The range is covered if the bucket blocks overlapping it leave no gap.
+		covered, overlapping := m.MinTime, false
+		for _, bm := range tenantMetas {
+			if bm.MaxTime <= m.MinTime || bm.MinTime >= m.MaxTime {
+				continue
+			}
+			overlapping = true
+			if bm.MinTime <= covered && bm.MaxTime > covered {
+				covered = bm.MaxTime
+			}
+		}
+		switch {
+		case covered >= m.MaxTime:
+			level.Info(logger).Log("msg", "restored block is already covered by blocks in the bucket, not uploading it", "block", id)
+			meta.Uploaded = append(meta.Uploaded, id)
+		case overlapping:
+			level.Warn(logger).Log("msg", "restored block partially overlaps blocks in the bucket and will be uploaded; vertical compaction is needed to deduplicate its data", "block", id)
+		}
+	}
+	return shipper.WriteMetaFile(logger, dir, meta)
+}
+
+// tenantLimits returns the resource limits of the TSDB of the given tenant, or nil if it is not limited.
+func (t *MultiTSDB) tenantLimits(tenantID string) *tenantLimits {
+	limits := &tenantLimits{
+		maxHeadSeries: t.headSeriesLimit,
+		maxWALSize:    t.walSizeLimit,
+		tenantID:      tenantID,
+		overrides:     t.overrides,
+	}
+	// Tenants may be limited by overrides reloaded later on.
+	if limits.maxHeadSeries == 0 && limits.maxWALSize == 0 && t.overrides == nil {
+		return nil
+	}
+	limits.headSeriesExceeded = t.limitsExceeded.WithLabelValues(tenantID, "head_series")
+	limits.walSizeExceeded = t.limitsExceeded.WithLabelValues(tenantID, "wal_size")
+	return limits
+}
+
 func (t *MultiTSDB) defaultTenantDataDir(tenantID string) string {
 	return path.Join(t.dataDir, tenantID)
 }
@@ -599,6 +1050,11 @@ func (t *MultiTSDB) getOrLoadTenant(tenantID string, blockingStart bool) (*tenan
 		return tenant, nil
 	}
 
+	if failure, ok := t.failedTenants[tenantID]; ok && time.Since(failure.at) < tenantOpenRetryInterval {
+		t.mtx.Unlock()
+		return nil, errors.Wrap(failure.err, "TSDB of tenant failed to open")
+	}
+
 	tenant = newTenant()
 	t.tenants[tenantID] = tenant
 	t.mtx.Unlock()
@@ -615,6 +1071,28 @@ func (t *MultiTSDB) getOrLoadTenant(tenantID string, blockingStart bool) (*tenan
 	return tenant, t.startTSDB(logger, tenantID, tenant)
 }
 
+// SetNativeHistograms enables or disables the ingestion of native histograms by the TSDBs of all tenants, including the
+// TSDBs already open. The tenants given to WithTenantNativeHistograms keep ingesting them.
+func (t *MultiTSDB) SetNativeHistograms(enabled bool) {
+	t.nativeHistograms.Store(enabled)
+
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	for tenantID, tenant := range t.tenants {
+		db := tenant.readyStorage().Get()
+		if db == nil {
+			// TSDBs being opened apply the new setting once open.
+			continue
+		}
+		if _, ok := t.tenantNativeHistograms[tenantID]; enabled || ok {
+			db.EnableNativeHistograms()
+		} else {
+			db.DisableNativeHistograms()
+		}
+	}
+}
+
 func (t *MultiTSDB) TenantAppendable(tenantID string) (Appendable, error) {
 	tenant, err := t.getOrLoadTenant(tenantID, false)
 	if err != nil {
@@ -700,7 +1178,8 @@ func (s *ReadyStorage) Close() error {
 
 // adapter implements a storage.Storage around TSDB.
 type adapter struct {
-	db *tsdb.DB
+	db     *tsdb.DB
+	limits *tenantLimits
 }
 
 // StartTime implements the Storage interface.
@@ -716,9 +1195,12 @@ func (a adapter) ExemplarQuerier(ctx context.Context) (storage.ExemplarQuerier,
 	return a.db.ExemplarQuerier(ctx)
 }
 
-// Appender returns a new appender against the storage.
+// Appender returns a new appender against the storage, enforcing the resource limits of the tenant.
 func (a adapter) Appender(ctx context.Context) (storage.Appender, error) {
-	return a.db.Appender(ctx), nil
+	if a.limits == nil {
+		return a.db.Appender(ctx), nil
+	}
+	return a.limits.appender(ctx, a.db)
 }
 
 // Close closes the storage and all its underlying resources.
@@ -4,29 +4,45 @@
 package receive
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"math"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/efficientgo/core/testutil"
 	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/prometheus/model/exemplar"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/thanos-io/objstore"
+	"go.uber.org/atomic"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
+	"gopkg.in/alecthomas/kingpin.v2"
 
+	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 	"github.com/thanos-io/thanos/pkg/component"
 	"github.com/thanos-io/thanos/pkg/exemplars/exemplarspb"
+	"github.com/thanos-io/thanos/pkg/overrides"
 	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/shipper"
 	"github.com/thanos-io/thanos/pkg/store"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
@@ -517,6 +533,396 @@ func TestMultiTSDBRecreatePrunedTenant(t *testing.T) {
 	testutil.Equals(t, 1, len(m.TSDBLocalClients()))
 }
 
+func TestMultiTSDBTenantOutOfOrderTimeWindow(t *testing.T) {
+	dir := t.TempDir()
+
+	m := NewMultiTSDB(dir, log.NewNopLogger(), prometheus.NewRegistry(),
+		&tsdb.Options{
+			MinBlockDuration:  (2 * time.Hour).Milliseconds(),
+			MaxBlockDuration:  (2 * time.Hour).Milliseconds(),
+			RetentionDuration: (6 * time.Hour).Milliseconds(),
+		},
+		labels.FromStrings("replica", "test"),
+		"tenant_id",
+		nil,
+		false,
+		metadata.NoneFunc,
+		WithTenantOutOfOrderTimeWindows(map[string]int64{"delayed": time.Hour.Milliseconds()}),
+	)
+	defer func() { testutil.Ok(t, m.Close()) }()
+
+	for _, tenant := range []string{"foo", "delayed"} {
+		testutil.Ok(t, appendSample(m, tenant, time.UnixMilli(time.Hour.Milliseconds())))
+	}
+
+	// Only the tenant with an out-of-order time window accepts the delayed sample.
+	testutil.Equals(t, storage.ErrOutOfOrderSample, appendSample(m, "foo", time.UnixMilli((30*time.Minute).Milliseconds())))
+	testutil.Ok(t, appendSample(m, "delayed", time.UnixMilli((30*time.Minute).Milliseconds())))
+}
+
+func TestMultiTSDBTenantRetention(t *testing.T) {
+	dir := t.TempDir()
+
+	m := NewMultiTSDB(dir, log.NewNopLogger(), prometheus.NewRegistry(),
+		&tsdb.Options{
+			MinBlockDuration:  (2 * time.Hour).Milliseconds(),
+			MaxBlockDuration:  (2 * time.Hour).Milliseconds(),
+			RetentionDuration: (6 * time.Hour).Milliseconds(),
+		},
+		labels.FromStrings("replica", "test"),
+		"tenant_id",
+		nil,
+		false,
+		metadata.NoneFunc,
+		WithTenantRetentions(map[string]int64{"short": time.Hour.Milliseconds(), "infinite": 0}),
+		WithTenantBlockDurations(map[string]int64{"short": (30 * time.Minute).Milliseconds()}),
+	)
+	defer func() { testutil.Ok(t, m.Close()) }()
+
+	for _, tenant := range []string{"foo", "short", "infinite"} {
+		testutil.Ok(t, appendSample(m, tenant, time.Now().Add(-4*time.Hour)))
+	}
+	testutil.Equals(t, 3, len(m.TSDBLocalClients()))
+
+	// Only the tenant with a retention shorter than the time since its last sample is pruned.
+	testutil.Ok(t, m.Prune(context.Background()))
+	testutil.Equals(t, 2, len(m.TSDBLocalClients()))
+	_, ok := m.tenants["short"]
+	testutil.Assert(t, !ok, "expected tenant with short retention to be pruned")
+}
+
+func TestMultiTSDBTenantUploadInterval(t *testing.T) {
+	dir := t.TempDir()
+	bucket := objstore.NewInMemBucket()
+
+	m := NewMultiTSDB(dir, log.NewNopLogger(), prometheus.NewRegistry(),
+		&tsdb.Options{
+			MinBlockDuration:  (2 * time.Hour).Milliseconds(),
+			MaxBlockDuration:  (2 * time.Hour).Milliseconds(),
+			RetentionDuration: (6 * time.Hour).Milliseconds(),
+		},
+		labels.FromStrings("replica", "test"),
+		"tenant_id",
+		bucket,
+		false,
+		metadata.NoneFunc,
+		WithTenantUploadIntervals(map[string]time.Duration{"slow": time.Hour}),
+	)
+	defer func() { testutil.Ok(t, m.Close()) }()
+
+	ctx := context.Background()
+	for _, tenant := range []string{"foo", "slow"} {
+		testutil.Ok(t, appendSample(m, tenant, time.Now()))
+	}
+	uploaded, err := m.Sync(ctx)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, uploaded)
+
+	compactHead := func(tenant string) {
+		db := m.tenants[tenant].readyStorage().Get()
+		head := db.Head()
+		testutil.Ok(t, db.CompactHead(tsdb.NewRangeHead(head, head.MinTime(), head.MaxTime())))
+	}
+	compactHead("foo")
+	compactHead("slow")
+
+	// The block of the tenant with an upload interval waits for the next interval.
+	uploaded, err = m.Sync(ctx)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, uploaded)
+
+	// Flushed blocks are uploaded right away.
+	testutil.Ok(t, m.Flush())
+	uploaded, err = m.Sync(ctx)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, uploaded)
+}
+
+type failingUploadBucket struct {
+	objstore.Bucket
+	fail atomic.Bool
+}
+
+func (b *failingUploadBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	if b.fail.Load() {
+		return errors.New("upload failed")
+	}
+	return b.Bucket.Upload(ctx, name, r)
+}
+
+func TestMultiTSDBTenantUploadIntervalFailedUpload(t *testing.T) {
+	dir := t.TempDir()
+	bucket := &failingUploadBucket{Bucket: objstore.NewInMemBucket()}
+	bucket.fail.Store(true)
+
+	m := NewMultiTSDB(dir, log.NewNopLogger(), prometheus.NewRegistry(),
+		&tsdb.Options{
+			MinBlockDuration:  (2 * time.Hour).Milliseconds(),
+			MaxBlockDuration:  (2 * time.Hour).Milliseconds(),
+			RetentionDuration: (6 * time.Hour).Milliseconds(),
+		},
+		labels.FromStrings("replica", "test"),
+		"tenant_id",
+		bucket,
+		false,
+		metadata.NoneFunc,
+		WithTenantUploadIntervals(map[string]time.Duration{"slow": time.Hour}),
+	)
+	defer func() { testutil.Ok(t, m.Close()) }()
+
+	ctx := context.Background()
+	testutil.Ok(t, appendSample(m, "slow", time.Now()))
+	db := m.tenants["slow"].readyStorage().Get()
+	head := db.Head()
+	testutil.Ok(t, db.CompactHead(tsdb.NewRangeHead(head, head.MinTime(), head.MaxTime())))
+
+	_, err := m.Sync(ctx)
+	testutil.NotOk(t, err)
+
+	// A failed upload is retried by the next Sync, regardless of the upload interval.
+	bucket.fail.Store(false)
+	uploaded, err := m.Sync(ctx)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, uploaded)
+}
+
+func TestMultiTSDBTenantLimits(t *testing.T) {
+	defer func(interval time.Duration) { walSizeRefreshInterval = interval }(walSizeRefreshInterval)
+	walSizeRefreshInterval = 0
+
+	newMultiTSDB := func(opt MultiTSDBOption) *MultiTSDB {
+		return NewMultiTSDB(t.TempDir(), log.NewNopLogger(), prometheus.NewRegistry(),
+			&tsdb.Options{
+				MinBlockDuration:  (2 * time.Hour).Milliseconds(),
+				MaxBlockDuration:  (2 * time.Hour).Milliseconds(),
+				RetentionDuration: (6 * time.Hour).Milliseconds(),
+			},
+			labels.FromStrings("replica", "test"),
+			"tenant_id",
+			nil,
+			false,
+			metadata.NoneFunc,
+			opt,
+		)
+	}
+
+	now := time.Now()
+	t.Run("head series", func(t *testing.T) {
+		m := newMultiTSDB(WithTenantHeadSeriesLimit(2))
+		defer func() { testutil.Ok(t, m.Close()) }()
+
+		for i := 0; i < 2; i++ {
+			testutil.Ok(t, appendSampleWithLabels(m, "foo", labels.FromStrings("series", fmt.Sprint(i)), now))
+		}
+		testutil.Equals(t, errTenantHeadSeriesLimit, appendSampleWithLabels(m, "foo", labels.FromStrings("series", "2"), now))
+		// Samples of series in the head are still accepted.
+		testutil.Ok(t, appendSampleWithLabels(m, "foo", labels.FromStrings("series", "0"), now.Add(time.Second)))
+		testutil.Equals(t, 1.0, promtestutil.ToFloat64(m.limitsExceeded.WithLabelValues("foo", "head_series")))
+
+		// The limit applies to each tenant.
+		for i := 0; i < 2; i++ {
+			testutil.Ok(t, appendSampleWithLabels(m, "bar", labels.FromStrings("series", fmt.Sprint(i)), now))
+		}
+	})
+	t.Run("WAL size", func(t *testing.T) {
+		m := newMultiTSDB(WithTenantWALSizeLimit(1))
+		defer func() { testutil.Ok(t, m.Close()) }()
+
+		// Once the first series is written to the WAL, it exceeds its size limit.
+		testutil.Ok(t, appendSampleWithLabels(m, "small-wal", labels.FromStrings("series", "0"), now))
+		testutil.Equals(t, errTenantWALSizeLimit, appendSampleWithLabels(m, "small-wal", labels.FromStrings("series", "1"), now))
+		testutil.Ok(t, appendSampleWithLabels(m, "small-wal", labels.FromStrings("series", "0"), now.Add(time.Second)))
+		testutil.Equals(t, 1.0, promtestutil.ToFloat64(m.limitsExceeded.WithLabelValues("small-wal", "wal_size")))
+	})
+}
+
+func TestMultiTSDBTenantLimitsOverrides(t *testing.T) {
+	dir := t.TempDir()
+	overridesPath := filepath.Join(dir, "overrides.yaml")
+	testutil.Ok(t, os.WriteFile(overridesPath, []byte("tenants: {foo: {max_head_series: 1}}\n"), os.ModePerm))
+
+	o := overrides.NewManager()
+	app := kingpin.New("test", "")
+	o.RegisterFlags(app)
+	_, err := app.Parse([]string{"--overrides.file=" + overridesPath, "--overrides.reload-interval=10ms"})
+	testutil.Ok(t, err)
+	testutil.Ok(t, o.Init(log.NewNopLogger(), prometheus.NewRegistry(), "receive"))
+
+	m := NewMultiTSDB(filepath.Join(dir, "tsdb"), log.NewNopLogger(), prometheus.NewRegistry(),
+		&tsdb.Options{
+			MinBlockDuration:  (2 * time.Hour).Milliseconds(),
+			MaxBlockDuration:  (2 * time.Hour).Milliseconds(),
+			RetentionDuration: (6 * time.Hour).Milliseconds(),
+		},
+		labels.FromStrings("replica", "test"),
+		"tenant_id",
+		nil,
+		false,
+		metadata.NoneFunc,
+		WithTenantHeadSeriesLimit(5),
+		WithOverrides(o),
+	)
+	defer func() { testutil.Ok(t, m.Close()) }()
+
+	now := time.Now()
+	testutil.Ok(t, appendSampleWithLabels(m, "foo", labels.FromStrings("series", "0"), now))
+	testutil.Equals(t, errTenantHeadSeriesLimit, appendSampleWithLabels(m, "foo", labels.FromStrings("series", "1"), now))
+	// Other tenants keep the limit given by flags.
+	for i := 0; i < 5; i++ {
+		testutil.Ok(t, appendSampleWithLabels(m, "bar", labels.FromStrings("series", fmt.Sprint(i)), now))
+	}
+	testutil.Equals(t, errTenantHeadSeriesLimit, appendSampleWithLabels(m, "bar", labels.FromStrings("series", "5"), now))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		testutil.Ok(t, o.Run(ctx))
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Reloaded overrides apply to the next appends.
+	testutil.Ok(t, os.WriteFile(overridesPath, []byte("tenants: {foo: {max_head_series: 2}}\n"), os.ModePerm))
+	retryCtx, retryCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer retryCancel()
+	testutil.Ok(t, runutil.Retry(10*time.Millisecond, retryCtx.Done(), func() error {
+		return appendSampleWithLabels(m, "foo", labels.FromStrings("series", "1"), now)
+	}))
+}
+
+func TestMultiTSDBIsolatesFailedTenants(t *testing.T) {
+	dir := t.TempDir()
+	testutil.Ok(t, os.MkdirAll(filepath.Join(dir, "good"), os.ModePerm))
+	testutil.Ok(t, os.MkdirAll(filepath.Join(dir, "bad"), os.ModePerm))
+	// A WAL that is not a directory cannot be opened.
+	testutil.Ok(t, os.WriteFile(filepath.Join(dir, "bad", "wal"), []byte("corrupted"), os.ModePerm))
+
+	m := NewMultiTSDB(dir, log.NewNopLogger(), prometheus.NewRegistry(),
+		&tsdb.Options{
+			MinBlockDuration:  (2 * time.Hour).Milliseconds(),
+			MaxBlockDuration:  (2 * time.Hour).Milliseconds(),
+			RetentionDuration: (6 * time.Hour).Milliseconds(),
+		},
+		labels.FromStrings("replica", "test"),
+		"tenant_id",
+		nil,
+		false,
+		metadata.NoneFunc,
+	)
+	defer func() { testutil.Ok(t, m.Close()) }()
+
+	testutil.Ok(t, m.Open())
+	testutil.Equals(t, 1, len(m.TSDBLocalClients()))
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(m.openFailures.WithLabelValues("bad")))
+
+	// The failed tenant is isolated, while the other tenants keep ingesting.
+	_, err := m.TenantAppendable("bad")
+	testutil.NotOk(t, err)
+	testutil.Ok(t, appendSample(m, "good", time.Now()))
+	testutil.Ok(t, appendSample(m, "new", time.Now()))
+}
+
+func TestMultiTSDBSnapshot(t *testing.T) {
+	bucket := objstore.NewInMemBucket()
+	newMultiTSDB := func(dir string) *MultiTSDB {
+		return NewMultiTSDB(dir, log.NewNopLogger(), prometheus.NewRegistry(),
+			&tsdb.Options{
+				MinBlockDuration:  (2 * time.Hour).Milliseconds(),
+				MaxBlockDuration:  (2 * time.Hour).Milliseconds(),
+				RetentionDuration: (6 * time.Hour).Milliseconds(),
+			},
+			labels.FromStrings("replica", "test"),
+			"tenant_id",
+			bucket,
+			false,
+			metadata.NoneFunc,
+		)
+	}
+	ctx := context.Background()
+
+	m := newMultiTSDB(t.TempDir())
+	defer func() { testutil.Ok(t, m.Close()) }()
+	for _, tenant := range []string{"foo", "bar"} {
+		testutil.Ok(t, appendSample(m, tenant, time.Now()))
+	}
+
+	_, err := m.Snapshot(ctx, "unknown", "baz")
+	testutil.NotOk(t, err)
+	tenants, err := m.Snapshot(ctx, "snap")
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"bar", "foo"}, tenants)
+
+	// The snapshot is restored into a new receiver, except for the tenants it already has.
+	dir := t.TempDir()
+	testutil.Ok(t, os.MkdirAll(filepath.Join(dir, "bar"), os.ModePerm))
+	restored := newMultiTSDB(dir)
+	defer func() { testutil.Ok(t, restored.Close()) }()
+
+	// The snapshotted receiver uploaded the data of the head of foo since the snapshot was taken.
+	shipped := metadata.Meta{
+		BlockMeta: tsdb.BlockMeta{ULID: ulid.MustNew(1, nil), MinTime: 0, MaxTime: time.Now().Add(time.Hour).UnixMilli(), Version: 1},
+		Thanos:    metadata.Thanos{Labels: map[string]string{"replica": "test", "tenant_id": "foo"}},
+	}
+	var buf bytes.Buffer
+	testutil.Ok(t, shipped.Write(&buf))
+	testutil.Ok(t, bucket.Upload(ctx, path.Join(shipped.ULID.String(), block.MetaFilename), &buf))
+
+	_, err = restored.RestoreSnapshot(ctx, "missing")
+	testutil.NotOk(t, err)
+	tenants, err = restored.RestoreSnapshot(ctx, "snap")
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"foo"}, tenants)
+
+	testutil.Ok(t, restored.Open())
+	testutil.Equals(t, 1, len(restored.tenants["foo"].readyStorage().Get().Blocks()))
+	// The restored head block is not uploaded again.
+	shipperMeta, err := shipper.ReadMetaFile(filepath.Join(dir, "foo"))
+	testutil.Ok(t, err)
+	testutil.Equals(t, []ulid.ULID{restored.tenants["foo"].readyStorage().Get().Blocks()[0].Meta().ULID}, shipperMeta.Uploaded)
+	testutil.Equals(t, 0, len(restored.tenants["bar"].readyStorage().Get().Blocks()))
+}
+
+type fakeSnapshotter struct {
+	tenants []string
+}
+
+func (f *fakeSnapshotter) Snapshot(_ context.Context, _ string, tenantIDs ...string) ([]string, error) {
+	f.tenants = tenantIDs
+	return tenantIDs, nil
+}
+
+func TestSnapshotAPI(t *testing.T) {
+	snapshotter := &fakeSnapshotter{}
+	a := NewSnapshotAPI(nil, snapshotter)
+
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, SnapshotAPIPath+"?tenant=foo&tenant=bar", nil))
+	testutil.Equals(t, http.StatusOK, rec.Code, "unexpected status, body: %s", rec.Body.String())
+	testutil.Equals(t, []string{"foo", "bar"}, snapshotter.tenants)
+
+	var resp struct {
+		Data snapshotResult `json:"data"`
+	}
+	testutil.Ok(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	testutil.Assert(t, resp.Data.Name != "", "expected the snapshot name to be returned")
+	testutil.Equals(t, []string{"foo", "bar"}, resp.Data.Tenants)
+
+	// Snapshots are only taken on POST requests.
+	snapshotter.tenants = nil
+	rec = httptest.NewRecorder()
+	a.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, SnapshotAPIPath, nil))
+	testutil.Equals(t, http.StatusMethodNotAllowed, rec.Code)
+	testutil.Equals(t, []string(nil), snapshotter.tenants)
+
+	// The admin API is not served on the remote write endpoint.
+	rec = httptest.NewRecorder()
+	NewHandler(nil, &Options{Tracer: opentracing.NoopTracer{}}).router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, SnapshotAPIPath, nil))
+	testutil.Equals(t, http.StatusNotFound, rec.Code)
+}
+
 func TestMultiTSDBStats(t *testing.T) {
 	tests := []struct {
 		name          string
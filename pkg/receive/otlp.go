@@ -0,0 +1,518 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+	"github.com/thanos-io/thanos/pkg/tracing"
+)
+
+const (
+	// OTLPMetricsPath is the path of the OTLP/HTTP metrics endpoint, so that OTLP exporters can be configured
+	// with the /api/v1/otlp base endpoint.
+	OTLPMetricsPath = "/api/v1/otlp/v1/metrics"
+
+	otlpContentTypeJSON     = "application/json"
+	otlpContentTypeProtobuf = "application/x-protobuf"
+
+	// otlpZeroThreshold is the zero threshold of native histograms converted from exponential histograms,
+	// as the OTLP version in use does not carry one.
+	otlpZeroThreshold = 1e-128
+	// Native histograms support schemas from -4 to 8. Exponential histograms with a higher scale are downscaled.
+	nativeHistogramMinSchema = -4
+	nativeHistogramMaxSchema = 8
+)
+
+// receiveOTLPHTTP handles OTLP/HTTP metrics export requests. Data points are translated to series which
+// go through the same limits, relabeling and forwarding as remote write requests.
+func (h *Handler) receiveOTLPHTTP(w http.ResponseWriter, r *http.Request) {
+	span, ctx := tracing.StartSpan(r.Context(), "receive_otlp_http")
+	defer span.Finish()
+
+	tenant, err := h.getTenant(r)
+	if err != nil {
+		// This must hard fail to ensure hard tenancy when feature is enabled.
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.isTenantValid(tenant); err != nil {
+		level.Error(h.logger).Log("msg", "tenant name not valid", "tenant", tenant)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (contentType != otlpContentTypeProtobuf && contentType != otlpContentTypeJSON) {
+		http.Error(w, "unsupported content type, expected "+otlpContentTypeProtobuf+" or "+otlpContentTypeJSON, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// Like remote write requests, requests of tenants above their concurrency limit are refused before waiting
+	// for the write gate.
+	requestLimiter := h.Limiter.RequestLimiter()
+	allowed, done := requestLimiter.AllowConcurrency(tenant)
+	if !allowed {
+		httpError(w, retryAfterError{error: errors.New("too many concurrent requests"), retryAfter: time.Second}, http.StatusTooManyRequests)
+		return
+	}
+	defer done()
+
+	writeGate := h.Limiter.WriteGate()
+	tracing.DoInSpan(r.Context(), "receive_write_gate_ismyturn", func(ctx context.Context) {
+		err = writeGate.Start(r.Context())
+	})
+	if errors.Is(err, errWriteQueueFull) {
+		httpError(w, err, http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		level.Error(h.logger).Log("err", err, "msg", "internal server error")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer writeGate.Done()
+
+	if r.ContentLength >= 0 && !requestLimiter.AllowSizeBytes(tenant, r.ContentLength) {
+		http.Error(w, "write request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	// Bodies are read up to one byte above the size limit, so that larger requests are refused without reading
+	// them fully, neither compressed nor decompressed.
+	sizeLimit := requestLimiter.SizeBytesLimit(tenant)
+	compressed, err := io.ReadAll(limitOTLPReader(r.Body, sizeLimit))
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "read request body").Error(), http.StatusInternalServerError)
+		return
+	}
+	if !requestLimiter.AllowSizeBytes(tenant, int64(len(compressed))) {
+		http.Error(w, "write request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	body, err := decodeOTLPBody(r.Header.Get("Content-Encoding"), compressed, sizeLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !requestLimiter.AllowSizeBytes(tenant, int64(len(body))) {
+		http.Error(w, "write request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var req colmetricspb.ExportMetricsServiceRequest
+	if contentType == otlpContentTypeJSON {
+		err = protojson.Unmarshal(body, &req)
+	} else {
+		err = proto.Unmarshal(body, &req)
+	}
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "decode OTLP metrics request").Error(), http.StatusBadRequest)
+		return
+	}
+
+	tenantAttribute := h.options.OTLPTenantAttribute
+	if h.authenticatedTenancy() {
+		// The tenant from the client certificate or bearer token can not be overridden to ensure hard tenancy.
+		tenantAttribute = ""
+	}
+	wreqs, dropped := otlpToWriteRequests(&req, tenantAttribute, tenant)
+	if dropped > 0 {
+		level.Debug(h.logger).Log("msg", "dropped OTLP data points which can not be translated", "numDropped", dropped)
+	}
+
+	tenants := make([]string, 0, len(wreqs))
+	for t := range wreqs {
+		tenants = append(tenants, t)
+	}
+	sort.Strings(tenants)
+
+	for _, t := range tenants {
+		if err := h.isTenantValid(t); err != nil {
+			level.Error(h.logger).Log("msg", "tenant name not valid", "tenant", t)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		tLogger := log.With(h.logger, "tenant", t)
+		if err := h.headSeriesLimitErr(tLogger, t); err != nil {
+			httpError(w, err, http.StatusTooManyRequests)
+			return
+		}
+		if responseStatusCode, err := h.writeRequest(ctx, tLogger, requestLimiter, t, 0, wreqs[t]); err != nil {
+			httpError(w, err, responseStatusCode)
+			return
+		}
+	}
+
+	var resp []byte
+	if contentType == otlpContentTypeJSON {
+		resp, err = protojson.Marshal(&colmetricspb.ExportMetricsServiceResponse{})
+	} else {
+		resp, err = proto.Marshal(&colmetricspb.ExportMetricsServiceResponse{})
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(resp)
+}
+
+// limitOTLPReader limits r to one byte more than the given size limit, if positive.
+func limitOTLPReader(r io.Reader, sizeLimit int64) io.Reader {
+	if sizeLimit <= 0 {
+		return r
+	}
+	return io.LimitReader(r, sizeLimit+1)
+}
+
+// decodeOTLPBody decompresses the body of an OTLP request with the given content encoding. At most one byte more
+// than the given size limit is decompressed, if positive.
+func decodeOTLPBody(contentEncoding string, body []byte, sizeLimit int64) ([]byte, error) {
+	switch contentEncoding {
+	case "":
+		return body, nil
+	case "gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, errors.Wrap(err, "gzip decode error")
+		}
+		defer gr.Close()
+
+		b, err := io.ReadAll(limitOTLPReader(gr, sizeLimit))
+		if err != nil {
+			return nil, errors.Wrap(err, "gzip decode error")
+		}
+		return b, nil
+	default:
+		return nil, errors.Errorf("unsupported content encoding %s", contentEncoding)
+	}
+}
+
+// otlpToWriteRequests translates the metrics of the OTLP request into remote write requests per tenant.
+// The tenant of each resource is read from its tenantAttribute resource attribute, if configured and present,
+// otherwise defaultTenant is used. It also returns the number of data points which could not be translated.
+func otlpToWriteRequests(req *colmetricspb.ExportMetricsServiceRequest, tenantAttribute, defaultTenant string) (map[string]*prompb.WriteRequest, int) {
+	var (
+		wreqs   = map[string]*prompb.WriteRequest{}
+		dropped int
+	)
+	for _, rm := range req.GetResourceMetrics() {
+		tenant := defaultTenant
+		resourceLabels := otlpLabels{}
+		var serviceName, serviceNamespace string
+		for _, attr := range rm.GetResource().GetAttributes() {
+			if tenantAttribute != "" && attr.GetKey() == tenantAttribute {
+				if v := otlpValueString(attr.GetValue()); v != "" {
+					tenant = v
+				}
+				continue
+			}
+			switch attr.GetKey() {
+			case "service.name":
+				serviceName = otlpValueString(attr.GetValue())
+			case "service.namespace":
+				serviceNamespace = otlpValueString(attr.GetValue())
+			case "service.instance.id":
+				resourceLabels.add("instance", otlpValueString(attr.GetValue()))
+			}
+		}
+		if serviceName != "" {
+			if serviceNamespace != "" {
+				serviceName = serviceNamespace + "/" + serviceName
+			}
+			resourceLabels.add("job", serviceName)
+		}
+
+		wreq, ok := wreqs[tenant]
+		if !ok {
+			wreq = &prompb.WriteRequest{}
+			wreqs[tenant] = wreq
+		}
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				series, d := otlpMetricToSeries(m, resourceLabels)
+				wreq.Timeseries = append(wreq.Timeseries, series...)
+				dropped += d
+			}
+		}
+	}
+
+	for tenant, wreq := range wreqs {
+		if len(wreq.Timeseries) == 0 {
+			delete(wreqs, tenant)
+		}
+	}
+	return wreqs, dropped
+}
+
+// otlpMetricToSeries translates the data points of an OTLP metric into series. It also returns the number
+// of data points which could not be translated, e.g. because of a delta temporality.
+func otlpMetricToSeries(m *metricspb.Metric, resourceLabels otlpLabels) ([]prompb.TimeSeries, int) {
+	name := sanitizeOTLPMetricName(m.GetName())
+	if name == "" {
+		return nil, 1
+	}
+
+	var (
+		series  []prompb.TimeSeries
+		dropped int
+	)
+	switch data := m.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		for _, dp := range data.Gauge.GetDataPoints() {
+			series = append(series, otlpSample(resourceLabels, dp.GetAttributes(), dp.GetTimeUnixNano(), otlpNumberValue(dp), dp.GetFlags(), name))
+		}
+	case *metricspb.Metric_Sum:
+		// Delta sums can not be represented as Prometheus series.
+		if data.Sum.GetAggregationTemporality() != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+			return nil, len(data.Sum.GetDataPoints())
+		}
+		for _, dp := range data.Sum.GetDataPoints() {
+			series = append(series, otlpSample(resourceLabels, dp.GetAttributes(), dp.GetTimeUnixNano(), otlpNumberValue(dp), dp.GetFlags(), name))
+		}
+	case *metricspb.Metric_Histogram:
+		if data.Histogram.GetAggregationTemporality() != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+			return nil, len(data.Histogram.GetDataPoints())
+		}
+		for _, dp := range data.Histogram.GetDataPoints() {
+			var cumulative uint64
+			for i, bound := range dp.GetExplicitBounds() {
+				if i < len(dp.GetBucketCounts()) {
+					cumulative += dp.GetBucketCounts()[i]
+				}
+				series = append(series, otlpSample(resourceLabels, dp.GetAttributes(), dp.GetTimeUnixNano(), float64(cumulative), dp.GetFlags(), name+"_bucket", labels.BucketLabel, strconv.FormatFloat(bound, 'f', -1, 64)))
+			}
+			series = append(series, otlpSample(resourceLabels, dp.GetAttributes(), dp.GetTimeUnixNano(), float64(dp.GetCount()), dp.GetFlags(), name+"_bucket", labels.BucketLabel, "+Inf"))
+			if dp.Sum != nil {
+				series = append(series, otlpSample(resourceLabels, dp.GetAttributes(), dp.GetTimeUnixNano(), dp.GetSum(), dp.GetFlags(), name+"_sum"))
+			}
+			series = append(series, otlpSample(resourceLabels, dp.GetAttributes(), dp.GetTimeUnixNano(), float64(dp.GetCount()), dp.GetFlags(), name+"_count"))
+		}
+	case *metricspb.Metric_ExponentialHistogram:
+		if data.ExponentialHistogram.GetAggregationTemporality() != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+			return nil, len(data.ExponentialHistogram.GetDataPoints())
+		}
+		for _, dp := range data.ExponentialHistogram.GetDataPoints() {
+			h, ok := otlpExponentialHistogram(dp)
+			if !ok {
+				dropped++
+				continue
+			}
+			series = append(series, prompb.TimeSeries{
+				Labels:     otlpSeriesLabels(resourceLabels, dp.GetAttributes(), name),
+				Histograms: []prompb.Histogram{h},
+			})
+		}
+	case *metricspb.Metric_Summary:
+		for _, dp := range data.Summary.GetDataPoints() {
+			for _, q := range dp.GetQuantileValues() {
+				series = append(series, otlpSample(resourceLabels, dp.GetAttributes(), dp.GetTimeUnixNano(), q.GetValue(), dp.GetFlags(), name, "quantile", strconv.FormatFloat(q.GetQuantile(), 'f', -1, 64)))
+			}
+			series = append(series, otlpSample(resourceLabels, dp.GetAttributes(), dp.GetTimeUnixNano(), dp.GetSum(), dp.GetFlags(), name+"_sum"))
+			series = append(series, otlpSample(resourceLabels, dp.GetAttributes(), dp.GetTimeUnixNano(), float64(dp.GetCount()), dp.GetFlags(), name+"_count"))
+		}
+	default:
+		return nil, 1
+	}
+	return series, dropped
+}
+
+func otlpNumberValue(dp *metricspb.NumberDataPoint) float64 {
+	if v, ok := dp.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+		return float64(v.AsInt)
+	}
+	return dp.GetAsDouble()
+}
+
+// otlpSample returns a series with a single sample. Data points without recorded value are translated to staleness markers.
+func otlpSample(resourceLabels otlpLabels, attrs []*commonpb.KeyValue, timeUnixNano uint64, v float64, flags uint32, name string, extra ...string) prompb.TimeSeries {
+	if flags&uint32(metricspb.DataPointFlags_FLAG_NO_RECORDED_VALUE) != 0 {
+		v = math.Float64frombits(value.StaleNaN)
+	}
+	return prompb.TimeSeries{
+		Labels:  otlpSeriesLabels(resourceLabels, attrs, name, extra...),
+		Samples: []prompb.Sample{{Value: v, Timestamp: otlpTimestamp(timeUnixNano)}},
+	}
+}
+
+// otlpExponentialHistogram translates an exponential histogram data point into a native histogram. It returns false if
+// the scale of the data point is too low to be represented as a native histogram.
+func otlpExponentialHistogram(dp *metricspb.ExponentialHistogramDataPoint) (prompb.Histogram, bool) {
+	scale := dp.GetScale()
+	if scale < nativeHistogramMinSchema {
+		return prompb.Histogram{}, false
+	}
+	var scaleDown int32
+	if scale > nativeHistogramMaxSchema {
+		scaleDown = scale - nativeHistogramMaxSchema
+		scale = nativeHistogramMaxSchema
+	}
+
+	h := prompb.Histogram{
+		Count:         &prompb.Histogram_CountInt{CountInt: dp.GetCount()},
+		Sum:           dp.GetSum(),
+		Schema:        scale,
+		ZeroThreshold: otlpZeroThreshold,
+		ZeroCount:     &prompb.Histogram_ZeroCountInt{ZeroCountInt: dp.GetZeroCount()},
+		Timestamp:     otlpTimestamp(dp.GetTimeUnixNano()),
+	}
+	h.PositiveSpans, h.PositiveDeltas = otlpBucketsToSpans(dp.GetPositive(), scaleDown)
+	h.NegativeSpans, h.NegativeDeltas = otlpBucketsToSpans(dp.GetNegative(), scaleDown)
+
+	if dp.GetFlags()&uint32(metricspb.DataPointFlags_FLAG_NO_RECORDED_VALUE) != 0 {
+		h.Sum = math.Float64frombits(value.StaleNaN)
+	}
+	return h, true
+}
+
+// otlpBucketsToSpans translates the dense buckets of an exponential histogram into a single span of delta encoded
+// native histogram buckets, merging buckets to reduce the scale by scaleDown.
+// Exponential histogram buckets are indexed by their lower boundary while native histogram buckets are indexed
+// by their upper boundary, so indexes differ by one.
+func otlpBucketsToSpans(b *metricspb.ExponentialHistogramDataPoint_Buckets, scaleDown int32) ([]*prompb.BucketSpan, []int64) {
+	if len(b.GetBucketCounts()) == 0 {
+		return nil, nil
+	}
+
+	var (
+		counts   []int64
+		firstIdx = (b.GetOffset() >> scaleDown) + 1
+		lastIdx  = firstIdx
+	)
+	for i, c := range b.GetBucketCounts() {
+		idx := ((b.GetOffset() + int32(i)) >> scaleDown) + 1
+		if len(counts) == 0 || idx != lastIdx {
+			counts = append(counts, 0)
+			lastIdx = idx
+		}
+		counts[len(counts)-1] += int64(c)
+	}
+
+	deltas := make([]int64, len(counts))
+	var prev int64
+	for i, c := range counts {
+		deltas[i] = c - prev
+		prev = c
+	}
+	return []*prompb.BucketSpan{{Offset: firstIdx, Length: uint32(len(counts))}}, deltas
+}
+
+func otlpTimestamp(timeUnixNano uint64) int64 {
+	return int64(timeUnixNano / 1e6)
+}
+
+// otlpSeriesLabels returns the sorted labels of a series made of the resource labels, the data point attributes,
+// the metric name and the given extra label name and value pairs.
+func otlpSeriesLabels(resourceLabels otlpLabels, attrs []*commonpb.KeyValue, name string, extra ...string) []labelpb.ZLabel {
+	lbls := otlpLabels{}
+	for _, attr := range attrs {
+		lbls.add(sanitizeOTLPLabelName(attr.GetKey()), otlpValueString(attr.GetValue()))
+	}
+	for n, v := range resourceLabels {
+		lbls[n] = v
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		lbls[extra[i]] = extra[i+1]
+	}
+	lbls[labels.MetricName] = name
+
+	res := make([]labelpb.ZLabel, 0, len(lbls))
+	for n, v := range lbls {
+		res = append(res, labelpb.ZLabel{Name: n, Value: v})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// otlpLabels holds labels by name.
+type otlpLabels map[string]string
+
+// add adds the label, joining the values of attributes whose names collide once sanitized.
+func (l otlpLabels) add(name, value string) {
+	if name == "" || value == "" {
+		return
+	}
+	if existing, ok := l[name]; ok {
+		value = existing + ";" + value
+	}
+	l[name] = value
+}
+
+func otlpValueString(v *commonpb.AnyValue) string {
+	switch v := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(v.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(v.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(v.DoubleValue, 'f', -1, 64)
+	case *commonpb.AnyValue_BytesValue:
+		return base64.StdEncoding.EncodeToString(v.BytesValue)
+	case *commonpb.AnyValue_ArrayValue:
+		b, _ := protojson.Marshal(v.ArrayValue)
+		return string(b)
+	case *commonpb.AnyValue_KvlistValue:
+		b, _ := protojson.Marshal(v.KvlistValue)
+		return string(b)
+	default:
+		return ""
+	}
+}
+
+// sanitizeOTLPMetricName replaces characters not allowed in metric names with underscores.
+func sanitizeOTLPMetricName(name string) string {
+	return sanitizeOTLPName(name, func(r rune) bool { return r == '_' || r == ':' || isAlphaNumeric(r) }, "_")
+}
+
+// sanitizeOTLPLabelName replaces characters not allowed in label names with underscores.
+func sanitizeOTLPLabelName(name string) string {
+	return sanitizeOTLPName(name, func(r rune) bool { return r == '_' || isAlphaNumeric(r) }, "key_")
+}
+
+func sanitizeOTLPName(name string, valid func(r rune) bool, digitPrefix string) string {
+	if name == "" {
+		return ""
+	}
+	name = strings.Map(func(r rune) rune {
+		if valid(r) {
+			return r
+		}
+		return '_'
+	}, name)
+	if name[0] >= '0' && name[0] <= '9' {
+		name = digitPrefix + name
+	}
+	return name
+}
+
+func isAlphaNumeric(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
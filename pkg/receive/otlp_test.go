@@ -0,0 +1,178 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+func otlpStringAttr(key, v string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}}
+}
+
+func TestOTLPToWriteRequests(t *testing.T) {
+	const ts = uint64(1_000_000_000)
+	sum := 10.5
+
+	resource := func(attrs ...*commonpb.KeyValue) *resourcepb.Resource {
+		return &resourcepb.Resource{Attributes: append([]*commonpb.KeyValue{
+			otlpStringAttr("service.name", "api"),
+			otlpStringAttr("service.namespace", "shop"),
+			otlpStringAttr("service.instance.id", "pod-1"),
+		}, attrs...)}
+	}
+	req := &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{
+			{
+				Resource: resource(),
+				ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{
+					{
+						Name: "memory.usage",
+						Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{
+							{Attributes: []*commonpb.KeyValue{otlpStringAttr("http.method", "GET")}, TimeUnixNano: ts, Value: &metricspb.NumberDataPoint_AsInt{AsInt: 3}},
+							{TimeUnixNano: ts, Flags: uint32(metricspb.DataPointFlags_FLAG_NO_RECORDED_VALUE)},
+						}}},
+					},
+					{
+						Name: "requests",
+						Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+							AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+							DataPoints:             []*metricspb.NumberDataPoint{{TimeUnixNano: ts, Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 1}}},
+						}},
+					},
+					{
+						Name: "latency",
+						Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+							AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+							DataPoints: []*metricspb.HistogramDataPoint{{
+								TimeUnixNano:   ts,
+								Count:          6,
+								Sum:            &sum,
+								ExplicitBounds: []float64{0.5, 1},
+								BucketCounts:   []uint64{1, 2, 3},
+							}},
+						}},
+					},
+				}}},
+			},
+			{
+				Resource: resource(otlpStringAttr("tenant", "team-a")),
+				ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{
+					{
+						Name: "rpc_duration",
+						Data: &metricspb.Metric_Summary{Summary: &metricspb.Summary{DataPoints: []*metricspb.SummaryDataPoint{{
+							TimeUnixNano:   ts,
+							Count:          4,
+							Sum:            2,
+							QuantileValues: []*metricspb.SummaryDataPoint_ValueAtQuantile{{Quantile: 0.5, Value: 0.3}},
+						}}}},
+					},
+				}}},
+			},
+		},
+	}
+
+	wreqs, dropped := otlpToWriteRequests(req, "tenant", "default-tenant")
+	testutil.Equals(t, 1, dropped)
+	testutil.Equals(t, 2, len(wreqs))
+
+	lbls := func(extra ...string) []labelpb.ZLabel {
+		return labelpb.ZLabelsFromPromLabels(labels.FromStrings(append([]string{"job", "shop/api", "instance", "pod-1"}, extra...)...))
+	}
+	sample := func(v float64) []prompb.Sample {
+		return []prompb.Sample{{Value: v, Timestamp: 1000}}
+	}
+
+	defaultSeries := wreqs["default-tenant"].Timeseries
+	testutil.Equals(t, 7, len(defaultSeries))
+	testutil.Equals(t, prompb.TimeSeries{Labels: lbls("__name__", "memory_usage", "http_method", "GET"), Samples: sample(3)}, defaultSeries[0])
+	testutil.Equals(t, lbls("__name__", "memory_usage"), defaultSeries[1].Labels)
+	testutil.Assert(t, value.IsStaleNaN(defaultSeries[1].Samples[0].Value), "expected staleness marker for data point without recorded value")
+	testutil.Equals(t, []prompb.TimeSeries{
+		{Labels: lbls("__name__", "latency_bucket", "le", "0.5"), Samples: sample(1)},
+		{Labels: lbls("__name__", "latency_bucket", "le", "1"), Samples: sample(3)},
+		{Labels: lbls("__name__", "latency_bucket", "le", "+Inf"), Samples: sample(6)},
+		{Labels: lbls("__name__", "latency_sum"), Samples: sample(10.5)},
+		{Labels: lbls("__name__", "latency_count"), Samples: sample(6)},
+	}, defaultSeries[2:])
+
+	testutil.Equals(t, []prompb.TimeSeries{
+		{Labels: lbls("__name__", "rpc_duration", "quantile", "0.5"), Samples: sample(0.3)},
+		{Labels: lbls("__name__", "rpc_duration_sum"), Samples: sample(2)},
+		{Labels: lbls("__name__", "rpc_duration_count"), Samples: sample(4)},
+	}, wreqs["team-a"].Timeseries)
+}
+
+func TestOTLPExponentialHistogram(t *testing.T) {
+	sum := 12.0
+	dp := &metricspb.ExponentialHistogramDataPoint{
+		TimeUnixNano: 2_000_000,
+		Count:        10,
+		Sum:          &sum,
+		Scale:        10,
+		ZeroCount:    1,
+		Positive:     &metricspb.ExponentialHistogramDataPoint_Buckets{Offset: -1, BucketCounts: []uint64{1, 2, 3, 1, 2}},
+	}
+
+	h, ok := otlpExponentialHistogram(dp)
+	testutil.Assert(t, ok, "expected exponential histogram to be converted")
+	// Scale 10 is reduced to schema 8 by merging 4 buckets into one, so OTLP buckets [-1, 3] end up in
+	// native histogram buckets [0, 1].
+	testutil.Equals(t, prompb.Histogram{
+		Count:          &prompb.Histogram_CountInt{CountInt: 10},
+		Sum:            12,
+		Schema:         8,
+		ZeroThreshold:  otlpZeroThreshold,
+		ZeroCount:      &prompb.Histogram_ZeroCountInt{ZeroCountInt: 1},
+		PositiveSpans:  []*prompb.BucketSpan{{Offset: 0, Length: 2}},
+		PositiveDeltas: []int64{1, 7},
+		Timestamp:      2,
+	}, h)
+
+	dp.Scale = -5
+	_, ok = otlpExponentialHistogram(dp)
+	testutil.Assert(t, !ok, "expected exponential histogram with too low scale to be dropped")
+}
+
+func TestSanitizeOTLPNames(t *testing.T) {
+	testutil.Equals(t, "http_server_duration", sanitizeOTLPMetricName("http.server.duration"))
+	testutil.Equals(t, "_2xx_responses", sanitizeOTLPMetricName("2xx_responses"))
+	testutil.Equals(t, "ns:metric", sanitizeOTLPMetricName("ns:metric"))
+	testutil.Equals(t, "k8s_pod_name", sanitizeOTLPLabelName("k8s.pod.name"))
+	testutil.Equals(t, "key_0_attr", sanitizeOTLPLabelName("0.attr"))
+	testutil.Equals(t, "", sanitizeOTLPLabelName(""))
+}
+
+func TestDecodeOTLPBody(t *testing.T) {
+	var compressed bytes.Buffer
+	gw := gzip.NewWriter(&compressed)
+	_, err := gw.Write(make([]byte, 1<<20))
+	testutil.Ok(t, err)
+	testutil.Ok(t, gw.Close())
+
+	// Bodies are not decompressed beyond one byte above the size limit.
+	b, err := decodeOTLPBody("gzip", compressed.Bytes(), 1024)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1025, len(b))
+
+	b, err = decodeOTLPBody("gzip", compressed.Bytes(), 0)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1<<20, len(b))
+
+	_, err = decodeOTLPBody("br", compressed.Bytes(), 0)
+	testutil.NotOk(t, err)
+}
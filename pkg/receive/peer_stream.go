@@ -0,0 +1,363 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+// ReplicationProtocol is the protocol used to forward and replicate write requests between receivers.
+type ReplicationProtocol string
+
+const (
+	// ReplicationProtocolUnary sends every forwarded write request as a separate RemoteWrite call.
+	ReplicationProtocolUnary ReplicationProtocol = "unary"
+	// ReplicationProtocolStream pipelines forwarded write requests over one long-lived RemoteWriteStream per peer.
+	ReplicationProtocolStream ReplicationProtocol = "stream"
+)
+
+var errStreamClosed = errors.New("replication stream closed")
+
+// streamWrite is a write request waiting in the queue of a peer stream or for its acknowledgement.
+type streamWrite struct {
+	ctx  context.Context
+	req  *storepb.WriteRequest
+	done chan error
+	// single is set once a batch the request was merged into failed, so that it is sent on its own.
+	single bool
+}
+
+// streamBatch is a write request merged from the queued write requests of the same tenant and replica.
+type streamBatch struct {
+	writes []*streamWrite
+	req    *storepb.WriteRequest
+}
+
+// timeout returns the time until the last writer of the batch stops waiting for it, 0 if one of them waits forever.
+func (b *streamBatch) timeout() time.Duration {
+	var last time.Time
+	for _, w := range b.writes {
+		deadline, ok := w.ctx.Deadline()
+		if !ok {
+			return 0
+		}
+		if deadline.After(last) {
+			last = deadline
+		}
+	}
+	if timeout := time.Until(last); timeout > time.Millisecond {
+		return timeout
+	}
+	return time.Millisecond
+}
+
+// peerStream forwards write requests to a single peer over a long-lived RemoteWriteStream.
+// Requests are queued and sent by a single goroutine, without waiting for previous requests to be
+// acknowledged by the peer. The requests queued while the previous ones are sent are merged per tenant and
+// replica into batches of up to batchSize requests. As the peer reports a single result per batch, the requests of
+// a failed batch are sent again on their own, so that writers only get the errors caused by their own series. The
+// number of queued and
+// unacknowledged requests is bounded by the queue size, once it is reached writers block until the peer catches up
+// or their context is done.
+type peerStream struct {
+	client    storepb.WriteableStoreClient
+	batchSize int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	queue  chan *streamWrite
+	slots  chan struct{}
+	wg     sync.WaitGroup
+
+	// Only accessed by the run goroutine.
+	conn   *streamConn
+	nextID uint64
+}
+
+func newPeerStream(client storepb.WriteableStoreClient, queueSize, batchSize int) *peerStream {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &peerStream{
+		client:    client,
+		batchSize: batchSize,
+		ctx:       ctx,
+		cancel:    cancel,
+		queue:     make(chan *streamWrite, queueSize),
+		slots:     make(chan struct{}, queueSize),
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.run()
+	}()
+	return s
+}
+
+// write queues the write request and waits until the peer acknowledged it. The returned error is
+// a gRPC status error, like the ones returned by RemoteWrite.
+func (s *peerStream) write(ctx context.Context, req *storepb.WriteRequest) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.ctx.Done():
+		return status.Error(codes.Unavailable, errStreamClosed.Error())
+	case s.slots <- struct{}{}:
+	}
+
+	w := &streamWrite{ctx: ctx, req: req, done: make(chan error, 1)}
+	// There is a free slot, so the queue has room for the request.
+	s.queue <- w
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.ctx.Done():
+		return status.Error(codes.Unavailable, errStreamClosed.Error())
+	case err := <-w.done:
+		return err
+	}
+}
+
+// close stops sending queued write requests and closes the stream to the peer.
+func (s *peerStream) close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *peerStream) run() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			if s.conn != nil {
+				s.conn.fail(status.Error(codes.Unavailable, errStreamClosed.Error()))
+			}
+			for {
+				select {
+				case w := <-s.queue:
+					s.finish(w, status.Error(codes.Unavailable, errStreamClosed.Error()))
+				default:
+					return
+				}
+			}
+		case w := <-s.queue:
+			for _, b := range mergeWrites(s.collect(w)) {
+				s.send(b)
+			}
+		}
+	}
+}
+
+// collect returns the write request w and the further queued ones, up to the batch size. Requests nobody is waiting
+// for anymore are finished instead.
+func (s *peerStream) collect(w *streamWrite) []*streamWrite {
+	writes := make([]*streamWrite, 0, 1)
+	for {
+		if err := w.ctx.Err(); err != nil {
+			s.finish(w, err)
+		} else {
+			writes = append(writes, w)
+		}
+		if len(writes) >= s.batchSize {
+			return writes
+		}
+		select {
+		case w = <-s.queue:
+		default:
+			return writes
+		}
+	}
+}
+
+// mergeWrites merges the write requests of the same tenant and replica into batches, in the order of their first
+// request. Requests to be sent on their own get their own batch.
+func mergeWrites(writes []*streamWrite) []*streamBatch {
+	type batchKey struct {
+		tenant  string
+		replica int64
+	}
+	var (
+		batches []*streamBatch
+		index   = map[batchKey]int{}
+	)
+	for _, w := range writes {
+		if w.single {
+			batches = append(batches, &streamBatch{writes: []*streamWrite{w}, req: w.req})
+			continue
+		}
+		key := batchKey{tenant: w.req.Tenant, replica: w.req.Replica}
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(batches)
+			batches = append(batches, &streamBatch{writes: []*streamWrite{w}, req: w.req})
+			continue
+		}
+		b := batches[i]
+		if len(b.writes) == 1 {
+			// Copy the first request, instead of appending to the time series of the writer.
+			b.req = &storepb.WriteRequest{Tenant: key.tenant, Replica: key.replica, Timeseries: append([]prompb.TimeSeries(nil), b.req.Timeseries...)}
+		}
+		b.writes = append(b.writes, w)
+		b.req.Timeseries = append(b.req.Timeseries, w.req.Timeseries...)
+	}
+	return batches
+}
+
+func (s *peerStream) send(b *streamBatch) {
+	if s.conn == nil || s.conn.broken() {
+		conn, err := s.open()
+		if err != nil {
+			for _, w := range b.writes {
+				s.finish(w, err)
+			}
+			return
+		}
+		s.conn = conn
+	}
+
+	s.nextID++
+	id := s.nextID
+	if !s.conn.track(id, b.writes) {
+		// The stream broke in the meantime, the requests have been failed with the stream error.
+		return
+	}
+	req := &storepb.WriteStreamRequest{Id: id, Request: *b.req, TimeoutMilliseconds: b.timeout().Milliseconds()}
+	if err := s.conn.stream.Send(req); err != nil {
+		if err == io.EOF {
+			// The actual error is returned on the receiving side of the stream.
+			return
+		}
+		s.conn.fail(err)
+	}
+}
+
+func (s *peerStream) open() (*streamConn, error) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	stream, err := s.client.RemoteWriteStream(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	c := &streamConn{
+		stream:  stream,
+		cancel:  cancel,
+		pending: map[uint64][]*streamWrite{},
+		release: s.release,
+		requeue: s.requeue,
+	}
+	go c.recv()
+	return c, nil
+}
+
+// finish reports the result of the write request to its writer and frees its slot.
+func (s *peerStream) finish(w *streamWrite, err error) {
+	w.done <- err
+	s.release()
+}
+
+func (s *peerStream) release() {
+	<-s.slots
+}
+
+// requeue queues the write request again to be sent on its own. Queued requests and the ones waiting for
+// acknowledgement hold a slot each, so the queue always has room for it.
+func (s *peerStream) requeue(w *streamWrite) {
+	w.single = true
+	s.queue <- w
+}
+
+// streamConn is a single RemoteWriteStream to a peer, tracking the batches of requests waiting for acknowledgement.
+type streamConn struct {
+	stream  storepb.WriteableStore_RemoteWriteStreamClient
+	cancel  context.CancelFunc
+	release func()
+	requeue func(w *streamWrite)
+
+	mtx     sync.Mutex
+	pending map[uint64][]*streamWrite
+	err     error
+}
+
+func (c *streamConn) broken() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.err != nil
+}
+
+// track registers the batch of requests as waiting for acknowledgement. If the stream is already broken,
+// the requests are failed with the stream error and false is returned.
+func (c *streamConn) track(id uint64, writes []*streamWrite) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.err != nil {
+		c.finish(writes, c.err)
+		return false
+	}
+	c.pending[id] = writes
+	return true
+}
+
+// finish reports the result of the requests to their writers and frees their slots.
+func (c *streamConn) finish(writes []*streamWrite, err error) {
+	for _, w := range writes {
+		w.done <- err
+		c.release()
+	}
+}
+
+func (c *streamConn) recv() {
+	for {
+		resp, err := c.stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				err = status.Error(codes.Unavailable, errStreamClosed.Error())
+			}
+			c.fail(err)
+			return
+		}
+
+		c.mtx.Lock()
+		writes, ok := c.pending[resp.Id]
+		delete(c.pending, resp.Id)
+		c.mtx.Unlock()
+		if !ok {
+			continue
+		}
+		switch {
+		case resp.Code == int32(codes.OK):
+			c.finish(writes, nil)
+		case len(writes) > 1:
+			// The error may be caused by the series of a single writer, or by series of different writers
+			// conflicting with each other.
+			for _, w := range writes {
+				c.requeue(w)
+			}
+		default:
+			c.finish(writes, status.Error(codes.Code(resp.Code), resp.Message))
+		}
+	}
+}
+
+// fail marks the stream as broken, closes it and fails all requests waiting for acknowledgement with err.
+func (c *streamConn) fail(err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	c.cancel()
+	for id, writes := range c.pending {
+		c.finish(writes, err)
+		delete(c.pending, id)
+	}
+}
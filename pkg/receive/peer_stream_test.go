@@ -0,0 +1,240 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+// fakeStreamWriteableStore acknowledges streamed write requests based on their tenant and the names of their series.
+type fakeStreamWriteableStore struct {
+	storepb.UnimplementedWriteableStoreServer
+
+	streams int32
+	blocked chan struct{}
+	block   chan struct{}
+
+	mtx      sync.Mutex
+	requests []*storepb.WriteStreamRequest
+}
+
+func (f *fakeStreamWriteableStore) received() []*storepb.WriteStreamRequest {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.requests
+}
+
+func (f *fakeStreamWriteableStore) RemoteWriteStream(srv storepb.WriteableStore_RemoteWriteStreamServer) error {
+	atomic.AddInt32(&f.streams, 1)
+	for {
+		r, err := srv.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		f.mtx.Lock()
+		f.requests = append(f.requests, r)
+		f.mtx.Unlock()
+
+		resp := &storepb.WriteStreamResponse{Id: r.Id}
+		for _, ts := range r.Request.Timeseries {
+			if len(ts.Labels) > 0 && ts.Labels[0].Value == "conflict" {
+				resp.Code = int32(codes.AlreadyExists)
+				resp.Message = "conflict"
+			}
+		}
+		switch r.Request.Tenant {
+		case "conflict":
+			resp.Code = int32(codes.AlreadyExists)
+			resp.Message = "conflict"
+		case "break":
+			return status.Error(codes.Unavailable, "broken stream")
+		case "block":
+			f.blocked <- struct{}{}
+			<-f.block
+		}
+		if err := srv.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func TestPeerStream(t *testing.T) {
+	t.Run("acknowledgements", func(t *testing.T) {
+		srv := &fakeStreamWriteableStore{}
+		s := newPeerStream(&fakeRemoteWriteGRPCServer{h: srv}, 10, 1)
+		defer s.close()
+
+		ctx := context.Background()
+		testutil.Ok(t, s.write(ctx, &storepb.WriteRequest{Tenant: "ok"}))
+		testutil.Equals(t, int64(0), srv.received()[0].TimeoutMilliseconds)
+
+		// The deadline of the writer is sent along with the request.
+		timeoutCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		testutil.Ok(t, s.write(timeoutCtx, &storepb.WriteRequest{Tenant: "ok"}))
+		timeout := srv.received()[1].TimeoutMilliseconds
+		testutil.Assert(t, timeout > 0 && timeout <= time.Minute.Milliseconds(), "unexpected timeout %d", timeout)
+
+		err := s.write(ctx, &storepb.WriteRequest{Tenant: "conflict"})
+		testutil.NotOk(t, err)
+		testutil.Equals(t, codes.AlreadyExists, status.Code(err))
+
+		err = s.write(ctx, &storepb.WriteRequest{Tenant: "break"})
+		testutil.NotOk(t, err)
+		testutil.Equals(t, codes.Unavailable, status.Code(err))
+
+		// The broken stream is replaced by a new one.
+		testutil.Ok(t, s.write(ctx, &storepb.WriteRequest{Tenant: "ok"}))
+		testutil.Equals(t, int32(2), atomic.LoadInt32(&srv.streams))
+	})
+
+	t.Run("backpressure", func(t *testing.T) {
+		srv := &fakeStreamWriteableStore{blocked: make(chan struct{}), block: make(chan struct{})}
+		s := newPeerStream(&fakeRemoteWriteGRPCServer{h: srv}, 1, 1)
+
+		blocked := make(chan error)
+		go func() {
+			blocked <- s.write(context.Background(), &storepb.WriteRequest{Tenant: "block"})
+		}()
+		<-srv.blocked
+
+		// The queue is full until the first request is acknowledged.
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		testutil.Equals(t, context.DeadlineExceeded, s.write(ctx, &storepb.WriteRequest{Tenant: "ok"}))
+
+		close(srv.block)
+		testutil.Ok(t, <-blocked)
+		testutil.Ok(t, s.write(context.Background(), &storepb.WriteRequest{Tenant: "ok"}))
+
+		s.close()
+		err := s.write(context.Background(), &storepb.WriteRequest{Tenant: "ok"})
+		testutil.Equals(t, codes.Unavailable, status.Code(err))
+	})
+	t.Run("batching", func(t *testing.T) {
+		s := &peerStream{batchSize: 3, queue: make(chan *streamWrite, 10), slots: make(chan struct{}, 10)}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		canceled, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		var writes []*streamWrite
+		for i, w := range []struct {
+			ctx    context.Context
+			tenant string
+		}{{ctx, "a"}, {canceled, "a"}, {ctx, "b"}, {context.Background(), "a"}, {ctx, "a"}} {
+			writes = append(writes, &streamWrite{
+				ctx:  w.ctx,
+				req:  &storepb.WriteRequest{Tenant: w.tenant, Timeseries: []prompb.TimeSeries{{Samples: []prompb.Sample{{Value: float64(i)}}}}},
+				done: make(chan error, 1),
+			})
+			s.slots <- struct{}{}
+		}
+		for _, w := range writes[1:] {
+			s.queue <- w
+		}
+
+		// Requests nobody waits for anymore are dropped, the others are merged per tenant and replica up to the batch size.
+		batches := mergeWrites(s.collect(writes[0]))
+		testutil.Equals(t, context.Canceled, <-writes[1].done)
+		testutil.Equals(t, 2, len(batches))
+		testutil.Equals(t, []*streamWrite{writes[0], writes[3]}, batches[0].writes)
+		testutil.Equals(t, []prompb.TimeSeries{writes[0].req.Timeseries[0], writes[3].req.Timeseries[0]}, batches[0].req.Timeseries)
+		testutil.Equals(t, []*streamWrite{writes[2]}, batches[1].writes)
+		testutil.Equals(t, writes[2].req, batches[1].req)
+		// The requests of the writers are not modified.
+		testutil.Equals(t, 1, len(writes[0].req.Timeseries))
+
+		// The batch is sent with the time until the last writer stops waiting, unless one of them waits forever.
+		testutil.Equals(t, time.Duration(0), batches[0].timeout())
+		testutil.Assert(t, batches[1].timeout() > 59*time.Second && batches[1].timeout() <= time.Minute)
+		testutil.Equals(t, 1, len(s.queue))
+	})
+}
+
+func TestPeerStream_FailedBatch(t *testing.T) {
+	srv := &fakeStreamWriteableStore{}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &peerStream{
+		client:    &fakeRemoteWriteGRPCServer{h: srv},
+		batchSize: 10,
+		ctx:       ctx,
+		cancel:    cancel,
+		queue:     make(chan *streamWrite, 10),
+		slots:     make(chan struct{}, 10),
+	}
+
+	// Queue the requests before the stream runs, so that they are merged into a single batch.
+	var writes []*streamWrite
+	for _, name := range []string{"ok", "conflict", "ok"} {
+		w := &streamWrite{
+			ctx:  context.Background(),
+			req:  &storepb.WriteRequest{Tenant: "a", Timeseries: []prompb.TimeSeries{{Labels: []labelpb.ZLabel{{Name: "__name__", Value: name}}}}},
+			done: make(chan error, 1),
+		}
+		s.slots <- struct{}{}
+		s.queue <- w
+		writes = append(writes, w)
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.run()
+	}()
+	defer s.close()
+
+	// Only the writer whose series failed gets the error of the batch.
+	testutil.Ok(t, <-writes[0].done)
+	err := <-writes[1].done
+	testutil.Equals(t, codes.AlreadyExists, status.Code(err))
+	testutil.Ok(t, <-writes[2].done)
+
+	received := srv.received()
+	testutil.Equals(t, 4, len(received))
+	testutil.Equals(t, 3, len(received[0].Request.Timeseries))
+	for _, r := range received[1:] {
+		testutil.Equals(t, 1, len(r.Request.Timeseries))
+	}
+}
+
+func TestPeerGroup_CloseRemovedStreams(t *testing.T) {
+	p := newPeerGroup(ReplicationProtocolStream, 10, 10)
+	defer p.close()
+
+	ctx := context.Background()
+	clients := map[string]*fakeRemoteWriteGRPCServer{}
+	for _, addr := range []string{"a", "b"} {
+		clients[addr] = &fakeRemoteWriteGRPCServer{h: &fakeStreamWriteableStore{}}
+		testutil.Ok(t, p.write(ctx, addr, clients[addr], &storepb.WriteRequest{Tenant: "ok"}))
+	}
+	stream := p.streams["b"]
+
+	// The stream to the peer removed from the hashring is closed, the one to the remaining peer is kept.
+	p.closeRemovedStreams(SingleNodeHashring("a").Nodes())
+	testutil.Equals(t, []string{"a"}, func() []string {
+		var addrs []string
+		for addr := range p.streams {
+			addrs = append(addrs, addr)
+		}
+		return addrs
+	}())
+	testutil.Equals(t, codes.Unavailable, status.Code(stream.write(ctx, &storepb.WriteRequest{Tenant: "ok"})))
+	testutil.Ok(t, p.write(ctx, "a", clients["a"], &storepb.WriteRequest{Tenant: "ok"}))
+}
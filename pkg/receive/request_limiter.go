@@ -4,14 +4,21 @@
 package receive
 
 import (
+	"sync"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
 )
 
 const (
-	seriesLimitName    = "series"
-	samplesLimitName   = "samples"
-	sizeBytesLimitName = "body_size"
+	seriesLimitName           = "series"
+	samplesLimitName          = "samples"
+	sizeBytesLimitName        = "body_size"
+	samplesPerSecondLimitName = "samples_per_second"
+	samplesPerSecondBurstName = "samples_per_second_burst"
+	concurrencyLimitName      = "concurrency"
 )
 
 var unlimitedRequestLimitsConfig = NewEmptyRequestLimitsConfig().
@@ -23,8 +30,24 @@ var unlimitedRequestLimitsConfig = NewEmptyRequestLimitsConfig().
 type configRequestLimiter struct {
 	tenantLimits        map[string]*requestLimitsConfig
 	cachedDefaultLimits *requestLimitsConfig
-	limitsHit           *prometheus.SummaryVec
-	configuredLimits    *prometheus.GaugeVec
+
+	tenantSamplesPerSecondLimits map[string]uint64
+	defaultSamplesPerSecondLimit uint64
+	tenantSamplesPerSecondBursts map[string]uint64
+	defaultSamplesPerSecondBurst uint64
+	// samplesRateLimiters holds the samples rate limiter of each tenant, created on first use.
+	samplesRateLimitersMtx sync.Mutex
+	samplesRateLimiters    map[string]*rate.Limiter
+
+	tenantConcurrencyLimits map[string]uint64
+	defaultConcurrencyLimit uint64
+	// inFlightRequests holds the number of requests of each tenant being processed.
+	inFlightRequestsMtx sync.Mutex
+	inFlightRequests    map[string]uint64
+
+	limitsHit        *prometheus.SummaryVec
+	rejectedRequests *prometheus.CounterVec
+	configuredLimits *prometheus.GaugeVec
 }
 
 func newConfigRequestLimiter(reg prometheus.Registerer, writeLimits *WriteLimitsConfig) *configRequestLimiter {
@@ -45,9 +68,37 @@ func newConfigRequestLimiter(reg prometheus.Registerer, writeLimits *WriteLimits
 		}
 	}
 
+	// Tenants without a samples rate limit inherit the default one.
+	tenantSamplesPerSecondLimits := make(map[string]uint64)
+	tenantSamplesPerSecondBursts := make(map[string]uint64)
+	for tenant, limitConfig := range tenantsLimits {
+		if limitConfig.SamplesPerSecondLimit != nil {
+			tenantSamplesPerSecondLimits[tenant] = *limitConfig.SamplesPerSecondLimit
+		}
+		if limitConfig.SamplesPerSecondBurst != nil {
+			tenantSamplesPerSecondBursts[tenant] = *limitConfig.SamplesPerSecondBurst
+		}
+	}
+
+	// Tenants without a concurrency limit inherit the default one.
+	tenantConcurrencyLimits := make(map[string]uint64)
+	for tenant, limitConfig := range tenantsLimits {
+		if limitConfig.MaxConcurrency != nil {
+			tenantConcurrencyLimits[tenant] = *limitConfig.MaxConcurrency
+		}
+	}
+
 	limiter := configRequestLimiter{
-		tenantLimits:        tenantRequestLimits,
-		cachedDefaultLimits: defaultRequestLimits,
+		tenantLimits:                 tenantRequestLimits,
+		cachedDefaultLimits:          defaultRequestLimits,
+		tenantSamplesPerSecondLimits: tenantSamplesPerSecondLimits,
+		defaultSamplesPerSecondLimit: writeLimits.DefaultLimits.SamplesPerSecondLimit,
+		tenantSamplesPerSecondBursts: tenantSamplesPerSecondBursts,
+		defaultSamplesPerSecondBurst: writeLimits.DefaultLimits.SamplesPerSecondBurst,
+		samplesRateLimiters:          make(map[string]*rate.Limiter),
+		tenantConcurrencyLimits:      tenantConcurrencyLimits,
+		defaultConcurrencyLimit:      writeLimits.DefaultLimits.MaxConcurrency,
+		inFlightRequests:             make(map[string]uint64),
 	}
 	limiter.registerMetrics(reg)
 	return &limiter
@@ -63,6 +114,14 @@ func (l *configRequestLimiter) registerMetrics(reg prometheus.Registerer) {
 			Objectives: map[float64]float64{0.50: 0.1, 0.95: 0.1, 0.99: 0.001},
 		}, []string{"tenant", "limit"},
 	)
+	l.rejectedRequests = promauto.With(reg).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "thanos",
+			Subsystem: "receive",
+			Name:      "write_limits_rejected_requests_total",
+			Help:      "The total number of remote write requests refused due to write limits.",
+		}, []string{"tenant", "limit"},
+	)
 	l.configuredLimits = promauto.With(reg).NewGaugeVec(
 		prometheus.GaugeOpts{
 			Namespace: "thanos",
@@ -79,6 +138,23 @@ func (l *configRequestLimiter) registerMetrics(reg prometheus.Registerer) {
 	l.configuredLimits.WithLabelValues("", sizeBytesLimitName).Set(float64(*l.cachedDefaultLimits.SizeBytesLimit))
 	l.configuredLimits.WithLabelValues("", seriesLimitName).Set(float64(*l.cachedDefaultLimits.SeriesLimit))
 	l.configuredLimits.WithLabelValues("", samplesLimitName).Set(float64(*l.cachedDefaultLimits.SamplesLimit))
+	for tenant, limit := range l.tenantSamplesPerSecondLimits {
+		l.configuredLimits.WithLabelValues(tenant, samplesPerSecondLimitName).Set(float64(limit))
+	}
+	l.configuredLimits.WithLabelValues("", samplesPerSecondLimitName).Set(float64(l.defaultSamplesPerSecondLimit))
+	for tenant, burst := range l.tenantSamplesPerSecondBursts {
+		l.configuredLimits.WithLabelValues(tenant, samplesPerSecondBurstName).Set(float64(burst))
+	}
+	l.configuredLimits.WithLabelValues("", samplesPerSecondBurstName).Set(float64(l.defaultSamplesPerSecondBurst))
+	for tenant, limit := range l.tenantConcurrencyLimits {
+		l.configuredLimits.WithLabelValues(tenant, concurrencyLimitName).Set(float64(limit))
+	}
+	l.configuredLimits.WithLabelValues("", concurrencyLimitName).Set(float64(l.defaultConcurrencyLimit))
+}
+
+// SizeBytesLimit returns the size limit of requests of the tenant in bytes. Non-positive values mean no limit.
+func (l *configRequestLimiter) SizeBytesLimit(tenant string) int64 {
+	return *l.limitsFor(tenant).SizeBytesLimit
 }
 
 func (l *configRequestLimiter) AllowSizeBytes(tenant string, contentLengthBytes int64) bool {
@@ -92,6 +168,7 @@ func (l *configRequestLimiter) AllowSizeBytes(tenant string, contentLengthBytes
 		l.limitsHit.
 			WithLabelValues(tenant, sizeBytesLimitName).
 			Observe(float64(contentLengthBytes - *limit))
+		l.rejectedRequests.WithLabelValues(tenant, sizeBytesLimitName).Inc()
 	}
 	return allowed
 }
@@ -107,6 +184,7 @@ func (l *configRequestLimiter) AllowSeries(tenant string, amount int64) bool {
 		l.limitsHit.
 			WithLabelValues(tenant, seriesLimitName).
 			Observe(float64(amount - *limit))
+		l.rejectedRequests.WithLabelValues(tenant, seriesLimitName).Inc()
 	}
 	return allowed
 }
@@ -121,10 +199,110 @@ func (l *configRequestLimiter) AllowSamples(tenant string, amount int64) bool {
 		l.limitsHit.
 			WithLabelValues(tenant, samplesLimitName).
 			Observe(float64(amount - *limit))
+		l.rejectedRequests.WithLabelValues(tenant, samplesLimitName).Inc()
 	}
 	return allowed
 }
 
+// AllowSamplesPerSecond returns true if ingesting the given amount of samples keeps the tenant within its
+// samples per second limit. The limiter allows bursts of up to the samples per second burst of the tenant, one second
+// worth of samples by default, so a single request with more samples than the burst is always refused. After being
+// idle, a tenant can send up to the burst plus the limit in the first second.
+func (l *configRequestLimiter) AllowSamplesPerSecond(tenant string, amount int64) bool {
+	limiter := l.samplesRateLimiterFor(tenant)
+	if limiter == nil {
+		return true
+	}
+
+	allowed := limiter.AllowN(time.Now(), int(amount))
+	if !allowed && l.limitsHit != nil {
+		l.limitsHit.
+			WithLabelValues(tenant, samplesPerSecondLimitName).
+			Observe(float64(amount))
+		l.rejectedRequests.WithLabelValues(tenant, samplesPerSecondLimitName).Inc()
+	}
+	return allowed
+}
+
+// SamplesPerSecondRetryAfter returns how long the tenant has to wait until a request with the given amount of samples
+// fits into its samples per second limit. It returns 0 if the tenant is not limited or the request never fits.
+func (l *configRequestLimiter) SamplesPerSecondRetryAfter(tenant string, amount int64) time.Duration {
+	limiter := l.samplesRateLimiterFor(tenant)
+	if limiter == nil {
+		return 0
+	}
+
+	now := time.Now()
+	r := limiter.ReserveN(now, int(amount))
+	if !r.OK() {
+		return 0
+	}
+	defer r.CancelAt(now)
+	return r.DelayFrom(now)
+}
+
+// AllowConcurrency returns true if the tenant has less requests being processed than its concurrency limit.
+// The request is then counted as being processed until the returned function is called.
+func (l *configRequestLimiter) AllowConcurrency(tenant string) (bool, func()) {
+	limit, ok := l.tenantConcurrencyLimits[tenant]
+	if !ok {
+		limit = l.defaultConcurrencyLimit
+	}
+	if limit == 0 {
+		return true, func() {}
+	}
+
+	l.inFlightRequestsMtx.Lock()
+	defer l.inFlightRequestsMtx.Unlock()
+
+	if l.inFlightRequests[tenant] >= limit {
+		if l.limitsHit != nil {
+			l.limitsHit.
+				WithLabelValues(tenant, concurrencyLimitName).
+				Observe(float64(l.inFlightRequests[tenant] + 1 - limit))
+			l.rejectedRequests.WithLabelValues(tenant, concurrencyLimitName).Inc()
+		}
+		return false, nil
+	}
+	l.inFlightRequests[tenant]++
+	return true, func() {
+		l.inFlightRequestsMtx.Lock()
+		defer l.inFlightRequestsMtx.Unlock()
+
+		if l.inFlightRequests[tenant]--; l.inFlightRequests[tenant] == 0 {
+			delete(l.inFlightRequests, tenant)
+		}
+	}
+}
+
+func (l *configRequestLimiter) samplesRateLimiterFor(tenant string) *rate.Limiter {
+	limit, ownLimit := l.tenantSamplesPerSecondLimits[tenant]
+	if !ownLimit {
+		limit = l.defaultSamplesPerSecondLimit
+	}
+	if limit == 0 {
+		return nil
+	}
+
+	l.samplesRateLimitersMtx.Lock()
+	defer l.samplesRateLimitersMtx.Unlock()
+
+	limiter, ok := l.samplesRateLimiters[tenant]
+	if !ok {
+		// Tenants with a limit of their own only inherit the default burst along with the default limit.
+		burst, ok := l.tenantSamplesPerSecondBursts[tenant]
+		if !ok && !ownLimit {
+			burst = l.defaultSamplesPerSecondBurst
+		}
+		if burst == 0 {
+			burst = limit
+		}
+		limiter = rate.NewLimiter(rate.Limit(limit), int(burst))
+		l.samplesRateLimiters[tenant] = limiter
+	}
+	return limiter
+}
+
 func (l *configRequestLimiter) limitsFor(tenant string) *requestLimitsConfig {
 	limits, ok := l.tenantLimits[tenant]
 	if !ok {
@@ -135,6 +313,10 @@ func (l *configRequestLimiter) limitsFor(tenant string) *requestLimitsConfig {
 
 type noopRequestLimiter struct{}
 
+func (l *noopRequestLimiter) SizeBytesLimit(tenant string) int64 {
+	return 0
+}
+
 func (l *noopRequestLimiter) AllowSizeBytes(tenant string, contentLengthBytes int64) bool {
 	return true
 }
@@ -146,3 +328,15 @@ func (l *noopRequestLimiter) AllowSeries(tenant string, amount int64) bool {
 func (l *noopRequestLimiter) AllowSamples(tenant string, amount int64) bool {
 	return true
 }
+
+func (l *noopRequestLimiter) AllowSamplesPerSecond(tenant string, amount int64) bool {
+	return true
+}
+
+func (l *noopRequestLimiter) SamplesPerSecondRetryAfter(tenant string, amount int64) time.Duration {
+	return 0
+}
+
+func (l *noopRequestLimiter) AllowConcurrency(tenant string) (bool, func()) {
+	return true, func() {}
+}
@@ -5,6 +5,7 @@ package receive
 
 import (
 	"testing"
+	"time"
 
 	"github.com/efficientgo/core/testutil"
 )
@@ -231,3 +232,113 @@ func TestRequestLimiter_AllowSamples(t *testing.T) {
 		})
 	}
 }
+
+func TestRequestLimiter_AllowSamplesPerSecond(t *testing.T) {
+	limits := WriteLimitsConfig{
+		DefaultLimits: DefaultLimitsConfig{
+			SamplesPerSecondLimit: 100,
+		},
+		TenantsLimits: TenantsWriteLimitsConfig{
+			"unlimited": NewEmptyWriteLimitConfig().SetSamplesPerSecondLimit(0),
+			"limited":   NewEmptyWriteLimitConfig().SetSamplesPerSecondLimit(10),
+		},
+	}
+	l := newConfigRequestLimiter(nil, &limits)
+
+	testutil.Equals(t, true, l.AllowSamplesPerSecond("unlimited", 100000))
+
+	testutil.Equals(t, true, l.AllowSamplesPerSecond("limited", 10))
+	testutil.Equals(t, false, l.AllowSamplesPerSecond("limited", 10))
+
+	// Tenants without a limit of their own are limited independently with the default limit.
+	testutil.Equals(t, true, l.AllowSamplesPerSecond("a", 60))
+	testutil.Equals(t, true, l.AllowSamplesPerSecond("b", 60))
+	testutil.Equals(t, false, l.AllowSamplesPerSecond("a", 60))
+	testutil.Equals(t, false, l.AllowSamplesPerSecond("b", 101))
+}
+
+func TestRequestLimiter_SamplesPerSecondRetryAfter(t *testing.T) {
+	limits := WriteLimitsConfig{
+		DefaultLimits: DefaultLimitsConfig{
+			SamplesPerSecondLimit: 10,
+		},
+	}
+	l := newConfigRequestLimiter(nil, &limits)
+
+	testutil.Equals(t, true, l.AllowSamplesPerSecond("tenant", 10))
+	retryAfter := l.SamplesPerSecondRetryAfter("tenant", 5)
+	testutil.Assert(t, retryAfter > 0 && retryAfter <= 500*time.Millisecond, "unexpected retry after %v", retryAfter)
+	// Computing the retry after does not consume the rate of the tenant.
+	testutil.Assert(t, l.SamplesPerSecondRetryAfter("tenant", 5) <= retryAfter, "expected the rate not to be consumed")
+
+	// Requests larger than the burst never fit.
+	testutil.Equals(t, time.Duration(0), l.SamplesPerSecondRetryAfter("tenant", 11))
+}
+
+func TestRequestLimiter_SamplesPerSecondBurst(t *testing.T) {
+	limits := WriteLimitsConfig{
+		DefaultLimits: DefaultLimitsConfig{
+			SamplesPerSecondLimit: 10,
+			SamplesPerSecondBurst: 5,
+		},
+		TenantsLimits: TenantsWriteLimitsConfig{
+			"default-burst": NewEmptyWriteLimitConfig().SetSamplesPerSecondBurst(0),
+			"large-burst":   NewEmptyWriteLimitConfig().SetSamplesPerSecondBurst(30),
+			"own-limit":     NewEmptyWriteLimitConfig().SetSamplesPerSecondLimit(20),
+		},
+	}
+	l := newConfigRequestLimiter(nil, &limits)
+
+	// Requests larger than the burst are refused even if the tenant was idle.
+	testutil.Equals(t, false, l.AllowSamplesPerSecond("tenant", 6))
+	testutil.Equals(t, true, l.AllowSamplesPerSecond("tenant", 5))
+	testutil.Equals(t, false, l.AllowSamplesPerSecond("tenant", 5))
+
+	// A burst of 0 allows one second worth of samples.
+	testutil.Equals(t, false, l.AllowSamplesPerSecond("default-burst", 11))
+	testutil.Equals(t, true, l.AllowSamplesPerSecond("default-burst", 10))
+	testutil.Equals(t, false, l.AllowSamplesPerSecond("default-burst", 1))
+
+	// The burst can exceed one second worth of samples.
+	testutil.Equals(t, true, l.AllowSamplesPerSecond("large-burst", 30))
+	testutil.Equals(t, false, l.AllowSamplesPerSecond("large-burst", 10))
+
+	// Tenants with a limit of their own do not inherit the default burst.
+	testutil.Equals(t, true, l.AllowSamplesPerSecond("own-limit", 20))
+}
+
+func TestRequestLimiter_AllowConcurrency(t *testing.T) {
+	limits := WriteLimitsConfig{
+		DefaultLimits: DefaultLimitsConfig{
+			MaxConcurrency: 2,
+		},
+		TenantsLimits: TenantsWriteLimitsConfig{
+			"unlimited": NewEmptyWriteLimitConfig().SetMaxConcurrency(0),
+			"limited":   NewEmptyWriteLimitConfig().SetMaxConcurrency(1),
+		},
+	}
+	l := newConfigRequestLimiter(nil, &limits)
+
+	for i := 0; i < 10; i++ {
+		allowed, _ := l.AllowConcurrency("unlimited")
+		testutil.Equals(t, true, allowed)
+	}
+
+	allowed, done := l.AllowConcurrency("limited")
+	testutil.Equals(t, true, allowed)
+	allowed, _ = l.AllowConcurrency("limited")
+	testutil.Equals(t, false, allowed)
+	done()
+	allowed, _ = l.AllowConcurrency("limited")
+	testutil.Equals(t, true, allowed)
+
+	// Tenants without a limit of their own are limited independently with the default limit.
+	for _, tenant := range []string{"a", "b"} {
+		for i := 0; i < 2; i++ {
+			allowed, _ := l.AllowConcurrency(tenant)
+			testutil.Equals(t, true, allowed)
+		}
+	}
+	allowed, _ = l.AllowConcurrency("a")
+	testutil.Equals(t, false, allowed)
+}
@@ -0,0 +1,63 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/thanos-io/thanos/pkg/api"
+)
+
+// SnapshotAPIPath is the path of the admin HTTP endpoint snapshotting the TSDBs of tenants to object storage.
+const SnapshotAPIPath = "/api/v1/admin/tsdb/snapshot"
+
+// snapshotResult is the response of the snapshot admin API.
+type snapshotResult struct {
+	Name    string   `json:"name"`
+	Tenants []string `json:"tenants"`
+}
+
+// SnapshotAPI serves the admin API snapshotting the TSDBs of tenants to object storage. It is served on the HTTP
+// server, behind its authentication, not on the remote write endpoint.
+type SnapshotAPI struct {
+	logger      log.Logger
+	snapshotter TSDBSnapshotter
+}
+
+// NewSnapshotAPI returns the snapshot admin API of the given TSDBSnapshotter.
+func NewSnapshotAPI(logger log.Logger, snapshotter TSDBSnapshotter) *SnapshotAPI {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return &SnapshotAPI{logger: logger, snapshotter: snapshotter}
+}
+
+// ServeHTTP snapshots the TSDBs of the tenants given by the tenant parameters, or of all tenants if there is none,
+// to object storage. The snapshot can be restored into the data directory of a new receiver on start.
+func (a *SnapshotAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		api.RespondError(w, &api.ApiError{Typ: api.ErrorBadData, Err: err}, nil)
+		return
+	}
+
+	name := fmt.Sprintf("%s-%016x", time.Now().UTC().Format("20060102T150405Z0700"), rand.Int63())
+	tenants, err := a.snapshotter.Snapshot(r.Context(), name, r.Form["tenant"]...)
+	if err != nil {
+		api.RespondError(w, &api.ApiError{Typ: api.ErrorInternal, Err: err}, nil)
+		return
+	}
+	level.Info(a.logger).Log("msg", "created TSDB snapshot", "snapshot", name, "tenants", strings.Join(tenants, ","))
+	api.Respond(w, snapshotResult{Name: name, Tenants: tenants}, nil)
+}
@@ -0,0 +1,71 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/extjwt"
+)
+
+// TenantJWTConfig configures how the tenant is derived from a claim of the JWT bearer token of write requests.
+type TenantJWTConfig struct {
+	// Claim is the name of the claim holding the tenant.
+	Claim string `yaml:"claim"`
+	// Issuer is the issuer tokens must have been issued by, if set.
+	Issuer string `yaml:"issuer"`
+	// Audience is the audience tokens must have been issued for, if set.
+	Audience string `yaml:"audience"`
+	// PublicKeysFile is the path to a file with the PEM encoded public keys tokens can be signed with.
+	PublicKeysFile string `yaml:"public_keys_file"`
+}
+
+// TenantJWTValidator validates the JWT bearer tokens of write requests and extracts their tenant.
+type TenantJWTValidator struct {
+	claim     string
+	validator *extjwt.Validator
+}
+
+// NewTenantJWTValidator parses the given YAML TenantJWTConfig and loads its public keys.
+func NewTenantJWTValidator(content []byte) (*TenantJWTValidator, error) {
+	var conf TenantJWTConfig
+	if err := yaml.UnmarshalStrict(content, &conf); err != nil {
+		return nil, errors.Wrap(err, "parsing tenant JWT config YAML")
+	}
+	if conf.Claim == "" {
+		return nil, errors.New("tenant JWT claim must be set")
+	}
+
+	keys, err := extjwt.ReadPEMKeys(conf.PublicKeysFile)
+	if err != nil {
+		return nil, err
+	}
+	return &TenantJWTValidator{
+		claim:     conf.Claim,
+		validator: extjwt.NewValidator(keys, conf.Issuer, conf.Audience),
+	}, nil
+}
+
+// tenant returns the value of the tenant claim of the bearer token of the given request,
+// once the token is validated against the public keys, expiration, issuer and audience.
+func (v *TenantJWTValidator) tenant(r *http.Request) (string, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", errors.New("could not get bearer token from request")
+	}
+	claims, err := v.validator.Validate(strings.TrimPrefix(auth, "Bearer "))
+	if err != nil {
+		return "", err
+	}
+
+	tenant, _ := claims[v.claim].(string)
+	if tenant == "" {
+		return "", errors.Errorf("could not get %s claim from bearer token", v.claim)
+	}
+	return tenant, nil
+}
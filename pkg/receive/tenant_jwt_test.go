@@ -0,0 +1,124 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestTenantJWTValidator(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testutil.Ok(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testutil.Ok(t, err)
+
+	pubKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	testutil.Ok(t, err)
+	keysFile := filepath.Join(t.TempDir(), "keys.pem")
+	testutil.Ok(t, os.WriteFile(keysFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubKey}), 0o600))
+
+	_, err = NewTenantJWTValidator([]byte(fmt.Sprintf("public_keys_file: %s\n", keysFile)))
+	testutil.NotOk(t, err)
+
+	v, err := NewTenantJWTValidator([]byte(fmt.Sprintf(`claim: org
+issuer: https://idp.example.com
+audience: thanos
+public_keys_file: %s
+`, keysFile)))
+	testutil.Ok(t, err)
+
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"org": "team-a",
+			"iss": "https://idp.example.com",
+			"aud": "thanos",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+	}
+	sign := func(method jwt.SigningMethod, claims jwt.MapClaims, key interface{}) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		testutil.Ok(t, err)
+		return token
+	}
+	tenant := func(token string) (string, error) {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/receive", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		return v.tenant(r)
+	}
+
+	got, err := tenant(sign(jwt.SigningMethodES256, validClaims(), key))
+	testutil.Ok(t, err)
+	testutil.Equals(t, "team-a", got)
+
+	_, err = tenant("")
+	testutil.NotOk(t, err)
+
+	_, err = tenant(sign(jwt.SigningMethodES256, validClaims(), otherKey))
+	testutil.NotOk(t, err)
+
+	// A token signed with the public key as HMAC secret must not be accepted.
+	_, err = tenant(sign(jwt.SigningMethodHS256, validClaims(), pubKey))
+	testutil.NotOk(t, err)
+
+	for _, tcase := range []struct {
+		name   string
+		update func(jwt.MapClaims)
+	}{
+		{name: "expired", update: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{name: "no expiration", update: func(c jwt.MapClaims) { delete(c, "exp") }},
+		{name: "unexpected issuer", update: func(c jwt.MapClaims) { c["iss"] = "https://other.example.com" }},
+		{name: "unexpected audience", update: func(c jwt.MapClaims) { c["aud"] = "other" }},
+		{name: "missing claim", update: func(c jwt.MapClaims) { delete(c, "org") }},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			claims := validClaims()
+			tcase.update(claims)
+			_, err := tenant(sign(jwt.SigningMethodES256, claims, key))
+			testutil.NotOk(t, err)
+		})
+	}
+}
+
+func TestGetTenantFromIdentity(t *testing.T) {
+	h := NewHandler(nil, &Options{
+		TenantHeader:  DefaultTenantHeader,
+		TenantField:   CertificateFieldDNSName,
+		TenantMapping: map[string]string{"prometheus.team-a.svc": "team-a"},
+	})
+	request := func(dnsName string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/receive", nil)
+		r.Header.Set(DefaultTenantHeader, "team-b")
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{DNSNames: []string{dnsName}}}}
+		return r
+	}
+
+	// The tenant header is ignored.
+	tenant, err := h.getTenant(request("prometheus.team-a.svc"))
+	testutil.Ok(t, err)
+	testutil.Equals(t, "team-a", tenant)
+
+	_, err = h.getTenant(request("prometheus.team-b.svc"))
+	testutil.NotOk(t, err)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/receive", nil)
+	_, err = h.getTenant(r)
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,166 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+
+	"github.com/thanos-io/thanos/pkg/overrides"
+)
+
+var (
+	// errTenantHeadSeriesLimit is returned when appending to a new series while the head of the tenant
+	// holds the maximum number of series.
+	errTenantHeadSeriesLimit = errors.New("tenant head series limit reached")
+	// errTenantWALSizeLimit is returned when appending to a new series while the WAL of the tenant
+	// exceeds its maximum size.
+	errTenantWALSizeLimit = errors.New("tenant WAL size limit reached")
+)
+
+// tenantOpenRetryInterval is the minimum interval between two attempts to open the TSDB of a tenant that failed to open.
+const tenantOpenRetryInterval = time.Minute
+
+// walSizeRefreshInterval is the interval at which the size of the WAL of a limited tenant is measured.
+// It is a variable, so that tests can shorten it.
+var walSizeRefreshInterval = 15 * time.Second
+
+// tenantLimits are the resource limits of the TSDB of a tenant.
+type tenantLimits struct {
+	// maxHeadSeries is the maximum number of series in the head, unless overridden by overrides. 0 means no limit.
+	maxHeadSeries uint64
+	// maxWALSize is the maximum size (in bytes) of the WAL, unless overridden by overrides. 0 means no limit.
+	maxWALSize int64
+
+	tenantID  string
+	overrides *overrides.Manager
+
+	headSeriesExceeded prometheus.Counter
+	walSizeExceeded    prometheus.Counter
+
+	mtx            sync.Mutex
+	walSize        int64
+	walSizeUpdated time.Time
+}
+
+// appender returns an appender of the given TSDB rejecting samples of new series once a limit is reached.
+// Samples of series already in the head are always accepted, so that the head keeps being compacted and
+// the WAL truncated.
+func (l *tenantLimits) appender(ctx context.Context, db *tsdb.DB) (storage.Appender, error) {
+	maxHeadSeries, maxWALSize := l.current()
+	if maxHeadSeries == 0 && maxWALSize == 0 {
+		return db.Appender(ctx), nil
+	}
+
+	var walFull bool
+	if maxWALSize > 0 {
+		size, err := l.walSizeOf(db)
+		if err != nil {
+			return nil, errors.Wrap(err, "get WAL size")
+		}
+		walFull = size > maxWALSize
+	}
+
+	app := db.Appender(ctx)
+	return &limitedAppender{
+		Appender:      app,
+		getRef:        app.(storage.GetRef),
+		head:          db.Head(),
+		limits:        l,
+		maxHeadSeries: maxHeadSeries,
+		walFull:       walFull,
+	}, nil
+}
+
+// current returns the current maximum number of series in the head and maximum size of the WAL, given by the
+// overrides if they set them.
+func (l *tenantLimits) current() (maxHeadSeries uint64, maxWALSize int64) {
+	maxHeadSeries, maxWALSize = l.maxHeadSeries, l.maxWALSize
+	if l.overrides == nil {
+		return maxHeadSeries, maxWALSize
+	}
+	o := l.overrides.Limits(l.tenantID)
+	if o.MaxHeadSeries != nil {
+		maxHeadSeries = *o.MaxHeadSeries
+	}
+	if o.MaxWALSize != nil {
+		maxWALSize = int64(*o.MaxWALSize)
+	}
+	return maxHeadSeries, maxWALSize
+}
+
+// walSizeOf returns the size of the WAL of the given TSDB, measured at most every walSizeRefreshInterval.
+func (l *tenantLimits) walSizeOf(db *tsdb.DB) (int64, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if time.Since(l.walSizeUpdated) < walSizeRefreshInterval {
+		return l.walSize, nil
+	}
+	size, err := fileutil.DirSize(filepath.Join(db.Dir(), "wal"))
+	if err != nil {
+		return 0, err
+	}
+	l.walSize, l.walSizeUpdated = size, time.Now()
+	return size, nil
+}
+
+// limitedAppender is a storage.Appender enforcing the resource limits of a tenant on new series.
+type limitedAppender struct {
+	storage.Appender
+	getRef storage.GetRef
+
+	head          *tsdb.Head
+	limits        *tenantLimits
+	maxHeadSeries uint64
+	walFull       bool
+}
+
+func (a *limitedAppender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	if err := a.checkNewSeries(ref, l); err != nil {
+		return 0, err
+	}
+	return a.Appender.Append(ref, l, t, v)
+}
+
+func (a *limitedAppender) AppendHistogram(ref storage.SeriesRef, l labels.Labels, t int64, h *histogram.Histogram, fh *histogram.FloatHistogram) (storage.SeriesRef, error) {
+	if err := a.checkNewSeries(ref, l); err != nil {
+		return 0, err
+	}
+	return a.Appender.AppendHistogram(ref, l, t, h, fh)
+}
+
+// GetRef implements storage.GetRef.
+func (a *limitedAppender) GetRef(lset labels.Labels, hash uint64) (storage.SeriesRef, labels.Labels) {
+	return a.getRef.GetRef(lset, hash)
+}
+
+// checkNewSeries returns an error if the series is not in the head yet and one of the limits is reached.
+func (a *limitedAppender) checkNewSeries(ref storage.SeriesRef, l labels.Labels) error {
+	if ref != 0 {
+		return nil
+	}
+	if ref, _ := a.getRef.GetRef(l, l.Hash()); ref != 0 {
+		return nil
+	}
+	if a.maxHeadSeries > 0 && a.head.NumSeries() >= a.maxHeadSeries {
+		a.limits.headSeriesExceeded.Inc()
+		return errTenantHeadSeriesLimit
+	}
+	if a.walFull {
+		a.limits.walSizeExceeded.Inc()
+		return errTenantWALSizeLimit
+	}
+	return nil
+}
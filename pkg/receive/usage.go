@@ -0,0 +1,185 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+// UsageReportsDir is the directory of the bucket the tenant usage reports of receivers are written to.
+const UsageReportsDir = "usage"
+
+// usageFinalUploadTimeout is the timeout of the upload of the usage report when the receiver stops.
+const usageFinalUploadTimeout = time.Minute
+
+// TenantUsageCounts are the samples and bytes ingested for a tenant.
+type TenantUsageCounts struct {
+	// Samples is the number of samples and histogram samples.
+	Samples uint64 `json:"samples"`
+	// Bytes is the size of the series in the protobuf encoding of the remote write protocol.
+	Bytes uint64 `json:"bytes"`
+}
+
+// TenantUsageReport is the usage report of a receiver written to the bucket.
+type TenantUsageReport struct {
+	// Labels are the external labels of the receiver.
+	Labels map[string]string `json:"labels"`
+	// Updated is the time the report was written.
+	Updated time.Time `json:"updated"`
+	// Tenants are the counts of the tenants since the receiver first reported them.
+	Tenants map[string]TenantUsageCounts `json:"tenants"`
+}
+
+// TenantUsage accounts the samples and bytes ingested per tenant, e.g. to bill tenants. The counts are exported
+// as metrics and, if a bucket is given, written to it periodically and restored from it on start, so that they
+// survive restarts.
+type TenantUsage struct {
+	logger log.Logger
+	bkt    objstore.Bucket
+	lset   labels.Labels
+
+	mtx     sync.Mutex
+	tenants map[string]TenantUsageCounts
+
+	samples *prometheus.CounterVec
+	bytes   *prometheus.CounterVec
+}
+
+// NewTenantUsage creates a TenantUsage of the receiver with the given external labels. The bucket may be nil.
+func NewTenantUsage(logger log.Logger, reg prometheus.Registerer, bkt objstore.Bucket, lset labels.Labels) *TenantUsage {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return &TenantUsage{
+		logger:  logger,
+		bkt:     bkt,
+		lset:    lset,
+		tenants: map[string]TenantUsageCounts{},
+		samples: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_receive_tenant_ingested_samples_total",
+			Help: "The number of samples accepted per tenant. Restored from the bucket on start if usage reports are enabled.",
+		}, []string{"tenant"}),
+		bytes: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_receive_tenant_ingested_bytes_total",
+			Help: "The size of the series accepted per tenant, in the protobuf encoding of the remote write protocol. Restored from the bucket on start if usage reports are enabled.",
+		}, []string{"tenant"}),
+	}
+}
+
+// Add accounts the series of the tenant's write request. It is a no-op on a nil TenantUsage.
+func (u *TenantUsage) Add(tenant string, wreq *prompb.WriteRequest) {
+	if u == nil {
+		return
+	}
+	var samples uint64
+	for _, ts := range wreq.Timeseries {
+		samples += uint64(len(ts.Samples) + len(ts.Histograms))
+	}
+	u.add(tenant, TenantUsageCounts{Samples: samples, Bytes: uint64(wreq.Size())})
+}
+
+func (u *TenantUsage) add(tenant string, counts TenantUsageCounts) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	c := u.tenants[tenant]
+	c.Samples += counts.Samples
+	c.Bytes += counts.Bytes
+	u.tenants[tenant] = c
+
+	u.samples.WithLabelValues(tenant).Add(float64(counts.Samples))
+	u.bytes.WithLabelValues(tenant).Add(float64(counts.Bytes))
+}
+
+// Counts returns the counts of all tenants.
+func (u *TenantUsage) Counts() map[string]TenantUsageCounts {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	counts := make(map[string]TenantUsageCounts, len(u.tenants))
+	for tenant, c := range u.tenants {
+		counts[tenant] = c
+	}
+	return counts
+}
+
+// reportName returns the name of the usage report of the receiver in the bucket.
+func (u *TenantUsage) reportName() string {
+	return path.Join(UsageReportsDir, fmt.Sprintf("%016x.json", u.lset.Hash()))
+}
+
+// Restore adds the counts of the usage report of the receiver in the bucket, if any, to the counts.
+// It has to be called before accounting write requests.
+func (u *TenantUsage) Restore(ctx context.Context) error {
+	r, err := u.bkt.Get(ctx, u.reportName())
+	if u.bkt.IsObjNotFoundErr(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "get usage report")
+	}
+	defer runutil.CloseWithLogOnErr(u.logger, r, "usage report reader")
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "read usage report")
+	}
+	var report TenantUsageReport
+	if err := json.Unmarshal(b, &report); err != nil {
+		return errors.Wrap(err, "unmarshal usage report")
+	}
+	for tenant, counts := range report.Tenants {
+		u.add(tenant, counts)
+	}
+	level.Info(u.logger).Log("msg", "restored tenant usage", "tenants", len(report.Tenants), "updated", report.Updated)
+	return nil
+}
+
+// Upload writes the usage report of the receiver to the bucket.
+func (u *TenantUsage) Upload(ctx context.Context) error {
+	b, err := json.MarshalIndent(TenantUsageReport{
+		Labels:  u.lset.Map(),
+		Updated: time.Now().UTC(),
+		Tenants: u.Counts(),
+	}, "", "\t")
+	if err != nil {
+		return errors.Wrap(err, "marshal usage report")
+	}
+	return errors.Wrap(u.bkt.Upload(ctx, u.reportName(), bytes.NewReader(b)), "upload usage report")
+}
+
+// Run writes the usage report to the bucket at the given interval until the context is done, and once more then.
+func (u *TenantUsage) Run(ctx context.Context, interval time.Duration) error {
+	defer func() {
+		uctx, cancel := context.WithTimeout(context.Background(), usageFinalUploadTimeout)
+		defer cancel()
+		if err := u.Upload(uctx); err != nil {
+			level.Error(u.logger).Log("msg", "failed to upload usage report", "err", err)
+		}
+	}()
+	return runutil.Repeat(interval, ctx.Done(), func() error {
+		if err := u.Upload(ctx); err != nil {
+			level.Error(u.logger).Log("msg", "failed to upload usage report", "err", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,62 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+func TestTenantUsage(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	lset := labels.FromStrings("replica", "a")
+
+	wreq := &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+		Labels:  labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "up")),
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 10}, {Value: 1, Timestamp: 20}},
+	}}}
+	size := uint64(wreq.Size())
+
+	u := NewTenantUsage(nil, prometheus.NewRegistry(), bkt, lset)
+	testutil.Ok(t, u.Restore(ctx))
+	u.Add("team-a", wreq)
+	u.Add("team-a", wreq)
+	u.Add("team-b", wreq)
+	testutil.Equals(t, map[string]TenantUsageCounts{
+		"team-a": {Samples: 4, Bytes: 2 * size},
+		"team-b": {Samples: 2, Bytes: size},
+	}, u.Counts())
+	testutil.Equals(t, 4.0, promtestutil.ToFloat64(u.samples.WithLabelValues("team-a")))
+	testutil.Equals(t, float64(size), promtestutil.ToFloat64(u.bytes.WithLabelValues("team-b")))
+	testutil.Ok(t, u.Upload(ctx))
+
+	// A restarted receiver continues counting from the last report.
+	restarted := NewTenantUsage(nil, prometheus.NewRegistry(), bkt, lset)
+	testutil.Ok(t, restarted.Restore(ctx))
+	restarted.Add("team-b", wreq)
+	testutil.Equals(t, map[string]TenantUsageCounts{
+		"team-a": {Samples: 4, Bytes: 2 * size},
+		"team-b": {Samples: 4, Bytes: 2 * size},
+	}, restarted.Counts())
+	testutil.Equals(t, 4.0, promtestutil.ToFloat64(restarted.samples.WithLabelValues("team-a")))
+
+	// Receivers with other external labels have their own report.
+	other := NewTenantUsage(nil, prometheus.NewRegistry(), bkt, labels.FromStrings("replica", "b"))
+	testutil.Ok(t, other.Restore(ctx))
+	testutil.Equals(t, map[string]TenantUsageCounts{}, other.Counts())
+
+	// A nil TenantUsage accounts nothing.
+	var noop *TenantUsage
+	noop.Add("team-a", wreq)
+}
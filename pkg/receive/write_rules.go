@@ -0,0 +1,111 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+// seriesRejectReason is the validation rule a rejected series breaks.
+type seriesRejectReason string
+
+const (
+	rejectReasonMaxLabelNames       seriesRejectReason = "max_label_names"
+	rejectReasonMaxLabelValueLength seriesRejectReason = "max_label_value_length"
+	rejectReasonMetricName          seriesRejectReason = "metric_name_regex"
+)
+
+// TenantWriteConfig holds the relabeling configs and validation rules applied to the series written by tenants,
+// before they are forwarded and appended.
+type TenantWriteConfig struct {
+	// Default are the write rules of tenants without specific rules.
+	Default WriteRulesConfig `yaml:"default"`
+	// Tenants are the write rules per tenant. They replace the default rules as a whole.
+	Tenants map[string]*WriteRulesConfig `yaml:"tenants"`
+}
+
+// WriteRulesConfig holds the relabeling configs and validation rules of a tenant.
+type WriteRulesConfig struct {
+	// RelabelConfigs are applied after the relabeling configs shared by all tenants.
+	RelabelConfigs []*relabel.Config `yaml:"relabel_configs"`
+	// MaxLabelNames is the maximum number of labels of a series, including the metric name. 0 means no limit.
+	MaxLabelNames int `yaml:"max_label_names"`
+	// MaxLabelValueLength is the maximum length of a label value, in bytes. 0 means no limit.
+	MaxLabelValueLength int `yaml:"max_label_value_length"`
+	// MetricNameRegex is the anchored regular expression metric names have to match, if any.
+	MetricNameRegex *relabel.Regexp `yaml:"metric_name_regex"`
+}
+
+// ParseTenantWriteConfig parses the tenant write configuration.
+func ParseTenantWriteConfig(content []byte) (*TenantWriteConfig, error) {
+	var conf TenantWriteConfig
+	if err := yaml.UnmarshalStrict(content, &conf); err != nil {
+		return nil, errors.Wrap(err, "parsing tenant write config YAML")
+	}
+	return &conf, nil
+}
+
+// rules returns the write rules of the given tenant.
+func (c *TenantWriteConfig) rules(tenant string) *WriteRulesConfig {
+	if c == nil {
+		return nil
+	}
+	if r, ok := c.Tenants[tenant]; ok && r != nil {
+		return r
+	}
+	return &c.Default
+}
+
+// validate checks the labels of a series against the rules. If the series breaks one of them,
+// the reason and an error describing it are returned.
+func (r *WriteRulesConfig) validate(lset []labelpb.ZLabel) (seriesRejectReason, error) {
+	if r.MaxLabelNames > 0 && len(lset) > r.MaxLabelNames {
+		return rejectReasonMaxLabelNames, errors.Errorf("series %s has %d labels, exceeding the limit of %d", labelpb.ZLabelsToPromLabels(lset), len(lset), r.MaxLabelNames)
+	}
+	if r.MaxLabelValueLength > 0 {
+		for _, l := range lset {
+			if len(l.Value) > r.MaxLabelValueLength {
+				return rejectReasonMaxLabelValueLength, errors.Errorf("value of label %s of series %s is %d bytes long, exceeding the limit of %d", l.Name, labelpb.ZLabelsToPromLabels(lset), len(l.Value), r.MaxLabelValueLength)
+			}
+		}
+	}
+	if r.MetricNameRegex != nil {
+		name := labelpb.ZLabelsToPromLabels(lset).Get(labels.MetricName)
+		if !r.MetricNameRegex.MatchString(name) {
+			return rejectReasonMetricName, errors.Errorf("metric name %q does not match %s", name, r.MetricNameRegex.String())
+		}
+	}
+	return "", nil
+}
+
+// applyWriteRules drops the time series of the write request that break the write rules of the tenant.
+// It returns an error describing the first rejected series, if any.
+func (h *Handler) applyWriteRules(tenant string, wreq *prompb.WriteRequest) error {
+	rules := h.options.TenantWriteConfig.rules(tenant)
+	if rules == nil {
+		return nil
+	}
+
+	var firstErr error
+	timeSeries := wreq.Timeseries[:0]
+	for _, ts := range wreq.Timeseries {
+		reason, err := rules.validate(ts.Labels)
+		if err != nil {
+			h.seriesRejected.WithLabelValues(tenant, string(reason)).Inc()
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		timeSeries = append(timeSeries, ts)
+	}
+	wreq.Timeseries = timeSeries
+	return firstErr
+}
@@ -0,0 +1,95 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+func TestParseTenantWriteConfig(t *testing.T) {
+	_, err := ParseTenantWriteConfig([]byte(`default:
+  max_label_names: 2
+  unknown: 1
+`))
+	testutil.NotOk(t, err)
+
+	_, err = ParseTenantWriteConfig([]byte(`default:
+  metric_name_regex: "("
+`))
+	testutil.NotOk(t, err)
+
+	conf, err := ParseTenantWriteConfig([]byte(`default:
+  max_label_names: 3
+tenants:
+  team-a:
+    max_label_value_length: 5
+    metric_name_regex: "team_a_.*"
+    relabel_configs:
+    - action: labeldrop
+      regex: pod
+`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, conf.rules("other").MaxLabelNames)
+
+	rules := conf.rules("team-a")
+	testutil.Equals(t, 0, rules.MaxLabelNames)
+	testutil.Equals(t, 5, rules.MaxLabelValueLength)
+	testutil.Equals(t, 1, len(rules.RelabelConfigs))
+	testutil.Assert(t, rules.MetricNameRegex.MatchString("team_a_requests"), "expected metric name to match")
+	testutil.Assert(t, !rules.MetricNameRegex.MatchString("other_team_a_requests"), "expected metric name regex to be anchored")
+}
+
+func TestApplyWriteRules(t *testing.T) {
+	conf, err := ParseTenantWriteConfig([]byte(`default:
+  max_label_names: 2
+tenants:
+  team-a:
+    max_label_value_length: 10
+    metric_name_regex: "team_a_.*"
+    relabel_configs:
+    - action: labeldrop
+      regex: pod
+`))
+	testutil.Ok(t, err)
+
+	h := NewHandler(nil, &Options{Registry: prometheus.NewRegistry(), TenantWriteConfig: conf})
+	series := func(lset ...string) prompb.TimeSeries {
+		return prompb.TimeSeries{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings(lset...))}
+	}
+
+	wreq := &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{
+		series("__name__", "up", "job", "a"),
+		series("__name__", "up", "job", "a", "pod", "b"),
+	}}
+	h.relabel("default-tenant", wreq)
+	err = h.applyWriteRules("default-tenant", wreq)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, []prompb.TimeSeries{series("__name__", "up", "job", "a")}, wreq.Timeseries)
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(h.seriesRejected.WithLabelValues("default-tenant", string(rejectReasonMaxLabelNames))))
+
+	// The relabeling configs of the tenant are applied before the validation rules.
+	wreq = &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{
+		series("__name__", "team_a_up", "job", "a", "pod", "b"),
+		series("__name__", "team_a_up", "job", "too-long-value"),
+		series("__name__", "up", "job", "a"),
+	}}
+	h.relabel("team-a", wreq)
+	err = h.applyWriteRules("team-a", wreq)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, []prompb.TimeSeries{series("__name__", "team_a_up", "job", "a")}, wreq.Timeseries)
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(h.seriesRejected.WithLabelValues("team-a", string(rejectReasonMaxLabelValueLength))))
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(h.seriesRejected.WithLabelValues("team-a", string(rejectReasonMetricName))))
+
+	wreq = &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{series("__name__", "team_a_up")}}
+	testutil.Ok(t, h.applyWriteRules("team-a", wreq))
+	testutil.Equals(t, 1, len(wreq.Timeseries))
+}
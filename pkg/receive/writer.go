@@ -84,6 +84,11 @@ func (r *Writer) Write(ctx context.Context, tenantID string, wreq *prompb.WriteR
 		numSamplesOutOfBounds = 0
 		numSamplesTooOld      = 0
 
+		numHistogramsDisabled = 0
+
+		numSamplesHeadSeriesLimit = 0
+		numSamplesWALSizeLimit    = 0
+
 		numExemplarsOutOfOrder  = 0
 		numExemplarsDuplicate   = 0
 		numExemplarsLabelLength = 0
@@ -160,6 +165,12 @@ func (r *Writer) Write(ctx context.Context, tenantID string, wreq *prompb.WriteR
 			case storage.ErrTooOldSample:
 				numSamplesTooOld++
 				level.Debug(tLogger).Log("msg", "Sample is too old", "lset", lset, "value", s.Value, "timestamp", s.Timestamp)
+			case errTenantHeadSeriesLimit:
+				numSamplesHeadSeriesLimit++
+				level.Debug(tLogger).Log("msg", "Head series limit reached for new series", "lset", lset, "value", s.Value, "timestamp", s.Timestamp)
+			case errTenantWALSizeLimit:
+				numSamplesWALSizeLimit++
+				level.Debug(tLogger).Log("msg", "WAL size limit reached for new series", "lset", lset, "value", s.Value, "timestamp", s.Timestamp)
 			default:
 				if err != nil {
 					level.Debug(tLogger).Log("msg", "Error ingesting sample", "err", err)
@@ -183,6 +194,15 @@ func (r *Writer) Write(ctx context.Context, tenantID string, wreq *prompb.WriteR
 			case storage.ErrTooOldSample:
 				numSamplesTooOld++
 				level.Debug(tLogger).Log("msg", "Histogram is too old", "lset", lset, "timestamp", hp.Timestamp)
+			case storage.ErrNativeHistogramsDisabled:
+				numHistogramsDisabled++
+				level.Debug(tLogger).Log("msg", "Native histograms are disabled", "lset", lset, "timestamp", hp.Timestamp)
+			case errTenantHeadSeriesLimit:
+				numSamplesHeadSeriesLimit++
+				level.Debug(tLogger).Log("msg", "Head series limit reached for new series", "lset", lset, "timestamp", hp.Timestamp)
+			case errTenantWALSizeLimit:
+				numSamplesWALSizeLimit++
+				level.Debug(tLogger).Log("msg", "WAL size limit reached for new series", "lset", lset, "timestamp", hp.Timestamp)
 			default:
 				if err != nil {
 					level.Debug(tLogger).Log("msg", "Error ingesting histogram", "err", err)
@@ -252,6 +272,19 @@ func (r *Writer) Write(ctx context.Context, tenantID string, wreq *prompb.WriteR
 		level.Warn(tLogger).Log("msg", "Error on ingesting samples that are outside of the allowed out-of-order time window", "numDropped", numSamplesTooOld)
 		errs.Add(errors.Wrapf(storage.ErrTooOldSample, "add %d samples", numSamplesTooOld))
 	}
+	if numHistogramsDisabled > 0 {
+		level.Warn(tLogger).Log("msg", "Error on ingesting native histograms while they are disabled for the tenant", "numDropped", numHistogramsDisabled)
+		errs.Add(errors.Wrapf(storage.ErrNativeHistogramsDisabled, "add %d histograms", numHistogramsDisabled))
+	}
+
+	if numSamplesHeadSeriesLimit > 0 {
+		level.Warn(tLogger).Log("msg", "Error on ingesting samples of new series while the tenant head series limit is reached", "numDropped", numSamplesHeadSeriesLimit)
+		errs.Add(errors.Wrapf(errTenantHeadSeriesLimit, "add %d samples", numSamplesHeadSeriesLimit))
+	}
+	if numSamplesWALSizeLimit > 0 {
+		level.Warn(tLogger).Log("msg", "Error on ingesting samples of new series while the tenant WAL size limit is reached", "numDropped", numSamplesWALSizeLimit)
+		errs.Add(errors.Wrapf(errTenantWALSizeLimit, "add %d samples", numSamplesWALSizeLimit))
+	}
 
 	if numExemplarsOutOfOrder > 0 {
 		level.Warn(tLogger).Log("msg", "Error on ingesting out-of-order exemplars", "numDropped", numExemplarsOutOfOrder)
@@ -366,6 +366,116 @@ func TestWriter(t *testing.T) {
 	}
 }
 
+func TestWriterNativeHistogramsPerTenant(t *testing.T) {
+	dir := t.TempDir()
+	logger := log.NewNopLogger()
+
+	const histogramsTenant = "histograms"
+	m := NewMultiTSDB(dir, logger, prometheus.NewRegistry(), &tsdb.Options{
+		MinBlockDuration:  (2 * time.Hour).Milliseconds(),
+		MaxBlockDuration:  (2 * time.Hour).Milliseconds(),
+		RetentionDuration: (6 * time.Hour).Milliseconds(),
+		NoLockfile:        true,
+	},
+		labels.FromStrings("replica", "01"),
+		"tenant_id",
+		nil,
+		false,
+		metadata.NoneFunc,
+		WithTenantNativeHistograms(histogramsTenant),
+	)
+	t.Cleanup(func() { testutil.Ok(t, m.Close()) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	w := NewWriter(logger, m, &WriterOptions{})
+	req := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:     []labelpb.ZLabel{{Name: "a", Value: "1"}},
+				Histograms: []prompb.Histogram{histogramToHistogramProto(10, testHistogram())},
+			},
+		},
+	}
+
+	for tenant, expectedErr := range map[string]error{
+		histogramsTenant: nil,
+		DefaultTenant:    errors.Wrapf(storage.ErrNativeHistogramsDisabled, "add 1 histograms"),
+	} {
+		var err error
+		testutil.Ok(t, runutil.Retry(100*time.Millisecond, ctx.Done(), func() error {
+			err = w.Write(context.Background(), tenant, req)
+			if errors.Cause(err) == ErrNotReady {
+				return err
+			}
+			return nil
+		}))
+		if expectedErr == nil {
+			testutil.Ok(t, err)
+			continue
+		}
+		testutil.NotOk(t, err)
+		testutil.Equals(t, expectedErr.Error(), err.Error())
+	}
+}
+
+func TestWriterSetNativeHistograms(t *testing.T) {
+	dir := t.TempDir()
+	logger := log.NewNopLogger()
+
+	const histogramsTenant = "histograms"
+	m := NewMultiTSDB(dir, logger, prometheus.NewRegistry(), &tsdb.Options{
+		MinBlockDuration:  (2 * time.Hour).Milliseconds(),
+		MaxBlockDuration:  (2 * time.Hour).Milliseconds(),
+		RetentionDuration: (6 * time.Hour).Milliseconds(),
+		NoLockfile:        true,
+	},
+		labels.FromStrings("replica", "01"),
+		"tenant_id",
+		nil,
+		false,
+		metadata.NoneFunc,
+		WithTenantNativeHistograms(histogramsTenant),
+	)
+	t.Cleanup(func() { testutil.Ok(t, m.Close()) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	w := NewWriter(logger, m, &WriterOptions{})
+	write := func(tenant string, ts int64) error {
+		req := &prompb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{
+				{
+					Labels:     []labelpb.ZLabel{{Name: "a", Value: "1"}},
+					Histograms: []prompb.Histogram{histogramToHistogramProto(ts, testHistogram())},
+				},
+			},
+		}
+		var err error
+		testutil.Ok(t, runutil.Retry(100*time.Millisecond, ctx.Done(), func() error {
+			err = w.Write(context.Background(), tenant, req)
+			if errors.Cause(err) == ErrNotReady {
+				return err
+			}
+			return nil
+		}))
+		return err
+	}
+
+	testutil.NotOk(t, write(DefaultTenant, 10))
+	testutil.Ok(t, write(histogramsTenant, 10))
+
+	// Open TSDBs apply the change.
+	m.SetNativeHistograms(true)
+	testutil.Ok(t, write(DefaultTenant, 20))
+
+	m.SetNativeHistograms(false)
+	testutil.NotOk(t, write(DefaultTenant, 30))
+	testutil.Ok(t, write(histogramsTenant, 30))
+}
+
 func BenchmarkWriterTimeSeriesWithSingleLabel_10(b *testing.B)   { benchmarkWriter(b, 1, 10, false) }
 func BenchmarkWriterTimeSeriesWithSingleLabel_100(b *testing.B)  { benchmarkWriter(b, 1, 100, false) }
 func BenchmarkWriterTimeSeriesWithSingleLabel_1000(b *testing.B) { benchmarkWriter(b, 1, 1000, false) }
@@ -9,9 +9,10 @@
 //   - Watch on changes against certain file e.g (`cfgFile`).
 //   - Optionally, specify different output file for watched `cfgFile` (`cfgOutputFile`).
 //     This will also try decompress the `cfgFile` if needed and substitute ALL the envvars using Kubernetes substitution format: (`$(var)`)
-//   - Watch on changes against certain directories (`watchedDirs`).
+//     Optionally, the `cfgFile` is rendered as a Go template first (`cfgTemplate`), see below.
+//   - Watch on changes against certain directories (`watchedDirs`) and files (`watchedFiles`).
 //
-// Once any of those two changes, Prometheus on given `reloadURL` will be notified, causing Prometheus to reload configuration and rules.
+// Once any of those changes, Prometheus on given `reloadURL` will be notified, causing Prometheus to reload configuration and rules.
 //
 // This and below for reloader:
 //
@@ -50,6 +51,20 @@
 //	global:
 //	  external_labels:
 //	    replica: '$(HOSTNAME)'
+//
+// If `cfgTemplate` is set, the config file is rendered as a Go template before substituting environment variables,
+// with the following functions:
+//
+//   - `env "NAME"` returns the value of the environment variable, failing if it is not set.
+//   - `include "path"` returns the content of the file, relative to the directory of the config file.
+//   - `indent N text` indents every line of the text by N spaces.
+//
+// For example:
+//
+//	scrape_configs:
+//	{{ include "scrape_configs.yaml" | indent 2 }}
+//
+// Included files are not watched unless listed in `watchedFiles`, but they are checked on every watch interval.
 package reloader
 
 import (
@@ -66,6 +81,7 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -88,9 +104,11 @@ type Reloader struct {
 	httpClient    http.Client
 	cfgFile       string
 	cfgOutputFile string
+	cfgTemplate   bool
 	watchInterval time.Duration
 	retryInterval time.Duration
 	watchedDirs   []string
+	watchedFiles  []string
 	watcher       *watcher
 
 	lastCfgHash         []byte
@@ -116,8 +134,13 @@ type Options struct {
 	// will be substituted and the output written into the given path. Prometheus should then use
 	// cfgOutputFile as its config file path.
 	CfgOutputFile string
+	// CfgTemplate renders the config file as a Go template before substituting environment variables, if
+	// CfgOutputFile is not empty. See the package documentation for the available functions.
+	CfgTemplate bool
 	// WatchedDirs is a collection of paths for the reloader to watch over.
 	WatchedDirs []string
+	// WatchedFiles is a collection of files for the reloader to watch over, e.g. files included by the config template.
+	WatchedFiles []string
 	// DelayInterval controls how long the reloader will wait without receiving
 	// new file-system events before it applies the reload.
 	DelayInterval time.Duration
@@ -141,8 +164,10 @@ func New(logger log.Logger, reg prometheus.Registerer, o *Options) *Reloader {
 		reloadURL:     o.ReloadURL,
 		cfgFile:       o.CfgFile,
 		cfgOutputFile: o.CfgOutputFile,
+		cfgTemplate:   o.CfgTemplate,
 		watcher:       newWatcher(logger, reg, o.DelayInterval),
 		watchedDirs:   o.WatchedDirs,
+		watchedFiles:  o.WatchedFiles,
 		watchInterval: o.WatchInterval,
 		retryInterval: o.RetryInterval,
 
@@ -195,7 +220,7 @@ func New(logger log.Logger, reg prometheus.Registerer, o *Options) *Reloader {
 // Because some edge cases might be missing, the reloader also relies on the
 // watch interval.
 func (r *Reloader) Watch(ctx context.Context) error {
-	if r.cfgFile == "" && len(r.watchedDirs) == 0 {
+	if r.cfgFile == "" && len(r.watchedDirs) == 0 && len(r.watchedFiles) == 0 {
 		level.Info(r.logger).Log("msg", "nothing to be watched")
 		<-ctx.Done()
 		return nil
@@ -223,6 +248,11 @@ func (r *Reloader) Watch(ctx context.Context) error {
 			return errors.Wrapf(err, "add directory %s to watcher", dir)
 		}
 	}
+	for _, fn := range r.watchedFiles {
+		if err := r.watcher.addFile(fn); err != nil {
+			return errors.Wrapf(err, "add file %s to watcher", fn)
+		}
+	}
 
 	// Start watching the file-system.
 	var wg sync.WaitGroup
@@ -236,7 +266,8 @@ func (r *Reloader) Watch(ctx context.Context) error {
 		"msg", "started watching config file and directories for changes",
 		"cfg", r.cfgFile,
 		"out", r.cfgOutputFile,
-		"dirs", strings.Join(r.watchedDirs, ","))
+		"dirs", strings.Join(r.watchedDirs, ","),
+		"files", strings.Join(r.watchedFiles, ","))
 
 	applyCtx, applyCancel := context.WithTimeout(ctx, r.watchInterval)
 
@@ -277,7 +308,6 @@ func (r *Reloader) apply(ctx context.Context) error {
 		if err := hashFile(h, r.cfgFile); err != nil {
 			return errors.Wrap(err, "hash file")
 		}
-		cfgHash = h.Sum(nil)
 		if r.cfgOutputFile != "" {
 			b, err := os.ReadFile(r.cfgFile)
 			if err != nil {
@@ -298,6 +328,17 @@ func (r *Reloader) apply(ctx context.Context) error {
 				}
 			}
 
+			if r.cfgTemplate {
+				b, err = renderTemplate(r.cfgFile, b)
+				if err != nil {
+					return errors.Wrap(err, "render template")
+				}
+				// Included files might have changed.
+				if _, err := h.Write(b); err != nil {
+					return errors.Wrap(err, "hash rendered template")
+				}
+			}
+
 			b, err = expandEnv(b)
 			if err != nil {
 				return errors.Wrap(err, "expand environment variables")
@@ -314,6 +355,7 @@ func (r *Reloader) apply(ctx context.Context) error {
 				return errors.Wrap(err, "rename file")
 			}
 		}
+		cfgHash = h.Sum(nil)
 	}
 
 	h := sha256.New()
@@ -348,7 +390,12 @@ func (r *Reloader) apply(ctx context.Context) error {
 			return errors.Wrap(err, "build hash")
 		}
 	}
-	if len(r.watchedDirs) > 0 {
+	for _, fn := range r.watchedFiles {
+		if err := hashFile(h, fn); err != nil {
+			return errors.Wrap(err, "build hash")
+		}
+	}
+	if len(r.watchedDirs) > 0 || len(r.watchedFiles) > 0 {
 		watchedDirsHash = h.Sum(nil)
 	}
 
@@ -375,7 +422,8 @@ func (r *Reloader) apply(ctx context.Context) error {
 			"msg", "Reload triggered",
 			"cfg_in", r.cfgFile,
 			"cfg_out", r.cfgOutputFile,
-			"watched_dirs", strings.Join(r.watchedDirs, ", "))
+			"watched_dirs", strings.Join(r.watchedDirs, ", "),
+			"watched_files", strings.Join(r.watchedFiles, ", "))
 		r.lastReloadSuccess.Set(1)
 		r.lastReloadSuccessTimestamp.SetToCurrentTime()
 		return nil
@@ -460,6 +508,38 @@ func expandEnv(b []byte) (r []byte, err error) {
 	return r, err
 }
 
+// renderTemplate renders the given config file content as a Go template.
+func renderTemplate(cfgFile string, b []byte) ([]byte, error) {
+	tmpl, err := template.New(filepath.Base(cfgFile)).Option("missingkey=error").Funcs(template.FuncMap{
+		"env": func(name string) (string, error) {
+			v, ok := os.LookupEnv(name)
+			if !ok {
+				return "", errors.Errorf("found reference to unset environment variable %q", name)
+			}
+			return v, nil
+		},
+		"include": func(fn string) (string, error) {
+			if !filepath.IsAbs(fn) {
+				fn = filepath.Join(filepath.Dir(cfgFile), fn)
+			}
+			b, err := os.ReadFile(filepath.Clean(fn))
+			return string(b), err
+		},
+		"indent": func(n int, text string) string {
+			pad := strings.Repeat(" ", n)
+			return pad + strings.ReplaceAll(strings.TrimSuffix(text, "\n"), "\n", "\n"+pad)
+		},
+	}).Parse(string(b))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 type watcher struct {
 	notify chan struct{}
 
@@ -697,3 +697,83 @@ config:
 	// Check no reload request made
 	testutil.Equals(t, 0, reloads.Load().(int))
 }
+
+func TestReloader_ConfigTemplateApply(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	reloads := atomic.NewInt64(0)
+	srv := &http.Server{Handler: http.HandlerFunc(func(resp http.ResponseWriter, r *http.Request) {
+		reloads.Inc()
+		resp.WriteHeader(http.StatusOK)
+	})}
+	l, err := net.Listen("tcp", "localhost:0")
+	testutil.Ok(t, err)
+	go func() { _ = srv.Serve(l) }()
+	defer func() { testutil.Ok(t, srv.Close()) }()
+
+	reloadURL, err := url.Parse(fmt.Sprintf("http://%s", l.Addr().String()))
+	testutil.Ok(t, err)
+
+	dir := t.TempDir()
+	var (
+		input    = filepath.Join(dir, "cfg.yaml.tmpl")
+		included = filepath.Join(dir, "scrape_configs.yaml")
+		watched  = filepath.Join(dir, "watched.yaml")
+		output   = filepath.Join(dir, "cfg.yaml")
+	)
+	t.Setenv("TEST_RELOADER_REPLICA", "replica-1")
+	testutil.Ok(t, os.WriteFile(input, []byte(`global:
+  external_labels:
+    replica: '{{ env "TEST_RELOADER_REPLICA" }}'
+    cluster: '$(TEST_RELOADER_REPLICA)'
+scrape_configs:
+{{ include "scrape_configs.yaml" | indent 2 }}
+`), os.ModePerm))
+	testutil.Ok(t, os.WriteFile(included, []byte("- job_name: a\n- job_name: b\n"), os.ModePerm))
+	testutil.Ok(t, os.WriteFile(watched, []byte("a"), os.ModePerm))
+
+	reloader := New(nil, nil, &Options{
+		ReloadURL:     reloadURL,
+		CfgFile:       input,
+		CfgOutputFile: output,
+		CfgTemplate:   true,
+		WatchedFiles:  []string{watched},
+		WatchInterval: time.Hour,
+		RetryInterval: 100 * time.Millisecond,
+	})
+
+	testutil.Ok(t, reloader.apply(ctx))
+	testutil.Equals(t, int64(1), reloads.Load())
+	b, err := os.ReadFile(output)
+	testutil.Ok(t, err)
+	testutil.Equals(t, `global:
+  external_labels:
+    replica: 'replica-1'
+    cluster: 'replica-1'
+scrape_configs:
+  - job_name: a
+  - job_name: b
+`, string(b))
+
+	// Nothing changed.
+	testutil.Ok(t, reloader.apply(ctx))
+	testutil.Equals(t, int64(1), reloads.Load())
+
+	// Changes of included and watched files trigger a reload.
+	testutil.Ok(t, os.WriteFile(included, []byte("- job_name: c\n"), os.ModePerm))
+	testutil.Ok(t, reloader.apply(ctx))
+	testutil.Equals(t, int64(2), reloads.Load())
+	b, err = os.ReadFile(output)
+	testutil.Ok(t, err)
+	testutil.Assert(t, strings.HasSuffix(string(b), "scrape_configs:\n  - job_name: c\n"), string(b))
+
+	testutil.Ok(t, os.WriteFile(watched, []byte("b"), os.ModePerm))
+	testutil.Ok(t, reloader.apply(ctx))
+	testutil.Equals(t, int64(3), reloads.Load())
+
+	// Invalid templates are not applied.
+	testutil.Ok(t, os.WriteFile(input, []byte(`{{ env "TEST_RELOADER_UNSET" }}`), os.ModePerm))
+	testutil.NotOk(t, reloader.apply(ctx))
+	testutil.Equals(t, int64(3), reloads.Load())
+}
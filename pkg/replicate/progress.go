@@ -0,0 +1,127 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package replicate
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const progressVersion1 = 1
+
+// progress is the progress of the replication, persisted to a local file after each replicated object, so an
+// interrupted replication resumes without checking or copying the objects it already replicated again.
+type progress struct {
+	path string
+
+	mtx    sync.Mutex
+	Blocks map[string]*blockProgress `json:"blocks"`
+	// Version of the file format.
+	Version int `json:"version"`
+}
+
+// blockProgress is the progress of the replication of a block.
+type blockProgress struct {
+	// Complete is true once the meta.json of the block is replicated.
+	Complete bool `json:"complete"`
+	// Objects are the SHA256 hashes of the replicated objects of the block, by object name.
+	Objects map[string]string `json:"objects,omitempty"`
+}
+
+// loadProgress reads the progress persisted to the file at path, if any. An empty path disables persisting the
+// progress.
+func loadProgress(path string) (*progress, error) {
+	p := &progress{path: path, Blocks: map[string]*blockProgress{}, Version: progressVersion1}
+	if path == "" {
+		return p, nil
+	}
+
+	b, err := os.ReadFile(filepath.Clean(path))
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read progress file")
+	}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal progress file %s", path)
+	}
+	if p.Version != progressVersion1 {
+		return nil, errors.Errorf("unexpected progress file version %d", p.Version)
+	}
+	if p.Blocks == nil {
+		p.Blocks = map[string]*blockProgress{}
+	}
+	return p, nil
+}
+
+// blockComplete returns true if the block was completely replicated.
+func (p *progress) blockComplete(id string) bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	b, ok := p.Blocks[id]
+	return ok && b.Complete
+}
+
+// object returns the hash of the object of the block, if it was replicated.
+func (p *progress) object(id, name string) (string, bool) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	b, ok := p.Blocks[id]
+	if !ok {
+		return "", false
+	}
+	h, ok := b.Objects[name]
+	return h, ok
+}
+
+// objectReplicated records the object of the block as replicated, and persists the progress.
+func (p *progress) objectReplicated(id, name, hash string) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	b, ok := p.Blocks[id]
+	if !ok {
+		b = &blockProgress{}
+		p.Blocks[id] = b
+	}
+	if b.Objects == nil {
+		b.Objects = map[string]string{}
+	}
+	b.Objects[name] = hash
+	return p.save()
+}
+
+// blockReplicated records the block as completely replicated, and persists the progress. The hashes of its objects
+// are dropped, as complete blocks are skipped altogether.
+func (p *progress) blockReplicated(id string) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.Blocks[id] = &blockProgress{Complete: true}
+	return p.save()
+}
+
+// save atomically writes the progress to its file. It must be called with the lock held.
+func (p *progress) save() error {
+	if p.path == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		return errors.Wrap(err, "marshal progress")
+	}
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(err, "write progress file")
+	}
+	return errors.Wrap(os.Rename(tmp, p.path), "rename progress file")
+}
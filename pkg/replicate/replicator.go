@@ -22,16 +22,17 @@ import (
 	"github.com/prometheus/prometheus/model/labels"
 
 	"github.com/thanos-io/objstore"
-	"github.com/thanos-io/objstore/client"
 
 	thanosblock "github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/compact"
 	"github.com/thanos-io/thanos/pkg/component"
+	"github.com/thanos-io/thanos/pkg/extobjstore"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	thanosmodel "github.com/thanos-io/thanos/pkg/model"
 	"github.com/thanos-io/thanos/pkg/prober"
 	"github.com/thanos-io/thanos/pkg/runutil"
 	"github.com/thanos-io/thanos/pkg/server/http"
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 )
 
 const (
@@ -65,6 +66,7 @@ func RunReplicate(
 	_ opentracing.Tracer,
 	httpBindAddr string,
 	httpTLSConfig string,
+	httpAuth *middleware.Authenticator,
 	httpGracePeriod time.Duration,
 	labelSelector labels.Selector,
 	resolutions []compact.ResolutionLevel,
@@ -75,6 +77,7 @@ func RunReplicate(
 	minTime, maxTime *thanosmodel.TimeOrDurationValue,
 	blockIDs []ulid.ULID,
 	ignoreMarkedForDeletion bool,
+	transferOpts TransferOptions,
 ) error {
 	logger = log.With(logger, "component", "replicate")
 
@@ -90,6 +93,7 @@ func RunReplicate(
 		http.WithListen(httpBindAddr),
 		http.WithGracePeriod(httpGracePeriod),
 		http.WithTLSConfig(httpTLSConfig),
+		http.WithAuthenticator(httpAuth),
 	)
 
 	g.Add(func() error {
@@ -114,7 +118,7 @@ func RunReplicate(
 		return errors.New("No supported bucket was configured to replicate from")
 	}
 
-	fromBkt, err := client.NewBucket(
+	fromBkt, err := extobjstore.NewBucket(
 		logger,
 		fromConfContentYaml,
 		prometheus.WrapRegistererWith(prometheus.Labels{"replicate": "from"}, reg),
@@ -133,7 +137,7 @@ func RunReplicate(
 		return errors.New("No supported bucket was configured to replicate to")
 	}
 
-	toBkt, err := client.NewBucket(
+	toBkt, err := extobjstore.NewBucket(
 		logger,
 		toConfContentYaml,
 		prometheus.WrapRegistererWith(prometheus.Labels{"replicate": "to"}, reg),
@@ -183,7 +187,7 @@ func RunReplicate(
 		logger := log.With(logger, "replication-run-id", runID.String())
 		level.Info(logger).Log("msg", "running replication attempt")
 
-		if err := newReplicationScheme(logger, metrics, blockFilter, fetcher, fromBkt, toBkt, reg).execute(ctx); err != nil {
+		if err := newReplicationScheme(logger, metrics, blockFilter, fetcher, fromBkt, toBkt, transferOpts, reg).execute(ctx); err != nil {
 			return errors.Wrap(err, "replication execute")
 		}
 
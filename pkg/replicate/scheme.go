@@ -6,10 +6,14 @@ package replicate
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"path"
 	"sort"
+	"strings"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
@@ -18,12 +22,14 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/prometheus/model/labels"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/thanos-io/objstore"
 
 	thanosblock "github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 	"github.com/thanos-io/thanos/pkg/compact"
+	"github.com/thanos-io/thanos/pkg/extobjstore"
 	"github.com/thanos-io/thanos/pkg/runutil"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 )
@@ -107,6 +113,20 @@ func (bf *BlockFilter) Filter(b *metadata.Meta) bool {
 
 type blockFilterFunc func(b *metadata.Meta) bool
 
+// TransferOptions configures how blocks are copied from the origin to the target bucket.
+type TransferOptions struct {
+	// Concurrency is the number of blocks replicated concurrently. Blocks are started in order of their minimum time.
+	Concurrency int
+	// ProgressFile is the local file the progress of the replication is persisted to, to resume it after an
+	// interruption. Empty means the progress is not persisted.
+	ProgressFile string
+	// VerifyChecksums enables reading back the objects copied to the target bucket, and comparing their SHA256 hash
+	// with the hash of the objects read from the origin bucket, and with the hash in the meta.json of the block if any.
+	VerifyChecksums bool
+	// BandwidthLimit is the maximum number of bytes per second copied to the target bucket. 0 means no limit.
+	BandwidthLimit int64
+}
+
 // TODO: Add filters field.
 type replicationScheme struct {
 	fromBkt objstore.InstrumentedBucketReader
@@ -115,6 +135,8 @@ type replicationScheme struct {
 	blockFilter blockFilterFunc
 	fetcher     thanosblock.MetadataFetcher
 
+	opts TransferOptions
+
 	logger  log.Logger
 	metrics *replicationMetrics
 
@@ -125,6 +147,8 @@ type replicationMetrics struct {
 	blocksAlreadyReplicated prometheus.Counter
 	blocksReplicated        prometheus.Counter
 	objectsReplicated       prometheus.Counter
+	bytesReplicated         prometheus.Counter
+	checksumMismatches      prometheus.Counter
 }
 
 func newReplicationMetrics(reg prometheus.Registerer) *replicationMetrics {
@@ -141,6 +165,14 @@ func newReplicationMetrics(reg prometheus.Registerer) *replicationMetrics {
 			Name: "thanos_replicate_objects_replicated_total",
 			Help: "Total number of objects replicated.",
 		}),
+		bytesReplicated: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_replicate_bytes_replicated_total",
+			Help: "Total number of bytes of objects replicated.",
+		}),
+		checksumMismatches: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_replicate_checksum_mismatches_total",
+			Help: "Total number of replicated objects whose checksum did not match the checksum of the origin object.",
+		}),
 	}
 	return m
 }
@@ -152,21 +184,30 @@ func newReplicationScheme(
 	fetcher thanosblock.MetadataFetcher,
 	from objstore.InstrumentedBucketReader,
 	to objstore.Bucket,
+	opts TransferOptions,
 	reg prometheus.Registerer,
 ) *replicationScheme {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
 
-	return &replicationScheme{
+	rs := &replicationScheme{
 		logger:      logger,
 		blockFilter: blockFilter,
 		fetcher:     fetcher,
 		fromBkt:     from,
 		toBkt:       to,
+		opts:        opts,
 		metrics:     metrics,
 		reg:         reg,
 	}
+	if opts.BandwidthLimit > 0 {
+		rs.toBkt = extobjstore.WrapWithUploadBandwidthLimit(to, opts.BandwidthLimit)
+	}
+	return rs
 }
 
 func (rs *replicationScheme) execute(ctx context.Context) error {
@@ -194,23 +235,51 @@ func (rs *replicationScheme) execute(ctx context.Context) error {
 		return availableBlocks[i].BlockMeta.MinTime < availableBlocks[j].BlockMeta.MinTime
 	})
 
+	prog, err := loadProgress(rs.opts.ProgressFile)
+	if err != nil {
+		return errors.Wrap(err, "load replication progress")
+	}
+
+	// Blocks are started in order, and at most opts.Concurrency of them are replicated at once.
+	g, gctx := errgroup.WithContext(ctx)
+	sem := make(chan struct{}, rs.opts.Concurrency)
 	for _, b := range availableBlocks {
-		if err := rs.ensureBlockIsReplicated(ctx, b.BlockMeta.ULID); err != nil {
-			return errors.Wrapf(err, "ensure block %v is replicated", b.BlockMeta.ULID.String())
+		id := b.BlockMeta.ULID
+		select {
+		case sem <- struct{}{}:
+		case <-gctx.Done():
 		}
+		if gctx.Err() != nil {
+			break
+		}
+		g.Go(func() error {
+			defer func() { <-sem }()
+			if err := rs.ensureBlockIsReplicated(gctx, id, prog); err != nil {
+				return errors.Wrapf(err, "ensure block %v is replicated", id.String())
+			}
+			return nil
+		})
 	}
-
-	return nil
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	return ctx.Err()
 }
 
 // ensureBlockIsReplicated ensures that a block present in the origin bucket is
 // present in the target bucket.
-func (rs *replicationScheme) ensureBlockIsReplicated(ctx context.Context, id ulid.ULID) error {
+func (rs *replicationScheme) ensureBlockIsReplicated(ctx context.Context, id ulid.ULID, prog *progress) error {
 	blockID := id.String()
 	chunksDir := path.Join(blockID, thanosblock.ChunksDirname)
 	indexFile := path.Join(blockID, thanosblock.IndexFilename)
 	metaFile := path.Join(blockID, thanosblock.MetaFilename)
 
+	if prog.blockComplete(blockID) {
+		level.Debug(rs.logger).Log("msg", "skipping block as already replicated according to the progress file", "block_uuid", blockID)
+		rs.metrics.blocksAlreadyReplicated.Inc()
+		return nil
+	}
+
 	level.Debug(rs.logger).Log("msg", "ensuring block is replicated", "block_uuid", blockID)
 
 	originMetaFile, err := rs.fromBkt.ReaderWithExpectedErrs(rs.fromBkt.IsObjNotFoundErr).Get(ctx, metaFile)
@@ -249,12 +318,18 @@ func (rs *replicationScheme) ensureBlockIsReplicated(ctx context.Context, id uli
 			level.Debug(rs.logger).Log("msg", "skipping block as already replicated", "block_uuid", blockID)
 			rs.metrics.blocksAlreadyReplicated.Inc()
 
-			return nil
+			return errors.Wrap(prog.blockReplicated(blockID), "save replication progress")
 		}
 	}
 
+	// The hashes of the files in the meta file, if any, are checked when verifying checksums.
+	var meta metadata.Meta
+	if err := json.Unmarshal(originMetaFileContent, &meta); err != nil {
+		return errors.Wrap(err, "unmarshal origin meta file")
+	}
+
 	if err := rs.fromBkt.Iter(ctx, chunksDir, func(objectName string) error {
-		err := rs.ensureObjectReplicated(ctx, objectName)
+		err := rs.ensureObjectReplicated(ctx, blockID, objectName, &meta, prog)
 		if err != nil {
 			return errors.Wrapf(err, "replicate object %v", objectName)
 		}
@@ -264,26 +339,31 @@ func (rs *replicationScheme) ensureBlockIsReplicated(ctx context.Context, id uli
 		return err
 	}
 
-	if err := rs.ensureObjectReplicated(ctx, indexFile); err != nil {
+	if err := rs.ensureObjectReplicated(ctx, blockID, indexFile, &meta, prog); err != nil {
 		return errors.Wrap(err, "replicate index file")
 	}
 
 	level.Debug(rs.logger).Log("msg", "replicating meta file", "object", metaFile)
 
-	if err := rs.toBkt.Upload(ctx, metaFile, bytes.NewBuffer(originMetaFileContent)); err != nil {
+	if _, err := rs.copyObject(ctx, metaFile, io.NopCloser(bytes.NewReader(originMetaFileContent)), nil); err != nil {
 		return errors.Wrap(err, "upload meta file")
 	}
 
 	rs.metrics.blocksReplicated.Inc()
 
-	return nil
+	return errors.Wrap(prog.blockReplicated(blockID), "save replication progress")
 }
 
 // ensureBlockIsReplicated ensures that an object present in the origin bucket
 // is present in the target bucket.
-func (rs *replicationScheme) ensureObjectReplicated(ctx context.Context, objectName string) error {
+func (rs *replicationScheme) ensureObjectReplicated(ctx context.Context, blockID, objectName string, meta *metadata.Meta, prog *progress) error {
 	level.Debug(rs.logger).Log("msg", "ensuring object is replicated", "object", objectName)
 
+	if _, ok := prog.object(blockID, objectName); ok {
+		level.Debug(rs.logger).Log("msg", "skipping object as already replicated according to the progress file", "object", objectName)
+		return nil
+	}
+
 	exists, err := rs.toBkt.Exists(ctx, objectName)
 	if err != nil {
 		return errors.Wrapf(err, "check if %v exists in target bucket", objectName)
@@ -302,14 +382,84 @@ func (rs *replicationScheme) ensureObjectReplicated(ctx context.Context, objectN
 		return errors.Wrapf(err, "get %v from origin bucket", objectName)
 	}
 
-	defer r.Close()
+	var expected *metadata.ObjectHash
+	relPath := strings.TrimPrefix(objectName, blockID+"/")
+	for _, f := range meta.Thanos.Files {
+		if f.RelPath == relPath && f.Hash != nil && f.Hash.Func == metadata.SHA256Func {
+			expected = f.Hash
+		}
+	}
 
-	if err = rs.toBkt.Upload(ctx, objectName, r); err != nil {
-		return errors.Wrapf(err, "upload %v to target bucket", objectName)
+	hash, err := rs.copyObject(ctx, objectName, r, expected)
+	if err != nil {
+		return err
 	}
 
 	level.Info(rs.logger).Log("msg", "object replicated", "object", objectName)
 	rs.metrics.objectsReplicated.Inc()
 
-	return nil
+	return errors.Wrap(prog.objectReplicated(blockID, objectName, hash), "save replication progress")
+}
+
+// copyObject uploads the object read from r to the target bucket, and returns its SHA256 hash. If checksums are
+// verified, the hash must match expected if not nil, and the hash of the uploaded object read back from the target
+// bucket.
+func (rs *replicationScheme) copyObject(ctx context.Context, objectName string, r io.ReadCloser, expected *metadata.ObjectHash) (string, error) {
+	defer runutil.CloseWithLogOnErr(rs.logger, r, "close origin object %v", objectName)
+
+	var (
+		h  = sha256.New()
+		cr = &countingReader{r: io.TeeReader(r, h)}
+	)
+	if err := rs.toBkt.Upload(ctx, objectName, cr); err != nil {
+		return "", errors.Wrapf(err, "upload %v to target bucket", objectName)
+	}
+	rs.metrics.bytesReplicated.Add(float64(cr.n))
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	if !rs.opts.VerifyChecksums {
+		return hash, nil
+	}
+
+	if expected != nil && expected.Value != hash {
+		rs.metrics.checksumMismatches.Inc()
+		rs.deleteMismatched(ctx, objectName)
+		return "", errors.Errorf("hash %s of %v read from origin bucket does not match hash %s in meta file", hash, objectName, expected.Value)
+	}
+
+	tr, err := rs.toBkt.Get(ctx, objectName)
+	if err != nil {
+		return "", errors.Wrapf(err, "get %v from target bucket to verify checksum", objectName)
+	}
+	defer runutil.CloseWithLogOnErr(rs.logger, tr, "close target object %v", objectName)
+
+	th := sha256.New()
+	if _, err := io.Copy(th, tr); err != nil {
+		return "", errors.Wrapf(err, "read %v from target bucket to verify checksum", objectName)
+	}
+	if targetHash := hex.EncodeToString(th.Sum(nil)); targetHash != hash {
+		rs.metrics.checksumMismatches.Inc()
+		rs.deleteMismatched(ctx, objectName)
+		return "", errors.Errorf("hash %s of %v replicated to target bucket does not match hash %s of origin object", targetHash, objectName, hash)
+	}
+	return hash, nil
+}
+
+// deleteMismatched deletes the object whose checksum did not match from the target bucket, so that it is replicated
+// again by the next replication.
+func (rs *replicationScheme) deleteMismatched(ctx context.Context, objectName string) {
+	if err := rs.toBkt.Delete(ctx, objectName); err != nil {
+		level.Warn(rs.logger).Log("msg", "failed to delete object with mismatched checksum from target bucket", "object", objectName, "err", err)
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
 }
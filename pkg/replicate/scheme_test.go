@@ -6,17 +6,24 @@ package replicate
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"io"
 	"math/rand"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/tsdb"
 
@@ -393,7 +400,7 @@ func TestReplicationSchemeAll(t *testing.T) {
 		)
 		testutil.Ok(t, err)
 
-		r := newReplicationScheme(logger, newReplicationMetrics(nil), filter, fetcher, objstore.WithNoopInstr(originBucket), targetBucket, nil)
+		r := newReplicationScheme(logger, newReplicationMetrics(nil), filter, fetcher, objstore.WithNoopInstr(originBucket), targetBucket, TransferOptions{}, nil)
 
 		err = r.execute(ctx)
 		testutil.Ok(t, err)
@@ -401,3 +408,153 @@ func TestReplicationSchemeAll(t *testing.T) {
 		c.assert(ctx, t, originBucket, targetBucket)
 	}
 }
+
+// testBucket is an in-memory bucket recording the objects checked for existence, and failing or corrupting uploads of
+// the given objects.
+type testBucket struct {
+	*objstore.InMemBucket
+
+	mtx       sync.Mutex
+	checked   []string
+	failing   map[string]bool
+	corrupted map[string]bool
+}
+
+func (b *testBucket) Exists(ctx context.Context, name string) (bool, error) {
+	b.mtx.Lock()
+	b.checked = append(b.checked, name)
+	b.mtx.Unlock()
+	return b.InMemBucket.Exists(ctx, name)
+}
+
+func (b *testBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	if b.failing[name] {
+		return errors.Errorf("upload of %s failed", name)
+	}
+	if b.corrupted[name] {
+		return b.InMemBucket.Upload(ctx, name, io.MultiReader(r, strings.NewReader("corrupted")))
+	}
+	return b.InMemBucket.Upload(ctx, name, r)
+}
+
+func uploadTestBlock(ctx context.Context, t *testing.T, bkt objstore.Bucket, meta *metadata.Meta, index []byte) {
+	b, err := json.Marshal(meta)
+	testutil.Ok(t, err)
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(meta.ULID.String(), "chunks", "000001"), bytes.NewReader([]byte("chunks"))))
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(meta.ULID.String(), "index"), bytes.NewReader(index)))
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(meta.ULID.String(), "meta.json"), bytes.NewReader(b)))
+}
+
+func testReplicationScheme(t *testing.T, originBucket *objstore.InMemBucket, targetBucket objstore.Bucket, opts TransferOptions) *replicationScheme {
+	logger := testLogger(t.Name())
+	matcher, err := labels.NewMatcher(labels.MatchEqual, "test-labelname", "test-labelvalue")
+	testutil.Ok(t, err)
+	filter := NewBlockFilter(logger, labels.Selector{matcher}, []compact.ResolutionLevel{compact.ResolutionLevelRaw}, []int{1}, nil).Filter
+	fetcher, err := newMetaFetcher(logger, objstore.WithNoopInstr(originBucket), nil, minTimeDuration, maxTimeDuration, 32, false)
+	testutil.Ok(t, err)
+	return newReplicationScheme(logger, newReplicationMetrics(nil), filter, fetcher, objstore.WithNoopInstr(originBucket), targetBucket, opts, nil)
+}
+
+func TestReplicationScheme_Progress(t *testing.T) {
+	ctx := context.Background()
+	originBucket := objstore.NewInMemBucket()
+	ids := []ulid.ULID{testULID(0), testULID(1)}
+	for i, id := range ids {
+		meta := testMeta(id)
+		meta.MinTime = int64(i)
+		uploadTestBlock(ctx, t, originBucket, meta, []byte("index"))
+	}
+
+	progressFile := filepath.Join(t.TempDir(), "progress.json")
+	secondIndex := path.Join(ids[1].String(), "index")
+	targetBucket := &testBucket{InMemBucket: objstore.NewInMemBucket(), failing: map[string]bool{secondIndex: true}}
+
+	// The replication is interrupted while replicating the second block.
+	testutil.NotOk(t, testReplicationScheme(t, originBucket, targetBucket, TransferOptions{ProgressFile: progressFile}).execute(ctx))
+
+	prog, err := loadProgress(progressFile)
+	testutil.Ok(t, err)
+	testutil.Assert(t, prog.blockComplete(ids[0].String()))
+	testutil.Assert(t, !prog.blockComplete(ids[1].String()))
+	_, ok := prog.object(ids[1].String(), path.Join(ids[1].String(), "chunks", "000001"))
+	testutil.Assert(t, ok)
+
+	// The resumed replication only checks the objects not replicated yet.
+	targetBucket.failing = nil
+	targetBucket.checked = nil
+	testutil.Ok(t, testReplicationScheme(t, originBucket, targetBucket, TransferOptions{ProgressFile: progressFile}).execute(ctx))
+	testutil.Equals(t, []string{secondIndex}, targetBucket.checked)
+	testutil.Equals(t, originBucket.Objects(), targetBucket.Objects())
+
+	prog, err = loadProgress(progressFile)
+	testutil.Ok(t, err)
+	testutil.Assert(t, prog.blockComplete(ids[1].String()))
+}
+
+func TestReplicationScheme_VerifyChecksums(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("corrupted upload", func(t *testing.T) {
+		originBucket := objstore.NewInMemBucket()
+		meta := testMeta(testULID(0))
+		uploadTestBlock(ctx, t, originBucket, meta, []byte("index"))
+
+		index := path.Join(meta.ULID.String(), "index")
+		targetBucket := &testBucket{InMemBucket: objstore.NewInMemBucket(), corrupted: map[string]bool{index: true}}
+
+		// Corrupted objects are only detected when verifying checksums.
+		testutil.Ok(t, testReplicationScheme(t, originBucket, targetBucket, TransferOptions{}).execute(ctx))
+		testutil.Ok(t, targetBucket.Delete(ctx, path.Join(meta.ULID.String(), "meta.json")))
+		testutil.Ok(t, targetBucket.Delete(ctx, index))
+
+		rs := testReplicationScheme(t, originBucket, targetBucket, TransferOptions{VerifyChecksums: true})
+		err := rs.execute(ctx)
+		testutil.NotOk(t, err)
+		testutil.Assert(t, strings.Contains(err.Error(), "does not match"), "%v", err)
+		testutil.Equals(t, 1.0, promtest.ToFloat64(rs.metrics.checksumMismatches))
+
+		ok, err := targetBucket.Exists(ctx, index)
+		testutil.Ok(t, err)
+		testutil.Assert(t, !ok)
+	})
+
+	t.Run("hash in meta file", func(t *testing.T) {
+		originBucket := objstore.NewInMemBucket()
+		meta := testMeta(testULID(0))
+		meta.Thanos.Files = []metadata.File{{RelPath: "index", Hash: &metadata.ObjectHash{Func: metadata.SHA256Func, Value: "invalid"}}}
+		uploadTestBlock(ctx, t, originBucket, meta, []byte("index"))
+
+		targetBucket := objstore.NewInMemBucket()
+		err := testReplicationScheme(t, originBucket, targetBucket, TransferOptions{VerifyChecksums: true}).execute(ctx)
+		testutil.NotOk(t, err)
+		testutil.Assert(t, strings.Contains(err.Error(), "in meta file"), "%v", err)
+
+		// The uploaded object is deleted, so that it is replicated again by the next replication.
+		ok, err := targetBucket.Exists(ctx, path.Join(meta.ULID.String(), "index"))
+		testutil.Ok(t, err)
+		testutil.Assert(t, !ok)
+
+		sum := sha256.Sum256([]byte("index"))
+		meta.Thanos.Files[0].Hash.Value = hex.EncodeToString(sum[:])
+		uploadTestBlock(ctx, t, originBucket, meta, []byte("index"))
+		testutil.Ok(t, testReplicationScheme(t, originBucket, objstore.NewInMemBucket(), TransferOptions{VerifyChecksums: true}).execute(ctx))
+	})
+}
+
+func TestReplicationScheme_ConcurrencyAndBandwidthLimit(t *testing.T) {
+	ctx := context.Background()
+	originBucket := objstore.NewInMemBucket()
+	for i := 0; i < 4; i++ {
+		uploadTestBlock(ctx, t, originBucket, testMeta(testULID(int64(i))), make([]byte, 1024))
+	}
+
+	targetBucket := objstore.NewInMemBucket()
+	rs := testReplicationScheme(t, originBucket, targetBucket, TransferOptions{Concurrency: 2, BandwidthLimit: 2048})
+	start := time.Now()
+	testutil.Ok(t, rs.execute(ctx))
+	testutil.Equals(t, originBucket.Objects(), targetBucket.Objects())
+	testutil.Equals(t, 4.0, promtest.ToFloat64(rs.metrics.blocksReplicated))
+
+	// The burst of a second worth of bytes is followed by more than a second of limited copies.
+	testutil.Assert(t, time.Since(start) > time.Second, "replication took %v", time.Since(start))
+}
@@ -0,0 +1,181 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package rules
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/tsdb"
+	"gopkg.in/yaml.v3"
+
+	"github.com/thanos-io/thanos/pkg/errutil"
+	"github.com/thanos-io/thanos/pkg/promclient"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// RangeQueryFunc evaluates the given query at every step of the given time range.
+type RangeQueryFunc func(ctx context.Context, q string, start, end time.Time, step time.Duration, partialResponseStrategy storepb.PartialResponseStrategy) (model.Matrix, error)
+
+// BackfillOptions configures the evaluation of recording rules over a past time range.
+type BackfillOptions struct {
+	// Start and End are the time range to evaluate the recording rules over.
+	Start, End time.Time
+	// EvalInterval is the evaluation interval of rule groups without an interval.
+	EvalInterval time.Duration
+	// QueryOffset is the query offset of rule groups without a query offset.
+	QueryOffset time.Duration
+	// BlockDuration is the time range of the written blocks.
+	BlockDuration time.Duration
+}
+
+// Backfill evaluates the recording rules of the given rule files over the time range of the options with the given
+// range query function, and writes their results as blocks to dir. It returns the IDs of the written blocks.
+// Rules are evaluated at multiples of the interval of their group. Alerting rules are skipped.
+func Backfill(ctx context.Context, logger log.Logger, dir string, files []string, queryRange RangeQueryFunc, opts BackfillOptions) ([]ulid.ULID, error) {
+	if opts.EvalInterval.Milliseconds() <= 0 {
+		return nil, errors.Errorf("eval interval %v has to be at least 1ms", opts.EvalInterval)
+	}
+	if opts.BlockDuration.Milliseconds() <= 0 {
+		return nil, errors.Errorf("block duration %v has to be at least 1ms", opts.BlockDuration)
+	}
+
+	var groups []configRuleAdapter
+	for _, fn := range files {
+		b, err := os.ReadFile(filepath.Clean(fn))
+		if err != nil {
+			return nil, err
+		}
+		var rg configGroups
+		if err := yaml.Unmarshal(b, &rg); err != nil {
+			return nil, errors.Wrap(err, fn)
+		}
+		for _, g := range rg.Groups {
+			if errs := g.validate(); len(errs) > 0 {
+				return nil, errutil.MultiError(errs).Err()
+			}
+		}
+		groups = append(groups, rg.Groups...)
+	}
+
+	blockDuration := opts.BlockDuration.Milliseconds()
+	start, end := timestamp.FromTime(opts.Start), timestamp.FromTime(opts.End)
+	var ids []ulid.ULID
+	for blockStart := start - start%blockDuration; blockStart <= end; blockStart += blockDuration {
+		id, err := backfillBlock(ctx, logger, dir, groups, queryRange, opts, blockStart, blockStart+blockDuration)
+		if err != nil {
+			return ids, errors.Wrapf(err, "backfill block %v", timestamp.Time(blockStart))
+		}
+		if id != (ulid.ULID{}) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// backfillBlock writes the results of the recording rules over [mint, maxt) as a block to dir. It returns an empty ID
+// if the rules have no results in the time range.
+func backfillBlock(ctx context.Context, logger log.Logger, dir string, groups []configRuleAdapter, queryRange RangeQueryFunc, opts BackfillOptions, mint, maxt int64) (_ ulid.ULID, err error) {
+	// The head only accepts samples up to half of its chunk range older than the newest one, while the results of every
+	// rule start at the beginning of the block.
+	w, err := tsdb.NewBlockWriter(log.NewNopLogger(), dir, 2*(maxt-mint))
+	if err != nil {
+		return ulid.ULID{}, errors.Wrap(err, "create block writer")
+	}
+	defer runutil.CloseWithErrCapture(&err, w, "close block writer")
+
+	var samples int
+	for _, g := range groups {
+		interval := time.Duration(g.group.Interval)
+		if interval.Milliseconds() <= 0 {
+			interval = opts.EvalInterval
+		}
+		queryOffset := opts.QueryOffset
+		if g.QueryOffset != nil {
+			queryOffset = time.Duration(*g.QueryOffset)
+		}
+
+		// First and last evaluation of the group in the block and the time range of the backfill.
+		first := mint
+		if start := timestamp.FromTime(opts.Start); start > first {
+			first = start
+		}
+		if r := first % interval.Milliseconds(); r != 0 {
+			first += interval.Milliseconds() - r
+		}
+		last := maxt - 1
+		if end := timestamp.FromTime(opts.End); end < last {
+			last = end
+		}
+		if first > last {
+			continue
+		}
+
+		ctx := ctx
+		if g.Tenant != "" {
+			ctx = WithTenant(ctx, g.Tenant)
+		}
+		for _, r := range g.group.Rules {
+			if r.Record.Value == "" {
+				level.Debug(logger).Log("msg", "skipping alerting rule", "group", g.group.Name, "alert", r.Alert.Value)
+				continue
+			}
+
+			level.Info(logger).Log("msg", "evaluating recording rule", "group", g.group.Name, "record", r.Record.Value, "start", timestamp.Time(first), "end", timestamp.Time(last))
+			m, err := queryRange(ctx, r.Expr.Value, timestamp.Time(first).Add(-queryOffset), timestamp.Time(last).Add(-queryOffset), interval, *g.PartialResponseStrategy)
+			if err != nil {
+				return ulid.ULID{}, errors.Wrapf(err, "evaluate rule %s of group %s", r.Record.Value, g.group.Name)
+			}
+
+			app := w.Appender(ctx)
+			for _, s := range m {
+				b := labels.NewBuilder(labels.EmptyLabels())
+				for n, v := range s.Metric {
+					b.Set(string(n), string(v))
+				}
+				// Rule labels override the labels of the query results, as in the ruler.
+				for n, v := range r.Labels {
+					b.Set(n, v)
+				}
+				b.Set(labels.MetricName, r.Record.Value)
+				lset := b.Labels()
+
+				for _, v := range s.Values {
+					if _, err := app.Append(0, lset, int64(v.Timestamp)+queryOffset.Milliseconds(), float64(v.Value)); err != nil {
+						return ulid.ULID{}, errors.Wrap(err, "append")
+					}
+				}
+				for _, h := range s.Histograms {
+					if _, err := app.AppendHistogram(0, lset, int64(h.Timestamp)+queryOffset.Milliseconds(), nil, promclient.SampleHistogramToFloatHistogram(h.Histogram)); err != nil {
+						return ulid.ULID{}, errors.Wrap(err, "append")
+					}
+				}
+				samples += len(s.Values) + len(s.Histograms)
+			}
+			if err := app.Commit(); err != nil {
+				return ulid.ULID{}, errors.Wrap(err, "commit")
+			}
+		}
+	}
+	if samples == 0 {
+		return ulid.ULID{}, nil
+	}
+
+	id, err := w.Flush(ctx)
+	if err != nil {
+		return ulid.ULID{}, errors.Wrap(err, "flush")
+	}
+	level.Info(logger).Log("msg", "wrote block", "id", id, "mint", timestamp.Time(mint), "maxt", timestamp.Time(maxt), "samples", samples)
+	return id, nil
+}
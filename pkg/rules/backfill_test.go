@@ -0,0 +1,127 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package rules
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+func TestBackfill(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "rules.yaml")
+	testutil.Ok(t, os.WriteFile(fn, []byte(`
+groups:
+- name: "a"
+  interval: 30m
+  rules:
+  - record: "job:up:sum"
+    expr: "sum(up) by (job)"
+    labels:
+      source: "rule"
+  - alert: "Down"
+    expr: "up == 0"
+- name: "b"
+  interval: 1h
+  query_offset: 10m
+  tenant: "team-b"
+  partial_response_strategy: "warn"
+  rules:
+  - record: "job:up:count"
+    expr: "count(up) by (job)"
+`), os.ModePerm))
+
+	type query struct {
+		q          string
+		start, end time.Time
+		step       time.Duration
+		tenant     string
+		strategy   storepb.PartialResponseStrategy
+	}
+	var queries []query
+	queryRange := func(ctx context.Context, q string, start, end time.Time, step time.Duration, partialResponseStrategy storepb.PartialResponseStrategy) (model.Matrix, error) {
+		tenant, _ := TenantFromContext(ctx)
+		queries = append(queries, query{q: q, start: start, end: end, step: step, tenant: tenant, strategy: partialResponseStrategy})
+
+		s := &model.SampleStream{Metric: model.Metric{"job": "a", "source": "query"}}
+		for ts := start; !ts.After(end); ts = ts.Add(step) {
+			s.Values = append(s.Values, model.SamplePair{Timestamp: model.TimeFromUnixNano(ts.UnixNano()), Value: 1})
+		}
+		return model.Matrix{s}, nil
+	}
+
+	start := time.Unix(0, 0).Add(10 * time.Minute).UTC()
+	end := time.Unix(0, 0).Add(3 * time.Hour).UTC()
+	ids, err := Backfill(context.Background(), log.NewNopLogger(), dir, []string{fn}, queryRange, BackfillOptions{
+		Start:         start,
+		End:           end,
+		EvalInterval:  time.Minute,
+		BlockDuration: 2 * time.Hour,
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(ids))
+
+	// Rules are evaluated at multiples of their interval, within the blocks and the time range.
+	at := func(d time.Duration) time.Time { return time.Unix(0, 0).Add(d).UTC() }
+	testutil.Equals(t, []query{
+		{q: "sum(up) by (job)", start: at(30 * time.Minute), end: at(2*time.Hour - time.Millisecond), step: 30 * time.Minute, strategy: storepb.PartialResponseStrategy_ABORT},
+		{q: "count(up) by (job)", start: at(50 * time.Minute), end: at(2*time.Hour - 10*time.Minute - time.Millisecond), step: time.Hour, tenant: "team-b", strategy: storepb.PartialResponseStrategy_WARN},
+		{q: "sum(up) by (job)", start: at(2 * time.Hour), end: at(3 * time.Hour), step: 30 * time.Minute, strategy: storepb.PartialResponseStrategy_ABORT},
+		{q: "count(up) by (job)", start: at(110 * time.Minute), end: at(170 * time.Minute), step: time.Hour, tenant: "team-b", strategy: storepb.PartialResponseStrategy_WARN},
+	}, queries)
+
+	series := map[string][]int64{}
+	for _, id := range ids {
+		b, err := tsdb.OpenBlock(nil, filepath.Join(dir, id.String()), chunkenc.NewPool())
+		testutil.Ok(t, err)
+		q, err := tsdb.NewBlockQuerier(b, math.MinInt64, math.MaxInt64)
+		testutil.Ok(t, err)
+
+		ss := q.Select(false, nil, labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+"))
+		for ss.Next() {
+			it := ss.At().Iterator(nil)
+			for it.Next() != chunkenc.ValNone {
+				ts, _ := it.At()
+				series[ss.At().Labels().String()] = append(series[ss.At().Labels().String()], ts)
+			}
+			testutil.Ok(t, it.Err())
+		}
+		testutil.Ok(t, ss.Err())
+		testutil.Ok(t, q.Close())
+		testutil.Ok(t, b.Close())
+	}
+
+	// Results are stored at the evaluation time, with the labels of the rule.
+	ms := func(d time.Duration) int64 { return timestamp.FromTime(at(d)) }
+	testutil.Equals(t, map[string][]int64{
+		`{__name__="job:up:sum", job="a", source="rule"}`:    {ms(30 * time.Minute), ms(time.Hour), ms(90 * time.Minute), ms(2 * time.Hour), ms(150 * time.Minute), ms(3 * time.Hour)},
+		`{__name__="job:up:count", job="a", source="query"}`: {ms(time.Hour), ms(2 * time.Hour), ms(3 * time.Hour)},
+	}, series)
+}
+
+func TestBackfill_InvalidOptions(t *testing.T) {
+	queryRange := func(context.Context, string, time.Time, time.Time, time.Duration, storepb.PartialResponseStrategy) (model.Matrix, error) {
+		return nil, nil
+	}
+	start, end := time.Unix(0, 0), time.Unix(0, 0).Add(time.Hour)
+
+	_, err := Backfill(context.Background(), log.NewNopLogger(), t.TempDir(), nil, queryRange, BackfillOptions{Start: start, End: end, BlockDuration: 2 * time.Hour})
+	testutil.NotOk(t, err)
+	_, err = Backfill(context.Background(), log.NewNopLogger(), t.TempDir(), nil, queryRange, BackfillOptions{Start: start, End: end, EvalInterval: time.Minute})
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,190 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package rules
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/objstore"
+	"gopkg.in/yaml.v3"
+
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// SetRuleFileTenant validates the given rule file and returns it with the tenant of all its rule groups set to the
+// given tenant. Rule files with rule groups of other tenants are rejected.
+func SetRuleFileTenant(content []byte, tenant string) ([]byte, error) {
+	if _, errs := ValidateAndCount(bytes.NewReader(content)); errs.Err() != nil {
+		return nil, errs.Err()
+	}
+
+	// Rule groups are kept as they are, as configRuleAdapter only marshals the fields supported by Prometheus.
+	var rgs struct {
+		Groups []map[string]interface{} `yaml:"groups"`
+	}
+	if err := yaml.Unmarshal(content, &rgs); err != nil {
+		return nil, err
+	}
+	for _, g := range rgs.Groups {
+		if t, ok := g["tenant"]; ok && t != tenant {
+			return nil, errors.Errorf("rule group %v has tenant %v instead of %v", g["name"], t, tenant)
+		}
+		g["tenant"] = tenant
+	}
+	return yaml.Marshal(rgs)
+}
+
+// BucketRuleFiles syncs the rule files of a bucket prefix organized per tenant, with the rule files of a tenant in
+// <prefix>/<tenant>/, to a local directory. The rule groups of the synced rule files have the tenant of their directory.
+type BucketRuleFiles struct {
+	logger log.Logger
+	bkt    objstore.BucketReader
+	prefix string
+	dir    string
+
+	syncs        prometheus.Counter
+	syncFailures prometheus.Counter
+	invalidFiles prometheus.Gauge
+}
+
+// NewBucketRuleFiles returns a BucketRuleFiles syncing the rule files of the given bucket prefix to the given directory.
+func NewBucketRuleFiles(logger log.Logger, reg prometheus.Registerer, bkt objstore.BucketReader, prefix, dir string) *BucketRuleFiles {
+	return &BucketRuleFiles{
+		logger: logger,
+		bkt:    bkt,
+		prefix: strings.Trim(prefix, objstore.DirDelim),
+		dir:    dir,
+		syncs: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_rule_bucket_rule_files_syncs_total",
+			Help: "Total number of syncs of the rule files of the bucket.",
+		}),
+		syncFailures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_rule_bucket_rule_files_sync_failures_total",
+			Help: "Total number of failed syncs of the rule files of the bucket.",
+		}),
+		invalidFiles: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "thanos_rule_bucket_rule_files_invalid",
+			Help: "Number of rule files of the bucket that are invalid and not loaded, as of the last sync.",
+		}),
+	}
+}
+
+// Pattern returns the glob pattern matching the synced rule files.
+func (b *BucketRuleFiles) Pattern() string {
+	return filepath.Join(b.dir, "*", "*")
+}
+
+// Sync downloads the rule files of the bucket to the directory and removes the ones deleted from the bucket.
+// Invalid rule files are skipped, keeping the last valid version of them. It returns whether the synced rule files
+// changed.
+func (b *BucketRuleFiles) Sync(ctx context.Context) (changed bool, err error) {
+	b.syncs.Inc()
+	defer func() {
+		if err != nil {
+			b.syncFailures.Inc()
+		}
+	}()
+
+	if err := os.MkdirAll(b.dir, os.ModePerm); err != nil {
+		return false, err
+	}
+
+	var (
+		synced  = map[string]struct{}{}
+		invalid int
+	)
+	tenants, err := b.list(ctx, b.prefix)
+	if err != nil {
+		return false, err
+	}
+	for _, tenantDir := range tenants {
+		if !strings.HasSuffix(tenantDir, objstore.DirDelim) {
+			continue
+		}
+		tenant := path.Base(tenantDir)
+		files, err := b.list(ctx, tenantDir)
+		if err != nil {
+			return false, err
+		}
+		for _, name := range files {
+			// Rule files of a tenant are not nested.
+			if strings.HasSuffix(name, objstore.DirDelim) {
+				continue
+			}
+			fn := filepath.Join(b.dir, tenant, path.Base(name))
+			synced[fn] = struct{}{}
+
+			content, err := b.download(ctx, name)
+			if err != nil {
+				return false, err
+			}
+			content, err = SetRuleFileTenant(content, tenant)
+			if err != nil {
+				level.Warn(b.logger).Log("msg", "skipping invalid rule file of bucket", "file", name, "err", err)
+				invalid++
+				continue
+			}
+
+			current, err := os.ReadFile(fn)
+			if err == nil && bytes.Equal(current, content) {
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(fn), os.ModePerm); err != nil {
+				return false, err
+			}
+			if err := os.WriteFile(fn, content, os.ModePerm); err != nil {
+				return false, err
+			}
+			changed = true
+		}
+	}
+	b.invalidFiles.Set(float64(invalid))
+
+	// Remove the rule files deleted from the bucket.
+	local, err := filepath.Glob(b.Pattern())
+	if err != nil {
+		return false, err
+	}
+	for _, fn := range local {
+		if _, ok := synced[fn]; ok {
+			continue
+		}
+		if err := os.Remove(fn); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+func (b *BucketRuleFiles) list(ctx context.Context, dir string) ([]string, error) {
+	var names []string
+	if err := b.bkt.Iter(ctx, dir, func(name string) error {
+		names = append(names, name)
+		return nil
+	}); err != nil {
+		return nil, errors.Wrapf(err, "list %s", dir)
+	}
+	return names, nil
+}
+
+func (b *BucketRuleFiles) download(ctx context.Context, name string) (_ []byte, err error) {
+	r, err := b.bkt.Get(ctx, name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get %s", name)
+	}
+	defer runutil.CloseWithErrCapture(&err, r, "close %s", name)
+	return io.ReadAll(r)
+}
@@ -0,0 +1,116 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package rules
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thanos-io/objstore"
+	"gopkg.in/yaml.v3"
+)
+
+func TestSetRuleFileTenant(t *testing.T) {
+	content, err := SetRuleFileTenant([]byte(`
+groups:
+- name: "a"
+  partial_response_strategy: "warn"
+  rules:
+  - record: "job:up:sum"
+    expr: "sum(up) by (job)"
+- name: "b"
+  tenant: "team-a"
+  rules:
+  - alert: "Down"
+    expr: "up == 0"
+`), "team-a")
+	testutil.Ok(t, err)
+
+	var rgs configGroups
+	testutil.Ok(t, yaml.Unmarshal(content, &rgs))
+	testutil.Equals(t, 2, len(rgs.Groups))
+	for _, g := range rgs.Groups {
+		testutil.Equals(t, "team-a", g.Tenant)
+	}
+	testutil.Equals(t, "warn", strings.ToLower(rgs.Groups[0].PartialResponseStrategy.String()))
+
+	_, err = SetRuleFileTenant([]byte(`
+groups:
+- name: "a"
+  tenant: "team-b"
+  rules:
+  - record: "job:up:sum"
+    expr: "sum(up) by (job)"
+`), "team-a")
+	testutil.NotOk(t, err)
+
+	_, err = SetRuleFileTenant([]byte(`
+groups:
+- name: "a"
+  rules:
+  - record: "job:up:sum"
+    expr: "sum(up) by (job"
+`), "team-a")
+	testutil.NotOk(t, err)
+}
+
+func TestBucketRuleFilesSync(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	dir := t.TempDir()
+
+	const valid = `
+groups:
+- name: "a"
+  rules:
+  - record: "job:up:sum"
+    expr: "sum(up) by (job)"
+`
+	testutil.Ok(t, bkt.Upload(ctx, "rules/team-a/a.yaml", strings.NewReader(valid)))
+	testutil.Ok(t, bkt.Upload(ctx, "rules/team-b/b.yaml", strings.NewReader(valid)))
+	testutil.Ok(t, bkt.Upload(ctx, "rules/team-b/nested/c.yaml", strings.NewReader(valid)))
+	testutil.Ok(t, bkt.Upload(ctx, "rules/team-c/invalid.yaml", strings.NewReader(`groups: [{name: "a", rules: [{record: "x", expr: "up{"}]}]`)))
+	testutil.Ok(t, bkt.Upload(ctx, "other/team-d/d.yaml", strings.NewReader(valid)))
+
+	files := func() []string {
+		fs, err := filepath.Glob(filepath.Join(dir, "*", "*"))
+		testutil.Ok(t, err)
+		for i := range fs {
+			fs[i], err = filepath.Rel(dir, fs[i])
+			testutil.Ok(t, err)
+		}
+		return fs
+	}
+
+	b := NewBucketRuleFiles(log.NewNopLogger(), prometheus.NewRegistry(), bkt, "/rules/", dir)
+	testutil.Equals(t, filepath.Join(dir, "*", "*"), b.Pattern())
+	changed, err := b.Sync(ctx)
+	testutil.Ok(t, err)
+	testutil.Assert(t, changed)
+	testutil.Equals(t, []string{filepath.Join("team-a", "a.yaml"), filepath.Join("team-b", "b.yaml")}, files())
+
+	content, err := os.ReadFile(filepath.Join(dir, "team-b", "b.yaml"))
+	testutil.Ok(t, err)
+	var rgs configGroups
+	testutil.Ok(t, yaml.Unmarshal(content, &rgs))
+	testutil.Equals(t, "team-b", rgs.Groups[0].Tenant)
+
+	changed, err = b.Sync(ctx)
+	testutil.Ok(t, err)
+	testutil.Assert(t, !changed)
+
+	// Invalid rule files keep the last valid version, deleted rule files are removed.
+	testutil.Ok(t, bkt.Upload(ctx, "rules/team-a/a.yaml", strings.NewReader(`groups: [{name: "a", rules: [{record: "x", expr: "up{"}]}]`)))
+	testutil.Ok(t, bkt.Delete(ctx, "rules/team-b/b.yaml"))
+	changed, err = b.Sync(ctx)
+	testutil.Ok(t, err)
+	testutil.Assert(t, changed)
+	testutil.Equals(t, []string{filepath.Join("team-a", "a.yaml")}, files())
+}
@@ -0,0 +1,193 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package rules
+
+import (
+	"hash/fnv"
+	"sort"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/thanos-io/thanos/pkg/rules/rulespb"
+)
+
+// alertMetricNames are the series alerting rules write.
+var alertMetricNames = []string{"ALERTS", "ALERTS_FOR_STATE"}
+
+// concurrentRuleBuckets returns the indices of the given rules split into n buckets that can be evaluated
+// concurrently. Rules selecting series written by other rules are kept in the same bucket, in their order. All rules
+// are kept in a single bucket if a rule selects series without a metric name, as it can depend on any rule, or if they
+// all end up in the same bucket. Rules are assigned to buckets by the hash of their names rather than balanced, so that
+// changes to other rules of the group do not move them to another bucket. Buckets can be empty.
+func concurrentRuleBuckets(rs []rulefmt.RuleNode, n int) [][]int {
+	all := make([]int, len(rs))
+	for i := range rs {
+		all[i] = i
+	}
+	if n <= 1 || len(rs) <= 1 {
+		return [][]int{all}
+	}
+
+	// Rules writing a metric name, by metric name.
+	writers := map[string][]int{}
+	for i, r := range rs {
+		if r.Record.Value != "" {
+			writers[r.Record.Value] = append(writers[r.Record.Value], i)
+			continue
+		}
+		for _, name := range alertMetricNames {
+			writers[name] = append(writers[name], i)
+		}
+	}
+
+	// Rules depending on each other form a component, found with union-find.
+	parents := make([]int, len(rs))
+	for i := range parents {
+		parents[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+	union := func(i, j int) {
+		parents[find(i)] = find(j)
+	}
+
+	for i, r := range rs {
+		expr, err := parser.ParseExpr(r.Expr.Value)
+		if err != nil {
+			// Invalid rules fail validation anyway.
+			return [][]int{all}
+		}
+		dependsOnAll := false
+		parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+			vs, ok := node.(*parser.VectorSelector)
+			if !ok {
+				return nil
+			}
+			name := vs.Name
+			for _, m := range vs.LabelMatchers {
+				if m.Name == labels.MetricName && m.Type == labels.MatchEqual {
+					name = m.Value
+				}
+			}
+			if name == "" {
+				dependsOnAll = true
+				return nil
+			}
+			for _, j := range writers[name] {
+				union(i, j)
+			}
+			return nil
+		})
+		if dependsOnAll {
+			return [][]int{all}
+		}
+	}
+
+	components := map[int][]int{}
+	var roots []int
+	for i := range rs {
+		root := find(i)
+		if _, ok := components[root]; !ok {
+			roots = append(roots, root)
+		}
+		components[root] = append(components[root], i)
+	}
+	if len(roots) == 1 {
+		return [][]int{all}
+	}
+
+	// The bucket of a component is the hash of the smallest name of its rules, which only changes with its rules.
+	buckets := make([][]int, n)
+	used := 0
+	for _, root := range roots {
+		name := ruleName(rs[root])
+		for _, i := range components[root] {
+			if other := ruleName(rs[i]); other < name {
+				name = other
+			}
+		}
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(name))
+		b := h.Sum64() % uint64(n)
+		if len(buckets[b]) == 0 {
+			used++
+		}
+		buckets[b] = append(buckets[b], components[root]...)
+	}
+	if used == 1 {
+		return [][]int{all}
+	}
+	for _, b := range buckets {
+		sort.Ints(b)
+	}
+	return buckets
+}
+
+// ruleName returns the name of the series written by the rule.
+func ruleName(r rulefmt.RuleNode) string {
+	if r.Record.Value != "" {
+		return r.Record.Value
+	}
+	return r.Alert.Value
+}
+
+// subset returns the group with the rules of the given indices only.
+func (g configRuleAdapter) subset(indices []int) configRuleAdapter {
+	nativeRules, _ := g.nativeRuleGroup["rules"].([]interface{})
+
+	sub := g
+	sub.group.Rules = make([]rulefmt.RuleNode, 0, len(indices))
+	subNativeRules := make([]interface{}, 0, len(indices))
+	for _, i := range indices {
+		sub.group.Rules = append(sub.group.Rules, g.group.Rules[i])
+		if i < len(nativeRules) {
+			subNativeRules = append(subNativeRules, nativeRules[i])
+		}
+	}
+	sub.nativeRuleGroup = make(map[string]interface{}, len(g.nativeRuleGroup))
+	for k, v := range g.nativeRuleGroup {
+		sub.nativeRuleGroup[k] = v
+	}
+	sub.nativeRuleGroup["rules"] = subNativeRules
+	return sub
+}
+
+// mergeConcurrentGroup merges the rules of a part of a rule group evaluated concurrently into the group,
+// in the order of the rules in the rule file. indices are the indices of the rules of the groups in the file.
+func mergeConcurrentGroup(g *rulespb.RuleGroup, indices []int, part *rulespb.RuleGroup, partIndices []int) []int {
+	type indexedRule struct {
+		index int
+		rule  *rulespb.Rule
+	}
+	rs := make([]indexedRule, 0, len(g.Rules)+len(part.Rules))
+	for i, r := range g.Rules {
+		rs = append(rs, indexedRule{index: indices[i], rule: r})
+	}
+	for i, r := range part.Rules {
+		rs = append(rs, indexedRule{index: partIndices[i], rule: r})
+	}
+	sort.Slice(rs, func(a, b int) bool { return rs[a].index < rs[b].index })
+
+	g.Rules = make([]*rulespb.Rule, 0, len(rs))
+	merged := make([]int, 0, len(rs))
+	for _, r := range rs {
+		g.Rules = append(g.Rules, r.rule)
+		merged = append(merged, r.index)
+	}
+	// The parts are evaluated concurrently, so the group takes as long as its slowest part.
+	if part.LastEvaluation.After(g.LastEvaluation) {
+		g.LastEvaluation = part.LastEvaluation
+	}
+	if part.EvaluationDurationSeconds > g.EvaluationDurationSeconds {
+		g.EvaluationDurationSeconds = part.EvaluationDurationSeconds
+	}
+	return merged
+}
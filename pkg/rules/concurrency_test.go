@@ -0,0 +1,120 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package rules
+
+import (
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"gopkg.in/yaml.v3"
+)
+
+func TestConcurrentRuleBuckets(t *testing.T) {
+	record := func(name, expr string) rulefmt.RuleNode {
+		r := rulefmt.RuleNode{}
+		r.Record.SetString(name)
+		r.Expr.SetString(expr)
+		return r
+	}
+	alert := func(name, expr string) rulefmt.RuleNode {
+		r := rulefmt.RuleNode{}
+		r.Alert.SetString(name)
+		r.Expr.SetString(expr)
+		return r
+	}
+
+	for _, tc := range []struct {
+		name        string
+		rules       []rulefmt.RuleNode
+		concurrency int
+		expected    [][]int
+	}{
+		{
+			name:        "sequential",
+			rules:       []rulefmt.RuleNode{record("a", "up"), record("b", "up")},
+			concurrency: 1,
+			expected:    [][]int{{0, 1}},
+		},
+		{
+			name:        "independent rules",
+			rules:       []rulefmt.RuleNode{record("a", "up"), record("b", "up"), alert("C", "up == 0")},
+			concurrency: 4,
+			expected:    [][]int{{0}, {1}, {2}, nil},
+		},
+		{
+			name:        "limited concurrency",
+			rules:       []rulefmt.RuleNode{record("a", "up"), record("b", "up"), record("c", "up"), record("d", "up")},
+			concurrency: 2,
+			expected:    [][]int{{0, 2}, {1, 3}},
+		},
+		{
+			name: "dependencies",
+			rules: []rulefmt.RuleNode{
+				record("a", "sum(up)"),
+				record("b", "sum(rate(http_requests_total[5m]))"),
+				record("c", `a{job="x"} * 2`),
+				alert("D", "rate(b[5m]) > 1"),
+				alert("E", `sum(ALERTS{alertstate="firing"}) > 10`),
+				record("f", `{__name__="node_load1"}`),
+			},
+			concurrency: 4,
+			expected:    [][]int{{0, 2}, {5}, nil, {1, 3, 4}},
+		},
+		{
+			name:        "assignment independent of other rules",
+			rules:       []rulefmt.RuleNode{record("e", "up"), record("a", "up"), record("b", "up")},
+			concurrency: 2,
+			expected:    [][]int{{0, 1}, {2}},
+		},
+		{
+			name:        "all rules in the same bucket",
+			rules:       []rulefmt.RuleNode{record("a", "up"), record("c", "up")},
+			concurrency: 2,
+			expected:    [][]int{{0, 1}},
+		},
+		{
+			name:        "selector without metric name",
+			rules:       []rulefmt.RuleNode{record("a", "up"), record("b", `{job="x"}`), record("c", "up")},
+			concurrency: 4,
+			expected:    [][]int{{0, 1, 2}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.Equals(t, tc.expected, concurrentRuleBuckets(tc.rules, tc.concurrency))
+		})
+	}
+}
+
+func TestConfigRuleAdapterSubset(t *testing.T) {
+	var g configRuleAdapter
+	testutil.Ok(t, yaml.Unmarshal([]byte(`
+name: "group"
+interval: 1m
+concurrency: 2
+rules:
+- record: "a"
+  expr: "up"
+- record: "b"
+  expr: "up"
+- record: "c"
+  expr: "up"
+`), &g))
+
+	sub := g.subset([]int{0, 2})
+	testutil.Equals(t, 2, len(sub.group.Rules))
+	testutil.Equals(t, "c", sub.group.Rules[1].Record.Value)
+	testutil.Equals(t, 3, len(g.group.Rules))
+
+	b, err := yaml.Marshal(sub)
+	testutil.Ok(t, err)
+	testutil.Equals(t, `interval: 1m
+name: group
+rules:
+    - expr: up
+      record: a
+    - expr: up
+      record: c
+`, string(b))
+}
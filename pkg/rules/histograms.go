@@ -0,0 +1,34 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package rules
+
+import (
+	"context"
+
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// NewNoNativeHistogramsAppendable returns a storage.Appendable refusing native histograms, as the TSDB does when they
+// are not enabled, for the storages without such an option like the WAL of the stateless mode.
+func NewNoNativeHistogramsAppendable(app storage.Appendable) storage.Appendable {
+	return noNativeHistogramsAppendable{Appendable: app}
+}
+
+type noNativeHistogramsAppendable struct {
+	storage.Appendable
+}
+
+func (a noNativeHistogramsAppendable) Appender(ctx context.Context) storage.Appender {
+	return noNativeHistogramsAppender{Appender: a.Appendable.Appender(ctx)}
+}
+
+type noNativeHistogramsAppender struct {
+	storage.Appender
+}
+
+func (noNativeHistogramsAppender) AppendHistogram(storage.SeriesRef, labels.Labels, int64, *histogram.Histogram, *histogram.FloatHistogram) (storage.SeriesRef, error) {
+	return 0, storage.ErrNativeHistogramsDisabled
+}
@@ -0,0 +1,24 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package rules
+
+import (
+	"context"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+func TestNoNativeHistogramsAppendable(t *testing.T) {
+	a := NewNoNativeHistogramsAppendable(nopAppendable{}).Appender(context.Background())
+
+	_, err := a.Append(0, labels.FromStrings("a", "1"), 10, 1)
+	testutil.Ok(t, err)
+	_, err = a.AppendHistogram(0, labels.FromStrings("a", "1"), 10, nil, &histogram.FloatHistogram{})
+	testutil.Equals(t, storage.ErrNativeHistogramsDisabled, err)
+	testutil.Ok(t, a.Commit())
+}
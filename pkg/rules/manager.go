@@ -9,6 +9,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -17,9 +18,14 @@ import (
 	"github.com/gogo/protobuf/proto"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/histogram"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/rules"
+	"github.com/prometheus/prometheus/storage"
 	"gopkg.in/yaml.v3"
 
 	"github.com/thanos-io/thanos/pkg/errutil"
@@ -36,6 +42,14 @@ type Group struct {
 	*rules.Group
 	OriginalFile            string
 	PartialResponseStrategy storepb.PartialResponseStrategy
+	// Tenant is the tenant the group is evaluated for, if any.
+	Tenant string
+	// QueryOffset is the offset of the evaluation queries of the group.
+	QueryOffset time.Duration
+
+	// ruleIndices are the indices of the rules in the rule file, if the group is a part of a rule group
+	// evaluated concurrently.
+	ruleIndices []int
 }
 
 func (g Group) toProto() *rulespb.RuleGroup {
@@ -121,10 +135,25 @@ type Manager struct {
 	mtx         sync.RWMutex
 	ruleFiles   map[string]string
 	externalURL string
+	// groupTenants are the tenants of the rule groups that have one, by group key.
+	groupTenants map[string]string
+	// queryOffset is the offset of the evaluation queries of the rule groups without a query offset of their own.
+	queryOffset time.Duration
+	// groupQueryOffsets are the query offsets of the rule groups that have one, by group key.
+	groupQueryOffsets map[string]time.Duration
+	// evalConcurrency is the number of parts the rules of rule groups without a concurrency of their own
+	// are split into to be evaluated concurrently.
+	evalConcurrency int
+	// groupRuleIndices are the indices of the rules in the rule file of the parts of rule groups evaluated
+	// concurrently, by group key.
+	groupRuleIndices map[string][]int
 }
 
 // NewManager creates new Manager.
-// QueryFunc from baseOpts will be rewritten.
+// QueryFunc from baseOpts will be rewritten. Rules are evaluated queryOffset in the past, unless their
+// group sets a query offset of its own, to allow for the delay until the queried data is available.
+// Up to evalConcurrency independent rules of a group are evaluated concurrently, unless the group sets
+// a concurrency of its own.
 func NewManager(
 	ctx context.Context,
 	reg prometheus.Registerer,
@@ -133,13 +162,20 @@ func NewManager(
 	queryFuncCreator func(partialResponseStrategy storepb.PartialResponseStrategy) rules.QueryFunc,
 	extLset labels.Labels,
 	externalURL string,
+	queryOffset time.Duration,
+	evalConcurrency int,
 ) *Manager {
 	m := &Manager{
-		workDir:     filepath.Join(dataDir, tmpRuleDir),
-		mgrs:        make(map[storepb.PartialResponseStrategy]*rules.Manager),
-		extLset:     extLset,
-		ruleFiles:   make(map[string]string),
-		externalURL: externalURL,
+		workDir:           filepath.Join(dataDir, tmpRuleDir),
+		mgrs:              make(map[storepb.PartialResponseStrategy]*rules.Manager),
+		extLset:           extLset,
+		ruleFiles:         make(map[string]string),
+		externalURL:       externalURL,
+		groupTenants:      make(map[string]string),
+		queryOffset:       queryOffset,
+		groupQueryOffsets: make(map[string]time.Duration),
+		evalConcurrency:   evalConcurrency,
+		groupRuleIndices:  make(map[string][]int),
 	}
 	for _, strategy := range storepb.PartialResponseStrategy_value {
 		s := storepb.PartialResponseStrategy(strategy)
@@ -147,7 +183,20 @@ func NewManager(
 		opts := baseOpts
 		opts.Registerer = extprom.WrapRegistererWith(prometheus.Labels{"strategy": strings.ToLower(s.String())}, reg)
 		opts.Context = ctx
-		opts.QueryFunc = queryFuncCreator(s)
+		queryFunc := queryFuncCreator(s)
+		opts.QueryFunc = func(ctx context.Context, q string, t time.Time) (promql.Vector, error) {
+			offset := m.groupQueryOffset(ctx)
+			v, err := queryFunc(m.groupTenantContext(ctx), q, t.Add(-offset))
+			// The results are moved to the evaluation time, as the offsetAppendable moves all samples
+			// of the group, including the ALERTS series and stale markers, back by the offset.
+			for i := range v {
+				v[i].T += offset.Milliseconds()
+			}
+			return v, err
+		}
+		if opts.Appendable != nil {
+			opts.Appendable = offsetAppendable{Appendable: tenantAppendable{Appendable: opts.Appendable, m: m}, m: m}
+		}
 
 		m.mgrs[s] = rules.NewManager(&opts)
 	}
@@ -155,6 +204,75 @@ func NewManager(
 	return m
 }
 
+// groupKeyFromContext returns the key of the rule group the context is evaluated for.
+// Prometheus attaches the file and the name of the group to the contexts of its evaluation queries and appenders.
+func groupKeyFromContext(ctx context.Context) (string, bool) {
+	origin, ok := ctx.Value(promql.QueryOrigin{}).(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	group, ok := origin["ruleGroup"].(map[string]string)
+	if !ok {
+		return "", false
+	}
+	return rules.GroupKey(group["file"], group["name"]), true
+}
+
+// groupQueryOffset returns the query offset of the rule group the context is evaluated for.
+func (m *Manager) groupQueryOffset(ctx context.Context) time.Duration {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	if key, ok := groupKeyFromContext(ctx); ok {
+		if offset, ok := m.groupQueryOffsets[key]; ok {
+			return offset
+		}
+	}
+	return m.queryOffset
+}
+
+// offsetAppendable stores the samples appended for a rule group with the query offset of the group, so that
+// the results of its rules, the ALERTS series and the stale markers are all stored with the timestamp of its queries.
+type offsetAppendable struct {
+	storage.Appendable
+	m *Manager
+}
+
+func (a offsetAppendable) Appender(ctx context.Context) storage.Appender {
+	app := a.Appendable.Appender(ctx)
+	offset := a.m.groupQueryOffset(ctx)
+	if offset == 0 {
+		return app
+	}
+	return offsetAppender{Appender: app, offset: offset.Milliseconds()}
+}
+
+type offsetAppender struct {
+	storage.Appender
+	offset int64
+}
+
+func (a offsetAppender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	return a.Appender.Append(ref, l, t-a.offset, v)
+}
+
+func (a offsetAppender) AppendExemplar(ref storage.SeriesRef, l labels.Labels, e exemplar.Exemplar) (storage.SeriesRef, error) {
+	e.Ts -= a.offset
+	return a.Appender.AppendExemplar(ref, l, e)
+}
+
+func (a offsetAppender) AppendHistogram(ref storage.SeriesRef, l labels.Labels, t int64, h *histogram.Histogram, fh *histogram.FloatHistogram) (storage.SeriesRef, error) {
+	return a.Appender.AppendHistogram(ref, l, t-a.offset, h, fh)
+}
+
+// groupConcurrency returns the number of parts the rules of the group are split into to be evaluated concurrently.
+func (m *Manager) groupConcurrency(g configRuleAdapter) int {
+	if g.Concurrency != nil {
+		return *g.Concurrency
+	}
+	return m.evalConcurrency
+}
+
 // Run is non blocking, in opposite to TSDB manager, which is blocking.
 func (m *Manager) Run() {
 	for _, mgr := range m.mgrs {
@@ -171,8 +289,25 @@ func (m *Manager) protoRuleGroups() []*rulespb.RuleGroup {
 
 	rg := m.RuleGroups()
 	res := make([]*rulespb.RuleGroup, 0, len(rg))
+	// The parts of rule groups evaluated concurrently are merged back into their group.
+	type concurrentGroup struct {
+		group   *rulespb.RuleGroup
+		indices []int
+	}
+	concurrentGroups := map[string]*concurrentGroup{}
 	for _, g := range rg {
-		res = append(res, g.toProto())
+		if g.ruleIndices == nil {
+			res = append(res, g.toProto())
+			continue
+		}
+		key := g.PartialResponseStrategy.String() + ";" + rules.GroupKey(g.OriginalFile, g.Name())
+		if cg, ok := concurrentGroups[key]; ok {
+			cg.indices = mergeConcurrentGroup(cg.group, cg.indices, g.toProto(), g.ruleIndices)
+			continue
+		}
+		cg := &concurrentGroup{group: g.toProto(), indices: g.ruleIndices}
+		concurrentGroups[key] = cg
+		res = append(res, cg.group)
 	}
 	return res
 }
@@ -183,10 +318,18 @@ func (m *Manager) RuleGroups() []Group {
 	var res []Group
 	for s, r := range m.mgrs {
 		for _, group := range r.RuleGroups() {
+			key := rules.GroupKey(group.File(), group.Name())
+			queryOffset, ok := m.groupQueryOffsets[key]
+			if !ok {
+				queryOffset = m.queryOffset
+			}
 			res = append(res, Group{
 				Group:                   group,
 				OriginalFile:            m.ruleFiles[group.File()],
 				PartialResponseStrategy: s,
+				Tenant:                  m.groupTenants[key],
+				QueryOffset:             queryOffset,
+				ruleIndices:             m.groupRuleIndices[key],
 			})
 		}
 	}
@@ -205,6 +348,9 @@ func (m *Manager) Active() []*rulespb.AlertInstance {
 
 type configRuleAdapter struct {
 	PartialResponseStrategy *storepb.PartialResponseStrategy
+	Tenant                  string
+	QueryOffset             *model.Duration
+	Concurrency             *int
 
 	group           rulefmt.RuleGroup
 	nativeRuleGroup map[string]interface{}
@@ -214,6 +360,10 @@ func (g *configRuleAdapter) UnmarshalYAML(unmarshal func(interface{}) error) err
 	rs := struct {
 		RuleGroup rulefmt.RuleGroup `yaml:",inline"`
 		Strategy  string            `yaml:"partial_response_strategy"`
+		Tenant    string            `yaml:"tenant"`
+		// QueryOffset is a pointer to tell groups without a query offset from groups with a zero query offset.
+		QueryOffset *model.Duration `yaml:"query_offset"`
+		Concurrency *int            `yaml:"concurrency"`
 	}{}
 
 	if err := unmarshal(&rs); err != nil {
@@ -225,6 +375,9 @@ func (g *configRuleAdapter) UnmarshalYAML(unmarshal func(interface{}) error) err
 	if err := g.PartialResponseStrategy.UnmarshalJSON([]byte("\"" + rs.Strategy + "\"")); err != nil {
 		return err
 	}
+	g.Tenant = rs.Tenant
+	g.QueryOffset = rs.QueryOffset
+	g.Concurrency = rs.Concurrency
 	g.group = rs.RuleGroup
 
 	var native map[string]interface{}
@@ -232,6 +385,9 @@ func (g *configRuleAdapter) UnmarshalYAML(unmarshal func(interface{}) error) err
 		return errors.Wrap(err, "failed to unmarshal rulefmt.configRuleAdapter")
 	}
 	delete(native, "partial_response_strategy")
+	delete(native, "tenant")
+	delete(native, "query_offset")
+	delete(native, "concurrency")
 
 	g.nativeRuleGroup = native
 	return nil
@@ -318,6 +474,9 @@ func (m *Manager) Update(evalInterval time.Duration, files []string) error {
 		errs            errutil.MultiError
 		filesByStrategy = map[storepb.PartialResponseStrategy][]string{}
 		ruleFiles       = map[string]string{}
+		groupTenants    = map[string]string{}
+		queryOffsets    = map[string]time.Duration{}
+		ruleIndices     = map[string][]int{}
 	)
 
 	// Initialize filesByStrategy for existing managers' strategies to make
@@ -353,29 +512,80 @@ func (m *Manager) Update(evalInterval time.Duration, files []string) error {
 			groupsByStrategy[*rg.PartialResponseStrategy] = append(groupsByStrategy[*rg.PartialResponseStrategy], rg)
 		}
 		for s, rg := range groupsByStrategy {
-			b, err := yaml.Marshal(configGroups{Groups: rg})
-			if err != nil {
-				errs = append(errs, errors.Wrapf(err, "%s: failed to marshal rule groups", fn))
-				continue
+			// Groups evaluated concurrently are split into parts of independent rules, each evaluated as a group of
+			// its own. The parts are written to separate files, as group names have to be unique within a file. The
+			// part of a rule only depends on its dependencies and the concurrency of its group, so that reloads keep
+			// the group keys, and with them the state of alerts and the staleness tracking of series.
+			var (
+				parts       [][]configRuleAdapter
+				partIndices []map[string][]int
+			)
+			for _, g := range rg {
+				buckets := concurrentRuleBuckets(g.group.Rules, m.groupConcurrency(g))
+				for p, indices := range buckets {
+					if p == len(parts) {
+						parts = append(parts, nil)
+						partIndices = append(partIndices, map[string][]int{})
+					}
+					if len(buckets) == 1 {
+						parts[p] = append(parts[p], g)
+						continue
+					}
+					if len(indices) == 0 {
+						continue
+					}
+					parts[p] = append(parts[p], g.subset(indices))
+					partIndices[p][g.group.Name] = indices
+				}
 			}
 
-			// Use full file name appending to work dir, so we can differentiate between different dirs and same filenames(!).
-			// This will be also used as key for file group name.
-			newFn := filepath.Join(m.workDir, s.String(), fn)
-			if err := os.MkdirAll(filepath.Dir(newFn), os.ModePerm); err != nil {
-				errs.Add(errors.Wrapf(err, "create %s", filepath.Dir(newFn)))
-				continue
-			}
-			if err := os.WriteFile(newFn, b, os.ModePerm); err != nil {
-				errs.Add(errors.Wrapf(err, "write file %v", newFn))
-				continue
+			for p, groups := range parts {
+				if len(groups) == 0 {
+					continue
+				}
+				b, err := yaml.Marshal(configGroups{Groups: groups})
+				if err != nil {
+					errs = append(errs, errors.Wrapf(err, "%s: failed to marshal rule groups", fn))
+					continue
+				}
+
+				// Use full file name appending to work dir, so we can differentiate between different dirs and same filenames(!).
+				// This will be also used as key for file group name.
+				newFn := filepath.Join(m.workDir, s.String(), fn)
+				if p > 0 {
+					newFn = filepath.Join(m.workDir, s.String()+"-"+strconv.Itoa(p), fn)
+				}
+				if err := os.MkdirAll(filepath.Dir(newFn), os.ModePerm); err != nil {
+					errs.Add(errors.Wrapf(err, "create %s", filepath.Dir(newFn)))
+					continue
+				}
+				if err := os.WriteFile(newFn, b, os.ModePerm); err != nil {
+					errs.Add(errors.Wrapf(err, "write file %v", newFn))
+					continue
+				}
+				filesByStrategy[s] = append(filesByStrategy[s], newFn)
+				ruleFiles[newFn] = fn
+				for _, g := range groups {
+					key := rules.GroupKey(newFn, g.group.Name)
+					if g.Tenant != "" {
+						groupTenants[key] = g.Tenant
+					}
+					if g.QueryOffset != nil {
+						queryOffsets[key] = time.Duration(*g.QueryOffset)
+					}
+					if indices, ok := partIndices[p][g.group.Name]; ok {
+						ruleIndices[key] = indices
+					}
+				}
 			}
-			filesByStrategy[s] = append(filesByStrategy[s], newFn)
-			ruleFiles[newFn] = fn
 		}
 	}
 
 	m.mtx.Lock()
+	// The tenants and query offsets are updated first, so that the new groups are evaluated with them right away.
+	m.groupTenants = groupTenants
+	m.groupQueryOffsets = queryOffsets
+	m.groupRuleIndices = ruleIndices
 	for s, fs := range filesByStrategy {
 		mgr, ok := m.mgrs[s]
 		if !ok {
@@ -395,6 +605,24 @@ func (m *Manager) Update(evalInterval time.Duration, files []string) error {
 	return errs.Err()
 }
 
+// Tenants returns the sorted tenants of the loaded rule groups.
+func (m *Manager) Tenants() []string {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	seen := map[string]struct{}{}
+	tenants := []string{}
+	for _, tenant := range m.groupTenants {
+		if _, ok := seen[tenant]; ok {
+			continue
+		}
+		seen[tenant] = struct{}{}
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
 // Rules returns specified rules from manager. This is used by gRPC and locally for HTTP and UI purposes.
 func (m *Manager) Rules(r *rulespb.RulesRequest, s rulespb.Rules_RulesServer) (err error) {
 	groups := m.protoRuleGroups()
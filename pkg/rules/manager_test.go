@@ -21,6 +21,8 @@ import (
 	"github.com/prometheus/prometheus/model/histogram"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/metadata"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/model/value"
 	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/rules"
 	"github.com/prometheus/prometheus/storage"
@@ -102,6 +104,8 @@ groups:
 		},
 		labels.FromStrings("replica", "1"),
 		"http://localhost",
+		0,
+		1,
 	)
 	testutil.Ok(t, thanosRuleMgr.Update(1*time.Second, []string{filepath.Join(dir, "rule.yaml")}))
 
@@ -196,6 +200,8 @@ groups:
 		},
 		labels.FromStrings("replica", "1"),
 		"http://localhost",
+		0,
+		1,
 	)
 	err = thanosRuleMgr.Update(10*time.Second, []string{
 		filepath.Join(dir, "no_strategy.yaml"),
@@ -343,6 +349,8 @@ func TestManager_Rules(t *testing.T) {
 		},
 		labels.FromStrings("replica", "test1"),
 		"http://localhost",
+		0,
+		1,
 	)
 	testutil.Ok(t, thanosRuleMgr.Update(60*time.Second, []string{
 		filepath.Join(curr, "../../examples/alerts/alerts.yaml"),
@@ -383,6 +391,8 @@ groups:
 		},
 		nil,
 		"http://localhost",
+		0,
+		1,
 	)
 
 	// We need to run the underlying rule managers to update them more than
@@ -441,6 +451,8 @@ groups:
 		},
 		nil,
 		"http://localhost",
+		0,
+		1,
 	)
 	thanosRuleMgr.Run()
 	t.Cleanup(thanosRuleMgr.Stop)
@@ -457,3 +469,328 @@ groups:
 	}))
 	testutil.Equals(t, "exceeded limit of 1 with 2 alerts", thanosRuleMgr.protoRuleGroups()[0].Rules[0].GetAlert().LastError)
 }
+
+type tenantRecordingAppendable struct {
+	mtx     sync.Mutex
+	tenants map[string]struct{}
+}
+
+func (a *tenantRecordingAppendable) Appender(ctx context.Context) storage.Appender {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if tenant, ok := TenantFromContext(ctx); ok {
+		a.tenants[tenant] = struct{}{}
+	}
+	return nopAppender{}
+}
+
+func TestManagerGroupTenants(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "tenants.yaml")
+	testutil.Ok(t, os.WriteFile(filename, []byte(`
+groups:
+- name: "team-a"
+  tenant: "team-a"
+  interval: 10ms
+  rules:
+  - record: "team_a"
+    expr: "up_a"
+- name: "team-b"
+  tenant: "team-b"
+  partial_response_strategy: "warn"
+  interval: 10ms
+  rules:
+  - record: "team_b"
+    expr: "up_b"
+- name: "no tenant"
+  interval: 10ms
+  rules:
+  - record: "no_tenant"
+    expr: "up_none"
+`), os.ModePerm))
+
+	var (
+		mtx           sync.Mutex
+		queryTenants  = map[string]string{}
+		appendTenants = &tenantRecordingAppendable{tenants: map[string]struct{}{}}
+	)
+	thanosRuleMgr := NewManager(
+		context.Background(),
+		nil,
+		dir,
+		rules.ManagerOptions{
+			Logger:     log.NewLogfmtLogger(os.Stderr),
+			Appendable: appendTenants,
+			Queryable:  nopQueryable{},
+		},
+		func(partialResponseStrategy storepb.PartialResponseStrategy) rules.QueryFunc {
+			return func(ctx context.Context, q string, t time.Time) (promql.Vector, error) {
+				tenant, _ := TenantFromContext(ctx)
+				mtx.Lock()
+				defer mtx.Unlock()
+				queryTenants[q] = tenant
+				return promql.Vector{{Metric: labels.FromStrings("foo", "bar"), F: 1}}, nil
+			}
+		},
+		nil,
+		"http://localhost",
+		0,
+		1,
+	)
+	thanosRuleMgr.Run()
+	t.Cleanup(thanosRuleMgr.Stop)
+	testutil.Ok(t, thanosRuleMgr.Update(10*time.Millisecond, []string{filename}))
+
+	testutil.Equals(t, []string{"team-a", "team-b"}, thanosRuleMgr.Tenants())
+	groupTenants := map[string]string{}
+	for _, g := range thanosRuleMgr.RuleGroups() {
+		groupTenants[g.Name()] = g.Tenant
+	}
+	testutil.Equals(t, map[string]string{"team-a": "team-a", "team-b": "team-b", "no tenant": ""}, groupTenants)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	testutil.Ok(t, runutil.Retry(10*time.Millisecond, ctx.Done(), func() error {
+		mtx.Lock()
+		defer mtx.Unlock()
+		appendTenants.mtx.Lock()
+		defer appendTenants.mtx.Unlock()
+		if len(queryTenants) < 3 || len(appendTenants.tenants) < 2 {
+			return errors.New("not all groups evaluated yet")
+		}
+		return nil
+	}))
+	mtx.Lock()
+	testutil.Equals(t, map[string]string{"up_a": "team-a", "up_b": "team-b", "up_none": ""}, queryTenants)
+	mtx.Unlock()
+	testutil.Equals(t, map[string]struct{}{"team-a": {}, "team-b": {}}, appendTenants.tenants)
+}
+
+func TestManagerGroupQueryOffsets(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "offsets.yaml")
+	testutil.Ok(t, os.WriteFile(filename, []byte(`
+groups:
+- name: "default offset"
+  interval: 10ms
+  rules:
+  - record: "default_offset"
+    expr: "up_default"
+- name: "no offset"
+  query_offset: 0s
+  interval: 10ms
+  rules:
+  - record: "no_offset"
+    expr: "up_none"
+- name: "large offset"
+  query_offset: 2h
+  partial_response_strategy: "warn"
+  interval: 10ms
+  rules:
+  - record: "large_offset"
+    expr: "up_large"
+  - alert: "LargeOffset"
+    expr: "alert_large"
+`), os.ModePerm))
+
+	var (
+		mtx        sync.Mutex
+		queryTimes = map[string]time.Time{}
+		appended   = &sampleRecordingAppendable{samples: map[string][]promql.Sample{}}
+	)
+	thanosRuleMgr := NewManager(
+		context.Background(),
+		nil,
+		dir,
+		rules.ManagerOptions{
+			Logger:     log.NewLogfmtLogger(os.Stderr),
+			Appendable: appended,
+			Queryable:  nopQueryable{},
+			NotifyFunc: func(context.Context, string, ...*rules.Alert) {},
+		},
+		func(partialResponseStrategy storepb.PartialResponseStrategy) rules.QueryFunc {
+			return func(ctx context.Context, q string, t time.Time) (promql.Vector, error) {
+				mtx.Lock()
+				defer mtx.Unlock()
+				// Results of recording rules are only returned once, so that they are marked stale afterwards.
+				if _, ok := queryTimes[q]; ok && q != "alert_large" {
+					return nil, nil
+				}
+				queryTimes[q] = t
+				return promql.Vector{{Metric: labels.FromStrings("foo", "bar"), T: timestamp.FromTime(t), F: 1}}, nil
+			}
+		},
+		nil,
+		"http://localhost",
+		time.Hour,
+		1,
+	)
+	thanosRuleMgr.Run()
+	t.Cleanup(thanosRuleMgr.Stop)
+	testutil.Ok(t, thanosRuleMgr.Update(10*time.Millisecond, []string{filename}))
+
+	groupOffsets := map[string]time.Duration{}
+	for _, g := range thanosRuleMgr.RuleGroups() {
+		groupOffsets[g.Name()] = g.QueryOffset
+	}
+	testutil.Equals(t, map[string]time.Duration{"default offset": time.Hour, "no offset": 0, "large offset": 2 * time.Hour}, groupOffsets)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	testutil.Ok(t, runutil.Retry(10*time.Millisecond, ctx.Done(), func() error {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if len(queryTimes) < 4 {
+			return errors.New("not all groups evaluated yet")
+		}
+		appended.mtx.Lock()
+		defer appended.mtx.Unlock()
+		for _, name := range []string{"default_offset", "no_offset", "large_offset"} {
+			if n := len(appended.samples[name]); n < 2 || !value.IsStaleNaN(appended.samples[name][n-1].F) {
+				return errors.Errorf("%s not marked stale yet", name)
+			}
+		}
+		if len(appended.samples["ALERTS"]) == 0 {
+			return errors.New("no alerts stored yet")
+		}
+		return nil
+	}))
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	now := time.Now()
+	for q, offset := range map[string]time.Duration{"up_default": time.Hour, "up_none": 0, "up_large": 2 * time.Hour, "alert_large": 2 * time.Hour} {
+		// Allow for the time passed since the evaluation.
+		ago := now.Sub(queryTimes[q])
+		testutil.Assert(t, ago >= offset && ago < offset+time.Minute, "query %s evaluated %v ago, expected offset %v", q, ago, offset)
+	}
+
+	// The results, the alerts and the stale markers are all stored with the offset of their group.
+	appended.mtx.Lock()
+	defer appended.mtx.Unlock()
+	for name, offset := range map[string]time.Duration{"default_offset": time.Hour, "no_offset": 0, "large_offset": 2 * time.Hour, "ALERTS": 2 * time.Hour, "ALERTS_FOR_STATE": 2 * time.Hour} {
+		for _, smpl := range appended.samples[name] {
+			ago := now.Sub(timestamp.Time(smpl.T))
+			testutil.Assert(t, ago >= offset && ago < offset+time.Minute, "sample of %s stored %v ago, expected offset %v", name, ago, offset)
+		}
+	}
+}
+
+// sampleRecordingAppendable records the appended samples by metric name.
+type sampleRecordingAppendable struct {
+	mtx     sync.Mutex
+	samples map[string][]promql.Sample
+}
+
+func (a *sampleRecordingAppendable) Appender(context.Context) storage.Appender {
+	return sampleRecordingAppender{nopAppender: nopAppender{}, a: a}
+}
+
+type sampleRecordingAppender struct {
+	nopAppender
+	a *sampleRecordingAppendable
+}
+
+func (a sampleRecordingAppender) Append(_ storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	a.a.mtx.Lock()
+	defer a.a.mtx.Unlock()
+	name := l.Get(labels.MetricName)
+	a.a.samples[name] = append(a.a.samples[name], promql.Sample{Metric: l, T: t, F: v})
+	return 0, nil
+}
+
+func TestManagerConcurrentGroups(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "concurrent.yaml")
+	testutil.Ok(t, os.WriteFile(filename, []byte(`
+groups:
+- name: "concurrent"
+  concurrency: 2
+  interval: 10ms
+  rules:
+  - record: "a"
+    expr: "up_a"
+  - record: "b"
+    expr: "up_b"
+  - record: "c"
+    expr: "a * 2"
+- name: "sequential"
+  interval: 10ms
+  rules:
+  - record: "d"
+    expr: "up_d"
+  - record: "e"
+    expr: "up_e"
+`), os.ModePerm))
+
+	var (
+		mtx                 sync.Mutex
+		inFlight, maxFlight = map[string]int{}, map[string]int{}
+	)
+	thanosRuleMgr := NewManager(
+		context.Background(),
+		nil,
+		dir,
+		rules.ManagerOptions{
+			Logger:     log.NewLogfmtLogger(os.Stderr),
+			Appendable: nopAppendable{},
+			Queryable:  nopQueryable{},
+		},
+		func(partialResponseStrategy storepb.PartialResponseStrategy) rules.QueryFunc {
+			return func(ctx context.Context, q string, t time.Time) (promql.Vector, error) {
+				group := "concurrent"
+				if strings.HasSuffix(q, "_d") || strings.HasSuffix(q, "_e") {
+					group = "sequential"
+				}
+				mtx.Lock()
+				inFlight[group]++
+				if inFlight[group] > maxFlight[group] {
+					maxFlight[group] = inFlight[group]
+				}
+				mtx.Unlock()
+
+				time.Sleep(50 * time.Millisecond)
+
+				mtx.Lock()
+				inFlight[group]--
+				mtx.Unlock()
+				return nil, nil
+			}
+		},
+		nil,
+		"http://localhost",
+		0,
+		1,
+	)
+	thanosRuleMgr.Run()
+	t.Cleanup(thanosRuleMgr.Stop)
+	testutil.Ok(t, thanosRuleMgr.Update(10*time.Millisecond, []string{filename}))
+
+	// The concurrent group is evaluated as two groups, but reported as one, with its rules in order.
+	testutil.Equals(t, 3, len(thanosRuleMgr.RuleGroups()))
+	groups := thanosRuleMgr.protoRuleGroups()
+	testutil.Equals(t, 2, len(groups))
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	testutil.Equals(t, "concurrent", groups[0].Name)
+	testutil.Equals(t, filename, groups[0].File)
+	var names []string
+	for _, r := range groups[0].Rules {
+		names = append(names, r.GetRecording().Name)
+	}
+	testutil.Equals(t, []string{"a", "b", "c"}, names)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	testutil.Ok(t, runutil.Retry(10*time.Millisecond, ctx.Done(), func() error {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if maxFlight["concurrent"] < 2 || maxFlight["sequential"] < 1 {
+			return errors.New("rules not evaluated concurrently yet")
+		}
+		return nil
+	}))
+	mtx.Lock()
+	defer mtx.Unlock()
+	testutil.Equals(t, 2, maxFlight["concurrent"])
+	testutil.Equals(t, 1, maxFlight["sequential"])
+}
@@ -0,0 +1,100 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package rules
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"go.uber.org/atomic"
+)
+
+// NewRemoteWriteLagAppendable returns a storage.Appendable tracking the newest sample committed to the given
+// storage.Appendable, and registers the thanos_rule_remote_write_lag_seconds metric, the time between it and the newest
+// sample sent by all remote write queues, given by lowestSentTimestamp.
+func NewRemoteWriteLagAppendable(reg prometheus.Registerer, app storage.Appendable, lowestSentTimestamp func() int64) storage.Appendable {
+	a := &remoteWriteLagAppendable{Appendable: app, lowestSentTimestamp: lowestSentTimestamp}
+	promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "thanos_rule_remote_write_lag_seconds",
+		Help: "Time between the newest sample committed and the newest sample sent by all remote write queues. Samples committed before the ruler started are not accounted.",
+	}, a.lag)
+	return a
+}
+
+type remoteWriteLagAppendable struct {
+	storage.Appendable
+	lowestSentTimestamp func() int64
+
+	// first and newest are the timestamps of the first and the newest sample committed, in milliseconds.
+	first, newest atomic.Int64
+}
+
+func (a *remoteWriteLagAppendable) Appender(ctx context.Context) storage.Appender {
+	return &remoteWriteLagAppender{Appender: a.Appendable.Appender(ctx), a: a}
+}
+
+func (a *remoteWriteLagAppendable) lag() float64 {
+	newest := a.newest.Load()
+	if newest == 0 {
+		return 0
+	}
+	// The queues did not send samples since the ruler started, or only the samples committed before.
+	sent := a.lowestSentTimestamp()
+	if first := a.first.Load(); sent < first {
+		sent = first
+	}
+	if sent >= newest {
+		return 0
+	}
+	return float64(newest-sent) / 1000
+}
+
+type remoteWriteLagAppender struct {
+	storage.Appender
+	a *remoteWriteLagAppendable
+
+	newest int64
+}
+
+func (a *remoteWriteLagAppender) track(t int64) {
+	if t > a.newest {
+		a.newest = t
+	}
+}
+
+func (a *remoteWriteLagAppender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	ref, err := a.Appender.Append(ref, l, t, v)
+	if err == nil {
+		a.track(t)
+	}
+	return ref, err
+}
+
+func (a *remoteWriteLagAppender) AppendHistogram(ref storage.SeriesRef, l labels.Labels, t int64, h *histogram.Histogram, fh *histogram.FloatHistogram) (storage.SeriesRef, error) {
+	ref, err := a.Appender.AppendHistogram(ref, l, t, h, fh)
+	if err == nil {
+		a.track(t)
+	}
+	return ref, err
+}
+
+func (a *remoteWriteLagAppender) Commit() error {
+	if err := a.Appender.Commit(); err != nil {
+		return err
+	}
+	if a.newest == 0 {
+		return nil
+	}
+	a.a.first.CompareAndSwap(0, a.newest)
+	for {
+		newest := a.a.newest.Load()
+		if a.newest <= newest || a.a.newest.CompareAndSwap(newest, a.newest) {
+			return nil
+		}
+	}
+}
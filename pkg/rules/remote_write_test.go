@@ -0,0 +1,60 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package rules
+
+import (
+	"context"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+func TestRemoteWriteLagAppendable(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	var sent int64
+	app := NewRemoteWriteLagAppendable(reg, nopAppendable{}, func() int64 { return sent })
+	lag := func() float64 {
+		mfs, err := reg.Gather()
+		testutil.Ok(t, err)
+		testutil.Equals(t, 1, len(mfs))
+		return mfs[0].GetMetric()[0].GetGauge().GetValue()
+	}
+	commit := func(floats []int64, histograms []int64) {
+		a := app.Appender(context.Background())
+		for _, ts := range floats {
+			_, err := a.Append(0, labels.FromStrings("a", "1"), ts, 1)
+			testutil.Ok(t, err)
+		}
+		for _, ts := range histograms {
+			_, err := a.AppendHistogram(0, labels.FromStrings("a", "1"), ts, nil, &histogram.FloatHistogram{})
+			testutil.Ok(t, err)
+		}
+		testutil.Ok(t, a.Commit())
+	}
+
+	// Nothing committed yet.
+	testutil.Equals(t, 0.0, lag())
+
+	// Nothing sent since the ruler started, the lag is measured from the first committed sample.
+	commit([]int64{10_000}, nil)
+	testutil.Equals(t, 0.0, lag())
+	commit([]int64{20_000, 15_000}, []int64{25_000})
+	testutil.Equals(t, 15.0, lag())
+
+	sent = 20_000
+	testutil.Equals(t, 5.0, lag())
+
+	// Rolled back samples are not accounted.
+	a := app.Appender(context.Background())
+	_, err := a.Append(0, labels.FromStrings("a", "1"), 60_000, 1)
+	testutil.Ok(t, err)
+	testutil.Ok(t, a.Rollback())
+	testutil.Equals(t, 5.0, lag())
+
+	sent = 25_000
+	testutil.Equals(t, 0.0, lag())
+}
@@ -0,0 +1,164 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package rules
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// TenantLabel is the label the results of rule groups with a tenant are stored with until they are remote written.
+// It is removed before the results are sent.
+const TenantLabel = "__thanos_rule_tenant__"
+
+type tenantContextKey struct{}
+
+// WithTenant returns a context carrying the tenant of the rule group being evaluated.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant of the rule group being evaluated, if the group has one.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantContextKey{}).(string)
+	return tenant, ok && tenant != ""
+}
+
+// groupTenantContext returns the context with the tenant of the rule group the context is evaluated for, if any.
+func (m *Manager) groupTenantContext(ctx context.Context) context.Context {
+	key, ok := groupKeyFromContext(ctx)
+	if !ok {
+		return ctx
+	}
+
+	m.mtx.RLock()
+	tenant, ok := m.groupTenants[key]
+	m.mtx.RUnlock()
+	if !ok {
+		return ctx
+	}
+	return WithTenant(ctx, tenant)
+}
+
+// tenantAppendable passes the tenant of the rule group results are appended for to the underlying storage.Appendable.
+type tenantAppendable struct {
+	storage.Appendable
+	m *Manager
+}
+
+func (a tenantAppendable) Appender(ctx context.Context) storage.Appender {
+	return a.Appendable.Appender(a.m.groupTenantContext(ctx))
+}
+
+// TenantTripperware returns a http.RoundTripper setting the given header to the tenant of the request context, if any.
+func TenantTripperware(header string, next http.RoundTripper) http.RoundTripper {
+	return tenantTripperware{header: header, next: next}
+}
+
+type tenantTripperware struct {
+	header string
+	next   http.RoundTripper
+}
+
+func (t tenantTripperware) RoundTrip(r *http.Request) (*http.Response, error) {
+	tenant, ok := TenantFromContext(r.Context())
+	if !ok {
+		return t.next.RoundTrip(r)
+	}
+	// RoundTrippers must not modify the given request.
+	r = r.Clone(r.Context())
+	r.Header.Set(t.header, tenant)
+	return t.next.RoundTrip(r)
+}
+
+// NewTenantLabelAppendable returns a storage.Appendable storing the series appended for a tenant with
+// the TenantLabel, so that they can be told apart once they are written to the WAL.
+func NewTenantLabelAppendable(app storage.Appendable) storage.Appendable {
+	return tenantLabelAppendable{Appendable: app}
+}
+
+type tenantLabelAppendable struct {
+	storage.Appendable
+}
+
+func (a tenantLabelAppendable) Appender(ctx context.Context) storage.Appender {
+	app := a.Appendable.Appender(ctx)
+	tenant, ok := TenantFromContext(ctx)
+	if !ok {
+		return app
+	}
+	return tenantLabelAppender{Appender: app, tenant: tenant}
+}
+
+type tenantLabelAppender struct {
+	storage.Appender
+	tenant string
+}
+
+func (a tenantLabelAppender) withTenant(l labels.Labels) labels.Labels {
+	return labels.NewBuilder(l).Set(TenantLabel, a.tenant).Labels()
+}
+
+func (a tenantLabelAppender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	return a.Appender.Append(ref, a.withTenant(l), t, v)
+}
+
+func (a tenantLabelAppender) AppendExemplar(ref storage.SeriesRef, l labels.Labels, e exemplar.Exemplar) (storage.SeriesRef, error) {
+	return a.Appender.AppendExemplar(ref, a.withTenant(l), e)
+}
+
+func (a tenantLabelAppender) AppendHistogram(ref storage.SeriesRef, l labels.Labels, t int64, h *histogram.Histogram, fh *histogram.FloatHistogram) (storage.SeriesRef, error) {
+	return a.Appender.AppendHistogram(ref, a.withTenant(l), t, h, fh)
+}
+
+// TenantRemoteWriteConfigs returns the remote write configs sending the results of the rule groups of each tenant
+// with the tenant in the given header, next to the given configs, which only send the results of rule groups without
+// a tenant. The TenantLabel is removed from the sent series.
+func TenantRemoteWriteConfigs(cfgs []*config.RemoteWriteConfig, header string, tenants []string) []*config.RemoteWriteConfig {
+	res := make([]*config.RemoteWriteConfig, 0, len(cfgs)*(len(tenants)+1))
+	for _, cfg := range cfgs {
+		noTenant := *cfg
+		noTenant.WriteRelabelConfigs = append([]*relabel.Config{
+			tenantRelabelConfig(relabel.Drop, ".+"),
+		}, cfg.WriteRelabelConfigs...)
+		res = append(res, &noTenant)
+
+		for _, tenant := range tenants {
+			c := *cfg
+			if cfg.Name != "" {
+				c.Name = cfg.Name + "-" + tenant
+			}
+			c.Headers = make(map[string]string, len(cfg.Headers)+1)
+			for k, v := range cfg.Headers {
+				c.Headers[k] = v
+			}
+			c.Headers[header] = tenant
+			c.WriteRelabelConfigs = append([]*relabel.Config{
+				tenantRelabelConfig(relabel.Keep, regexp.QuoteMeta(tenant)),
+				tenantRelabelConfig(relabel.LabelDrop, TenantLabel),
+			}, cfg.WriteRelabelConfigs...)
+			res = append(res, &c)
+		}
+	}
+	return res
+}
+
+func tenantRelabelConfig(action relabel.Action, regex string) *relabel.Config {
+	c := relabel.DefaultRelabelConfig
+	c.Action = action
+	c.Regex = relabel.MustNewRegexp(regex)
+	if action != relabel.LabelDrop {
+		c.SourceLabels = model.LabelNames{TenantLabel}
+	}
+	return &c
+}
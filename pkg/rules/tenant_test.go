@@ -0,0 +1,108 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package rules
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	commoncfg "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/storage"
+)
+
+type recordingAppendable struct {
+	series []labels.Labels
+}
+
+func (a *recordingAppendable) Appender(context.Context) storage.Appender {
+	return &recordingAppender{a: a}
+}
+
+type recordingAppender struct {
+	nopAppender
+	a *recordingAppendable
+}
+
+func (r *recordingAppender) Append(_ storage.SeriesRef, l labels.Labels, _ int64, _ float64) (storage.SeriesRef, error) {
+	r.a.series = append(r.a.series, l)
+	return 0, nil
+}
+
+func TestTenantLabelAppendable(t *testing.T) {
+	rec := &recordingAppendable{}
+	app := NewTenantLabelAppendable(rec)
+
+	_, err := app.Appender(WithTenant(context.Background(), "team-a")).Append(0, labels.FromStrings("__name__", "up"), 0, 1)
+	testutil.Ok(t, err)
+	_, err = app.Appender(context.Background()).Append(0, labels.FromStrings("__name__", "up"), 0, 1)
+	testutil.Ok(t, err)
+
+	testutil.Equals(t, []labels.Labels{
+		labels.FromStrings("__name__", "up", TenantLabel, "team-a"),
+		labels.FromStrings("__name__", "up"),
+	}, rec.series)
+}
+
+func TestTenantTripperware(t *testing.T) {
+	var tenants []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenants = append(tenants, r.Header.Get("THANOS-TENANT"))
+	}))
+	defer srv.Close()
+
+	c := &http.Client{Transport: TenantTripperware("THANOS-TENANT", http.DefaultTransport)}
+	for _, ctx := range []context.Context{WithTenant(context.Background(), "team-a"), context.Background()} {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		testutil.Ok(t, err)
+		resp, err := c.Do(req)
+		testutil.Ok(t, err)
+		testutil.Ok(t, resp.Body.Close())
+		testutil.Equals(t, "", req.Header.Get("THANOS-TENANT"))
+	}
+	testutil.Equals(t, []string{"team-a", ""}, tenants)
+}
+
+func TestTenantRemoteWriteConfigs(t *testing.T) {
+	u, err := url.Parse("http://receive:19291/api/v1/receive")
+	testutil.Ok(t, err)
+	cfg := config.DefaultRemoteWriteConfig
+	cfg.URL = &commoncfg.URL{URL: u}
+	cfg.Name = "receive"
+	cfg.Headers = map[string]string{"X-Scope": "rules"}
+
+	cfgs := TenantRemoteWriteConfigs([]*config.RemoteWriteConfig{&cfg}, "THANOS-TENANT", []string{"team-a", "team.b"})
+	testutil.Equals(t, 3, len(cfgs))
+	testutil.Equals(t, map[string]string{"X-Scope": "rules"}, cfg.Headers)
+	testutil.Equals(t, 0, len(cfg.WriteRelabelConfigs))
+
+	testutil.Equals(t, "receive", cfgs[0].Name)
+	testutil.Equals(t, "receive-team-a", cfgs[1].Name)
+	testutil.Equals(t, "receive-team.b", cfgs[2].Name)
+	testutil.Equals(t, map[string]string{"X-Scope": "rules", "THANOS-TENANT": "team-a"}, cfgs[1].Headers)
+
+	lsets := []labels.Labels{
+		labels.FromStrings("__name__", "up"),
+		labels.FromStrings("__name__", "up", TenantLabel, "team-a"),
+		labels.FromStrings("__name__", "up", TenantLabel, "team.b"),
+		labels.FromStrings("__name__", "up", TenantLabel, "teamxb"),
+	}
+	// Every config sends exactly the series of its tenant, without the tenant label.
+	for i, cfg := range cfgs {
+		var kept []int
+		for j, lset := range lsets {
+			if res, keep := relabel.Process(lset, cfg.WriteRelabelConfigs...); keep {
+				testutil.Equals(t, labels.FromStrings("__name__", "up"), res)
+				kept = append(kept, j)
+			}
+		}
+		testutil.Equals(t, []int{i}, kept)
+	}
+}
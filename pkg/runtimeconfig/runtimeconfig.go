@@ -0,0 +1,206 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package runtimeconfig implements the runtime configuration of Thanos components, a file with operational settings
+// which is reloaded when it changes, so that they can be adjusted without restarting the component.
+package runtimeconfig
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/api"
+	"github.com/thanos-io/thanos/pkg/extkingpin"
+	"github.com/thanos-io/thanos/pkg/extprom"
+	"github.com/thanos-io/thanos/pkg/featuregate"
+	"github.com/thanos-io/thanos/pkg/logging"
+)
+
+// APIPath is the path of the HTTP endpoint serving the runtime configuration.
+const APIPath = "/api/v1/status/runtime-config"
+
+// Config is the runtime configuration. Unset fields leave the settings given by flags unchanged.
+type Config struct {
+	// LogLevel overrides the level given by the log.level flag.
+	LogLevel string `yaml:"log_level,omitempty" json:"log_level,omitempty"`
+	// FeatureGates enable or disable features regardless of the features enabled by flags. Gates which are not
+	// implemented by the component are ignored, so that components can share the configuration.
+	FeatureGates featuregate.Settings `yaml:"feature_gates,omitempty" json:"feature_gates,omitempty"`
+}
+
+// Parse parses and validates the runtime configuration.
+func Parse(content []byte) (*Config, error) {
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, errors.Wrap(err, "parsing runtime config YAML")
+	}
+
+	switch cfg.LogLevel {
+	case "", "error", "warn", "info", "debug":
+	default:
+		return nil, errors.Errorf("unexpected log level %q, expected one of error, warn, info or debug", cfg.LogLevel)
+	}
+	if err := cfg.FeatureGates.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Manager loads the runtime configuration from a file and reloads it when the file changes. Until the file is loaded,
+// or if no file is given, the configuration is empty.
+type Manager struct {
+	path     string
+	interval time.Duration
+
+	logger   log.Logger
+	logLevel *logging.LevelSwitch
+
+	mtx      sync.RWMutex
+	cfg      *Config
+	content  []byte
+	onReload []func()
+
+	hashGauge            prometheus.Gauge
+	successGauge         prometheus.Gauge
+	lastSuccessTimeGauge prometheus.Gauge
+}
+
+// NewManager returns a new Manager with an empty configuration. Its file is given by the flags registered by
+// RegisterFlags, and is loaded by Init.
+func NewManager() *Manager {
+	return &Manager{cfg: &Config{}, logger: log.NewNopLogger()}
+}
+
+// RegisterFlags registers the flags of the runtime configuration on the command of a component.
+func (m *Manager) RegisterFlags(cmd extkingpin.FlagClause) {
+	cmd.Flag("runtime-config.file", "Path to YAML file with the runtime configuration, holding the log level and feature gates overriding the ones given by flags. The file is reloaded when it changes. See format details: https://thanos.io/tip/operating/runtime-config.md").
+		Default("").StringVar(&m.path)
+	cmd.Flag("runtime-config.reload-interval", "How often the runtime configuration file is checked for changes.").
+		Default("10s").DurationVar(&m.interval)
+}
+
+// Init loads the runtime configuration file, if any, and registers the metrics of its reloads. The log level of the
+// configuration is applied to the given level switch.
+func (m *Manager) Init(logger log.Logger, reg prometheus.Registerer, logLevel *logging.LevelSwitch) error {
+	m.logger = logger
+	m.logLevel = logLevel
+	if m.path == "" {
+		return nil
+	}
+	if m.interval <= 0 {
+		return errors.Errorf("reload interval of the runtime config must be positive, got %s", m.interval)
+	}
+
+	m.hashGauge = promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_runtime_config_hash",
+		Help: "Hash of the currently loaded runtime configuration file.",
+	})
+	m.successGauge = promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_runtime_config_last_reload_successful",
+		Help: "Whether the last runtime configuration file reload attempt was successful.",
+	})
+	m.lastSuccessTimeGauge = promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_runtime_config_last_reload_success_timestamp_seconds",
+		Help: "Timestamp of the last successful runtime configuration file reload.",
+	})
+	return m.reload()
+}
+
+// Run reloads the runtime configuration file when it changes until the given context is canceled.
+func (m *Manager) Run(ctx context.Context) error {
+	if m.path == "" {
+		<-ctx.Done()
+		return nil
+	}
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := m.reload(); err != nil {
+				level.Error(m.logger).Log("msg", "reloading runtime config failed, keeping the previous one", "path", m.path, "err", err)
+			}
+		}
+	}
+}
+
+func (m *Manager) reload() error {
+	content, err := os.ReadFile(m.path)
+	if err != nil {
+		m.successGauge.Set(0)
+		return errors.Wrapf(err, "reading runtime config file %s", m.path)
+	}
+
+	m.mtx.RLock()
+	unchanged := m.content != nil && bytes.Equal(m.content, content)
+	m.mtx.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	cfg, err := Parse(content)
+	if err != nil {
+		m.successGauge.Set(0)
+		return errors.Wrapf(err, "loading runtime config file %s", m.path)
+	}
+	if m.logLevel != nil {
+		// The level was validated by Parse already.
+		_ = m.logLevel.SetLevel(cfg.LogLevel)
+	}
+
+	m.mtx.Lock()
+	m.cfg = cfg
+	m.content = content
+	onReload := m.onReload
+	m.mtx.Unlock()
+
+	for _, f := range onReload {
+		f()
+	}
+
+	m.hashGauge.Set(extprom.HashAsMetricValue(content))
+	m.successGauge.Set(1)
+	m.lastSuccessTimeGauge.SetToCurrentTime()
+	level.Info(m.logger).Log("msg", "loaded runtime config", "path", m.path)
+	return nil
+}
+
+// OnReload registers a function called after the runtime configuration is reloaded.
+func (m *Manager) OnReload(f func()) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.onReload = append(m.onReload, f)
+}
+
+// Config returns the current runtime configuration. It must not be modified.
+func (m *Manager) Config() *Config {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	return m.cfg
+}
+
+// ServeHTTP serves the current runtime configuration.
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	api.Respond(w, m.Config(), nil)
+}
@@ -0,0 +1,111 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package runtimeconfig
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/thanos-io/thanos/pkg/featuregate"
+	"github.com/thanos-io/thanos/pkg/logging"
+)
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse([]byte(`
+log_level: debug
+feature_gates:
+  query-pushdown: true
+`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, "debug", cfg.LogLevel)
+	testutil.Equals(t, featuregate.Settings{featuregate.QueryPushdown: true}, cfg.FeatureGates)
+
+	cfg, err = Parse(nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(cfg.FeatureGates))
+
+	for _, content := range []string{
+		`unknown_field: true`,
+		`log_level: verbose`,
+		`limits: {request_series: 1000}`,
+		`feature_gates: {unknown: true}`,
+	} {
+		_, err := Parse([]byte(content))
+		testutil.NotOk(t, err, content)
+	}
+}
+
+func TestManager(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "runtime-config.yaml")
+	testutil.Ok(t, os.WriteFile(path, []byte("log_level: debug\n"), os.ModePerm))
+
+	m := NewManager()
+	app := kingpin.New("test", "")
+	m.RegisterFlags(app)
+	_, err := app.Parse([]string{"--runtime-config.file=" + path})
+	testutil.Ok(t, err)
+
+	_, logLevel := logging.NewLoggerWithLevelSwitch("info", logging.LogFormatLogfmt, "")
+	reg := prometheus.NewRegistry()
+	var reloads int
+	m.OnReload(func() { reloads++ })
+	testutil.Ok(t, m.Init(log.NewNopLogger(), reg, logLevel))
+	testutil.Equals(t, 1, reloads)
+	testutil.Equals(t, "debug", logLevel.Level())
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(m.successGauge))
+
+	t.Run("serve", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, APIPath, nil))
+		testutil.Equals(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Status string `json:"status"`
+			Data   Config `json:"data"`
+		}
+		testutil.Ok(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		testutil.Equals(t, "success", resp.Status)
+		testutil.Equals(t, "debug", resp.Data.LogLevel)
+	})
+	t.Run("invalid config keeps the previous one", func(t *testing.T) {
+		testutil.Ok(t, os.WriteFile(path, []byte("log_level: verbose\n"), os.ModePerm))
+		testutil.NotOk(t, m.reload())
+		testutil.Equals(t, "debug", logLevel.Level())
+		testutil.Equals(t, 0.0, promtestutil.ToFloat64(m.successGauge))
+	})
+	t.Run("changed config", func(t *testing.T) {
+		testutil.Ok(t, os.WriteFile(path, []byte("feature_gates: {query-pushdown: true}\n"), os.ModePerm))
+		testutil.Ok(t, m.reload())
+		// Removing the log level restores the one given by flags.
+		testutil.Equals(t, "info", logLevel.Level())
+		testutil.Equals(t, true, m.Config().FeatureGates[featuregate.QueryPushdown])
+		testutil.Equals(t, 1.0, promtestutil.ToFloat64(m.successGauge))
+		testutil.Equals(t, 2, reloads)
+	})
+}
+
+func TestManager_NoFile(t *testing.T) {
+	m := NewManager()
+	testutil.Ok(t, m.Init(log.NewNopLogger(), prometheus.NewRegistry(), nil))
+	testutil.Equals(t, &Config{}, m.Config())
+}
+
+func TestManager_InvalidReloadInterval(t *testing.T) {
+	m := NewManager()
+	app := kingpin.New("test", "")
+	m.RegisterFlags(app)
+	_, err := app.Parse([]string{"--runtime-config.file=runtime-config.yaml", "--runtime-config.reload-interval=0s"})
+	testutil.Ok(t, err)
+	testutil.NotOk(t, m.Init(log.NewNopLogger(), prometheus.NewRegistry(), nil))
+}
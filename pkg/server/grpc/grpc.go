@@ -30,6 +30,7 @@ import (
 	"google.golang.org/grpc/status"
 
 	"github.com/thanos-io/thanos/pkg/component"
+	"github.com/thanos-io/thanos/pkg/extgrpc"
 	"github.com/thanos-io/thanos/pkg/prober"
 	"github.com/thanos-io/thanos/pkg/tracing"
 )
@@ -82,6 +83,8 @@ func New(logger log.Logger, reg prometheus.Registerer, tracer opentracing.Tracer
 			met.UnaryServerInterceptor(),
 			tags.UnaryServerInterceptor(tagsOpts...),
 			tracing.UnaryServerInterceptor(tracer),
+			extgrpc.RequestIDUnaryServerInterceptor(),
+			extgrpc.TenantUnaryServerInterceptor(),
 			grpc_logging.UnaryServerInterceptor(kit.InterceptorLogger(logger), logOpts...),
 		),
 		grpc_middleware.WithStreamServerChain(
@@ -89,6 +92,8 @@ func New(logger log.Logger, reg prometheus.Registerer, tracer opentracing.Tracer
 			met.StreamServerInterceptor(),
 			tags.StreamServerInterceptor(tagsOpts...),
 			tracing.StreamServerInterceptor(tracer),
+			extgrpc.RequestIDStreamServerInterceptor(),
+			extgrpc.TenantStreamServerInterceptor(),
 			grpc_logging.StreamServerInterceptor(kit.InterceptorLogger(logger), logOpts...),
 		),
 	}...)
@@ -50,12 +50,18 @@ func New(logger log.Logger, reg *prometheus.Registry, comp component.Component,
 	registerProbes(mux, prober, logger)
 	registerProfiler(mux)
 
-	var h http.Handler
+	var h http.Handler = mux
+	if options.tenant != nil {
+		// The tenant is resolved after the authentication, which gives the user it can be taken from.
+		h = options.tenant.Handler(h)
+	}
+	if options.authenticator != nil {
+		// Probes stay unauthenticated, so orchestrators can check the server without credentials.
+		h = options.authenticator.Handler(h, "/-/healthy", "/-/ready")
+	}
 	if options.enableH2C {
 		h2s := &http2.Server{}
-		h = h2c.NewHandler(mux, h2s)
-	} else {
-		h = mux
+		h = h2c.NewHandler(h, h2s)
 	}
 
 	return &Server{
@@ -136,6 +142,7 @@ func registerProbes(mux *http.ServeMux, p *prober.HTTPProbe, logger log.Logger)
 	if p != nil {
 		mux.Handle("/-/healthy", p.HealthyHandler(logger))
 		mux.Handle("/-/ready", p.ReadyHandler(logger))
+		mux.Handle("/api/v1/status/health", p.HealthHandler(logger))
 	}
 }
 
@@ -0,0 +1,216 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-io/thanos/pkg/extjwt"
+)
+
+// AuthConfig configures the authentication of HTTP requests. Requests are accepted when they authenticate with any of
+// the configured methods.
+type AuthConfig struct {
+	// BasicAuthUsers maps the users allowed to authenticate with basic auth to their bcrypt hashed password.
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"`
+	// BearerTokens are the static tokens allowed to authenticate as bearer token.
+	BearerTokens []string `yaml:"bearer_tokens"`
+	// JWT configures the validation of JWT bearer tokens, if set.
+	JWT *JWTAuthConfig `yaml:"jwt"`
+}
+
+// JWTAuthConfig configures how JWT bearer tokens are validated.
+type JWTAuthConfig struct {
+	// JWKSURL is the URL of the JSON Web Key Set with the public keys tokens can be signed with.
+	JWKSURL string `yaml:"jwks_url"`
+	// JWKSRefreshInterval is the interval after which the JSON Web Key Set is fetched again.
+	JWKSRefreshInterval model.Duration `yaml:"jwks_refresh_interval"`
+	// Issuer is the issuer tokens must have been issued by, if set.
+	Issuer string `yaml:"issuer"`
+	// Audience is the audience tokens must have been issued for, if set.
+	Audience string `yaml:"audience"`
+}
+
+const (
+	defaultJWKSRefreshInterval = model.Duration(time.Hour)
+	// maxBasicAuthCacheSize is the number of successful basic auth credentials remembered to avoid hashing the
+	// password of each request.
+	maxBasicAuthCacheSize = 1000
+)
+
+// Authenticator authenticates HTTP requests with basic auth, static bearer tokens or JWT bearer tokens.
+type Authenticator struct {
+	logger log.Logger
+
+	users        map[string][]byte
+	bearerTokens [][]byte
+	jwt          *jwtAuthenticator
+
+	mtx       sync.Mutex
+	authCache map[[sha256.Size]byte]struct{}
+}
+
+// NewAuthenticator parses the given YAML AuthConfig. For JWT authentication, the JSON Web Key Set is fetched once to
+// check the configuration.
+func NewAuthenticator(logger log.Logger, content []byte) (*Authenticator, error) {
+	var conf AuthConfig
+	if err := yaml.UnmarshalStrict(content, &conf); err != nil {
+		return nil, errors.Wrap(err, "parsing HTTP auth config YAML")
+	}
+	if len(conf.BasicAuthUsers) == 0 && len(conf.BearerTokens) == 0 && conf.JWT == nil {
+		return nil, errors.New("no authentication method configured")
+	}
+
+	a := &Authenticator{
+		logger:    logger,
+		users:     make(map[string][]byte, len(conf.BasicAuthUsers)),
+		authCache: map[[sha256.Size]byte]struct{}{},
+	}
+	for user, hash := range conf.BasicAuthUsers {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, errors.Wrapf(err, "password of user %s is not a bcrypt hash", user)
+		}
+		a.users[user] = []byte(hash)
+	}
+	for _, token := range conf.BearerTokens {
+		if token == "" {
+			return nil, errors.New("bearer tokens must not be empty")
+		}
+		a.bearerTokens = append(a.bearerTokens, []byte(token))
+	}
+	if conf.JWT != nil {
+		if conf.JWT.JWKSURL == "" {
+			return nil, errors.New("JWKS URL must be set for JWT authentication")
+		}
+		if conf.JWT.JWKSRefreshInterval == 0 {
+			conf.JWT.JWKSRefreshInterval = defaultJWKSRefreshInterval
+		}
+		jwks, err := extjwt.NewJWKS(logger, conf.JWT.JWKSURL, time.Duration(conf.JWT.JWKSRefreshInterval))
+		if err != nil {
+			return nil, err
+		}
+		a.jwt = &jwtAuthenticator{validator: extjwt.NewValidator(jwks, conf.JWT.Issuer, conf.JWT.Audience)}
+	}
+	return a, nil
+}
+
+// NewContextWithUser creates a context with the identity of the authenticated user of the request.
+func NewContextWithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey, user)
+}
+
+// UserFromContext returns the identity of the authenticated user of the request from context.
+func UserFromContext(ctx context.Context) (string, bool) {
+	user, ok := ctx.Value(userKey).(string)
+	return user, ok
+}
+
+// Handler returns a handler rejecting requests which are not authenticated with 401 Unauthorized before passing them
+// to next. Requests for the given unauthenticated paths, e.g. probes, are always passed.
+func (a *Authenticator) Handler(next http.Handler, unauthenticatedPaths ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range unauthenticatedPaths {
+			if r.URL.Path == p {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		user, err := a.authenticate(r)
+		if err != nil {
+			level.Debug(a.logger).Log("msg", "rejected unauthenticated HTTP request", "path", r.URL.Path, "err", err)
+			if len(a.users) > 0 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="thanos"`)
+			}
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContextWithUser(r.Context(), user)))
+	})
+}
+
+// authenticate authenticates the request and returns the identity of its user: the user name for basic auth,
+// bearer-token-<n> for the n-th static bearer token, and the subject of JWT bearer tokens, which must not be expired.
+func (a *Authenticator) authenticate(r *http.Request) (string, error) {
+	if user, password, ok := r.BasicAuth(); ok {
+		if len(a.users) == 0 {
+			return "", errors.New("basic auth is not enabled")
+		}
+		return user, a.authenticateBasic(user, password)
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", errors.New("no credentials in request")
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	for i, t := range a.bearerTokens {
+		if subtle.ConstantTimeCompare(t, []byte(token)) == 1 {
+			return fmt.Sprintf("bearer-token-%d", i+1), nil
+		}
+	}
+	if a.jwt == nil {
+		return "", errors.New("invalid bearer token")
+	}
+	return a.jwt.validate(token)
+}
+
+func (a *Authenticator) authenticateBasic(user, password string) error {
+	hash, ok := a.users[user]
+	// Compare with a dummy hash for unknown users to not leak which users exist through the response time.
+	if !ok {
+		hash = []byte("$2a$10$SFTRF3VGddWiGjzoyH4zaOGa6X.gfpvKW15riSZdi46hzkVfnDnDy")
+	}
+
+	key := sha256.Sum256([]byte(user + ":" + password + ":" + string(hash)))
+	a.mtx.Lock()
+	_, cached := a.authCache[key]
+	a.mtx.Unlock()
+	if cached {
+		return nil
+	}
+
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !ok {
+		return errors.Errorf("invalid password for user %s", user)
+	}
+
+	a.mtx.Lock()
+	if len(a.authCache) >= maxBasicAuthCacheSize {
+		a.authCache = map[[sha256.Size]byte]struct{}{}
+	}
+	a.authCache[key] = struct{}{}
+	a.mtx.Unlock()
+	return nil
+}
+
+// jwtAuthenticator authenticates users by the subject of their JWT bearer token.
+type jwtAuthenticator struct {
+	validator *extjwt.Validator
+}
+
+// validate validates the token and returns its subject, which must be set.
+func (a *jwtAuthenticator) validate(token string) (string, error) {
+	claims, err := a.validator.Validate(token)
+	if err != nil {
+		return "", err
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return "", errors.New("invalid bearer token: missing sub claim")
+	}
+	return sub, nil
+}
@@ -0,0 +1,181 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestAuthenticator(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	testutil.Ok(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	testutil.Ok(t, err)
+
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testutil.Ok(t, json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key-1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		}))
+	}))
+	defer jwks.Close()
+
+	signClaims := func(key *rsa.PrivateKey, kid string, claims jwt.RegisteredClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = kid
+		s, err := token.SignedString(key)
+		testutil.Ok(t, err)
+		return s
+	}
+	signToken := func(key *rsa.PrivateKey, kid, issuer string) string {
+		return signClaims(key, kid, jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   "carol",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		})
+	}
+
+	// The password of alice is "secret".
+	a, err := NewAuthenticator(log.NewNopLogger(), []byte(fmt.Sprintf(`
+basic_auth_users:
+  alice: $2a$04$wvhsYqZjrZZ9d.P7fZrdQe1KxYmnn5LF.YCD4.bFYhvC3.OmGwoQi
+bearer_tokens:
+  - static-token
+jwt:
+  jwks_url: %s
+  issuer: thanos-test
+`, jwks.URL)))
+	testutil.Ok(t, err)
+
+	h := a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ := UserFromContext(r.Context())
+		_, _ = w.Write([]byte(user))
+	}), "/-/ready")
+
+	for _, tcase := range []struct {
+		name    string
+		path    string
+		setAuth func(r *http.Request)
+		expCode int
+		expUser string
+	}{
+		{name: "no credentials", expCode: http.StatusUnauthorized},
+		{name: "no credentials for unauthenticated path", path: "/-/ready", expCode: http.StatusOK},
+		{
+			name:    "valid basic auth",
+			setAuth: func(r *http.Request) { r.SetBasicAuth("alice", "secret") },
+			expCode: http.StatusOK,
+			expUser: "alice",
+		},
+		{
+			name:    "wrong password",
+			setAuth: func(r *http.Request) { r.SetBasicAuth("alice", "wrong") },
+			expCode: http.StatusUnauthorized,
+		},
+		{
+			name:    "unknown user",
+			setAuth: func(r *http.Request) { r.SetBasicAuth("bob", "secret") },
+			expCode: http.StatusUnauthorized,
+		},
+		{
+			name:    "valid static bearer token",
+			setAuth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer static-token") },
+			expCode: http.StatusOK,
+			expUser: "bearer-token-1",
+		},
+		{
+			name:    "invalid static bearer token",
+			setAuth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer other-token") },
+			expCode: http.StatusUnauthorized,
+		},
+		{
+			name:    "valid JWT",
+			setAuth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+signToken(key, "key-1", "thanos-test")) },
+			expCode: http.StatusOK,
+			expUser: "carol",
+		},
+		{
+			name:    "JWT with unexpected issuer",
+			setAuth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+signToken(key, "key-1", "other")) },
+			expCode: http.StatusUnauthorized,
+		},
+		{
+			name: "JWT signed with unknown key",
+			setAuth: func(r *http.Request) {
+				r.Header.Set("Authorization", "Bearer "+signToken(otherKey, "key-1", "thanos-test"))
+			},
+			expCode: http.StatusUnauthorized,
+		},
+		{
+			name: "JWT without expiration",
+			setAuth: func(r *http.Request) {
+				r.Header.Set("Authorization", "Bearer "+signClaims(key, "key-1", jwt.RegisteredClaims{Issuer: "thanos-test", Subject: "carol"}))
+			},
+			expCode: http.StatusUnauthorized,
+		},
+		{
+			name: "JWT without subject",
+			setAuth: func(r *http.Request) {
+				r.Header.Set("Authorization", "Bearer "+signClaims(key, "key-1", jwt.RegisteredClaims{
+					Issuer:    "thanos-test",
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				}))
+			},
+			expCode: http.StatusUnauthorized,
+		},
+		{
+			name:    "JWT with unknown key ID",
+			setAuth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+signToken(key, "key-2", "thanos-test")) },
+			expCode: http.StatusUnauthorized,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			path := tcase.path
+			if path == "" {
+				path = "/api/v1/query"
+			}
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			if tcase.setAuth != nil {
+				tcase.setAuth(r)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			testutil.Equals(t, tcase.expCode, w.Code)
+			if tcase.expCode == http.StatusOK {
+				testutil.Equals(t, tcase.expUser, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestNewAuthenticator_InvalidConfig(t *testing.T) {
+	for _, content := range []string{
+		``,
+		`unknown_field: true`,
+		`basic_auth_users: {alice: secret}`,
+		`bearer_tokens: [""]`,
+		`jwt: {issuer: thanos}`,
+	} {
+		_, err := NewAuthenticator(log.NewNopLogger(), []byte(content))
+		testutil.NotOk(t, err, content)
+	}
+}
@@ -10,14 +10,22 @@ import (
 	"time"
 
 	"github.com/oklog/ulid"
+	"github.com/opentracing/opentracing-go"
 )
 
+// RequestIDHeader is the HTTP header carrying the request id, which identifies a query across components.
+const RequestIDHeader = "X-Request-ID"
+
 type ctxKey int
 
-const reqIDKey = ctxKey(0)
+const (
+	reqIDKey  = ctxKey(0)
+	userKey   = ctxKey(1)
+	tenantKey = ctxKey(2)
+)
 
-// newContextWithRequestID creates a context with a request id.
-func newContextWithRequestID(ctx context.Context, rid string) context.Context {
+// NewContextWithRequestID creates a context with a request id.
+func NewContextWithRequestID(ctx context.Context, rid string) context.Context {
 	return context.WithValue(ctx, reqIDKey, rid)
 }
 
@@ -27,16 +35,21 @@ func RequestIDFromContext(ctx context.Context) (string, bool) {
 	return rid, ok
 }
 
-// RequestID sets a unique request id for each request.
+// RequestID sets a unique request id for each request, unless it already has one. The request id is returned in the
+// response header and tagged on the span of the request, if any.
 func RequestID(h http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		reqID := r.Header.Get("X-Request-ID")
+		reqID := r.Header.Get(RequestIDHeader)
 		if reqID == "" {
 			entropy := ulid.Monotonic(rand.New(rand.NewSource(time.Now().UnixNano())), 0)
-			reqID := ulid.MustNew(ulid.Timestamp(time.Now()), entropy)
-			r.Header.Set("X-Request-ID", reqID.String())
+			reqID = ulid.MustNew(ulid.Timestamp(time.Now()), entropy).String()
+			r.Header.Set(RequestIDHeader, reqID)
+		}
+		w.Header().Set(RequestIDHeader, reqID)
+		if span := opentracing.SpanFromContext(r.Context()); span != nil {
+			span.SetTag("request_id", reqID)
 		}
-		ctx := newContextWithRequestID(r.Context(), reqID)
+		ctx := NewContextWithRequestID(r.Context(), reqID)
 		h.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
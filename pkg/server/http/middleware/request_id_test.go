@@ -0,0 +1,68 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+)
+
+func TestRequestID(t *testing.T) {
+	var ctxID string
+	h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctxID, _ = RequestIDFromContext(r.Context())
+	}))
+
+	t.Run("generated", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/query", nil))
+		testutil.Assert(t, ctxID != "", "expected generated request id in context")
+		testutil.Equals(t, ctxID, w.Header().Get(RequestIDHeader))
+	})
+	t.Run("given", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/query", nil)
+		r.Header.Set(RequestIDHeader, "query-1")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		testutil.Equals(t, "query-1", ctxID)
+		testutil.Equals(t, "query-1", w.Header().Get(RequestIDHeader))
+	})
+}
+
+func TestTenantResolver(t *testing.T) {
+	var (
+		tenant string
+		header string
+		ok     bool
+	)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, ok = TenantFromContext(r.Context())
+		header = r.Header.Get("X-Scope-OrgID")
+	})
+
+	h := TenantResolver{Header: "X-Scope-OrgID"}.Handler(next)
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/query", nil)
+	r.Header.Set("X-Scope-OrgID", "team-a")
+	r.Header.Set(TenantHeader, "team-b")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	testutil.Equals(t, "team-a", tenant)
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/query", nil))
+	testutil.Assert(t, !ok, "expected no tenant in context")
+
+	// The tenant of authenticated users cannot be changed by the header, which is replaced by the user.
+	h = TenantResolver{Header: "X-Scope-OrgID", FromUser: true}.Handler(next)
+	r = httptest.NewRequest(http.MethodGet, "/api/v1/query", nil)
+	r.Header.Set("X-Scope-OrgID", "team-a")
+	h.ServeHTTP(httptest.NewRecorder(), r.WithContext(NewContextWithUser(r.Context(), "team-c")))
+	testutil.Equals(t, "team-c", tenant)
+	testutil.Equals(t, "team-c", header)
+
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	testutil.Assert(t, !ok, "expected no tenant in context without user")
+	testutil.Equals(t, "", header)
+}
@@ -0,0 +1,64 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package middleware
+
+import (
+	"context"
+	"net/http"
+)
+
+// TenantHeader is the HTTP header carrying the tenant of queries. It is the default tenant header of receivers, in
+// which rulers send the tenant of rule groups.
+const TenantHeader = "THANOS-TENANT"
+
+// NewContextWithTenant creates a context with the tenant of the request.
+func NewContextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey, tenant)
+}
+
+// TenantFromContext returns the tenant of the request from context.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey).(string)
+	return tenant, ok
+}
+
+// TenantResolver identifies the tenant of HTTP requests.
+type TenantResolver struct {
+	// Header is the header carrying the tenant. TenantHeader is used if empty.
+	Header string
+	// FromUser takes the tenant from the user authenticated by the Authenticator instead of the header, so that
+	// clients cannot claim the tenant of another user.
+	FromUser bool
+}
+
+// Tenant returns the tenant of the request, or an empty string if it has none.
+func (t TenantResolver) Tenant(r *http.Request) string {
+	if t.FromUser {
+		user, _ := UserFromContext(r.Context())
+		return user
+	}
+	return r.Header.Get(t.header())
+}
+
+func (t TenantResolver) header() string {
+	if t.Header == "" {
+		return TenantHeader
+	}
+	return t.Header
+}
+
+// Handler adds the tenant of the request, if any, to its context. The tenant header of the request is replaced by the
+// tenant, so that handlers forwarding the header downstream forward the tenant identified here.
+func (t TenantResolver) Handler(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenant := t.Tenant(r)
+		if tenant == "" {
+			r.Header.Del(t.header())
+		} else {
+			r.Header.Set(t.header(), tenant)
+			r = r.WithContext(NewContextWithTenant(r.Context(), tenant))
+		}
+		h.ServeHTTP(w, r)
+	}
+}
@@ -6,6 +6,8 @@ package http
 import (
 	"net/http"
 	"time"
+
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 )
 
 type options struct {
@@ -14,6 +16,8 @@ type options struct {
 	tlsConfigPath string
 	mux           *http.ServeMux
 	enableH2C     bool
+	authenticator *middleware.Authenticator
+	tenant        *middleware.TenantResolver
 }
 
 // Option overrides behavior of Server.
@@ -55,6 +59,20 @@ func WithEnableH2C(enableH2C bool) Option {
 	})
 }
 
+// WithAuthenticator sets the authenticator rejecting unauthenticated requests to all endpoints but the probes.
+func WithAuthenticator(a *middleware.Authenticator) Option {
+	return optionFunc(func(o *options) {
+		o.authenticator = a
+	})
+}
+
+// WithTenant sets the resolver adding the tenant of requests to their context.
+func WithTenant(t middleware.TenantResolver) Option {
+	return optionFunc(func(o *options) {
+		o.tenant = &t
+	})
+}
+
 // WithMux overrides the server's default mux.
 func WithMux(mux *http.ServeMux) Option {
 	return optionFunc(func(o *options) {
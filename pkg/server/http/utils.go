@@ -5,11 +5,12 @@ package http
 
 import "net/http"
 
-// ResponseWriterWithStatus wraps around http.ResponseWriter to capture the status code of the response.
+// ResponseWriterWithStatus wraps around http.ResponseWriter to capture the status code and size of the response.
 type ResponseWriterWithStatus struct {
 	http.ResponseWriter
 	status          int
 	isHeaderWritten bool
+	bytesWritten    int64
 }
 
 // WrapResponseWriterWithStatus wraps the http.ResponseWriter for extracting status.
@@ -30,3 +31,18 @@ func (r *ResponseWriterWithStatus) WriteHeader(code int) {
 		r.isHeaderWritten = true
 	}
 }
+
+// Write writes the data, and the header with status 200 if it was not written yet.
+func (r *ResponseWriterWithStatus) Write(b []byte) (int, error) {
+	if !r.isHeaderWritten {
+		r.WriteHeader(http.StatusOK)
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytesWritten += int64(n)
+	return n, err
+}
+
+// BytesWritten returns the number of bytes of the response body written so far.
+func (r *ResponseWriterWithStatus) BytesWritten() int64 {
+	return r.bytesWritten
+}
@@ -28,6 +28,7 @@ import (
 
 	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/extobjstore"
 	"github.com/thanos-io/thanos/pkg/runutil"
 )
 
@@ -37,6 +38,7 @@ type metrics struct {
 	uploads           prometheus.Counter
 	uploadFailures    prometheus.Counter
 	uploadedCompacted prometheus.Gauge
+	overlapCompacted  prometheus.Gauge
 }
 
 func newMetrics(reg prometheus.Registerer, uploadCompacted bool) *metrics {
@@ -62,10 +64,16 @@ func newMetrics(reg prometheus.Registerer, uploadCompacted bool) *metrics {
 		Name: "thanos_shipper_upload_compacted_done",
 		Help: "If 1 it means shipper uploaded all compacted blocks from the filesystem.",
 	}
+	overlapCompactedGaugeOpts := prometheus.GaugeOpts{
+		Name: "thanos_shipper_overlapping_compacted_blocks",
+		Help: "Number of compacted blocks from the filesystem skipped in the last sync, as they overlap blocks in the bucket.",
+	}
 	if uploadCompacted {
 		m.uploadedCompacted = promauto.With(reg).NewGauge(uploadCompactedGaugeOpts)
+		m.overlapCompacted = promauto.With(reg).NewGauge(overlapCompactedGaugeOpts)
 	} else {
 		m.uploadedCompacted = promauto.With(nil).NewGauge(uploadCompactedGaugeOpts)
+		m.overlapCompacted = promauto.With(nil).NewGauge(overlapCompactedGaugeOpts)
 	}
 	return &m
 }
@@ -80,14 +88,52 @@ type Shipper struct {
 	labels  func() labels.Labels
 	source  metadata.SourceType
 
-	uploadCompacted        bool
-	allowOutOfOrderUploads bool
-	hashFunc               metadata.HashFunc
+	uploadCompacted          bool
+	skipOverlappingCompacted bool
+	allowOutOfOrderUploads   bool
+	hashFunc                 metadata.HashFunc
+	uploadConcurrency        int
+	uploadBytesPerSecond     int64
+
+	// fetcher caches the metas of the blocks of the bucket for LabelCollisions.
+	fetcher *block.MetaFetcher
+}
+
+// Option configures optional behaviour of the Shipper.
+type Option func(s *Shipper)
+
+// WithUploadCompacted makes the shipper also upload compacted blocks which are already in filesystem. Compacted blocks
+// overlapping blocks in the bucket with the same labels stop the sync, or are skipped if skipOverlapping is true.
+func WithUploadCompacted(upload, skipOverlapping bool) Option {
+	return func(s *Shipper) {
+		s.uploadCompacted = upload
+		s.skipOverlappingCompacted = skipOverlapping
+	}
+}
+
+// WithAllowOutOfOrderUploads allows blocks to be uploaded out of order.
+func WithAllowOutOfOrderUploads(allow bool) Option {
+	return func(s *Shipper) {
+		s.allowOutOfOrderUploads = allow
+	}
+}
+
+// WithUploadConcurrency sets the number of goroutines uploading the files of a block. Defaults to 1.
+func WithUploadConcurrency(concurrency int) Option {
+	return func(s *Shipper) {
+		s.uploadConcurrency = concurrency
+	}
+}
+
+// WithUploadBandwidthLimit limits the bytes uploaded per second. Non-positive values disable the limit.
+func WithUploadBandwidthLimit(bytesPerSecond int64) Option {
+	return func(s *Shipper) {
+		s.uploadBytesPerSecond = bytesPerSecond
+	}
 }
 
 // New creates a new shipper that detects new TSDB blocks in dir and uploads them to
 // remote if necessary. It attaches the Thanos metadata section in each meta JSON file.
-// If uploadCompacted is enabled, it also uploads compacted blocks which are already in filesystem.
 func New(
 	logger log.Logger,
 	r prometheus.Registerer,
@@ -95,9 +141,8 @@ func New(
 	bucket objstore.Bucket,
 	lbls func() labels.Labels,
 	source metadata.SourceType,
-	uploadCompacted bool,
-	allowOutOfOrderUploads bool,
 	hashFunc metadata.HashFunc,
+	opts ...Option,
 ) *Shipper {
 	if logger == nil {
 		logger = log.NewNopLogger()
@@ -106,17 +151,23 @@ func New(
 		lbls = func() labels.Labels { return nil }
 	}
 
-	return &Shipper{
-		logger:                 logger,
-		dir:                    dir,
-		bucket:                 bucket,
-		labels:                 lbls,
-		metrics:                newMetrics(r, uploadCompacted),
-		source:                 source,
-		allowOutOfOrderUploads: allowOutOfOrderUploads,
-		uploadCompacted:        uploadCompacted,
-		hashFunc:               hashFunc,
+	s := &Shipper{
+		logger:            logger,
+		dir:               dir,
+		bucket:            bucket,
+		labels:            lbls,
+		source:            source,
+		hashFunc:          hashFunc,
+		uploadConcurrency: 1,
+	}
+	for _, o := range opts {
+		o(s)
 	}
+	if s.uploadBytesPerSecond > 0 {
+		s.bucket = extobjstore.WrapWithUploadBandwidthLimit(s.bucket, s.uploadBytesPerSecond)
+	}
+	s.metrics = newMetrics(r, s.uploadCompacted)
+	return s
 }
 
 // Timestamps returns the minimum timestamp for which data is available and the highest timestamp
@@ -155,6 +206,36 @@ func (s *Shipper) Timestamps() (minTime, maxSyncTime int64, err error) {
 	return minTime, maxSyncTime, nil
 }
 
+// UploadedUntil returns the time before which all the data of the local blocks was uploaded: the min time of the
+// oldest block not uploaded yet, or the max time of the uploaded blocks if all were uploaded. It returns math.MinInt64
+// if no block was uploaded. Contrary to the max time returned by Timestamps, it does not advance past a block whose
+// upload failed or is pending when newer blocks were uploaded, e.g. with out of order uploads.
+func (s *Shipper) UploadedUntil() (int64, error) {
+	meta, err := ReadMetaFile(s.dir)
+	if err != nil {
+		return 0, errors.Wrap(err, "read shipper meta file")
+	}
+	hasUploaded := make(map[ulid.ULID]struct{}, len(meta.Uploaded))
+	for _, id := range meta.Uploaded {
+		hasUploaded[id] = struct{}{}
+	}
+
+	metas, err := s.blockMetasFromOldest()
+	if err != nil {
+		return 0, err
+	}
+	until := int64(math.MinInt64)
+	for _, m := range metas {
+		if _, ok := hasUploaded[m.ULID]; !ok {
+			return m.MinTime, nil
+		}
+		if m.MaxTime > until {
+			until = m.MaxTime
+		}
+	}
+	return until, nil
+}
+
 type lazyOverlapChecker struct {
 	synced bool
 	logger log.Logger
@@ -203,6 +284,19 @@ func (c *lazyOverlapChecker) sync(ctx context.Context) error {
 	return nil
 }
 
+// add adds the given block uploaded to the bucket, so that blocks shipped afterwards are checked against it as well.
+func (c *lazyOverlapChecker) add(m tsdb.BlockMeta) {
+	if !c.synced {
+		// It will be gathered from the bucket on the first check.
+		return
+	}
+	if _, ok := c.lookupMetas[m.ULID]; ok {
+		return
+	}
+	c.metas = append(c.metas, m)
+	c.lookupMetas[m.ULID] = struct{}{}
+}
+
 func (c *lazyOverlapChecker) IsOverlapping(ctx context.Context, newMeta tsdb.BlockMeta) error {
 	if !c.synced {
 		level.Info(c.logger).Log("msg", "gathering all existing blocks from the remote bucket for check", "id", newMeta.ULID.String())
@@ -253,6 +347,7 @@ func (s *Shipper) Sync(ctx context.Context) (uploaded int, err error) {
 	var (
 		checker    = newLazyOverlapChecker(s.logger, s.bucket, s.labels)
 		uploadErrs int
+		overlaps   int
 	)
 
 	metas, err := s.blockMetasFromOldest()
@@ -293,7 +388,14 @@ func (s *Shipper) Sync(ctx context.Context) (uploaded int, err error) {
 		// Skip overlap check if out of order uploads is enabled.
 		if m.Compaction.Level > 1 && !s.allowOutOfOrderUploads {
 			if err := checker.IsOverlapping(ctx, m.BlockMeta); err != nil {
-				return 0, errors.Errorf("Found overlap or error during sync, cannot upload compacted block, details: %v", err)
+				// Errors gathering the blocks of the bucket still stop the sync.
+				if !s.skipOverlappingCompacted || !checker.synced {
+					return 0, errors.Errorf("Found overlap or error during sync, cannot upload compacted block, details: %v", err)
+				}
+				// Keep the block locally, it is checked again on the next sync.
+				level.Warn(s.logger).Log("msg", "skipping compacted block overlapping blocks in the bucket", "block", m.ULID, "err", err)
+				overlaps++
+				continue
 			}
 		}
 
@@ -309,6 +411,7 @@ func (s *Shipper) Sync(ctx context.Context) (uploaded int, err error) {
 			continue
 		}
 		meta.Uploaded = append(meta.Uploaded, m.ULID)
+		checker.add(m.BlockMeta)
 		uploaded++
 		s.metrics.uploads.Inc()
 	}
@@ -323,11 +426,78 @@ func (s *Shipper) Sync(ctx context.Context) (uploaded int, err error) {
 	}
 
 	if s.uploadCompacted {
-		s.metrics.uploadedCompacted.Set(1)
+		s.metrics.overlapCompacted.Set(float64(overlaps))
+		if overlaps == 0 {
+			s.metrics.uploadedCompacted.Set(1)
+		} else {
+			s.metrics.uploadedCompacted.Set(0)
+		}
 	}
 	return uploaded, nil
 }
 
+// LabelCollisions returns the uncompacted blocks of the bucket with the external labels of the shipper overlapping the
+// blocks in the directory, that were not uploaded from it. Such blocks are uploaded by another uploader with the same
+// external labels, which results in overlapping blocks for the compactor. Blocks uploaded from the directory are
+// recognized by the sources of the blocks in it, which include the blocks compacted into them locally. The metas of
+// the blocks of the bucket are cached, so that only the metas of new blocks are downloaded by later calls.
+func (s *Shipper) LabelCollisions(ctx context.Context) ([]ulid.ULID, error) {
+	metas, err := s.blockMetasFromOldest()
+	if err != nil {
+		return nil, err
+	}
+	if len(metas) == 0 {
+		return nil, nil
+	}
+	own := make(map[ulid.ULID]struct{}, len(metas))
+	for _, m := range metas {
+		own[m.ULID] = struct{}{}
+		for _, id := range m.Compaction.Sources {
+			own[id] = struct{}{}
+		}
+	}
+	if meta, err := ReadMetaFile(s.dir); err == nil {
+		for _, id := range meta.Uploaded {
+			own[id] = struct{}{}
+		}
+	}
+
+	if s.fetcher == nil {
+		if s.fetcher, err = block.NewMetaFetcher(s.logger, block.FetcherConcurrency, objstore.WithNoopInstr(s.bucket), "", nil, nil); err != nil {
+			return nil, errors.Wrap(err, "create meta fetcher")
+		}
+	}
+	bucketMetas, _, err := s.fetcher.Fetch(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch metas of bucket blocks")
+	}
+
+	var (
+		lset       = s.labels()
+		collisions []ulid.ULID
+	)
+	for id, m := range bucketMetas {
+		if _, ok := own[id]; ok {
+			continue
+		}
+		// Blocks are created after their data, so blocks created before the oldest local block cannot overlap it.
+		if int64(id.Time()) < metas[0].MinTime {
+			continue
+		}
+		if m.Compaction.Level > 1 || m.Thanos.Downsample.Resolution > 0 || !labels.Equal(labels.FromMap(m.Thanos.Labels), lset) {
+			continue
+		}
+		for _, local := range metas {
+			if m.MinTime < local.MaxTime && local.MinTime < m.MaxTime {
+				collisions = append(collisions, id)
+				break
+			}
+		}
+	}
+	sort.Slice(collisions, func(i, j int) bool { return collisions[i].Compare(collisions[j]) < 0 })
+	return collisions, nil
+}
+
 // sync uploads the block if not exists in remote storage.
 // TODO(khyatisoneji): Double check if block does not have deletion-mark.json for some reason, otherwise log it or return error.
 func (s *Shipper) upload(ctx context.Context, meta *metadata.Meta) error {
@@ -363,7 +533,8 @@ func (s *Shipper) upload(ctx context.Context, meta *metadata.Meta) error {
 	if err := meta.WriteToDir(s.logger, updir); err != nil {
 		return errors.Wrap(err, "write meta file")
 	}
-	return block.Upload(ctx, s.logger, s.bucket, updir, s.hashFunc)
+	// Blocks are not cleaned up on failure, their upload is resumed on the next sync.
+	return block.UploadResumable(ctx, s.logger, s.bucket, updir, s.hashFunc, s.uploadConcurrency)
 }
 
 // blockMetasFromOldest returns the block meta of each block found in dir
@@ -42,7 +42,7 @@ func TestShipper_SyncBlocks_e2e(t *testing.T) {
 		dir := t.TempDir()
 
 		extLset := labels.FromStrings("prometheus", "prom-1")
-		shipper := New(log.NewLogfmtLogger(os.Stderr), nil, dir, metricsBucket, func() labels.Labels { return extLset }, metadata.TestSource, false, false, metadata.NoneFunc)
+		shipper := New(log.NewLogfmtLogger(os.Stderr), nil, dir, metricsBucket, func() labels.Labels { return extLset }, metadata.TestSource, metadata.NoneFunc)
 
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
@@ -121,11 +121,11 @@ func TestShipper_SyncBlocks_e2e(t *testing.T) {
 				maxSyncSoFar = meta.MaxTime
 				testutil.Equals(t, 1, b)
 			} else {
-				// 5 blocks uploaded so far - 5 existence checks & 25 uploads (5 files each).
+				// 5 blocks uploaded so far - 5 existence checks, 30 size checks (3 files each, before and after upload) & 20 uploads (4 files each).
 				testutil.Ok(t, promtest.GatherAndCompare(metrics, strings.NewReader(`
 				# HELP thanos_objstore_bucket_operations_total Total number of all attempted operations against a bucket.
 				# TYPE thanos_objstore_bucket_operations_total counter
-				thanos_objstore_bucket_operations_total{bucket="test",operation="attributes"} 0
+				thanos_objstore_bucket_operations_total{bucket="test",operation="attributes"} 30
 				thanos_objstore_bucket_operations_total{bucket="test",operation="delete"} 0
 				thanos_objstore_bucket_operations_total{bucket="test",operation="exists"} 5
 				thanos_objstore_bucket_operations_total{bucket="test",operation="get"} 0
@@ -217,7 +217,7 @@ func TestShipper_SyncBlocksWithMigrating_e2e(t *testing.T) {
 		defer upcancel2()
 		testutil.Ok(t, p.WaitPrometheusUp(upctx2, logger))
 
-		shipper := New(log.NewLogfmtLogger(os.Stderr), nil, dir, bkt, func() labels.Labels { return extLset }, metadata.TestSource, true, false, metadata.NoneFunc)
+		shipper := New(log.NewLogfmtLogger(os.Stderr), nil, dir, bkt, func() labels.Labels { return extLset }, metadata.TestSource, metadata.NoneFunc, WithUploadCompacted(true, false))
 
 		// Create 10 new blocks. 9 of them (non compacted) should be actually uploaded.
 		var (
@@ -373,7 +373,7 @@ func TestShipper_SyncOverlapBlocks_e2e(t *testing.T) {
 	testutil.Ok(t, p.WaitPrometheusUp(upctx2, logger))
 
 	// Here, the allowOutOfOrderUploads flag is set to true, which allows blocks with overlaps to be uploaded.
-	shipper := New(log.NewLogfmtLogger(os.Stderr), nil, dir, bkt, func() labels.Labels { return extLset }, metadata.TestSource, true, true, metadata.NoneFunc)
+	shipper := New(log.NewLogfmtLogger(os.Stderr), nil, dir, bkt, func() labels.Labels { return extLset }, metadata.TestSource, metadata.NoneFunc, WithUploadCompacted(true, false), WithAllowOutOfOrderUploads(true))
 
 	// Creating 2 overlapping blocks - both uploaded when OOO uploads allowed.
 	var (
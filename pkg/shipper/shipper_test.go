@@ -6,16 +6,19 @@ package shipper
 import (
 	"context"
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
 	"os"
 	"path"
 	"path/filepath"
 	"sort"
+	"sync"
 	"testing"
 
 	"github.com/go-kit/log"
 	"github.com/oklog/ulid"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/tsdb"
 
@@ -29,7 +32,7 @@ import (
 func TestShipperTimestamps(t *testing.T) {
 	dir := t.TempDir()
 
-	s := New(nil, nil, dir, nil, nil, metadata.TestSource, false, false, metadata.NoneFunc)
+	s := New(nil, nil, dir, nil, nil, metadata.TestSource, metadata.NoneFunc)
 
 	// Missing thanos meta file.
 	_, _, err := s.Timestamps()
@@ -84,6 +87,29 @@ func TestShipperTimestamps(t *testing.T) {
 	testutil.Ok(t, err)
 	testutil.Equals(t, int64(1000), mint)
 	testutil.Equals(t, int64(2000), maxt)
+
+	until, err := s.UploadedUntil()
+	testutil.Ok(t, err)
+	testutil.Equals(t, int64(2000), until)
+
+	// The newer block uploaded out of order does not advance the time all data was uploaded until.
+	meta = &Meta{
+		Version:  MetaVersion1,
+		Uploaded: []ulid.ULID{id2},
+	}
+	testutil.Ok(t, WriteMetaFile(log.NewNopLogger(), dir, meta))
+	_, maxt, err = s.Timestamps()
+	testutil.Ok(t, err)
+	testutil.Equals(t, int64(4000), maxt)
+	until, err = s.UploadedUntil()
+	testutil.Ok(t, err)
+	testutil.Equals(t, int64(1000), until)
+
+	meta.Uploaded = []ulid.ULID{id1, id2}
+	testutil.Ok(t, WriteMetaFile(log.NewNopLogger(), dir, meta))
+	until, err = s.UploadedUntil()
+	testutil.Ok(t, err)
+	testutil.Equals(t, int64(4000), until)
 }
 
 func TestIterBlockMetas(t *testing.T) {
@@ -122,7 +148,7 @@ func TestIterBlockMetas(t *testing.T) {
 		},
 	}.WriteToDir(log.NewNopLogger(), path.Join(dir, id3.String())))
 
-	shipper := New(nil, nil, dir, nil, nil, metadata.TestSource, false, false, metadata.NoneFunc)
+	shipper := New(nil, nil, dir, nil, nil, metadata.TestSource, metadata.NoneFunc)
 	metas, err := shipper.blockMetasFromOldest()
 	testutil.Ok(t, err)
 	testutil.Equals(t, sort.SliceIsSorted(metas, func(i, j int) bool {
@@ -153,7 +179,7 @@ func BenchmarkIterBlockMetas(b *testing.B) {
 	})
 	b.ResetTimer()
 
-	shipper := New(nil, nil, dir, nil, nil, metadata.TestSource, false, false, metadata.NoneFunc)
+	shipper := New(nil, nil, dir, nil, nil, metadata.TestSource, metadata.NoneFunc)
 
 	_, err := shipper.blockMetasFromOldest()
 	testutil.Ok(b, err)
@@ -165,7 +191,7 @@ func TestShipperAddsSegmentFiles(t *testing.T) {
 	inmemory := objstore.NewInMemBucket()
 
 	lbls := []labels.Label{{Name: "test", Value: "test"}}
-	s := New(nil, nil, dir, inmemory, func() labels.Labels { return lbls }, metadata.TestSource, false, false, metadata.NoneFunc)
+	s := New(nil, nil, dir, inmemory, func() labels.Labels { return lbls }, metadata.TestSource, metadata.NoneFunc)
 
 	id := ulid.MustNew(1, nil)
 	blockDir := path.Join(dir, id.String())
@@ -198,6 +224,179 @@ func TestShipperAddsSegmentFiles(t *testing.T) {
 	testutil.Equals(t, []string{segmentFile}, meta.Thanos.SegmentFiles)
 }
 
+func TestShipperSkipsOverlappingCompactedBlocks(t *testing.T) {
+	ctx := context.Background()
+	lbls := labels.FromStrings("test", "test")
+
+	var (
+		bucketBlock       = ulid.MustNew(1, nil)
+		otherLabelsBlock  = ulid.MustNew(2, nil)
+		overlappingBucket = ulid.MustNew(3, nil)
+		compacted         = ulid.MustNew(4, nil)
+		overlappingLocal  = ulid.MustNew(5, nil)
+		uncompacted       = ulid.MustNew(6, nil)
+	)
+	setup := func() (string, *objstore.InMemBucket) {
+		dir := t.TempDir()
+		bkt := objstore.NewInMemBucket()
+
+		bktDir := t.TempDir()
+		writeTestBlock(t, bktDir, bucketBlock, 1000, 3000, 1, lbls)
+		writeTestBlock(t, bktDir, otherLabelsBlock, 6000, 8000, 1, labels.FromStrings("test", "other"))
+		for _, id := range []ulid.ULID{bucketBlock, otherLabelsBlock} {
+			testutil.Ok(t, block.Upload(ctx, log.NewNopLogger(), bkt, filepath.Join(bktDir, id.String()), metadata.NoneFunc))
+		}
+
+		writeTestBlock(t, dir, overlappingBucket, 2000, 4000, 2, nil)
+		writeTestBlock(t, dir, compacted, 4000, 7000, 2, nil)
+		writeTestBlock(t, dir, overlappingLocal, 6000, 8000, 2, nil)
+		writeTestBlock(t, dir, uncompacted, 8000, 9000, 1, nil)
+		return dir, bkt
+	}
+
+	t.Run("abort", func(t *testing.T) {
+		dir, bkt := setup()
+		s := New(nil, nil, dir, bkt, func() labels.Labels { return lbls }, metadata.TestSource, metadata.NoneFunc, WithUploadCompacted(true, false))
+
+		_, err := s.Sync(ctx)
+		testutil.NotOk(t, err)
+		exists, err := bkt.Exists(ctx, path.Join(uncompacted.String(), block.MetaFilename))
+		testutil.Ok(t, err)
+		testutil.Assert(t, !exists)
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		dir, bkt := setup()
+		s := New(nil, nil, dir, bkt, func() labels.Labels { return lbls }, metadata.TestSource, metadata.NoneFunc, WithUploadCompacted(true, true))
+
+		for i := 0; i < 2; i++ {
+			uploaded, err := s.Sync(ctx)
+			testutil.Ok(t, err)
+			if i == 0 {
+				testutil.Equals(t, 2, uploaded)
+			} else {
+				testutil.Equals(t, 0, uploaded)
+			}
+
+			// Blocks overlapping the bucket, or blocks uploaded before them in the same sync, are kept locally.
+			for id, exp := range map[ulid.ULID]bool{overlappingBucket: false, compacted: true, overlappingLocal: false, uncompacted: true} {
+				exists, err := bkt.Exists(ctx, path.Join(id.String(), block.MetaFilename))
+				testutil.Ok(t, err)
+				testutil.Equals(t, exp, exists, id.String())
+			}
+			testutil.Equals(t, 2.0, promtestutil.ToFloat64(s.metrics.overlapCompacted))
+			testutil.Equals(t, 0.0, promtestutil.ToFloat64(s.metrics.uploadedCompacted))
+		}
+	})
+}
+
+func TestShipperLabelCollisions(t *testing.T) {
+	ctx := context.Background()
+	lbls := labels.FromStrings("test", "test")
+	dir := t.TempDir()
+	bkt := objstore.NewInMemBucket()
+
+	// Blocks are created after their data, as reflected by the time of their ULID.
+	var (
+		local       = ulid.MustNew(3000, nil)
+		uploaded    = ulid.MustNew(3001, nil)
+		other       = ulid.MustNew(3002, nil)
+		otherLabels = ulid.MustNew(3003, nil)
+		compacted   = ulid.MustNew(3004, nil)
+		adjacent    = ulid.MustNew(3005, nil)
+		beforeLocal = ulid.MustNew(500, nil)
+	)
+	writeTestBlock(t, dir, local, 1000, 3000, 1, nil)
+	countingBkt := &metaCountingBucket{Bucket: bkt, gets: map[string]int{}}
+	s := New(nil, nil, dir, countingBkt, func() labels.Labels { return lbls }, metadata.TestSource, metadata.NoneFunc)
+
+	collisions, err := s.LabelCollisions(ctx)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(collisions))
+
+	_, err = s.Sync(ctx)
+	testutil.Ok(t, err)
+
+	bktDir := t.TempDir()
+	writeTestBlock(t, bktDir, other, 2000, 4000, 1, lbls)
+	writeTestBlock(t, bktDir, otherLabels, 1000, 3000, 1, labels.FromStrings("test", "other"))
+	writeTestBlock(t, bktDir, compacted, 0, 4000, 2, lbls)
+	writeTestBlock(t, bktDir, adjacent, 3000, 5000, 1, lbls)
+	// Not even checked, as it was created before the data of the local block.
+	writeTestBlock(t, bktDir, beforeLocal, 1000, 3000, 1, lbls)
+	for _, id := range []ulid.ULID{other, otherLabels, compacted, adjacent, beforeLocal} {
+		testutil.Ok(t, block.Upload(ctx, log.NewNopLogger(), bkt, filepath.Join(bktDir, id.String()), metadata.NoneFunc))
+	}
+
+	// The block uploaded from the directory was removed locally since.
+	writeTestBlock(t, bktDir, uploaded, 1000, 3000, 1, lbls)
+	testutil.Ok(t, block.Upload(ctx, log.NewNopLogger(), bkt, filepath.Join(bktDir, uploaded.String()), metadata.NoneFunc))
+	testutil.Ok(t, WriteMetaFile(log.NewNopLogger(), dir, &Meta{Version: MetaVersion1, Uploaded: []ulid.ULID{local, uploaded}}))
+
+	collisions, err = s.LabelCollisions(ctx)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []ulid.ULID{other}, collisions)
+
+	// Blocks uploaded from the directory and compacted locally since are recognized by the sources of the local
+	// blocks, even once they are not listed as uploaded anymore.
+	var (
+		uploadedSource    = ulid.MustNew(6000, nil)
+		compactedLocally  = ulid.MustNew(7000, nil)
+		compactedMetaPath = filepath.Join(dir, compactedLocally.String())
+	)
+	writeTestBlock(t, bktDir, uploadedSource, 5000, 6000, 1, lbls)
+	testutil.Ok(t, block.Upload(ctx, log.NewNopLogger(), bkt, filepath.Join(bktDir, uploadedSource.String()), metadata.NoneFunc))
+	writeTestBlock(t, dir, compactedLocally, 5000, 7000, 2, nil)
+	m, err := metadata.ReadFromDir(compactedMetaPath)
+	testutil.Ok(t, err)
+	m.Compaction.Sources = []ulid.ULID{uploadedSource, compactedLocally}
+	testutil.Ok(t, m.WriteToDir(log.NewNopLogger(), compactedMetaPath))
+	testutil.Ok(t, WriteMetaFile(log.NewNopLogger(), dir, &Meta{Version: MetaVersion1, Uploaded: []ulid.ULID{local, uploaded}}))
+
+	collisions, err = s.LabelCollisions(ctx)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []ulid.ULID{other}, collisions)
+
+	// The metas of the blocks of the bucket are only downloaded once.
+	for name, n := range countingBkt.gets {
+		testutil.Equals(t, 1, n, name)
+	}
+}
+
+// metaCountingBucket counts the downloads of every object.
+type metaCountingBucket struct {
+	objstore.Bucket
+
+	mtx  sync.Mutex
+	gets map[string]int
+}
+
+func (b *metaCountingBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	b.mtx.Lock()
+	b.gets[name]++
+	b.mtx.Unlock()
+	return b.Bucket.Get(ctx, name)
+}
+
+// writeTestBlock writes a minimal block for the shipper to the given directory.
+func writeTestBlock(t *testing.T, dir string, id ulid.ULID, mint, maxt int64, level int, lset labels.Labels) {
+	blockDir := filepath.Join(dir, id.String())
+	testutil.Ok(t, os.MkdirAll(filepath.Join(blockDir, block.ChunksDirname), os.ModePerm))
+	testutil.Ok(t, metadata.Meta{
+		BlockMeta: tsdb.BlockMeta{
+			ULID:       id,
+			MinTime:    mint,
+			MaxTime:    maxt,
+			Version:    1,
+			Stats:      tsdb.BlockStats{NumSamples: 1000},
+			Compaction: tsdb.BlockMetaCompaction{Level: level},
+		},
+		Thanos: metadata.Thanos{Labels: lset.Map()},
+	}.WriteToDir(log.NewNopLogger(), blockDir))
+	testutil.Ok(t, os.WriteFile(filepath.Join(blockDir, block.IndexFilename), []byte("index file"), 0666))
+	testutil.Ok(t, os.WriteFile(filepath.Join(blockDir, block.ChunksDirname, "000001"), []byte("chunks"), 0666))
+}
+
 func TestReadMetaFile(t *testing.T) {
 	t.Run("Missing meta file", func(t *testing.T) {
 		// Create TSDB directory without meta file
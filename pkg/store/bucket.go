@@ -56,6 +56,7 @@ import (
 	"github.com/thanos-io/thanos/pkg/model"
 	"github.com/thanos-io/thanos/pkg/pool"
 	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 	storecache "github.com/thanos-io/thanos/pkg/store/cache"
 	"github.com/thanos-io/thanos/pkg/store/hintspb"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
@@ -127,6 +128,7 @@ type bucketStoreMetrics struct {
 	seriesDataSizeTouched *prometheus.HistogramVec
 	seriesDataSizeFetched *prometheus.HistogramVec
 	seriesBlocksQueried   prometheus.Histogram
+	coldBlocksQueried     prometheus.Counter
 	seriesGetAllDuration  prometheus.Histogram
 	seriesMergeDuration   prometheus.Histogram
 	resultSeriesCount     prometheus.Histogram
@@ -202,6 +204,10 @@ func newBucketStoreMetrics(reg prometheus.Registerer) *bucketStoreMetrics {
 		Help:    "Number of blocks in a bucket store that were touched to satisfy a query.",
 		Buckets: prometheus.ExponentialBuckets(1, 2, 10),
 	})
+	m.coldBlocksQueried = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_bucket_store_cold_blocks_queried_total",
+		Help: "Total number of times blocks with a cold storage class were touched to satisfy a query.",
+	})
 	m.seriesGetAllDuration = promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
 		Name:    "thanos_bucket_store_series_get_all_duration_seconds",
 		Help:    "Time it takes until all per-block prepares and loads for a query are finished.",
@@ -345,6 +351,11 @@ type BucketStore struct {
 	// bytesLimiterFactory creates a new limiter used to limit the amount of bytes fetched/touched by each Series() call.
 	bytesLimiterFactory BytesLimiterFactory
 	partitioner         Partitioner
+	// tenantSelectLimits, if set, returns the series and samples limits of the tenant of a request, replacing the
+	// limits of chunksLimiterFactory and seriesLimiterFactory.
+	tenantSelectLimits func(tenant string) SeriesSelectLimits
+	// postingsS2Encoding, if set, returns whether postings stored in the index cache are compressed with S2.
+	postingsS2Encoding func() bool
 
 	filterConfig             *FilterConfig
 	advLabelSets             []labelpb.ZLabelSet
@@ -442,6 +453,22 @@ func WithSeriesBatchSize(seriesBatchSize int) BucketStoreOption {
 	}
 }
 
+// WithTenantSeriesSelectLimits sets the series and samples limits of requests by their tenant, instead of the limits
+// of the chunks and series limiter factories. The tenant of requests without tenant is empty.
+func WithTenantSeriesSelectLimits(limits func(tenant string) SeriesSelectLimits) BucketStoreOption {
+	return func(s *BucketStore) {
+		s.tenantSelectLimits = limits
+	}
+}
+
+// WithPostingsS2Encoding compresses the postings stored in the index cache with S2 instead of Snappy while the given
+// function returns true. The result is readable by stores using Snappy.
+func WithPostingsS2Encoding(enabled func() bool) BucketStoreOption {
+	return func(s *BucketStore) {
+		s.postingsS2Encoding = enabled
+	}
+}
+
 // NewBucketStore creates a new bucket backed store that implements the store API against
 // an object store bucket. It is optimized to work against high latency backends.
 func NewBucketStore(
@@ -693,6 +720,7 @@ func (s *BucketStore) addBlock(ctx context.Context, meta *metadata.Meta) (err er
 	if err != nil {
 		return errors.Wrap(err, "new bucket block")
 	}
+	b.postingsS2Encoding = s.postingsS2Encoding
 	defer func() {
 		if err != nil {
 			runutil.CloseWithErrCapture(&err, b, "index-header")
@@ -822,6 +850,27 @@ func (s *BucketStore) limitMaxTime(maxt int64) int64 {
 	return maxt
 }
 
+// chunksLimiter returns the chunks limiter of a request with the given context.
+func (s *BucketStore) chunksLimiter(ctx context.Context) ChunksLimiter {
+	failedCounter := s.metrics.queriesDropped.WithLabelValues("chunks")
+	if s.tenantSelectLimits == nil {
+		return s.chunksLimiterFactory(failedCounter)
+	}
+	tenant, _ := middleware.TenantFromContext(ctx)
+	// The samples limit is an approximation based on the max number of samples per chunk.
+	return NewLimiter(s.tenantSelectLimits(tenant).SamplesPerRequest/MaxSamplesPerChunk, failedCounter)
+}
+
+// seriesLimiter returns the series limiter of a request with the given context.
+func (s *BucketStore) seriesLimiter(ctx context.Context) SeriesLimiter {
+	failedCounter := s.metrics.queriesDropped.WithLabelValues("series")
+	if s.tenantSelectLimits == nil {
+		return s.seriesLimiterFactory(failedCounter)
+	}
+	tenant, _ := middleware.TenantFromContext(ctx)
+	return NewLimiter(s.tenantSelectLimits(tenant).SeriesPerRequest, failedCounter)
+}
+
 type seriesEntry struct {
 	lset labels.Labels
 	refs []chunks.ChunkRef
@@ -1198,12 +1247,13 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, srv storepb.Store_Serie
 		ctx              = srv.Context()
 		stats            = &queryStats{}
 		respSets         []respSet
+		coldBlocks       []string
 		mtx              sync.Mutex
 		g, gctx          = errgroup.WithContext(ctx)
 		resHints         = &hintspb.SeriesResponseHints{}
 		reqBlockMatchers []*labels.Matcher
-		chunksLimiter    = s.chunksLimiterFactory(s.metrics.queriesDropped.WithLabelValues("chunks"))
-		seriesLimiter    = s.seriesLimiterFactory(s.metrics.queriesDropped.WithLabelValues("series"))
+		chunksLimiter    = s.chunksLimiter(ctx)
+		seriesLimiter    = s.seriesLimiter(ctx)
 	)
 
 	if req.Hints != nil {
@@ -1247,6 +1297,9 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, srv storepb.Store_Serie
 				// Keep track of queried blocks.
 				resHints.AddQueriedBlock(blk.meta.ULID)
 			}
+			if sc := blk.meta.Thanos.StorageClass; sc != nil && sc.Cold {
+				coldBlocks = append(coldBlocks, blk.meta.ULID.String())
+			}
 
 			shardMatcher := req.ShardInfo.Matcher(&s.buffers)
 
@@ -1398,6 +1451,18 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, srv storepb.Store_Serie
 		return err
 	}
 
+	if len(coldBlocks) > 0 {
+		s.metrics.coldBlocksQueried.Add(float64(len(coldBlocks)))
+		// Warnings abort queries without partial response, which would fail all queries of cold blocks.
+		if !req.PartialResponseDisabled && req.PartialResponseStrategy != storepb.PartialResponseStrategy_ABORT {
+			warn := errors.Errorf("query touched %d blocks in a cold storage class, which may be slow and costly to read: %s", len(coldBlocks), strings.Join(coldBlocks, ", "))
+			if err = srv.Send(storepb.NewWarnSeriesResponse(warn)); err != nil {
+				err = status.Error(codes.Unknown, errors.Wrap(err, "send series response warning").Error())
+				return
+			}
+		}
+	}
+
 	if s.enableSeriesResponseHints {
 		var anyHints *types.Any
 
@@ -1451,7 +1516,7 @@ func (s *BucketStore) LabelNames(ctx context.Context, req *storepb.LabelNamesReq
 
 	var mtx sync.Mutex
 	var sets [][]string
-	var seriesLimiter = s.seriesLimiterFactory(s.metrics.queriesDropped.WithLabelValues("series"))
+	var seriesLimiter = s.seriesLimiter(ctx)
 	var bytesLimiter = s.bytesLimiterFactory(s.metrics.queriesDropped.WithLabelValues("bytes"))
 
 	for _, b := range s.blocks {
@@ -1635,7 +1700,7 @@ func (s *BucketStore) LabelValues(ctx context.Context, req *storepb.LabelValuesR
 
 	var mtx sync.Mutex
 	var sets [][]string
-	var seriesLimiter = s.seriesLimiterFactory(s.metrics.queriesDropped.WithLabelValues("series"))
+	var seriesLimiter = s.seriesLimiter(ctx)
 	var bytesLimiter = s.bytesLimiterFactory(s.metrics.queriesDropped.WithLabelValues("bytes"))
 
 	for _, b := range s.blocks {
@@ -1930,6 +1995,8 @@ type bucketBlock struct {
 	pendingReaders sync.WaitGroup
 
 	partitioner Partitioner
+	// postingsS2Encoding, if set, returns whether postings stored in the index cache are compressed with S2.
+	postingsS2Encoding func() bool
 
 	// Block's labels used by block-level matchers to filter blocks to query. These are used to select blocks using
 	// request hints' BlockMatchers.
@@ -2442,7 +2509,11 @@ func (r *bucketIndexReader) fetchPostings(ctx context.Context, keys []labels.Lab
 				compressions++
 				s := time.Now()
 				bep := newBigEndianPostings(pBytes[4:])
-				data, err := diffVarintSnappyEncode(bep, bep.length())
+				encode := diffVarintSnappyEncode
+				if r.block.postingsS2Encoding != nil && r.block.postingsS2Encoding() {
+					encode = diffVarintS2Encode
+				}
+				data, err := encode(bep, bep.length())
 				compressionTime = time.Since(s)
 				if err == nil {
 					dataToCache = data
@@ -52,6 +52,7 @@ import (
 	"github.com/thanos-io/thanos/pkg/compact/downsample"
 	"github.com/thanos-io/thanos/pkg/gate"
 	"github.com/thanos-io/thanos/pkg/pool"
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 	storecache "github.com/thanos-io/thanos/pkg/store/cache"
 	"github.com/thanos-io/thanos/pkg/store/hintspb"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
@@ -1752,6 +1753,88 @@ func TestSeries_ErrorUnmarshallingRequestHints(t *testing.T) {
 	testutil.Equals(t, true, regexp.MustCompile(".*unmarshal series request hints.*").MatchString(err.Error()))
 }
 
+func TestSeries_ColdBlocksWarning(t *testing.T) {
+	tb := testutil.NewTB(t)
+
+	tmpDir := t.TempDir()
+
+	bktDir := filepath.Join(tmpDir, "bkt")
+	bkt, err := filesystem.NewBucket(bktDir)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, bkt.Close()) }()
+
+	var (
+		logger   = log.NewNopLogger()
+		instrBkt = objstore.WithNoopInstr(bkt)
+	)
+
+	head, _ := storetestutil.CreateHeadWithSeries(t, 0, storetestutil.HeadGenOptions{
+		TSDBDir:          filepath.Join(tmpDir, "head"),
+		SamplesPerSeries: 1,
+		Series:           2,
+		Random:           rand.New(rand.NewSource(120)),
+	})
+	blk := createBlockFromHead(t, bktDir, head)
+	testutil.Ok(t, head.Close())
+
+	_, err = metadata.InjectThanos(logger, filepath.Join(bktDir, blk.String()), metadata.Thanos{
+		Labels:       labels.Labels{{Name: "ext1", Value: "1"}}.Map(),
+		Downsample:   metadata.ThanosDownsample{Resolution: 0},
+		Source:       metadata.TestSource,
+		StorageClass: &metadata.StorageClass{Name: "COLDLINE", Cold: true},
+	}, nil)
+	testutil.Ok(t, err)
+
+	fetcher, err := block.NewMetaFetcher(logger, 10, instrBkt, tmpDir, nil, nil)
+	testutil.Ok(tb, err)
+
+	reg := prometheus.NewRegistry()
+	store, err := NewBucketStore(
+		instrBkt,
+		fetcher,
+		tmpDir,
+		NewChunksLimiterFactory(0),
+		NewSeriesLimiterFactory(0),
+		NewBytesLimiterFactory(0),
+		NewGapBasedPartitioner(PartitionerMaxGapSize),
+		10,
+		false,
+		DefaultPostingOffsetInMemorySampling,
+		true,
+		false,
+		0,
+		WithLogger(logger),
+		WithRegistry(reg),
+	)
+	testutil.Ok(tb, err)
+	defer func() { testutil.Ok(t, store.Close()) }()
+
+	testutil.Ok(tb, store.SyncBlocks(context.Background()))
+
+	for _, tcase := range []struct {
+		strategy storepb.PartialResponseStrategy
+		warnings int
+	}{
+		{strategy: storepb.PartialResponseStrategy_WARN, warnings: 1},
+		// Warnings abort queries without partial response, so none is sent.
+		{strategy: storepb.PartialResponseStrategy_ABORT, warnings: 0},
+	} {
+		srv := newStoreSeriesServer(context.Background())
+		testutil.Ok(t, store.Series(&storepb.SeriesRequest{
+			MinTime:                 0,
+			MaxTime:                 3,
+			Matchers:                []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "foo", Value: "bar"}},
+			PartialResponseStrategy: tcase.strategy,
+		}, srv))
+		testutil.Equals(t, 2, len(srv.SeriesSet))
+		testutil.Equals(t, tcase.warnings, len(srv.Warnings))
+		if tcase.warnings > 0 {
+			testutil.Assert(t, strings.Contains(srv.Warnings[0], blk.String()), srv.Warnings[0])
+		}
+	}
+	testutil.Equals(t, 2.0, promtest.ToFloat64(store.metrics.coldBlocksQueried))
+}
+
 func TestSeries_BlockWithMultipleChunks(t *testing.T) {
 	tb := testutil.NewTB(t)
 
@@ -2493,3 +2576,25 @@ func BenchmarkDownsampledBlockSeries(b *testing.B) {
 		}
 	}
 }
+
+func TestBucketStore_TenantSeriesSelectLimits(t *testing.T) {
+	s := &BucketStore{
+		metrics:              newBucketStoreMetrics(nil),
+		chunksLimiterFactory: NewChunksLimiterFactory(1),
+		seriesLimiterFactory: NewSeriesLimiterFactory(1),
+	}
+	ctx := middleware.NewContextWithTenant(context.Background(), "team-a")
+	testutil.NotOk(t, s.seriesLimiter(ctx).Reserve(2))
+	testutil.NotOk(t, s.chunksLimiter(ctx).Reserve(2))
+
+	WithTenantSeriesSelectLimits(func(tenant string) SeriesSelectLimits {
+		if tenant == "team-a" {
+			return SeriesSelectLimits{SeriesPerRequest: 2, SamplesPerRequest: 2 * MaxSamplesPerChunk}
+		}
+		return SeriesSelectLimits{SeriesPerRequest: 1, SamplesPerRequest: MaxSamplesPerChunk}
+	})(s)
+	testutil.Ok(t, s.seriesLimiter(ctx).Reserve(2))
+	testutil.Ok(t, s.chunksLimiter(ctx).Reserve(2))
+	testutil.NotOk(t, s.seriesLimiter(context.Background()).Reserve(2))
+	testutil.NotOk(t, s.chunksLimiter(context.Background()).Reserve(2))
+}
@@ -60,6 +60,14 @@ func NewRemoteIndexCache(logger log.Logger, cacheClient cacheutil.RemoteCacheCli
 	return c, nil
 }
 
+// Ping checks whether the remote cache can be reached, if its client supports it.
+func (c *RemoteIndexCache) Ping(ctx context.Context) error {
+	if p, ok := c.memcached.(cacheutil.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
 // StorePostings sets the postings identified by the ulid and label to the value v.
 // The function enqueues the request and returns immediately: the entry will be
 // asynchronously stored in the cache.
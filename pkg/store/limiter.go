@@ -13,6 +13,7 @@ import (
 	"go.uber.org/atomic"
 
 	"github.com/thanos-io/thanos/pkg/extkingpin"
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 )
 
@@ -116,27 +117,39 @@ var _ storepb.StoreServer = &limitedStoreServer{}
 // limitedStoreServer is a storepb.StoreServer that can apply series and sample limits against individual Series requests.
 type limitedStoreServer struct {
 	storepb.StoreServer
-	newSeriesLimiter      SeriesLimiterFactory
-	newSamplesLimiter     ChunksLimiterFactory
+	selectLimits          func(tenant string) SeriesSelectLimits
 	failedRequestsCounter *prometheus.CounterVec
 }
 
 // NewLimitedStoreServer creates a new limitedStoreServer.
 func NewLimitedStoreServer(store storepb.StoreServer, reg prometheus.Registerer, selectLimits SeriesSelectLimits) storepb.StoreServer {
+	return NewDynamicLimitedStoreServer(store, reg, func(string) SeriesSelectLimits { return selectLimits })
+}
+
+// NewDynamicLimitedStoreServer creates a new limitedStoreServer applying the limits returned by selectLimits for the
+// tenant of each Series request at its start. The tenant of requests without tenant is empty.
+func NewDynamicLimitedStoreServer(store storepb.StoreServer, reg prometheus.Registerer, selectLimits func(tenant string) SeriesSelectLimits) storepb.StoreServer {
+	return NewTenantLimitedStoreServer(store, promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_selects_dropped_total",
+		Help: "Number of select queries that were dropped due to configured limits.",
+	}, []string{"reason"}), selectLimits)
+}
+
+// NewTenantLimitedStoreServer is like NewDynamicLimitedStoreServer, counting the dropped requests by reason in the
+// given counter.
+func NewTenantLimitedStoreServer(store storepb.StoreServer, failedRequestsCounter *prometheus.CounterVec, selectLimits func(tenant string) SeriesSelectLimits) storepb.StoreServer {
 	return &limitedStoreServer{
-		StoreServer:       store,
-		newSeriesLimiter:  NewSeriesLimiterFactory(selectLimits.SeriesPerRequest),
-		newSamplesLimiter: NewChunksLimiterFactory(selectLimits.SamplesPerRequest),
-		failedRequestsCounter: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
-			Name: "thanos_store_selects_dropped_total",
-			Help: "Number of select queries that were dropped due to configured limits.",
-		}, []string{"reason"}),
+		StoreServer:           store,
+		selectLimits:          selectLimits,
+		failedRequestsCounter: failedRequestsCounter,
 	}
 }
 
 func (s *limitedStoreServer) Series(req *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
-	seriesLimiter := s.newSeriesLimiter(s.failedRequestsCounter.WithLabelValues("series"))
-	chunksLimiter := s.newSamplesLimiter(s.failedRequestsCounter.WithLabelValues("chunks"))
+	tenant, _ := middleware.TenantFromContext(srv.Context())
+	limits := s.selectLimits(tenant)
+	seriesLimiter := NewLimiter(limits.SeriesPerRequest, s.failedRequestsCounter.WithLabelValues("series"))
+	chunksLimiter := NewLimiter(limits.SamplesPerRequest, s.failedRequestsCounter.WithLabelValues("chunks"))
 	limitedSrv := newLimitedServer(srv, seriesLimiter, chunksLimiter)
 	if err := s.StoreServer.Series(req, limitedSrv); err != nil {
 		return err
@@ -14,6 +14,7 @@ import (
 	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/prometheus/model/labels"
 
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 )
 
@@ -108,6 +109,34 @@ func TestRateLimitedServer(t *testing.T) {
 	}
 }
 
+func TestDynamicLimitedServer(t *testing.T) {
+	series := []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("series", "1"), makeSamples(10)),
+		storeSeriesResponse(t, labels.FromStrings("series", "2"), makeSamples(10)),
+	}
+	limits := SeriesSelectLimits{SeriesPerRequest: 1}
+	store := NewDynamicLimitedStoreServer(newStoreServerStub(series), prometheus.NewRegistry(), func(tenant string) SeriesSelectLimits {
+		if tenant == "team-a" {
+			return SeriesSelectLimits{SeriesPerRequest: 2}
+		}
+		return limits
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	err := store.Series(&storepb.SeriesRequest{}, storepb.NewInProcessStream(ctx, 10))
+	testutil.NotOk(t, err)
+	testutil.Equals(t, "failed to send series: limit 1 violated (got 2)", err.Error())
+
+	// The limits of the tenant of the request apply.
+	testutil.Ok(t, store.Series(&storepb.SeriesRequest{}, storepb.NewInProcessStream(middleware.NewContextWithTenant(ctx, "team-a"), 10)))
+
+	// Changed limits apply to the next request.
+	limits.SeriesPerRequest = 2
+	testutil.Ok(t, store.Series(&storepb.SeriesRequest{}, storepb.NewInProcessStream(ctx, 10)))
+}
+
 func makeSamples(numSamples int) []sample {
 	samples := make([]sample, numSamples)
 	for i := range samples {
@@ -54,6 +54,24 @@ func diffVarintSnappyEncode(p index.Postings, length int) ([]byte, error) {
 	return result, nil
 }
 
+// diffVarintS2Encode encodes postings into diff+varint representation, and compresses the result with S2 in its
+// Snappy compatible format, which is smaller than the one of diffVarintSnappyEncode but slower to produce.
+// Returned byte slice starts with codecHeaderSnappy header, as it can be decoded by diffVarintSnappyDecode.
+// Length argument is expected number of postings, used for preallocating buffer.
+func diffVarintS2Encode(p index.Postings, length int) ([]byte, error) {
+	buf, err := diffVarintEncodeNoHeader(p, length)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]byte, len(codecHeaderSnappy)+s2.MaxEncodedLen(len(buf)))
+	copy(result, codecHeaderSnappy)
+
+	compressed := s2.EncodeSnappyBetter(result[len(codecHeaderSnappy):], buf)
+	result = result[:len(codecHeaderSnappy)+len(compressed)]
+	return result, nil
+}
+
 // diffVarintEncodeNoHeader encodes postings into diff+varint representation.
 // It doesn't add any header to the output bytes.
 // Length argument is expected number of postings, used for preallocating buffer.
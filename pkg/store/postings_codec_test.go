@@ -16,6 +16,7 @@ import (
 	"github.com/prometheus/prometheus/tsdb/index"
 
 	"github.com/efficientgo/core/testutil"
+	"github.com/golang/snappy"
 	storetestutil "github.com/thanos-io/thanos/pkg/store/storepb/testutil"
 )
 
@@ -59,6 +60,7 @@ func TestDiffVarintCodec(t *testing.T) {
 	}{
 		"raw":    {codingFunction: diffVarintEncodeNoHeader, decodingFunction: func(bytes []byte) (closeablePostings, error) { return newDiffVarintPostings(bytes, nil), nil }},
 		"snappy": {codingFunction: diffVarintSnappyEncode, decodingFunction: diffVarintSnappyDecode},
+		"s2":     {codingFunction: diffVarintS2Encode, decodingFunction: diffVarintSnappyDecode},
 	}
 
 	for postingName, postings := range postingsMap {
@@ -89,6 +91,18 @@ func TestDiffVarintCodec(t *testing.T) {
 	}
 }
 
+func TestDiffVarintS2Encode_SnappyCompatible(t *testing.T) {
+	p := index.NewListPostings([]storage.SeriesRef{1, 5, 8, 42, 1000, 1001, 1002})
+	data, err := diffVarintS2Encode(p, 7)
+	testutil.Ok(t, err)
+	testutil.Assert(t, isDiffVarintSnappyEncodedPostings(data), "S2 encoded postings should have the Snappy codec header")
+
+	// Stores which do not compress postings with S2 decode them with Snappy.
+	raw, err := snappy.Decode(nil, data[len(codecHeaderSnappy):])
+	testutil.Ok(t, err)
+	comparePostings(t, index.NewListPostings([]storage.SeriesRef{1, 5, 8, 42, 1000, 1001, 1002}), newDiffVarintPostings(raw, nil))
+}
+
 func comparePostings(t *testing.T, p1, p2 index.Postings) {
 	for p1.Next() {
 		if !p2.Next() {
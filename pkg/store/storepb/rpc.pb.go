@@ -186,6 +186,88 @@ func (m *WriteRequest) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_WriteRequest proto.InternalMessageInfo
 
+type WriteStreamRequest struct {
+	// id identifies the request within the stream, the response for the request has the same id.
+	Id      uint64       `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Request WriteRequest `protobuf:"bytes,2,opt,name=request,proto3" json:"request"`
+	// timeout_milliseconds is the time left until the sender stops waiting for the response, 0 if it waits forever.
+	TimeoutMilliseconds int64 `protobuf:"varint,3,opt,name=timeout_milliseconds,json=timeoutMilliseconds,proto3" json:"timeout_milliseconds,omitempty"`
+}
+
+func (m *WriteStreamRequest) Reset()         { *m = WriteStreamRequest{} }
+func (m *WriteStreamRequest) String() string { return proto.CompactTextString(m) }
+func (*WriteStreamRequest) ProtoMessage()    {}
+func (*WriteStreamRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a938d55a388af629, []int{2}
+}
+func (m *WriteStreamRequest) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *WriteStreamRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_WriteStreamRequest.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *WriteStreamRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WriteStreamRequest.Merge(m, src)
+}
+func (m *WriteStreamRequest) XXX_Size() int {
+	return m.Size()
+}
+func (m *WriteStreamRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_WriteStreamRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WriteStreamRequest proto.InternalMessageInfo
+
+type WriteStreamResponse struct {
+	Id uint64 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// code is the gRPC status code of the write request, 0 (OK) if it succeeded.
+	Code int32 `protobuf:"varint,2,opt,name=code,proto3" json:"code,omitempty"`
+	// message is the error message of the failed write request.
+	Message string `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+}
+
+func (m *WriteStreamResponse) Reset()         { *m = WriteStreamResponse{} }
+func (m *WriteStreamResponse) String() string { return proto.CompactTextString(m) }
+func (*WriteStreamResponse) ProtoMessage()    {}
+func (*WriteStreamResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a938d55a388af629, []int{3}
+}
+func (m *WriteStreamResponse) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *WriteStreamResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_WriteStreamResponse.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *WriteStreamResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WriteStreamResponse.Merge(m, src)
+}
+func (m *WriteStreamResponse) XXX_Size() int {
+	return m.Size()
+}
+func (m *WriteStreamResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_WriteStreamResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WriteStreamResponse proto.InternalMessageInfo
+
 // Deprecated. Use `thanos.info` instead.
 type InfoRequest struct {
 }
@@ -194,7 +276,7 @@ func (m *InfoRequest) Reset()         { *m = InfoRequest{} }
 func (m *InfoRequest) String() string { return proto.CompactTextString(m) }
 func (*InfoRequest) ProtoMessage()    {}
 func (*InfoRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a938d55a388af629, []int{2}
+	return fileDescriptor_a938d55a388af629, []int{4}
 }
 func (m *InfoRequest) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -238,7 +320,7 @@ func (m *InfoResponse) Reset()         { *m = InfoResponse{} }
 func (m *InfoResponse) String() string { return proto.CompactTextString(m) }
 func (*InfoResponse) ProtoMessage()    {}
 func (*InfoResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a938d55a388af629, []int{3}
+	return fileDescriptor_a938d55a388af629, []int{5}
 }
 func (m *InfoResponse) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -311,7 +393,7 @@ func (m *SeriesRequest) Reset()         { *m = SeriesRequest{} }
 func (m *SeriesRequest) String() string { return proto.CompactTextString(m) }
 func (*SeriesRequest) ProtoMessage()    {}
 func (*SeriesRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a938d55a388af629, []int{4}
+	return fileDescriptor_a938d55a388af629, []int{6}
 }
 func (m *SeriesRequest) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -359,7 +441,7 @@ func (m *QueryHints) Reset()         { *m = QueryHints{} }
 func (m *QueryHints) String() string { return proto.CompactTextString(m) }
 func (*QueryHints) ProtoMessage()    {}
 func (*QueryHints) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a938d55a388af629, []int{5}
+	return fileDescriptor_a938d55a388af629, []int{7}
 }
 func (m *QueryHints) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -404,7 +486,7 @@ func (m *ShardInfo) Reset()         { *m = ShardInfo{} }
 func (m *ShardInfo) String() string { return proto.CompactTextString(m) }
 func (*ShardInfo) ProtoMessage()    {}
 func (*ShardInfo) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a938d55a388af629, []int{6}
+	return fileDescriptor_a938d55a388af629, []int{8}
 }
 func (m *ShardInfo) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -442,7 +524,7 @@ func (m *Func) Reset()         { *m = Func{} }
 func (m *Func) String() string { return proto.CompactTextString(m) }
 func (*Func) ProtoMessage()    {}
 func (*Func) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a938d55a388af629, []int{7}
+	return fileDescriptor_a938d55a388af629, []int{9}
 }
 func (m *Func) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -482,7 +564,7 @@ func (m *Grouping) Reset()         { *m = Grouping{} }
 func (m *Grouping) String() string { return proto.CompactTextString(m) }
 func (*Grouping) ProtoMessage()    {}
 func (*Grouping) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a938d55a388af629, []int{8}
+	return fileDescriptor_a938d55a388af629, []int{10}
 }
 func (m *Grouping) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -519,7 +601,7 @@ func (m *Range) Reset()         { *m = Range{} }
 func (m *Range) String() string { return proto.CompactTextString(m) }
 func (*Range) ProtoMessage()    {}
 func (*Range) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a938d55a388af629, []int{9}
+	return fileDescriptor_a938d55a388af629, []int{11}
 }
 func (m *Range) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -560,7 +642,7 @@ func (m *SeriesResponse) Reset()         { *m = SeriesResponse{} }
 func (m *SeriesResponse) String() string { return proto.CompactTextString(m) }
 func (*SeriesResponse) ProtoMessage()    {}
 func (*SeriesResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a938d55a388af629, []int{10}
+	return fileDescriptor_a938d55a388af629, []int{12}
 }
 func (m *SeriesResponse) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -663,7 +745,7 @@ func (m *LabelNamesRequest) Reset()         { *m = LabelNamesRequest{} }
 func (m *LabelNamesRequest) String() string { return proto.CompactTextString(m) }
 func (*LabelNamesRequest) ProtoMessage()    {}
 func (*LabelNamesRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a938d55a388af629, []int{11}
+	return fileDescriptor_a938d55a388af629, []int{13}
 }
 func (m *LabelNamesRequest) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -705,7 +787,7 @@ func (m *LabelNamesResponse) Reset()         { *m = LabelNamesResponse{} }
 func (m *LabelNamesResponse) String() string { return proto.CompactTextString(m) }
 func (*LabelNamesResponse) ProtoMessage()    {}
 func (*LabelNamesResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a938d55a388af629, []int{12}
+	return fileDescriptor_a938d55a388af629, []int{14}
 }
 func (m *LabelNamesResponse) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -752,7 +834,7 @@ func (m *LabelValuesRequest) Reset()         { *m = LabelValuesRequest{} }
 func (m *LabelValuesRequest) String() string { return proto.CompactTextString(m) }
 func (*LabelValuesRequest) ProtoMessage()    {}
 func (*LabelValuesRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a938d55a388af629, []int{13}
+	return fileDescriptor_a938d55a388af629, []int{15}
 }
 func (m *LabelValuesRequest) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -794,7 +876,7 @@ func (m *LabelValuesResponse) Reset()         { *m = LabelValuesResponse{} }
 func (m *LabelValuesResponse) String() string { return proto.CompactTextString(m) }
 func (*LabelValuesResponse) ProtoMessage()    {}
 func (*LabelValuesResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a938d55a388af629, []int{14}
+	return fileDescriptor_a938d55a388af629, []int{16}
 }
 func (m *LabelValuesResponse) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -828,6 +910,8 @@ func init() {
 	proto.RegisterEnum("thanos.Aggr", Aggr_name, Aggr_value)
 	proto.RegisterType((*WriteResponse)(nil), "thanos.WriteResponse")
 	proto.RegisterType((*WriteRequest)(nil), "thanos.WriteRequest")
+	proto.RegisterType((*WriteStreamRequest)(nil), "thanos.WriteStreamRequest")
+	proto.RegisterType((*WriteStreamResponse)(nil), "thanos.WriteStreamResponse")
 	proto.RegisterType((*InfoRequest)(nil), "thanos.InfoRequest")
 	proto.RegisterType((*InfoResponse)(nil), "thanos.InfoResponse")
 	proto.RegisterType((*SeriesRequest)(nil), "thanos.SeriesRequest")
@@ -846,90 +930,97 @@ func init() {
 func init() { proto.RegisterFile("store/storepb/rpc.proto", fileDescriptor_a938d55a388af629) }
 
 var fileDescriptor_a938d55a388af629 = []byte{
-	// 1323 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x57, 0xdd, 0x6e, 0x13, 0xc7,
-	0x17, 0xf7, 0x7a, 0xbd, 0xfe, 0x38, 0x4e, 0xf2, 0x37, 0x83, 0x09, 0x1b, 0x23, 0x39, 0xfe, 0xbb,
-	0xaa, 0x64, 0x21, 0x6a, 0x53, 0x83, 0x90, 0x5a, 0x71, 0x93, 0x04, 0x43, 0xa2, 0x12, 0x53, 0xc6,
-	0x09, 0x69, 0xa9, 0x2a, 0x6b, 0x6d, 0x4f, 0xd6, 0x2b, 0xec, 0xdd, 0x65, 0x67, 0xb6, 0x89, 0x6f,
-	0xdb, 0xdb, 0xaa, 0xaa, 0xfa, 0x08, 0x7d, 0x8a, 0x3e, 0x02, 0x77, 0xe5, 0xb2, 0xea, 0x05, 0x6a,
-	0xe1, 0x45, 0xaa, 0x39, 0x3b, 0x6b, 0x7b, 0xd3, 0x00, 0x45, 0x70, 0x13, 0xcd, 0xf9, 0xfd, 0xce,
-	0x9c, 0x39, 0xdf, 0xde, 0xc0, 0x65, 0x2e, 0xbc, 0x80, 0xb5, 0xf0, 0xaf, 0x3f, 0x68, 0x05, 0xfe,
-	0xb0, 0xe9, 0x07, 0x9e, 0xf0, 0x48, 0x56, 0x8c, 0x2d, 0xd7, 0xe3, 0x95, 0x8d, 0xa4, 0x82, 0x98,
-	0xf9, 0x8c, 0x47, 0x2a, 0x95, 0xb2, 0xed, 0xd9, 0x1e, 0x1e, 0x5b, 0xf2, 0xa4, 0xd0, 0x5a, 0xf2,
-	0x82, 0x1f, 0x78, 0xd3, 0x33, 0xf7, 0x94, 0xc9, 0x89, 0x35, 0x60, 0x93, 0xb3, 0x94, 0xed, 0x79,
-	0xf6, 0x84, 0xb5, 0x50, 0x1a, 0x84, 0xc7, 0x2d, 0xcb, 0x9d, 0x45, 0x54, 0xfd, 0x7f, 0xb0, 0x7a,
-	0x14, 0x38, 0x82, 0x51, 0xc6, 0x7d, 0xcf, 0xe5, 0xac, 0xfe, 0x83, 0x06, 0x2b, 0x0a, 0x79, 0x1a,
-	0x32, 0x2e, 0xc8, 0x16, 0x80, 0x70, 0xa6, 0x8c, 0xb3, 0xc0, 0x61, 0xdc, 0xd4, 0x6a, 0x7a, 0xa3,
-	0xd8, 0xbe, 0x22, 0x6f, 0x4f, 0x99, 0x18, 0xb3, 0x90, 0xf7, 0x87, 0x9e, 0x3f, 0x6b, 0x1e, 0x38,
-	0x53, 0xd6, 0x43, 0x95, 0xed, 0xcc, 0xb3, 0x17, 0x9b, 0x29, 0xba, 0x74, 0x89, 0xac, 0x43, 0x56,
-	0x30, 0xd7, 0x72, 0x85, 0x99, 0xae, 0x69, 0x8d, 0x02, 0x55, 0x12, 0x31, 0x21, 0x17, 0x30, 0x7f,
-	0xe2, 0x0c, 0x2d, 0x53, 0xaf, 0x69, 0x0d, 0x9d, 0xc6, 0x62, 0x7d, 0x15, 0x8a, 0x7b, 0xee, 0xb1,
-	0xa7, 0x7c, 0xa8, 0xff, 0x92, 0x86, 0x95, 0x48, 0x8e, 0xbc, 0x24, 0x43, 0xc8, 0x62, 0xa0, 0xb1,
-	0x43, 0xab, 0xcd, 0x28, 0xb1, 0xcd, 0xfb, 0x12, 0xdd, 0xbe, 0x2d, 0x5d, 0xf8, 0xf3, 0xc5, 0xe6,
-	0x4d, 0xdb, 0x11, 0xe3, 0x70, 0xd0, 0x1c, 0x7a, 0xd3, 0x56, 0xa4, 0xf0, 0x89, 0xe3, 0xa9, 0x53,
-	0xcb, 0x7f, 0x62, 0xb7, 0x12, 0x39, 0x6b, 0x3e, 0xc6, 0xdb, 0x54, 0x99, 0x26, 0x1b, 0x90, 0x9f,
-	0x3a, 0x6e, 0x5f, 0x06, 0x82, 0x8e, 0xeb, 0x34, 0x37, 0x75, 0x5c, 0x19, 0x29, 0x52, 0xd6, 0x69,
-	0x44, 0x29, 0xd7, 0xa7, 0xd6, 0x29, 0x52, 0x2d, 0x28, 0xa0, 0xd5, 0x83, 0x99, 0xcf, 0xcc, 0x4c,
-	0x4d, 0x6b, 0xac, 0xb5, 0x2f, 0xc4, 0xde, 0xf5, 0x62, 0x82, 0x2e, 0x74, 0xc8, 0x2d, 0x00, 0x7c,
-	0xb0, 0xcf, 0x99, 0xe0, 0xa6, 0x81, 0xf1, 0xcc, 0x6f, 0x44, 0x2e, 0xf5, 0x98, 0x50, 0x69, 0x2d,
-	0x4c, 0x94, 0xcc, 0xeb, 0x3f, 0x1a, 0xb0, 0x1a, 0xa5, 0x3c, 0x2e, 0xd5, 0xb2, 0xc3, 0xda, 0xeb,
-	0x1d, 0x4e, 0x27, 0x1d, 0xbe, 0x25, 0x29, 0x31, 0x1c, 0xb3, 0x80, 0x9b, 0x3a, 0xbe, 0x5e, 0x4e,
-	0x64, 0x73, 0x3f, 0x22, 0x95, 0x03, 0x73, 0x5d, 0xd2, 0x86, 0x4b, 0xd2, 0x64, 0xc0, 0xb8, 0x37,
-	0x09, 0x85, 0xe3, 0xb9, 0xfd, 0x13, 0xc7, 0x1d, 0x79, 0x27, 0x18, 0xb4, 0x4e, 0x2f, 0x4e, 0xad,
-	0x53, 0x3a, 0xe7, 0x8e, 0x90, 0x22, 0xd7, 0x00, 0x2c, 0xdb, 0x0e, 0x98, 0x6d, 0x09, 0x16, 0xc5,
-	0xba, 0xd6, 0x5e, 0x89, 0x5f, 0xdb, 0xb2, 0xed, 0x80, 0x2e, 0xf1, 0xe4, 0x73, 0xd8, 0xf0, 0xad,
-	0x40, 0x38, 0xd6, 0x44, 0xbe, 0x82, 0x95, 0xef, 0x8f, 0x1c, 0x6e, 0x0d, 0x26, 0x6c, 0x64, 0x66,
-	0x6b, 0x5a, 0x23, 0x4f, 0x2f, 0x2b, 0x85, 0xb8, 0x33, 0xee, 0x28, 0x9a, 0x7c, 0x73, 0xce, 0x5d,
-	0x2e, 0x02, 0x4b, 0x30, 0x7b, 0x66, 0xe6, 0xb0, 0x2c, 0x9b, 0xf1, 0xc3, 0x5f, 0x26, 0x6d, 0xf4,
-	0x94, 0xda, 0xbf, 0x8c, 0xc7, 0x04, 0xd9, 0x84, 0x22, 0x7f, 0xe2, 0xf8, 0xfd, 0xe1, 0x38, 0x74,
-	0x9f, 0x70, 0x33, 0x8f, 0xae, 0x80, 0x84, 0x76, 0x10, 0x21, 0x57, 0xc1, 0x18, 0x3b, 0xae, 0xe0,
-	0x66, 0xa1, 0xa6, 0x61, 0x42, 0xa3, 0x09, 0x6c, 0xc6, 0x13, 0xd8, 0xdc, 0x72, 0x67, 0x34, 0x52,
-	0x21, 0x04, 0x32, 0x5c, 0x30, 0xdf, 0x04, 0x4c, 0x1b, 0x9e, 0x49, 0x19, 0x8c, 0xc0, 0x72, 0x6d,
-	0x66, 0x16, 0x11, 0x8c, 0x04, 0x72, 0x03, 0x8a, 0x4f, 0x43, 0x16, 0xcc, 0xfa, 0x91, 0xed, 0x15,
-	0xb4, 0x4d, 0xe2, 0x28, 0x1e, 0x4a, 0x6a, 0x57, 0x32, 0x14, 0x9e, 0xce, 0xcf, 0xe4, 0x3a, 0x00,
-	0x1f, 0x5b, 0xc1, 0xa8, 0xef, 0xb8, 0xc7, 0x9e, 0xb9, 0x8a, 0x77, 0x16, 0x0d, 0x29, 0x19, 0x9c,
-	0xac, 0x02, 0x8f, 0x8f, 0xe4, 0x26, 0xac, 0x9f, 0x38, 0x62, 0xec, 0x85, 0xa2, 0xaf, 0xe6, 0xb1,
-	0xaf, 0x86, 0x6d, 0xad, 0xa6, 0x37, 0x0a, 0xb4, 0xac, 0x58, 0x1a, 0x91, 0xd8, 0x24, 0xbc, 0xfe,
-	0xab, 0x06, 0xb0, 0x70, 0x01, 0x53, 0x24, 0x98, 0xdf, 0x9f, 0x3a, 0x93, 0x89, 0xc3, 0x55, 0x3b,
-	0x82, 0x84, 0xf6, 0x11, 0x21, 0x35, 0xc8, 0x1c, 0x87, 0xee, 0x10, 0xbb, 0xb1, 0xb8, 0x68, 0x82,
-	0xbb, 0xa1, 0x3b, 0xa4, 0xc8, 0x90, 0x6b, 0x90, 0xb7, 0x03, 0x2f, 0xf4, 0x1d, 0xd7, 0xc6, 0x9e,
-	0x2a, 0xb6, 0x4b, 0xb1, 0xd6, 0x3d, 0x85, 0xd3, 0xb9, 0x06, 0xf9, 0x28, 0x4e, 0x99, 0x81, 0xaa,
-	0xf3, 0x8d, 0x40, 0x25, 0xa8, 0x32, 0x58, 0x3f, 0x81, 0xc2, 0x3c, 0x64, 0x74, 0x51, 0x65, 0x66,
-	0xc4, 0x4e, 0xe7, 0x2e, 0x46, 0xfc, 0x88, 0x9d, 0x92, 0xff, 0xc3, 0x8a, 0xf0, 0x84, 0x35, 0xe9,
-	0x23, 0xc6, 0xd5, 0xe0, 0x14, 0x11, 0x43, 0x33, 0x9c, 0xac, 0x41, 0x7a, 0x30, 0xc3, 0x15, 0x90,
-	0xa7, 0xe9, 0xc1, 0x4c, 0xae, 0x3a, 0x95, 0xab, 0x0c, 0xe6, 0x4a, 0x49, 0xf5, 0x0a, 0x64, 0x64,
-	0x64, 0xb2, 0xd8, 0xae, 0xa5, 0xc6, 0xb3, 0x40, 0xf1, 0x5c, 0x6f, 0x43, 0x3e, 0x8e, 0x47, 0xd9,
-	0xd3, 0xce, 0xb1, 0xa7, 0x27, 0xec, 0x6d, 0x82, 0x81, 0x81, 0x49, 0x85, 0x44, 0x8a, 0x95, 0x54,
-	0xff, 0x49, 0x83, 0xb5, 0x78, 0x3b, 0xa8, 0xa5, 0xd9, 0x80, 0xec, 0x7c, 0x8b, 0xcb, 0x14, 0xad,
-	0xcd, 0xbb, 0x00, 0xd1, 0xdd, 0x14, 0x55, 0x3c, 0xa9, 0x40, 0xee, 0xc4, 0x0a, 0x5c, 0x99, 0x78,
-	0xdc, 0xd8, 0xbb, 0x29, 0x1a, 0x03, 0xe4, 0x5a, 0xdc, 0xda, 0xfa, 0xeb, 0x5b, 0x7b, 0x37, 0xa5,
-	0x9a, 0x7b, 0x3b, 0x0f, 0xd9, 0x80, 0xf1, 0x70, 0x22, 0xea, 0xbf, 0xa5, 0xe1, 0x02, 0xb6, 0x4a,
-	0xd7, 0x9a, 0x2e, 0x56, 0xd6, 0x1b, 0x47, 0x5c, 0x7b, 0x8f, 0x11, 0x4f, 0xbf, 0xe7, 0x88, 0x97,
-	0xc1, 0xe0, 0xc2, 0x0a, 0x84, 0x5a, 0xef, 0x91, 0x40, 0x4a, 0xa0, 0x33, 0x77, 0xa4, 0x36, 0x9c,
-	0x3c, 0x2e, 0x26, 0xdd, 0x78, 0xfb, 0xa4, 0x2f, 0x6f, 0xda, 0xec, 0x7f, 0xdf, 0xb4, 0xf5, 0x00,
-	0xc8, 0x72, 0xe6, 0x54, 0x39, 0xcb, 0x60, 0xc8, 0xf6, 0x89, 0x7e, 0x02, 0x0b, 0x34, 0x12, 0x48,
-	0x05, 0xf2, 0xaa, 0x52, 0xb2, 0x5f, 0x25, 0x31, 0x97, 0x17, 0xbe, 0xea, 0x6f, 0xf5, 0xb5, 0xfe,
-	0x7b, 0x5a, 0x3d, 0xfa, 0xc8, 0x9a, 0x84, 0x8b, 0x7a, 0x95, 0xc1, 0xc0, 0x0e, 0x54, 0x0d, 0x1c,
-	0x09, 0x6f, 0xae, 0x62, 0xfa, 0x3d, 0xaa, 0xa8, 0x7f, 0xa8, 0x2a, 0x66, 0xce, 0xa9, 0xa2, 0x71,
-	0x4e, 0x15, 0xb3, 0xef, 0x56, 0xc5, 0xdc, 0x3b, 0x54, 0x31, 0x84, 0x8b, 0x89, 0x84, 0xaa, 0x32,
-	0xae, 0x43, 0xf6, 0x3b, 0x44, 0x54, 0x1d, 0x95, 0xf4, 0xa1, 0x0a, 0x79, 0xf5, 0x5b, 0x28, 0xcc,
-	0x3f, 0x3b, 0x48, 0x11, 0x72, 0x87, 0xdd, 0x2f, 0xba, 0x0f, 0x8e, 0xba, 0xa5, 0x14, 0x29, 0x80,
-	0xf1, 0xf0, 0xb0, 0x43, 0xbf, 0x2e, 0x69, 0x24, 0x0f, 0x19, 0x7a, 0x78, 0xbf, 0x53, 0x4a, 0x4b,
-	0x8d, 0xde, 0xde, 0x9d, 0xce, 0xce, 0x16, 0x2d, 0xe9, 0x52, 0xa3, 0x77, 0xf0, 0x80, 0x76, 0x4a,
-	0x19, 0x89, 0xd3, 0xce, 0x4e, 0x67, 0xef, 0x51, 0xa7, 0x64, 0x48, 0xfc, 0x4e, 0x67, 0xfb, 0xf0,
-	0x5e, 0x29, 0x7b, 0x75, 0x1b, 0x32, 0xf2, 0x77, 0x9b, 0xe4, 0x40, 0xa7, 0x5b, 0x47, 0x91, 0xd5,
-	0x9d, 0x07, 0x87, 0xdd, 0x83, 0x92, 0x26, 0xb1, 0xde, 0xe1, 0x7e, 0x29, 0x2d, 0x0f, 0xfb, 0x7b,
-	0xdd, 0x92, 0x8e, 0x87, 0xad, 0xaf, 0x22, 0x73, 0xa8, 0xd5, 0xa1, 0x25, 0xa3, 0xfd, 0x7d, 0x1a,
-	0x0c, 0xf4, 0x91, 0x7c, 0x0a, 0x19, 0x5c, 0xcd, 0x17, 0xe3, 0x8c, 0x2e, 0x7d, 0x05, 0x56, 0xca,
-	0x49, 0x50, 0xe5, 0xef, 0x33, 0xc8, 0x46, 0xfb, 0x8b, 0x5c, 0x4a, 0xee, 0xb3, 0xf8, 0xda, 0xfa,
-	0x59, 0x38, 0xba, 0x78, 0x5d, 0x23, 0x3b, 0x00, 0x8b, 0xb9, 0x22, 0x1b, 0x89, 0x2a, 0x2e, 0x6f,
-	0xa9, 0x4a, 0xe5, 0x3c, 0x4a, 0xbd, 0x7f, 0x17, 0x8a, 0x4b, 0x65, 0x25, 0x49, 0xd5, 0xc4, 0xf0,
-	0x54, 0xae, 0x9c, 0xcb, 0x45, 0x76, 0xda, 0x5d, 0x58, 0xc3, 0xef, 0x6e, 0x39, 0x15, 0x51, 0x32,
-	0x6e, 0x43, 0x91, 0xb2, 0xa9, 0x27, 0x18, 0xe2, 0x64, 0x1e, 0xfe, 0xf2, 0xe7, 0x79, 0xe5, 0xd2,
-	0x19, 0x54, 0x7d, 0xc6, 0xa7, 0xb6, 0x3f, 0x7e, 0xf6, 0x77, 0x35, 0xf5, 0xec, 0x65, 0x55, 0x7b,
-	0xfe, 0xb2, 0xaa, 0xfd, 0xf5, 0xb2, 0xaa, 0xfd, 0xfc, 0xaa, 0x9a, 0x7a, 0xfe, 0xaa, 0x9a, 0xfa,
-	0xe3, 0x55, 0x35, 0xf5, 0x38, 0xa7, 0xfe, 0x93, 0x18, 0x64, 0xb1, 0x67, 0x6e, 0xfc, 0x13, 0x00,
-	0x00, 0xff, 0xff, 0x73, 0x1f, 0x05, 0x4d, 0xb3, 0x0c, 0x00, 0x00,
+	// 1433 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x57, 0xdd, 0x6e, 0x13, 0xd7,
+	0x16, 0xf6, 0x78, 0x3c, 0xfe, 0x59, 0x4e, 0x72, 0xcc, 0x8e, 0x09, 0x8e, 0x91, 0x12, 0x9f, 0x39,
+	0x3a, 0x92, 0x85, 0x38, 0x36, 0x18, 0x84, 0x74, 0x2a, 0x6e, 0x92, 0x60, 0x48, 0x54, 0x12, 0xca,
+	0x76, 0x42, 0x5a, 0xaa, 0xca, 0x1a, 0xdb, 0x3b, 0xe3, 0x11, 0x9e, 0x1f, 0x66, 0xef, 0x69, 0xe2,
+	0xdb, 0xf6, 0xb6, 0xad, 0xaa, 0x3e, 0x02, 0x4f, 0xd1, 0x47, 0xe0, 0xae, 0x5c, 0x56, 0xbd, 0x40,
+	0x2d, 0xbc, 0x48, 0xb5, 0x7f, 0xc6, 0x9e, 0x09, 0x06, 0x8a, 0xe0, 0xc6, 0xda, 0x6b, 0x7d, 0x6b,
+	0xaf, 0xf9, 0xd6, 0xef, 0x8c, 0xe1, 0x12, 0x65, 0x7e, 0x48, 0xda, 0xe2, 0x37, 0x18, 0xb4, 0xc3,
+	0x60, 0xd8, 0x0a, 0x42, 0x9f, 0xf9, 0x28, 0xcf, 0xc6, 0x96, 0xe7, 0xd3, 0xfa, 0x7a, 0xda, 0x80,
+	0x4d, 0x03, 0x42, 0xa5, 0x49, 0xbd, 0x6a, 0xfb, 0xb6, 0x2f, 0x8e, 0x6d, 0x7e, 0x52, 0xda, 0x46,
+	0xfa, 0x42, 0x10, 0xfa, 0xee, 0xb9, 0x7b, 0xca, 0xe5, 0xc4, 0x1a, 0x90, 0xc9, 0x79, 0xc8, 0xf6,
+	0x7d, 0x7b, 0x42, 0xda, 0x42, 0x1a, 0x44, 0x27, 0x6d, 0xcb, 0x9b, 0x4a, 0xc8, 0xfc, 0x17, 0x2c,
+	0x1f, 0x87, 0x0e, 0x23, 0x98, 0xd0, 0xc0, 0xf7, 0x28, 0x31, 0xbf, 0xd7, 0x60, 0x49, 0x69, 0x9e,
+	0x46, 0x84, 0x32, 0xb4, 0x05, 0xc0, 0x1c, 0x97, 0x50, 0x12, 0x3a, 0x84, 0xd6, 0xb4, 0x86, 0xde,
+	0x2c, 0x77, 0x2e, 0xf3, 0xdb, 0x2e, 0x61, 0x63, 0x12, 0xd1, 0xfe, 0xd0, 0x0f, 0xa6, 0xad, 0x43,
+	0xc7, 0x25, 0x3d, 0x61, 0xb2, 0x9d, 0x7b, 0xfe, 0x72, 0x33, 0x83, 0x13, 0x97, 0xd0, 0x1a, 0xe4,
+	0x19, 0xf1, 0x2c, 0x8f, 0xd5, 0xb2, 0x0d, 0xad, 0x59, 0xc2, 0x4a, 0x42, 0x35, 0x28, 0x84, 0x24,
+	0x98, 0x38, 0x43, 0xab, 0xa6, 0x37, 0xb4, 0xa6, 0x8e, 0x63, 0xd1, 0xfc, 0x51, 0x03, 0x24, 0x58,
+	0xf4, 0x58, 0x48, 0x2c, 0x37, 0xe6, 0xb2, 0x02, 0x59, 0x67, 0x54, 0xd3, 0x1a, 0x5a, 0x33, 0x87,
+	0xb3, 0xce, 0x08, 0xdd, 0xe4, 0x0e, 0x04, 0x24, 0x3c, 0x97, 0x3b, 0xd5, 0x96, 0x4c, 0x70, 0x2b,
+	0x19, 0x82, 0x62, 0x14, 0x9b, 0xa2, 0xeb, 0x50, 0xe5, 0xe4, 0xfc, 0x88, 0xf5, 0x5d, 0x67, 0x32,
+	0x71, 0x28, 0x19, 0xfa, 0xde, 0x88, 0x2a, 0x0e, 0xab, 0x0a, 0xdb, 0x4f, 0x40, 0x66, 0x0f, 0x56,
+	0x53, 0x74, 0x64, 0xb2, 0xde, 0xe0, 0x83, 0x20, 0x37, 0xf4, 0x47, 0x44, 0x90, 0x31, 0xb0, 0x38,
+	0xf3, 0x20, 0x5d, 0x42, 0xa9, 0x65, 0x13, 0xf1, 0x80, 0x12, 0x8e, 0x45, 0x73, 0x19, 0xca, 0x7b,
+	0xde, 0x89, 0xaf, 0x58, 0x9a, 0xbf, 0x64, 0x61, 0x49, 0xca, 0xca, 0xfb, 0x10, 0xf2, 0xa2, 0x9a,
+	0x71, 0xd6, 0x97, 0xe3, 0xe0, 0xee, 0x73, 0xed, 0xf6, 0x6d, 0x1e, 0xd5, 0x1f, 0x2f, 0x37, 0x6f,
+	0xda, 0x0e, 0x1b, 0x47, 0x83, 0xd6, 0xd0, 0x77, 0xdb, 0xd2, 0xe0, 0x7f, 0x8e, 0xaf, 0x4e, 0xed,
+	0xe0, 0x89, 0xdd, 0x4e, 0x35, 0x46, 0xeb, 0xb1, 0xb8, 0x8d, 0x95, 0x6b, 0xb4, 0x0e, 0x45, 0xd7,
+	0xf1, 0xfa, 0x3c, 0x68, 0x41, 0x5b, 0xc7, 0x05, 0xd7, 0xf1, 0x78, 0x39, 0x05, 0x64, 0x9d, 0x49,
+	0x48, 0xd5, 0xc7, 0xb5, 0xce, 0x04, 0xd4, 0x86, 0x92, 0xf0, 0x7a, 0x38, 0x0d, 0x48, 0x2d, 0xd7,
+	0xd0, 0x9a, 0x2b, 0x9d, 0x0b, 0x31, 0xbb, 0x5e, 0x0c, 0xe0, 0xb9, 0x0d, 0xba, 0x05, 0x20, 0x1e,
+	0xd8, 0xa7, 0x84, 0xd1, 0x9a, 0x21, 0xe2, 0x99, 0xdd, 0x90, 0x94, 0x7a, 0x24, 0xae, 0x54, 0x69,
+	0xa2, 0x64, 0x6a, 0xfe, 0x60, 0xc0, 0xb2, 0xec, 0xab, 0xb8, 0x07, 0x92, 0x84, 0xb5, 0xb7, 0x13,
+	0xce, 0xa6, 0x09, 0xdf, 0xe2, 0x10, 0x1b, 0x8e, 0x49, 0xc8, 0xeb, 0xac, 0x27, 0x5b, 0x45, 0x3c,
+	0x7c, 0x5f, 0x82, 0x8a, 0xc0, 0xcc, 0x16, 0x75, 0xe0, 0x22, 0x77, 0x19, 0x12, 0xea, 0x4f, 0x22,
+	0xe6, 0xf8, 0x5e, 0xff, 0xd4, 0xf1, 0x46, 0xfe, 0xa9, 0x08, 0x5a, 0xc7, 0xab, 0xae, 0x75, 0x86,
+	0x67, 0xd8, 0xb1, 0x80, 0xd0, 0x55, 0x00, 0xcb, 0xb6, 0x43, 0x62, 0x5b, 0x8c, 0xc8, 0x58, 0x57,
+	0x3a, 0x4b, 0xf1, 0xd3, 0xb6, 0x6c, 0x3b, 0xc4, 0x09, 0x1c, 0x7d, 0x06, 0xeb, 0x81, 0x15, 0x32,
+	0xc7, 0x9a, 0xf4, 0x43, 0x55, 0xf9, 0xfe, 0xc8, 0xa1, 0xd6, 0x60, 0x42, 0x46, 0xb5, 0x7c, 0x43,
+	0x6b, 0x16, 0xf1, 0x25, 0x65, 0x10, 0x77, 0xc6, 0x1d, 0x05, 0xa3, 0xaf, 0x17, 0xdc, 0xa5, 0x2c,
+	0xb4, 0x18, 0xb1, 0xa7, 0xb5, 0x82, 0x28, 0xcb, 0x66, 0xfc, 0xe0, 0x2f, 0xd2, 0x3e, 0x7a, 0xca,
+	0xec, 0x0d, 0xe7, 0x31, 0x80, 0x36, 0xa1, 0x4c, 0x9f, 0x38, 0x41, 0x7f, 0x38, 0x8e, 0xbc, 0x27,
+	0xb4, 0x56, 0x14, 0x54, 0x80, 0xab, 0x76, 0x84, 0x06, 0x5d, 0x01, 0x63, 0xec, 0x78, 0x8c, 0xd6,
+	0x4a, 0x6a, 0xf6, 0xe4, 0x9a, 0x69, 0xc5, 0x6b, 0xa6, 0xb5, 0xe5, 0x4d, 0xb1, 0x34, 0xe1, 0x93,
+	0x41, 0x19, 0x09, 0x6a, 0x20, 0xd2, 0x26, 0xce, 0xa8, 0x0a, 0x46, 0x68, 0x79, 0x36, 0xa9, 0x95,
+	0x85, 0x52, 0x0a, 0xe8, 0x06, 0x94, 0x9f, 0x46, 0x24, 0x9c, 0xf6, 0xa5, 0xef, 0x25, 0xe1, 0x1b,
+	0xc5, 0x51, 0x3c, 0xe4, 0xd0, 0x2e, 0x47, 0x30, 0x3c, 0x9d, 0x9d, 0xd1, 0x35, 0x00, 0x3a, 0xb6,
+	0xc2, 0x51, 0xdf, 0xf1, 0x4e, 0xfc, 0xda, 0x72, 0x43, 0x4b, 0xb6, 0x57, 0x8f, 0x23, 0x62, 0xb2,
+	0x4a, 0x34, 0x3e, 0xa2, 0x9b, 0xb0, 0x76, 0xea, 0xb0, 0x31, 0x5f, 0x02, 0x6a, 0xe9, 0xf4, 0xd5,
+	0xb0, 0xad, 0x34, 0xf4, 0x66, 0x09, 0x57, 0x15, 0x8a, 0x25, 0x28, 0x9a, 0x84, 0x9a, 0xcf, 0x34,
+	0x80, 0x39, 0x05, 0x91, 0x22, 0x46, 0x02, 0xb5, 0x46, 0x54, 0x3b, 0x02, 0x57, 0xc9, 0xed, 0x81,
+	0x1a, 0x90, 0x3b, 0x89, 0xbc, 0xa1, 0xda, 0x4e, 0xb3, 0x26, 0xb8, 0x1b, 0x79, 0x43, 0x2c, 0x10,
+	0x74, 0x15, 0x8a, 0x76, 0xe8, 0x47, 0x81, 0xe3, 0xd9, 0xa2, 0xa7, 0xca, 0x9d, 0x4a, 0x6c, 0x75,
+	0x4f, 0xe9, 0xf1, 0xcc, 0x02, 0xfd, 0x27, 0x4e, 0x99, 0xd1, 0xd0, 0x92, 0x1b, 0x01, 0x73, 0xa5,
+	0xca, 0xa0, 0x79, 0x0a, 0xa5, 0x59, 0xc8, 0x82, 0xa2, 0xca, 0xcc, 0x88, 0x9c, 0xcd, 0x28, 0x4a,
+	0x7c, 0x44, 0xce, 0xd0, 0xbf, 0x61, 0x89, 0xf9, 0xcc, 0x9a, 0xf4, 0x85, 0x8e, 0xaa, 0xc1, 0x29,
+	0x0b, 0x9d, 0x70, 0x43, 0xf9, 0x9a, 0x1b, 0x4c, 0xc5, 0x0a, 0x28, 0xe2, 0xec, 0x60, 0xca, 0xf7,
+	0xb9, 0xca, 0x55, 0x4e, 0xe4, 0x4a, 0x49, 0x66, 0x1d, 0x72, 0x3c, 0x32, 0x5e, 0x6c, 0xcf, 0x52,
+	0xe3, 0x59, 0xc2, 0xe2, 0x6c, 0x76, 0xa0, 0x18, 0xc7, 0xa3, 0xfc, 0x69, 0x0b, 0xfc, 0xe9, 0x29,
+	0x7f, 0x9b, 0x60, 0x88, 0xc0, 0xb8, 0x41, 0x2a, 0xc5, 0x4a, 0x32, 0x7f, 0xd2, 0x60, 0x25, 0xde,
+	0x0e, 0x6a, 0x69, 0x36, 0x21, 0x3f, 0x7b, 0x55, 0xf1, 0x14, 0xad, 0xcc, 0xba, 0x40, 0x68, 0x77,
+	0x33, 0x58, 0xe1, 0xa8, 0x0e, 0x85, 0x53, 0x2b, 0xf4, 0x78, 0xe2, 0xc5, 0x6b, 0x69, 0x37, 0x83,
+	0x63, 0x05, 0xba, 0x1a, 0xb7, 0xb6, 0xfe, 0xf6, 0xd6, 0xde, 0xcd, 0xa8, 0xe6, 0xde, 0x2e, 0x42,
+	0x3e, 0x24, 0x34, 0x9a, 0x30, 0xf3, 0xd7, 0x2c, 0x5c, 0x10, 0xad, 0x72, 0x60, 0xb9, 0xf3, 0x95,
+	0xf5, 0xce, 0x11, 0xd7, 0x3e, 0x62, 0xc4, 0xb3, 0x1f, 0x39, 0xe2, 0x55, 0x30, 0x28, 0xb3, 0x42,
+	0xa6, 0xd6, 0xbb, 0x14, 0x50, 0x05, 0x74, 0xe2, 0x8d, 0xd4, 0x86, 0xe3, 0xc7, 0xf9, 0xa4, 0x1b,
+	0xef, 0x9f, 0xf4, 0xe4, 0xa6, 0xcd, 0xff, 0xf3, 0x4d, 0x6b, 0x86, 0x80, 0x92, 0x99, 0x53, 0xe5,
+	0xac, 0x82, 0xc1, 0xdb, 0x47, 0xbe, 0x02, 0x4b, 0x58, 0x0a, 0xa8, 0x0e, 0x45, 0x55, 0x29, 0xde,
+	0xaf, 0x1c, 0x98, 0xc9, 0x73, 0xae, 0xfa, 0x7b, 0xb9, 0x9a, 0xbf, 0x65, 0xd5, 0x43, 0x1f, 0x59,
+	0x93, 0x68, 0x5e, 0xaf, 0x2a, 0x18, 0xa2, 0x03, 0x55, 0x03, 0x4b, 0xe1, 0xdd, 0x55, 0xcc, 0x7e,
+	0x44, 0x15, 0xf5, 0x4f, 0x55, 0xc5, 0xdc, 0x82, 0x2a, 0x1a, 0x0b, 0xaa, 0x98, 0xff, 0xb0, 0x2a,
+	0x16, 0x3e, 0xa0, 0x8a, 0x11, 0xac, 0xa6, 0x12, 0xaa, 0xca, 0xb8, 0x06, 0xf9, 0x6f, 0x85, 0x46,
+	0xd5, 0x51, 0x49, 0x9f, 0xaa, 0x90, 0x57, 0xbe, 0x81, 0xd2, 0xec, 0xb3, 0x03, 0x95, 0xa1, 0x70,
+	0x74, 0xf0, 0xf9, 0xc1, 0x83, 0xe3, 0x83, 0x4a, 0x06, 0x95, 0xc0, 0x78, 0x78, 0xd4, 0xc5, 0x5f,
+	0x55, 0x34, 0x54, 0x84, 0x1c, 0x3e, 0xba, 0xdf, 0xad, 0x64, 0xb9, 0x45, 0x6f, 0xef, 0x4e, 0x77,
+	0x67, 0x0b, 0x57, 0x74, 0x6e, 0xd1, 0x3b, 0x7c, 0x80, 0xbb, 0x95, 0x1c, 0xd7, 0xe3, 0xee, 0x4e,
+	0x77, 0xef, 0x51, 0xb7, 0x62, 0x70, 0xfd, 0x9d, 0xee, 0xf6, 0xd1, 0xbd, 0x4a, 0xfe, 0xca, 0x36,
+	0xe4, 0xf8, 0x7b, 0x1b, 0x15, 0x40, 0xc7, 0x5b, 0xc7, 0xd2, 0xeb, 0xce, 0x83, 0xa3, 0x83, 0xc3,
+	0x8a, 0xc6, 0x75, 0xbd, 0xa3, 0xfd, 0x4a, 0x96, 0x1f, 0xf6, 0xf7, 0x0e, 0x2a, 0xba, 0x38, 0x6c,
+	0x7d, 0x29, 0xdd, 0x09, 0xab, 0x2e, 0xae, 0x18, 0x9d, 0xef, 0xb2, 0x60, 0x08, 0x8e, 0xe8, 0x3a,
+	0xe4, 0xc4, 0x6a, 0x5e, 0x8d, 0x33, 0x9a, 0xf8, 0x0a, 0xac, 0x57, 0xd3, 0x4a, 0x95, 0xbf, 0xff,
+	0x43, 0x5e, 0xee, 0x2f, 0x74, 0x31, 0xbd, 0xcf, 0xe2, 0x6b, 0x6b, 0xe7, 0xd5, 0xf2, 0xe2, 0x35,
+	0x0d, 0xed, 0x00, 0xcc, 0xe7, 0x0a, 0xad, 0xa7, 0xaa, 0x98, 0xdc, 0x52, 0xf5, 0xfa, 0x22, 0x48,
+	0x3d, 0xff, 0x2e, 0x94, 0x13, 0x65, 0x45, 0x69, 0xd3, 0xd4, 0xf0, 0xd4, 0x2f, 0x2f, 0xc4, 0xa4,
+	0x9f, 0xce, 0x33, 0x0d, 0x56, 0xc4, 0x87, 0x34, 0x1f, 0x0b, 0x99, 0x8d, 0xdb, 0x50, 0xc6, 0xc4,
+	0xf5, 0x19, 0x11, 0x7a, 0xb4, 0xf0, 0x0b, 0xbe, 0x7e, 0xf1, 0x9c, 0x56, 0xfd, 0x59, 0xc9, 0x20,
+	0x0c, 0x17, 0x12, 0xb7, 0xe5, 0xe7, 0xf9, 0x9c, 0xde, 0x9b, 0x7f, 0x21, 0xea, 0x97, 0x17, 0x62,
+	0xb1, 0xbf, 0xa6, 0x76, 0x4d, 0xdb, 0xfe, 0xef, 0xf3, 0xbf, 0x36, 0x32, 0xcf, 0x5f, 0x6d, 0x68,
+	0x2f, 0x5e, 0x6d, 0x68, 0x7f, 0xbe, 0xda, 0xd0, 0x7e, 0x7e, 0xbd, 0x91, 0x79, 0xf1, 0x7a, 0x23,
+	0xf3, 0xfb, 0xeb, 0x8d, 0xcc, 0xe3, 0x82, 0xfa, 0x0f, 0x36, 0xc8, 0x8b, 0x46, 0xbc, 0xf1, 0xf7,
+	0x00, 0x75, 0x10, 0x23, 0x8a, 0xed, 0x0d, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -1182,6 +1273,9 @@ var _Store_serviceDesc = grpc.ServiceDesc{
 type WriteableStoreClient interface {
 	// WriteRequest allows you to write metrics to this store via remote write
 	RemoteWrite(ctx context.Context, in *WriteRequest, opts ...grpc.CallOption) (*WriteResponse, error)
+	// RemoteWriteStream allows you to write metrics to this store over a long-lived stream. Every WriteStreamRequest
+	// is acknowledged with a WriteStreamResponse carrying the same id, not necessarily in the order of the requests.
+	RemoteWriteStream(ctx context.Context, opts ...grpc.CallOption) (WriteableStore_RemoteWriteStreamClient, error)
 }
 
 type writeableStoreClient struct {
@@ -1201,10 +1295,44 @@ func (c *writeableStoreClient) RemoteWrite(ctx context.Context, in *WriteRequest
 	return out, nil
 }
 
+func (c *writeableStoreClient) RemoteWriteStream(ctx context.Context, opts ...grpc.CallOption) (WriteableStore_RemoteWriteStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_WriteableStore_serviceDesc.Streams[0], "/thanos.WriteableStore/RemoteWriteStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &writeableStoreRemoteWriteStreamClient{stream}
+	return x, nil
+}
+
+type WriteableStore_RemoteWriteStreamClient interface {
+	Send(*WriteStreamRequest) error
+	Recv() (*WriteStreamResponse, error)
+	grpc.ClientStream
+}
+
+type writeableStoreRemoteWriteStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *writeableStoreRemoteWriteStreamClient) Send(m *WriteStreamRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *writeableStoreRemoteWriteStreamClient) Recv() (*WriteStreamResponse, error) {
+	m := new(WriteStreamResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // WriteableStoreServer is the server API for WriteableStore service.
 type WriteableStoreServer interface {
 	// WriteRequest allows you to write metrics to this store via remote write
 	RemoteWrite(context.Context, *WriteRequest) (*WriteResponse, error)
+	// RemoteWriteStream allows you to write metrics to this store over a long-lived stream. Every WriteStreamRequest
+	// is acknowledged with a WriteStreamResponse carrying the same id, not necessarily in the order of the requests.
+	RemoteWriteStream(WriteableStore_RemoteWriteStreamServer) error
 }
 
 // UnimplementedWriteableStoreServer can be embedded to have forward compatible implementations.
@@ -1214,6 +1342,9 @@ type UnimplementedWriteableStoreServer struct {
 func (*UnimplementedWriteableStoreServer) RemoteWrite(ctx context.Context, req *WriteRequest) (*WriteResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method RemoteWrite not implemented")
 }
+func (*UnimplementedWriteableStoreServer) RemoteWriteStream(srv WriteableStore_RemoteWriteStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method RemoteWriteStream not implemented")
+}
 
 func RegisterWriteableStoreServer(s *grpc.Server, srv WriteableStoreServer) {
 	s.RegisterService(&_WriteableStore_serviceDesc, srv)
@@ -1237,6 +1368,32 @@ func _WriteableStore_RemoteWrite_Handler(srv interface{}, ctx context.Context, d
 	return interceptor(ctx, in, info, handler)
 }
 
+func _WriteableStore_RemoteWriteStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(WriteableStoreServer).RemoteWriteStream(&writeableStoreRemoteWriteStreamServer{stream})
+}
+
+type WriteableStore_RemoteWriteStreamServer interface {
+	Send(*WriteStreamResponse) error
+	Recv() (*WriteStreamRequest, error)
+	grpc.ServerStream
+}
+
+type writeableStoreRemoteWriteStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *writeableStoreRemoteWriteStreamServer) Send(m *WriteStreamResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *writeableStoreRemoteWriteStreamServer) Recv() (*WriteStreamRequest, error) {
+	m := new(WriteStreamRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 var _WriteableStore_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "thanos.WriteableStore",
 	HandlerType: (*WriteableStoreServer)(nil),
@@ -1246,7 +1403,14 @@ var _WriteableStore_serviceDesc = grpc.ServiceDesc{
 			Handler:    _WriteableStore_RemoteWrite_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "RemoteWriteStream",
+			Handler:       _WriteableStore_RemoteWriteStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
 	Metadata: "store/storepb/rpc.proto",
 }
 
@@ -1322,6 +1486,89 @@ func (m *WriteRequest) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	return len(dAtA) - i, nil
 }
 
+func (m *WriteStreamRequest) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *WriteStreamRequest) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *WriteStreamRequest) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.TimeoutMilliseconds != 0 {
+		i = encodeVarintRpc(dAtA, i, uint64(m.TimeoutMilliseconds))
+		i--
+		dAtA[i] = 0x18
+	}
+	{
+		size, err := m.Request.MarshalToSizedBuffer(dAtA[:i])
+		if err != nil {
+			return 0, err
+		}
+		i -= size
+		i = encodeVarintRpc(dAtA, i, uint64(size))
+	}
+	i--
+	dAtA[i] = 0x12
+	if m.Id != 0 {
+		i = encodeVarintRpc(dAtA, i, uint64(m.Id))
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *WriteStreamResponse) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *WriteStreamResponse) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *WriteStreamResponse) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if len(m.Message) > 0 {
+		i -= len(m.Message)
+		copy(dAtA[i:], m.Message)
+		i = encodeVarintRpc(dAtA, i, uint64(len(m.Message)))
+		i--
+		dAtA[i] = 0x1a
+	}
+	if m.Code != 0 {
+		i = encodeVarintRpc(dAtA, i, uint64(m.Code))
+		i--
+		dAtA[i] = 0x10
+	}
+	if m.Id != 0 {
+		i = encodeVarintRpc(dAtA, i, uint64(m.Id))
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
+}
+
 func (m *InfoRequest) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
@@ -1512,20 +1759,20 @@ func (m *SeriesRequest) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		dAtA[i] = 0x30
 	}
 	if len(m.Aggregates) > 0 {
-		dAtA5 := make([]byte, len(m.Aggregates)*10)
-		var j4 int
+		dAtA6 := make([]byte, len(m.Aggregates)*10)
+		var j5 int
 		for _, num := range m.Aggregates {
 			for num >= 1<<7 {
-				dAtA5[j4] = uint8(uint64(num)&0x7f | 0x80)
+				dAtA6[j5] = uint8(uint64(num)&0x7f | 0x80)
 				num >>= 7
-				j4++
+				j5++
 			}
-			dAtA5[j4] = uint8(num)
-			j4++
+			dAtA6[j5] = uint8(num)
+			j5++
 		}
-		i -= j4
-		copy(dAtA[i:], dAtA5[:j4])
-		i = encodeVarintRpc(dAtA, i, uint64(j4))
+		i -= j5
+		copy(dAtA[i:], dAtA6[:j5])
+		i = encodeVarintRpc(dAtA, i, uint64(j5))
 		i--
 		dAtA[i] = 0x2a
 	}
@@ -2168,6 +2415,42 @@ func (m *WriteRequest) Size() (n int) {
 	return n
 }
 
+func (m *WriteStreamRequest) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Id != 0 {
+		n += 1 + sovRpc(uint64(m.Id))
+	}
+	l = m.Request.Size()
+	n += 1 + l + sovRpc(uint64(l))
+	if m.TimeoutMilliseconds != 0 {
+		n += 1 + sovRpc(uint64(m.TimeoutMilliseconds))
+	}
+	return n
+}
+
+func (m *WriteStreamResponse) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Id != 0 {
+		n += 1 + sovRpc(uint64(m.Id))
+	}
+	if m.Code != 0 {
+		n += 1 + sovRpc(uint64(m.Code))
+	}
+	l = len(m.Message)
+	if l > 0 {
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	return n
+}
+
 func (m *InfoRequest) Size() (n int) {
 	if m == nil {
 		return 0
@@ -2715,6 +2998,247 @@ func (m *WriteRequest) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
+func (m *WriteStreamRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: WriteStreamRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: WriteStreamRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Id", wireType)
+			}
+			m.Id = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Id |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Request", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if err := m.Request.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TimeoutMilliseconds", wireType)
+			}
+			m.TimeoutMilliseconds = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.TimeoutMilliseconds |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *WriteStreamResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: WriteStreamResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: WriteStreamResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Id", wireType)
+			}
+			m.Id = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Id |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Code", wireType)
+			}
+			m.Code = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Code |= int32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Message", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Message = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
 func (m *InfoRequest) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
@@ -51,17 +51,24 @@ func NewServerConfig(logger log.Logger, certPath, keyPath, clientCA string) (*tl
 	tlsCfg.GetCertificate = mngr.getCertificate
 
 	if clientCA != "" {
-		caPEM, err := os.ReadFile(filepath.Clean(clientCA))
-		if err != nil {
-			return nil, errors.Wrap(err, "reading client CA")
-		}
-
-		certPool := x509.NewCertPool()
-		if !certPool.AppendCertsFromPEM(caPEM) {
-			return nil, errors.Wrap(err, "building client CA")
+		caMngr := &caManager{logger: logger, path: clientCA}
+		// Client CA is loaded during server startup to check for any errors.
+		if _, err := caMngr.getCertPool(); err != nil {
+			return nil, errors.Wrap(err, "client CA")
 		}
-		tlsCfg.ClientCAs = certPool
+		// Each handshake uses the configuration with the current client CA, so that the client certificates are
+		// verified against the reloaded CA certificates, which are also sent to clients as acceptable CAs.
 		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+		tlsCfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			certPool, err := caMngr.getCertPool()
+			if err != nil {
+				return nil, errors.Wrap(err, "client CA")
+			}
+			cfg := tlsCfg.Clone()
+			cfg.GetConfigForClient = nil
+			cfg.ClientCAs = certPool
+			return cfg, nil
+		}
 
 		level.Info(logger).Log("msg", "server TLS client verification enabled")
 	}
@@ -106,37 +113,44 @@ func (m *serverTLSManager) getCertificate(clientHello *tls.ClientHelloInfo) (*tl
 
 // NewClientConfig provides new client TLS configuration.
 func NewClientConfig(logger log.Logger, cert, key, caCert, serverName string, skipVerify bool) (*tls.Config, error) {
-	var certPool *x509.CertPool
-	if caCert != "" {
-		caPEM, err := os.ReadFile(filepath.Clean(caCert))
-		if err != nil {
-			return nil, errors.Wrap(err, "reading client CA")
-		}
+	r, err := NewClientConfigReloader(logger, cert, key, caCert, serverName, skipVerify)
+	if err != nil {
+		return nil, err
+	}
+	return r.Config()
+}
 
-		certPool = x509.NewCertPool()
-		if !certPool.AppendCertsFromPEM(caPEM) {
-			return nil, errors.Wrap(err, "building client CA")
+// ClientConfigReloader provides client TLS configurations with the CA certificates reloaded when their file changes.
+// The client certificate and key of the configurations are reloaded on each handshake.
+type ClientConfigReloader struct {
+	cfg    *tls.Config
+	caMngr *caManager
+}
+
+// NewClientConfigReloader provides new client TLS configuration reloader.
+func NewClientConfigReloader(logger log.Logger, cert, key, caCert, serverName string, skipVerify bool) (*ClientConfigReloader, error) {
+	r := &ClientConfigReloader{cfg: &tls.Config{}}
+	if caCert != "" {
+		r.caMngr = &caManager{logger: logger, path: caCert}
+		if _, err := r.caMngr.getCertPool(); err != nil {
+			return nil, errors.Wrap(err, "client CA")
 		}
 		level.Info(logger).Log("msg", "TLS client using provided certificate pool")
 	} else {
-		var err error
-		certPool, err = x509.SystemCertPool()
+		certPool, err := x509.SystemCertPool()
 		if err != nil {
 			return nil, errors.Wrap(err, "reading system certificate pool")
 		}
+		r.cfg.RootCAs = certPool
 		level.Info(logger).Log("msg", "TLS client using system certificate pool")
 	}
 
-	tlsCfg := &tls.Config{
-		RootCAs: certPool,
-	}
-
 	if serverName != "" {
-		tlsCfg.ServerName = serverName
+		r.cfg.ServerName = serverName
 	}
 
 	if skipVerify {
-		tlsCfg.InsecureSkipVerify = true
+		r.cfg.InsecureSkipVerify = true
 	}
 
 	if (key != "") != (cert != "") {
@@ -148,11 +162,24 @@ func NewClientConfig(logger log.Logger, cert, key, caCert, serverName string, sk
 			certPath: cert,
 			keyPath:  key,
 		}
-		tlsCfg.GetClientCertificate = mngr.getClientCertificate
+		r.cfg.GetClientCertificate = mngr.getClientCertificate
 
 		level.Info(logger).Log("msg", "TLS client authentication enabled")
 	}
-	return tlsCfg, nil
+	return r, nil
+}
+
+// Config returns the client TLS configuration with the current CA certificates.
+func (r *ClientConfigReloader) Config() (*tls.Config, error) {
+	cfg := r.cfg.Clone()
+	if r.caMngr != nil {
+		certPool, err := r.caMngr.getCertPool()
+		if err != nil {
+			return nil, errors.Wrap(err, "client CA")
+		}
+		cfg.RootCAs = certPool
+	}
+	return cfg, nil
 }
 
 type clientTLSManager struct {
@@ -190,3 +217,41 @@ func (m *clientTLSManager) getClientCertificate(*tls.CertificateRequestInfo) (*t
 
 	return m.cert, nil
 }
+
+// caManager reloads the CA certificates when their file changes.
+type caManager struct {
+	logger log.Logger
+	path   string
+
+	mtx     sync.Mutex
+	pool    *x509.CertPool
+	modTime time.Time
+}
+
+func (m *caManager) getCertPool() (*x509.CertPool, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	stat, err := os.Stat(m.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.pool == nil || !stat.ModTime().Equal(m.modTime) {
+		caPEM, err := os.ReadFile(filepath.Clean(m.path))
+		if err != nil {
+			return nil, errors.Wrap(err, "reading CA")
+		}
+
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.Errorf("building CA from %s: no certificates found", m.path)
+		}
+		if m.pool != nil {
+			level.Info(m.logger).Log("msg", "reloaded TLS CA certificates", "file", m.path)
+		}
+		m.modTime = stat.ModTime()
+		m.pool = certPool
+	}
+	return m.pool, nil
+}
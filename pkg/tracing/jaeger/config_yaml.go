@@ -13,6 +13,7 @@ import (
 
 	glog "github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
 	"go.opentelemetry.io/contrib/samplers/jaegerremote"
 	"go.opentelemetry.io/otel/attribute"
 	otel_jaeger "go.opentelemetry.io/otel/exporters/jaeger"
@@ -101,7 +102,7 @@ func getAgentEndpointOptions(config Config) []otel_jaeger.AgentEndpointOption {
 // Ref: https://www.jaegertracing.io/docs/1.35/sampling/#client-sampling-configuration
 func getSamplingFraction(samplerType string, samplingFactor float64) float64 {
 	switch samplerType {
-	case "const":
+	case SamplerTypeConstant:
 		if samplingFactor > 1 {
 			return 1.0
 		} else if samplingFactor < 0 {
@@ -109,48 +110,37 @@ func getSamplingFraction(samplerType string, samplingFactor float64) float64 {
 		}
 		return math.Round(samplingFactor)
 
-	case "probabilistic":
+	case SamplerTypeProbabilistic:
 		return samplingFactor
-
-	case "ratelimiting":
-		return math.Round(samplingFactor) // Needs to be an integer
 	}
 
 	return samplingFactor
 }
 
-func getSampler(config Config) tracesdk.Sampler {
-	samplerType := config.SamplerType
-	if samplerType == "" {
-		samplerType = SamplerTypeRateLimiting
-	}
-	samplingFraction := getSamplingFraction(samplerType, config.SamplerParam)
-
-	var sampler tracesdk.Sampler
-	switch samplerType {
+// validateSamplerConfig validates the sampler options of the config.
+func validateSamplerConfig(config Config) error {
+	switch config.SamplerType {
+	case "", SamplerTypeRemote, SamplerTypeConstant:
 	case SamplerTypeProbabilistic:
-		sampler = tracesdk.TraceIDRatioBased(samplingFraction)
-	case SamplerTypeConstant:
-		if samplingFraction == 1.0 {
-			sampler = tracesdk.AlwaysSample()
-		} else {
-			sampler = tracesdk.NeverSample()
+		if config.SamplerParam < 0 || config.SamplerParam > 1 {
+			return errors.Errorf("sampler_param of the %s sampler has to be in [0, 1], got %v", SamplerTypeProbabilistic, config.SamplerParam)
 		}
-	case SamplerTypeRemote:
-		remoteOptions := getRemoteOptions(config)
-		sampler = jaegerremote.New(config.ServiceName, remoteOptions...)
-	// Fallback always to default (rate limiting).
 	case SamplerTypeRateLimiting:
-		fallthrough
-	default:
-		// The same config options are applicable to both remote and rate-limiting samplers.
-		remoteOptions := getRemoteOptions(config)
-		sampler = jaegerremote.New(config.ServiceName, remoteOptions...)
-		sampler, ok := sampler.(*rateLimitingSampler)
-		if ok {
-			sampler.Update(config.SamplerParam)
+		if config.SamplerParam < 0 {
+			return errors.Errorf("sampler_param of the %s sampler has to be a non-negative number of traces per second, got %v", SamplerTypeRateLimiting, config.SamplerParam)
 		}
+	default:
+		return errors.Errorf("unknown sampler_type %q, expected one of %s, %s, %s or %s", config.SamplerType,
+			SamplerTypeRemote, SamplerTypeProbabilistic, SamplerTypeConstant, SamplerTypeRateLimiting)
+	}
+	if config.InitialSamplingRate < 0 || config.InitialSamplingRate > 1 {
+		return errors.Errorf("initial_sampler_rate has to be in [0, 1], got %v", config.InitialSamplingRate)
 	}
+	return nil
+}
+
+func getSampler(config Config) tracesdk.Sampler {
+	sampler := getRootSampler(config)
 
 	// Use parent-based to make sure we respect the span parent, if
 	// it is sampled. Optionally, allow user to specify the
@@ -167,13 +157,38 @@ func getSampler(config Config) tracesdk.Sampler {
 	return sampler
 }
 
+// getRootSampler returns the sampler of root spans.
+func getRootSampler(config Config) tracesdk.Sampler {
+	samplingFraction := getSamplingFraction(config.SamplerType, config.SamplerParam)
+
+	switch config.SamplerType {
+	case SamplerTypeProbabilistic:
+		return tracesdk.TraceIDRatioBased(samplingFraction)
+	case SamplerTypeConstant:
+		if samplingFraction == 1.0 {
+			return tracesdk.AlwaysSample()
+		}
+		return tracesdk.NeverSample()
+	case SamplerTypeRateLimiting:
+		return newRateLimitingSampler(config.SamplerParam)
+	// Fallback always to default (remote), as the Jaeger client did.
+	case SamplerTypeRemote:
+		fallthrough
+	default:
+		// The sampling strategies, per operation or not, are fetched from the sampling server.
+		return jaegerremote.New(config.ServiceName, getRemoteOptions(config)...)
+	}
+}
+
 func getRemoteOptions(config Config) []jaegerremote.Option {
 	var remoteOptions []jaegerremote.Option
+	// SamplerRefreshInterval is the interval for polling the backend for sampling strategies.
+	// Ref: https://github.com/open-telemetry/opentelemetry-specification/blob/main/specification/sdk-environment-variables.md#general-sdk-configuration.
 	if config.SamplerRefreshInterval != 0 {
 		remoteOptions = append(remoteOptions, jaegerremote.WithSamplingRefreshInterval(config.SamplerRefreshInterval))
 	}
-	if config.SamplingServerURL != "" {
-		remoteOptions = append(remoteOptions, jaegerremote.WithSamplingServerURL(config.SamplingServerURL))
+	if url := getSamplingServerURL(config); url != "" {
+		remoteOptions = append(remoteOptions, jaegerremote.WithSamplingServerURL(url))
 	}
 	if config.SamplerMaxOperations != 0 {
 		remoteOptions = append(remoteOptions, jaegerremote.WithMaxOperations(config.SamplerMaxOperations))
@@ -181,11 +196,6 @@ func getRemoteOptions(config Config) []jaegerremote.Option {
 	if config.OperationNameLateBinding {
 		remoteOptions = append(remoteOptions, jaegerremote.WithOperationNameLateBinding(true))
 	}
-	// SamplerRefreshInterval is the interval for polling the backend for sampling strategies.
-	// Ref: https://github.com/open-telemetry/opentelemetry-specification/blob/main/specification/sdk-environment-variables.md#general-sdk-configuration.
-	if config.SamplerRefreshInterval != 0 {
-		remoteOptions = append(remoteOptions, jaegerremote.WithSamplingRefreshInterval(config.SamplerRefreshInterval))
-	}
 	// InitialSamplingRate is the sampling probability when the backend is unreachable.
 	if config.InitialSamplingRate != 0.0 {
 		remoteOptions = append(remoteOptions, jaegerremote.WithInitialSampler(tracesdk.TraceIDRatioBased(config.InitialSamplingRate)))
@@ -194,6 +204,18 @@ func getRemoteOptions(config Config) []jaegerremote.Option {
 	return remoteOptions
 }
 
+// getSamplingServerURL returns the URL of the sampling server, given either directly or, as in the Jaeger client, by
+// the host and port of the sampling manager.
+func getSamplingServerURL(config Config) string {
+	if config.SamplingServerURL != "" {
+		return config.SamplingServerURL
+	}
+	if config.SamplerManagerHostPort != "" {
+		return "http://" + config.SamplerManagerHostPort + "/sampling"
+	}
+	return ""
+}
+
 // parseTags parses the given string into a collection of attributes.
 // Spec for this value:
 // - comma separated list of key=value
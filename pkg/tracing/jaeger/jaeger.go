@@ -25,6 +25,10 @@ func NewTracerProvider(ctx context.Context, logger log.Logger, conf []byte) (*tr
 		return nil, err
 	}
 
+	if err := validateSamplerConfig(config); err != nil {
+		return nil, err
+	}
+
 	printDeprecationWarnings(config, logger)
 
 	var exporter *otel_jaeger.Exporter
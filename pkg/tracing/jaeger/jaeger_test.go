@@ -5,19 +5,27 @@ package jaeger
 
 import (
 	"context"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/efficientgo/core/testutil"
 	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/thanos/pkg/runutil"
 	"github.com/thanos-io/thanos/pkg/tracing"
 	"github.com/thanos-io/thanos/pkg/tracing/migration"
 
 	"github.com/go-kit/log"
+	"go.opentelemetry.io/contrib/samplers/jaegerremote"
 	"go.opentelemetry.io/otel/attribute"
 	tracesdk "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
 var parentConfig = ParentBasedSamplerConfig{LocalParentSampled: true}
@@ -188,6 +196,88 @@ func TestContextTracing_ForceTracing(t *testing.T) {
 	tracing.ContextTracing_ForceTracing(t, exp, clientRoot, srvRoot, srvChild)
 }
 
+func TestRateLimitingSampler(t *testing.T) {
+	sampler := getRootSampler(Config{SamplerType: SamplerTypeRateLimiting, SamplerParam: 2})
+	rateLimiter := sampler.(*rateLimitingSampler).rateLimiter
+	now := time.Now()
+	rateLimiter.timeNow = func() time.Time { return now }
+	rateLimiter.lastTick = now
+
+	sampled := func() int {
+		n := 0
+		for i := 0; i < 10; i++ {
+			if sampler.ShouldSample(tracesdk.SamplingParameters{ParentContext: context.Background(), Name: "a"}).Decision == tracesdk.RecordAndSample {
+				n++
+			}
+		}
+		return n
+	}
+	testutil.Equals(t, 2, sampled())
+	testutil.Equals(t, 0, sampled())
+
+	now = now.Add(time.Second)
+	testutil.Equals(t, 2, sampled())
+}
+
+func TestRemoteSampler_PerOperationStrategies(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testutil.Equals(t, "thanos", r.URL.Query().Get("service"))
+		_, _ = w.Write([]byte(`{
+			"operationSampling": {
+				"defaultSamplingProbability": 0,
+				"defaultLowerBoundTracesPerSecond": 0,
+				"perOperationStrategies": [{"operation": "sampled", "probabilisticSampling": {"samplingRate": 1}}]
+			}
+		}`))
+	}))
+	defer srv.Close()
+
+	sampler := getRootSampler(Config{
+		ServiceName:            "thanos",
+		SamplerType:            SamplerTypeRemote,
+		SamplerManagerHostPort: strings.TrimPrefix(srv.URL, "http://"),
+		SamplerRefreshInterval: 10 * time.Millisecond,
+	})
+	defer sampler.(*jaegerremote.Sampler).Close()
+
+	decision := func(name string) tracesdk.SamplingDecision {
+		// Probabilistic samplers sample by trace ID, and always sample the zero trace ID.
+		traceID := oteltrace.TraceID{}
+		_, _ = rand.Read(traceID[:])
+		return sampler.ShouldSample(tracesdk.SamplingParameters{ParentContext: context.Background(), TraceID: traceID, Name: name}).Decision
+	}
+	testutil.Ok(t, runutil.Retry(10*time.Millisecond, make(chan struct{}), func() error {
+		if decision("sampled") != tracesdk.RecordAndSample {
+			return errors.New("operation not sampled yet")
+		}
+		return nil
+	}))
+	testutil.Equals(t, tracesdk.RecordAndSample, decision("sampled"))
+	// The lower bound of operations without strategy starts with a credit of one trace.
+	decision("other")
+	testutil.Equals(t, tracesdk.Drop, decision("other"))
+}
+
+func TestValidateSamplerConfig(t *testing.T) {
+	for _, config := range []Config{
+		{},
+		{SamplerType: SamplerTypeRemote, InitialSamplingRate: 0.1},
+		{SamplerType: SamplerTypeProbabilistic, SamplerParam: 0.5},
+		{SamplerType: SamplerTypeRateLimiting, SamplerParam: 0.5},
+		{SamplerType: SamplerTypeConstant, SamplerParam: 1},
+	} {
+		testutil.Ok(t, validateSamplerConfig(config))
+	}
+	for _, config := range []Config{
+		{SamplerType: "adaptive"},
+		{SamplerType: SamplerTypeRemote, InitialSamplingRate: 2},
+		{SamplerType: SamplerTypeProbabilistic, SamplerParam: 1.5},
+		{SamplerType: SamplerTypeRateLimiting, SamplerParam: -1},
+	} {
+		testutil.NotOk(t, validateSamplerConfig(config))
+	}
+}
+
 func TestParseTags(t *testing.T) {
 	for _, tcase := range []struct {
 		input    string
@@ -9,6 +9,7 @@
 package jaeger
 
 import (
+	"fmt"
 	"math"
 	"sync"
 	"time"
@@ -28,11 +29,19 @@ type RateLimiter struct {
 	timeNow func() time.Time
 }
 
+// rateLimitingSampler samples up to a given number of traces per second.
 type rateLimitingSampler struct {
 	rateLimiter        *RateLimiter
 	maxTracesPerSecond float64
 }
 
+// newRateLimitingSampler returns a sampler sampling up to maxTracesPerSecond traces per second.
+func newRateLimitingSampler(maxTracesPerSecond float64) *rateLimitingSampler {
+	s := &rateLimitingSampler{}
+	s.init(maxTracesPerSecond)
+	return s
+}
+
 // NewRateLimiter creates a new RateLimiter.
 func NewRateLimiter(creditsPerSecond, maxBalance float64) *RateLimiter {
 	return &RateLimiter{
@@ -92,7 +101,7 @@ func (rl *RateLimiter) Update(creditsPerSecond, maxBalance float64) {
 }
 
 func (r *rateLimitingSampler) Description() string {
-	return "rateLimitingSampler{}"
+	return fmt.Sprintf("rateLimitingSampler{maxTracesPerSecond:%v}", r.maxTracesPerSecond)
 }
 
 func (r *rateLimitingSampler) ShouldSample(p tracesdk.SamplingParameters) tracesdk.SamplingResult {
@@ -115,6 +124,7 @@ func (r *rateLimitingSampler) init(rateLimit float64) {
 	} else {
 		r.rateLimiter.Update(rateLimit, math.Max(rateLimit, 1.0))
 	}
+	r.maxTracesPerSecond = rateLimit
 }
 
 func (r *rateLimitingSampler) Update(maxTracesPerSecond float64) {
@@ -8,8 +8,10 @@ import (
 
 	"github.com/thanos-io/thanos/pkg/exthttp"
 
+	"github.com/pkg/errors"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"google.golang.org/grpc/credentials"
 )
 
 type retryConfig struct {
@@ -33,7 +35,7 @@ type Config struct {
 	TLSConfig          exthttp.TLSConfig `yaml:"tls_config"`
 }
 
-func traceGRPCOptions(config Config) []otlptracegrpc.Option {
+func traceGRPCOptions(config Config) ([]otlptracegrpc.Option, error) {
 	var options []otlptracegrpc.Option
 	if config.Endpoint != "" {
 		options = append(options, otlptracegrpc.WithEndpoint(config.Endpoint))
@@ -41,6 +43,12 @@ func traceGRPCOptions(config Config) []otlptracegrpc.Option {
 
 	if config.Insecure {
 		options = append(options, otlptracegrpc.WithInsecure())
+	} else {
+		tlsConfig, err := exthttp.NewTLSConfig(&config.TLSConfig)
+		if err != nil {
+			return nil, errors.Wrap(err, "otlp: tls config")
+		}
+		options = append(options, otlptracegrpc.WithTLSCredentials(credentials.NewTLS(tlsConfig)))
 	}
 
 	if config.ReconnectionPeriod != 0 {
@@ -65,10 +73,10 @@ func traceGRPCOptions(config Config) []otlptracegrpc.Option {
 		options = append(options, otlptracegrpc.WithHeaders(config.Headers))
 	}
 
-	return options
+	return options, nil
 }
 
-func traceHTTPOptions(config Config) []otlptracehttp.Option {
+func traceHTTPOptions(config Config) ([]otlptracehttp.Option, error) {
 	var options []otlptracehttp.Option
 	if config.Endpoint != "" {
 		options = append(options, otlptracehttp.WithEndpoint(config.Endpoint))
@@ -77,7 +85,10 @@ func traceHTTPOptions(config Config) []otlptracehttp.Option {
 	if config.Insecure {
 		options = append(options, otlptracehttp.WithInsecure())
 	} else {
-		tlsConfig, _ := exthttp.NewTLSConfig(&config.TLSConfig)
+		tlsConfig, err := exthttp.NewTLSConfig(&config.TLSConfig)
+		if err != nil {
+			return nil, errors.Wrap(err, "otlp: tls config")
+		}
 		options = append(options, otlptracehttp.WithTLSClientConfig(tlsConfig))
 	}
 
@@ -104,7 +115,7 @@ func traceHTTPOptions(config Config) []otlptracehttp.Option {
 	}
 	// how to specify JSON/binary format here?
 
-	return options
+	return options, nil
 }
 
 func createHTTPRetryConfig(config Config) otlptracehttp.RetryConfig {
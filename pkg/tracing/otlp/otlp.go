@@ -35,11 +35,12 @@ func NewTracerProvider(ctx context.Context, logger log.Logger, conf []byte) (*tr
 	}
 
 	var exporter *otlptrace.Exporter
-	var err error
 	switch strings.ToLower(config.ClientType) {
 	case TracingClientHTTP:
-		options := traceHTTPOptions(config)
-
+		options, err := traceHTTPOptions(config)
+		if err != nil {
+			return nil, err
+		}
 		client := otlptracehttp.NewClient(options...)
 		exporter, err = otlptrace.New(ctx, client)
 		if err != nil {
@@ -47,7 +48,10 @@ func NewTracerProvider(ctx context.Context, logger log.Logger, conf []byte) (*tr
 		}
 
 	case TracingClientGRPC:
-		options := traceGRPCOptions(config)
+		options, err := traceGRPCOptions(config)
+		if err != nil {
+			return nil, err
+		}
 		client := otlptracegrpc.NewClient(options...)
 		exporter, err = otlptrace.New(ctx, client)
 		if err != nil {
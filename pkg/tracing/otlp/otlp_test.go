@@ -5,6 +5,9 @@ package otlp
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/efficientgo/core/testutil"
@@ -14,6 +17,8 @@ import (
 	"github.com/go-kit/log"
 	tracesdk "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 // This test creates an OTLP tracer, starts a span and checks whether it is logged in the exporter.
@@ -34,3 +39,77 @@ func TestContextTracing_ClientEnablesTracing(t *testing.T) {
 	testutil.Equals(t, 1, len(exp.GetSpans()))
 	testutil.Equals(t, 1, tracing.CountSampledSpans(exp.GetSpans()))
 }
+
+// This test checks that the span context is propagated in the W3C traceparent header over both HTTP and gRPC.
+func TestPropagation_W3CTraceContext(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tracerOtel := newTraceProvider(
+		context.Background(),
+		tracesdk.NewSimpleSpanProcessor(exp),
+		log.NewNopLogger(),
+		"thanos")
+	tracer, _ := migration.Bridge(tracerOtel, log.NewNopLogger())
+
+	clientRoot, ctx := tracing.StartSpan(tracing.ContextWithTracer(context.Background(), tracer), "client")
+	traceID, ok := tracing.TraceIDFromContext(ctx)
+	testutil.Assert(t, ok, "expected trace ID")
+
+	t.Run("http", func(t *testing.T) {
+		var header http.Header
+		rt := tracing.HTTPTripperware(log.NewNopLogger(), roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			header = r.Header.Clone()
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+		}))
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/api/v1/query", nil)
+		testutil.Ok(t, err)
+		_, err = rt.RoundTrip(req)
+		testutil.Ok(t, err)
+		testutil.Assert(t, strings.HasPrefix(header.Get("traceparent"), "00-"+traceID+"-"), "unexpected traceparent header %q", header.Get("traceparent"))
+
+		// The server side continues the trace of the traceparent header.
+		var srvTraceID string
+		h := tracing.HTTPMiddleware(tracer, "query", log.NewNopLogger(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			srvTraceID, _ = tracing.TraceIDFromContext(r.Context())
+		}))
+		srvReq := httptest.NewRequest(http.MethodGet, "/api/v1/query", nil)
+		srvReq.Header.Set("traceparent", header.Get("traceparent"))
+		h.ServeHTTP(httptest.NewRecorder(), srvReq)
+		testutil.Equals(t, traceID, srvTraceID)
+	})
+	t.Run("grpc", func(t *testing.T) {
+		var md metadata.MD
+		err := tracing.UnaryClientInterceptor(tracer)(ctx, "/thanos.Store/Info", nil, nil, nil, func(ctx context.Context, _ string, _, _ interface{}, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+			md, _ = metadata.FromOutgoingContext(ctx)
+			return nil
+		})
+		testutil.Ok(t, err)
+		testutil.Equals(t, 1, len(md.Get("traceparent")))
+		testutil.Assert(t, strings.HasPrefix(md.Get("traceparent")[0], "00-"+traceID+"-"), "unexpected traceparent metadata %q", md.Get("traceparent")[0])
+
+		// The server side continues the trace of the traceparent metadata.
+		var srvTraceID string
+		_, err = tracing.UnaryServerInterceptor(tracer)(metadata.NewIncomingContext(context.Background(), md), nil, &grpc.UnaryServerInfo{FullMethod: "/thanos.Store/Info"}, func(ctx context.Context, _ interface{}) (interface{}, error) {
+			srvTraceID, _ = tracing.TraceIDFromContext(ctx)
+			return nil, nil
+		})
+		testutil.Ok(t, err)
+		testutil.Equals(t, traceID, srvTraceID)
+	})
+	clientRoot.Finish()
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestNewTracerProvider_InvalidTLSConfig(t *testing.T) {
+	for _, clientType := range []string{TracingClientGRPC, TracingClientHTTP} {
+		_, err := NewTracerProvider(context.Background(), log.NewNopLogger(), []byte(`
+client_type: `+clientType+`
+endpoint: localhost:4317
+tls_config:
+  ca_file: /non-existent/ca.pem
+`))
+		testutil.NotOk(t, err, clientType)
+	}
+}
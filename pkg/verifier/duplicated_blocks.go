@@ -0,0 +1,236 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package verifier
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// DuplicatedBlocks checks the overlapping blocks of each group for blocks with exactly the same series and chunks,
+// e.g. the same block uploaded twice by HA replicas or by a sidecar which lost its shipper state. Unlike
+// DuplicatedCompactionBlocks, the duplicates can have different sources, so their data is downloaded and compared.
+// If repair is enabled, the block with the lowest ULID of each set of duplicates is kept, and the others are marked
+// for deletion.
+type DuplicatedBlocks struct{}
+
+func (DuplicatedBlocks) IssueID() string { return "duplicated_blocks" }
+
+func (DuplicatedBlocks) VerifyRepair(ctx Context, idMatcher func(ulid.ULID) bool, repair bool) error {
+	if idMatcher != nil {
+		return errors.Errorf("id matching is not supported")
+	}
+
+	level.Info(ctx.Logger).Log("msg", "started verifying issue", "with-repair", repair)
+
+	overlaps, err := fetchOverlaps(ctx, ctx.Fetcher)
+	if err != nil {
+		return errors.Wrap(err, "fetch overlaps")
+	}
+
+	tmpdir, err := os.MkdirTemp("", "duplicated-blocks-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpdir); err != nil {
+			level.Warn(ctx.Logger).Log("msg", "failed to delete dir", "tmpdir", tmpdir, "err", err)
+		}
+	}()
+
+	var (
+		// Blocks can be in multiple overlaps, so their hashes are computed once.
+		hashes = map[ulid.ULID]string{}
+		// Duplicates are marked for deletion once, with the block they are a duplicate of.
+		toMark  = map[ulid.ULID]ulid.ULID{}
+		toMarks []ulid.ULID
+	)
+	for k, o := range overlaps {
+		for r, blocks := range o {
+			for _, candidates := range sameStatsBlocks(blocks) {
+				dups, err := sameContentBlocks(ctx, tmpdir, candidates, hashes)
+				if err != nil {
+					return errors.Wrapf(err, "compare blocks of group %s", k)
+				}
+				for _, d := range dups {
+					level.Warn(ctx.Logger).Log("msg", "found duplicated blocks", "group", k, "range-min", r.Min, "range-max", r.Max, "keep", d[0].ULID, "mark", sprintMetas(d[1:]))
+					for _, m := range d[1:] {
+						if _, ok := toMark[m.ULID]; ok {
+							continue
+						}
+						toMark[m.ULID] = d[0].ULID
+						toMarks = append(toMarks, m.ULID)
+					}
+				}
+			}
+		}
+	}
+
+	if len(toMarks) == 0 {
+		level.Info(ctx.Logger).Log("msg", "no duplicated blocks found")
+		return nil
+	}
+	level.Warn(ctx.Logger).Log("msg", "Found duplicated blocks that are ok to be marked for deletion", "ULIDs", fmt.Sprintf("%v", toMarks), "num", len(toMarks))
+	if !repair {
+		level.Info(ctx.Logger).Log("msg", "dry run; pass --repair to mark the duplicated blocks for deletion")
+		return nil
+	}
+
+	for i, id := range toMarks {
+		details := fmt.Sprintf("duplicate of block %s found by verify-repair", toMark[id])
+		if err := block.MarkForDeletion(ctx, ctx.Logger, ctx.Bkt, id, details, ctx.metrics.blocksMarkedForDeletion); err != nil {
+			return errors.Wrapf(err, "mark block %s for deletion", id)
+		}
+		level.Info(ctx.Logger).Log("msg", "Marked duplicated block for deletion", "id", id, "duplicate-of", toMark[id], "to-be-marked", len(toMarks)-(i+1), "marked", i+1)
+	}
+
+	level.Info(ctx.Logger).Log("msg", "Marked all duplicated blocks for deletion. You might want to rerun this verify to check if there is still any unrelated overlap")
+	return nil
+}
+
+// sameStatsBlocks returns the sets of blocks with the same time range and stats, which can have the same content.
+func sameStatsBlocks(blocks []tsdb.BlockMeta) (res [][]tsdb.BlockMeta) {
+	var sets [][]tsdb.BlockMeta
+	for _, b := range blocks {
+		added := false
+		for i, s := range sets {
+			if s[0].MinTime != b.MinTime || s[0].MaxTime != b.MaxTime || s[0].Stats != b.Stats {
+				continue
+			}
+			sets[i] = append(sets[i], b)
+			added = true
+			break
+		}
+		if !added {
+			sets = append(sets, []tsdb.BlockMeta{b})
+		}
+	}
+
+	for _, s := range sets {
+		if len(s) < 2 {
+			continue
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
+// sameContentBlocks returns the sets of blocks with the same series and chunks, sorted by ULID.
+func sameContentBlocks(ctx Context, dir string, blocks []tsdb.BlockMeta, hashes map[ulid.ULID]string) ([][]tsdb.BlockMeta, error) {
+	byHash := map[string][]tsdb.BlockMeta{}
+	var keys []string
+	for _, b := range blocks {
+		h, ok := hashes[b.ULID]
+		if !ok {
+			var err error
+			if h, err = downloadAndHashBlock(ctx, dir, b.ULID); err != nil {
+				return nil, errors.Wrapf(err, "hash block %s", b.ULID)
+			}
+			hashes[b.ULID] = h
+		}
+		if _, ok := byHash[h]; !ok {
+			keys = append(keys, h)
+		}
+		byHash[h] = append(byHash[h], b)
+	}
+
+	var res [][]tsdb.BlockMeta
+	for _, h := range keys {
+		d := byHash[h]
+		if len(d) < 2 {
+			continue
+		}
+		sort.Slice(d, func(i, j int) bool { return d[i].ULID.Compare(d[j].ULID) < 0 })
+		res = append(res, d)
+	}
+	return res, nil
+}
+
+// downloadAndHashBlock downloads the block and returns the hash of its series and chunks.
+func downloadAndHashBlock(ctx Context, dir string, id ulid.ULID) (string, error) {
+	bdir := filepath.Join(dir, id.String())
+	defer func() {
+		if err := os.RemoveAll(bdir); err != nil {
+			level.Warn(ctx.Logger).Log("msg", "failed to delete dir", "dir", bdir, "err", err)
+		}
+	}()
+
+	level.Info(ctx.Logger).Log("msg", "downloading block to compare its content", "id", id)
+	if err := block.Download(ctx, ctx.Logger, ctx.Bkt, id, bdir); err != nil {
+		return "", errors.Wrap(err, "download block")
+	}
+	return hashBlock(ctx.Logger, bdir)
+}
+
+// hashBlock returns the hash of the labels and chunks of all series of the block in bdir.
+func hashBlock(logger log.Logger, bdir string) (_ string, err error) {
+	b, err := tsdb.OpenBlock(logger, bdir, downsample.NewPool())
+	if err != nil {
+		return "", errors.Wrap(err, "open block")
+	}
+	defer runutil.CloseWithErrCapture(&err, b, "block")
+
+	indexr, err := b.Index()
+	if err != nil {
+		return "", errors.Wrap(err, "open index reader")
+	}
+	defer runutil.CloseWithErrCapture(&err, indexr, "index reader")
+
+	chunkr, err := b.Chunks()
+	if err != nil {
+		return "", errors.Wrap(err, "open chunk reader")
+	}
+	defer runutil.CloseWithErrCapture(&err, chunkr, "chunk reader")
+
+	postings, err := indexr.Postings(index.AllPostingsKey())
+	if err != nil {
+		return "", errors.Wrap(err, "get all postings list")
+	}
+
+	var (
+		h       = sha256.New()
+		builder labels.ScratchBuilder
+		chks    []chunks.Meta
+		buf     [8]byte
+	)
+	for postings.Next() {
+		if err := indexr.Series(postings.At(), &builder, &chks); err != nil {
+			return "", errors.Wrapf(err, "get series %d", postings.At())
+		}
+		_, _ = h.Write([]byte(builder.Labels().String()))
+		for _, c := range chks {
+			chk, err := chunkr.Chunk(c)
+			if err != nil {
+				return "", errors.Wrapf(err, "get chunk %d, series %d", c.Ref, postings.At())
+			}
+			binary.BigEndian.PutUint64(buf[:], uint64(c.MinTime))
+			_, _ = h.Write(buf[:])
+			binary.BigEndian.PutUint64(buf[:], uint64(c.MaxTime))
+			_, _ = h.Write(buf[:])
+			_, _ = h.Write([]byte{byte(chk.Encoding())})
+			_, _ = h.Write(chk.Bytes())
+		}
+	}
+	if postings.Err() != nil {
+		return "", errors.Wrap(postings.Err(), "iterate postings")
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
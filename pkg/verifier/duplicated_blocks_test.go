@@ -0,0 +1,64 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package verifier
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestDuplicatedBlocks_VerifyRepair(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	bkt := objstore.WithNoopInstr(objstore.NewInMemBucket())
+	extLset := labels.FromStrings("cluster", "a")
+
+	// Blocks with the same single series are created with the same samples.
+	var ids []ulid.ULID
+	for _, series := range []labels.Labels{
+		labels.FromStrings("a", "1"),
+		labels.FromStrings("a", "1"),
+		labels.FromStrings("a", "2"),
+	} {
+		id, err := e2eutil.CreateBlock(ctx, dir, []labels.Labels{series}, 100, 0, 1000, extLset, 0, metadata.NoneFunc)
+		testutil.Ok(t, err)
+		testutil.Ok(t, block.Upload(ctx, log.NewNopLogger(), bkt, filepath.Join(dir, id.String()), metadata.NoneFunc))
+		ids = append(ids, id)
+	}
+	keep, dup := ids[0], ids[1]
+	if dup.Compare(keep) < 0 {
+		keep, dup = dup, keep
+	}
+
+	fetcher, err := block.NewMetaFetcher(log.NewNopLogger(), 1, bkt, "", nil, nil)
+	testutil.Ok(t, err)
+	m := NewManager(nil, log.NewNopLogger(), bkt, nil, fetcher, 0, Registry{VerifierRepairers: []VerifierRepairer{DuplicatedBlocks{}}})
+
+	// Verification only reports the duplicates.
+	testutil.Ok(t, m.Verify(ctx, nil))
+	for _, id := range ids {
+		ok, err := bkt.Exists(ctx, path.Join(id.String(), metadata.DeletionMarkFilename))
+		testutil.Ok(t, err)
+		testutil.Assert(t, !ok)
+	}
+
+	testutil.Ok(t, m.VerifyAndRepair(ctx, nil))
+	for _, id := range ids {
+		ok, err := bkt.Exists(ctx, path.Join(id.String(), metadata.DeletionMarkFilename))
+		testutil.Ok(t, err)
+		testutil.Equals(t, id == dup, ok, "block %s", id)
+	}
+}
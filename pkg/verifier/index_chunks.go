@@ -0,0 +1,65 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package verifier
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+
+	"github.com/thanos-io/thanos/pkg/block"
+)
+
+// IndexChunksMismatchIssue cross-checks every chunk reference in the index of the blocks against their chunk
+// segment files, and reports dangling, truncated or corrupted chunks.
+// NOTE: This downloads every verified block completely.
+// No repair is available for this issue.
+type IndexChunksMismatchIssue struct{}
+
+func (IndexChunksMismatchIssue) IssueID() string { return "index_chunks_mismatch" }
+
+func (IndexChunksMismatchIssue) Verify(ctx Context, idMatcher func(ulid.ULID) bool) error {
+	level.Info(ctx.Logger).Log("msg", "started verifying issue")
+
+	metas, _, err := ctx.Fetcher.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	for id := range metas {
+		if idMatcher != nil && !idMatcher(id) {
+			continue
+		}
+		if err := verifyIndexChunks(ctx, id); err != nil {
+			level.Warn(ctx.Logger).Log("msg", "detected issue", "id", id, "err", err)
+			continue
+		}
+		level.Debug(ctx.Logger).Log("msg", "no issue", "id", id)
+	}
+
+	level.Info(ctx.Logger).Log("msg", "verified issue")
+	return nil
+}
+
+func verifyIndexChunks(ctx Context, id ulid.ULID) error {
+	tmpdir, err := os.MkdirTemp("", fmt.Sprintf("index-chunks-block-%s-", id))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpdir); err != nil {
+			level.Warn(ctx.Logger).Log("msg", "failed to delete dir", "tmpdir", tmpdir, "err", err)
+		}
+	}()
+
+	dir := filepath.Join(tmpdir, id.String())
+	if err := block.Download(ctx, ctx.Logger, ctx.Bkt, id, dir); err != nil {
+		return errors.Wrapf(err, "download block %s", id)
+	}
+	return block.VerifyChunks(ctx.Logger, dir)
+}
@@ -31,6 +31,9 @@ import (
 
 	"github.com/thanos-io/thanos/pkg/alert"
 	"github.com/thanos-io/thanos/pkg/cacheutil"
+	"github.com/thanos-io/thanos/pkg/extobjstore"
+	"github.com/thanos-io/thanos/pkg/extobjstore/hdfs"
+	"github.com/thanos-io/thanos/pkg/extobjstore/obs"
 	"github.com/thanos-io/thanos/pkg/logging"
 	"github.com/thanos-io/thanos/pkg/queryfrontend"
 	storecache "github.com/thanos-io/thanos/pkg/store/cache"
@@ -55,6 +58,8 @@ var (
 		client.ALIYUNOSS:  oss.Config{},
 		client.FILESYSTEM: filesystem.Config{},
 		client.BOS:        bos.Config{},
+		extobjstore.OBS:   obs.DefaultConfig,
+		extobjstore.HDFS:  hdfs.DefaultConfig,
 	}
 
 	tracingConfigs = map[trclient.TracingProvider]interface{}{
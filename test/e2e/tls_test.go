@@ -20,11 +20,13 @@ import (
 
 	"github.com/fortytw2/leaktest"
 	"github.com/go-kit/log"
+	"github.com/opentracing/opentracing-go"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
 
 	"github.com/efficientgo/core/testutil"
+	"github.com/thanos-io/thanos/pkg/extgrpc"
 	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
 
 	pb "google.golang.org/grpc/examples/features/proto/echo"
@@ -95,6 +97,70 @@ func TestGRPCServerCertAutoRotate(t *testing.T) {
 	testutil.Equals(t, expMessage, resp.Message)
 }
 
+func TestGRPCServerCACertAutoRotate(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)() // To see whether any goroutines leaked.
+
+	logger := log.NewLogfmtLogger(os.Stderr)
+	expMessage := "hello world"
+
+	tmpDirClt := t.TempDir()
+	caClt := filepath.Join(tmpDirClt, "ca")
+	certClt := filepath.Join(tmpDirClt, "cert")
+	keyClt := filepath.Join(tmpDirClt, "key")
+
+	tmpDirSrv := t.TempDir()
+	caSrv := filepath.Join(tmpDirSrv, "ca")
+	certSrv := filepath.Join(tmpDirSrv, "cert")
+	keySrv := filepath.Join(tmpDirSrv, "key")
+
+	genCerts(t, certSrv, keySrv, caClt)
+	genCerts(t, certClt, keyClt, caSrv)
+
+	configSrv, err := thTLS.NewServerConfig(logger, certSrv, keySrv, caSrv)
+	testutil.Ok(t, err)
+
+	srv := grpc.NewServer(grpc.KeepaliveParams(keepalive.ServerParameters{MaxConnectionAge: 1 * time.Millisecond}), grpc.Creds(credentials.NewTLS(configSrv)))
+
+	pb.RegisterEchoServer(srv, &ecServer{})
+	p, err := e2eutil.FreePort()
+	testutil.Ok(t, err)
+	addr := fmt.Sprint("localhost:", p)
+	lis, err := net.Listen("tcp", addr)
+	testutil.Ok(t, err)
+
+	go func() {
+		testutil.Ok(t, srv.Serve(lis))
+	}()
+	defer func() { srv.Stop() }()
+	time.Sleep(50 * time.Millisecond) // Wait for the server to start.
+
+	// Setup the connection and the client the same way store clients are set up.
+	dialOpts, err := extgrpc.StoreClientGRPCOpts(logger, nil, opentracing.NoopTracer{}, true, false, certClt, keyClt, caClt, serverName)
+	testutil.Ok(t, err)
+	conn, err := grpc.Dial(addr, append(dialOpts, grpc.WithConnectParams(grpc.ConnectParams{MinConnectTimeout: 1 * time.Minute}))...)
+	testutil.Ok(t, err)
+	defer func() {
+		testutil.Ok(t, conn.Close())
+	}()
+	clt := pb.NewEchoClient(conn)
+
+	// Check a good state.
+	resp, err := clt.UnaryEcho(context.Background(), &pb.EchoRequest{Message: expMessage})
+	testutil.Ok(t, err)
+	testutil.Equals(t, expMessage, resp.Message)
+
+	// Rotate the CAs by removing their private keys, so new ones are generated, and check for a good state.
+	testutil.Ok(t, os.Remove(caClt+".priv"))
+	testutil.Ok(t, os.Remove(caSrv+".priv"))
+	time.Sleep(10 * time.Millisecond) // Make sure the modification time of the files changes.
+	genCerts(t, certSrv, keySrv, caClt)
+	genCerts(t, certClt, keyClt, caSrv)
+	time.Sleep(50 * time.Millisecond) // Wait for the server MaxConnectionAge to expire.
+	resp, err = clt.UnaryEcho(context.Background(), &pb.EchoRequest{Message: expMessage})
+	testutil.Ok(t, err)
+	testutil.Equals(t, expMessage, resp.Message)
+}
+
 var caRoot = &x509.Certificate{
 	SerialNumber:          big.NewInt(2019),
 	NotAfter:              time.Now().AddDate(10, 0, 0),